@@ -0,0 +1,48 @@
+#!/bin/bash
+
+#
+# Regenerate the Go protobuf package src/pb from Pulsar function proto files
+# Prerequisite -
+# 1. protoc v3.11.4 or later
+# 2. protoc-gen-go v1.20.1, go get google.golang.org/protobuf/cmd/protoc-gen-go@v1.20.1
+#
+# Usage: ./scripts/gen-proto.sh [pulsar-version]
+# With a Pulsar version such as 2.7.1, the proto files are downloaded from the Pulsar release tag first.
+# Without a version, the local proto files under proto/pulsar/functions are regenerated.
+#
+
+set -e
+
+# absolute directory
+DIR="$( cd "$( dirname "${BASH_SOURCE[0]}" )" >/dev/null 2>&1 && pwd )"
+PROTO_DIR=$DIR/../proto/pulsar/functions
+PB_DIR=$DIR/../src/pb
+PULSAR_VERSION=$1
+
+if [ -n "$PULSAR_VERSION" ]; then
+    for f in Function.proto Request.proto; do
+        echo download $f from Pulsar v$PULSAR_VERSION
+        curl -sSfL -o $PROTO_DIR/$f \
+            https://raw.githubusercontent.com/apache/pulsar/v$PULSAR_VERSION/pulsar-functions/proto/src/main/proto/$f
+        # generate under package pb and disable the original Pulsar Java options
+        sed -i.bak -e 's/^package proto;/package pb;/' -e 's/^option java_/\/\/ option java_/' $PROTO_DIR/$f
+        rm -f $PROTO_DIR/$f.bak
+    done
+fi
+
+# retain the license header of the generated files
+HEADER=$(mktemp)
+head -22 $PB_DIR/Function.pb.go > $HEADER
+
+protoc --proto_path=$PROTO_DIR --go_out=$PB_DIR --go_opt=paths=source_relative $PROTO_DIR/Function.proto $PROTO_DIR/Request.proto
+
+for f in Function.pb.go Request.pb.go; do
+    cat $HEADER $PB_DIR/$f > $PB_DIR/$f.tmp
+    mv $PB_DIR/$f.tmp $PB_DIR/$f
+done
+rm -f $HEADER
+
+# verify the compatibility of function metadata parsing
+cd $DIR/../src
+go build ./pb/...
+go test ./unit-test/ -run 'FunctionMetadata'
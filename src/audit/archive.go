@@ -0,0 +1,338 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package audit
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/datastax/burnell/src/util"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ArchiveObject is an archived object of the audit events of a day
+type ArchiveObject struct {
+	Key        string    `json:"key"`
+	Date       string    `json:"date"`
+	Events     int       `json:"events"`
+	Bytes      int64     `json:"bytes"`
+	ArchivedAt time.Time `json:"archivedAt"`
+}
+
+// ArchiveVerification is the result of verifying an archived object in the bucket
+type ArchiveVerification struct {
+	ArchiveObject
+	Verified bool   `json:"verified"`
+	Error    string `json:"error,omitempty"`
+}
+
+// ArchiveStatus is the archiver state
+type ArchiveStatus struct {
+	URL       string          `json:"url"`
+	Pending   int             `json:"pending"`
+	Dropped   uint64          `json:"dropped"`
+	LastError string          `json:"lastError,omitempty"`
+	LastRun   time.Time       `json:"lastRun,omitempty"`
+	Archived  []ArchiveObject `json:"archived"`
+}
+
+// ObjectStore puts and checks the archived objects
+type ObjectStore interface {
+	PutObject(key, contentType string, data []byte) error
+	HeadObject(key string) (int64, error)
+}
+
+// Archiver batches the audit events and uploads them as gzip compressed NDJSON objects partitioned by the event date,
+// {prefix}audit/year=YYYY/month=MM/day=DD/{time}-{host}-{seq}.ndjson.gz, so a bucket lifecycle rule can expire
+// or transition the objects by the prefix and age. A failed upload keeps the events for the next run.
+type Archiver struct {
+	store      ObjectStore
+	url        string
+	prefix     string
+	host       string
+	batchSize  int
+	maxPending int
+	full       chan struct{}
+
+	lock      sync.Mutex
+	flushLock sync.Mutex
+	pending   []Event
+	archived  []ArchiveObject
+	seq       int
+	dropped   uint64
+	lastError string
+	lastRun   time.Time
+}
+
+var (
+	archiver *Archiver
+
+	// the number of the recent archived objects kept for the status and the verification
+	archiveRecentObjects = util.GetEnvInt("AuditArchiveRecentObjects", 100)
+
+	archiveCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "burnell",
+		Subsystem: "audit_archive",
+		Name:      "events_total",
+		Help:      "The number of audit events archived by the result, archived or dropped.",
+	}, []string{"result"})
+)
+
+func init() {
+	prometheus.MustRegister(archiveCounter)
+}
+
+// NewArchiver creates an archiver, the objects are named under the prefix. A run is triggered once batchSize events
+// are pending, and maxPending is the cap of the events kept over the failed uploads.
+func NewArchiver(store ObjectStore, storeURL, prefix string, batchSize, maxPending int) *Archiver {
+	host, _ := os.Hostname()
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	return &Archiver{
+		store:      store,
+		url:        storeURL,
+		prefix:     prefix,
+		host:       util.AssignString(host, "burnell"),
+		batchSize:  batchSize,
+		maxPending: maxPending,
+		full:       make(chan struct{}, 1),
+		pending:    make([]Event, 0),
+		archived:   make([]ArchiveObject, 0),
+	}
+}
+
+// Add adds an event to be archived, the oldest pending event is dropped over the cap
+func (a *Archiver) Add(e Event) {
+	a.lock.Lock()
+	a.pending = append(a.pending, e)
+	if a.maxPending > 0 && len(a.pending) > a.maxPending {
+		over := len(a.pending) - a.maxPending
+		a.pending = a.pending[over:]
+		a.dropped += uint64(over)
+		archiveCounter.WithLabelValues("dropped").Add(float64(over))
+	}
+	full := len(a.pending) >= a.batchSize
+	a.lock.Unlock()
+	if full {
+		select {
+		case a.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Start runs the archive loop at every interval or once a batch is full
+func (a *Archiver) Start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-a.full:
+			}
+			if _, err := a.Flush(); err != nil {
+				logger.Errorf("audit archive error %v", err)
+			}
+		}
+	}()
+}
+
+// ArchiveKey is the object key of a batch of the events of a day
+func (a *Archiver) ArchiveKey(date time.Time, now time.Time, seq int) string {
+	return fmt.Sprintf("%saudit/year=%04d/month=%02d/day=%02d/%s-%s-%06d.ndjson.gz", a.prefix,
+		date.Year(), date.Month(), date.Day(), now.Format("20060102T150405Z"), a.host, seq)
+}
+
+// Flush uploads the pending events now and returns the objects written
+func (a *Archiver) Flush() ([]ArchiveObject, error) {
+	a.flushLock.Lock()
+	defer a.flushLock.Unlock()
+
+	a.lock.Lock()
+	batch := a.pending
+	a.pending = make([]Event, 0)
+	a.lock.Unlock()
+
+	now := time.Now().UTC()
+	days := map[string][]Event{}
+	for _, e := range batch {
+		date := e.Time.UTC().Format("2006-01-02")
+		days[date] = append(days[date], e)
+	}
+	dates := make([]string, 0, len(days))
+	for d := range days {
+		dates = append(dates, d)
+	}
+	sort.Strings(dates)
+
+	objects := []ArchiveObject{}
+	var failed []Event
+	var lastErr error
+	for _, d := range dates {
+		events := days[d]
+		object, err := a.upload(d, events, now)
+		if err != nil {
+			failed = append(failed, events...)
+			lastErr = err
+			continue
+		}
+		objects = append(objects, object)
+		archiveCounter.WithLabelValues("archived").Add(float64(len(events)))
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.lastRun = now
+	a.lastError = ""
+	if lastErr != nil {
+		a.lastError = lastErr.Error()
+		// the failed events are retried before the events added during the upload
+		a.pending = append(failed, a.pending...)
+	}
+	a.archived = append(a.archived, objects...)
+	if len(a.archived) > archiveRecentObjects {
+		a.archived = a.archived[len(a.archived)-archiveRecentObjects:]
+	}
+	return objects, lastErr
+}
+
+func (a *Archiver) upload(date string, events []Event, now time.Time) (ArchiveObject, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	encoder := json.NewEncoder(zw)
+	for _, e := range events {
+		if err := encoder.Encode(e); err != nil {
+			return ArchiveObject{}, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return ArchiveObject{}, err
+	}
+
+	day, _ := time.Parse("2006-01-02", date)
+	a.lock.Lock()
+	a.seq++
+	key := a.ArchiveKey(day, now, a.seq)
+	a.lock.Unlock()
+	if err := a.store.PutObject(key, "application/gzip", buf.Bytes()); err != nil {
+		return ArchiveObject{}, err
+	}
+	return ArchiveObject{
+		Key:        key,
+		Date:       date,
+		Events:     len(events),
+		Bytes:      int64(buf.Len()),
+		ArchivedAt: now,
+	}, nil
+}
+
+// Verify checks the recent archived objects exist in the bucket with the uploaded size, the newest first up to the limit
+func (a *Archiver) Verify(limit int) []ArchiveVerification {
+	a.lock.Lock()
+	objects := append([]ArchiveObject{}, a.archived...)
+	a.lock.Unlock()
+
+	results := []ArchiveVerification{}
+	for i := len(objects) - 1; i >= 0; i-- {
+		if limit > 0 && len(results) >= limit {
+			break
+		}
+		result := ArchiveVerification{ArchiveObject: objects[i]}
+		size, err := a.store.HeadObject(objects[i].Key)
+		switch {
+		case err != nil:
+			result.Error = err.Error()
+		case size >= 0 && size != objects[i].Bytes:
+			result.Error = fmt.Sprintf("object size %d is not the archived size %d", size, objects[i].Bytes)
+		default:
+			result.Verified = true
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// Status returns the archiver state with the recent archived objects, the newest first
+func (a *Archiver) Status() ArchiveStatus {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	status := ArchiveStatus{
+		URL:       a.url,
+		Pending:   len(a.pending),
+		Dropped:   a.dropped,
+		LastError: a.lastError,
+		LastRun:   a.lastRun,
+		Archived:  make([]ArchiveObject, 0, len(a.archived)),
+	}
+	for i := len(a.archived) - 1; i >= 0; i-- {
+		status.Archived = append(status.Archived, a.archived[i])
+	}
+	return status
+}
+
+// ErrArchiveDisabled is returned when AuditArchiveURL is not configured
+var ErrArchiveDisabled = errors.New("audit archive is not configured")
+
+// SetArchiver replaces the archiver of the audit events, nil disables the archival
+func SetArchiver(a *Archiver) {
+	archiver = a
+}
+
+// ActiveArchiver returns the archiver, or ErrArchiveDisabled
+func ActiveArchiver() (*Archiver, error) {
+	if archiver == nil {
+		return nil, ErrArchiveDisabled
+	}
+	return archiver, nil
+}
+
+// InitArchive starts archiving the audit events if AuditArchiveURL is configured
+func InitArchive() error {
+	config := util.GetConfig()
+	if config.AuditArchiveURL == "" {
+		return nil
+	}
+	store, prefix, err := util.NewObjectStore(config.AuditArchiveURL, config.AuditArchiveEndpoint, config.AuditArchiveRegion)
+	if err != nil {
+		return err
+	}
+	if prefix != "" && prefix[len(prefix)-1] != '/' {
+		prefix += "/"
+	}
+	a := NewArchiver(store, config.AuditArchiveURL, prefix,
+		util.GetEnvInt("AuditArchiveBatchSize", 10000),
+		util.GetEnvInt("AuditArchiveMaxPending", 100000),
+	)
+	a.Start(time.Duration(util.GetEnvInt("AuditArchiveFlushSeconds", 300)) * time.Second)
+	SetArchiver(a)
+	logger.Infof("archive audit events to %s", config.AuditArchiveURL)
+	return nil
+}
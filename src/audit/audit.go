@@ -0,0 +1,98 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package audit
+
+// audit records who did what to which resource
+
+import (
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/datastax/burnell/src/util"
+)
+
+// Event is an audit record
+type Event struct {
+	Time     time.Time `json:"time"`
+	Subject  string    `json:"subject"`
+	Tenant   string    `json:"tenant"`
+	Action   string    `json:"action"`
+	Resource string    `json:"resource"`
+	Detail   string    `json:"detail,omitempty"`
+	Status   int       `json:"status"`
+}
+
+var (
+	// the number of recent events kept in memory
+	recentSize = util.GetEnvInt("AuditRecentEvents", 1000)
+
+	recent     = make([]Event, 0)
+	recentLock = sync.RWMutex{}
+
+	logger = log.WithFields(log.Fields{"app": "burnell,audit"})
+)
+
+// Record records an audit event
+func Record(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	logger.WithFields(log.Fields{
+		"subject":  e.Subject,
+		"tenant":   e.Tenant,
+		"action":   e.Action,
+		"resource": e.Resource,
+		"status":   e.Status,
+	}).Info(e.Detail)
+
+	recentLock.Lock()
+	recent = append(recent, e)
+	if len(recent) > recentSize {
+		recent = recent[len(recent)-recentSize:]
+	}
+	recentLock.Unlock()
+
+	if exporter != nil {
+		exporter.Enqueue(e)
+	}
+	if archiver != nil {
+		archiver.Add(e)
+	}
+}
+
+// Events returns the recent events of a tenant in the reverse chronological order, an empty tenant returns all tenants.
+// A limit of 0 returns all recent events.
+func Events(tenant string, limit int) []Event {
+	recentLock.RLock()
+	defer recentLock.RUnlock()
+	events := []Event{}
+	for i := len(recent) - 1; i >= 0; i-- {
+		if tenant == "" || recent[i].Tenant == tenant {
+			events = append(events, recent[i])
+			if limit > 0 && len(events) >= limit {
+				break
+			}
+		}
+	}
+	return events
+}
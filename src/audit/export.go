@@ -0,0 +1,310 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/syslog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/datastax/burnell/src/util"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// the formats of the HTTP collector
+const (
+	// JSONExportFormat posts a batch as a JSON array
+	JSONExportFormat = "json"
+	// SplunkExportFormat posts a batch to the Splunk HTTP Event Collector
+	SplunkExportFormat = "splunk"
+	// ElasticExportFormat posts a batch to the Elasticsearch bulk API
+	ElasticExportFormat = "elastic"
+)
+
+// Sink delivers a batch of audit events to an external system
+type Sink interface {
+	Send(events []Event) error
+}
+
+// SyslogSink writes every audit event as a JSON message to a syslog server
+type SyslogSink struct {
+	writer *syslog.Writer
+}
+
+// NewSyslogSink connects to a syslog server over udp or tcp
+func NewSyslogSink(network, addr string) (*SyslogSink, error) {
+	writer, err := syslog.Dial(network, addr, syslog.LOG_INFO|syslog.LOG_AUTH, "burnell-audit")
+	if err != nil {
+		return nil, err
+	}
+	return &SyslogSink{writer: writer}, nil
+}
+
+// Send writes the events to syslog, the writer reconnects on a write failure
+func (s *SyslogSink) Send(events []Event) error {
+	for _, e := range events {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if err = s.writer.Info(string(data)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HTTPSink posts a batch of audit events to an HTTP collector
+type HTTPSink struct {
+	URL    string
+	Token  string
+	Format string
+	client *http.Client
+}
+
+// NewHTTPSink creates a sink for the json, splunk or elastic format
+func NewHTTPSink(url, token, format string) (*HTTPSink, error) {
+	format = util.AssignString(strings.ToLower(format), JSONExportFormat)
+	if format != JSONExportFormat && format != SplunkExportFormat && format != ElasticExportFormat {
+		return nil, fmt.Errorf("unsupported audit export format %s", format)
+	}
+	return &HTTPSink{
+		URL:    url,
+		Token:  token,
+		Format: format,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// Body encodes a batch of events in the collector format
+func (s *HTTPSink) Body(events []Event) ([]byte, error) {
+	var buf bytes.Buffer
+	switch s.Format {
+	case SplunkExportFormat:
+		// HEC accepts concatenated event objects
+		for _, e := range events {
+			data, err := json.Marshal(map[string]interface{}{
+				"time":       float64(e.Time.UnixNano()) / float64(time.Second),
+				"sourcetype": "burnell:audit",
+				"event":      e,
+			})
+			if err != nil {
+				return nil, err
+			}
+			buf.Write(data)
+		}
+	case ElasticExportFormat:
+		for _, e := range events {
+			data, err := json.Marshal(e)
+			if err != nil {
+				return nil, err
+			}
+			buf.WriteString(`{"index":{}}` + "\n")
+			buf.Write(data)
+			buf.WriteString("\n")
+		}
+	default:
+		data, err := json.Marshal(events)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(data)
+	}
+	return buf.Bytes(), nil
+}
+
+// Send posts the batch, a non 2xx response is an error
+func (s *HTTPSink) Send(events []Event) error {
+	body, err := s.Body(events)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	switch s.Format {
+	case SplunkExportFormat:
+		req.Header.Set("Content-Type", "application/json")
+		if s.Token != "" {
+			req.Header.Set("Authorization", "Splunk "+s.Token)
+		}
+	case ElasticExportFormat:
+		req.Header.Set("Content-Type", "application/x-ndjson")
+		if s.Token != "" {
+			req.Header.Set("Authorization", "ApiKey "+s.Token)
+		}
+	default:
+		req.Header.Set("Content-Type", "application/json")
+		if s.Token != "" {
+			req.Header.Set("Authorization", "Bearer "+s.Token)
+		}
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("audit collector %s response status code %d", s.URL, resp.StatusCode)
+	}
+	return nil
+}
+
+// Exporter batches audit events and delivers them to a sink with retry
+type Exporter struct {
+	sink          Sink
+	events        chan Event
+	batchSize     int
+	flushInterval time.Duration
+	retries       int
+	backoff       time.Duration
+}
+
+var (
+	exporter *Exporter
+
+	exportCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "burnell",
+		Subsystem: "audit_export",
+		Name:      "events_total",
+		Help:      "The number of audit events exported by the result, sent or dropped.",
+	}, []string{"result"})
+)
+
+func init() {
+	prometheus.MustRegister(exportCounter)
+}
+
+// NewExporter creates an exporter, a batch is delivered once it is full or at every flush interval.
+// A failed batch is retried with an exponential backoff before it is dropped.
+func NewExporter(sink Sink, batchSize int, flushInterval time.Duration, retries int, backoff time.Duration) *Exporter {
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	return &Exporter{
+		sink:          sink,
+		events:        make(chan Event, util.GetEnvInt("AuditExportBufferSize", 10000)),
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		retries:       retries,
+		backoff:       backoff,
+	}
+}
+
+// Start runs the export loop
+func (x *Exporter) Start() {
+	go func() {
+		batch := make([]Event, 0, x.batchSize)
+		ticker := time.NewTicker(x.flushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case e := <-x.events:
+				batch = append(batch, e)
+				if len(batch) >= x.batchSize {
+					x.deliver(batch)
+					batch = make([]Event, 0, x.batchSize)
+				}
+			case <-ticker.C:
+				if len(batch) > 0 {
+					x.deliver(batch)
+					batch = make([]Event, 0, x.batchSize)
+				}
+			}
+		}
+	}()
+}
+
+// Enqueue adds an event to the export buffer without blocking, the event is dropped if the buffer is full
+func (x *Exporter) Enqueue(e Event) bool {
+	select {
+	case x.events <- e:
+		return true
+	default:
+		exportCounter.WithLabelValues("dropped").Inc()
+		return false
+	}
+}
+
+func (x *Exporter) deliver(batch []Event) {
+	backoff := x.backoff
+	for attempt := 0; ; attempt++ {
+		err := x.sink.Send(batch)
+		if err == nil {
+			exportCounter.WithLabelValues("sent").Add(float64(len(batch)))
+			return
+		}
+		if attempt >= x.retries {
+			logger.Errorf("drop %d audit events after %d attempts error %v", len(batch), attempt+1, err)
+			exportCounter.WithLabelValues("dropped").Add(float64(len(batch)))
+			return
+		}
+		logger.Warnf("audit export attempt %d error %v, retry in %v", attempt+1, err, backoff)
+		time.Sleep(backoff)
+		backoff = backoff * 2
+	}
+}
+
+// NewSink creates a sink by the URL scheme, syslog:// or syslog+tcp:// for syslog, http:// or https:// for an HTTP collector
+func NewSink(exportURL, token, format string) (Sink, error) {
+	u, err := url.Parse(exportURL)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "syslog", "syslog+udp":
+		return NewSyslogSink("udp", u.Host)
+	case "syslog+tcp":
+		return NewSyslogSink("tcp", u.Host)
+	case "http", "https":
+		return NewHTTPSink(exportURL, token, format)
+	default:
+		return nil, fmt.Errorf("unsupported audit export url scheme %s", u.Scheme)
+	}
+}
+
+// InitExport starts exporting the audit events if AuditExportURL is configured
+func InitExport() error {
+	config := util.GetConfig()
+	if config.AuditExportURL == "" {
+		return nil
+	}
+	sink, err := NewSink(config.AuditExportURL, config.AuditExportToken, config.AuditExportFormat)
+	if err != nil {
+		return err
+	}
+	exporter = NewExporter(sink,
+		util.GetEnvInt("AuditExportBatchSize", 100),
+		time.Duration(util.GetEnvInt("AuditExportFlushSeconds", 5))*time.Second,
+		util.GetEnvInt("AuditExportRetries", 3),
+		time.Second,
+	)
+	exporter.Start()
+	logger.Infof("export audit events to %s", config.AuditExportURL)
+	return nil
+}
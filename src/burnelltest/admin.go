@@ -0,0 +1,189 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package burnelltest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// AdminServer is a fake broker admin REST API keeping the Pulsar tenants and namespaces in memory.
+// The other admin calls are recorded and replied 204 No Content, or 404 for GET, unless a response is set.
+type AdminServer struct {
+	*httptest.Server
+	tenants   map[string]map[string]bool
+	responses map[string]cannedResponse
+	requests  []string
+	lock      sync.Mutex
+}
+
+type cannedResponse struct {
+	status int
+	body   interface{}
+}
+
+// NewAdminServer starts a fake admin server with the Pulsar tenants
+func NewAdminServer(tenants ...string) *AdminServer {
+	a := &AdminServer{tenants: make(map[string]map[string]bool), responses: make(map[string]cannedResponse)}
+	for _, t := range tenants {
+		a.tenants[t] = make(map[string]bool)
+	}
+	a.Server = httptest.NewServer(http.HandlerFunc(a.serve))
+	return a
+}
+
+// AddTenant creates a Pulsar tenant
+func (a *AdminServer) AddTenant(tenant string) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if _, ok := a.tenants[tenant]; !ok {
+		a.tenants[tenant] = make(map[string]bool)
+	}
+}
+
+// AddNamespace creates a namespace under a Pulsar tenant, the tenant is created if it does not exist
+func (a *AdminServer) AddNamespace(tenant, namespace string) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if _, ok := a.tenants[tenant]; !ok {
+		a.tenants[tenant] = make(map[string]bool)
+	}
+	a.tenants[tenant][namespace] = true
+}
+
+// SetResponse replies the status and the JSON body unless it is nil to the admin calls of the method and path
+func (a *AdminServer) SetResponse(method, path string, status int, body interface{}) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.responses[method+" "+path] = cannedResponse{status: status, body: body}
+}
+
+// Requests returns the received admin requests as "METHOD path" in the order
+func (a *AdminServer) Requests() []string {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return append([]string{}, a.requests...)
+}
+
+func (a *AdminServer) serve(w http.ResponseWriter, r *http.Request) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.requests = append(a.requests, r.Method+" "+r.URL.Path)
+	if resp, ok := a.responses[r.Method+" "+r.URL.Path]; ok {
+		if resp.body == nil {
+			w.WriteHeader(resp.status)
+			return
+		}
+		data, _ := json.Marshal(resp.body)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(resp.status)
+		w.Write(data)
+		return
+	}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) < 3 || parts[0] != "admin" || parts[1] != "v2" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	switch {
+	case parts[2] == "tenants" && len(parts) == 3 && r.Method == http.MethodGet:
+		writeJSON(w, sortedKeys(a.tenants))
+	case parts[2] == "tenants" && len(parts) == 4:
+		a.tenantResource(w, r.Method, parts[3])
+	case parts[2] == "namespaces" && len(parts) == 4 && r.Method == http.MethodGet:
+		namespaces, ok := a.tenants[parts[3]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		names := []string{}
+		for ns := range namespaces {
+			names = append(names, parts[3]+"/"+ns)
+		}
+		sort.Strings(names)
+		writeJSON(w, names)
+	case parts[2] == "namespaces" && len(parts) == 5 && r.Method != http.MethodGet:
+		a.namespaceResource(w, r.Method, parts[3], parts[4])
+	case r.Method == http.MethodGet:
+		w.WriteHeader(http.StatusNotFound)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (a *AdminServer) tenantResource(w http.ResponseWriter, method, tenant string) {
+	_, ok := a.tenants[tenant]
+	switch method {
+	case http.MethodGet:
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		writeJSON(w, map[string]interface{}{"adminRoles": []string{}, "allowedClusters": []string{"standalone"}})
+	case http.MethodPut, http.MethodPost:
+		if !ok {
+			a.tenants[tenant] = make(map[string]bool)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(a.tenants, tenant)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (a *AdminServer) namespaceResource(w http.ResponseWriter, method, tenant, namespace string) {
+	namespaces, ok := a.tenants[tenant]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	switch method {
+	case http.MethodPut:
+		namespaces[namespace] = true
+	case http.MethodDelete:
+		delete(namespaces, namespace)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func sortedKeys(m map[string]map[string]bool) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	data, _ := json.Marshal(v)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
@@ -0,0 +1,54 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package burnelltest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+)
+
+// FederatedMetricsServer serves a federated Prometheus metrics fixture in the text exposition format
+type FederatedMetricsServer struct {
+	*httptest.Server
+	data []byte
+	lock sync.RWMutex
+}
+
+// NewFederatedMetricsServer starts a federated metrics server with the fixture
+func NewFederatedMetricsServer(data []byte) *FederatedMetricsServer {
+	f := &FederatedMetricsServer{data: data}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.lock.RLock()
+		defer f.lock.RUnlock()
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write(f.data)
+	}))
+	return f
+}
+
+// SetMetrics replaces the fixture
+func (f *FederatedMetricsServer) SetMetrics(data []byte) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.data = data
+}
@@ -0,0 +1,138 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+// Package burnelltest runs the burnell HTTP API in-process for the integration tests without a Pulsar cluster.
+// The tenant plans are kept in an in-memory store, the function logs are served by a fake log server,
+// the tenant usage is built from a federated metrics fixture, and the broker admin REST API is faked.
+package burnelltest
+
+import (
+	"fmt"
+	"net/http/httptest"
+
+	"github.com/datastax/burnell/src/logclient"
+	"github.com/datastax/burnell/src/metrics"
+	"github.com/datastax/burnell/src/policy"
+	"github.com/datastax/burnell/src/route"
+	"github.com/datastax/burnell/src/util"
+)
+
+// Options are the initial state of the harness
+type Options struct {
+	// Tenants are the tenant plans created before serving
+	Tenants []policy.TenantPlan
+	// FederatedMetrics is the federated Prometheus metrics fixture, the tenant usage is built from it if it is not empty
+	FederatedMetrics []byte
+}
+
+// Harness is the burnell proxy routes served by a local HTTP server over the in-memory fakes.
+// The package level state of burnell is shared, only one harness can run at a time.
+type Harness struct {
+	*httptest.Server
+	Store   *MemoryTenantStore
+	Metrics *FederatedMetricsServer
+	Logs    *LogServer
+	Admin   *AdminServer
+
+	savedPromURL   string
+	savedLogServer string
+	savedBrokerURL string
+}
+
+// New starts a harness. The token verification is disabled unless the JWT keys are configured,
+// so every request is authorized as the superuser.
+func New(opts Options) (*Harness, error) {
+	logs, err := NewLogServer()
+	if err != nil {
+		return nil, err
+	}
+	tenants := []string{}
+	for _, plan := range opts.Tenants {
+		tenants = append(tenants, plan.Name)
+	}
+	h := &Harness{
+		Store:          NewMemoryTenantStore(),
+		Metrics:        NewFederatedMetricsServer(opts.FederatedMetrics),
+		Logs:           logs,
+		Admin:          NewAdminServer(tenants...),
+		savedPromURL:   util.Config.FederatedPromURL,
+		savedLogServer: util.Config.LogServerPort,
+		savedBrokerURL: util.Config.BrokerProxyURL,
+	}
+	util.Config.FederatedPromURL = h.Metrics.URL
+	util.Config.LogServerPort = logs.Port()
+	util.Config.BrokerProxyURL = h.Admin.URL
+
+	policy.TenantManager.SetupStore(h.Store)
+	for _, plan := range opts.Tenants {
+		if _, _, err := policy.TenantManager.UpdateTenant(plan.Name, plan); err != nil {
+			h.Close()
+			return nil, fmt.Errorf("tenant %s: %v", plan.Name, err)
+		}
+	}
+	if len(opts.FederatedMetrics) > 0 {
+		if err := metrics.InitUsageDbTable(); err != nil {
+			h.Close()
+			return nil, err
+		}
+		h.BuildUsage(opts.FederatedMetrics)
+	}
+
+	route.Init()
+	h.Server = httptest.NewServer(route.NewRouter())
+	return h, nil
+}
+
+// BuildUsage replaces the federated metrics fixture and rebuilds the tenant usage from it
+func (h *Harness) BuildUsage(data []byte) {
+	h.Metrics.SetMetrics(data)
+	metrics.SetCache(metrics.SuperRole, data)
+	metrics.BuildTenantUsage()
+}
+
+// AddFunction registers a running function instance 0 on the local log server with the logs.
+// The function worker address is 127.0.0.1, FunctionWorkerDomain must not be set.
+func (h *Harness) AddFunction(tenant, namespace, function, logs string) {
+	logclient.WriteFunctionMapIfNotExist(tenant+namespace+function, logclient.FunctionType{
+		Tenant:       tenant,
+		Namespace:    namespace,
+		FunctionName: function,
+		Instances: map[int]logclient.InstanceStatus{
+			0: {ID: 0, Running: true, AssignmentWorkerID: "127.0.0.1"},
+		},
+		Parallism: 1,
+	})
+	h.Logs.SetFunctionLog(tenant, namespace, function, "0", logs)
+}
+
+// Close stops the servers and restores the configuration. The tenant manager is left with an empty in-memory store.
+func (h *Harness) Close() {
+	if h.Server != nil {
+		h.Server.Close()
+	}
+	h.Metrics.Close()
+	h.Logs.Close()
+	h.Admin.Close()
+	util.Config.BrokerProxyURL = h.savedBrokerURL
+	util.Config.FederatedPromURL = h.savedPromURL
+	util.Config.LogServerPort = h.savedLogServer
+	policy.TenantManager.SetupStore(NewMemoryTenantStore())
+}
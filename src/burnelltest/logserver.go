@@ -0,0 +1,90 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package burnelltest
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	"github.com/datastax/burnell/src/logstream"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// LogServer is a fake function worker log server over the logstream gRPC service on a local port
+type LogServer struct {
+	listener net.Listener
+	server   *grpc.Server
+	logs     map[string]string
+	lock     sync.RWMutex
+}
+
+// NewLogServer starts a log server on a random local port
+func NewLogServer() (*LogServer, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &LogServer{
+		listener: listener,
+		server:   grpc.NewServer(),
+		logs:     make(map[string]string),
+	}
+	logstream.RegisterLogStreamServer(s.server, s)
+	go s.server.Serve(listener)
+	return s, nil
+}
+
+// Port returns the listening port in the format of :port, as the LogServerPort configuration
+func (s *LogServer) Port() string {
+	_, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	return ":" + port
+}
+
+// SetFunctionLog sets the log content of a function instance
+func (s *LogServer) SetFunctionLog(tenant, namespace, function, instance, logs string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.logs[logstream.FunctionLogPath(tenant, namespace, function, instance)] = logs
+}
+
+// Read returns the whole log of the requested file, a file without logs is not found
+func (s *LogServer) Read(ctx context.Context, in *logstream.ReadRequest) (*logstream.LogLines, error) {
+	s.lock.RLock()
+	logs, ok := s.logs[in.GetFile()]
+	s.lock.RUnlock()
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "log file %s not found", in.GetFile())
+	}
+	return &logstream.LogLines{
+		Logs:          logs,
+		BackwardIndex: 0,
+		ForwardIndex:  int64(len(logs)),
+	}, nil
+}
+
+// Close stops the log server
+func (s *LogServer) Close() {
+	s.server.Stop()
+}
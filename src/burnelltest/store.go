@@ -0,0 +1,59 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package burnelltest
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/datastax/burnell/src/policy"
+)
+
+// MemoryTenantStore is an in-memory tenant store in place of the Pulsar tenant management topic
+type MemoryTenantStore struct {
+	records []policy.TenantPlan
+	lock    sync.Mutex
+}
+
+// NewMemoryTenantStore creates an empty in-memory tenant store
+func NewMemoryTenantStore() *MemoryTenantStore {
+	return &MemoryTenantStore{}
+}
+
+// Write appends a tenant plan record
+func (m *MemoryTenantStore) Write(tenantName string, record []byte) error {
+	var plan policy.TenantPlan
+	if err := json.Unmarshal(record, &plan); err != nil {
+		return err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.records = append(m.records, plan)
+	return nil
+}
+
+// Records returns the written tenant plan records in the write order, as they are in the topic
+func (m *MemoryTenantStore) Records() []policy.TenantPlan {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return append([]policy.TenantPlan{}, m.records...)
+}
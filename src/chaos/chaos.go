@@ -0,0 +1,151 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package chaos
+
+// chaos injects latency and errors into the upstream calls and the policy store
+// to test the resilience of the clients and UI, it is only active with EnableFaultInjection
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/datastax/burnell/src/util"
+)
+
+const (
+	// Upstream is the target of the proxied broker, function worker, and Pulsar SQL calls
+	Upstream = "upstream"
+	// PolicyStore is the target of the tenant plan writes
+	PolicyStore = "policystore"
+)
+
+// Fault is a fault injection rule
+type Fault struct {
+	Target string `json:"target"`
+	// PathPrefix limits the fault to the requests path, or the tenant name for the policy store, empty matches all
+	PathPrefix string `json:"pathPrefix,omitempty"`
+	// LatencyMs is the latency added before the call
+	LatencyMs int `json:"latencyMs,omitempty"`
+	// ErrorRate is the probability between 0 and 1 of failing the call
+	ErrorRate float64 `json:"errorRate,omitempty"`
+	// Status is the status code of the injected upstream error, the default is 503
+	Status   int    `json:"status,omitempty"`
+	Injected uint64 `json:"injected"`
+}
+
+// ErrInjected is the error of an injected fault
+type ErrInjected struct {
+	Target string
+	Path   string
+}
+
+func (e *ErrInjected) Error() string {
+	return fmt.Sprintf("injected %s fault on %s", e.Target, e.Path)
+}
+
+var (
+	faults     = make([]Fault, 0)
+	faultsLock = sync.Mutex{}
+)
+
+// Enabled returns whether the fault injection is turned on, it must never be set in production
+func Enabled() bool {
+	return util.GetConfig().EnableFaultInjection == "true"
+}
+
+// SetFaults validates and replaces the fault rules
+func SetFaults(rules []Fault) error {
+	for i, f := range rules {
+		if f.Target != Upstream && f.Target != PolicyStore {
+			return fmt.Errorf("fault %d target %s must be %s or %s", i, f.Target, Upstream, PolicyStore)
+		}
+		if f.ErrorRate < 0 || f.ErrorRate > 1 {
+			return fmt.Errorf("fault %d error rate %v must be between 0 and 1", i, f.ErrorRate)
+		}
+		if f.LatencyMs < 0 || f.LatencyMs > 60000 {
+			return fmt.Errorf("fault %d latency %d ms must be between 0 and 60000", i, f.LatencyMs)
+		}
+		if f.Status != 0 && (f.Status < 400 || f.Status > 599) {
+			return fmt.Errorf("fault %d status %d must be an error status code", i, f.Status)
+		}
+	}
+	updated := make([]Fault, len(rules))
+	for i, f := range rules {
+		if f.Status == 0 {
+			f.Status = http.StatusServiceUnavailable
+		}
+		f.Injected = 0
+		updated[i] = f
+	}
+	faultsLock.Lock()
+	faults = updated
+	faultsLock.Unlock()
+	return nil
+}
+
+// Faults returns the fault rules and the number of injected errors
+func Faults() []Fault {
+	faultsLock.Lock()
+	defer faultsLock.Unlock()
+	rules := make([]Fault, len(faults))
+	copy(rules, faults)
+	return rules
+}
+
+// Inject applies the first fault rule matching the target and path. It sleeps the latency of the rule,
+// and returns the status code and an error if the call is chosen to fail.
+func Inject(target, path string) (int, error) {
+	if !Enabled() {
+		return 0, nil
+	}
+	faultsLock.Lock()
+	var matched *Fault
+	for i := range faults {
+		if faults[i].Target == target && strings.HasPrefix(path, faults[i].PathPrefix) {
+			matched = &faults[i]
+			break
+		}
+	}
+	if matched == nil {
+		faultsLock.Unlock()
+		return 0, nil
+	}
+	latency := time.Duration(matched.LatencyMs) * time.Millisecond
+	failed := matched.ErrorRate > 0 && rand.Float64() < matched.ErrorRate
+	status := matched.Status
+	if failed {
+		matched.Injected++
+	}
+	faultsLock.Unlock()
+
+	if latency > 0 {
+		time.Sleep(latency)
+	}
+	if failed {
+		return status, &ErrInjected{Target: target, Path: path}
+	}
+	return 0, nil
+}
@@ -0,0 +1,178 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package graphql
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Resolver resolves a field based on its arguments
+type Resolver func(args map[string]interface{}) (interface{}, error)
+
+// Object is a resolved object whose fields are resolved lazily, only the selected fields are evaluated
+type Object map[string]Resolver
+
+// Schema is the root query fields
+type Schema Object
+
+// Request is the GraphQL request body
+type Request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// Error is a GraphQL error
+type Error struct {
+	Message string   `json:"message"`
+	Path    []string `json:"path,omitempty"`
+}
+
+// Response is the GraphQL response body
+type Response struct {
+	Data   map[string]interface{} `json:"data"`
+	Errors []Error                `json:"errors,omitempty"`
+}
+
+// Execute parses and executes the query against the schema.
+// A failed field is set to null and reported in the errors without failing the other fields.
+func Execute(schema Schema, req Request) Response {
+	fields, err := Parse(req.Query, req.Variables)
+	if err != nil {
+		return Response{Errors: []Error{{Message: err.Error()}}}
+	}
+	resp := Response{}
+	resp.Data = executeObject(Object(schema), fields, []string{}, &resp.Errors)
+	return resp
+}
+
+func executeObject(obj Object, fields []Field, path []string, errs *[]Error) map[string]interface{} {
+	result := make(map[string]interface{})
+	for _, f := range fields {
+		fieldPath := childPath(path, f.ResponseKey())
+		if f.Name == "__typename" {
+			result[f.ResponseKey()] = "Object"
+			continue
+		}
+		resolve, ok := obj[f.Name]
+		if !ok {
+			*errs = append(*errs, Error{Message: fmt.Sprintf("unknown field %s", f.Name), Path: fieldPath})
+			continue
+		}
+		args := f.Arguments
+		if args == nil {
+			args = map[string]interface{}{}
+		}
+		value, err := resolve(args)
+		if err != nil {
+			*errs = append(*errs, Error{Message: err.Error(), Path: fieldPath})
+			result[f.ResponseKey()] = nil
+			continue
+		}
+		result[f.ResponseKey()] = complete(value, f.Selections, fieldPath, errs)
+	}
+	return result
+}
+
+// complete projects the selected sub fields on the resolved value
+func complete(value interface{}, selections []Field, path []string, errs *[]Error) interface{} {
+	switch v := value.(type) {
+	case Object:
+		return executeObject(v, selections, path, errs)
+	case []Object:
+		list := make([]interface{}, len(v))
+		for i, obj := range v {
+			list[i] = executeObject(obj, selections, path, errs)
+		}
+		return list
+	}
+	if len(selections) == 0 {
+		return value
+	}
+
+	// plain Go values are converted to generic json values for field selection
+	data, err := json.Marshal(value)
+	if err != nil {
+		*errs = append(*errs, Error{Message: err.Error(), Path: path})
+		return nil
+	}
+	var generic interface{}
+	if err = json.Unmarshal(data, &generic); err != nil {
+		*errs = append(*errs, Error{Message: err.Error(), Path: path})
+		return nil
+	}
+	return project(generic, selections, path, errs)
+}
+
+func project(value interface{}, selections []Field, path []string, errs *[]Error) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{})
+		for _, f := range selections {
+			fieldValue, ok := v[f.Name]
+			if !ok {
+				*errs = append(*errs, Error{Message: fmt.Sprintf("unknown field %s", f.Name), Path: childPath(path, f.ResponseKey())})
+				continue
+			}
+			if len(f.Selections) > 0 {
+				fieldValue = project(fieldValue, f.Selections, childPath(path, f.ResponseKey()), errs)
+			}
+			result[f.ResponseKey()] = fieldValue
+		}
+		return result
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			list[i] = project(item, selections, path, errs)
+		}
+		return list
+	default:
+		return value
+	}
+}
+
+// childPath copies the path so that the error paths do not share the underlying array
+func childPath(path []string, key string) []string {
+	return append(append(make([]string, 0, len(path)+1), path...), key)
+}
+
+// StringArg returns a required string argument
+func StringArg(args map[string]interface{}, name string) (string, error) {
+	if v, ok := args[name].(string); ok && v != "" {
+		return v, nil
+	}
+	return "", fmt.Errorf("missing required string argument %s", name)
+}
+
+// IntArg returns a required integer argument, a variable decoded from json is a whole float64
+func IntArg(args map[string]interface{}, name string) (int, error) {
+	switch v := args[name].(type) {
+	case int:
+		return v, nil
+	case float64:
+		if v == float64(int(v)) {
+			return int(v), nil
+		}
+	}
+	return 0, fmt.Errorf("missing required integer argument %s", name)
+}
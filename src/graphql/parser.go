@@ -0,0 +1,334 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package graphql
+
+// a parser for the subset of GraphQL query language used by burnell,
+// that includes query operation, variables, aliases, field arguments, and nested selection sets.
+// Fragments, directives, and mutations are not supported.
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Field is a selected field in the query
+type Field struct {
+	Alias      string
+	Name       string
+	Arguments  map[string]interface{}
+	Selections []Field
+}
+
+// ResponseKey is the key of the field in the response which is either the alias or the name
+func (f Field) ResponseKey() string {
+	if f.Alias != "" {
+		return f.Alias
+	}
+	return f.Name
+}
+
+// variable is a reference to a query variable to be resolved at execution
+type variable string
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenName
+	tokenString
+	tokenNumber
+	tokenPunct
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+}
+
+// maxSelectionDepth is the max nesting of the selection sets, a deeper query is a parse error
+const maxSelectionDepth = 16
+
+type parser struct {
+	tokens []token
+	pos    int
+	depth  int
+}
+
+// Parse parses a query document and returns the top level selections.
+// Variables referenced in arguments are substituted by the supplied values.
+func Parse(query string, variables map[string]interface{}) ([]Field, error) {
+	tokens, err := tokenize(query)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	fields, err := p.parseDocument()
+	if err != nil {
+		return nil, err
+	}
+	if err = substitute(fields, variables); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+func tokenize(query string) ([]token, error) {
+	tokens := []token{}
+	runes := []rune(query)
+	for i := 0; i < len(runes); {
+		c := runes[i]
+		switch {
+		case unicode.IsSpace(c) || c == ',':
+			i++
+		case c == '#':
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+		case strings.ContainsRune("{}():$!=[]", c):
+			tokens = append(tokens, token{tokenPunct, string(c)})
+			i++
+		case c == '"':
+			var sb strings.Builder
+			i++
+			for ; i < len(runes) && runes[i] != '"'; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				sb.WriteRune(runes[i])
+			}
+			if i >= len(runes) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			i++
+			tokens = append(tokens, token{tokenString, sb.String()})
+		case c == '-' || unicode.IsDigit(c):
+			start := i
+			i++
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, token{tokenNumber, string(runes[start:i])})
+		case c == '_' || unicode.IsLetter(c):
+			start := i
+			for i < len(runes) && (runes[i] == '_' || unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i])) {
+				i++
+			}
+			tokens = append(tokens, token{tokenName, string(runes[start:i])})
+		default:
+			return nil, fmt.Errorf("unexpected character %q", c)
+		}
+	}
+	return append(tokens, token{kind: tokenEOF}), nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) isPunct(v string) bool {
+	t := p.peek()
+	return t.kind == tokenPunct && t.value == v
+}
+
+func (p *parser) expectPunct(v string) error {
+	if t := p.next(); t.kind != tokenPunct || t.value != v {
+		return fmt.Errorf("expected %s but got %q", v, t.value)
+	}
+	return nil
+}
+
+func (p *parser) expectName() (string, error) {
+	t := p.next()
+	if t.kind != tokenName {
+		return "", fmt.Errorf("expected a name but got %q", t.value)
+	}
+	return t.value, nil
+}
+
+func (p *parser) parseDocument() ([]Field, error) {
+	if t := p.peek(); t.kind == tokenName {
+		if t.value != "query" {
+			return nil, fmt.Errorf("unsupported operation %s", t.value)
+		}
+		p.next()
+		if p.peek().kind == tokenName {
+			p.next() // operation name
+		}
+		if p.isPunct("(") {
+			if err := p.skipVariableDefinitions(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	fields, err := p.parseSelectionSet()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %q after the query", t.value)
+	}
+	return fields, nil
+}
+
+// variable definitions are not type checked, the handler supplies the values
+func (p *parser) skipVariableDefinitions() error {
+	depth := 0
+	for {
+		t := p.next()
+		switch {
+		case t.kind == tokenEOF:
+			return fmt.Errorf("unterminated variable definitions")
+		case t.kind == tokenPunct && t.value == "(":
+			depth++
+		case t.kind == tokenPunct && t.value == ")":
+			if depth--; depth == 0 {
+				return nil
+			}
+		}
+	}
+}
+
+func (p *parser) parseSelectionSet() ([]Field, error) {
+	if err := p.expectPunct("{"); err != nil {
+		return nil, err
+	}
+	if p.depth++; p.depth > maxSelectionDepth {
+		return nil, fmt.Errorf("selection sets are nested deeper than %d", maxSelectionDepth)
+	}
+	defer func() { p.depth-- }()
+	fields := []Field{}
+	for !p.isPunct("}") {
+		if p.peek().kind == tokenEOF {
+			return nil, fmt.Errorf("unterminated selection set")
+		}
+		f, err := p.parseField()
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, f)
+	}
+	p.next()
+	return fields, nil
+}
+
+func (p *parser) parseField() (Field, error) {
+	f := Field{}
+	name, err := p.expectName()
+	if err != nil {
+		return f, err
+	}
+	if p.isPunct(":") {
+		p.next()
+		f.Alias = name
+		if name, err = p.expectName(); err != nil {
+			return f, err
+		}
+	}
+	f.Name = name
+	if p.isPunct("(") {
+		if f.Arguments, err = p.parseArguments(); err != nil {
+			return f, err
+		}
+	}
+	if p.isPunct("{") {
+		if f.Selections, err = p.parseSelectionSet(); err != nil {
+			return f, err
+		}
+	}
+	return f, nil
+}
+
+func (p *parser) parseArguments() (map[string]interface{}, error) {
+	p.next()
+	args := make(map[string]interface{})
+	for !p.isPunct(")") {
+		name, err := p.expectName()
+		if err != nil {
+			return nil, err
+		}
+		if err = p.expectPunct(":"); err != nil {
+			return nil, err
+		}
+		if args[name], err = p.parseValue(); err != nil {
+			return nil, err
+		}
+	}
+	p.next()
+	return args, nil
+}
+
+func (p *parser) parseValue() (interface{}, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenString:
+		return t.value, nil
+	case tokenNumber:
+		if n, err := strconv.Atoi(t.value); err == nil {
+			return n, nil
+		}
+		return strconv.ParseFloat(t.value, 64)
+	case tokenName:
+		switch t.value {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+		return t.value, nil // enum value
+	case tokenPunct:
+		if t.value == "$" {
+			name, err := p.expectName()
+			return variable(name), err
+		}
+	}
+	return nil, fmt.Errorf("unexpected argument value %q", t.value)
+}
+
+func substitute(fields []Field, variables map[string]interface{}) error {
+	for i := range fields {
+		for k, v := range fields[i].Arguments {
+			if name, ok := v.(variable); ok {
+				value, exists := variables[string(name)]
+				if !exists {
+					return fmt.Errorf("variable $%s is not provided", name)
+				}
+				fields[i].Arguments[k] = value
+			}
+		}
+		if err := substitute(fields[i].Selections, variables); err != nil {
+			return err
+		}
+	}
+	return nil
+}
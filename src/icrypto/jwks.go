@@ -0,0 +1,135 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package icrypto
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+)
+
+// JSONWebKey is a public key in the JWK format, RFC 7517
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg,omitempty"`
+	Kid string `json:"kid"`
+	// RSA modulus and exponent
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// EC curve and coordinates
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JSONWebKeySet is a set of public keys in the JWKS format
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+func base64URL(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// thumbprint is the RFC 7638 JWK thumbprint as the key ID, computed from the required members in lexicographic order
+func thumbprint(members interface{}) string {
+	data, _ := json.Marshal(members)
+	sum := sha256.Sum256(data)
+	return base64URL(sum[:])
+}
+
+// rsaJWK converts a RSA public key to JWK
+func rsaJWK(key *rsa.PublicKey) JSONWebKey {
+	n := base64URL(key.N.Bytes())
+	e := base64URL(big.NewInt(int64(key.E)).Bytes())
+	return JSONWebKey{
+		Kty: "RSA",
+		Use: "sig",
+		N:   n,
+		E:   e,
+		Kid: thumbprint(struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{e, "RSA", n}),
+	}
+}
+
+// ecJWK converts an ECDSA public key to JWK
+func ecJWK(key *ecdsa.PublicKey) JSONWebKey {
+	params := key.Curve.Params()
+	size := (params.BitSize + 7) / 8
+	x := base64URL(padBytes(key.X.Bytes(), size))
+	y := base64URL(padBytes(key.Y.Bytes(), size))
+	alg := map[string]string{"P-256": "ES256", "P-384": "ES384", "P-521": "ES512"}[params.Name]
+	return JSONWebKey{
+		Kty: "EC",
+		Use: "sig",
+		Alg: alg,
+		Crv: params.Name,
+		X:   x,
+		Y:   y,
+		Kid: thumbprint(struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{params.Name, "EC", x, y}),
+	}
+}
+
+// padBytes left pads the big endian bytes to the size of the curve coordinate
+func padBytes(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	padded := make([]byte, size)
+	copy(padded[size-len(b):], b)
+	return padded
+}
+
+// JWKS returns the active public keys, the HMAC secret key is never exposed
+func (keys *JWTKeys) JWKS() JSONWebKeySet {
+	set := JSONWebKeySet{Keys: []JSONWebKey{}}
+	if keys.RSA != nil && keys.RSA.PublicKey != nil {
+		set.Keys = append(set.Keys, rsaJWK(keys.RSA.PublicKey))
+	}
+	if keys.ECPublicKey != nil {
+		set.Keys = append(set.Keys, ecJWK(keys.ECPublicKey))
+	}
+	return set
+}
+
+// keyID returns the JWKS key ID of the verification key of the signing key
+func (keys *JWTKeys) keyID(signingKey interface{}) string {
+	switch k := signingKey.(type) {
+	case *rsa.PrivateKey:
+		return rsaJWK(&k.PublicKey).Kid
+	case *ecdsa.PrivateKey:
+		return ecJWK(&k.PublicKey).Kid
+	}
+	return ""
+}
@@ -0,0 +1,199 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package icrypto
+
+import (
+	"crypto/ecdsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// DefaultAllowedAlgs is the default allowed token signature algorithms, Pulsar default RSA
+const DefaultAllowedAlgs = "RS256,RS384,RS512"
+
+// JWTKeys signs and verifies tokens with RSA, ECDSA or HMAC keys by the algorithm in the token header.
+// Only the algorithms in the allowlist are accepted.
+type JWTKeys struct {
+	RSA          *RSAKeyPair
+	ECPrivateKey *ecdsa.PrivateKey
+	ECPublicKey  *ecdsa.PublicKey
+	HMACSecret   []byte
+	allowedAlgs  map[string]bool
+}
+
+// NewJWTKeys creates JWTKeys with a comma separated list of allowed algorithms, i.e. RS256,ES256,HS256
+func NewJWTKeys(rsaKeys *RSAKeyPair, allowedAlgs string) *JWTKeys {
+	keys := &JWTKeys{
+		RSA:         rsaKeys,
+		allowedAlgs: make(map[string]bool),
+	}
+	for _, alg := range strings.Split(allowedAlgs, ",") {
+		alg = strings.ToUpper(strings.TrimSpace(alg))
+		// none is never allowed
+		if method := SigMethod(alg); method != nil && method != jwt.SigningMethodNone {
+			keys.allowedAlgs[method.Alg()] = true
+		}
+	}
+	return keys
+}
+
+// LoadECDSAKeys loads the ECDSA private and public key in PEM or DER format, the private key is optional for verification only
+func (keys *JWTKeys) LoadECDSAKeys(privateKeyPath, publicKeyPath string) error {
+	if privateKeyPath != "" {
+		data, err := getDataFromKeyFile(privateKeyPath)
+		if err != nil {
+			return err
+		}
+		key, err := x509.ParsePKCS8PrivateKey(data)
+		if err != nil {
+			if key, err = x509.ParseECPrivateKey(data); err != nil {
+				return err
+			}
+		}
+		ecPrivate, ok := key.(*ecdsa.PrivateKey)
+		if !ok {
+			return fmt.Errorf("expected key to be of type *ecdsa.PrivateKey, but actual was %T", key)
+		}
+		keys.ECPrivateKey = ecPrivate
+		keys.ECPublicKey = &ecPrivate.PublicKey
+	}
+	if publicKeyPath != "" {
+		data, err := getDataFromKeyFile(publicKeyPath)
+		if err != nil {
+			return err
+		}
+		key, err := x509.ParsePKIXPublicKey(data)
+		if err != nil {
+			return err
+		}
+		ecPublic, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("expected key to be of type *ecdsa.PublicKey, but actual was %T", key)
+		}
+		keys.ECPublicKey = ecPublic
+	}
+	return nil
+}
+
+// LoadHMACSecret loads the HMAC secret key file, the same secret key file used by Pulsar
+func (keys *JWTKeys) LoadHMACSecret(secretKeyPath string) error {
+	data, err := ioutil.ReadFile(secretKeyPath)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return errors.New("empty HMAC secret key")
+	}
+	keys.HMACSecret = data
+	return nil
+}
+
+// IsAllowed returns if the signing method is in the allowlist
+func (keys *JWTKeys) IsAllowed(method jwt.SigningMethod) bool {
+	return method != nil && keys.allowedAlgs[method.Alg()]
+}
+
+func (keys *JWTKeys) key(method jwt.SigningMethod, private bool) (interface{}, error) {
+	if method == nil {
+		return nil, errors.New("missing signing method")
+	}
+	if !keys.IsAllowed(method) {
+		return nil, fmt.Errorf("signing method %v is not allowed", method.Alg())
+	}
+	switch method.(type) {
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+		if keys.RSA != nil && private && keys.RSA.PrivateKey != nil {
+			return keys.RSA.PrivateKey, nil
+		} else if keys.RSA != nil && !private && keys.RSA.PublicKey != nil {
+			return keys.RSA.PublicKey, nil
+		}
+	case *jwt.SigningMethodECDSA:
+		if private && keys.ECPrivateKey != nil {
+			return keys.ECPrivateKey, nil
+		} else if !private && keys.ECPublicKey != nil {
+			return keys.ECPublicKey, nil
+		}
+	case *jwt.SigningMethodHMAC:
+		if len(keys.HMACSecret) > 0 {
+			return keys.HMACSecret, nil
+		}
+	}
+	return nil, fmt.Errorf("missing key for signing method %v", method.Alg())
+}
+
+// GenerateToken generates token with user defined subject signed by the key of the signing method
+func (keys *JWTKeys) GenerateToken(userSubject string, timeDuration time.Duration, signingMethod jwt.SigningMethod) (string, error) {
+	signingKey, err := keys.key(signingMethod, true)
+	if err != nil {
+		return "", err
+	}
+	token := jwt.New(signingMethod)
+	if timeDuration > 0 {
+		token.Claims = jwt.MapClaims{
+			"exp": time.Now().Add(timeDuration).Unix(),
+			"iat": time.Now().Unix(),
+			"sub": userSubject,
+		}
+	} else {
+		token.Claims = jwt.MapClaims{
+			"sub": userSubject,
+		}
+	}
+	// the key ID to look up the public key in the JWKS
+	if kid := keys.keyID(signingKey); kid != "" {
+		token.Header["kid"] = kid
+	}
+	return token.SignedString(signingKey)
+}
+
+// DecodeToken decodes a token string verified by the key of the algorithm in the token header
+func (keys *JWTKeys) DecodeToken(tokenStr string) (*jwt.Token, error) {
+	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
+		return keys.key(token.Method, false)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if token.Valid {
+		return token, nil
+	}
+	return nil, errors.New("invalid token")
+}
+
+// GetTokenSubject gets the subjects from a token
+func (keys *JWTKeys) GetTokenSubject(tokenStr string) (string, error) {
+	token, err := keys.DecodeToken(tokenStr)
+	if err != nil {
+		return "", err
+	}
+	claims := token.Claims.(jwt.MapClaims)
+	if subjects, ok := claims["sub"].(string); ok {
+		return subjects, nil
+	}
+	return "", errors.New("missing subjects")
+}
@@ -86,23 +86,9 @@ func Init() {
 
 // GetK8sClient gets k8s clientset
 func GetK8sClient() (*Client, error) {
-	var config *rest.Config
-
-	if home := homedir.HomeDir(); home != "" {
-		// TODO: add configuration to allow customized config file
-		kubeconfig := filepath.Join(home, ".kube", "config")
-		if _, err := os.Stat(kubeconfig); os.IsNotExist(err) {
-			log.Infof("this is an in-cluster k8s monitor")
-			if config, err = rest.InClusterConfig(); err != nil {
-				return nil, err
-			}
-
-		} else {
-			log.Infof("this is outside of k8s cluster deployment, kubeconfig dir %s", kubeconfig)
-			if config, err = clientcmd.BuildConfigFromFlags("", kubeconfig); err != nil {
-				return nil, err
-			}
-		}
+	config, err := restConfig()
+	if err != nil {
+		return nil, err
 	}
 
 	clientset, err := kubernetes.NewForConfig(config)
@@ -123,6 +109,29 @@ func GetK8sClient() (*Client, error) {
 	return &client, nil
 }
 
+// restConfig returns the kubeconfig under the home directory outside of k8s, or the in-cluster config
+func restConfig() (*rest.Config, error) {
+	var config *rest.Config
+
+	if home := homedir.HomeDir(); home != "" {
+		// TODO: add configuration to allow customized config file
+		kubeconfig := filepath.Join(home, ".kube", "config")
+		if _, err := os.Stat(kubeconfig); os.IsNotExist(err) {
+			log.Infof("this is an in-cluster k8s monitor")
+			if config, err = rest.InClusterConfig(); err != nil {
+				return nil, err
+			}
+
+		} else {
+			log.Infof("this is outside of k8s cluster deployment, kubeconfig dir %s", kubeconfig)
+			if config, err = clientcmd.BuildConfigFromFlags("", kubeconfig); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return config, nil
+}
+
 func buildInClusterConfig() kubernetes.Interface {
 	config, err := rest.InClusterConfig()
 	if err != nil {
@@ -0,0 +1,189 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package k8s
+
+// the TenantPlan custom resource controller reconciles the tenant plans declared in k8s into the policy store,
+// and reports the effective plan enforced by burnell back in the custom resource status
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/datastax/burnell/src/policy"
+	"github.com/datastax/burnell/src/util"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+// TenantPlanGVR is the group version resource of the TenantPlan custom resource
+var TenantPlanGVR = schema.GroupVersionResource{Group: "burnell.datastax.com", Version: "v1alpha1", Resource: "tenantplans"}
+
+// TenantPlanStore is the policy store the custom resources are reconciled into
+type TenantPlanStore interface {
+	GetTenant(tenantName string) (policy.TenantPlan, error)
+	UpdateTenant(tenantName string, tenantPlan policy.TenantPlan) (policy.TenantPlan, int, error)
+}
+
+// TenantPlanStatus is the status of a TenantPlan custom resource
+type TenantPlanStatus struct {
+	ObservedGeneration int64              `json:"observedGeneration"`
+	Synced             bool               `json:"synced"`
+	Message            string             `json:"message,omitempty"`
+	PlanType           string             `json:"planType,omitempty"`
+	Policy             *policy.PlanPolicy `json:"policy,omitempty"`
+	UpdatedAt          string             `json:"updatedAt,omitempty"`
+}
+
+// TenantPlanController reconciles the TenantPlan custom resources of a k8s namespace, an empty namespace is all namespaces
+type TenantPlanController struct {
+	client    dynamic.Interface
+	namespace string
+	store     TenantPlanStore
+	logger    *log.Entry
+}
+
+// NewTenantPlanController creates a TenantPlan controller
+func NewTenantPlanController(client dynamic.Interface, namespace string, store TenantPlanStore) *TenantPlanController {
+	return &TenantPlanController{
+		client:    client,
+		namespace: namespace,
+		store:     store,
+		logger:    log.WithFields(log.Fields{"app": "tenantplan-controller"}),
+	}
+}
+
+// Reconcile updates the tenant plan of every custom resource whose spec differs from the policy store,
+// and writes the status. It returns the number of updated tenant plans.
+func (c *TenantPlanController) Reconcile(ctx context.Context) (int, error) {
+	list, err := c.client.Resource(TenantPlanGVR).Namespace(c.namespace).List(ctx, meta_v1.ListOptions{})
+	if err != nil {
+		return 0, err
+	}
+	updated := 0
+	for i := range list.Items {
+		item := &list.Items[i]
+		status, changed := c.reconcileItem(item)
+		if changed {
+			updated++
+		}
+		if err := c.writeStatus(ctx, item, status); err != nil {
+			c.logger.Errorf("tenantplan %s/%s status update error %v", item.GetNamespace(), item.GetName(), err)
+		}
+	}
+	return updated, nil
+}
+
+// reconcileItem applies the spec of a custom resource, the tenant name is the spec name or the resource name
+func (c *TenantPlanController) reconcileItem(item *unstructured.Unstructured) (TenantPlanStatus, bool) {
+	status := TenantPlanStatus{ObservedGeneration: item.GetGeneration()}
+	var spec policy.TenantPlan
+	data, err := json.Marshal(item.Object["spec"])
+	if err == nil {
+		err = json.Unmarshal(data, &spec)
+	}
+	if err != nil {
+		status.Message = fmt.Sprintf("invalid spec %v", err)
+		return status, false
+	}
+	tenant := util.AssignString(spec.Name, item.GetName())
+
+	existing, _ := c.store.GetTenant(tenant)
+	spec.PlanType = util.AssignString(util.AssignString(spec.PlanType, existing.PlanType), policy.FreeTier)
+	_, fields, err := policy.DiffTenantPlan(spec, existing)
+	if err != nil {
+		status.Message = err.Error()
+		return status, false
+	}
+	plan := existing
+	changed := len(fields) > 0
+	if changed {
+		if plan, _, err = c.store.UpdateTenant(tenant, spec); err != nil {
+			status.Message = err.Error()
+			return status, false
+		}
+		c.logger.Infof("tenantplan %s/%s updated tenant %s fields %v", item.GetNamespace(), item.GetName(), tenant, fields)
+	}
+	status.Synced = true
+	status.PlanType = plan.PlanType
+	status.Policy = &plan.Policy
+	status.UpdatedAt = plan.UpdatedAt.UTC().Format(time.RFC3339)
+	return status, changed
+}
+
+// writeStatus updates the status subresource unless it is unchanged
+func (c *TenantPlanController) writeStatus(ctx context.Context, item *unstructured.Unstructured, status TenantPlanStatus) error {
+	data, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	desired := map[string]interface{}{}
+	if err := json.Unmarshal(data, &desired); err != nil {
+		return err
+	}
+	current, _ := json.Marshal(item.Object["status"])
+	if next, _ := json.Marshal(desired); bytes.Equal(current, next) {
+		return nil
+	}
+	item.Object["status"] = desired
+	_, err = c.client.Resource(TenantPlanGVR).Namespace(item.GetNamespace()).UpdateStatus(ctx, item, meta_v1.UpdateOptions{})
+	return err
+}
+
+// Run reconciles the custom resources at the interval
+func (c *TenantPlanController) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	for {
+		if updated, err := c.Reconcile(context.Background()); err != nil {
+			c.logger.Errorf("tenantplan reconcile error %v", err)
+		} else if updated > 0 {
+			c.logger.Infof("tenantplan reconcile updated %d tenant plans", updated)
+		}
+		<-ticker.C
+	}
+}
+
+// StartTenantPlanController starts the TenantPlan controller when TenantPlanSyncIntervalSeconds is set,
+// TenantPlanNamespace limits the custom resources to a k8s namespace
+func StartTenantPlanController(store TenantPlanStore) error {
+	interval := time.Duration(util.GetEnvInt("TenantPlanSyncIntervalSeconds", 0)) * time.Second
+	if interval <= 0 {
+		return nil
+	}
+	config, err := restConfig()
+	if err != nil {
+		return err
+	}
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+	controller := NewTenantPlanController(client, os.Getenv("TenantPlanNamespace"), store)
+	go controller.Run(interval)
+	return nil
+}
@@ -0,0 +1,152 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package logclient
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/golang/protobuf/proto"
+
+	"github.com/datastax/burnell/src/pb"
+	"github.com/datastax/burnell/src/util"
+)
+
+// ApplyAssignment updates the function instance worker from an assignment
+func ApplyAssignment(a *pb.Assignment) error {
+	md := a.GetInstance().GetFunctionMetaData()
+	if md.GetFunctionDetails() == nil {
+		return fmt.Errorf("assignment misses function details")
+	}
+	fd := md.GetFunctionDetails()
+	key := fd.GetTenant() + fd.GetNamespace() + fd.GetName()
+	if _, ok := ReadFunctionMap(key); !ok {
+		ParseServiceRequest(md)
+	}
+	setAssignmentWorkerID(key, int(a.GetInstance().GetInstanceId()), a.GetWorkerId())
+	return nil
+}
+
+// RemoveAssignment removes the worker assignment of a function instance.
+// The key is the fully qualified instance name such as tenant/namespace/function:0
+func RemoveAssignment(instanceKey string) error {
+	i := strings.LastIndex(instanceKey, ":")
+	if i < 0 {
+		return fmt.Errorf("invalid assignment key %s", instanceKey)
+	}
+	instanceID, err := strconv.Atoi(instanceKey[i+1:])
+	if err != nil {
+		return fmt.Errorf("invalid assignment key %s", instanceKey)
+	}
+	parts := strings.Split(instanceKey[:i], "/")
+	if len(parts) != 3 {
+		return fmt.Errorf("invalid assignment key %s", instanceKey)
+	}
+	setAssignmentWorkerID(parts[0]+parts[1]+parts[2], instanceID, "")
+	return nil
+}
+
+func setAssignmentWorkerID(key string, instanceID int, workerID string) {
+	fnMpLock.Lock()
+	defer fnMpLock.Unlock()
+	if f, ok := functionMap[key]; ok {
+		status, exists := f.Instances[instanceID]
+		if !exists {
+			status = InstanceStatus{ID: instanceID}
+		}
+		status.AssignmentWorkerID = workerID
+		f.Instances[instanceID] = status
+		functionMap[key] = f
+	}
+}
+
+// AssignmentReaderLoop continuously reads function instance assignments from the functions assignment topic
+func AssignmentReaderLoop(sig chan *liveSignal) {
+	defer func(s chan *liveSignal) {
+		logger.Errorf("function assignment listener terminated")
+		s <- &liveSignal{}
+	}(sig)
+
+	topicName := util.AssignString(util.GetConfig().FunctionAssignmentTopic, "persistent://public/functions/assignments")
+	client, err := newPulsarClient()
+	if err != nil {
+		logger.Errorf("pulsar.NewClient %v", err)
+		util.PulsarClientFailed(util.FunctionAssignClient, topicName, err)
+		return
+	}
+	defer client.Close()
+
+	reader, err := client.CreateReader(pulsar.ReaderOptions{
+		Topic:          topicName,
+		StartMessageID: pulsar.EarliestMessageID(),
+	})
+	if err != nil {
+		logger.Errorf("pulsar.CreateReader %v", err)
+		util.PulsarClientFailed(util.FunctionAssignClient, topicName, err)
+		return
+	}
+	defer reader.Close()
+	util.PulsarClientConnected(util.FunctionAssignClient, topicName)
+
+	ctx := context.Background()
+	for {
+		msg, err := reader.Next(ctx)
+		if err != nil {
+			logger.Errorf("pulsar.reader.Next %v", err)
+			util.PulsarClientFailed(util.FunctionAssignClient, topicName, err)
+			return
+		}
+		util.PulsarClientMessage(util.FunctionAssignClient)
+		// an empty payload is the tombstone of an instance assignment
+		if len(msg.Payload()) == 0 {
+			if err := RemoveAssignment(msg.Key()); err != nil {
+				logger.Errorf("%v", err)
+			}
+			continue
+		}
+		a := pb.Assignment{}
+		if err := proto.Unmarshal(msg.Payload(), &a); err != nil {
+			logger.Errorf("failed to unmarshal function assignment %v", err)
+			continue
+		}
+		if err := ApplyAssignment(&a); err != nil {
+			logger.Errorf("%v", err)
+		}
+	}
+}
+
+// FunctionAssignmentWatchDog is a watch dog for the function assignment topic reader process
+func FunctionAssignmentWatchDog() {
+	go func() {
+		s := make(chan *liveSignal)
+		go AssignmentReaderLoop(s)
+		for {
+			select {
+			case <-s:
+				go AssignmentReaderLoop(s)
+			}
+		}
+	}()
+}
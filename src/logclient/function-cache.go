@@ -0,0 +1,202 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package logclient
+
+import (
+	"container/list"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/datastax/burnell/src/util"
+)
+
+// the max number of functions in functionMap, the least recently used functions are evicted over it, 0 is no limit
+var functionCacheMax = util.GetEnvInt("FunctionCacheMaxEntries", 10000)
+
+// a deleted function stays in functionMap for its logs until the retention is over
+var functionDeletedRetention = time.Duration(util.GetEnvInt("FunctionCacheDeletedRetentionMinutes", 1440)) * time.Minute
+
+var (
+	// functionLRU is the functionMap keys from the most to the least recently used, guarded by lruLock
+	functionLRU     = list.New()
+	functionLRUKeys = make(map[string]*list.Element)
+	lruLock         = sync.Mutex{}
+
+	// deletedFunctions is the time the functions are deleted, guarded by fnMpLock
+	deletedFunctions = make(map[string]time.Time)
+)
+
+// SetFunctionCacheLimit sets the max number of functions in the cache, 0 is no limit
+func SetFunctionCacheLimit(max int) {
+	fnMpLock.Lock()
+	defer fnMpLock.Unlock()
+	functionCacheMax = max
+	evictFunctions()
+}
+
+// touchFunction marks the function as the most recently used
+func touchFunction(key string) {
+	lruLock.Lock()
+	defer lruLock.Unlock()
+	if e, ok := functionLRUKeys[key]; ok {
+		functionLRU.MoveToFront(e)
+		return
+	}
+	functionLRUKeys[key] = functionLRU.PushFront(key)
+}
+
+// removeFunction removes a function from the cache, the caller holds fnMpLock
+func removeFunction(key string) {
+	delete(functionMap, key)
+	delete(deletedFunctions, key)
+	lruLock.Lock()
+	if e, ok := functionLRUKeys[key]; ok {
+		functionLRU.Remove(e)
+		delete(functionLRUKeys, key)
+	}
+	lruLock.Unlock()
+}
+
+// evictFunctions evicts the least recently used functions over the limit, the caller holds fnMpLock
+func evictFunctions() {
+	if functionCacheMax <= 0 {
+		return
+	}
+	evicted := 0
+	for len(functionMap) > functionCacheMax {
+		lruLock.Lock()
+		e := functionLRU.Back()
+		lruLock.Unlock()
+		if e == nil {
+			break
+		}
+		removeFunction(e.Value.(string))
+		evicted++
+	}
+	util.CacheEvicted(util.FunctionCache, util.EvictedLRU, evicted)
+}
+
+// setFunctionDeleted marks or unmarks a function deleted at the time
+func setFunctionDeleted(key string, deleted bool, t time.Time) {
+	fnMpLock.Lock()
+	defer fnMpLock.Unlock()
+	if _, ok := functionMap[key]; !ok {
+		return
+	}
+	if deleted {
+		deletedFunctions[key] = t
+	} else {
+		delete(deletedFunctions, key)
+	}
+}
+
+// CompactFunctionMap drops the functions deleted over the retention and the functions under deleted tenants,
+// and records the cache size. It returns the number of dropped functions.
+func CompactFunctionMap(tenantDeleted func(string) bool, now time.Time) int {
+	fnMpLock.Lock()
+	defer fnMpLock.Unlock()
+	dropped := 0
+	bytes := 0
+	for k, v := range functionMap {
+		if deletedAt, ok := deletedFunctions[k]; ok && now.Sub(deletedAt) > functionDeletedRetention {
+			removeFunction(k)
+			dropped++
+			continue
+		}
+		if tenantDeleted != nil && tenantDeleted(v.Tenant) {
+			removeFunction(k)
+			dropped++
+			continue
+		}
+		if data, err := json.Marshal(v); err == nil {
+			bytes += len(k) + len(data)
+		}
+	}
+	util.CacheEvicted(util.FunctionCache, util.EvictedDeleted, dropped)
+	util.CacheSize(util.FunctionCache, len(functionMap), bytes)
+	return dropped
+}
+
+// FunctionCacheCompactor periodically compacts the function cache
+func FunctionCacheCompactor(tenantDeleted func(string) bool) {
+	interval := time.Duration(util.GetEnvInt("CacheCompactionIntervalSeconds", 600)) * time.Second
+	go func() {
+		ticker := time.NewTicker(interval)
+		for {
+			select {
+			case <-ticker.C:
+				if dropped := CompactFunctionMap(tenantDeleted, time.Now()); dropped > 0 {
+					logger.Infof("compacted %d deleted functions from the cache", dropped)
+				}
+			}
+		}
+	}()
+}
+
+// functionSnapshot is the function cache checkpointed along with the cursor of the function metadata reader
+type functionSnapshot struct {
+	Functions map[string]FunctionType `json:"functions"`
+	Deleted   map[string]time.Time    `json:"deleted"`
+}
+
+// functionCacheSnapshot returns the snapshot of the function cache
+func functionCacheSnapshot() (interface{}, error) {
+	fnMpLock.RLock()
+	defer fnMpLock.RUnlock()
+	snapshot := functionSnapshot{
+		Functions: make(map[string]FunctionType, len(functionMap)),
+		Deleted:   make(map[string]time.Time, len(deletedFunctions)),
+	}
+	for k, v := range functionMap {
+		snapshot.Functions[k] = v
+	}
+	for k, deletedAt := range deletedFunctions {
+		snapshot.Deleted[k] = deletedAt
+	}
+	return snapshot, nil
+}
+
+// restoreFunctionCache loads the snapshot of a checkpoint into the function cache
+func restoreFunctionCache(data []byte) error {
+	snapshot := functionSnapshot{}
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return err
+	}
+	fnMpLock.Lock()
+	defer fnMpLock.Unlock()
+	for k, v := range snapshot.Functions {
+		if v.Instances == nil {
+			v.Instances = make(map[int]InstanceStatus)
+		}
+		functionMap[k] = v
+		touchFunction(k)
+	}
+	for k, deletedAt := range snapshot.Deleted {
+		if _, ok := functionMap[k]; ok {
+			deletedFunctions[k] = deletedAt
+		}
+	}
+	evictFunctions()
+	logger.Infof("function cache restored %d functions from the checkpoint", len(snapshot.Functions))
+	return nil
+}
@@ -0,0 +1,192 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package logclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/datastax/burnell/src/pb"
+	"github.com/datastax/burnell/src/util"
+)
+
+// TopicLister lists the topics under a namespace
+type TopicLister func(tenant, namespace string) ([]string, error)
+
+var partitionSuffix = regexp.MustCompile(`-partition-\d+$`)
+
+// inputTopics returns the input topics by name and by regex pattern in the function source spec
+func inputTopics(source *pb.SourceSpec) ([]string, []string) {
+	topics, patterns := []string{}, []string{}
+	if source == nil {
+		return topics, patterns
+	}
+	for topic, spec := range source.GetInputSpecs() {
+		if spec.GetIsRegexPattern() {
+			patterns = append(patterns, topic)
+		} else {
+			topics = append(topics, topic)
+		}
+	}
+	for topic := range source.GetTopicsToSerDeClassName() {
+		if !util.StrContains(topics, topic) {
+			topics = append(topics, topic)
+		}
+	}
+	if p := source.GetTopicsPattern(); p != "" && !util.StrContains(patterns, p) {
+		patterns = append(patterns, p)
+	}
+	sort.Strings(topics)
+	sort.Strings(patterns)
+	return topics, patterns
+}
+
+// MatchTopics returns the topics fully matched by the regex pattern, partitions are reported as the partitioned topic
+func MatchTopics(pattern string, topics []string) ([]string, error) {
+	r, err := regexp.Compile("^(?:" + pattern + ")$")
+	if err != nil {
+		return nil, err
+	}
+	matched := []string{}
+	for _, v := range topics {
+		topic := partitionSuffix.ReplaceAllString(v, "")
+		if r.MatchString(topic) && !util.StrContains(matched, topic) {
+			matched = append(matched, topic)
+		}
+	}
+	sort.Strings(matched)
+	return matched, nil
+}
+
+// patternNamespace returns the tenant and namespace of a topic pattern such as persistent://tenant/namespace/topic-.*
+func patternNamespace(pattern string) (string, string, error) {
+	name := pattern
+	if i := strings.Index(name, "://"); i >= 0 {
+		name = name[i+3:]
+	}
+	parts := strings.Split(name, "/")
+	if len(parts) < 3 {
+		return "", "", fmt.Errorf("topic pattern %s is not under tenant/namespace", pattern)
+	}
+	return parts[0], parts[1], nil
+}
+
+// ResolveInputTopics resolves the regex input topics of all functions against the namespace topic list
+func ResolveInputTopics(lister TopicLister) {
+	fnMpLock.RLock()
+	keys := map[string][]string{}
+	for k, v := range functionMap {
+		if len(v.InputTopicRegex) > 0 {
+			keys[k] = v.InputTopicRegex
+		}
+	}
+	fnMpLock.RUnlock()
+
+	// cache the topic list per namespace in this run
+	namespaceTopics := map[string][]string{}
+	for key, patterns := range keys {
+		matched := []string{}
+		for _, pattern := range patterns {
+			tenant, namespace, err := patternNamespace(pattern)
+			if err != nil {
+				logger.Errorf("function %s %v", key, err)
+				continue
+			}
+			topics, ok := namespaceTopics[tenant+"/"+namespace]
+			if !ok {
+				if topics, err = lister(tenant, namespace); err != nil {
+					logger.Errorf("failed to list topics under %s/%s error %v", tenant, namespace, err)
+					continue
+				}
+				namespaceTopics[tenant+"/"+namespace] = topics
+			}
+			topics, err = MatchTopics(pattern, topics)
+			if err != nil {
+				logger.Errorf("function %s invalid input topic pattern %s error %v", key, pattern, err)
+				continue
+			}
+			matched = append(matched, topics...)
+		}
+		fnMpLock.Lock()
+		if f, ok := functionMap[key]; ok {
+			f.MatchedInputTopics = matched
+			functionMap[key] = f
+		}
+		fnMpLock.Unlock()
+	}
+}
+
+// AdminNamespaceTopics lists the persistent topics under a namespace from the broker admin REST API
+func AdminNamespaceTopics(tenant, namespace string) ([]string, error) {
+	requestURL := util.SingleJoinSlash(util.Config.BrokerProxyURL, "/admin/v2/persistent/"+tenant+"/"+namespace)
+	newRequest, err := http.NewRequest(http.MethodGet, requestURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	newRequest.Header.Add("X-Request", "burnell-functions-cache")
+	newRequest.Header.Add("Authorization", "Bearer "+util.PulsarAuthToken())
+
+	client := &http.Client{
+		CheckRedirect: util.PreserveHeaderForRedirect,
+		Timeout:       30 * time.Second,
+	}
+	response, err := client.Do(newRequest)
+	if response != nil {
+		defer response.Body.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s failure status code %d", requestURL, response.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	topics := []string{}
+	if err := json.Unmarshal(body, &topics); err != nil {
+		return nil, err
+	}
+	return topics, nil
+}
+
+// InputTopicResolver periodically resolves the regex input topics of functions
+func InputTopicResolver() {
+	interval := time.Duration(util.GetEnvInt("InputTopicResolveIntervalSeconds", 300)) * time.Second
+	go func() {
+		ticker := time.NewTicker(interval)
+		for {
+			select {
+			case <-ticker.C:
+				ResolveInputTopics(AdminNamespaceTopics)
+			}
+		}
+	}()
+}
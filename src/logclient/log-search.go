@@ -0,0 +1,165 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package logclient
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/datastax/burnell/src/util"
+)
+
+// LogReader reads a function instance log until the context is done, it is GetFunctionLog except in tests
+type LogReader func(ctx context.Context, functionName, workerID string, instanceID int, rd FunctionLogRequest) (FunctionLogResponse, error)
+
+// LogSearchRequest is a tenant wide function log search
+type LogSearchRequest struct {
+	// Query is the case insensitive sub string to match a log line
+	Query string
+	// Since is how far back the matched lines are kept, by the line timestamp
+	Since time.Duration
+	// Bytes is the tail of each instance log to search
+	Bytes int64
+	// Concurrency is the number of instance logs read in parallel
+	Concurrency int
+}
+
+// LogMatch is a matched log line of a function instance
+type LogMatch struct {
+	Timestamp time.Time `json:"timestamp"`
+	Namespace string    `json:"namespace"`
+	Function  string    `json:"function"`
+	Instance  int       `json:"instance"`
+	Line      string    `json:"line"`
+}
+
+// the timestamp layouts of the java instance log4j patterns and the python instance,
+// the time only layout is on the current day
+var logTimeLayouts = []string{
+	"[2006-01-02 15:04:05 -0700]",
+	"2006-01-02T15:04:05.000-0700",
+	"2006-01-02T15:04:05.000Z07:00",
+	"2006-01-02 15:04:05.000",
+	"2006-01-02T15:04:05",
+	"15:04:05.000",
+}
+
+// parseLogTime parses the leading timestamp of a log line
+func parseLogTime(line string, now time.Time) (time.Time, bool) {
+	for _, layout := range logTimeLayouts {
+		if len(line) < len(layout) {
+			continue
+		}
+		t, err := time.Parse(layout, line[:len(layout)])
+		if err != nil {
+			continue
+		}
+		if t.Year() == 0 {
+			t = time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+			if t.After(now) {
+				t = t.AddDate(0, 0, -1)
+			}
+		}
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+// matchLogs returns the lines containing the query, a line without timestamp such as a stack trace
+// takes the timestamp of the line before it
+func matchLogs(fn FunctionType, instance int, logs string, req LogSearchRequest, now time.Time) []LogMatch {
+	query := strings.ToLower(req.Query)
+	cutoff := now.Add(-req.Since)
+	matches := []LogMatch{}
+	var ts time.Time
+	for _, line := range strings.Split(logs, "\n") {
+		if t, ok := parseLogTime(line, now); ok {
+			ts = t
+		}
+		if line == "" || !strings.Contains(strings.ToLower(line), query) {
+			continue
+		}
+		if req.Since > 0 && !ts.IsZero() && ts.Before(cutoff) {
+			continue
+		}
+		matches = append(matches, LogMatch{
+			Timestamp: ts,
+			Namespace: fn.Namespace,
+			Function:  fn.FunctionName,
+			Instance:  instance,
+			Line:      line,
+		})
+	}
+	return matches
+}
+
+// SearchTenantLogs fans out to all the tenant function instances with bounded concurrency and
+// returns the matched lines sorted by timestamp. A failed instance does not fail the search.
+func SearchTenantLogs(ctx context.Context, tenant string, req LogSearchRequest, reader LogReader) ([]LogMatch, []error) {
+	type instanceKey struct {
+		fn       FunctionType
+		instance int
+	}
+	instances := []instanceKey{}
+	for _, fn := range TenantFunctions(tenant) {
+		parallism := int(fn.Parallism)
+		if parallism < 1 {
+			parallism = 1
+		}
+		for i := 0; i < parallism; i++ {
+			instances = append(instances, instanceKey{fn, i})
+		}
+	}
+
+	concurrency := req.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	now := time.Now().UTC()
+	pool := util.NewWorkerPool(util.WorkerPoolConfig{Name: "log-search", Concurrency: concurrency})
+	results := make([][]LogMatch, len(instances))
+	taskErrs := pool.Run(ctx, len(instances), func(ctx context.Context, i int) error {
+		k := instances[i]
+		res, err := reader(ctx, k.fn.Tenant+k.fn.Namespace+k.fn.FunctionName, "", k.instance, FunctionLogRequest{Bytes: req.Bytes})
+		if err != nil {
+			return fmt.Errorf("%s/%s instance %d: %v", k.fn.Namespace, k.fn.FunctionName, k.instance, err)
+		}
+		results[i] = matchLogs(k.fn, k.instance, res.Logs, req, now)
+		return nil
+	})
+	matches := []LogMatch{}
+	errs := []error{}
+	for i := range instances {
+		if taskErrs[i] != nil {
+			errs = append(errs, taskErrs[i])
+		}
+		matches = append(matches, results[i]...)
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Timestamp.Before(matches[j].Timestamp)
+	})
+	return matches, errs
+}
@@ -28,6 +28,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -73,6 +74,8 @@ type InstanceStatus struct {
 	LastQueryTime    time.Time `json:"lastQueryTime"`
 	WorkerID         string    `json:"workerId"`
 	MetadataWorkerID string    `json:"metadataWorkerId"`
+	// AssignmentWorkerID is the worker assigned in the functions assignment topic
+	AssignmentWorkerID string `json:"assignmentWorkerId"`
 }
 
 // FunctionType is the object encapsulates all the function attributes
@@ -83,11 +86,38 @@ type FunctionType struct {
 	Component    string                 `json:"component"`
 	Instances    map[int]InstanceStatus `json:"instances"`
 	Parallism    int32                  `json:"parallism"`
+	// InputTopics are the input topics specified by name
+	InputTopics []string `json:"inputTopics,omitempty"`
+	// InputTopicRegex are the input topics specified by regex pattern
+	InputTopicRegex []string `json:"inputTopicRegex,omitempty"`
+	// MatchedInputTopics are the topics currently matched by InputTopicRegex
+	MatchedInputTopics []string `json:"matchedInputTopics,omitempty"`
+	// Extras are the function details added by newer Pulsar versions than the generated protobuf code
+	Extras map[string]interface{} `json:"extras,omitempty"`
+	// Runtime is the function runtime such as JAVA, PYTHON, and GO
+	Runtime string `json:"runtime"`
+	// Resources are the resources requested per instance
+	Resources FunctionResources `json:"resources"`
+	// SecretNames are the secret names only, the secret values are redacted
+	SecretNames []string `json:"secretNames,omitempty"`
+}
+
+// FunctionResources is the CPU, RAM in bytes and disk in bytes requested
+type FunctionResources struct {
+	CPU  float64 `json:"cpu"`
+	RAM  int64   `json:"ram"`
+	Disk int64   `json:"disk"`
 }
 
 // the signal to track if the liveness of the reader process
 type liveSignal struct{}
 
+// the function metadata topic of the function workers
+const functionMetadataTopic = "persistent://public/functions/metadata"
+
+// functionCursor checkpoints the function metadata reader position with the function cache to resume from on restart
+var functionCursor *util.ReaderCursor
+
 // functionMap stores FunctionType object and the key is tenant+namespace+function name
 var functionMap = make(map[string]FunctionType)
 var fnMpLock = sync.RWMutex{}
@@ -97,6 +127,9 @@ func ReadFunctionMap(key string) (FunctionType, bool) {
 	fnMpLock.RLock()
 	defer fnMpLock.RUnlock()
 	f, ok := functionMap[key]
+	if ok {
+		touchFunction(key)
+	}
 	return f, ok
 }
 
@@ -106,6 +139,24 @@ func WriteFunctionMapIfNotExist(key string, f FunctionType) {
 	defer fnMpLock.Unlock()
 	if _, ok := functionMap[key]; !ok {
 		functionMap[key] = f
+		touchFunction(key)
+		evictFunctions()
+	}
+}
+
+// updateFunctionDetails refreshes the details of an existing function from the latest metadata since the function can be updated
+func updateFunctionDetails(key string, latest FunctionType) {
+	fnMpLock.Lock()
+	defer fnMpLock.Unlock()
+	if f, ok := functionMap[key]; ok {
+		f.InputTopics = latest.InputTopics
+		f.InputTopicRegex = latest.InputTopicRegex
+		f.Extras = latest.Extras
+		f.Runtime = latest.Runtime
+		f.Resources = latest.Resources
+		f.SecretNames = latest.SecretNames
+		f.Parallism = latest.Parallism
+		functionMap[key] = f
 	}
 }
 
@@ -122,6 +173,7 @@ func UpdateWorkerIDInFunctionMap(key, workerID string, instanceID int, running b
 	fnMpLock.Lock()
 	defer fnMpLock.Unlock()
 	if f, ok := functionMap[key]; ok {
+		status.AssignmentWorkerID = f.Instances[instanceID].AssignmentWorkerID
 		f.Instances[instanceID] = status
 		functionMap[key] = f
 	}
@@ -132,7 +184,7 @@ func DeleteFunctionMap(key string) bool {
 	fnMpLock.Lock()
 	defer fnMpLock.Unlock()
 	if _, ok := functionMap[key]; ok {
-		delete(functionMap, key)
+		removeFunction(key)
 		return ok
 	}
 	return false
@@ -150,17 +202,21 @@ func TenantFunctionCount(tenant string) int {
 	return counter
 }
 
-// ReaderLoop continuously reads messages from function metadata topic
-func ReaderLoop(sig chan *liveSignal) {
-	defer func(s chan *liveSignal) {
-		logger.Errorf("function listener terminated")
-		s <- &liveSignal{}
-	}(sig)
+// TenantFunctions returns all functions under the tenant
+func TenantFunctions(tenant string) []FunctionType {
+	fnMpLock.RLock()
+	defer fnMpLock.RUnlock()
+	functions := []FunctionType{}
+	for _, v := range functionMap {
+		if v.Tenant == tenant {
+			functions = append(functions, v)
+		}
+	}
+	return functions
+}
 
-	// Configuration variables pertaining to this reader
-	tokenStr := util.GetConfig().PulsarToken
+func newPulsarClient() (pulsar.Client, error) {
 	uri := util.GetConfig().PulsarURL
-	topicName := "persistent://public/functions/metadata"
 
 	clientOpt := pulsar.ClientOptions{
 		URL:               uri,
@@ -168,18 +224,66 @@ func ReaderLoop(sig chan *liveSignal) {
 		ConnectionTimeout: 30 * time.Second,
 	}
 
-	if tokenStr != "" {
-		clientOpt.Authentication = pulsar.NewAuthenticationToken(tokenStr)
+	if util.HasPulsarToken() {
+		clientOpt.Authentication = pulsar.NewAuthenticationTokenFromSupplier(util.PulsarTokenSupplier)
 	}
 
 	if strings.HasPrefix(uri, "pulsar+ssl://") {
 		trustStore := util.AssignString(util.GetConfig().TrustStore, "/etc/ssl/certs/ca-bundle.crt")
 		clientOpt.TLSTrustCertsFilePath = trustStore
 	}
+	return pulsar.NewClient(clientOpt)
+}
+
+// secretNames returns the sorted names in the secrets map json, the values are never retained
+func secretNames(key, secretsMap string) []string {
+	if secretsMap == "" {
+		return nil
+	}
+	secrets := map[string]json.RawMessage{}
+	if err := json.Unmarshal([]byte(secretsMap), &secrets); err != nil {
+		logger.Errorf("function %s failed to parse secrets map", key)
+		return nil
+	}
+	names := make([]string, 0, len(secrets))
+	for k := range secrets {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// TenantFunctionResources returns all functions under the tenant and the total resources requested by all instances
+func TenantFunctionResources(tenant string) (FunctionResources, []FunctionType) {
+	total := FunctionResources{}
+	functions := TenantFunctions(tenant)
+	for _, f := range functions {
+		instances := f.Parallism
+		if instances < 1 {
+			instances = 1
+		}
+		total.CPU += f.Resources.CPU * float64(instances)
+		total.RAM += f.Resources.RAM * int64(instances)
+		total.Disk += f.Resources.Disk * int64(instances)
+	}
+	return total, functions
+}
+
+// ReaderLoop continuously reads messages from function metadata topic
+func ReaderLoop(sig chan *liveSignal) {
+	defer func(s chan *liveSignal) {
+		logger.Errorf("function listener terminated")
+		s <- &liveSignal{}
+	}(sig)
+
+	// Configuration variables pertaining to this reader
+	topicName := functionMetadataTopic
+
 	// Pulsar client
-	client, err := pulsar.NewClient(clientOpt)
+	client, err := newPulsarClient()
 	if err != nil {
 		logger.Errorf("pulsar.NewClient %v", err)
+		util.PulsarClientFailed(util.FunctionMetadataClient, topicName, err)
 		return
 	}
 
@@ -187,32 +291,44 @@ func ReaderLoop(sig chan *liveSignal) {
 
 	reader, err := client.CreateReader(pulsar.ReaderOptions{
 		Topic:          topicName,
-		StartMessageID: pulsar.EarliestMessageID(),
+		StartMessageID: functionCursor.Start(restoreFunctionCache),
 	})
 
 	if err != nil {
 		logger.Errorf("pulsar.CreateReader %v", err)
+		util.PulsarClientFailed(util.FunctionMetadataClient, topicName, err)
 		return
 	}
 
 	defer reader.Close()
+	util.PulsarClientConnected(util.FunctionMetadataClient, topicName)
 
 	ctx := context.Background()
 
 	// infinite loop to receive messages
 	for {
-		msg, err := reader.Next(ctx)
+		msg, err := functionCursor.Next(ctx, reader)
 		if err != nil {
 			logger.Errorf("pulsar.reader.Next %v", err)
+			util.PulsarClientFailed(util.FunctionMetadataClient, topicName, err)
 			return
 		}
+		util.PulsarClientMessage(util.FunctionMetadataClient)
 		sr := pb.ServiceRequest{}
 		proto.Unmarshal(msg.Payload(), &sr)
-		ParseServiceRequest(sr.GetFunctionMetaData())
+		ApplyServiceRequest(&sr, time.Now())
+		functionCursor.Processed(msg.ID())
 		// logger.Infof(" the total number of functions %d", len(functionMap))
 	}
 }
 
+// ApplyServiceRequest caches the function of a service request, and marks the function deleted at the time by a delete request
+func ApplyServiceRequest(sr *pb.ServiceRequest, t time.Time) {
+	ParseServiceRequest(sr.GetFunctionMetaData())
+	fd := sr.GetFunctionMetaData().GetFunctionDetails()
+	setFunctionDeleted(fd.GetTenant()+fd.GetNamespace()+fd.GetName(), sr.GetServiceRequestType() == pb.ServiceRequest_DELETE, t)
+}
+
 // ParseServiceRequest build a Function object based on Pulsar function metadata message
 func ParseServiceRequest(sr *pb.FunctionMetaData) {
 	fd := sr.FunctionDetails
@@ -226,11 +342,28 @@ func ParseServiceRequest(sr *pb.FunctionMetaData) {
 		Component:    GetComponentType(fd.ComponentType),
 		Instances:    make(map[int]InstanceStatus),
 	}
+	f.InputTopics, f.InputTopicRegex = inputTopics(fd.GetSource())
+	f.Runtime = fd.GetRuntime().String()
+	f.Resources = FunctionResources{
+		CPU:  fd.GetResources().GetCpu(),
+		RAM:  fd.GetResources().GetRam(),
+		Disk: fd.GetResources().GetDisk(),
+	}
+	f.SecretNames = secretNames(key, fd.GetSecretsMap())
+	extras, err := pb.FunctionDetailsExtras(fd)
+	if err != nil {
+		logger.Errorf("function %s failed to decode newer function details %v", key, err)
+	}
+	if len(extras) > 0 {
+		f.Extras = extras
+	}
 	WriteFunctionMapIfNotExist(key, f)
+	updateFunctionDetails(key, f)
 }
 
 // FunctionTopicWatchDog is a watch dog for the function topic reader process
 func FunctionTopicWatchDog() {
+	functionCursor = util.NewReaderCursor(util.FunctionMetadataCursor, functionMetadataTopic, functionCacheSnapshot)
 
 	go func() {
 		s := make(chan *liveSignal)
@@ -246,7 +379,8 @@ func FunctionTopicWatchDog() {
 
 // GetFunctionLog gets the logs from the function worker process
 // Since the function may get reassigned after restart, we will establish the connection every time the log request is being made.
-func GetFunctionLog(functionName, workerID string, instanceID int, rd FunctionLogRequest) (FunctionLogResponse, error) {
+// The dial and the read are abandoned once the request context is done.
+func GetFunctionLog(ctx context.Context, functionName, workerID string, instanceID int, rd FunctionLogRequest) (FunctionLogResponse, error) {
 	var fn FunctionType
 	if workerID == "" {
 		var err error
@@ -261,7 +395,9 @@ func GetFunctionLog(functionName, workerID string, instanceID int, rd FunctionLo
 	address := fqdn + util.AssignString(util.GetConfig().LogServerPort, logstream.DefaultLogServerPort)
 	// address = logstream.DefaultLogServerPort
 	logger.Infof("connect to function worker address %s", address)
-	conn, err := grpc.Dial(address, grpc.WithInsecure(), grpc.WithBlock(), grpc.WithTimeout(600*time.Second))
+	dialCtx, cancelDial := context.WithTimeout(ctx, 600*time.Second)
+	defer cancelDial()
+	conn, err := grpc.DialContext(dialCtx, address, grpc.WithInsecure(), grpc.WithBlock())
 	if err != nil {
 		logger.Errorf("grpc.Dial to log server error %v", err)
 		return FunctionLogResponse{}, err
@@ -269,7 +405,7 @@ func GetFunctionLog(functionName, workerID string, instanceID int, rd FunctionLo
 	defer conn.Close()
 	c := logstream.NewLogStreamClient(conn)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	readCtx, cancel := context.WithTimeout(ctx, 1*time.Second)
 	defer cancel()
 
 	direction := requestDirection(rd)
@@ -281,7 +417,7 @@ func GetFunctionLog(functionName, workerID string, instanceID int, rd FunctionLo
 		BackwardIndex: rd.BackwardPosition,
 	}
 	logger.Debugf("making a remote call %v", req)
-	res, err := c.Read(ctx, req)
+	res, err := c.Read(readCtx, req)
 	if err != nil {
 		logger.Errorf("grcp call to log server failed : %v", err)
 		return FunctionLogResponse{}, err
@@ -349,7 +485,7 @@ func GetFunctionStatus(fn FunctionType) (FuncStatus, error) {
 		return FuncStatus{}, err
 	}
 	newRequest.Header.Add("X-Request", "burnell-functions-cache")
-	newRequest.Header.Add("Authorization", "Bearer "+util.Config.PulsarToken)
+	newRequest.Header.Add("Authorization", "Bearer "+util.PulsarAuthToken())
 
 	client := &http.Client{
 		CheckRedirect: util.PreserveHeaderForRedirect,
@@ -393,6 +529,10 @@ func GetFunctionWorkerID(functionName string, instanceID int) (FunctionType, str
 	}
 	instanceStatus, ok := function.Instances[instanceID]
 
+	// the assignment topic is up to date after rebalancing
+	if ok && instanceStatus.AssignmentWorkerID != "" {
+		return function, instanceStatus.AssignmentWorkerID, nil
+	}
 	if ok && time.Since(instanceStatus.LastQueryTime) < queryTimeout {
 		return function, instanceStatus.WorkerID, nil
 	}
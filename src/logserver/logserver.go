@@ -170,14 +170,14 @@ func (s *server) Read(ctx context.Context, in *pb.ReadRequest) (*pb.LogLines, er
 			return nil, err
 		}
 		// fmt.Printf("backwardPos %d forwardPos %d\ntxt:%s\n", newBackwardPos, r.forwardPos, txt)
-		return &pb.LogLines{Logs: txt, ForwardIndex: r.forwardPos, BackwardIndex: newBackwardPos}, nil
+		return &pb.LogLines{Logs: pb.LogMasker().Mask(txt), ForwardIndex: r.forwardPos, BackwardIndex: newBackwardPos}, nil
 	}
 	txt, newForwardPos, err := r.ReadForward(in.GetBytes())
 	if err != nil {
 		return nil, err
 	}
 
-	return &pb.LogLines{Logs: txt, ForwardIndex: newForwardPos, BackwardIndex: r.backwardPos}, nil
+	return &pb.LogLines{Logs: pb.LogMasker().Mask(txt), ForwardIndex: newForwardPos, BackwardIndex: r.backwardPos}, nil
 }
 
 func main() {
@@ -188,6 +188,13 @@ func main() {
 		log.Fatalln(err)
 	}
 
+	if err := pb.InitLogMasking(); err != nil {
+		log.Fatalln(err)
+	}
+	if err := pb.StartLogShipping(); err != nil {
+		log.Fatalln(err)
+	}
+
 	srv := grpc.NewServer()
 	pb.RegisterLogStreamServer(srv, &server{})
 	reflection.Register(srv)
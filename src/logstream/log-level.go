@@ -0,0 +1,137 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package logstream
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/datastax/burnell/src/util"
+)
+
+// LogLevel is the level of a function log line, from the lowest
+type LogLevel int
+
+// the function log levels, AllLevels keeps every line
+const (
+	AllLevels LogLevel = iota
+	TraceLevel
+	DebugLevel
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+	FatalLevel
+)
+
+var logLevelNames = map[string]LogLevel{
+	"all":     AllLevels,
+	"trace":   TraceLevel,
+	"debug":   DebugLevel,
+	"info":    InfoLevel,
+	"warn":    WarnLevel,
+	"warning": WarnLevel,
+	"error":   ErrorLevel,
+	"fatal":   FatalLevel,
+}
+
+// String returns the lower case name of the level
+func (l LogLevel) String() string {
+	switch l {
+	case TraceLevel:
+		return "trace"
+	case DebugLevel:
+		return "debug"
+	case InfoLevel:
+		return "info"
+	case WarnLevel:
+		return "warn"
+	case ErrorLevel:
+		return "error"
+	case FatalLevel:
+		return "fatal"
+	default:
+		return "all"
+	}
+}
+
+// ParseLogLevel parses a case insensitive level name, empty is all levels
+func ParseLogLevel(name string) (LogLevel, error) {
+	if name == "" {
+		return AllLevels, nil
+	}
+	if l, ok := logLevelNames[strings.ToLower(name)]; ok {
+		return l, nil
+	}
+	return AllLevels, fmt.Errorf("unknown log level %s, must be all, trace, debug, info, warn, error or fatal", name)
+}
+
+// the level of a log4j or a Python logging line such as 12:00:00.000 [main] WARN org.example.Function - message
+var logLinePattern = regexp.MustCompile(`\b(TRACE|DEBUG|INFO|WARN|WARNING|ERROR|FATAL|CRITICAL)\b`)
+
+// FilterLogLevel keeps the log lines at or above the level. A line without a level, such as a stack trace,
+// follows the level of the previous line, and is dropped at the start of the logs.
+func FilterLogLevel(logs string, min LogLevel) string {
+	if min == AllLevels || logs == "" {
+		return logs
+	}
+	var b strings.Builder
+	keep := false
+	for _, line := range strings.SplitAfter(logs, "\n") {
+		if m := logLinePattern.FindString(line); m != "" {
+			level := logLevelNames[strings.ToLower(m)]
+			if m == "CRITICAL" {
+				level = FatalLevel
+			}
+			keep = level >= min
+		}
+		if keep {
+			b.WriteString(line)
+		}
+	}
+	return b.String()
+}
+
+var (
+	clientLogLevel     = WarnLevel
+	clientLogLevelLock = sync.RWMutex{}
+)
+
+// InitClientLogLevel sets the ClientFunctionLogLevel in the configuration, warn if empty
+func InitClientLogLevel() error {
+	level, err := ParseLogLevel(util.AssignString(util.GetConfig().ClientFunctionLogLevel, WarnLevel.String()))
+	if err != nil {
+		return err
+	}
+	clientLogLevelLock.Lock()
+	defer clientLogLevelLock.Unlock()
+	clientLogLevel = level
+	return nil
+}
+
+// ClientLogLevel returns the lowest function log level returned to the client subjects
+func ClientLogLevel() LogLevel {
+	clientLogLevelLock.RLock()
+	defer clientLogLevelLock.RUnlock()
+	return clientLogLevel
+}
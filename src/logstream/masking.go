@@ -0,0 +1,168 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package logstream
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/datastax/burnell/src/util"
+)
+
+// DefaultMask replaces the masked data unless a rule has its own replacement
+const DefaultMask = "****"
+
+type maskRule struct {
+	name        string
+	re          *regexp.Regexp
+	replacement string
+	// valid verifies a match before it is masked, all matches are masked if nil
+	valid func(string) bool
+}
+
+// the built-in masking rules in the order they apply, the secret rules before the email so a token is masked whole
+var builtinMaskRules = []maskRule{
+	{name: "jwt", re: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`), replacement: DefaultMask},
+	{name: "bearer-token", re: regexp.MustCompile(`(?i)\b(bearer\s+)[A-Za-z0-9._~+/-]+=*`), replacement: "${1}" + DefaultMask},
+	{name: "secret", re: regexp.MustCompile(`(?i)\b(password|passwd|secret|token|api[_-]?key)(\s*[=:]\s*)[^\s,;]+`), replacement: "${1}${2}" + DefaultMask},
+	{name: "credit-card", re: regexp.MustCompile(`\b[2-6]\d(?:[ -]?\d){11,17}\b`), replacement: DefaultMask, valid: luhnValid},
+	{name: "email", re: regexp.MustCompile(`\b[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}\b`), replacement: DefaultMask},
+}
+
+// luhnValid verifies the digits in the card number pass the Luhn checksum
+func luhnValid(number string) bool {
+	sum, double := 0, false
+	for i := len(number) - 1; i >= 0; i-- {
+		c := number[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if double {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// Masker masks the sensitive data in the function logs before they are returned to the tenants
+type Masker struct {
+	rules []maskRule
+}
+
+// NewMasker compiles the built-in rules in the comma separated builtins, all if empty or none if it is none,
+// and the additional rules
+func NewMasker(builtins string, rules []util.LogMaskRule) (*Masker, error) {
+	m := &Masker{}
+	switch strings.TrimSpace(builtins) {
+	case "":
+		m.rules = append(m.rules, builtinMaskRules...)
+	case "none":
+	default:
+		for _, name := range strings.Split(builtins, ",") {
+			name = strings.TrimSpace(name)
+			found := false
+			for _, r := range builtinMaskRules {
+				if r.name == name {
+					m.rules = append(m.rules, r)
+					found = true
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("unknown built-in log masking rule %s", name)
+			}
+		}
+	}
+	return m.With(rules)
+}
+
+// With returns a new masker with the additional rules applied after the rules of m
+func (m *Masker) With(rules []util.LogMaskRule) (*Masker, error) {
+	masker := &Masker{rules: append([]maskRule{}, m.rules...)}
+	for _, r := range rules {
+		if r.Name == "" {
+			return nil, fmt.Errorf("log masking rule requires a name")
+		}
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("log masking rule %s: %v", r.Name, err)
+		}
+		masker.rules = append(masker.rules, maskRule{name: r.Name, re: re, replacement: util.AssignString(r.Replacement, DefaultMask)})
+	}
+	return masker, nil
+}
+
+// Rules returns the rule names in the order they apply
+func (m *Masker) Rules() []string {
+	names := make([]string, 0, len(m.rules))
+	for _, r := range m.rules {
+		names = append(names, r.name)
+	}
+	return names
+}
+
+// Mask replaces the matches of all rules in the logs
+func (m *Masker) Mask(logs string) string {
+	for _, r := range m.rules {
+		if r.valid == nil {
+			logs = r.re.ReplaceAllString(logs, r.replacement)
+			continue
+		}
+		logs = r.re.ReplaceAllStringFunc(logs, func(match string) string {
+			if r.valid(match) {
+				return r.replacement
+			}
+			return match
+		})
+	}
+	return logs
+}
+
+var (
+	logMasker, _  = NewMasker("", nil)
+	logMaskerLock = sync.RWMutex{}
+)
+
+// InitLogMasking sets the masker of the LogMaskingBuiltins and LogMaskingRules in the configuration
+func InitLogMasking() error {
+	masker, err := NewMasker(util.GetConfig().LogMaskingBuiltins, util.GetConfig().LogMaskingRules)
+	if err != nil {
+		return err
+	}
+	logMaskerLock.Lock()
+	defer logMaskerLock.Unlock()
+	logMasker = masker
+	return nil
+}
+
+// LogMasker returns the masker of the global rules
+func LogMasker() *Masker {
+	logMaskerLock.RLock()
+	defer logMaskerLock.RUnlock()
+	return logMasker
+}
@@ -0,0 +1,349 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package logstream
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/apex/log"
+	"github.com/datastax/burnell/src/util"
+)
+
+var shipLog = log.WithFields(log.Fields{"app": "log-shipper"})
+
+// LogBatch is a batch of complete log lines of a function instance
+type LogBatch struct {
+	Tenant    string
+	Namespace string
+	Function  string
+	Instance  string
+	// Offset is the byte position of the batch in the log file
+	Offset int64
+	Data   []byte
+}
+
+// LogSink ships the log batches to a durable store
+type LogSink interface {
+	Ship(batch LogBatch) error
+	Close()
+}
+
+// LogShipper tails the function logs under the log path prefix and ships the new lines to a sink.
+// The shipped offsets are kept in a state file so that a restarted agent resumes where it left.
+type LogShipper struct {
+	root       string
+	sink       LogSink
+	batchBytes int64
+	stateFile  string
+	offsets    map[string]int64
+}
+
+// NewLogShipper creates a log shipper, an empty state file does not persist the offsets
+func NewLogShipper(root string, sink LogSink, batchBytes int64, stateFile string) *LogShipper {
+	s := &LogShipper{
+		root:       root,
+		sink:       sink,
+		batchBytes: batchBytes,
+		stateFile:  stateFile,
+		offsets:    make(map[string]int64),
+	}
+	if stateFile != "" {
+		if data, err := ioutil.ReadFile(stateFile); err == nil {
+			if err := json.Unmarshal(data, &s.offsets); err != nil {
+				shipLog.Errorf("ignore corrupted state file %s error %v", stateFile, err)
+			}
+		}
+	}
+	return s
+}
+
+// Offset returns the shipped offset of a log file
+func (s *LogShipper) Offset(file string) int64 {
+	return s.offsets[file]
+}
+
+// ShipOnce ships the new complete lines of all the function instance logs,
+// a file failed to ship is retried from the same offset on the next run
+func (s *LogShipper) ShipOnce() error {
+	files, err := filepath.Glob(filepath.Join(s.root, "*", "*", "*", "*.log"))
+	if err != nil {
+		return err
+	}
+	var lastErr error
+	for _, file := range files {
+		if err := s.shipFile(file); err != nil {
+			shipLog.Errorf("ship log file %s error %v", file, err)
+			lastErr = err
+		}
+	}
+	if err := s.saveState(); err != nil {
+		shipLog.Errorf("save state file %s error %v", s.stateFile, err)
+	}
+	return lastErr
+}
+
+func (s *LogShipper) shipFile(file string) error {
+	rel, err := filepath.Rel(s.root, file)
+	if err != nil {
+		return err
+	}
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	name := strings.TrimSuffix(parts[3], ".log")
+	instance := name[strings.LastIndex(name, "-")+1:]
+
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	offset := s.offsets[file]
+	if info.Size() < offset {
+		// the log file was rotated or truncated
+		offset = 0
+	}
+	for offset < info.Size() {
+		size := info.Size() - offset
+		if size > s.batchBytes {
+			size = s.batchBytes
+		}
+		buf := make([]byte, size)
+		n, err := f.ReadAt(buf, offset)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		// only ship complete lines, unless a single line is over the batch size
+		end := bytes.LastIndexByte(buf[:n], '\n') + 1
+		if end == 0 {
+			if int64(n) < s.batchBytes {
+				break
+			}
+			end = n
+		}
+		if err := s.sink.Ship(LogBatch{
+			Tenant:    parts[0],
+			Namespace: parts[1],
+			Function:  parts[2],
+			Instance:  instance,
+			Offset:    offset,
+			Data:      buf[:end],
+		}); err != nil {
+			return err
+		}
+		offset += int64(end)
+		s.offsets[file] = offset
+	}
+	s.offsets[file] = offset
+	return nil
+}
+
+func (s *LogShipper) saveState() error {
+	if s.stateFile == "" {
+		return nil
+	}
+	data, err := json.Marshal(s.offsets)
+	if err != nil {
+		return err
+	}
+	tmp := s.stateFile + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.stateFile)
+}
+
+// Run ships the logs at every interval
+func (s *LogShipper) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		s.ShipOnce()
+		<-ticker.C
+	}
+}
+
+// PulsarLogSink ships the log batches to a per tenant topic, the topic pattern has the {tenant} placeholder
+type PulsarLogSink struct {
+	client       pulsar.Client
+	topicPattern string
+	producers    map[string]pulsar.Producer
+	lock         sync.Mutex
+}
+
+// NewPulsarLogSink creates a Pulsar log sink, the token supplier is called on every connection so a refreshed token applies.
+// A nil token supplier is no authentication.
+func NewPulsarLogSink(pulsarURL string, token func() (string, error), trustStore, topicPattern string) (*PulsarLogSink, error) {
+	clientOpt := pulsar.ClientOptions{
+		URL:               pulsarURL,
+		OperationTimeout:  30 * time.Second,
+		ConnectionTimeout: 30 * time.Second,
+	}
+	if token != nil {
+		clientOpt.Authentication = pulsar.NewAuthenticationTokenFromSupplier(token)
+	}
+	if strings.HasPrefix(pulsarURL, "pulsar+ssl://") {
+		clientOpt.TLSTrustCertsFilePath = util.AssignString(trustStore, "/etc/ssl/certs/ca-bundle.crt")
+	}
+	client, err := pulsar.NewClient(clientOpt)
+	if err != nil {
+		return nil, err
+	}
+	return &PulsarLogSink{
+		client:       client,
+		topicPattern: topicPattern,
+		producers:    make(map[string]pulsar.Producer),
+	}, nil
+}
+
+// Ship sends the batch as one message with the function instance in the properties
+func (p *PulsarLogSink) Ship(batch LogBatch) error {
+	topic := strings.Replace(p.topicPattern, "{tenant}", batch.Tenant, -1)
+	p.lock.Lock()
+	producer, ok := p.producers[topic]
+	if !ok {
+		var err error
+		if producer, err = p.client.CreateProducer(pulsar.ProducerOptions{Topic: topic}); err != nil {
+			p.lock.Unlock()
+			return err
+		}
+		p.producers[topic] = producer
+	}
+	p.lock.Unlock()
+
+	_, err := producer.Send(context.Background(), &pulsar.ProducerMessage{
+		Payload: batch.Data,
+		Key:     batch.Namespace + "/" + batch.Function + "/" + batch.Instance,
+		Properties: map[string]string{
+			"namespace": batch.Namespace,
+			"function":  batch.Function,
+			"instance":  batch.Instance,
+			"offset":    strconv.FormatInt(batch.Offset, 10),
+		},
+	})
+	return err
+}
+
+// Close closes all the producers and the client
+func (p *PulsarLogSink) Close() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, producer := range p.producers {
+		producer.Close()
+	}
+	p.client.Close()
+}
+
+// S3LogSink ships each log batch as an object under {prefix}{tenant}/{namespace}/{function}/{instance}/,
+// signed with AWS signature version 4. The endpoint can be any S3 compatible store with path style access.
+type S3LogSink struct {
+	Endpoint     string
+	Region       string
+	Bucket       string
+	Prefix       string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	Client       *http.Client
+}
+
+// Ship puts the batch as an object named by the time and the file offset
+func (s *S3LogSink) Ship(batch LogBatch) error {
+	key := fmt.Sprintf("%s%s/%s/%s/%s/%d-%d.log", s.Prefix, batch.Tenant, batch.Namespace, batch.Function, batch.Instance,
+		time.Now().Unix(), batch.Offset)
+	store := util.ObjectStore{
+		Endpoint:     s.Endpoint,
+		Region:       s.Region,
+		Bucket:       s.Bucket,
+		AccessKey:    s.AccessKey,
+		SecretKey:    s.SecretKey,
+		SessionToken: s.SessionToken,
+		Client:       s.Client,
+	}
+	return store.PutObject(key, "text/plain", batch.Data)
+}
+
+// Close is a no-op for the http client
+func (s *S3LogSink) Close() {}
+
+// StartLogShipping starts the log shipping by the LogShippingMode environment variable, pulsar or s3.
+// It is a no-op if the mode is not set.
+func StartLogShipping() error {
+	var sink LogSink
+	switch mode := os.Getenv("LogShippingMode"); mode {
+	case "":
+		return nil
+	case "pulsar":
+		pulsarURL := util.AssignString(util.GetConfig().PulsarURL, os.Getenv("PulsarURL"))
+		if pulsarURL == "" {
+			return fmt.Errorf("log shipping to pulsar requires PulsarURL")
+		}
+		var token func() (string, error)
+		if util.HasPulsarToken() {
+			token = util.PulsarTokenSupplier
+		}
+		var err error
+		sink, err = NewPulsarLogSink(pulsarURL, token,
+			util.AssignString(util.GetConfig().TrustStore, os.Getenv("TrustStore")),
+			util.AssignString(os.Getenv("LogShippingTopic"), "persistent://{tenant}/default/function-logs"))
+		if err != nil {
+			return err
+		}
+	case "s3":
+		bucket := os.Getenv("LogShippingS3Bucket")
+		if bucket == "" {
+			return fmt.Errorf("log shipping to s3 requires LogShippingS3Bucket")
+		}
+		sink = &S3LogSink{
+			Endpoint:     os.Getenv("LogShippingS3Endpoint"),
+			Region:       util.AssignString(os.Getenv("LogShippingS3Region"), os.Getenv("AWS_REGION"), "us-east-1"),
+			Bucket:       bucket,
+			Prefix:       os.Getenv("LogShippingS3Prefix"),
+			AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		}
+	default:
+		return fmt.Errorf("unsupported LogShippingMode %s", mode)
+	}
+
+	shipper := NewLogShipper(FilePath, sink, int64(util.GetEnvInt("LogShippingBatchBytes", 1024*1024)),
+		util.AssignString(os.Getenv("LogShippingStateFile"), filepath.Join(FilePath, ".log-shipper-offsets.json")))
+	go shipper.Run(time.Duration(util.GetEnvInt("LogShippingIntervalSeconds", 10)) * time.Second)
+	return nil
+}
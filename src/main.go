@@ -32,13 +32,16 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/rs/cors"
 
+	"github.com/datastax/burnell/src/audit"
+	"github.com/datastax/burnell/src/k8s"
 	"github.com/datastax/burnell/src/logclient"
+	"github.com/datastax/burnell/src/logstream"
 	"github.com/datastax/burnell/src/metrics"
+	"github.com/datastax/burnell/src/notification"
 	"github.com/datastax/burnell/src/policy"
 	"github.com/datastax/burnell/src/route"
 	"github.com/datastax/burnell/src/util"
 	"github.com/datastax/burnell/src/workflow"
-	httptls "github.com/kafkaesque-io/pulsar-beam/src/util"
 )
 
 // commit sha which this binary is built against
@@ -54,7 +57,7 @@ func main() {
 		log.Fatalf("gops instrument error %v", err)
 	}
 
-	modePtr := flag.String("mode", util.Proxy, "process running mode: proxy(default), init, healer")
+	modePtr := flag.String("mode", util.Proxy, "process running mode: proxy(default), init, healer, stats, readonly")
 	version := flag.Bool("version", false, "version (commit sha)")
 	flag.Parse()
 	if *version {
@@ -77,30 +80,111 @@ func main() {
 	} else if util.IsHealer(&mode) {
 		router = route.HealerRouter()
 		workflow.ConfigKeysJWTs(false)
-	} else { //default proxy mode
+	} else if util.IsStatsOnly(&mode) {
+		// metrics and usage routes only for a replica dedicated to Prometheus scrapes and billing exports
 		route.Init()
 		metrics.Init()
+		if err := metrics.InitSeriesLimits(); err != nil {
+			log.Fatalf("federated series limits error %v", err)
+		}
+		if err := route.InitRateLimitExemptions(); err != nil {
+			log.Fatalf("rate limit exemptions error %v", err)
+		}
+		router = route.StatsRouter()
+		util.MemoryWatermarkMonitor()
+	} else { //default proxy mode, and the read-only replica with the mutating routes and workers disabled
+		route.Init()
+		metrics.Init()
+		if err := metrics.InitSeriesLimits(); err != nil {
+			log.Fatalf("federated series limits error %v", err)
+		}
+		if err := audit.InitExport(); err != nil {
+			log.Fatalf("audit export error %v", err)
+		}
+		if err := audit.InitArchive(); err != nil {
+			log.Fatalf("audit archive error %v", err)
+		}
+		if err := route.InitRateLimitExemptions(); err != nil {
+			log.Fatalf("rate limit exemptions error %v", err)
+		}
+		if err := route.InitProxyHooks(); err != nil {
+			log.Fatalf("proxy hooks error %v", err)
+		}
+		if err := route.InitAdminUpstreams(); err != nil {
+			log.Fatalf("admin upstreams error %v", err)
+		}
+		if err := route.InitClusterRouting(); err != nil {
+			log.Fatalf("cluster routing error %v", err)
+		}
+		if err := route.InitUpstreamCredentials(); err != nil {
+			log.Fatalf("upstream credentials error %v", err)
+		}
+		if err := route.InitAdminAPIVersions(); err != nil {
+			log.Fatalf("admin api versions error %v", err)
+		}
+		if err := route.InitQuotaEnforcement(); err != nil {
+			log.Fatalf("quota enforcement error %v", err)
+		}
+		if err := logstream.InitLogMasking(); err != nil {
+			log.Fatalf("log masking error %v", err)
+		}
+		if err := logstream.InitClientLogLevel(); err != nil {
+			log.Fatalf("client function log level error %v", err)
+		}
+		if err := util.InitReasons(); err != nil {
+			log.Fatalf("error reason messages error %v", err)
+		}
+		if err := policy.InitNamingPolicies(); err != nil {
+			log.Fatalf("naming policies error %v", err)
+		}
+		if err := policy.InitPlanChangeRules(); err != nil {
+			log.Fatalf("plan change rules error %v", err)
+		}
+		if err := notification.InitAlerts(); err != nil {
+			log.Fatalf("alert routes error %v", err)
+		}
 
 		router = route.NewRouter()
+		util.MemoryWatermarkMonitor()
 		if !util.IsStatsMode() {
 			log.Infof("a full proxy mode")
 			logclient.FunctionTopicWatchDog()
+			logclient.FunctionAssignmentWatchDog()
+			logclient.InputTopicResolver()
+			notification.Init()
 			policy.Initialize()
+			logclient.FunctionCacheCompactor(policy.TenantManager.IsDeletedTenant)
+			if util.IsReadOnlyMode() {
+				log.Warnf("read-only replica, the mutating routes and workers are disabled")
+			} else {
+				policy.BacklogQuotaMonitor(metrics.GetTopicBacklogs)
+				policy.SyntheticProber()
+				policy.UsageReportScheduler()
+				policy.ExpirationNoticeScheduler()
+				if err := k8s.StartTenantPlanController(&policy.TenantManager); err != nil {
+					log.Fatalf("tenantplan controller error %v", err)
+				}
+			}
 		}
 	}
 
 	c := cors.New(cors.Options{
-		AllowedOrigins:   []string{"http://localhost:3000", "http://localhost:8080"},
-		AllowCredentials: true,
-		AllowedHeaders:   []string{"Authorization", "PulsarTopicUrl"},
+		AllowOriginRequestFunc: route.CORSOriginFunc(router),
+		AllowCredentials:       true,
+		AllowedHeaders:         []string{"Authorization", "PulsarTopicUrl"},
 	})
 
-	handler := c.Handler(router)
+	// the hot routes skip the mux path matching under the Prometheus scrape storms
+	handler := c.Handler(route.NewFastPath(router, route.HotRoutes))
 
 	certFile := util.GetConfig().CertFile
 	keyFile := util.GetConfig().KeyFile
 	port := util.AssignString(config.PORT, "8080")
-	err := httptls.ListenAndServeTLS(":"+port, certFile, keyFile, handler)
+	serverConfig, err := util.LoadHTTPServerConfig(config)
+	if err != nil {
+		log.Fatalf("http server config error %v", err)
+	}
+	err = util.ListenAndServe(":"+port, certFile, keyFile, serverConfig, handler)
 	if err != nil {
 		log.Fatal(err.Error())
 	}
@@ -0,0 +1,221 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/datastax/burnell/src/notification"
+	"github.com/datastax/burnell/src/util"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// BytesInRate is the bytes in per usage build cycle
+	BytesInRate = "bytesIn"
+	// Backlog is the message backlog
+	Backlog = "backlog"
+)
+
+// UsageAnomaly is a tenant usage sample out of its historical pattern
+type UsageAnomaly struct {
+	Tenant     string    `json:"tenant"`
+	Metric     string    `json:"metric"`
+	Value      float64   `json:"value"`
+	Mean       float64   `json:"mean"`
+	StdDev     float64   `json:"stdDev"`
+	ZScore     float64   `json:"zScore"`
+	DetectedAt time.Time `json:"detectedAt"`
+}
+
+// AnomalyDetector detects anomaly over a rolling window of samples per tenant and metric.
+// A sample is anomalous when its z-score is over ZScore, or it jumps over the window mean by JumpPercent.
+// A zero ZScore or JumpPercent disables the check.
+// MinStdDev is the floor of the window standard deviation and of the mean in the jump check,
+// so that a spike over a flat or an idle window is still anomalous.
+type AnomalyDetector struct {
+	Window      int
+	MinSamples  int
+	ZScore      float64
+	JumpPercent float64
+	MinStdDev   float64
+
+	history map[string][]float64
+	lock    sync.Mutex
+}
+
+var (
+	usageAnomalyGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "burnell",
+		Subsystem: "usage",
+		Name:      "anomaly",
+		Help:      "Whether the tenant usage metric is anomalous in the last usage build, 1 is anomalous.",
+	}, []string{"tenant", "metric"})
+	usageAnomalyCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "burnell",
+		Subsystem: "usage",
+		Name:      "anomalies_total",
+		Help:      "The number of tenant usage anomalies detected.",
+	}, []string{"tenant", "metric"})
+
+	usageDetector = newUsageDetector()
+
+	// the last bytes in counter per tenant to compute the bytes in per cycle
+	lastBytesIn     = make(map[string]uint64)
+	lastBytesInLock = sync.Mutex{}
+)
+
+func init() {
+	prometheus.MustRegister(usageAnomalyGauge, usageAnomalyCounter)
+}
+
+func newUsageDetector() *AnomalyDetector {
+	d := NewAnomalyDetector(util.GetEnvInt("UsageAnomalyWindow", 12),
+		float64(util.GetEnvInt("UsageAnomalyZScore", 3)), float64(util.GetEnvInt("UsageAnomalyJumpPercent", 200)))
+	d.MinStdDev = float64(util.GetEnvInt("UsageAnomalyMinStdDev", 1))
+	return d
+}
+
+// NewAnomalyDetector creates an anomaly detector
+func NewAnomalyDetector(window int, zScore, jumpPercent float64) *AnomalyDetector {
+	if window < 2 {
+		window = 2
+	}
+	return &AnomalyDetector{
+		Window:      window,
+		MinSamples:  3,
+		ZScore:      zScore,
+		JumpPercent: jumpPercent,
+		MinStdDev:   1,
+		history:     make(map[string][]float64),
+	}
+}
+
+// Observe adds a sample to the window and returns the anomaly if the sample is anomalous, otherwise nil.
+// The sample is evaluated against the window before it is added.
+func (d *AnomalyDetector) Observe(tenant, metric string, value float64) *UsageAnomaly {
+	key := tenant + "/" + metric
+	d.lock.Lock()
+	samples := d.history[key]
+	d.history[key] = append(samples, value)
+	if len(d.history[key]) > d.Window {
+		d.history[key] = d.history[key][len(d.history[key])-d.Window:]
+	}
+	d.lock.Unlock()
+
+	if len(samples) < d.MinSamples {
+		return nil
+	}
+	mean, stdDev := meanStdDev(samples)
+	anomaly := &UsageAnomaly{
+		Tenant:     tenant,
+		Metric:     metric,
+		Value:      value,
+		Mean:       mean,
+		StdDev:     stdDev,
+		DetectedAt: time.Now(),
+	}
+	// a flat window has no deviation, the floor keeps the z-score of a spike finite and over the threshold
+	if deviation := math.Max(stdDev, d.MinStdDev); deviation > 0 {
+		anomaly.ZScore = (value - mean) / deviation
+	}
+	if d.ZScore > 0 && anomaly.ZScore > d.ZScore {
+		return anomaly
+	}
+	if d.JumpPercent > 0 && value > math.Max(mean, d.MinStdDev)*(1+d.JumpPercent/100) {
+		return anomaly
+	}
+	return nil
+}
+
+func meanStdDev(samples []float64) (float64, float64) {
+	sum := 0.0
+	for _, v := range samples {
+		sum += v
+	}
+	mean := sum / float64(len(samples))
+	variance := 0.0
+	for _, v := range samples {
+		variance += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(variance / float64(len(samples)))
+}
+
+// DetectUsageAnomalies evaluates the bytes in per cycle and backlog of every tenant in the usage db.
+// It updates the anomaly metrics, and alerts the configured webhooks.
+func DetectUsageAnomalies() []UsageAnomaly {
+	usages, err := GetTenantsUsage()
+	if err != nil {
+		logger.Errorf("failed to get tenants usage for anomaly detection error %v", err)
+		return nil
+	}
+	anomalies := []UsageAnomaly{}
+	for _, usage := range usages {
+		lastBytesInLock.Lock()
+		last, ok := lastBytesIn[usage.Name]
+		lastBytesIn[usage.Name] = usage.TotalBytesIn
+		lastBytesInLock.Unlock()
+
+		// skip the first sample and counter reset
+		if ok && usage.TotalBytesIn >= last {
+			anomalies = evaluateUsage(anomalies, usage.Name, BytesInRate, float64(usage.TotalBytesIn-last))
+		}
+		anomalies = evaluateUsage(anomalies, usage.Name, Backlog, float64(usage.MsgInBacklog))
+	}
+	return anomalies
+}
+
+func evaluateUsage(anomalies []UsageAnomaly, tenant, metric string, value float64) []UsageAnomaly {
+	anomaly := usageDetector.Observe(tenant, metric, value)
+	if anomaly == nil {
+		usageAnomalyGauge.WithLabelValues(tenant, metric).Set(0)
+		return anomalies
+	}
+	usageAnomalyGauge.WithLabelValues(tenant, metric).Set(1)
+	usageAnomalyCounter.WithLabelValues(tenant, metric).Inc()
+	recordAnomalyHistory(*anomaly)
+	logger.Warnf("tenant %s %s anomaly value %.0f mean %.0f zscore %.2f", tenant, metric, value, anomaly.Mean, anomaly.ZScore)
+	alertUsageAnomaly(*anomaly)
+	return append(anomalies, *anomaly)
+}
+
+func alertUsageAnomaly(anomaly UsageAnomaly) {
+	webhooks := []string{}
+	for _, v := range strings.Split(util.GetConfig().UsageAnomalyWebhooks, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			webhooks = append(webhooks, v)
+		}
+	}
+	if len(webhooks) == 0 {
+		return
+	}
+	go notification.Notifier.Notify(nil, webhooks, notification.Notice{
+		Tenant:  anomaly.Tenant,
+		Kind:    notification.UsageAnomaly,
+		Subject: fmt.Sprintf("tenant %s %s anomaly", anomaly.Tenant, anomaly.Metric),
+		Message: fmt.Sprintf("%s is %.0f against the mean %.0f with z-score %.2f", anomaly.Metric, anomaly.Value, anomaly.Mean, anomaly.ZScore),
+	})
+}
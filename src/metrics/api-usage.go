@@ -0,0 +1,119 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package metrics
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/datastax/burnell/src/util"
+)
+
+// DailyAPIUsage is the burnell API calls of a tenant in a UTC day, in total and per endpoint
+type DailyAPIUsage struct {
+	Date      string            `json:"date"`
+	Calls     uint64            `json:"calls"`
+	Endpoints map[string]uint64 `json:"endpoints"`
+}
+
+// TenantAPIUsage is the burnell API calls of a tenant within the days from and to, both inclusive
+type TenantAPIUsage struct {
+	Tenant    string            `json:"tenant"`
+	From      string            `json:"from"`
+	To        string            `json:"to"`
+	Calls     uint64            `json:"calls"`
+	Endpoints map[string]uint64 `json:"endpoints"`
+	Days      []DailyAPIUsage   `json:"days"`
+}
+
+var (
+	// the number of days kept per tenant
+	apiUsageDays = util.GetEnvInt("APIUsageHistoryDays", 400)
+
+	apiUsage     = make(map[string]map[string]*DailyAPIUsage)
+	apiUsageLock = sync.RWMutex{}
+)
+
+// RecordAPICall counts a tenant API call of the endpoint, the endpoint is the route name or the path template
+func RecordAPICall(tenant, endpoint string, t time.Time) {
+	t = t.UTC()
+	date := t.Format("2006-01-02")
+	apiUsageLock.Lock()
+	defer apiUsageLock.Unlock()
+	days, ok := apiUsage[tenant]
+	if !ok {
+		days = make(map[string]*DailyAPIUsage)
+		apiUsage[tenant] = days
+	}
+	day, ok := days[date]
+	if !ok {
+		day = &DailyAPIUsage{Date: date, Endpoints: make(map[string]uint64)}
+		days[date] = day
+		cutoff := t.AddDate(0, 0, -apiUsageDays).Format("2006-01-02")
+		for d := range days {
+			if d < cutoff {
+				delete(days, d)
+			}
+		}
+	}
+	day.Calls++
+	day.Endpoints[endpoint]++
+}
+
+// TenantAPICalls returns the number of the tenant API calls in the UTC day of the time
+func TenantAPICalls(tenant string, t time.Time) uint64 {
+	apiUsageLock.RLock()
+	defer apiUsageLock.RUnlock()
+	if day, ok := apiUsage[tenant][t.UTC().Format("2006-01-02")]; ok {
+		return day.Calls
+	}
+	return 0
+}
+
+// GetTenantAPIUsage returns the API calls of a tenant within the days in the format of 2006-01-02
+func GetTenantAPIUsage(tenant, from, to string) (TenantAPIUsage, error) {
+	for _, date := range []string{from, to} {
+		if _, err := time.Parse("2006-01-02", date); err != nil {
+			return TenantAPIUsage{}, fmt.Errorf("from and to must be in the format of YYYY-MM-DD")
+		}
+	}
+	usage := TenantAPIUsage{Tenant: tenant, From: from, To: to, Endpoints: make(map[string]uint64), Days: []DailyAPIUsage{}}
+	apiUsageLock.RLock()
+	for date, day := range apiUsage[tenant] {
+		if date < from || date > to {
+			continue
+		}
+		d := DailyAPIUsage{Date: date, Calls: day.Calls, Endpoints: make(map[string]uint64, len(day.Endpoints))}
+		for k, v := range day.Endpoints {
+			d.Endpoints[k] = v
+			usage.Endpoints[k] += v
+		}
+		usage.Calls += d.Calls
+		usage.Days = append(usage.Days, d)
+	}
+	apiUsageLock.RUnlock()
+
+	sort.Slice(usage.Days, func(i, j int) bool { return usage.Days[i].Date < usage.Days[j].Date })
+	return usage, nil
+}
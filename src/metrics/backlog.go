@@ -0,0 +1,85 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package metrics
+
+import (
+	"sort"
+)
+
+// TopicBacklog is the backlog size and the backlog quota limit of a topic in bytes
+type TopicBacklog struct {
+	Topic        string  `json:"topic"`
+	Namespace    string  `json:"namespace"`
+	BacklogBytes float64 `json:"backlogBytes"`
+	QuotaBytes   float64 `json:"quotaBytes"`
+}
+
+// UsagePercent is the backlog size over the quota limit, 0 without a quota limit
+func (b TopicBacklog) UsagePercent() float64 {
+	if b.QuotaBytes <= 0 {
+		return 0
+	}
+	return b.BacklogBytes / b.QuotaBytes * 100
+}
+
+// AggregateTopicBacklogs collects the backlog size and the quota limit of every topic from the federated metrics
+func AggregateTopicBacklogs(data []byte) ([]TopicBacklog, error) {
+	backlogs := make(map[string]*TopicBacklog)
+	err := forEachSample(data, func(name string, labels map[string]string, value float64) {
+		if name != "pulsar_storage_backlog_size" && name != "pulsar_storage_backlog_quota_limit" {
+			return
+		}
+		topic := labels["topic"]
+		if topic == "" {
+			return
+		}
+		b, ok := backlogs[topic]
+		if !ok {
+			b = &TopicBacklog{Topic: topic, Namespace: labels["namespace"]}
+			backlogs[topic] = b
+		}
+		// a topic is scraped once per broker owning it, the largest values are the current owner
+		if name == "pulsar_storage_backlog_size" && value > b.BacklogBytes {
+			b.BacklogBytes = value
+		} else if name == "pulsar_storage_backlog_quota_limit" && value > b.QuotaBytes {
+			b.QuotaBytes = value
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := make([]TopicBacklog, 0, len(backlogs))
+	for _, b := range backlogs {
+		result = append(result, *b)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Topic < result[j].Topic })
+	return result, nil
+}
+
+// GetTopicBacklogs returns the topic backlogs from the cached federated broker metrics
+func GetTopicBacklogs() ([]TopicBacklog, error) {
+	data, err := GetTenantPromMetrics(SuperRole)
+	if err != nil {
+		return nil, err
+	}
+	return AggregateTopicBacklogs(data)
+}
@@ -0,0 +1,135 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/datastax/burnell/src/util"
+)
+
+// BrokerMetrics is the broker level aggregation of the federated topic metrics
+type BrokerMetrics struct {
+	Broker           string  `json:"broker"`
+	Topics           int     `json:"topics"`
+	Producers        float64 `json:"producers"`
+	Consumers        float64 `json:"consumers"`
+	MsgRateIn        float64 `json:"msgRateIn"`
+	MsgRateOut       float64 `json:"msgRateOut"`
+	ThroughputIn     float64 `json:"throughputIn"`
+	ThroughputOut    float64 `json:"throughputOut"`
+	StorageSize      float64 `json:"storageSize"`
+	BacklogMessages  float64 `json:"backlogMessages"`
+	UnackedMessages  float64 `json:"unackedMessages"`
+	BlockedConsumers float64 `json:"blockedConsumers"`
+}
+
+// the per topic metrics summed by the broker
+var brokerMetricFields = map[string]func(b *BrokerMetrics) *float64{
+	"pulsar_producers_count":                          func(b *BrokerMetrics) *float64 { return &b.Producers },
+	"pulsar_consumers_count":                          func(b *BrokerMetrics) *float64 { return &b.Consumers },
+	"pulsar_rate_in":                                  func(b *BrokerMetrics) *float64 { return &b.MsgRateIn },
+	"pulsar_rate_out":                                 func(b *BrokerMetrics) *float64 { return &b.MsgRateOut },
+	"pulsar_throughput_in":                            func(b *BrokerMetrics) *float64 { return &b.ThroughputIn },
+	"pulsar_throughput_out":                           func(b *BrokerMetrics) *float64 { return &b.ThroughputOut },
+	"pulsar_storage_size":                             func(b *BrokerMetrics) *float64 { return &b.StorageSize },
+	"pulsar_msg_backlog":                              func(b *BrokerMetrics) *float64 { return &b.BacklogMessages },
+	"pulsar_subscription_unacked_messages":            func(b *BrokerMetrics) *float64 { return &b.UnackedMessages },
+	"pulsar_subscription_blocked_on_unacked_messages": func(b *BrokerMetrics) *float64 { return &b.BlockedConsumers },
+}
+
+// forEachSample calls fn with every sample in the text format metrics.
+// The samples are parsed line by line since the federated output of multiple brokers can repeat the TYPE lines.
+func forEachSample(data []byte, fn func(name string, labels map[string]string, value float64)) error {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, labels, rest, ok := parseSample(line)
+		if !ok {
+			return fmt.Errorf("invalid metric sample %s", line)
+		}
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			return fmt.Errorf("metric sample %s without value", name)
+		}
+		value, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return fmt.Errorf("metric sample %s value %v", name, err)
+		}
+		labelMap := make(map[string]string, len(labels))
+		for _, l := range labels {
+			labelMap[l.name] = l.value
+		}
+		fn(name, labelMap, value)
+	}
+	return scanner.Err()
+}
+
+// AggregateBrokerMetrics sums the federated topic metrics by the broker pod, or the scrape instance without the pod label
+func AggregateBrokerMetrics(data []byte) (map[string]*BrokerMetrics, error) {
+	brokers := make(map[string]*BrokerMetrics)
+	topics := make(map[string]map[string]bool)
+	err := forEachSample(data, func(name string, labels map[string]string, value float64) {
+		field, ok := brokerMetricFields[name]
+		if !ok {
+			return
+		}
+		broker := util.AssignString(labels["kubernetes_pod_name"], labels["instance"])
+		if broker == "" {
+			return
+		}
+		b, ok := brokers[broker]
+		if !ok {
+			b = &BrokerMetrics{Broker: broker}
+			brokers[broker] = b
+			topics[broker] = make(map[string]bool)
+		}
+		*field(b) += value
+		if topic := labels["topic"]; topic != "" {
+			topics[broker][topic] = true
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	for broker, b := range brokers {
+		b.Topics = len(topics[broker])
+	}
+	return brokers, nil
+}
+
+// GetBrokerMetrics aggregates the cached federated broker metrics by the broker
+func GetBrokerMetrics() (map[string]*BrokerMetrics, error) {
+	data, err := GetTenantPromMetrics(SuperRole)
+	if err != nil {
+		return nil, err
+	}
+	return AggregateBrokerMetrics(data)
+}
@@ -0,0 +1,97 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package metrics
+
+import (
+	"sort"
+	"strings"
+)
+
+// BundleLoad is the load of a namespace bundle on its owner broker
+type BundleLoad struct {
+	// Bundle is {tenant}/{namespace}/{range}
+	Bundle        string  `json:"bundle"`
+	Namespace     string  `json:"namespace"`
+	Range         string  `json:"range"`
+	Broker        string  `json:"broker,omitempty"`
+	MsgRateIn     float64 `json:"msgRateIn"`
+	MsgRateOut    float64 `json:"msgRateOut"`
+	ThroughputIn  float64 `json:"throughputIn"`
+	ThroughputOut float64 `json:"throughputOut"`
+	Topics        int     `json:"topics"`
+	Producers     int     `json:"producers"`
+	Consumers     int     `json:"consumers"`
+}
+
+// AggregateBundleLoads collects the load of every namespace bundle from the bundle metrics in the federated metrics
+func AggregateBundleLoads(data []byte) ([]BundleLoad, error) {
+	bundles := make(map[string]*BundleLoad)
+	err := forEachSample(data, func(name string, labels map[string]string, value float64) {
+		if !strings.HasPrefix(name, "pulsar_bundle_") {
+			return
+		}
+		bundle := labels["bundle"]
+		i := strings.LastIndex(bundle, "/")
+		if i <= 0 {
+			return
+		}
+		b, ok := bundles[bundle]
+		if !ok {
+			b = &BundleLoad{Bundle: bundle, Namespace: bundle[:i], Range: bundle[i+1:], Broker: labels["broker"]}
+			bundles[bundle] = b
+		}
+		switch name {
+		case "pulsar_bundle_msg_rate_in":
+			b.MsgRateIn = value
+		case "pulsar_bundle_msg_rate_out":
+			b.MsgRateOut = value
+		case "pulsar_bundle_msg_throughput_in":
+			b.ThroughputIn = value
+		case "pulsar_bundle_msg_throughput_out":
+			b.ThroughputOut = value
+		case "pulsar_bundle_topics_count":
+			b.Topics = int(value)
+		case "pulsar_bundle_producer_count":
+			b.Producers = int(value)
+		case "pulsar_bundle_consumer_count":
+			b.Consumers = int(value)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := make([]BundleLoad, 0, len(bundles))
+	for _, b := range bundles {
+		result = append(result, *b)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Bundle < result[j].Bundle })
+	return result, nil
+}
+
+// GetBundleLoads returns the bundle loads from the cached federated broker metrics
+func GetBundleLoads() ([]BundleLoad, error) {
+	data, err := GetTenantPromMetrics(SuperRole)
+	if err != nil {
+		return nil, err
+	}
+	return AggregateBundleLoads(data)
+}
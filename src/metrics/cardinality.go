@@ -0,0 +1,130 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+
+	"github.com/datastax/burnell/src/util"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// maxMetricsLineBytes is the max length of a line of the federated metrics
+const maxMetricsLineBytes = 1024 * 1024
+
+// the default series limits
+const (
+	defaultMaxSeriesPerTenant = 20000
+	defaultMaxSeriesTotal     = 500000
+)
+
+var (
+	// maxSeriesPerTenant is the max number of series served to a tenant, 0 is no limit
+	maxSeriesPerTenant = defaultMaxSeriesPerTenant
+	// maxSeriesTotal is the max number of series served to the superuser scrape, 0 is no limit
+	maxSeriesTotal = defaultMaxSeriesTotal
+
+	droppedSeries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "burnell",
+		Subsystem: "federated",
+		Name:      "series_dropped_total",
+		Help:      "The number of federated series dropped by the cardinality guard.",
+	}, []string{"tenant"})
+)
+
+func init() {
+	prometheus.MustRegister(droppedSeries)
+}
+
+// InitSeriesLimits sets the series limits from MaxFederatedSeriesPerTenant and MaxFederatedSeriesTotal in the configuration
+func InitSeriesLimits() error {
+	perTenant, total := defaultMaxSeriesPerTenant, defaultMaxSeriesTotal
+	for _, n := range []struct {
+		name  string
+		value string
+		field *int
+	}{
+		{"MaxFederatedSeriesPerTenant", util.GetConfig().MaxFederatedSeriesPerTenant, &perTenant},
+		{"MaxFederatedSeriesTotal", util.GetConfig().MaxFederatedSeriesTotal, &total},
+	} {
+		if n.value == "" {
+			continue
+		}
+		v, err := strconv.Atoi(n.value)
+		if err != nil || v < 0 {
+			return fmt.Errorf("invalid %s %s", n.name, n.value)
+		}
+		*n.field = v
+	}
+	SetSeriesLimits(perTenant, total)
+	return nil
+}
+
+// SetSeriesLimits overwrites the per tenant and total series limits, 0 is no limit
+func SetSeriesLimits(perTenant, total int) {
+	maxSeriesPerTenant = perTenant
+	maxSeriesTotal = total
+}
+
+// GuardCardinality caps the number of series served to the tenant.
+// Comment and type definition lines are retained, the excess series are dropped and counted.
+// A line over maxMetricsLineBytes is an error rather than the metrics cut at the line.
+func GuardCardinality(tenant string, data []byte) ([]byte, error) {
+	limit := maxSeriesPerTenant
+	if tenant == SuperRole {
+		limit = maxSeriesTotal
+	}
+	if limit <= 0 {
+		return data, nil
+	}
+
+	var buf bytes.Buffer
+	buf.Grow(len(data))
+	series, dropped := 0, 0
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), maxMetricsLineBytes)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) > 0 && line[0] != '#' {
+			if series >= limit {
+				dropped++
+				continue
+			}
+			series++
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("tenant %s federated metrics %v", tenant, err)
+	}
+
+	if dropped == 0 {
+		return data, nil
+	}
+	logger.Warnf("tenant %s federated metrics over %d series limit, dropped %d series", tenant, limit, dropped)
+	droppedSeries.WithLabelValues(tenant).Add(float64(dropped))
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,49 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var tenantConnectionsGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "burnell",
+	Subsystem: "tenant",
+	Name:      "connections",
+	Help:      "The number of producers, consumers and subscriptions of the tenant in the last usage build.",
+}, []string{"tenant", "kind"})
+
+func init() {
+	prometheus.MustRegister(tenantConnectionsGauge)
+}
+
+// updateConnectionGauges sets the producer, consumer and subscription series of every tenant
+func updateConnectionGauges() {
+	usages, err := GetTenantsUsage()
+	if err != nil {
+		logger.Errorf("failed to get tenants usage for the connection metrics error %v", err)
+		return
+	}
+	for _, usage := range usages {
+		tenantConnectionsGauge.WithLabelValues(usage.Name, Producers).Set(float64(usage.Producers))
+		tenantConnectionsGauge.WithLabelValues(usage.Name, Consumers).Set(float64(usage.Consumers))
+		tenantConnectionsGauge.WithLabelValues(usage.Name, Subscriptions).Set(float64(usage.Subscriptions))
+	}
+}
@@ -30,11 +30,13 @@ import (
 	"regexp"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/apex/log"
 	"github.com/datastax/burnell/src/util"
 	"github.com/hashicorp/go-memdb"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/prometheus/common/expfmt"
 )
 
@@ -46,7 +48,15 @@ type Usage struct {
 	TotalMessagesOut uint64    `json:"totalMessagesOut"`
 	TotalBytesOut    uint64    `json:"totalBytesOut"`
 	MsgInBacklog     uint64    `json:"msgInBacklog"`
+	Producers        uint64    `json:"producers"`
+	Consumers        uint64    `json:"consumers"`
+	Subscriptions    uint64    `json:"subscriptions"`
+	TxnCommitted     uint64    `json:"txnCommitted"`
+	TxnAborted       uint64    `json:"txnAborted"`
+	APICalls         uint64    `json:"apiCalls"`
 	UpdatedAt        time.Time `json:"updatedAt"`
+	// Metadata is the tenant metadata in the tenants usage
+	Metadata map[string]string `json:"metadata,omitempty"`
 }
 
 // TopicPerBrokerUsage is the usage for topic on each individual broker
@@ -61,6 +71,11 @@ type TopicPerBrokerUsage struct {
 	TotalMessagesOut uint64    `json:"totalMessagesOut"`
 	TotalBytesOut    uint64    `json:"totalBytesOut"`
 	MsgInBacklog     uint64    `json:"msgInBacklog"`
+	Producers        uint64    `json:"producers"`
+	Consumers        uint64    `json:"consumers"`
+	Subscriptions    uint64    `json:"subscriptions"`
+	TxnCommitted     uint64    `json:"txnCommitted"`
+	TxnAborted       uint64    `json:"txnAborted"`
 	UpdatedAt        time.Time `json:"updatedAt"`
 }
 
@@ -79,23 +94,36 @@ var (
 	cacheLock = sync.RWMutex{}
 	// the the cache for raw prometheus data
 	cache = make(map[string]*TenantPromMetrics)
+
+	// the usage snapshot version for ETag
+	usageVersion uint64
 )
 
 var tenantMetricNames = map[string]bool{
-	"pulsar_in_bytes_total":     true,
-	"pulsar_in_messages_total":  true,
-	"pulsar_out_bytes_total":    true,
-	"pulsar_out_messages_total": true,
-	"pulsar_msg_backlog":        true,
+	"pulsar_in_bytes_total":      true,
+	"pulsar_in_messages_total":   true,
+	"pulsar_out_bytes_total":     true,
+	"pulsar_out_messages_total":  true,
+	"pulsar_msg_backlog":         true,
+	"pulsar_producers_count":     true,
+	"pulsar_consumers_count":     true,
+	"pulsar_subscriptions_count": true,
+	// the per topic transaction buffer metrics, the transaction coordinator metrics are cluster wide without a namespace
+	"pulsar_txn_tb_committed_total": true,
+	"pulsar_txn_tb_aborted_total":   true,
 }
 
 var logger = log.WithFields(log.Fields{"app": "burnell,federated-prom-scraper"})
 
 // SetCache sets the federated prom cache
 func SetCache(tenant string, data []byte) {
+	setCache(tenant, data, time.Now())
+}
+
+func setCache(tenant string, data []byte, scrapedAt time.Time) {
 	cacheLock.Lock()
 	cache[tenant] = &TenantPromMetrics{
-		updateTime: time.Now(),
+		updateTime: scrapedAt,
 		promData:   data,
 	}
 	cacheLock.Unlock()
@@ -103,18 +131,26 @@ func SetCache(tenant string, data []byte) {
 
 // GetCache gets the federated prom cache
 func GetCache(tenant string) ([]byte, error) {
+	data, _, err := getCache(tenant)
+	return data, err
+}
+
+// getCache gets the federated prom cache and the scrape time of the cached metrics
+func getCache(tenant string) ([]byte, time.Time, error) {
 	cacheLock.RLock()
 	defer cacheLock.RUnlock()
 	if metrics, ok := cache[tenant]; ok {
 		if time.Since(metrics.updateTime) < scrapeInterval {
-			return metrics.promData, nil
+			return metrics.promData, metrics.updateTime, nil
 		}
 	}
-	return nil, fmt.Errorf("error")
+	return nil, time.Time{}, fmt.Errorf("error")
 }
 
 var usageDb *memdb.MemDB
 
+var errUsageNotEnabled = fmt.Errorf("tenant usage is not enabled")
+
 const (
 	usageDbTable = "topic-usage"
 
@@ -140,6 +176,7 @@ func Init() {
 				select {
 				case <-ticker.C:
 					BuildTenantUsage()
+					DetectUsageAnomalies()
 				}
 			}
 		}()
@@ -193,27 +230,22 @@ func FilterFederatedMetrics(byteData []byte, subject string) string {
 	var str strings.Builder
 	scanner := bufio.NewScanner(strings.NewReader(string(byteData)))
 
-	pattern := fmt.Sprintf(`.*,namespace="%s.*`, subject)
-	typeDefPattern := fmt.Sprintf(`^# TYPE .*`)
+	pattern := regexp.MustCompile(fmt.Sprintf(`[{,]namespace="%s`, regexp.QuoteMeta(subject)))
 	typeDef := ""
 	for scanner.Scan() {
 		text := scanner.Text()
-		matched, err := regexp.MatchString(typeDefPattern, text)
-		if matched && err == nil {
+		if strings.HasPrefix(text, "# TYPE ") {
 			typeDef = text
-		} else {
-			matched, err = regexp.MatchString(pattern, text)
-			if matched && err == nil {
-				if typeDef == "" {
-					str.WriteString(text)
-					str.WriteString("\n")
-				} else {
-					str.WriteString(typeDef)
-					str.WriteString("\n")
-					str.WriteString(text)
-					str.WriteString("\n")
-					typeDef = ""
-				}
+		} else if pattern.MatchString(text) {
+			if typeDef == "" {
+				str.WriteString(text)
+				str.WriteString("\n")
+			} else {
+				str.WriteString(typeDef)
+				str.WriteString("\n")
+				str.WriteString(text)
+				str.WriteString("\n")
+				typeDef = ""
 			}
 		}
 	}
@@ -222,9 +254,15 @@ func FilterFederatedMetrics(byteData []byte, subject string) string {
 
 // GetTenantPromMetrics gets tenant prometheus metrics
 func GetTenantPromMetrics(tenant string) ([]byte, error) {
+	data, _, err := GetScrapedTenantPromMetrics(tenant)
+	return data, err
+}
+
+// GetScrapedTenantPromMetrics gets tenant prometheus metrics and the time they are scraped from the federated Prometheus
+func GetScrapedTenantPromMetrics(tenant string) ([]byte, time.Time, error) {
 	log.Infof("get tenant prom metrics %s", tenant)
-	if data, err := GetCache(tenant); err == nil {
-		return data, nil
+	if data, scrapedAt, err := getCache(tenant); err == nil {
+		return data, scrapedAt, nil
 	}
 
 	var url string
@@ -236,10 +274,11 @@ func GetTenantPromMetrics(tenant string) ([]byte, error) {
 	}
 	data, err := scrapeJob(url)
 	if err == nil {
-		SetCache(tenant, data)
-		return data, nil
+		scrapedAt := time.Now()
+		setCache(tenant, data, scrapedAt)
+		return data, scrapedAt, nil
 	}
-	return nil, err
+	return nil, time.Time{}, err
 }
 
 // scrapeJob(url+"/?match[]={job=~\"broker.*\"}") + scrapeJob(url+"/?match[]={job=~\"function.*\"}")
@@ -296,11 +335,30 @@ func BuildTenantUsage() {
 					default:
 					}
 				}
-				counter := entry.GetUntyped()
-				UpdatePerBrokerTenantUsage(topic, broker, label, uint64(counter.GetValue()))
+				UpdatePerBrokerTenantUsage(topic, broker, label, uint64(sampleValue(entry)))
 			}
 		}
 	}
+	atomic.AddUint64(&usageVersion, 1)
+	recordUsageHistory()
+	updateConnectionGauges()
+}
+
+// sampleValue returns the value of a federated sample, the federation exposes untyped samples
+// but a counter type is kept when the metric family declares it
+func sampleValue(m *dto.Metric) float64 {
+	if m.GetCounter() != nil {
+		return m.GetCounter().GetValue()
+	}
+	if m.GetGauge() != nil {
+		return m.GetGauge().GetValue()
+	}
+	return m.GetUntyped().GetValue()
+}
+
+// UsageVersion returns the version of the usage snapshot, it increases every time the usage is rebuilt
+func UsageVersion() uint64 {
+	return atomic.LoadUint64(&usageVersion)
 }
 
 // UpdatePerBrokerTenantUsage updates per broker tenant usage
@@ -330,6 +388,16 @@ func UpdatePerBrokerTenantUsage(topic, broker, label string, counter uint64) err
 		perBrokerUsage.TotalMessagesOut = counter
 	case "pulsar_msg_backlog":
 		perBrokerUsage.MsgInBacklog = counter
+	case "pulsar_producers_count":
+		perBrokerUsage.Producers = counter
+	case "pulsar_consumers_count":
+		perBrokerUsage.Consumers = counter
+	case "pulsar_subscriptions_count":
+		perBrokerUsage.Subscriptions = counter
+	case "pulsar_txn_tb_committed_total":
+		perBrokerUsage.TxnCommitted = counter
+	case "pulsar_txn_tb_aborted_total":
+		perBrokerUsage.TxnAborted = counter
 	default:
 		return fmt.Errorf("incorrect lable %s", label)
 	}
@@ -363,6 +431,9 @@ func GetTenantsUsage() ([]Usage, error) {
 
 // GetTenantUsage get tenant's usage
 func GetTenantUsage(tenant string) (*Usage, error) {
+	if usageDb == nil {
+		return nil, errUsageNotEnabled
+	}
 	usage := Usage{
 		Name: tenant,
 	}
@@ -382,16 +453,26 @@ func GetTenantUsage(tenant string) (*Usage, error) {
 			usage.TotalBytesOut = usage.TotalBytesOut + p.TotalBytesOut
 			usage.TotalMessagesOut = usage.TotalMessagesOut + p.TotalMessagesOut
 			usage.MsgInBacklog = usage.MsgInBacklog + p.MsgInBacklog
+			usage.Producers = usage.Producers + p.Producers
+			usage.Consumers = usage.Consumers + p.Consumers
+			usage.Subscriptions = usage.Subscriptions + p.Subscriptions
+			usage.TxnCommitted = usage.TxnCommitted + p.TxnCommitted
+			usage.TxnAborted = usage.TxnAborted + p.TxnAborted
 		}
 	}
 
 	usage.UpdatedAt = time.Now()
+	// the burnell API calls of the current UTC day
+	usage.APICalls = TenantAPICalls(tenant, usage.UpdatedAt)
 	return &usage, nil
 }
 
 // GetTenantNamespacesUsage get tenant's namespace usage
 func GetTenantNamespacesUsage(tenant string) ([]Usage, error) {
 	// key is tenant and namespace concatenated
+	if usageDb == nil {
+		return nil, errUsageNotEnabled
+	}
 	tnamespaces := make(map[string]Usage)
 	txn := usageDb.Txn(false)
 	defer txn.Abort()
@@ -417,6 +498,11 @@ func GetTenantNamespacesUsage(tenant string) ([]Usage, error) {
 			usage.TotalBytesOut = usage.TotalBytesOut + p.TotalBytesOut
 			usage.TotalMessagesOut = usage.TotalMessagesOut + p.TotalMessagesOut
 			usage.MsgInBacklog = usage.MsgInBacklog + p.MsgInBacklog
+			usage.Producers = usage.Producers + p.Producers
+			usage.Consumers = usage.Consumers + p.Consumers
+			usage.Subscriptions = usage.Subscriptions + p.Subscriptions
+			usage.TxnCommitted = usage.TxnCommitted + p.TxnCommitted
+			usage.TxnAborted = usage.TxnAborted + p.TxnAborted
 
 			tnamespaces[key] = usage
 		}
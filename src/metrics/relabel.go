@@ -0,0 +1,189 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/datastax/burnell/src/util"
+)
+
+// metric name suffixes of histogram and summary series that belong to the same family
+var familySuffixes = []string{"_bucket", "_sum", "_count"}
+
+type label struct {
+	name  string
+	value string
+}
+
+// Relabel rewrites federated metrics in Prometheus text format based on the rules.
+// The original data slice is returned if no rule is configured. A line over maxMetricsLineBytes is an error.
+func Relabel(data []byte, rules util.MetricsRelabelRules, cluster string) ([]byte, error) {
+	if rules.ClusterLabel == "" && !rules.NormalizeTenantLabels && len(rules.RenameMetrics) == 0 {
+		return data, nil
+	}
+	var buf bytes.Buffer
+	buf.Grow(len(data) + len(data)/10)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), maxMetricsLineBytes)
+	for scanner.Scan() {
+		buf.WriteString(relabelLine(scanner.Text(), rules, cluster))
+		buf.WriteByte('\n')
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("relabel federated metrics %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func relabelLine(line string, rules util.MetricsRelabelRules, cluster string) string {
+	if strings.HasPrefix(line, "# TYPE ") || strings.HasPrefix(line, "# HELP ") {
+		parts := strings.SplitN(line, " ", 4)
+		if len(parts) >= 3 {
+			parts[2] = renameMetric(parts[2], rules.RenameMetrics)
+			return strings.Join(parts, " ")
+		}
+		return line
+	}
+	if line == "" || line[0] == '#' {
+		return line
+	}
+
+	name, labels, rest, ok := parseSample(line)
+	if !ok {
+		return line
+	}
+	name = renameMetric(name, rules.RenameMetrics)
+	if rules.NormalizeTenantLabels {
+		labels = normalizeTenantLabels(labels)
+	}
+	if rules.ClusterLabel != "" && cluster != "" {
+		labels = setLabel(labels, rules.ClusterLabel, cluster)
+	}
+
+	var sb strings.Builder
+	sb.WriteString(name)
+	if len(labels) > 0 {
+		sb.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+			sb.WriteString(l.name)
+			sb.WriteString(`="`)
+			sb.WriteString(l.value)
+			sb.WriteByte('"')
+		}
+		sb.WriteByte('}')
+	}
+	sb.WriteString(rest)
+	return sb.String()
+}
+
+func renameMetric(name string, renames map[string]string) string {
+	if newName, ok := renames[name]; ok {
+		return newName
+	}
+	for _, suffix := range familySuffixes {
+		if strings.HasSuffix(name, suffix) {
+			if newName, ok := renames[strings.TrimSuffix(name, suffix)]; ok {
+				return newName + suffix
+			}
+		}
+	}
+	return name
+}
+
+func normalizeTenantLabels(labels []label) []label {
+	namespace := ""
+	normalized := make([]label, 0, len(labels)+1)
+	for _, l := range labels {
+		if l.name == "exported_namespace" {
+			l.name = "namespace"
+		}
+		if l.name == "namespace" {
+			namespace = l.value
+		}
+		normalized = append(normalized, l)
+	}
+	if parts := strings.SplitN(namespace, "/", 2); len(parts) == 2 {
+		normalized = setLabel(normalized, "tenant", parts[0])
+	}
+	return normalized
+}
+
+// setLabel overwrites an existing label or appends a new one
+func setLabel(labels []label, name, value string) []label {
+	for i := range labels {
+		if labels[i].name == name {
+			labels[i].value = value
+			return labels
+		}
+	}
+	return append(labels, label{name: name, value: value})
+}
+
+// parseSample parses a sample line into metric name, labels with the escaped values as is,
+// and the rest of line including the value and timestamp
+func parseSample(line string) (string, []label, string, bool) {
+	brace := strings.IndexByte(line, '{')
+	space := strings.IndexByte(line, ' ')
+	if brace < 0 || (space >= 0 && space < brace) {
+		if space < 0 {
+			return "", nil, "", false
+		}
+		return line[:space], nil, line[space:], true
+	}
+
+	name := line[:brace]
+	labels := []label{}
+	i := brace + 1
+	for i < len(line) {
+		for i < len(line) && (line[i] == ',' || line[i] == ' ') {
+			i++
+		}
+		if i < len(line) && line[i] == '}' {
+			return name, labels, line[i+1:], true
+		}
+		eq := strings.IndexByte(line[i:], '=')
+		if eq < 0 || i+eq+1 >= len(line) || line[i+eq+1] != '"' {
+			return "", nil, "", false
+		}
+		labelName := strings.TrimSpace(line[i : i+eq])
+		j := i + eq + 2
+		for j < len(line) && line[j] != '"' {
+			if line[j] == '\\' {
+				j++
+			}
+			j++
+		}
+		if j >= len(line) {
+			return "", nil, "", false
+		}
+		labels = append(labels, label{name: labelName, value: line[i+eq+2 : j]})
+		i = j + 1
+	}
+	return "", nil, "", false
+}
@@ -0,0 +1,185 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package metrics
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/datastax/burnell/src/util"
+)
+
+// DailySLA is the requests and the upstream availability observed for a tenant in a UTC day
+type DailySLA struct {
+	Date         string `json:"date"`
+	Requests     uint64 `json:"requests"`
+	ServerErrors uint64 `json:"serverErrors"`
+	// ObservedMinutes are the minutes with at least one upstream call
+	ObservedMinutes uint64 `json:"observedMinutes"`
+	// UnavailableMinutes are the observed minutes with at least one failed upstream call
+	UnavailableMinutes uint64 `json:"unavailableMinutes"`
+	// Probes are the synthetic produce and consume probes of the tenant heartbeat topic
+	Probes        uint64 `json:"probes"`
+	ProbeFailures uint64 `json:"probeFailures"`
+	// ProbeLatencyMs is the total end to end latency of the successful probes
+	ProbeLatencyMs float64 `json:"probeLatencyMs"`
+}
+
+// TenantSLAReport is the monthly SLA report of a tenant, the rates are percentages
+type TenantSLAReport struct {
+	Tenant             string  `json:"tenant"`
+	Month              string  `json:"month"`
+	Requests           uint64  `json:"requests"`
+	ServerErrors       uint64  `json:"serverErrors"`
+	SuccessRate        float64 `json:"successRate"`
+	ObservedMinutes    uint64  `json:"observedMinutes"`
+	UnavailableMinutes uint64  `json:"unavailableMinutes"`
+	Availability       float64 `json:"availability"`
+	Probes             uint64  `json:"probes"`
+	ProbeFailures      uint64  `json:"probeFailures"`
+	ProbeSuccessRate   float64 `json:"probeSuccessRate"`
+	// ProbeAvgLatencyMs is the average end to end latency of the successful probes
+	ProbeAvgLatencyMs float64    `json:"probeAvgLatencyMs"`
+	Days              []DailySLA `json:"days"`
+}
+
+type tenantSLA struct {
+	days         map[string]*DailySLA
+	lastMinute   int64
+	lastMinuteKO bool
+}
+
+var (
+	// the number of days kept per tenant
+	slaHistoryDays = util.GetEnvInt("SLAHistoryDays", 400)
+
+	slaHistory     = make(map[string]*tenantSLA)
+	slaHistoryLock = sync.Mutex{}
+)
+
+// RecordTenantRequest records the response status of a tenant request, and whether the upstream was called and failed
+func RecordTenantRequest(tenant string, status int, upstreamCalled, upstreamFailed bool, t time.Time) {
+	t = t.UTC()
+	slaHistoryLock.Lock()
+	defer slaHistoryLock.Unlock()
+	sla, day := tenantSLADay(tenant, t)
+	day.Requests++
+	if status >= 500 {
+		day.ServerErrors++
+	}
+	if !upstreamCalled {
+		return
+	}
+	if minute := t.Unix() / 60; minute != sla.lastMinute {
+		sla.lastMinute, sla.lastMinuteKO = minute, false
+		day.ObservedMinutes++
+	}
+	if upstreamFailed && !sla.lastMinuteKO {
+		sla.lastMinuteKO = true
+		day.UnavailableMinutes++
+	}
+}
+
+// RecordTenantProbe records the result and the end to end latency of a synthetic probe of the tenant
+func RecordTenantProbe(tenant string, success bool, latency time.Duration, t time.Time) {
+	slaHistoryLock.Lock()
+	defer slaHistoryLock.Unlock()
+	_, day := tenantSLADay(tenant, t.UTC())
+	day.Probes++
+	if !success {
+		day.ProbeFailures++
+		return
+	}
+	day.ProbeLatencyMs += float64(latency) / float64(time.Millisecond)
+}
+
+// tenantSLADay returns the tenant SLA history and its day of the time, the lock must be held
+func tenantSLADay(tenant string, t time.Time) (*tenantSLA, *DailySLA) {
+	sla, ok := slaHistory[tenant]
+	if !ok {
+		sla = &tenantSLA{days: make(map[string]*DailySLA)}
+		slaHistory[tenant] = sla
+	}
+	date := t.Format("2006-01-02")
+	day, ok := sla.days[date]
+	if !ok {
+		day = &DailySLA{Date: date}
+		sla.days[date] = day
+		sla.prune(t)
+	}
+	return sla, day
+}
+
+// prune removes the days out of the history window
+func (s *tenantSLA) prune(now time.Time) {
+	cutoff := now.AddDate(0, 0, -slaHistoryDays).Format("2006-01-02")
+	for date := range s.days {
+		if date < cutoff {
+			delete(s.days, date)
+		}
+	}
+}
+
+// GetTenantSLAReport returns the SLA report of a tenant for the month in the format of 2006-01.
+// The rates are 100 if nothing is observed.
+func GetTenantSLAReport(tenant, month string) (TenantSLAReport, error) {
+	if _, err := time.Parse("2006-01", month); err != nil {
+		return TenantSLAReport{}, fmt.Errorf("month must be in the format of YYYY-MM")
+	}
+	report := TenantSLAReport{Tenant: tenant, Month: month, Days: []DailySLA{}}
+	slaHistoryLock.Lock()
+	if sla, ok := slaHistory[tenant]; ok {
+		for date, day := range sla.days {
+			if date[:7] == month {
+				report.Days = append(report.Days, *day)
+			}
+		}
+	}
+	slaHistoryLock.Unlock()
+
+	sort.Slice(report.Days, func(i, j int) bool { return report.Days[i].Date < report.Days[j].Date })
+	for _, day := range report.Days {
+		report.Requests += day.Requests
+		report.ServerErrors += day.ServerErrors
+		report.ObservedMinutes += day.ObservedMinutes
+		report.UnavailableMinutes += day.UnavailableMinutes
+		report.Probes += day.Probes
+		report.ProbeFailures += day.ProbeFailures
+		report.ProbeAvgLatencyMs += day.ProbeLatencyMs
+	}
+	report.SuccessRate = percentage(report.Requests-report.ServerErrors, report.Requests)
+	report.Availability = percentage(report.ObservedMinutes-report.UnavailableMinutes, report.ObservedMinutes)
+	report.ProbeSuccessRate = percentage(report.Probes-report.ProbeFailures, report.Probes)
+	if succeeded := report.Probes - report.ProbeFailures; succeeded > 0 {
+		report.ProbeAvgLatencyMs /= float64(succeeded)
+	}
+	return report, nil
+}
+
+func percentage(part, total uint64) float64 {
+	if total == 0 {
+		return 100
+	}
+	return float64(part) * 100 / float64(total)
+}
@@ -0,0 +1,160 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package metrics
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/datastax/burnell/src/util"
+)
+
+// the usage metric names in the history, the same as the Usage JSON fields
+const (
+	TotalMessagesIn  = "totalMessagesIn"
+	TotalBytesIn     = "totalBytesIn"
+	TotalMessagesOut = "totalMessagesOut"
+	TotalBytesOut    = "totalBytesOut"
+	MsgInBacklog     = "msgInBacklog"
+	Producers        = "producers"
+	Consumers        = "consumers"
+	Subscriptions    = "subscriptions"
+	TxnCommitted     = "txnCommitted"
+	TxnAborted       = "txnAborted"
+	APICalls         = "apiCalls"
+)
+
+// UsageMetrics are all metrics kept in the usage history
+var UsageMetrics = []string{TotalMessagesIn, TotalBytesIn, TotalMessagesOut, TotalBytesOut, MsgInBacklog, Producers, Consumers, Subscriptions, TxnCommitted, TxnAborted, APICalls}
+
+var (
+	// the number of usage snapshots kept per tenant
+	usageHistorySize = util.GetEnvInt("UsageHistorySize", 1440)
+
+	usageHistory     = make(map[string][]Usage)
+	usageHistoryLock = sync.RWMutex{}
+
+	// the number of recent anomalies kept for the annotations
+	anomalyHistorySize = util.GetEnvInt("UsageAnomalyHistorySize", 1000)
+
+	anomalyHistory     = make([]UsageAnomaly, 0)
+	anomalyHistoryLock = sync.RWMutex{}
+)
+
+// recordUsageHistory adds the current usage of every tenant to the history
+func recordUsageHistory() {
+	usages, err := GetTenantsUsage()
+	if err != nil {
+		logger.Errorf("failed to get tenants usage for the usage history error %v", err)
+		return
+	}
+	now := time.Now()
+	usageHistoryLock.Lock()
+	defer usageHistoryLock.Unlock()
+	for _, usage := range usages {
+		usage.UpdatedAt = now
+		samples := append(usageHistory[usage.Name], usage)
+		if len(samples) > usageHistorySize {
+			samples = samples[len(samples)-usageHistorySize:]
+		}
+		usageHistory[usage.Name] = samples
+	}
+}
+
+// recordAnomalyHistory keeps a detected anomaly
+func recordAnomalyHistory(anomaly UsageAnomaly) {
+	anomalyHistoryLock.Lock()
+	defer anomalyHistoryLock.Unlock()
+	anomalyHistory = append(anomalyHistory, anomaly)
+	if len(anomalyHistory) > anomalyHistorySize {
+		anomalyHistory = anomalyHistory[len(anomalyHistory)-anomalyHistorySize:]
+	}
+}
+
+// UsageHistoryTenants returns the sorted names of the tenants in the usage history
+func UsageHistoryTenants() []string {
+	usageHistoryLock.RLock()
+	defer usageHistoryLock.RUnlock()
+	names := make([]string, 0, len(usageHistory))
+	for name := range usageHistory {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GetUsageHistory returns the usage snapshots of a tenant within the time range in the chronological order
+func GetUsageHistory(tenant string, from, to time.Time) []Usage {
+	usageHistoryLock.RLock()
+	defer usageHistoryLock.RUnlock()
+	samples := []Usage{}
+	for _, u := range usageHistory[tenant] {
+		if !u.UpdatedAt.Before(from) && !u.UpdatedAt.After(to) {
+			samples = append(samples, u)
+		}
+	}
+	return samples
+}
+
+// GetUsageAnomalies returns the anomalies of a tenant detected within the time range, an empty tenant returns all tenants
+func GetUsageAnomalies(tenant string, from, to time.Time) []UsageAnomaly {
+	anomalyHistoryLock.RLock()
+	defer anomalyHistoryLock.RUnlock()
+	anomalies := []UsageAnomaly{}
+	for _, a := range anomalyHistory {
+		if (tenant == "" || a.Tenant == tenant) && !a.DetectedAt.Before(from) && !a.DetectedAt.After(to) {
+			anomalies = append(anomalies, a)
+		}
+	}
+	return anomalies
+}
+
+// UsageMetricValue returns the value of a usage metric by the name
+func UsageMetricValue(u Usage, metric string) (float64, bool) {
+	switch metric {
+	case TotalMessagesIn:
+		return float64(u.TotalMessagesIn), true
+	case TotalBytesIn:
+		return float64(u.TotalBytesIn), true
+	case TotalMessagesOut:
+		return float64(u.TotalMessagesOut), true
+	case TotalBytesOut:
+		return float64(u.TotalBytesOut), true
+	case MsgInBacklog:
+		return float64(u.MsgInBacklog), true
+	case Producers:
+		return float64(u.Producers), true
+	case Consumers:
+		return float64(u.Consumers), true
+	case Subscriptions:
+		return float64(u.Subscriptions), true
+	case TxnCommitted:
+		return float64(u.TxnCommitted), true
+	case TxnAborted:
+		return float64(u.TxnAborted), true
+	case APICalls:
+		return float64(u.APICalls), true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,128 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package metrics
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strconv"
+	"time"
+)
+
+// the kinds of the usage report metrics
+const (
+	// CounterMetric is a cumulative counter, its change is the sum of the increases over the period
+	CounterMetric = "counter"
+	// GaugeMetric is a point in time value
+	GaugeMetric = "gauge"
+)
+
+var counterMetrics = map[string]bool{
+	TotalMessagesIn:  true,
+	TotalBytesIn:     true,
+	TotalMessagesOut: true,
+	TotalBytesOut:    true,
+	TxnCommitted:     true,
+	TxnAborted:       true,
+	APICalls:         true,
+}
+
+// UsageReportMetric is the summary of a usage metric over the report period
+type UsageReportMetric struct {
+	Metric string  `json:"metric"`
+	Kind   string  `json:"kind"`
+	Start  float64 `json:"start"`
+	End    float64 `json:"end"`
+	Change float64 `json:"change"`
+	Max    float64 `json:"max"`
+}
+
+// UsageReport is the usage summary of a tenant over a period from the usage history
+type UsageReport struct {
+	Tenant string    `json:"tenant"`
+	From   time.Time `json:"from"`
+	To     time.Time `json:"to"`
+	// Samples is the number of the usage snapshots in the period, the history may not cover the whole period
+	Samples     int                 `json:"samples"`
+	Metrics     []UsageReportMetric `json:"metrics"`
+	Anomalies   int                 `json:"anomalies"`
+	GeneratedAt time.Time           `json:"generatedAt"`
+}
+
+// BuildUsageReport summarizes the usage samples in the chronological order. The change of a counter
+// is the sum of the increases between the samples so that a counter reset is not a negative change.
+func BuildUsageReport(tenant string, samples []Usage, anomalies []UsageAnomaly, from, to, now time.Time) UsageReport {
+	report := UsageReport{
+		Tenant:      tenant,
+		From:        from,
+		To:          to,
+		Samples:     len(samples),
+		Metrics:     make([]UsageReportMetric, 0, len(UsageMetrics)),
+		Anomalies:   len(anomalies),
+		GeneratedAt: now,
+	}
+	for _, metric := range UsageMetrics {
+		m := UsageReportMetric{Metric: metric, Kind: GaugeMetric}
+		if counterMetrics[metric] {
+			m.Kind = CounterMetric
+		}
+		for i, u := range samples {
+			v, _ := UsageMetricValue(u, metric)
+			if i == 0 {
+				m.Start = v
+			} else if m.Kind == CounterMetric && v >= m.End {
+				m.Change += v - m.End
+			} else if m.Kind == CounterMetric {
+				// the counter is reset
+				m.Change += v
+			}
+			m.End = v
+			if v > m.Max {
+				m.Max = v
+			}
+		}
+		if m.Kind == GaugeMetric {
+			m.Change = m.End - m.Start
+		}
+		report.Metrics = append(report.Metrics, m)
+	}
+	return report
+}
+
+// GetUsageReport builds the usage report of a tenant from the usage history within the period
+func GetUsageReport(tenant string, from, to time.Time) UsageReport {
+	return BuildUsageReport(tenant, GetUsageHistory(tenant, from, to), GetUsageAnomalies(tenant, from, to), from, to, time.Now())
+}
+
+// CSV renders the report metrics as CSV, one row per metric
+func (r UsageReport) CSV() ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"tenant", "from", "to", "metric", "kind", "start", "end", "change", "max"})
+	format := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	for _, m := range r.Metrics {
+		w.Write([]string{r.Tenant, r.From.UTC().Format(time.RFC3339), r.To.UTC().Format(time.RFC3339),
+			m.Metric, m.Kind, format(m.Start), format(m.End), format(m.Change), format(m.Max)})
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
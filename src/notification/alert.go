@@ -0,0 +1,289 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package notification
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/datastax/burnell/src/util"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// the operator alert severities from the lowest
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+var severityLevels = map[string]int{SeverityInfo: 0, SeverityWarning: 1, SeverityCritical: 2}
+
+// the kinds of the operator alerts in addition to QuotaWarning
+const (
+	// ListenerFailure is the alert when an internal Pulsar client such as the tenant policy listener fails
+	ListenerFailure = "listener-failure"
+	// SLAViolation is the alert when the synthetic probe of a tenant fails
+	SLAViolation = "sla-violation"
+)
+
+// PagerDutyEventsURL is the PagerDuty Events API v2 endpoint
+const PagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+// the alert transports
+const (
+	webhookTransport   = "webhook"
+	slackTransport     = "slack"
+	pagerDutyTransport = "pagerduty"
+)
+
+var alertCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "burnell",
+	Subsystem: "alerts",
+	Name:      "total",
+	Help:      "The number of the operator alerts by the kind, the severity, the transport and the result, sent, failed or suppressed.",
+}, []string{"kind", "severity", "transport", "result"})
+
+func init() {
+	prometheus.MustRegister(alertCounter)
+}
+
+// SlackTransport posts notice as a message to Slack incoming webhook URLs
+type SlackTransport struct {
+	Client *http.Client
+}
+
+var slackColors = map[string]string{SeverityInfo: "#439fe0", SeverityWarning: "#ffa500", SeverityCritical: "#d00000"}
+
+// Send posts the notice as a Slack message with the severity color to every incoming webhook URL
+func (t *SlackTransport) Send(recipients []string, notice Notice) error {
+	fields := []map[string]interface{}{{"title": "kind", "value": notice.Kind, "short": true}}
+	if notice.Tenant != "" {
+		fields = append(fields, map[string]interface{}{"title": "tenant", "value": notice.Tenant, "short": true})
+	}
+	if notice.Source != "" {
+		fields = append(fields, map[string]interface{}{"title": "source", "value": notice.Source, "short": true})
+	}
+	data, err := json.Marshal(map[string]interface{}{
+		"text": fmt.Sprintf("[%s] %s", strings.ToUpper(notice.Severity), notice.Subject),
+		"attachments": []map[string]interface{}{{
+			"color":  slackColors[notice.Severity],
+			"text":   notice.Message,
+			"fields": fields,
+			"ts":     notice.CreatedAt.Unix(),
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	return postAll(t.Client, recipients, data, "slack webhook")
+}
+
+// PagerDutyTransport triggers PagerDuty events of the notice for the routing keys
+type PagerDutyTransport struct {
+	Client *http.Client
+	// URL is the Events API v2 endpoint, PagerDutyEventsURL if empty
+	URL string
+}
+
+// Send triggers an event for every routing key, the repeated alerts of the same kind, tenant and source are
+// deduplicated into one PagerDuty incident
+func (t *PagerDutyTransport) Send(recipients []string, notice Notice) error {
+	endpoint := util.AssignString(t.URL, PagerDutyEventsURL)
+	var lastErr error
+	for _, key := range recipients {
+		data, err := json.Marshal(map[string]interface{}{
+			"routing_key":  key,
+			"event_action": "trigger",
+			"dedup_key":    strings.Join([]string{"burnell", notice.Kind, notice.Tenant, notice.Source}, "/"),
+			"payload": map[string]interface{}{
+				"summary":        notice.Subject,
+				"source":         util.AssignString(notice.Source, "burnell"),
+				"severity":       notice.Severity,
+				"component":      notice.Tenant,
+				"group":          notice.Kind,
+				"timestamp":      notice.CreatedAt.UTC().Format(time.RFC3339),
+				"custom_details": map[string]string{"message": notice.Message},
+			},
+		})
+		if err != nil {
+			return err
+		}
+		if err := postAll(t.Client, []string{endpoint}, data, "pagerduty events"); err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// postAll posts the json body to every URL and returns the last failure
+func postAll(client *http.Client, urls []string, data []byte, target string) error {
+	var lastErr error
+	for _, u := range urls {
+		resp, err := client.Post(u, "application/json", bytes.NewReader(data))
+		if resp != nil {
+			resp.Body.Close()
+		}
+		if err != nil {
+			lastErr = err
+		} else if resp.StatusCode > 299 {
+			lastErr = fmt.Errorf("%s failure status code %d", target, resp.StatusCode)
+		}
+	}
+	return lastErr
+}
+
+// Alerter dispatches the operator alerts to the transports of the routes matching the alert kind and severity.
+// Repeated alerts of the same kind, severity, tenant and source are suppressed within the interval.
+type Alerter struct {
+	Webhook   Transport
+	Slack     Transport
+	PagerDuty Transport
+	Interval  time.Duration
+
+	routes   []util.AlertRoute
+	lastSent map[string]time.Time
+	lock     sync.Mutex
+}
+
+// NewAlerter creates an alerter without any route
+func NewAlerter(webhook, slack, pagerDuty Transport, interval time.Duration) *Alerter {
+	return &Alerter{
+		Webhook:   webhook,
+		Slack:     slack,
+		PagerDuty: pagerDuty,
+		Interval:  interval,
+		lastSent:  make(map[string]time.Time),
+	}
+}
+
+func newAlertClient() *http.Client {
+	return &http.Client{Timeout: 10 * time.Second}
+}
+
+// Alerts is the global operator alerter
+var Alerts = NewAlerter(&WebhookTransport{Client: newAlertClient()}, &SlackTransport{Client: newAlertClient()},
+	&PagerDutyTransport{Client: newAlertClient()}, time.Hour)
+
+// InitAlerts sets the AlertRoutes in the configuration and alerts the internal Pulsar client failures
+func InitAlerts() error {
+	Alerts.Interval = time.Duration(util.GetEnvInt("NotificationIntervalMinutes", 60)) * time.Minute
+	if err := Alerts.SetRoutes(util.GetConfig().AlertRoutes); err != nil {
+		return err
+	}
+	util.OnPulsarClientFailed(func(c util.PulsarClientStatus) {
+		go Alerts.Raise(Notice{
+			Kind:     ListenerFailure,
+			Severity: SeverityCritical,
+			Source:   c.Name,
+			Subject:  fmt.Sprintf("burnell %s failed", c.Name),
+			Message:  fmt.Sprintf("internal Pulsar client %s of topic %s failed %s, reconnected %d times", c.Name, c.Topic, c.LastError, c.Reconnects),
+		})
+	})
+	return nil
+}
+
+// SetRoutes validates and replaces the routes
+func (a *Alerter) SetRoutes(routes []util.AlertRoute) error {
+	names := make(map[string]bool, len(routes))
+	for _, r := range routes {
+		if r.Name == "" {
+			return fmt.Errorf("alert route requires a name")
+		}
+		if names[r.Name] {
+			return fmt.Errorf("duplicate alert route %s", r.Name)
+		}
+		names[r.Name] = true
+		if _, ok := severityLevels[r.Severity]; !ok {
+			return fmt.Errorf("alert route %s severity %s is not %s, %s or %s", r.Name, r.Severity, SeverityInfo, SeverityWarning, SeverityCritical)
+		}
+		if len(r.Webhooks) == 0 && len(r.SlackWebhooks) == 0 && len(r.PagerDutyRoutingKeys) == 0 {
+			return fmt.Errorf("alert route %s requires a webhook, a Slack webhook or a PagerDuty routing key", r.Name)
+		}
+		for _, webhook := range append(append([]string{}, r.Webhooks...), r.SlackWebhooks...) {
+			if u, err := url.ParseRequestURI(webhook); err != nil || !(u.Scheme == "http" || u.Scheme == "https") {
+				return fmt.Errorf("alert route %s webhook must be a http or https URL", r.Name)
+			}
+		}
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.routes = routes
+	return nil
+}
+
+// Raise sends the alert to the transports of the matched routes, an alert without a severity is a warning.
+// It returns the number of the matched routes, 0 if the alert is suppressed.
+func (a *Alerter) Raise(notice Notice) int {
+	if notice.CreatedAt.IsZero() {
+		notice.CreatedAt = time.Now()
+	}
+	if _, ok := severityLevels[notice.Severity]; !ok {
+		notice.Severity = SeverityWarning
+	}
+	level := severityLevels[notice.Severity]
+
+	a.lock.Lock()
+	matched := []util.AlertRoute{}
+	for _, r := range a.routes {
+		if level >= severityLevels[r.Severity] && (len(r.Kinds) == 0 || util.StrContains(r.Kinds, notice.Kind)) {
+			matched = append(matched, r)
+		}
+	}
+	if len(matched) == 0 {
+		a.lock.Unlock()
+		return 0
+	}
+	key := strings.Join([]string{notice.Kind, notice.Severity, notice.Tenant, notice.Source}, "/")
+	if last, ok := a.lastSent[key]; ok && time.Since(last) < a.Interval {
+		a.lock.Unlock()
+		alertCounter.WithLabelValues(notice.Kind, notice.Severity, "none", "suppressed").Inc()
+		return 0
+	}
+	a.lastSent[key] = time.Now()
+	a.lock.Unlock()
+
+	for _, r := range matched {
+		a.send(webhookTransport, a.Webhook, r.Webhooks, notice)
+		a.send(slackTransport, a.Slack, r.SlackWebhooks, notice)
+		a.send(pagerDutyTransport, a.PagerDuty, r.PagerDutyRoutingKeys, notice)
+	}
+	return len(matched)
+}
+
+func (a *Alerter) send(name string, transport Transport, recipients []string, notice Notice) {
+	if transport == nil || len(recipients) == 0 {
+		return
+	}
+	if err := transport.Send(recipients, notice); err != nil {
+		logger.Errorf("failed to send %s %s alert to %s error %v", notice.Severity, notice.Kind, name, err)
+		alertCounter.WithLabelValues(notice.Kind, notice.Severity, name, "failed").Inc()
+		return
+	}
+	alertCounter.WithLabelValues(notice.Kind, notice.Severity, name, "sent").Inc()
+}
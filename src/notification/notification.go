@@ -0,0 +1,195 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package notification
+
+// notification delivers tenant notices to the contacts specified in the tenant plan
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/datastax/burnell/src/util"
+)
+
+const (
+	// QuotaWarning is the notice when a tenant reaches the plan limit
+	QuotaWarning = "quota-warning"
+	// Expiration is the notice for an expiring plan or credential
+	Expiration = "expiration"
+	// Maintenance is the notice for a scheduled maintenance
+	Maintenance = "maintenance"
+	// UsageAnomaly is the alert when a tenant usage is out of the historical pattern
+	UsageAnomaly = "usage-anomaly"
+	// UsageReport is the scheduled or on demand usage summary of a tenant
+	UsageReport = "usage-report"
+	// PlanChange is the tenant plan change event matched by a plan change rule
+	PlanChange = "plan-change"
+)
+
+// Notice is the notification sent to a tenant
+type Notice struct {
+	Tenant    string    `json:"tenant"`
+	Kind      string    `json:"kind"`
+	Subject   string    `json:"subject"`
+	Message   string    `json:"message"`
+	CreatedAt time.Time `json:"createdAt"`
+	// Data is the JSON document of the notice such as the usage report, the email only has the message
+	Data json.RawMessage `json:"data,omitempty"`
+	// Severity and Source are the severity and the component such as the Pulsar client name of an operator alert
+	Severity string `json:"severity,omitempty"`
+	Source   string `json:"source,omitempty"`
+}
+
+// Transport delivers a notice to a list of recipients
+type Transport interface {
+	Send(recipients []string, notice Notice) error
+}
+
+// SMTPTransport sends notice as email
+type SMTPTransport struct {
+	Addr     string
+	From     string
+	Username string
+	Password string
+}
+
+// Send sends an email to all recipients
+func (t *SMTPTransport) Send(recipients []string, notice Notice) error {
+	var auth smtp.Auth
+	if t.Username != "" {
+		host := strings.Split(t.Addr, ":")[0]
+		auth = smtp.PlainAuth("", t.Username, t.Password, host)
+	}
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s\r\n",
+		t.From, strings.Join(recipients, ","), notice.Subject, notice.Message)
+	return smtp.SendMail(t.Addr, auth, t.From, recipients, []byte(msg))
+}
+
+// WebhookTransport posts notice as a json object to webhook URLs
+type WebhookTransport struct {
+	Client *http.Client
+}
+
+// Send posts the notice to every webhook URL
+func (t *WebhookTransport) Send(recipients []string, notice Notice) error {
+	data, err := json.Marshal(notice)
+	if err != nil {
+		return err
+	}
+	var lastErr error
+	for _, u := range recipients {
+		resp, err := t.Client.Post(u, "application/json", bytes.NewReader(data))
+		if resp != nil {
+			resp.Body.Close()
+		}
+		if err != nil {
+			lastErr = err
+		} else if resp.StatusCode > 299 {
+			lastErr = fmt.Errorf("webhook %s failure status code %d", u, resp.StatusCode)
+		}
+	}
+	return lastErr
+}
+
+// Service dispatches notices to email and webhook transports.
+// Repeated notices of the same kind to the same tenant are suppressed within the interval, except maintenance notices,
+// usage reports, plan changes and expirations that are sent once per expiry.
+type Service struct {
+	Email    Transport
+	Webhook  Transport
+	Interval time.Duration
+
+	lastSent map[string]time.Time
+	lock     sync.Mutex
+}
+
+// Notifier is the global notification service
+var Notifier = NewService(nil, &WebhookTransport{Client: &http.Client{Timeout: 10 * time.Second}}, time.Hour)
+
+var logger = log.WithFields(log.Fields{"app": "notification"})
+
+// NewService creates a notification service
+func NewService(email, webhook Transport, interval time.Duration) *Service {
+	return &Service{
+		Email:    email,
+		Webhook:  webhook,
+		Interval: interval,
+		lastSent: make(map[string]time.Time),
+	}
+}
+
+// Init sets up the global notification service based on the configuration
+func Init() {
+	cfg := util.GetConfig()
+	var email Transport
+	if cfg.SMTPAddr != "" {
+		email = &SMTPTransport{
+			Addr:     cfg.SMTPAddr,
+			From:     util.AssignString(cfg.SMTPFrom, "burnell@localhost"),
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+		}
+	} else {
+		logger.Infof("SMTP is not configured, email notification is disabled")
+	}
+	interval := time.Duration(util.GetEnvInt("NotificationIntervalMinutes", 60)) * time.Minute
+	Notifier = NewService(email, &WebhookTransport{Client: &http.Client{Timeout: 10 * time.Second}}, interval)
+}
+
+// Notify sends the notice to emails and webhooks. It returns false if the notice is suppressed.
+func (s *Service) Notify(emails, webhooks []string, notice Notice) bool {
+	if len(emails) == 0 && len(webhooks) == 0 {
+		return false
+	}
+	if notice.CreatedAt.IsZero() {
+		notice.CreatedAt = time.Now()
+	}
+	if notice.Kind != Maintenance && notice.Kind != UsageReport && notice.Kind != PlanChange && notice.Kind != Expiration {
+		key := notice.Tenant + "/" + notice.Kind
+		s.lock.Lock()
+		if last, ok := s.lastSent[key]; ok && time.Since(last) < s.Interval {
+			s.lock.Unlock()
+			return false
+		}
+		s.lastSent[key] = time.Now()
+		s.lock.Unlock()
+	}
+
+	if s.Email != nil && len(emails) > 0 {
+		if err := s.Email.Send(emails, notice); err != nil {
+			logger.Errorf("failed to email tenant %s %s notice error %v", notice.Tenant, notice.Kind, err)
+		}
+	}
+	if s.Webhook != nil && len(webhooks) > 0 {
+		if err := s.Webhook.Send(webhooks, notice); err != nil {
+			logger.Errorf("failed to post tenant %s %s notice to webhook error %v", notice.Tenant, notice.Kind, err)
+		}
+	}
+	return true
+}
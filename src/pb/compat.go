@@ -0,0 +1,96 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package pb
+
+// compatibility shim for the function metadata fields added by newer Pulsar versions than the generated code
+
+import (
+	"fmt"
+	"unicode/utf8"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// newerFunctionDetailsFields are the FunctionDetails fields added by newer Pulsar versions, by the field number.
+// The bool fields are decoded from varint.
+var newerFunctionDetailsFields = map[protowire.Number]struct {
+	name   string
+	isBool bool
+}{
+	19: {name: "customRuntimeOptions"},
+	20: {name: "builtin"},
+	21: {name: "retainOrdering", isBool: true},
+	22: {name: "retainKeyOrdering", isBool: true},
+	23: {name: "subscriptionPosition"},
+}
+
+// FunctionDetailsExtras decodes the wire fields unknown to the generated code, so they are exposed instead of silently dropped.
+// The key is the field name if it is a known newer Pulsar field, otherwise field<number>.
+func FunctionDetailsExtras(fd *FunctionDetails) (map[string]interface{}, error) {
+	extras := make(map[string]interface{})
+	if fd == nil {
+		return extras, nil
+	}
+	b := fd.ProtoReflect().GetUnknown()
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return extras, protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		var value interface{}
+		switch typ {
+		case protowire.VarintType:
+			v, m := protowire.ConsumeVarint(b)
+			n, value = m, v
+			if f, ok := newerFunctionDetailsFields[num]; ok && f.isBool {
+				value = v != 0
+			}
+		case protowire.Fixed32Type:
+			value, n = protowire.ConsumeFixed32(b)
+		case protowire.Fixed64Type:
+			value, n = protowire.ConsumeFixed64(b)
+		case protowire.BytesType:
+			v, m := protowire.ConsumeBytes(b)
+			n, value = m, v
+			if utf8.Valid(v) {
+				value = string(v)
+			}
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return extras, protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		name := fmt.Sprintf("field%d", num)
+		if f, ok := newerFunctionDetailsFields[num]; ok {
+			name = f.name
+		}
+		if value != nil {
+			extras[name] = value
+		}
+	}
+	return extras, nil
+}
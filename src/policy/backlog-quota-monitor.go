@@ -0,0 +1,289 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package policy
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/datastax/burnell/src/audit"
+	"github.com/datastax/burnell/src/metrics"
+	"github.com/datastax/burnell/src/notification"
+	"github.com/datastax/burnell/src/util"
+)
+
+// the remediations of a namespace over the backlog quota threshold
+const (
+	// NotifyRemediation notifies the tenant
+	NotifyRemediation = "notify"
+	// ExpandRemediation doubles the namespace backlog quota up to the plan backlogQuotaMB
+	ExpandRemediation = "expand"
+	// SkipRemediation skips all messages in the backlog of the subscriptions on the over quota topics
+	SkipRemediation = "skip"
+)
+
+// the namespace backlog quota policy name in the broker admin REST API
+const destinationStorage = "destination_storage"
+
+// BacklogQuota is a namespace backlog quota of the broker admin REST API
+type BacklogQuota struct {
+	Limit  int64  `json:"limit"`
+	Policy string `json:"policy"`
+}
+
+// BacklogRemediation is the result of a remediation action
+type BacklogRemediation struct {
+	Action string `json:"action"`
+	Detail string `json:"detail,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// NamespaceBacklogStatus is a namespace with topics over the backlog quota threshold
+type NamespaceBacklogStatus struct {
+	Namespace       string               `json:"namespace"`
+	Tenant          string               `json:"tenant"`
+	MaxUsagePercent float64              `json:"maxUsagePercent"`
+	QuotaBytes      float64              `json:"quotaBytes"`
+	OverQuotaTopics []string             `json:"overQuotaTopics"`
+	Remediations    []BacklogRemediation `json:"remediations,omitempty"`
+	CheckedAt       time.Time            `json:"checkedAt"`
+}
+
+var (
+	backlogQuotaThreshold      = float64(util.GetEnvInt("BacklogQuotaThresholdPercent", 90))
+	backlogRemediationCooldown = time.Duration(util.GetEnvInt("BacklogQuotaRemediationCooldownMinutes", 30)) * time.Minute
+
+	backlogStatuses     = []NamespaceBacklogStatus{}
+	backlogRemediated   = make(map[string]time.Time)
+	backlogStatusesLock = sync.RWMutex{}
+
+	backlogLog = log.WithFields(log.Fields{"app": "backlog-quota-monitor"})
+)
+
+// BacklogRemediations returns the configured remediation actions in BacklogQuotaRemediation
+func BacklogRemediations() []string {
+	actions := []string{}
+	for _, a := range strings.Split(util.GetConfig().BacklogQuotaRemediation, ",") {
+		if a = strings.ToLower(strings.TrimSpace(a)); a != "" {
+			actions = append(actions, a)
+		}
+	}
+	return actions
+}
+
+// DetectBacklogQuota groups the topics at or over the threshold percentage of their backlog quota by the namespace
+func DetectBacklogQuota(backlogs []metrics.TopicBacklog, thresholdPercent float64, now time.Time) []NamespaceBacklogStatus {
+	namespaces := make(map[string]*NamespaceBacklogStatus)
+	for _, b := range backlogs {
+		usage := b.UsagePercent()
+		if b.Namespace == "" || b.QuotaBytes <= 0 || usage < thresholdPercent {
+			continue
+		}
+		status, ok := namespaces[b.Namespace]
+		if !ok {
+			status = &NamespaceBacklogStatus{
+				Namespace: b.Namespace,
+				Tenant:    strings.Split(b.Namespace, "/")[0],
+				CheckedAt: now,
+			}
+			namespaces[b.Namespace] = status
+		}
+		status.OverQuotaTopics = append(status.OverQuotaTopics, b.Topic)
+		if usage > status.MaxUsagePercent {
+			status.MaxUsagePercent = usage
+			status.QuotaBytes = b.QuotaBytes
+		}
+	}
+	statuses := []NamespaceBacklogStatus{}
+	for _, s := range namespaces {
+		sort.Strings(s.OverQuotaTopics)
+		statuses = append(statuses, *s)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Namespace < statuses[j].Namespace })
+	return statuses
+}
+
+// expandBacklogQuota doubles the namespace backlog quota, capped by the plan backlog quota
+func expandBacklogQuota(status NamespaceBacklogStatus) (string, error) {
+	plan, err := TenantManager.GetTenant(status.Tenant)
+	if err != nil {
+		plan = newFreeTenantPlan(status.Tenant)
+	}
+	quotas := make(map[string]BacklogQuota)
+	if _, err := adminAPIRequest(http.MethodGet, "namespaces/"+status.Namespace+"/backlogQuotaMap", nil, &quotas); err != nil {
+		return "", err
+	}
+	quota := quotas[destinationStorage]
+	if quota.Limit <= 0 {
+		// the namespace is under the broker default quota
+		quota.Limit = int64(status.QuotaBytes)
+	}
+	quota.Policy = util.AssignString(quota.Policy, "producer_request_hold")
+	current := quota.Limit
+	quota.Limit *= 2
+	if planMB := PlanBacklogQuotaMB(plan); planMB > 0 {
+		max := int64(planMB) * 1024 * 1024
+		if current >= max {
+			return "", fmt.Errorf("backlog quota %d bytes is at the plan limit", current)
+		}
+		if quota.Limit > max {
+			quota.Limit = max
+		}
+	}
+	if _, err := adminAPIRequest(http.MethodPost, "namespaces/"+status.Namespace+"/backlogQuota", quota, nil); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("backlog quota expanded from %d to %d bytes", current, quota.Limit), nil
+}
+
+// skipBacklog skips all messages of the subscriptions on the over quota topics
+func skipBacklog(status NamespaceBacklogStatus) (string, error) {
+	skipped := 0
+	for _, topic := range status.OverQuotaTopics {
+		path := strings.Replace(topic, "://", "/", 1)
+		subs := []string{}
+		if _, err := adminAPIRequest(http.MethodGet, path+"/subscriptions", nil, &subs); err != nil {
+			return fmt.Sprintf("%d subscriptions skipped", skipped), err
+		}
+		for _, sub := range subs {
+			if _, err := adminAPIRequest(http.MethodPost, path+"/subscription/"+url.PathEscape(sub)+"/skip_all", nil, nil); err != nil {
+				return fmt.Sprintf("%d subscriptions skipped", skipped), err
+			}
+			skipped++
+		}
+	}
+	return fmt.Sprintf("%d subscriptions skipped", skipped), nil
+}
+
+// RemediateBacklogQuota runs the remediation actions on the namespace, every action is recorded in the audit
+func RemediateBacklogQuota(status NamespaceBacklogStatus, actions []string) []BacklogRemediation {
+	results := []BacklogRemediation{}
+	for _, action := range actions {
+		result := BacklogRemediation{Action: action}
+		var err error
+		switch action {
+		case NotifyRemediation:
+			if TenantManager.NotifyTenant(status.Tenant, notification.QuotaWarning, "backlog quota reached",
+				fmt.Sprintf("namespace %s topics %s are at %.0f%% of the backlog quota", status.Namespace,
+					strings.Join(status.OverQuotaTopics, ","), status.MaxUsagePercent)) {
+				result.Detail = "tenant notified"
+			} else {
+				result.Detail = "tenant opted out or has no contacts"
+			}
+		case ExpandRemediation:
+			result.Detail, err = expandBacklogQuota(status)
+		case SkipRemediation:
+			result.Detail, err = skipBacklog(status)
+		default:
+			err = fmt.Errorf("unknown remediation %s", action)
+		}
+		auditStatus := http.StatusOK
+		if err != nil {
+			result.Error = err.Error()
+			auditStatus = http.StatusInternalServerError
+			backlogLog.Errorf("namespace %s backlog quota remediation %s error %v", status.Namespace, action, err)
+		}
+		if action != NotifyRemediation && err == nil {
+			RecordTenantEvent(TenantEvent{
+				Tenant:   status.Tenant,
+				Category: QuotaEventCategory,
+				Type:     "backlog-quota-" + action,
+				Summary:  "namespace " + status.Namespace + " " + result.Detail,
+			})
+		}
+		audit.Record(audit.Event{
+			Subject:  "burnell",
+			Tenant:   status.Tenant,
+			Action:   "backlog-quota-" + action,
+			Resource: status.Namespace,
+			Detail:   util.AssignString(result.Detail, result.Error),
+			Status:   auditStatus,
+		})
+		results = append(results, result)
+	}
+	return results
+}
+
+// CheckBacklogQuota detects the namespaces over the backlog quota threshold and remediates them,
+// a namespace is remediated at most once within the cooldown
+func CheckBacklogQuota(getBacklogs func() ([]metrics.TopicBacklog, error), actions []string, now time.Time) ([]NamespaceBacklogStatus, error) {
+	backlogs, err := getBacklogs()
+	if err != nil {
+		return nil, err
+	}
+	statuses := DetectBacklogQuota(backlogs, backlogQuotaThreshold, now)
+
+	backlogStatusesLock.Lock()
+	defer backlogStatusesLock.Unlock()
+	for i, s := range statuses {
+		if len(actions) == 0 {
+			continue
+		}
+		if last, ok := backlogRemediated[s.Namespace]; ok && now.Sub(last) < backlogRemediationCooldown {
+			continue
+		}
+		backlogRemediated[s.Namespace] = now
+		statuses[i].Remediations = RemediateBacklogQuota(s, actions)
+	}
+	for ns, last := range backlogRemediated {
+		if now.Sub(last) >= backlogRemediationCooldown {
+			delete(backlogRemediated, ns)
+		}
+	}
+	backlogStatuses = statuses
+	return statuses, nil
+}
+
+// GetBacklogQuotaStatuses returns the namespaces over the backlog quota threshold in the last check
+func GetBacklogQuotaStatuses() []NamespaceBacklogStatus {
+	backlogStatusesLock.RLock()
+	defer backlogStatusesLock.RUnlock()
+	return backlogStatuses
+}
+
+// BacklogQuotaMonitor periodically checks the backlog quota of the namespaces from the federated metrics,
+// it is disabled unless BacklogQuotaMonitorIntervalSeconds is set as an environment variable
+func BacklogQuotaMonitor(getBacklogs func() ([]metrics.TopicBacklog, error)) {
+	interval := time.Duration(util.GetEnvInt("BacklogQuotaMonitorIntervalSeconds", 0)) * time.Second
+	if interval <= 0 {
+		return
+	}
+	actions := BacklogRemediations()
+	backlogLog.Infof("check namespace backlog quota every %v with remediations %v", interval, actions)
+	go func() {
+		ticker := time.NewTicker(interval)
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := CheckBacklogQuota(getBacklogs, actions, time.Now()); err != nil {
+					backlogLog.Errorf("backlog quota check error %v", err)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,202 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package policy
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/datastax/burnell/src/metrics"
+	"github.com/datastax/burnell/src/util"
+)
+
+// the bundle split algorithms of the broker
+const (
+	RangeEquallyDivide      = "range_equally_divide"
+	TopicCountEquallyDivide = "topic_count_equally_divide"
+)
+
+// BundleSplitAlgorithms are the split algorithms accepted by a bundle split
+var BundleSplitAlgorithms = []string{RangeEquallyDivide, TopicCountEquallyDivide}
+
+// the bundle range {lower}_{upper} of the 32 bit hash
+var bundleRangeRegex = regexp.MustCompile(`^0x[0-9a-fA-F]{8}_0x[0-9a-fA-F]{8}$`)
+
+// BundleSplitThresholds is the bundle load over which a split is advised, the defaults are the broker load balancer defaults
+type BundleSplitThresholds struct {
+	MsgRate         float64 `json:"msgRate"`
+	ThroughputBytes float64 `json:"throughputBytes"`
+	Topics          int     `json:"topics"`
+	Sessions        int     `json:"sessions"`
+	// MaxBundles is the max bundles of a namespace, no split is advised at the max
+	MaxBundles int `json:"maxBundles"`
+}
+
+// DefaultBundleSplitThresholds returns the split thresholds configured by the environment variables
+func DefaultBundleSplitThresholds() BundleSplitThresholds {
+	return BundleSplitThresholds{
+		MsgRate:         float64(util.GetEnvInt("BundleSplitMaxMsgRate", 30000)),
+		ThroughputBytes: float64(util.GetEnvInt("BundleSplitMaxThroughputMB", 100)) * 1024 * 1024,
+		Topics:          util.GetEnvInt("BundleSplitMaxTopics", 1000),
+		Sessions:        util.GetEnvInt("BundleSplitMaxSessions", 1000),
+		MaxBundles:      util.GetEnvInt("BundleSplitMaxBundles", 128),
+	}
+}
+
+// BundleSplitAdvice is a bundle advised to split with the reasons and the suggested split algorithm
+type BundleSplitAdvice struct {
+	Bundle    string   `json:"bundle"`
+	Tenant    string   `json:"tenant"`
+	Namespace string   `json:"namespace"`
+	Range     string   `json:"range"`
+	Broker    string   `json:"broker,omitempty"`
+	Reasons   []string `json:"reasons"`
+	// Severity is the largest load over its threshold, the advices are sorted by the severity
+	Severity  float64            `json:"severity"`
+	Algorithm string             `json:"algorithm"`
+	SplitPath string             `json:"splitPath"`
+	Load      metrics.BundleLoad `json:"load"`
+}
+
+// AdviseBundleSplits inspects the bundle loads and advises to split the bundles over any threshold.
+// A bundle with a single topic is not advised since a split can't divide a topic,
+// neither are the bundles of a namespace with the max bundles.
+func AdviseBundleSplits(loads []metrics.BundleLoad, thresholds BundleSplitThresholds) []BundleSplitAdvice {
+	bundles := make(map[string]int)
+	for _, b := range loads {
+		bundles[b.Namespace]++
+	}
+	advices := []BundleSplitAdvice{}
+	for _, b := range loads {
+		if b.Topics == 1 || (thresholds.MaxBundles > 0 && bundles[b.Namespace] >= thresholds.MaxBundles) {
+			continue
+		}
+		advice := BundleSplitAdvice{
+			Bundle:    b.Bundle,
+			Tenant:    strings.Split(b.Namespace, "/")[0],
+			Namespace: b.Namespace,
+			Range:     b.Range,
+			Broker:    b.Broker,
+			Algorithm: RangeEquallyDivide,
+			Load:      b,
+		}
+		over := func(reason string, value, threshold float64) bool {
+			if threshold <= 0 || value <= threshold {
+				return false
+			}
+			advice.Reasons = append(advice.Reasons, fmt.Sprintf("%s %.0f over %.0f", reason, value, threshold))
+			advice.Severity = math.Max(advice.Severity, value/threshold)
+			return true
+		}
+		over("msg rate", b.MsgRateIn+b.MsgRateOut, thresholds.MsgRate)
+		over("throughput bytes", b.ThroughputIn+b.ThroughputOut, thresholds.ThroughputBytes)
+		// the topics and the sessions are divided evenly only by the topic count
+		if over("topics", float64(b.Topics), float64(thresholds.Topics)) ||
+			over("sessions", float64(b.Producers+b.Consumers), float64(thresholds.Sessions)) {
+			advice.Algorithm = TopicCountEquallyDivide
+		}
+		if len(advice.Reasons) == 0 {
+			continue
+		}
+		advice.SplitPath = "/admin/v2/namespaces/" + b.Namespace + "/" + b.Range + "/split?unload=true&splitAlgorithmName=" + advice.Algorithm
+		advices = append(advices, advice)
+	}
+	sort.SliceStable(advices, func(i, j int) bool { return advices[i].Severity > advices[j].Severity })
+	return advices
+}
+
+// GetBundleSplitAdvices returns the split advices of the bundles under the tenant, or all tenants if empty,
+// from the bundle loads in the federated metrics cache
+func GetBundleSplitAdvices(tenant string) ([]BundleSplitAdvice, error) {
+	loads, err := metrics.GetBundleLoads()
+	if err != nil {
+		return nil, err
+	}
+	advices := []BundleSplitAdvice{}
+	for _, advice := range AdviseBundleSplits(loads, DefaultBundleSplitThresholds()) {
+		if tenant == "" || advice.Tenant == tenant {
+			advices = append(advices, advice)
+		}
+	}
+	return advices, nil
+}
+
+// ValidateBundleRange ensures the bundle is a {lower}_{upper} hash range with the lower boundary under the upper one
+func ValidateBundleRange(bundle string) error {
+	if !bundleRangeRegex.MatchString(bundle) {
+		return fmt.Errorf("bundle %s is not a hash range like 0x00000000_0xffffffff", bundle)
+	}
+	boundaries := strings.Split(bundle, "_")
+	lower, _ := strconv.ParseUint(boundaries[0][2:], 16, 32)
+	upper, _ := strconv.ParseUint(boundaries[1][2:], 16, 32)
+	if lower >= upper {
+		return fmt.Errorf("bundle %s lower boundary is not under the upper boundary", bundle)
+	}
+	return nil
+}
+
+// namespaceBundles is the bundle boundaries of a namespace in the broker admin REST API
+type namespaceBundles struct {
+	Boundaries []string `json:"boundaries"`
+	NumBundles int      `json:"numBundles"`
+}
+
+// NamespaceBundles returns the bundle ranges of the namespace
+func NamespaceBundles(namespace string) ([]string, error) {
+	bundles := namespaceBundles{}
+	if _, err := adminAPIRequest(http.MethodGet, "namespaces/"+namespace+"/bundles", nil, &bundles); err != nil {
+		return nil, err
+	}
+	ranges := []string{}
+	for i := 0; i+1 < len(bundles.Boundaries); i++ {
+		ranges = append(ranges, bundles.Boundaries[i]+"_"+bundles.Boundaries[i+1])
+	}
+	return ranges, nil
+}
+
+// ValidateBundleOperation ensures the bundle is a current bundle of the namespace before it is split or unloaded,
+// a split requires a known algorithm if specified, and the namespace under the max bundles.
+// It returns the HTTP status code of the validation failure.
+func ValidateBundleOperation(namespace, bundle string, split bool, algorithm string) (int, error) {
+	if err := ValidateBundleRange(bundle); err != nil {
+		return http.StatusUnprocessableEntity, err
+	}
+	if split && algorithm != "" && !util.StrContains(BundleSplitAlgorithms, algorithm) {
+		return http.StatusUnprocessableEntity, fmt.Errorf("split algorithm %s is not one of %s", algorithm, strings.Join(BundleSplitAlgorithms, ","))
+	}
+	ranges, err := NamespaceBundles(namespace)
+	if err != nil {
+		return http.StatusBadGateway, err
+	}
+	if !util.StrContains(ranges, strings.ToLower(bundle)) {
+		return http.StatusNotFound, fmt.Errorf("bundle %s is not a current bundle of namespace %s", bundle, namespace)
+	}
+	if max := DefaultBundleSplitThresholds().MaxBundles; split && max > 0 && len(ranges) >= max {
+		return http.StatusUnprocessableEntity, fmt.Errorf("namespace %s already has the max %d bundles", namespace, max)
+	}
+	return http.StatusOK, nil
+}
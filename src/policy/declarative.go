@@ -0,0 +1,317 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package policy
+
+// declarative tenant configuration to manage the tenants of a cluster from a git repository
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/datastax/burnell/src/util"
+	"github.com/ghodss/yaml"
+)
+
+// the kinds of the declarative changes
+const (
+	TenantPlanChange   = "tenant-plan"
+	PulsarTenantChange = "pulsar-tenant"
+	NamespaceChange    = "namespace"
+	RetentionChange    = "retention"
+	MessageTTLChange   = "message-ttl"
+	TokenChange        = "token"
+)
+
+// the actions of the declarative changes
+const (
+	CreateAction = "create"
+	UpdateAction = "update"
+	IssueAction  = "issue"
+)
+
+// DeclaredNamespace is the desired namespace and its policies, a nil policy is not managed
+type DeclaredNamespace struct {
+	Name              string             `json:"name"`
+	Retention         *RetentionPolicies `json:"retention,omitempty"`
+	MessageTTLSeconds *int               `json:"messageTTLSeconds,omitempty"`
+}
+
+// DeclaredToken is a token to issue for the subject
+type DeclaredToken struct {
+	Subject string `json:"subject"`
+	Exp     string `json:"exp,omitempty"`
+	Alg     string `json:"alg,omitempty"`
+}
+
+// DeclaredTenant is the desired tenant plan with the namespaces and tokens of the tenant
+type DeclaredTenant struct {
+	TenantPlan
+	Namespaces []DeclaredNamespace `json:"namespaces,omitempty"`
+	Tokens     []DeclaredToken     `json:"tokens,omitempty"`
+}
+
+// DeclarativeConfig is the desired state of the tenants, the tenants not in the document are left as is
+type DeclarativeConfig struct {
+	Tenants []DeclaredTenant `json:"tenants"`
+}
+
+// ApplyChange is a change between the desired and the current state
+type ApplyChange struct {
+	Kind     string   `json:"kind"`
+	Tenant   string   `json:"tenant"`
+	Resource string   `json:"resource"`
+	Action   string   `json:"action"`
+	Fields   []string `json:"fields,omitempty"`
+	Applied  bool     `json:"applied"`
+	Error    string   `json:"error,omitempty"`
+	// Token is the issued token of a token change
+	Token string `json:"token,omitempty"`
+}
+
+// ApplyResult is the changes of an apply, the changes are computed but not applied in a dry run
+type ApplyResult struct {
+	DryRun    bool          `json:"dryRun"`
+	Changes   []ApplyChange `json:"changes"`
+	Unchanged int           `json:"unchanged"`
+	Failed    int           `json:"failed"`
+}
+
+// ParseDeclarativeConfig parses a YAML or JSON document, unknown fields are rejected
+func ParseDeclarativeConfig(data []byte) (DeclarativeConfig, error) {
+	var cfg DeclarativeConfig
+	jsonData, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return cfg, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(jsonData))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&cfg); err != nil {
+		return cfg, err
+	}
+	return cfg, cfg.Validate()
+}
+
+// Validate validates the whole document before any change is applied
+func (cfg DeclarativeConfig) Validate() error {
+	seen := map[string]bool{}
+	for i, t := range cfg.Tenants {
+		if t.Name == "" {
+			return fmt.Errorf("tenants[%d] name is required", i)
+		}
+		if seen[t.Name] {
+			return fmt.Errorf("tenant %s is declared more than once", t.Name)
+		}
+		seen[t.Name] = true
+		if err := ValidateTenantName(t.Name, false); err != nil {
+			return err
+		}
+		if t.PlanType != "" && getPlanPolicy(strings.ToLower(t.PlanType)) == nil {
+			return fmt.Errorf("tenant %s invalid plan type %s", t.Name, t.PlanType)
+		}
+		if err := ValidateTenantPlan(t.TenantPlan); err != nil {
+			return err
+		}
+		for _, ns := range t.Namespaces {
+			if ns.Name == "" || strings.Contains(ns.Name, "/") {
+				return fmt.Errorf("tenant %s invalid namespace name %q", t.Name, ns.Name)
+			}
+		}
+		for _, token := range t.Tokens {
+			if token.Subject == "" {
+				return fmt.Errorf("tenant %s token subject is required", t.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// DiffTenantPlan returns the reconciled desired plan and the changed fields against the existing plan,
+// an empty existing plan is a new tenant
+func DiffTenantPlan(desired, existing TenantPlan) (TenantPlan, []string, error) {
+	desired.PlanType = util.AssignString(desired.PlanType, existing.PlanType)
+	desired.PlanType = util.AssignString(desired.PlanType, FreeTier)
+	plan, err := ReconcileTenantPlan(desired, existing)
+	if err != nil {
+		return TenantPlan{}, nil, err
+	}
+	if existing.Name == "" {
+		return plan, []string{"name"}, nil
+	}
+	fields := []string{}
+	compare := []struct {
+		name           string
+		want, existing interface{}
+	}{
+		{"planType", strings.ToLower(plan.PlanType), strings.ToLower(existing.PlanType)},
+		{"tenantStatus", plan.TenantStatus, existing.TenantStatus},
+		{"org", plan.Org, existing.Org},
+		{"users", plan.Users, existing.Users},
+		{"policy", plan.Policy, existing.Policy},
+		{"contacts", plan.Contacts, existing.Contacts},
+		{"notifications", plan.Notifications, existing.Notifications},
+		{"allowedOrigins", plan.AllowedOrigins, existing.AllowedOrigins},
+		{"allowedClusters", plan.AllowedClusters, existing.AllowedClusters},
+		{"quotaEnforcement", plan.QuotaEnforcement, existing.QuotaEnforcement},
+		{"metadata", plan.Metadata, existing.Metadata},
+		{"sso", plan.SSO, existing.SSO},
+	}
+	for _, c := range compare {
+		if !reflect.DeepEqual(c.want, c.existing) {
+			fields = append(fields, c.name)
+		}
+	}
+	return plan, fields, nil
+}
+
+// ApplyDeclarativeConfig computes the changes of the document against the current state,
+// and applies them unless it is a dry run. A failed change does not stop the others, tokens are issued by the caller.
+func (s *TenantPolicyHandler) ApplyDeclarativeConfig(cfg DeclarativeConfig, dryRun bool) ApplyResult {
+	result := ApplyResult{DryRun: dryRun, Changes: []ApplyChange{}}
+	pulsarTenants := []string{}
+	listErr := ""
+	if _, err := adminAPIRequest(http.MethodGet, "tenants", nil, &pulsarTenants); err != nil {
+		listErr = err.Error()
+	}
+
+	add := func(c ApplyChange, apply func() error) bool {
+		if c.Action == "" {
+			result.Unchanged++
+			return true
+		}
+		if c.Error == "" && !dryRun && apply != nil {
+			if err := apply(); err != nil {
+				c.Error = err.Error()
+			} else {
+				c.Applied = true
+			}
+		}
+		if c.Error != "" {
+			result.Failed++
+		}
+		result.Changes = append(result.Changes, c)
+		return c.Error == ""
+	}
+
+	for _, t := range cfg.Tenants {
+		existing, _ := s.GetTenant(t.Name)
+		desired := t.TenantPlan
+		c := ApplyChange{Kind: TenantPlanChange, Tenant: t.Name, Resource: t.Name}
+		if _, fields, err := DiffTenantPlan(desired, existing); err != nil {
+			c.Action, c.Error = UpdateAction, err.Error()
+		} else if existing.Name == "" {
+			c.Action = CreateAction
+		} else if len(fields) > 0 {
+			c.Action, c.Fields = UpdateAction, fields
+		}
+		desired.PlanType = util.AssignString(util.AssignString(desired.PlanType, existing.PlanType), FreeTier)
+		add(c, func() error {
+			_, _, err := s.UpdateTenant(t.Name, desired)
+			return err
+		})
+
+		if len(t.Namespaces) > 0 {
+			s.applyNamespaces(t, pulsarTenants, listErr, add)
+		}
+		for _, token := range t.Tokens {
+			add(ApplyChange{Kind: TokenChange, Tenant: t.Name, Resource: token.Subject, Action: IssueAction}, nil)
+		}
+	}
+	return result
+}
+
+// applyNamespaces computes and applies the Pulsar tenant, namespace, retention and message TTL changes of a tenant
+func (s *TenantPolicyHandler) applyNamespaces(t DeclaredTenant, pulsarTenants []string, listErr string,
+	add func(ApplyChange, func() error) bool) {
+	tenantExists := util.StrContains(pulsarTenants, t.Name)
+	c := ApplyChange{Kind: PulsarTenantChange, Tenant: t.Name, Resource: t.Name, Error: listErr}
+	if !tenantExists {
+		c.Action = CreateAction
+	}
+	tenantInfo := map[string]interface{}{"adminRoles": []string{}, "allowedClusters": []string{util.Config.ClusterName}}
+	if !add(c, func() error {
+		_, err := adminAPIRequest(http.MethodPut, "tenants/"+t.Name, tenantInfo, nil)
+		return err
+	}) {
+		return
+	}
+
+	namespaces := []string{}
+	if tenantExists {
+		if _, err := adminAPIRequest(http.MethodGet, "namespaces/"+t.Name, nil, &namespaces); err != nil {
+			add(ApplyChange{Kind: NamespaceChange, Tenant: t.Name, Resource: t.Name, Action: UpdateAction, Error: err.Error()}, nil)
+			return
+		}
+	}
+	for _, ns := range t.Namespaces {
+		name := t.Name + "/" + ns.Name
+		exists := util.StrContains(namespaces, name)
+		c := ApplyChange{Kind: NamespaceChange, Tenant: t.Name, Resource: name}
+		if !exists {
+			c.Action = CreateAction
+		}
+		if !add(c, func() error {
+			_, err := adminAPIRequest(http.MethodPut, "namespaces/"+name, nil, nil)
+			return err
+		}) {
+			continue
+		}
+
+		if ns.Retention != nil {
+			c := ApplyChange{Kind: RetentionChange, Tenant: t.Name, Resource: name}
+			current := RetentionPolicies{}
+			if exists {
+				if _, err := adminAPIRequest(http.MethodGet, "namespaces/"+name+"/retention", nil, &current); err != nil {
+					c.Action, c.Error = UpdateAction, err.Error()
+				}
+			}
+			if c.Error == "" && (!exists || current != *ns.Retention) {
+				c.Action = UpdateAction
+			}
+			retention := *ns.Retention
+			add(c, func() error {
+				_, err := adminAPIRequest(http.MethodPost, "namespaces/"+name+"/retention", retention, nil)
+				return err
+			})
+		}
+		if ns.MessageTTLSeconds != nil {
+			c := ApplyChange{Kind: MessageTTLChange, Tenant: t.Name, Resource: name}
+			current := 0
+			if exists {
+				if _, err := adminAPIRequest(http.MethodGet, "namespaces/"+name+"/messageTTL", nil, &current); err != nil {
+					c.Action, c.Error = UpdateAction, err.Error()
+				}
+			}
+			if c.Error == "" && (!exists || current != *ns.MessageTTLSeconds) {
+				c.Action = UpdateAction
+			}
+			ttl := *ns.MessageTTLSeconds
+			add(c, func() error {
+				_, err := adminAPIRequest(http.MethodPost, "namespaces/"+name+"/messageTTL", ttl, nil)
+				return err
+			})
+		}
+	}
+}
@@ -0,0 +1,230 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package policy
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/datastax/burnell/src/util"
+)
+
+// DeletedTenantResources is the Pulsar resources left over by a tenant whose plan is deleted
+type DeletedTenantResources struct {
+	Tenant    string    `json:"tenant"`
+	DeletedAt time.Time `json:"deletedAt"`
+	CheckedAt time.Time `json:"checkedAt"`
+	// ForceRemoveAt is when the leftovers are force removed, it is zero when force removal is disabled
+	ForceRemoveAt time.Time `json:"forceRemoveAt"`
+	TenantExists  bool      `json:"tenantExists"`
+	Namespaces    []string  `json:"namespaces"`
+	Topics        []string  `json:"topics"`
+	Error         string    `json:"error,omitempty"`
+}
+
+// deletedTenantTracker keeps the deleted tenants until their Pulsar resources are verified removed
+type deletedTenantTracker struct {
+	tenants map[string]*DeletedTenantResources
+	lock    sync.RWMutex
+}
+
+var deletedTenants = deletedTenantTracker{tenants: make(map[string]*DeletedTenantResources)}
+
+var deletedTenantLog = log.WithFields(log.Fields{"app": "deleted-tenant-reconciler"})
+
+// the interval to verify the Pulsar resources of deleted tenants, 0 disables the reconciler
+var deletedTenantReconcileInterval = time.Duration(util.GetEnvInt("DeletedTenantReconcileIntervalSeconds", 0)) * time.Second
+
+// the grace period after the plan deletion before left over namespaces and topics are force removed, 0 disables force removal
+var deletedTenantForceRemoveAfter = time.Duration(util.GetEnvInt("DeletedTenantForceRemoveHours", 0)) * time.Hour
+
+// trackDeletedTenant starts tracking the Pulsar resources of a tenant plan record,
+// a deleted plan is tracked and a live plan, i.e. a recreated tenant, is no longer tracked
+func trackDeletedTenant(t TenantPlan) {
+	deletedTenants.lock.Lock()
+	defer deletedTenants.lock.Unlock()
+	if deletedTenantReconcileInterval <= 0 {
+		return
+	}
+	if t.TenantStatus != Deleted {
+		delete(deletedTenants.tenants, t.Name)
+		return
+	}
+	deletedAt := t.UpdatedAt
+	if deletedAt.IsZero() {
+		deletedAt = time.Now()
+	}
+	resources := &DeletedTenantResources{
+		Tenant:       t.Name,
+		DeletedAt:    deletedAt,
+		TenantExists: true,
+	}
+	if deletedTenantForceRemoveAfter > 0 {
+		resources.ForceRemoveAt = deletedAt.Add(deletedTenantForceRemoveAfter)
+	}
+	deletedTenants.tenants[t.Name] = resources
+}
+
+// SetDeletedTenantReconcile sets the reconcile interval and the grace period before the force removal, 0 disables them
+func SetDeletedTenantReconcile(interval, forceRemoveAfter time.Duration) {
+	deletedTenants.lock.Lock()
+	defer deletedTenants.lock.Unlock()
+	deletedTenantReconcileInterval = interval
+	deletedTenantForceRemoveAfter = forceRemoveAfter
+	if interval <= 0 {
+		deletedTenants.tenants = make(map[string]*DeletedTenantResources)
+	}
+}
+
+// isDeletionTracked returns true if the tenant is still tracked by the same plan deletion
+func isDeletionTracked(tenant string, deletedAt time.Time) bool {
+	deletedTenants.lock.RLock()
+	defer deletedTenants.lock.RUnlock()
+	r, ok := deletedTenants.tenants[tenant]
+	return ok && r.DeletedAt.Equal(deletedAt)
+}
+
+// ClearDeletedTenant clears the deletion of a tenant plan once the Pulsar tenant is created again.
+// It writes a free plan so that the reconciler of every instance stops tracking the tenant
+// and never force removes the live tenant. It is a no-op unless the tenant plan is deleted.
+func (s *TenantPolicyHandler) ClearDeletedTenant(tenantName string) error {
+	deletedTenants.lock.RLock()
+	_, tracked := deletedTenants.tenants[tenantName]
+	deletedTenants.lock.RUnlock()
+	if !tracked && !s.IsDeletedTenant(tenantName) {
+		return nil
+	}
+	plan := newFreeTenantPlan(tenantName)
+	plan.Audit = "free plan of the recreated tenant"
+	if _, err := s.updateDb(plan); err != nil {
+		return err
+	}
+	trackDeletedTenant(plan)
+	deletedTenantLog.Infof("tenant %s is recreated, its plan deletion is cleared", tenantName)
+	return nil
+}
+
+// GetDeletedTenantResources returns the deleted tenants whose Pulsar resources are not verified removed yet
+func GetDeletedTenantResources() []DeletedTenantResources {
+	deletedTenants.lock.RLock()
+	defer deletedTenants.lock.RUnlock()
+	results := make([]DeletedTenantResources, 0, len(deletedTenants.tenants))
+	for _, r := range deletedTenants.tenants {
+		results = append(results, *r)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Tenant < results[j].Tenant })
+	return results
+}
+
+// ReconcileDeletedTenants verifies the namespaces and topics of every deleted tenant are removed from Pulsar,
+// and force removes them once the grace period is over
+func ReconcileDeletedTenants() {
+	for _, r := range GetDeletedTenantResources() {
+		checked := inspectDeletedTenant(r)
+		if checked.Error == "" && len(checked.Namespaces) > 0 && !checked.ForceRemoveAt.IsZero() && time.Now().After(checked.ForceRemoveAt) {
+			// the tenant may be recreated while it is inspected
+			if !isDeletionTracked(r.Tenant, r.DeletedAt) {
+				continue
+			}
+			deletedTenantLog.Warnf("force remove tenant %s namespaces %v", checked.Tenant, checked.Namespaces)
+			if err := forceRemoveTenant(checked.Tenant, checked.Namespaces); err != nil {
+				deletedTenantLog.Errorf("force remove tenant %s error %v", checked.Tenant, err)
+				checked.Error = err.Error()
+			} else {
+				checked = inspectDeletedTenant(r)
+			}
+		}
+
+		deletedTenants.lock.Lock()
+		if tracked, ok := deletedTenants.tenants[r.Tenant]; ok && tracked.DeletedAt.Equal(r.DeletedAt) {
+			if checked.Error == "" && len(checked.Namespaces) == 0 {
+				deletedTenantLog.Infof("deleted tenant %s has no left over Pulsar resources", r.Tenant)
+				delete(deletedTenants.tenants, r.Tenant)
+			} else {
+				deletedTenants.tenants[r.Tenant] = &checked
+			}
+		}
+		deletedTenants.lock.Unlock()
+	}
+}
+
+// inspectDeletedTenant lists the namespaces and topics left under a deleted tenant
+func inspectDeletedTenant(r DeletedTenantResources) DeletedTenantResources {
+	r.CheckedAt = time.Now()
+	r.Namespaces = []string{}
+	r.Topics = []string{}
+	r.Error = ""
+
+	statusCode, err := adminAPIRequest(http.MethodGet, "namespaces/"+r.Tenant, nil, &r.Namespaces)
+	if statusCode == http.StatusNotFound {
+		r.TenantExists = false
+		return r
+	}
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	r.TenantExists = true
+	for _, ns := range r.Namespaces {
+		topics := []string{}
+		if _, err := adminAPIRequest(http.MethodGet, "namespaces/"+ns+"/topics", nil, &topics); err != nil {
+			r.Error = err.Error()
+			return r
+		}
+		r.Topics = append(r.Topics, topics...)
+	}
+	return r
+}
+
+// forceRemoveTenant deletes the namespaces with their topics and the Pulsar tenant
+func forceRemoveTenant(tenant string, namespaces []string) error {
+	for _, ns := range namespaces {
+		if statusCode, err := adminAPIRequest(http.MethodDelete, "namespaces/"+ns+"?force=true", nil, nil); err != nil && statusCode != http.StatusNotFound {
+			return err
+		}
+	}
+	if statusCode, err := adminAPIRequest(http.MethodDelete, "tenants/"+tenant, nil, nil); err != nil && statusCode != http.StatusNotFound {
+		return err
+	}
+	return nil
+}
+
+// DeletedTenantReconcileWorker periodically reconciles the deleted tenants,
+// it is disabled unless DeletedTenantReconcileIntervalSeconds is set as an environment variable
+func DeletedTenantReconcileWorker() {
+	if deletedTenantReconcileInterval <= 0 {
+		return
+	}
+	deletedTenantLog.Infof("reconcile deleted tenants every %v, force removal after %v", deletedTenantReconcileInterval, deletedTenantForceRemoveAfter)
+	go func() {
+		ticker := time.NewTicker(deletedTenantReconcileInterval)
+		for {
+			select {
+			case <-ticker.C:
+				ReconcileDeletedTenants()
+			}
+		}
+	}()
+}
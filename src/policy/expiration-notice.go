@@ -0,0 +1,142 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package policy
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/datastax/burnell/src/notification"
+	"github.com/datastax/burnell/src/util"
+)
+
+var expirationLog = log.WithFields(log.Fields{"app": "expiration-notice"})
+
+// expirations are the expiries of the issued tenant tokens and the expiries already notified
+type expirations struct {
+	// tokens are the expiries of the issued tokens by the tenant and the token subject
+	tokens map[string]map[string]time.Time
+	// notified are the notified expiries by the tenant plan or the token, a changed expiry is notified again
+	notified map[string]time.Time
+	lock     sync.Mutex
+}
+
+var tenantExpirations = expirations{tokens: make(map[string]map[string]time.Time), notified: make(map[string]time.Time)}
+
+// TrackTokenExpiry tracks the expiry of a token issued to a subject of the tenant for the expiration notice,
+// a token without an expiry is not tracked. The latest issued token of a subject replaces the earlier one.
+func TrackTokenExpiry(tenant, subject string, expiresAt time.Time) {
+	if tenant == "" || expiresAt.IsZero() {
+		return
+	}
+	tenantExpirations.lock.Lock()
+	defer tenantExpirations.lock.Unlock()
+	if _, ok := tenantExpirations.tokens[tenant]; !ok {
+		tenantExpirations.tokens[tenant] = make(map[string]time.Time)
+	}
+	tenantExpirations.tokens[tenant][subject] = expiresAt
+}
+
+// due evaluates if the expiry is within the lead time and has not been notified, and marks it notified.
+// The lock must be held.
+func (e *expirations) due(key string, expiresAt, now time.Time, lead time.Duration) bool {
+	if !expiresAt.After(now) || expiresAt.Sub(now) > lead {
+		return false
+	}
+	if notified, ok := e.notified[key]; ok && notified.Equal(expiresAt) {
+		return false
+	}
+	e.notified[key] = expiresAt
+	return true
+}
+
+// CheckExpirations sends the expiration notice once for every tenant plan and issued token expiring within the lead time,
+// to the tenants opting in the expiration notice. The expired tokens are no longer tracked. It returns the number of sent notices.
+func (s *TenantPolicyHandler) CheckExpirations(now time.Time, lead time.Duration) int {
+	notices := []notification.Notice{}
+	tenantExpirations.lock.Lock()
+	for _, t := range s.ListTenants() {
+		if t.ExpiresAt != nil && tenantExpirations.due("plan/"+t.Name, *t.ExpiresAt, now, lead) {
+			notices = append(notices, notification.Notice{
+				Tenant:    t.Name,
+				Kind:      notification.Expiration,
+				Subject:   fmt.Sprintf("tenant %s %s plan expires at %s", t.Name, t.PlanType, t.ExpiresAt.UTC().Format(time.RFC3339)),
+				Message:   fmt.Sprintf("The %s plan of tenant %s expires in %v. Please renew the plan to keep the tenant active.", t.PlanType, t.Name, t.ExpiresAt.Sub(now).Truncate(time.Minute)),
+				CreatedAt: now,
+			})
+		}
+	}
+	for tenant, tokens := range tenantExpirations.tokens {
+		for subject, expiresAt := range tokens {
+			key := "token/" + tenant + "/" + subject
+			if !expiresAt.After(now) {
+				delete(tokens, subject)
+				delete(tenantExpirations.notified, key)
+				continue
+			}
+			if tenantExpirations.due(key, expiresAt, now, lead) {
+				notices = append(notices, notification.Notice{
+					Tenant:    tenant,
+					Kind:      notification.Expiration,
+					Subject:   fmt.Sprintf("tenant %s token of subject %s expires at %s", tenant, subject, expiresAt.UTC().Format(time.RFC3339)),
+					Message:   fmt.Sprintf("The token issued to subject %s of tenant %s expires in %v. Please issue a new token before it expires.", subject, tenant, expiresAt.Sub(now).Truncate(time.Minute)),
+					CreatedAt: now,
+				})
+			}
+		}
+		if len(tokens) == 0 {
+			delete(tenantExpirations.tokens, tenant)
+		}
+	}
+	tenantExpirations.lock.Unlock()
+
+	sent := 0
+	for _, notice := range notices {
+		if s.NotifyTenantNotice(notice) {
+			sent++
+		}
+	}
+	return sent
+}
+
+// ExpirationNoticeScheduler checks the expiring tenant plans and tokens every ExpirationNoticeIntervalMinutes (default 60),
+// and notifies the tenants ExpirationNoticeLeadHours (default 72) before the expiry. 0 interval disables it.
+func ExpirationNoticeScheduler() {
+	interval := time.Duration(util.GetEnvInt("ExpirationNoticeIntervalMinutes", 60)) * time.Minute
+	lead := time.Duration(util.GetEnvInt("ExpirationNoticeLeadHours", 72)) * time.Hour
+	if interval <= 0 {
+		return
+	}
+	expirationLog.Infof("check tenant plan and token expiries every %v, notify %v ahead", interval, lead)
+	go func() {
+		ticker := time.NewTicker(interval)
+		for {
+			select {
+			case <-ticker.C:
+				sent := TenantManager.CheckExpirations(time.Now(), lead)
+				expirationLog.Infof("sent %d expiration notices", sent)
+			}
+		}
+	}()
+}
@@ -54,6 +54,31 @@ var KafkaesqueFeatureCodes = []FeatureCode{
 		Description: "tracks cluster usage by hours",
 		Alias:       "cut,clusterUsageTracking",
 	},
+	{
+		Name:        PulsarSQL,
+		Description: "queries tenant topics with Pulsar SQL",
+		Alias:       "pulsarSQL,presto",
+	},
+	{
+		Name:        GeoReplication,
+		Description: "manages namespace geo-replication clusters",
+		Alias:       "geoReplication,replication",
+	},
+	{
+		Name:        TieredStorage,
+		Description: "manages namespace tiered storage offload",
+		Alias:       "tieredStorage,offload",
+	},
+	{
+		Name:        MessageDeduplication,
+		Description: "enables message deduplication on new topics",
+		Alias:       "messageDeduplication,deduplication,dedup",
+	},
+	{
+		Name:        SyntheticProbe,
+		Description: "probes the tenant heartbeat topic end to end",
+		Alias:       "syntheticProbe,probe",
+	},
 }
 
 ///// internal implementation
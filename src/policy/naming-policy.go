@@ -0,0 +1,148 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package policy
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/datastax/burnell/src/util"
+)
+
+// the resource kinds under the naming policies
+const (
+	NamespaceResource = "namespace"
+	TopicResource     = "topic"
+)
+
+// DefaultNamingPolicy is the key of the naming policy applied to the plans without their own policy
+const DefaultNamingPolicy = "default"
+
+type namingRule struct {
+	rule    util.NamingRule
+	pattern *regexp.Regexp
+}
+
+type namingPolicy struct {
+	namespace namingRule
+	topic     namingRule
+}
+
+var (
+	namingPolicies     = map[string]namingPolicy{}
+	namingPoliciesLock = sync.RWMutex{}
+)
+
+// InitNamingPolicies compiles the NamingPolicies in the configuration
+func InitNamingPolicies() error {
+	return SetNamingPolicies(util.GetConfig().NamingPolicies)
+}
+
+// SetNamingPolicies replaces the naming policies keyed by the plan type
+func SetNamingPolicies(policies map[string]util.NamingPolicy) error {
+	compiled := make(map[string]namingPolicy, len(policies))
+	for planType, p := range policies {
+		name := strings.ToLower(planType)
+		if name != DefaultNamingPolicy && getPlanPolicy(name) == nil {
+			return fmt.Errorf("naming policy of unknown plan type %s", planType)
+		}
+		ns, err := compileNamingRule(p.Namespace)
+		if err != nil {
+			return fmt.Errorf("plan %s namespace naming rule: %v", planType, err)
+		}
+		topic, err := compileNamingRule(p.Topic)
+		if err != nil {
+			return fmt.Errorf("plan %s topic naming rule: %v", planType, err)
+		}
+		compiled[name] = namingPolicy{namespace: ns, topic: topic}
+	}
+
+	namingPoliciesLock.Lock()
+	defer namingPoliciesLock.Unlock()
+	namingPolicies = compiled
+	return nil
+}
+
+func compileNamingRule(rule util.NamingRule) (namingRule, error) {
+	if rule.MaxLength < 0 {
+		return namingRule{}, fmt.Errorf("negative maxLength %d", rule.MaxLength)
+	}
+	compiled := namingRule{rule: rule}
+	if rule.Pattern != "" {
+		pattern, err := regexp.Compile("^(?:" + rule.Pattern + ")$")
+		if err != nil {
+			return namingRule{}, err
+		}
+		compiled.pattern = pattern
+	}
+	return compiled, nil
+}
+
+// ValidateResourceName validates the name of a namespace or topic created under the tenant
+// against the naming policy of the tenant plan, an unknown tenant is under the free plan.
+func ValidateResourceName(tenant, kind, name string) error {
+	plan, err := TenantManager.GetTenant(tenant)
+	if err != nil {
+		plan = newFreeTenantPlan(tenant)
+	}
+	return ValidatePlanResourceName(plan, kind, name)
+}
+
+// ValidatePlanResourceName validates a namespace or topic name against the naming policy of the plan type
+func ValidatePlanResourceName(plan TenantPlan, kind, name string) error {
+	planType := strings.ToLower(util.AssignString(plan.PlanType, FreeTier))
+
+	namingPoliciesLock.RLock()
+	p, ok := namingPolicies[planType]
+	if !ok {
+		p, ok = namingPolicies[DefaultNamingPolicy]
+	}
+	namingPoliciesLock.RUnlock()
+	if !ok {
+		return nil
+	}
+
+	rule := p.namespace
+	if kind == TopicResource {
+		rule = p.topic
+	}
+	if reason := rule.violation(plan.Name, name); reason != "" {
+		return &ValidationError{Fields: []FieldError{{Field: kind, Value: name, Reason: reason}}}
+	}
+	return nil
+}
+
+// violation returns the reason the name breaks the rule, empty if the name conforms
+func (r namingRule) violation(tenant, name string) string {
+	if r.rule.MaxLength > 0 && len(name) > r.rule.MaxLength {
+		return fmt.Sprintf("longer than %d characters", r.rule.MaxLength)
+	}
+	if prefix := strings.ReplaceAll(r.rule.Prefix, "{tenant}", tenant); !strings.HasPrefix(name, prefix) {
+		return fmt.Sprintf("requires the prefix %s", prefix)
+	}
+	if r.pattern != nil && !r.pattern.MatchString(name) {
+		return fmt.Sprintf("does not match the pattern %s", r.rule.Pattern)
+	}
+	return ""
+}
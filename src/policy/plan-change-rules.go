@@ -0,0 +1,217 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/datastax/burnell/src/notification"
+	"github.com/datastax/burnell/src/util"
+)
+
+// the plan fields that change on every write and are never reported as a change
+var planBookkeepingFields = map[string]bool{"version": true, "updatedAt": true, "encrypted": true}
+
+// PlanFieldChanges returns the sorted JSON field paths that differ between the plans. A nested object field is
+// named with dots such as policy.featureCodes, and an array or a map of strings is compared as one field.
+func PlanFieldChanges(prev, next TenantPlan) []string {
+	changes := []string{}
+	diffFields("", planFields(prev), planFields(next), &changes)
+	sort.Strings(changes)
+	return changes
+}
+
+func planFields(t TenantPlan) map[string]interface{} {
+	fields := map[string]interface{}{}
+	if data, err := json.Marshal(t); err == nil {
+		json.Unmarshal(data, &fields)
+	}
+	for f := range planBookkeepingFields {
+		delete(fields, f)
+	}
+	return fields
+}
+
+func diffFields(prefix string, prev, next map[string]interface{}, changes *[]string) {
+	names := map[string]bool{}
+	for k := range prev {
+		names[k] = true
+	}
+	for k := range next {
+		names[k] = true
+	}
+	for name := range names {
+		p, n := prev[name], next[name]
+		pMap, pOK := p.(map[string]interface{})
+		nMap, nOK := n.(map[string]interface{})
+		// the metadata is a map of strings rather than a nested object
+		if (pOK || nOK) && name != "metadata" {
+			if !pOK {
+				pMap = map[string]interface{}{}
+			}
+			if !nOK {
+				nMap = map[string]interface{}{}
+			}
+			diffFields(prefix+name+".", pMap, nMap, changes)
+		} else if !reflect.DeepEqual(p, n) {
+			*changes = append(*changes, prefix+name)
+		}
+	}
+}
+
+// planChangeRule is a compiled rule with the number of the matched events
+type planChangeRule struct {
+	matched uint64
+	util.PlanChangeRule
+}
+
+// PlanChangeRuleStats is a plan change rule with the number of the matched events
+type PlanChangeRuleStats struct {
+	util.PlanChangeRule
+	Matched uint64 `json:"matched"`
+}
+
+var (
+	planChangeRules     = []*planChangeRule{}
+	planChangeRulesLock = sync.RWMutex{}
+)
+
+// InitPlanChangeRules sets the PlanChangeRules in the configuration
+func InitPlanChangeRules() error {
+	return SetPlanChangeRules(util.GetConfig().PlanChangeRules)
+}
+
+// SetPlanChangeRules replaces the rules. A rule keeps its counter if its name is unchanged.
+func SetPlanChangeRules(rules []util.PlanChangeRule) error {
+	compiled := make([]*planChangeRule, 0, len(rules))
+	names := make(map[string]bool, len(rules))
+	for _, r := range rules {
+		if r.Name == "" {
+			return fmt.Errorf("plan change rule requires a name")
+		}
+		if names[r.Name] {
+			return fmt.Errorf("duplicate plan change rule %s", r.Name)
+		}
+		names[r.Name] = true
+		if len(r.Webhooks) == 0 {
+			return fmt.Errorf("plan change rule %s requires a webhook", r.Name)
+		}
+		for _, webhook := range r.Webhooks {
+			if u, err := url.ParseRequestURI(webhook); err != nil || !(u.Scheme == "http" || u.Scheme == "https") {
+				return fmt.Errorf("plan change rule %s webhook %s must be a http or https URL", r.Name, webhook)
+			}
+		}
+		for _, t := range r.Types {
+			if t != PlanUpdated && t != PlanDeleted {
+				return fmt.Errorf("plan change rule %s type %s is not %s or %s", r.Name, t, PlanUpdated, PlanDeleted)
+			}
+		}
+		compiled = append(compiled, &planChangeRule{PlanChangeRule: r})
+	}
+
+	planChangeRulesLock.Lock()
+	defer planChangeRulesLock.Unlock()
+	existing := make(map[string]*planChangeRule, len(planChangeRules))
+	for _, r := range planChangeRules {
+		existing[r.Name] = r
+	}
+	for _, c := range compiled {
+		if prev, ok := existing[c.Name]; ok {
+			c.matched = atomic.LoadUint64(&prev.matched)
+		}
+	}
+	planChangeRules = compiled
+	return nil
+}
+
+// PlanChangeRules returns the rules with their matched counters
+func PlanChangeRules() []PlanChangeRuleStats {
+	planChangeRulesLock.RLock()
+	defer planChangeRulesLock.RUnlock()
+	stats := make([]PlanChangeRuleStats, 0, len(planChangeRules))
+	for _, r := range planChangeRules {
+		stats = append(stats, PlanChangeRuleStats{PlanChangeRule: r.PlanChangeRule, Matched: atomic.LoadUint64(&r.matched)})
+	}
+	return stats
+}
+
+// MatchChangedFields returns true if any changed field path is one of the fields, a nested field of them,
+// or has one of them as the last name, i.e. featureCodes matches policy.featureCodes. Empty fields match any change.
+func MatchChangedFields(fields, changes []string) bool {
+	if len(fields) == 0 {
+		return len(changes) > 0
+	}
+	for _, c := range changes {
+		for _, f := range fields {
+			if c == f || strings.HasPrefix(c, f+".") || strings.HasSuffix(c, "."+f) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (r *planChangeRule) match(event TenantPlanEvent) bool {
+	if r.Tenant != "" && r.Tenant != event.Tenant {
+		return false
+	}
+	if len(r.Types) > 0 && !util.StrContains(r.Types, event.Type) {
+		return false
+	}
+	return MatchChangedFields(r.Fields, event.Changes)
+}
+
+// DispatchPlanChangeRules posts the event asynchronously to the webhooks of every matched rule
+// and returns the number of the matched rules
+func DispatchPlanChangeRules(event TenantPlanEvent) int {
+	planChangeRulesLock.RLock()
+	defer planChangeRulesLock.RUnlock()
+	matched := 0
+	for _, r := range planChangeRules {
+		if !r.match(event) {
+			continue
+		}
+		matched++
+		atomic.AddUint64(&r.matched, 1)
+		data, err := json.Marshal(event)
+		if err != nil {
+			continue
+		}
+		notice := notification.Notice{
+			Tenant:    event.Tenant,
+			Kind:      notification.PlanChange,
+			Subject:   fmt.Sprintf("tenant %s plan %s by rule %s", event.Tenant, event.Type, r.Name),
+			Message:   "changed fields " + strings.Join(event.Changes, ","),
+			CreatedAt: event.Time,
+			Data:      data,
+		}
+		go notification.Notifier.Notify(nil, r.Webhooks, notice)
+	}
+	return matched
+}
@@ -0,0 +1,175 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/datastax/burnell/src/util"
+)
+
+// the plan limit changes
+const (
+	LimitIncreased = "increased"
+	LimitDecreased = "decreased"
+	LimitUnchanged = "unchanged"
+)
+
+// the plan policy fields that are not a limit, the message retention is compared by messageHourRetention
+var planCompareSkippedFields = map[string]bool{
+	"name": true, "featureCodes": true, "messageRetention": true, "reserved0": true, "reserved1": true,
+}
+
+// PlanLimitDiff is a plan policy limit of the two plan types, -1 is unlimited
+type PlanLimitDiff struct {
+	Field  string `json:"field"`
+	From   int64  `json:"from"`
+	To     int64  `json:"to"`
+	Change string `json:"change"`
+}
+
+// FeatureCodesDiff is the feature codes added, removed and kept from one plan type to the other
+type FeatureCodesDiff struct {
+	Added     []string `json:"added"`
+	Removed   []string `json:"removed"`
+	Unchanged []string `json:"unchanged"`
+}
+
+// PlanComparison is the field by field difference of the policies of two plan types
+type PlanComparison struct {
+	From     string           `json:"from"`
+	To       string           `json:"to"`
+	Limits   []PlanLimitDiff  `json:"limits"`
+	Features FeatureCodesDiff `json:"features"`
+	// Upgrade is true if no limit decreases and no feature code is removed
+	Upgrade bool `json:"upgrade"`
+}
+
+// ComparePlans compares the policy of the plan type from to the plan type to. The limits are sorted by the field,
+// the unchanged limits are only included with all.
+func ComparePlans(from, to string, all bool) (PlanComparison, error) {
+	from, to = strings.ToLower(from), strings.ToLower(to)
+	fromPolicy, toPolicy := getPlanPolicy(from), getPlanPolicy(to)
+	if fromPolicy == nil {
+		return PlanComparison{}, fmt.Errorf("unknown plan type %s", from)
+	}
+	if toPolicy == nil {
+		return PlanComparison{}, fmt.Errorf("unknown plan type %s", to)
+	}
+
+	comparison := PlanComparison{
+		From:     from,
+		To:       to,
+		Limits:   []PlanLimitDiff{},
+		Features: compareFeatureCodes(fromPolicy.FeatureCodes, toPolicy.FeatureCodes),
+		Upgrade:  true,
+	}
+	fromLimits, toLimits := planLimits(*fromPolicy), planLimits(*toPolicy)
+	fields := make([]string, 0, len(fromLimits))
+	for f := range fromLimits {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+	for _, f := range fields {
+		diff := PlanLimitDiff{Field: "policy." + f, From: fromLimits[f], To: toLimits[f], Change: compareLimit(fromLimits[f], toLimits[f])}
+		if diff.Change == LimitDecreased {
+			comparison.Upgrade = false
+		}
+		if all || diff.Change != LimitUnchanged {
+			comparison.Limits = append(comparison.Limits, diff)
+		}
+	}
+	if len(comparison.Features.Removed) > 0 {
+		comparison.Upgrade = false
+	}
+	return comparison, nil
+}
+
+// planLimits returns the numeric policy fields by the JSON name
+func planLimits(p PlanPolicy) map[string]int64 {
+	fields := map[string]interface{}{}
+	if data, err := json.Marshal(p); err == nil {
+		json.Unmarshal(data, &fields)
+	}
+	limits := map[string]int64{}
+	for f, v := range fields {
+		if n, ok := v.(float64); ok && !planCompareSkippedFields[f] {
+			limits[f] = int64(n)
+		}
+	}
+	return limits
+}
+
+// compareLimit compares two limits where a negative limit is unlimited
+func compareLimit(from, to int64) string {
+	switch {
+	case from == to || (from < 0 && to < 0):
+		return LimitUnchanged
+	case from < 0:
+		return LimitDecreased
+	case to < 0 || to > from:
+		return LimitIncreased
+	default:
+		return LimitDecreased
+	}
+}
+
+// planFeatureCodes returns the sorted feature codes, all the known features for all-enabled
+func planFeatureCodes(codes string) []string {
+	features := []string{}
+	switch codes {
+	case FeatureAllEnabled:
+		for _, f := range KafkaesqueFeatureCodes {
+			features = append(features, f.Name)
+		}
+	case FeatureAllDisabled, "":
+	default:
+		for _, c := range strings.Split(codes, ",") {
+			if c, ok := ValidateFeatureCode(c); ok && !util.StrContains(features, c) {
+				features = append(features, c)
+			}
+		}
+	}
+	sort.Strings(features)
+	return features
+}
+
+func compareFeatureCodes(from, to string) FeatureCodesDiff {
+	diff := FeatureCodesDiff{Added: []string{}, Removed: []string{}, Unchanged: []string{}}
+	fromCodes, toCodes := planFeatureCodes(from), planFeatureCodes(to)
+	for _, c := range toCodes {
+		if util.StrContains(fromCodes, c) {
+			diff.Unchanged = append(diff.Unchanged, c)
+		} else {
+			diff.Added = append(diff.Added, c)
+		}
+	}
+	for _, c := range fromCodes {
+		if !util.StrContains(toCodes, c) {
+			diff.Removed = append(diff.Removed, c)
+		}
+	}
+	return diff
+}
@@ -0,0 +1,213 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package policy
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"github.com/datastax/burnell/src/icrypto"
+)
+
+// EncryptedFields is the encrypted sensitive fields of a tenant plan record in the tenant topic
+type EncryptedFields struct {
+	// KeyID identifies the AES key that encrypts the fields
+	KeyID string `json:"keyId"`
+	// Contacts is the base64 AES-GCM ciphertext of the contacts JSON
+	Contacts string `json:"contacts,omitempty"`
+	// Metadata is the base64 AES-GCM ciphertext of the custom metadata JSON
+	Metadata string `json:"metadata,omitempty"`
+}
+
+// ErrPlanUndecryptable is the update of a tenant plan whose sensitive fields can't be decrypted by the configured keys
+var ErrPlanUndecryptable = errors.New("plan can't be decrypted by the configured policy encryption keys, configure the key to update it")
+
+// planKeyring is the AES keys to encrypt the sensitive tenant plan fields, the active key encrypts
+// and all keys decrypt so that the records encrypted before a rotation are still readable
+type planKeyring struct {
+	activeID string
+	keys     map[string][]byte
+}
+
+var (
+	planKeys     *planKeyring
+	planKeysLock sync.RWMutex
+
+	planCipher = icrypto.AES{}
+)
+
+// SetPlanEncryptionKeys sets the keys in a comma separated list of {keyId}={base64 AES key}, the first key is active.
+// An AES key is 16, 24 or 32 bytes. An empty list disables the encryption.
+func SetPlanEncryptionKeys(spec string) error {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		planKeysLock.Lock()
+		planKeys = nil
+		planKeysLock.Unlock()
+		return nil
+	}
+	keyring := &planKeyring{keys: make(map[string][]byte)}
+	for _, entry := range strings.Split(spec, ",") {
+		parts := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return fmt.Errorf("policy encryption key must be in the format of keyId=base64Key")
+		}
+		key, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			return fmt.Errorf("policy encryption key %s is not base64 encoded %v", parts[0], err)
+		}
+		if l := len(key); l != 16 && l != 24 && l != 32 {
+			return fmt.Errorf("policy encryption key %s must be 16, 24 or 32 bytes but is %d", parts[0], l)
+		}
+		if _, ok := keyring.keys[parts[0]]; ok {
+			return fmt.Errorf("duplicate policy encryption key %s", parts[0])
+		}
+		if keyring.activeID == "" {
+			keyring.activeID = parts[0]
+		}
+		keyring.keys[parts[0]] = key
+	}
+	planKeysLock.Lock()
+	planKeys = keyring
+	planKeysLock.Unlock()
+	return nil
+}
+
+// LoadPlanEncryptionKeys sets the keys from the key file, such as a mounted Kubernetes secret, or the keys in the configuration
+// if there is no key file. The key file has the same comma separated list of {keyId}={base64 AES key}.
+func LoadPlanEncryptionKeys(keys, keyFile string) error {
+	if keyFile != "" {
+		data, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			return fmt.Errorf("failed to read policy encryption key file %s %v", keyFile, err)
+		}
+		keys = string(data)
+	}
+	return SetPlanEncryptionKeys(keys)
+}
+
+// ActivePlanEncryptionKey returns the ID of the key that encrypts the tenant plans, empty if the encryption is disabled
+func ActivePlanEncryptionKey() string {
+	planKeysLock.RLock()
+	defer planKeysLock.RUnlock()
+	if planKeys == nil {
+		return ""
+	}
+	return planKeys.activeID
+}
+
+// EncryptTenantPlan returns the plan record with the sensitive fields encrypted by the active key,
+// the plan is returned as is if the encryption is disabled
+func EncryptTenantPlan(t TenantPlan) (TenantPlan, error) {
+	planKeysLock.RLock()
+	keyring := planKeys
+	planKeysLock.RUnlock()
+	t.Encrypted = nil
+	if keyring == nil {
+		return t, nil
+	}
+
+	key := keyring.keys[keyring.activeID]
+	contacts, err := encryptField(t.Contacts, key)
+	if err != nil {
+		return TenantPlan{}, err
+	}
+	encrypted := &EncryptedFields{KeyID: keyring.activeID, Contacts: contacts}
+	if len(t.Metadata) > 0 {
+		if encrypted.Metadata, err = encryptField(t.Metadata, key); err != nil {
+			return TenantPlan{}, err
+		}
+	}
+	t.Encrypted = encrypted
+	t.Contacts = nil
+	t.Metadata = nil
+	return t, nil
+}
+
+// encryptField returns the base64 AES-GCM ciphertext of the JSON of the field
+func encryptField(field interface{}, key []byte) (string, error) {
+	data, err := json.Marshal(field)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := planCipher.Encrypt(data, key)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+// decryptField decrypts the base64 AES-GCM ciphertext into the field
+func decryptField(ciphertext string, key []byte, field interface{}) error {
+	data, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return err
+	}
+	if data, err = planCipher.Decrypt(data, key); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, field)
+}
+
+// DecryptTenantPlan returns the plan with the sensitive fields decrypted from the record
+func DecryptTenantPlan(t TenantPlan) (TenantPlan, error) {
+	if t.Encrypted == nil {
+		return t, nil
+	}
+	planKeysLock.RLock()
+	keyring := planKeys
+	planKeysLock.RUnlock()
+	if keyring == nil {
+		return t, fmt.Errorf("tenant %s plan is encrypted but no policy encryption key is configured", t.Name)
+	}
+	key, ok := keyring.keys[t.Encrypted.KeyID]
+	if !ok {
+		return t, fmt.Errorf("tenant %s plan is encrypted by an unknown key %s", t.Name, t.Encrypted.KeyID)
+	}
+
+	// the decrypted fields never overwrite the fields shared with a copy of the plan
+	var contacts *TenantContacts
+	if t.Encrypted.Contacts != "" {
+		if err := decryptField(t.Encrypted.Contacts, key, &contacts); err != nil {
+			return t, fmt.Errorf("tenant %s plan contacts decryption error %v", t.Name, err)
+		}
+	}
+	var metadata map[string]string
+	if t.Encrypted.Metadata != "" {
+		if err := decryptField(t.Encrypted.Metadata, key, &metadata); err != nil {
+			return t, fmt.Errorf("tenant %s plan metadata decryption error %v", t.Name, err)
+		}
+	}
+	if t.Encrypted.Contacts != "" {
+		t.Contacts = contacts
+	}
+	if t.Encrypted.Metadata != "" {
+		t.Metadata = metadata
+	}
+	t.Encrypted = nil
+	return t, nil
+}
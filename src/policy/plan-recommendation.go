@@ -0,0 +1,262 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package policy
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/datastax/burnell/src/metrics"
+	"github.com/datastax/burnell/src/util"
+)
+
+// the plan recommendation actions
+const (
+	RecommendUpgrade   = "upgrade"
+	RecommendDowngrade = "downgrade"
+)
+
+// the plan types from the lowest tier to the highest
+var planTiers = []string{FreeTier, StarterTier, ProductionTier, DedicatedTier, PrivateTier}
+
+func planTier(planType string) int {
+	for i, tier := range planTiers {
+		if tier == planType {
+			return i
+		}
+	}
+	return -1
+}
+
+// the usage metrics of the history evaluated against the plan capacity
+var recommendationMetrics = []string{metrics.Producers, metrics.Consumers, metrics.APICalls}
+
+// PlanRecommendationThresholds decide when a tenant consistently exceeds or stays far under its plan capacity
+type PlanRecommendationThresholds struct {
+	// Window is the usage history analyzed up to now
+	Window time.Duration `json:"window"`
+	// MinSamples is the least usage samples in the window to recommend on
+	MinSamples int `json:"minSamples"`
+	// OverPercent and UnderPercent are the usage percentages of the plan capacity a sample is over or under at
+	OverPercent  float64 `json:"overPercent"`
+	UnderPercent float64 `json:"underPercent"`
+	// ConsistentPercent is the percentage of the samples over, or under, for the tenant to exceed, or underuse, the plan
+	ConsistentPercent float64 `json:"consistentPercent"`
+	// QuotaEvents is the number of the quota rejections in the window to recommend an upgrade, 0 ignores them
+	QuotaEvents int `json:"quotaEvents"`
+}
+
+// DefaultPlanRecommendationThresholds returns the recommendation thresholds configured by the environment variables
+func DefaultPlanRecommendationThresholds() PlanRecommendationThresholds {
+	return PlanRecommendationThresholds{
+		Window:            time.Duration(util.GetEnvInt("PlanRecommendationWindowHours", 168)) * time.Hour,
+		MinSamples:        util.GetEnvInt("PlanRecommendationMinSamples", 12),
+		OverPercent:       float64(util.GetEnvInt("PlanRecommendationOverPercent", 90)),
+		UnderPercent:      float64(util.GetEnvInt("PlanRecommendationUnderPercent", 20)),
+		ConsistentPercent: float64(util.GetEnvInt("PlanRecommendationConsistentPercent", 80)),
+		QuotaEvents:       util.GetEnvInt("PlanRecommendationQuotaEvents", 10),
+	}
+}
+
+// UsageCapacityFinding is a usage metric of the tenant against the capacity of its plan
+type UsageCapacityFinding struct {
+	Metric string  `json:"metric"`
+	Peak   float64 `json:"peak"`
+	// PeakPercent is the highest usage percentage of the plan capacity, -1 if the plan is unlimited
+	PeakPercent float64 `json:"peakPercent"`
+	// OverSamplesPercent and UnderSamplesPercent are the percentages of the samples over and under the thresholds
+	OverSamplesPercent  float64 `json:"overSamplesPercent"`
+	UnderSamplesPercent float64 `json:"underSamplesPercent"`
+}
+
+// PlanRecommendation is a tenant advised to change the plan type by its usage history
+type PlanRecommendation struct {
+	Tenant   string `json:"tenant"`
+	Org      string `json:"org,omitempty"`
+	PlanType string `json:"planType"`
+	Action   string `json:"action"`
+	// RecommendedPlanType is the lowest plan type fitting the peak usage
+	RecommendedPlanType string                 `json:"recommendedPlanType"`
+	Samples             int                    `json:"samples"`
+	QuotaEvents         int                    `json:"quotaEvents"`
+	From                time.Time              `json:"from"`
+	To                  time.Time              `json:"to"`
+	Findings            []UsageCapacityFinding `json:"findings"`
+}
+
+// usageCapacity is the tenant wide capacity of the plan policy for a usage sample, the producers and consumers limits
+// are per topic and the request rate is over the UTC day of the daily API calls. ok is false if it does not apply.
+func usageCapacity(p PlanPolicy, planType, metric string, u metrics.Usage) (capacity float64, ok bool) {
+	perTopic := func(limit int) (float64, bool) {
+		if limit < 0 || p.NumOfTopics < 0 {
+			return -1, true
+		}
+		return float64(limit * p.NumOfTopics), limit > 0 && p.NumOfTopics > 0
+	}
+	switch metric {
+	case metrics.Producers:
+		return perTopic(p.NumOfProducers)
+	case metrics.Consumers:
+		return perTopic(p.NumOfConsumers)
+	case metrics.APICalls:
+		rate := p.RequestRate
+		if defaults := getPlanPolicy(planType); rate == 0 && defaults != nil {
+			rate = defaults.RequestRate
+		}
+		if rate < 0 {
+			return -1, true
+		}
+		// the day just begun is too short to tell
+		t := u.UpdatedAt.UTC()
+		elapsed := t.Sub(time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)).Seconds()
+		return float64(rate) * elapsed, rate > 0 && elapsed >= time.Hour.Seconds()
+	}
+	return 0, false
+}
+
+// peakUsagePercent is the highest usage percentage of the plan capacity over the samples, -1 if unlimited
+func peakUsagePercent(p PlanPolicy, planType, metric string, samples []metrics.Usage) float64 {
+	peak := -1.0
+	for _, u := range samples {
+		capacity, ok := usageCapacity(p, planType, metric, u)
+		if !ok || capacity < 0 {
+			continue
+		}
+		value, _ := metrics.UsageMetricValue(u, metric)
+		peak = math.Max(peak, value*100/capacity)
+	}
+	return peak
+}
+
+// fitsPlan evaluates the samples never go over the capacity of the plan type
+func fitsPlan(planType string, samples []metrics.Usage) bool {
+	p := getPlanPolicy(planType)
+	for _, metric := range recommendationMetrics {
+		if peakUsagePercent(*p, planType, metric, samples) > 100 {
+			return false
+		}
+	}
+	return true
+}
+
+// RecommendPlans analyzes the usage history of the tenants in the window up to now. A tenant is recommended to
+// upgrade if a usage consistently goes over its plan capacity, or the quota rejections reach the threshold, and to
+// downgrade if every usage consistently stays far under it. The recommended plan type is the lowest tier fitting
+// the peak usage. The recommendations are sorted by the tenant.
+func RecommendPlans(tenants []TenantPlan, history func(tenant string, from, to time.Time) []metrics.Usage,
+	quotaEvents func(tenant string, since time.Time) int, thresholds PlanRecommendationThresholds, now time.Time) []PlanRecommendation {
+	from := now.Add(-thresholds.Window)
+	recommendations := []PlanRecommendation{}
+	for _, t := range tenants {
+		planType := strings.ToLower(t.PlanType)
+		tier := planTier(planType)
+		if tier < 0 {
+			continue
+		}
+		rec := PlanRecommendation{
+			Tenant:      t.Name,
+			Org:         t.Org,
+			PlanType:    planType,
+			From:        from,
+			To:          now,
+			Findings:    []UsageCapacityFinding{},
+			QuotaEvents: quotaEvents(t.Name, from),
+		}
+		samples := history(t.Name, from, now)
+		rec.Samples = len(samples)
+		exceeded := thresholds.QuotaEvents > 0 && rec.QuotaEvents >= thresholds.QuotaEvents
+		underused := rec.Samples >= thresholds.MinSamples && rec.QuotaEvents == 0
+		for _, metric := range recommendationMetrics {
+			finding := UsageCapacityFinding{Metric: metric, PeakPercent: -1}
+			evaluated, over, under := 0, 0, 0
+			for _, u := range samples {
+				value, _ := metrics.UsageMetricValue(u, metric)
+				finding.Peak = math.Max(finding.Peak, value)
+				capacity, ok := usageCapacity(t.Policy, planType, metric, u)
+				if !ok || capacity < 0 {
+					continue
+				}
+				evaluated++
+				percent := value * 100 / capacity
+				finding.PeakPercent = math.Max(finding.PeakPercent, percent)
+				if percent >= thresholds.OverPercent {
+					over++
+				} else if percent <= thresholds.UnderPercent {
+					under++
+				}
+			}
+			if evaluated > 0 {
+				finding.OverSamplesPercent = float64(over) * 100 / float64(evaluated)
+				finding.UnderSamplesPercent = float64(under) * 100 / float64(evaluated)
+			}
+			if rec.Samples >= thresholds.MinSamples && evaluated > 0 && finding.OverSamplesPercent >= thresholds.ConsistentPercent {
+				exceeded = true
+			}
+			// an unlimited capacity is never underused
+			if evaluated == 0 || finding.UnderSamplesPercent < thresholds.ConsistentPercent {
+				underused = false
+			}
+			rec.Findings = append(rec.Findings, finding)
+		}
+
+		switch {
+		case exceeded && tier < len(planTiers)-1:
+			rec.Action = RecommendUpgrade
+			rec.RecommendedPlanType = planTiers[len(planTiers)-1]
+			for _, higher := range planTiers[tier+1:] {
+				if fitsPlan(higher, samples) {
+					rec.RecommendedPlanType = higher
+					break
+				}
+			}
+		case underused && tier > 0:
+			for _, lower := range planTiers[:tier] {
+				if fitsPlan(lower, samples) {
+					rec.Action = RecommendDowngrade
+					rec.RecommendedPlanType = lower
+					break
+				}
+			}
+		}
+		if rec.Action != "" {
+			recommendations = append(recommendations, rec)
+		}
+	}
+	sort.SliceStable(recommendations, func(i, j int) bool { return recommendations[i].Tenant < recommendations[j].Tenant })
+	return recommendations
+}
+
+// tenantQuotaEvents is the number of the quota rejections of the tenant since the time in the tenant event feed
+func tenantQuotaEvents(tenant string, since time.Time) int {
+	count := 0
+	for _, e := range TenantEvents(tenant, since, []string{QuotaEventCategory}, 0) {
+		count += e.Count
+	}
+	return count
+}
+
+// GetPlanRecommendations returns the plan recommendations of all tenants over the usage history kept in the process
+func GetPlanRecommendations(thresholds PlanRecommendationThresholds) []PlanRecommendation {
+	return RecommendPlans(TenantManager.ListTenants(), metrics.GetUsageHistory, tenantQuotaEvents, thresholds, time.Now())
+}
@@ -27,7 +27,6 @@ import (
 	"time"
 
 	"github.com/datastax/burnell/src/util"
-	"github.com/kafkaesque-io/pulsar-beam/src/db"
 )
 
 // TenantStatus can be used for tenant status
@@ -69,6 +68,16 @@ const (
 	BrokerMetrics = "broker-metrics"
 	// InfiniteMessageRetention is the feature for infinite message retention
 	InfiniteMessageRetention = "infinite-message-retention"
+	// PulsarSQL is the feature to query topics with Pulsar SQL
+	PulsarSQL = "pulsar-sql"
+	// GeoReplication is the feature to manage namespace replication clusters
+	GeoReplication = "geo-replication"
+	// TieredStorage is the feature to manage namespace tiered storage offload
+	TieredStorage = "tiered-storage"
+	// MessageDeduplication is the feature to enable message deduplication on new topics
+	MessageDeduplication = "message-deduplication"
+	// SyntheticProbe is the feature to probe the tenant heartbeat topic end to end
+	SyntheticProbe = "synthetic-probe"
 )
 
 // PlanPolicy is the tenant policy
@@ -82,13 +91,35 @@ type PlanPolicy struct {
 	NumOfProducers       int           `json:"numofProducers"`
 	NumOfConsumers       int           `json:"numOfConsumers"`
 	Functions            int           `json:"functions"`
+	NumOfPartitions      int           `json:"numOfPartitions"`
 	FeatureCodes         string        `json:"featureCodes"`
-	Reserved0            string        `json:"reserved0"`
-	Reserved1            string        `json:"reserved1"`
+	// RequestRate is the sustained API requests per second of the tenant, and RequestBurst is the bucket size
+	// over the sustained rate. 0 is the plan type default, and -1 is unlimited.
+	RequestRate  int `json:"requestRate"`
+	RequestBurst int `json:"requestBurst"`
+	// BacklogQuotaMB is the max backlog quota per topic the backlog quota remediation can expand to, -1 is unlimited
+	BacklogQuotaMB int    `json:"backlogQuotaMB"`
+	Reserved0      string `json:"reserved0"`
+	Reserved1      string `json:"reserved1"`
+}
+
+// TenantContacts is the tenant contacts for notification
+type TenantContacts struct {
+	Emails   []string `json:"emails"`
+	Webhooks []string `json:"webhooks"`
+}
+
+// NotificationPreferences specifies the kinds of notice the tenant opts in
+type NotificationPreferences struct {
+	QuotaWarning bool `json:"quotaWarning"`
+	Expiration   bool `json:"expiration"`
+	Maintenance  bool `json:"maintenance"`
+	UsageReport  bool `json:"usageReport"`
 }
 
 // TenantPlan is the tenant plan information stored in the database
 type TenantPlan struct {
+	Version      int          `json:"version"`
 	Name         string       `json:"name"`
 	TenantStatus TenantStatus `json:"tenantStatus"`
 	Org          string       `json:"org"`
@@ -97,6 +128,36 @@ type TenantPlan struct {
 	UpdatedAt    time.Time    `json:"updatedAt"`
 	Policy       PlanPolicy   `json:"policy"`
 	Audit        string       `json:"audit"`
+
+	// Contacts and Notifications are replaced as a whole by a plan update, nil keeps the existing ones,
+	// so empty contacts clear them and all false preferences opt out of every notice
+	Contacts      *TenantContacts          `json:"contacts,omitempty"`
+	Notifications *NotificationPreferences `json:"notifications,omitempty"`
+
+	// AllowedOrigins is the browser origins allowed to call the tenant APIs, i.e. https://app.example.com or https://*.example.com
+	AllowedOrigins []string `json:"allowedOrigins,omitempty"`
+
+	// AllowedClusters is the Pulsar clusters fronted by burnell that the tenant may target, all clusters if empty
+	AllowedClusters []string `json:"allowedClusters,omitempty"`
+
+	// QuotaEnforcement is enforce or observe the plan quota and rate limits, the global QuotaEnforcement if empty
+	QuotaEnforcement string `json:"quotaEnforcement,omitempty"`
+
+	// Metadata is the custom key value pairs such as the upstream billing or CRM IDs
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	// SSO maps the console single sign on users to the tenant by their email domain or IdP group
+	SSO *TenantSSO `json:"sso,omitempty"`
+
+	// ExpiresAt is the end of a time limited plan such as a trial, nil never expires and keeps the existing expiry on update
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+
+	// Protected refuses the tenant deletion until a superuser clears it by SetProtected in a separate call
+	Protected bool `json:"protected,omitempty"`
+
+	// Encrypted is the sensitive fields encrypted at rest in the tenant topic, it is only set in the records
+	// of the store and never in the plans returned by the tenant manager
+	Encrypted *EncryptedFields `json:"encrypted,omitempty"`
 }
 
 // PlanPolicies struct
@@ -126,7 +187,11 @@ var TenantPlanPolicies = PlanPolicies{
 		NumOfProducers:       3,
 		NumOfConsumers:       5,
 		Functions:            1,
+		NumOfPartitions:      4,
 		FeatureCodes:         FeatureAllDisabled,
+		RequestRate:          20,
+		RequestBurst:         40,
+		BacklogQuotaMB:       512,
 	},
 	StarterPlan: PlanPolicy{
 		Name:                 StarterTier,
@@ -137,7 +202,11 @@ var TenantPlanPolicies = PlanPolicies{
 		NumOfProducers:       30,
 		NumOfConsumers:       50,
 		Functions:            10,
+		NumOfPartitions:      8,
 		FeatureCodes:         FeatureAllDisabled,
+		RequestRate:          50,
+		RequestBurst:         100,
+		BacklogQuotaMB:       2048,
 	},
 	ProductionPlan: PlanPolicy{
 		Name:                 ProductionTier,
@@ -148,7 +217,11 @@ var TenantPlanPolicies = PlanPolicies{
 		NumOfProducers:       60,
 		NumOfConsumers:       100,
 		Functions:            20,
+		NumOfPartitions:      16,
 		FeatureCodes:         FeatureAllDisabled,
+		RequestRate:          200,
+		RequestBurst:         400,
+		BacklogQuotaMB:       10240,
 	},
 	DedicatedPlan: PlanPolicy{
 		Name:                 DedicatedTier,
@@ -159,7 +232,11 @@ var TenantPlanPolicies = PlanPolicies{
 		NumOfProducers:       300,
 		NumOfConsumers:       500,
 		Functions:            30,
+		NumOfPartitions:      64,
 		FeatureCodes:         FeatureAllDisabled,
+		RequestRate:          1000,
+		RequestBurst:         2000,
+		BacklogQuotaMB:       102400,
 	},
 	PrivatePlan: PlanPolicy{
 		Name:                 PrivateTier,
@@ -170,7 +247,11 @@ var TenantPlanPolicies = PlanPolicies{
 		NumOfProducers:       -1,
 		NumOfConsumers:       -1,
 		Functions:            -1,
+		NumOfPartitions:      -1,
 		FeatureCodes:         FeatureAllEnabled,
+		RequestRate:          -1,
+		RequestBurst:         -1,
+		BacklogQuotaMB:       -1,
 	},
 }
 
@@ -195,20 +276,24 @@ func getPlanPolicy(plan string) *PlanPolicy {
 var TenantManager TenantPolicyHandler
 
 // PulsarBeamManager is the global object the manage the Pulsar Beam topic
-var PulsarBeamManager db.PulsarHandler
+var PulsarBeamManager PulsarBeamHandler
 
 // Initialize initializes database
 func Initialize() {
+	if err := loadConfiguredFieldBounds(); err != nil {
+		log.Fatal(err)
+	}
+	if err := LoadPlanEncryptionKeys(util.GetConfig().PolicyEncryptionKeys, util.GetConfig().PolicyEncryptionKeysFile); err != nil {
+		log.Fatal(err)
+	}
 	if err := TenantManager.Setup(); err != nil {
 		log.Fatal(err)
 	}
 
 	if util.GetConfig().PulsarBeamTopic != "" {
 
-		PulsarBeamManager = db.PulsarHandler{}
 		PulsarBeamManager.PulsarURL = util.GetConfig().PulsarURL
 		PulsarBeamManager.TopicName = util.GetConfig().PulsarBeamTopic
-		PulsarBeamManager.PulsarToken = util.GetConfig().PulsarToken
 		if err := PulsarBeamManager.Init(); err != nil {
 			log.Fatal(err)
 		}
@@ -218,6 +303,12 @@ func Initialize() {
 		panic(err)
 	}
 	CacheTopicStatsWorker()
+	if util.IsReadOnlyMode() {
+		// the primary burnell reconciles the deleted tenants and enforces the retention
+		return
+	}
+	DeletedTenantReconcileWorker()
+	RetentionEnforceWorker()
 }
 
 // Init is called at bootstrap to build feature codes
@@ -225,6 +316,26 @@ func Init() {
 
 }
 
+// PlanRequestRate returns the sustained requests per second and the burst of the tenant plan,
+// the plan type default applies to the plans created before the request rate fields
+func PlanRequestRate(t TenantPlan) (int, int) {
+	rate, burst := t.Policy.RequestRate, t.Policy.RequestBurst
+	if defaults := getPlanPolicy(strings.ToLower(t.PlanType)); defaults != nil {
+		rate = takeNonZero(rate, defaults.RequestRate)
+		burst = takeNonZero(burst, defaults.RequestBurst)
+	}
+	return rate, burst
+}
+
+// PlanBacklogQuotaMB returns the max backlog quota per topic of the tenant plan in MB,
+// the plan type default applies to the plans created before the backlog quota field
+func PlanBacklogQuotaMB(t TenantPlan) int {
+	if defaults := getPlanPolicy(strings.ToLower(t.PlanType)); defaults != nil {
+		return takeNonZero(t.Policy.BacklogQuotaMB, defaults.BacklogQuotaMB)
+	}
+	return t.Policy.BacklogQuotaMB
+}
+
 // IsFeatureSupported checks if the feature is supported
 func IsFeatureSupported(feature, featureCodes string) bool {
 	return featureCodes == FeatureAllEnabled || util.StrContains(strings.Split(featureCodes, ","), feature)
@@ -232,6 +343,7 @@ func IsFeatureSupported(feature, featureCodes string) bool {
 
 func newFreeTenantPlan(tenantName string) TenantPlan {
 	return TenantPlan{
+		Version:      TenantPlanVersion,
 		Name:         tenantName,
 		TenantStatus: Activated,
 		PlanType:     FreeTier,
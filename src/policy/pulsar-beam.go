@@ -0,0 +1,212 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package policy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/apex/log"
+	"github.com/datastax/burnell/src/util"
+	"github.com/kafkaesque-io/pulsar-beam/src/model"
+)
+
+// the errors of the Pulsar Beam topic configuration documents, the same as the pulsar-beam db package
+var (
+	ErrBeamDocNotFound      = errors.New("no document found")
+	ErrBeamDocAlreadyExists = errors.New("document already existed")
+)
+
+// PulsarBeamHandler keeps the Pulsar Beam topic configurations in a topic as the database.
+// The client authenticates with the token supplier so a refreshed token applies to the reconnects.
+type PulsarBeamHandler struct {
+	PulsarURL string
+	TopicName string
+
+	client     pulsar.Client
+	producer   pulsar.Producer
+	topics     map[string]model.TopicConfig
+	topicsLock sync.RWMutex
+	logger     *log.Entry
+}
+
+// Init creates the client and the producer, and starts the listener building the topic configurations in memory
+func (s *PulsarBeamHandler) Init() error {
+	s.logger = log.WithFields(log.Fields{"app": "pulsarbeamdb"})
+	s.topics = make(map[string]model.TopicConfig)
+
+	clientOpt := pulsar.ClientOptions{
+		URL:               s.PulsarURL,
+		OperationTimeout:  30 * time.Second,
+		ConnectionTimeout: 30 * time.Second,
+	}
+	if util.HasPulsarToken() {
+		clientOpt.Authentication = pulsar.NewAuthenticationTokenFromSupplier(util.PulsarTokenSupplier)
+	}
+	if strings.HasPrefix(s.PulsarURL, "pulsar+ssl://") {
+		trustStore := util.GetConfig().TrustStore
+		if trustStore == "" {
+			return fmt.Errorf("this is fatal that we are missing trustStore while pulsar+ssl is required")
+		}
+		clientOpt.TLSTrustCertsFilePath = trustStore
+	}
+
+	var err error
+	if s.client, err = pulsar.NewClient(clientOpt); err != nil {
+		return err
+	}
+	if s.producer, err = s.client.CreateProducer(pulsar.ProducerOptions{
+		Topic:           s.TopicName,
+		DisableBatching: true,
+	}); err != nil {
+		return err
+	}
+
+	go func() {
+		sig := make(chan *liveSignal)
+		go s.dbListener(sig)
+		for {
+			select {
+			case <-sig:
+				time.Sleep(time.Second)
+				go s.dbListener(sig)
+			}
+		}
+	}()
+	return nil
+}
+
+// dbListener reads the topic configurations from the earliest of the topic
+func (s *PulsarBeamHandler) dbListener(sig chan *liveSignal) {
+	defer func(termination chan *liveSignal) {
+		s.logger.Errorf("pulsar beam db listener terminated")
+		termination <- &liveSignal{}
+	}(sig)
+	reader, err := s.client.CreateReader(pulsar.ReaderOptions{
+		Topic:          s.TopicName,
+		StartMessageID: pulsar.EarliestMessageID(),
+		ReadCompacted:  true,
+	})
+	if err != nil {
+		util.PulsarClientFailed(util.PulsarBeamClient, s.TopicName, err)
+		return
+	}
+	defer reader.Close()
+	util.PulsarClientConnected(util.PulsarBeamClient, s.TopicName)
+
+	ctx := context.Background()
+	for {
+		data, err := reader.Next(ctx)
+		if err != nil {
+			util.PulsarClientFailed(util.PulsarBeamClient, s.TopicName, err)
+			return
+		}
+		util.PulsarClientMessage(util.PulsarBeamClient)
+		doc := model.TopicConfig{}
+		if err = json.Unmarshal(data.Payload(), &doc); err != nil {
+			s.logger.Errorf("pulsar beam topic configuration unmarshal error %v", err)
+			continue
+		}
+		s.topicsLock.Lock()
+		if doc.TopicStatus != model.Deleted {
+			s.topics[doc.Key] = doc
+		} else {
+			delete(s.topics, doc.Key)
+		}
+		s.topicsLock.Unlock()
+	}
+}
+
+// send publishes a topic configuration document keyed by the document key
+func (s *PulsarBeamHandler) send(doc model.TopicConfig) error {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	_, err = s.producer.Send(context.Background(), &pulsar.ProducerMessage{
+		Payload: data,
+		Key:     doc.Key,
+	})
+	return err
+}
+
+// GetByKey gets a topic configuration by the key
+func (s *PulsarBeamHandler) GetByKey(hashedTopicKey string) (*model.TopicConfig, error) {
+	s.topicsLock.RLock()
+	defer s.topicsLock.RUnlock()
+	if v, ok := s.topics[hashedTopicKey]; ok {
+		return &v, nil
+	}
+	return &model.TopicConfig{}, ErrBeamDocNotFound
+}
+
+// Update updates or creates a topic configuration, and returns the key
+func (s *PulsarBeamHandler) Update(topicCfg *model.TopicConfig) (string, error) {
+	key, err := model.GetKeyFromNames(topicCfg.TopicFullName, topicCfg.PulsarURL)
+	if err != nil {
+		return key, err
+	}
+	now := time.Now()
+	s.topicsLock.RLock()
+	existing, ok := s.topics[key]
+	s.topicsLock.RUnlock()
+	topicCfg.Key = key
+	topicCfg.UpdatedAt = now
+	if ok {
+		topicCfg.CreatedAt = existing.CreatedAt
+	} else {
+		topicCfg.CreatedAt = now
+	}
+
+	if err = s.send(*topicCfg); err != nil {
+		return "", err
+	}
+	s.logger.Infof("upsert %s", key)
+	s.topicsLock.Lock()
+	s.topics[key] = *topicCfg
+	s.topicsLock.Unlock()
+	return key, nil
+}
+
+// DeleteByKey deletes a topic configuration by the key
+func (s *PulsarBeamHandler) DeleteByKey(hashedTopicKey string) (string, error) {
+	s.topicsLock.RLock()
+	v, ok := s.topics[hashedTopicKey]
+	s.topicsLock.RUnlock()
+	if !ok {
+		return "", ErrBeamDocNotFound
+	}
+	v.TopicStatus = model.Deleted
+	if err := s.send(v); err != nil {
+		return "", err
+	}
+	s.topicsLock.Lock()
+	delete(s.topics, hashedTopicKey)
+	s.topicsLock.Unlock()
+	return hashedTopicKey, nil
+}
@@ -22,16 +22,22 @@
 package policy
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/datastax/burnell/src/chaos"
 	"github.com/datastax/burnell/src/util"
 
 	"github.com/apex/log"
@@ -48,18 +54,41 @@ type liveSignal struct{}
 type TenantPolicyHandler struct {
 	client      pulsar.Client
 	topicName   string
+	store       TenantStore
 	tenants     map[string]TenantPlan
 	tenantsLock sync.RWMutex
 	logger      *log.Entry
+	// keyIDs is the ID of the key encrypting the latest record of each tenant, guarded by tenantsLock
+	keyIDs map[string]string
+	// undecryptable is the ciphertext of the plans the configured keys can't decrypt, guarded by tenantsLock.
+	// It is only written back to the store, the cached plans returned to the callers never carry it.
+	undecryptable map[string]*EncryptedFields
+	// warm is 1 once the listener caught up to the latest message of the topic at startup
+	warm int32
+	// cacheOnly is the creation time of the free plans not persisted in the topic, guarded by tenantsLock
+	cacheOnly map[string]time.Time
+	// deleted is the deletion time of the deleted tenants, guarded by tenantsLock
+	deleted map[string]time.Time
+	// orgs, emailDomains and idpGroups index the cached tenant names by the lower case org, the lower case
+	// SSO email domain and the SSO IdP group, guarded by tenantsLock
+	orgs         tenantIndex
+	emailDomains tenantIndex
+	idpGroups    tenantIndex
+	// replay is the records read by the listener from the topic, guarded by tenantsLock
+	replay topicReplay
+	// cursor checkpoints the listener position with the cache to resume from on restart
+	cursor *util.ReaderCursor
 }
 
+// the max wait for the tenant cache to warm up before serving tenant plan reads anyway
+var tenantCacheWarmTimeout = time.Duration(util.GetEnvInt("TenantCacheWarmTimeoutSeconds", 120)) * time.Second
+
 //Setup sets up the database
 func (s *TenantPolicyHandler) Setup() error {
-	s.logger = log.WithFields(log.Fields{"app": "tenantdb"})
-	s.tenants = make(map[string]TenantPlan)
+	s.initCache()
 	pulsarURL := util.GetConfig().PulsarURL
-	s.topicName = util.AssignString(util.GetConfig().TenantManagmentTopic, "persistent://public/default/tenants-management")
-	tokenStr := util.GetConfig().PulsarToken
+	s.topicName = tenantTopicName()
+	s.cursor = util.NewReaderCursor(util.TenantPolicyCursor, s.topicName, s.cacheSnapshot)
 
 	clientOpt := pulsar.ClientOptions{
 		URL:               pulsarURL,
@@ -67,8 +96,9 @@ func (s *TenantPolicyHandler) Setup() error {
 		ConnectionTimeout: 30 * time.Second,
 	}
 
-	if tokenStr != "" {
-		clientOpt.Authentication = pulsar.NewAuthenticationToken(tokenStr)
+	// the token supplier gives the refreshed token to the reconnects without restarting the listener loop
+	if util.HasPulsarToken() {
+		clientOpt.Authentication = pulsar.NewAuthenticationTokenFromSupplier(util.PulsarTokenSupplier)
 	}
 
 	if strings.HasPrefix(pulsarURL, "pulsar+ssl://") {
@@ -84,6 +114,14 @@ func (s *TenantPolicyHandler) Setup() error {
 	if err != nil {
 		return err
 	}
+	s.store = &pulsarTenantStore{client: s.client, topicName: s.topicName}
+
+	time.AfterFunc(tenantCacheWarmTimeout, func() {
+		if !s.IsWarm() {
+			s.logger.Errorf("tenant cache is not warm after %v, start serving tenant plan reads", tenantCacheWarmTimeout)
+			s.markWarm()
+		}
+	})
 
 	go func() {
 		sig := make(chan *liveSignal)
@@ -95,6 +133,7 @@ func (s *TenantPolicyHandler) Setup() error {
 			}
 		}
 	}()
+	go s.tenantCacheCompactor()
 
 	return nil
 }
@@ -108,50 +147,129 @@ func (s *TenantPolicyHandler) dbListener(sig chan *liveSignal) error {
 	s.logger.Infof("listens to tenant database changes")
 	reader, err := s.client.CreateReader(pulsar.ReaderOptions{
 		Topic:          s.topicName,
-		StartMessageID: pulsar.EarliestMessageID(),
+		StartMessageID: s.cursor.Start(s.restoreCache),
+		ReadCompacted:  tenantTopicReadCompacted,
 	})
 
 	if err != nil {
+		util.PulsarClientFailed(util.TenantReaderClient, s.topicName, err)
 		return err
 	}
 	defer reader.Close()
+	util.PulsarClientConnected(util.TenantReaderClient, s.topicName)
+	s.tenantsLock.Lock()
+	s.replay.start()
+	s.tenantsLock.Unlock()
+	if !s.IsWarm() && !reader.HasNext() {
+		s.markWarm()
+	}
 
 	ctx := context.Background()
 
 	// infinite loop to receive messages
 	for {
-		data, err := reader.Next(ctx)
+		data, err := s.cursor.Next(ctx, reader)
 		if err != nil {
 			log.Errorf("tenant db listener reader error %v", err)
+			util.PulsarClientFailed(util.TenantReaderClient, s.topicName, err)
 			return err
 		}
-		t := TenantPlan{}
-		if err = json.Unmarshal(data.Payload(), &t); err != nil {
-			s.logger.Errorf("tenant unmarshal error %v", err)
+		util.PulsarClientMessage(util.TenantReaderClient)
+		s.ApplyTenantRecord(data.Payload())
+		s.cursor.Processed(data.ID())
+		if !s.IsWarm() && !reader.HasNext() {
+			s.markWarm()
+			s.cursor.Flush()
 		}
-		s.logger.Infof("tenant %s plan %v", t.Name, t)
+	}
+}
 
-		s.tenantsLock.Lock()
-		if t.TenantStatus != Deleted {
-			s.tenants[t.Name] = t
+// ApplyTenantRecord applies a tenant plan record of the tenant topic to the cache, and returns the plan
+func (s *TenantPolicyHandler) ApplyTenantRecord(payload []byte) TenantPlan {
+	t := TenantPlan{}
+	if err := json.Unmarshal(payload, &t); err != nil {
+		s.logger.Errorf("tenant unmarshal error %v", err)
+	}
+	// upgrade historical records in cache, they are rewritten in the current version on the next update
+	if migrated, ok, err := MigrateTenantPlan(t); err != nil {
+		s.logger.Errorf("tenant %s plan migration error %v", t.Name, err)
+	} else if ok {
+		s.logger.Infof("tenant %s plan migrated to version %d", t.Name, migrated.Version)
+		t = migrated
+	}
+	keyID := ""
+	// a record encrypted before the metadata encryption has the plaintext metadata, and is rewritten by the reencryption
+	if t.Encrypted != nil && (t.Encrypted.Metadata != "" || len(t.Metadata) == 0) {
+		keyID = t.Encrypted.KeyID
+	}
+	// an undecryptable plan keeps the ciphertext so that it is never overwritten without the encrypted fields
+	var ciphertext *EncryptedFields
+	if decrypted, err := DecryptTenantPlan(t); err != nil {
+		s.logger.Errorf("tenant %s plan decryption error %v", t.Name, err)
+		ciphertext = t.Encrypted
+	} else {
+		t = decrypted
+	}
+	t.Encrypted = nil
+	s.logger.Infof("tenant %s plan %s status %d", t.Name, t.PlanType, t.TenantStatus)
+
+	s.tenantsLock.Lock()
+	s.replay.record(t.Name)
+	if t.TenantStatus != Deleted {
+		s.cacheTenant(t)
+		s.keyIDs[t.Name] = keyID
+		if ciphertext != nil {
+			s.undecryptable[t.Name] = ciphertext
 		} else {
-			delete(s.tenants, t.Name)
+			delete(s.undecryptable, t.Name)
 		}
+	} else {
+		s.uncacheTenant(t.Name)
+		delete(s.keyIDs, t.Name)
+		delete(s.undecryptable, t.Name)
+	}
+	s.markTenantDeleted(t.Name, t.TenantStatus == Deleted)
+	s.tenantsLock.Unlock()
+	trackDeletedTenant(t)
+	publishTenantPlanEvent(t, !s.IsWarm())
+	return t
+}
+
+// IsWarm returns true once the tenant cache caught up to the tenant management topic at startup
+func (s *TenantPolicyHandler) IsWarm() bool {
+	return atomic.LoadInt32(&s.warm) == 1
+}
+
+func (s *TenantPolicyHandler) markWarm() {
+	if atomic.CompareAndSwapInt32(&s.warm, 0, 1) {
+		s.tenantsLock.Lock()
+		s.replay.warm()
+		s.logger.Infof("tenant cache is warm with %d tenants", len(s.tenants))
 		s.tenantsLock.Unlock()
 	}
 }
 
 // UpdateTenant creates or updates a tenant plan
 func (s *TenantPolicyHandler) UpdateTenant(tenantName string, tenantPlan TenantPlan) (TenantPlan, int, error) {
-	existingTenant, _ := s.GetTenant(tenantName)
+	existingTenant, notFound := s.GetTenant(tenantName)
+	if err := ValidateTenantName(tenantName, notFound != nil); err != nil {
+		return TenantPlan{}, http.StatusUnprocessableEntity, err
+	}
+	if err := ValidateTenantPlan(tenantPlan); err != nil {
+		return TenantPlan{}, http.StatusUnprocessableEntity, err
+	}
 	tenantPlan.Name = tenantName //enforce tenant in the database record
 	newPlan, err := ReconcileTenantPlan(tenantPlan, existingTenant)
-	if err != nil {
+	if errors.Is(err, ErrTenantProtected) {
+		return TenantPlan{}, http.StatusConflict, err
+	} else if err != nil {
 		return TenantPlan{}, http.StatusUnprocessableEntity, err
 	}
 
 	updatedPlan, err := s.updateDb(newPlan)
-	if err != nil {
+	if errors.Is(err, ErrPlanUndecryptable) {
+		return TenantPlan{}, http.StatusConflict, err
+	} else if err != nil {
 		return TenantPlan{}, http.StatusInternalServerError, err
 	}
 	return updatedPlan, http.StatusOK, nil
@@ -159,43 +277,134 @@ func (s *TenantPolicyHandler) UpdateTenant(tenantName string, tenantPlan TenantP
 
 // updateDb updates records directly on DB with no validation
 func (s *TenantPolicyHandler) updateDb(tenantPlan TenantPlan) (TenantPlan, error) {
-
-	producer, err := s.client.CreateProducer(pulsar.ProducerOptions{
-		Topic:           s.topicName,
-		DisableBatching: true,
-	})
-	if err != nil {
+	if _, err := chaos.Inject(chaos.PolicyStore, tenantPlan.Name); err != nil {
 		return TenantPlan{}, err
 	}
-	defer producer.Close()
+
+	s.tenantsLock.RLock()
+	ciphertext, undecryptable := s.undecryptable[tenantPlan.Name]
+	s.tenantsLock.RUnlock()
+	if undecryptable && tenantPlan.TenantStatus != Deleted {
+		return TenantPlan{}, fmt.Errorf("tenant %s %w", tenantPlan.Name, ErrPlanUndecryptable)
+	}
 
 	tenantPlan.UpdatedAt = time.Now()
-	ctx := context.Background()
-	data, err := json.Marshal(tenantPlan)
+	tenantPlan.Version = TenantPlanVersion
+	tenantPlan.Encrypted = nil
+	record, err := EncryptTenantPlan(tenantPlan)
 	if err != nil {
 		return TenantPlan{}, err
 	}
-	msg := pulsar.ProducerMessage{
-		Payload: data,
-		Key:     tenantPlan.Name,
+	if undecryptable {
+		// the deletion record carries the fields that can't be decrypted
+		record.Encrypted = ciphertext
 	}
-
-	if _, err = producer.Send(ctx, &msg); err != nil {
+	data, err := json.Marshal(record)
+	if err != nil {
 		return TenantPlan{}, err
 	}
-	producer.Flush()
-
-	s.logger.Infof("send to Pulsar %s", tenantPlan.Name)
+	if err = s.store.Write(tenantPlan.Name, data); err != nil {
+		return TenantPlan{}, err
+	}
+	s.logger.Infof("tenant %s plan is written to the store", tenantPlan.Name)
 
 	s.tenantsLock.Lock()
-	s.tenants[tenantPlan.Name] = tenantPlan
+	s.cacheTenant(tenantPlan)
+	s.keyIDs[tenantPlan.Name] = ActivePlanEncryptionKey()
+	s.markTenantDeleted(tenantPlan.Name, tenantPlan.TenantStatus == Deleted)
 	s.tenantsLock.Unlock()
 	return tenantPlan, nil
 }
 
+// ReencryptTenants rewrites the tenant plans not encrypted by the active key after a key rotation,
+// or not encrypted at all once the encryption is enabled. It returns the number of rewritten plans.
+// The plans that can't be decrypted by the configured keys are skipped.
+func (s *TenantPolicyHandler) ReencryptTenants() (int, error) {
+	activeID := ActivePlanEncryptionKey()
+	if activeID == "" {
+		return 0, fmt.Errorf("policy encryption is not enabled")
+	}
+	stale := []TenantPlan{}
+	s.tenantsLock.RLock()
+	for name, t := range s.tenants {
+		// cache only plans are never written to the topic
+		if _, skip := s.undecryptable[name]; skip {
+			continue
+		}
+		if keyID, ok := s.keyIDs[name]; ok && keyID != activeID {
+			stale = append(stale, t)
+		}
+	}
+	s.tenantsLock.RUnlock()
+
+	for i, t := range stale {
+		if _, err := s.updateDb(t); err != nil {
+			return i, err
+		}
+	}
+	return len(stale), nil
+}
+
+// SetAllowedOrigins replaces the allowed browser origins of the tenant, an empty list disallows all tenant specific origins
+func (s *TenantPolicyHandler) SetAllowedOrigins(tenantName string, origins []string) (TenantPlan, int, error) {
+	if err := ValidateAllowedOrigins(origins); err != nil {
+		return TenantPlan{}, http.StatusUnprocessableEntity, err
+	}
+	plan, err := s.GetTenant(tenantName)
+	if err != nil {
+		return TenantPlan{}, http.StatusNotFound, err
+	}
+	plan.AllowedOrigins = origins
+	plan.Audit = plan.Audit + ",allowed origins updated"
+	updatedPlan, err := s.updateDb(plan)
+	if err != nil {
+		return TenantPlan{}, http.StatusInternalServerError, err
+	}
+	return updatedPlan, http.StatusOK, nil
+}
+
+// SetProtected sets or clears the deletion protection of the tenant
+func (s *TenantPolicyHandler) SetProtected(tenantName string, protected bool) (TenantPlan, int, error) {
+	plan, err := s.GetTenant(tenantName)
+	if err != nil {
+		return TenantPlan{}, http.StatusNotFound, err
+	}
+	if plan.Protected == protected {
+		return plan, http.StatusOK, nil
+	}
+	plan.Protected = protected
+	if protected {
+		plan.Audit = plan.Audit + ",deletion protection set"
+	} else {
+		plan.Audit = plan.Audit + ",deletion protection cleared"
+	}
+	updatedPlan, err := s.updateDb(plan)
+	if err != nil {
+		return TenantPlan{}, http.StatusInternalServerError, err
+	}
+	return updatedPlan, http.StatusOK, nil
+}
+
+// IsProtected returns true if the tenant plan is protected from deletion
+func (s *TenantPolicyHandler) IsProtected(tenantName string) bool {
+	plan, err := s.GetTenant(tenantName)
+	return err == nil && plan.Protected
+}
+
+// IsOriginAllowed evaluates if the browser origin is allowed by the tenant
+func (s *TenantPolicyHandler) IsOriginAllowed(tenantName, origin string) bool {
+	plan, err := s.GetTenant(tenantName)
+	if err != nil {
+		return false
+	}
+	return MatchOrigin(plan.AllowedOrigins, origin)
+}
+
 // Close closes database
 func (s *TenantPolicyHandler) Close() error {
-	s.client.Close()
+	if s.client != nil {
+		s.client.Close()
+	}
 	return nil
 }
 
@@ -209,6 +418,18 @@ func (s *TenantPolicyHandler) GetTenant(tenantName string) (TenantPlan, error) {
 	return TenantPlan{}, fmt.Errorf("tenant not found in database")
 }
 
+// ListTenants returns the tenant plans in the cache sorted by the name
+func (s *TenantPolicyHandler) ListTenants() []TenantPlan {
+	s.tenantsLock.RLock()
+	defer s.tenantsLock.RUnlock()
+	plans := make([]TenantPlan, 0, len(s.tenants))
+	for _, t := range s.tenants {
+		plans = append(plans, t)
+	}
+	sort.Slice(plans, func(i, j int) bool { return plans[i].Name < plans[j].Name })
+	return plans
+}
+
 // GetOrCreateTenant gets a tenant. It creates a tenant with free plan if it does not exist in cache only.
 func (s *TenantPolicyHandler) GetOrCreateTenant(tenantName string) (TenantPlan, error) {
 	t, err := s.GetTenant(tenantName)
@@ -216,13 +437,19 @@ func (s *TenantPolicyHandler) GetOrCreateTenant(tenantName string) (TenantPlan,
 		s.logger.Errorf("tenant %s not found in plan policy database", tenantName)
 		t = newFreeTenantPlan(tenantName)
 		s.tenantsLock.Lock()
-		s.tenants[tenantName] = t
+		if tenantCacheOnlyMax <= 0 || len(s.cacheOnly) < tenantCacheOnlyMax {
+			s.cacheTenant(t)
+			s.cacheOnly[tenantName] = time.Now()
+		}
 		s.tenantsLock.Unlock()
 	}
 	return t, nil
 }
 
-// DeleteTenant gets a tenant by the name
+// ErrTenantProtected is the deletion of a tenant protected from deletion
+var ErrTenantProtected = errors.New("tenant is protected from deletion, a superuser has to clear the protection first")
+
+// DeleteTenant deletes a tenant by the name unless it is protected
 func (s *TenantPolicyHandler) DeleteTenant(tenantName string) (TenantPlan, error) {
 	s.tenantsLock.RLock()
 	t, ok := s.tenants[tenantName]
@@ -230,6 +457,9 @@ func (s *TenantPolicyHandler) DeleteTenant(tenantName string) (TenantPlan, error
 	if !ok {
 		return TenantPlan{}, fmt.Errorf("not found")
 	}
+	if t.Protected {
+		return TenantPlan{}, ErrTenantProtected
+	}
 
 	t.TenantStatus = Deleted
 	if _, err := s.updateDb(t); err != nil {
@@ -237,7 +467,10 @@ func (s *TenantPolicyHandler) DeleteTenant(tenantName string) (TenantPlan, error
 	}
 
 	s.tenantsLock.Lock()
-	delete(s.tenants, tenantName)
+	s.uncacheTenant(tenantName)
+	delete(s.keyIDs, tenantName)
+	delete(s.undecryptable, tenantName)
+	s.markTenantDeleted(tenantName, true)
 	s.tenantsLock.Unlock()
 	return t, nil
 }
@@ -253,14 +486,13 @@ func (s *TenantPolicyHandler) EvaluateFeatureCode(tenant, featureCode string) bo
 // ReconcileTenantPlan reconcile tenant plan with the requested and existing plan in the database
 func ReconcileTenantPlan(reqPlan, existingPlan TenantPlan) (TenantPlan, error) {
 	reqPlan.UpdatedAt = time.Now()
-	emptyPlan := TenantPlan{}
 	emptyPolicy := PlanPolicy{}
 	reqPlanPolicy := getPlanPolicy(strings.ToLower(reqPlan.PlanType))
 	if reqPlanPolicy == nil {
 		return TenantPlan{}, fmt.Errorf("a valid plan type is missing")
 	}
 
-	if emptyPlan == existingPlan {
+	if existingPlan.Name == "" {
 		// this is new creation
 		if reqPlan.Audit == "" {
 			reqPlan.Audit = "initial creation,"
@@ -272,11 +504,21 @@ func ReconcileTenantPlan(reqPlan, existingPlan TenantPlan) (TenantPlan, error) {
 		return reqPlan, nil
 	}
 
+	// the protection is only changed by SetProtected, and a protected tenant cannot be deleted by the plan update
+	if existingPlan.Protected && reqPlan.TenantStatus == Deleted {
+		return TenantPlan{}, ErrTenantProtected
+	}
+	reqPlan.Protected = existingPlan.Protected
+
 	reqPlan.Policy.NumOfTopics = takeNonZero(reqPlan.Policy.NumOfTopics, existingPlan.Policy.NumOfTopics)
 	reqPlan.Policy.NumOfNamespaces = takeNonZero(reqPlan.Policy.NumOfNamespaces, existingPlan.Policy.NumOfNamespaces)
 	reqPlan.Policy.NumOfProducers = takeNonZero(reqPlan.Policy.NumOfProducers, existingPlan.Policy.NumOfProducers)
 	reqPlan.Policy.NumOfConsumers = takeNonZero(reqPlan.Policy.NumOfConsumers, existingPlan.Policy.NumOfConsumers)
 	reqPlan.Policy.Functions = takeNonZero(reqPlan.Policy.Functions, existingPlan.Policy.Functions)
+	reqPlan.Policy.NumOfPartitions = takeNonZero(reqPlan.Policy.NumOfPartitions, existingPlan.Policy.NumOfPartitions)
+	reqPlan.Policy.RequestRate = takeNonZero(reqPlan.Policy.RequestRate, existingPlan.Policy.RequestRate)
+	reqPlan.Policy.RequestBurst = takeNonZero(reqPlan.Policy.RequestBurst, existingPlan.Policy.RequestBurst)
+	reqPlan.Policy.BacklogQuotaMB = takeNonZero(reqPlan.Policy.BacklogQuotaMB, existingPlan.Policy.BacklogQuotaMB)
 	reqPlan.Policy.Name = util.AssignString(reqPlan.Policy.Name, existingPlan.Policy.Name)
 	reqPlan.Policy.FeatureCodes = util.AssignString(reqPlan.Policy.FeatureCodes, existingPlan.Policy.FeatureCodes)
 
@@ -288,6 +530,30 @@ func ReconcileTenantPlan(reqPlan, existingPlan TenantPlan) (TenantPlan, error) {
 	reqPlan.TenantStatus = takeTenantStatus(reqPlan.TenantStatus, existingPlan.TenantStatus)
 	reqPlan.Org = util.AssignString(reqPlan.Org, existingPlan.Org)
 	reqPlan.Users = util.AssignString(reqPlan.Users, existingPlan.Users)
+	if reqPlan.Contacts == nil {
+		reqPlan.Contacts = existingPlan.Contacts
+	}
+	if reqPlan.Notifications == nil {
+		reqPlan.Notifications = existingPlan.Notifications
+	}
+	if len(reqPlan.AllowedOrigins) == 0 {
+		reqPlan.AllowedOrigins = existingPlan.AllowedOrigins
+	}
+	if len(reqPlan.AllowedClusters) == 0 {
+		reqPlan.AllowedClusters = existingPlan.AllowedClusters
+	}
+	if reqPlan.QuotaEnforcement == "" {
+		reqPlan.QuotaEnforcement = existingPlan.QuotaEnforcement
+	}
+	if len(reqPlan.Metadata) == 0 {
+		reqPlan.Metadata = existingPlan.Metadata
+	}
+	if reqPlan.SSO == nil {
+		reqPlan.SSO = existingPlan.SSO
+	}
+	if reqPlan.ExpiresAt == nil {
+		reqPlan.ExpiresAt = existingPlan.ExpiresAt
+	}
 
 	reqPlan.Audit = existingPlan.Audit + "," + reqPlan.Audit
 	return reqPlan, nil
@@ -360,6 +626,22 @@ func (s *TenantPolicyHandler) GetFunctionsLimit(tenant string) int {
 	return getPlanPolicy(FreeTier).Functions
 }
 
+// GetPartitionsLimit gets the max number of partitions per topic the plan supports, -1 is unlimited.
+// It takes the plan type default if the plan policy does not specify it.
+func (s *TenantPolicyHandler) GetPartitionsLimit(tenant string) int {
+	s.tenantsLock.RLock()
+	defer s.tenantsLock.RUnlock()
+	if t, ok := s.tenants[tenant]; ok {
+		if t.Policy.NumOfPartitions != 0 {
+			return t.Policy.NumOfPartitions
+		}
+		if p := getPlanPolicy(strings.ToLower(t.PlanType)); p != nil {
+			return p.NumOfPartitions
+		}
+	}
+	return getPlanPolicy(FreeTier).NumOfPartitions
+}
+
 // AdminAPIGETRespStringArray is a template tenant call that returns an array of string
 func AdminAPIGETRespStringArray(subroute string) ([]string, error) {
 	requestURL := util.SingleJoinSlash(util.SingleJoinSlash(util.Config.BrokerProxyURL, "/admin/v2"), subroute)
@@ -371,7 +653,7 @@ func AdminAPIGETRespStringArray(subroute string) ([]string, error) {
 		return empty, err
 	}
 	newRequest.Header.Add("X-Proxy", "burnell")
-	newRequest.Header.Add("Authorization", "Bearer "+util.Config.PulsarToken)
+	newRequest.Header.Add("Authorization", "Bearer "+util.PulsarAuthToken())
 	client := &http.Client{
 		CheckRedirect: util.PreserveHeaderForRedirect,
 	}
@@ -397,3 +679,51 @@ func AdminAPIGETRespStringArray(subroute string) ([]string, error) {
 
 	return respStrs, nil
 }
+
+// adminAPIRequest sends a request with the JSON body unless it is nil to the broker admin v2 REST API,
+// and unmarshals the response body into v unless v is nil or the response has no content
+func adminAPIRequest(method, subroute string, body, v interface{}) (int, error) {
+	requestURL := util.SingleJoinSlash(util.SingleJoinSlash(util.Config.BrokerProxyURL, "/admin/v2"), subroute)
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+	newRequest, err := http.NewRequest(method, requestURL, reqBody)
+	if err != nil {
+		return 0, err
+	}
+	if body != nil {
+		newRequest.Header.Set("Content-Type", "application/json")
+	}
+	newRequest.Header.Add("X-Proxy", "burnell")
+	newRequest.Header.Add("Authorization", "Bearer "+util.PulsarAuthToken())
+	client := &http.Client{
+		CheckRedirect: util.PreserveHeaderForRedirect,
+		Timeout:       30 * time.Second,
+	}
+	response, err := client.Do(newRequest)
+	if response != nil {
+		defer response.Body.Close()
+	}
+	if err != nil {
+		return 0, err
+	}
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, fmt.Errorf("%s %s response status code %d", method, requestURL, response.StatusCode)
+	}
+	if v == nil || response.StatusCode == http.StatusNoContent {
+		return response.StatusCode, nil
+	}
+	respBody, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return response.StatusCode, err
+	}
+	if len(respBody) == 0 {
+		return response.StatusCode, nil
+	}
+	return response.StatusCode, json.Unmarshal(respBody, v)
+}
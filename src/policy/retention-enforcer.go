@@ -0,0 +1,203 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package policy
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/datastax/burnell/src/util"
+)
+
+// RetentionPolicies is the namespace retention policies of the broker admin REST API
+type RetentionPolicies struct {
+	RetentionTimeInMinutes int   `json:"retentionTimeInMinutes"`
+	RetentionSizeInMB      int64 `json:"retentionSizeInMB"`
+}
+
+// NamespaceRetention is the retention and TTL of a namespace against the tenant plan
+type NamespaceRetention struct {
+	Namespace        string `json:"namespace"`
+	RetentionMinutes int    `json:"retentionMinutes"`
+	// TTLSeconds is the message TTL, 0 is not set
+	TTLSeconds int `json:"ttlSeconds"`
+	// AbovePlan is true when the namespace is manually configured to retain longer than the plan
+	AbovePlan bool `json:"abovePlan"`
+	// Enforced is true when the retention or TTL was set to the plan in the last run
+	Enforced bool   `json:"enforced"`
+	Error    string `json:"error,omitempty"`
+}
+
+// TenantRetentionSummary is the retention enforcement result of a tenant
+type TenantRetentionSummary struct {
+	Tenant               string               `json:"tenant"`
+	PlanRetentionMinutes int                  `json:"planRetentionMinutes"`
+	CheckedAt            time.Time            `json:"checkedAt"`
+	Namespaces           []NamespaceRetention `json:"namespaces"`
+	Error                string               `json:"error,omitempty"`
+}
+
+// Drifted returns true if any namespace is above the plan or failed to be enforced
+func (t TenantRetentionSummary) Drifted() bool {
+	if t.Error != "" {
+		return true
+	}
+	for _, ns := range t.Namespaces {
+		if ns.AbovePlan || ns.Error != "" {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	retentionSummaries     = make(map[string]TenantRetentionSummary)
+	retentionSummariesLock = sync.RWMutex{}
+
+	retentionLog = log.WithFields(log.Fields{"app": "retention-enforcer"})
+)
+
+// planRetentionMinutes returns the retention of the plan in minutes, -1 is infinite and 0 is not enforced
+func planRetentionMinutes(t TenantPlan) int {
+	if IsFeatureSupported(InfiniteMessageRetention, t.Policy.FeatureCodes) || t.Policy.MessageHourRetention < 0 {
+		return -1
+	}
+	return t.Policy.MessageHourRetention * 60
+}
+
+// EnforceTenantRetention sets the retention and TTL of every namespace of the tenant to the plan,
+// a namespace configured above the plan is flagged but left as is
+func EnforceTenantRetention(t TenantPlan) TenantRetentionSummary {
+	summary := TenantRetentionSummary{
+		Tenant:               t.Name,
+		PlanRetentionMinutes: planRetentionMinutes(t),
+		CheckedAt:            time.Now(),
+		Namespaces:           []NamespaceRetention{},
+	}
+	if summary.PlanRetentionMinutes == 0 {
+		return summary
+	}
+	namespaces := []string{}
+	if _, err := adminAPIRequest(http.MethodGet, "namespaces/"+t.Name, nil, &namespaces); err != nil {
+		summary.Error = err.Error()
+		return summary
+	}
+	for _, ns := range namespaces {
+		summary.Namespaces = append(summary.Namespaces, enforceNamespaceRetention(ns, summary.PlanRetentionMinutes))
+	}
+	return summary
+}
+
+func enforceNamespaceRetention(ns string, planMinutes int) NamespaceRetention {
+	result := NamespaceRetention{Namespace: ns}
+	retention := RetentionPolicies{}
+	if _, err := adminAPIRequest(http.MethodGet, "namespaces/"+ns+"/retention", nil, &retention); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.RetentionMinutes = retention.RetentionTimeInMinutes
+	if _, err := adminAPIRequest(http.MethodGet, "namespaces/"+ns+"/messageTTL", nil, &result.TTLSeconds); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	if planMinutes > 0 && (retention.RetentionTimeInMinutes < 0 || retention.RetentionTimeInMinutes > planMinutes) {
+		result.AbovePlan = true
+		retentionLog.Warnf("namespace %s retention %d minutes is above the plan %d minutes", ns, retention.RetentionTimeInMinutes, planMinutes)
+		return result
+	}
+	if retention.RetentionTimeInMinutes != planMinutes {
+		if retention.RetentionSizeInMB == 0 {
+			retention.RetentionSizeInMB = -1
+		}
+		retention.RetentionTimeInMinutes = planMinutes
+		if _, err := adminAPIRequest(http.MethodPost, "namespaces/"+ns+"/retention", retention, nil); err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		result.RetentionMinutes = planMinutes
+		result.Enforced = true
+	}
+	// messages are not expired under infinite retention
+	if planTTL := planMinutes * 60; planMinutes > 0 && result.TTLSeconds != planTTL {
+		if result.TTLSeconds > planTTL {
+			result.AbovePlan = true
+			return result
+		}
+		if _, err := adminAPIRequest(http.MethodPost, "namespaces/"+ns+"/messageTTL", planTTL, nil); err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		result.TTLSeconds = planTTL
+		result.Enforced = true
+	}
+	return result
+}
+
+// EnforceRetention enforces the plan retention of all tenants and keeps the summaries
+func EnforceRetention() {
+	for _, t := range TenantManager.ListTenants() {
+		summary := EnforceTenantRetention(t)
+		retentionSummariesLock.Lock()
+		retentionSummaries[t.Name] = summary
+		retentionSummariesLock.Unlock()
+	}
+}
+
+// GetRetentionSummaries returns the last retention enforcement result of each tenant sorted by the tenant name,
+// only the drifted tenants if driftOnly
+func GetRetentionSummaries(driftOnly bool) []TenantRetentionSummary {
+	retentionSummariesLock.RLock()
+	defer retentionSummariesLock.RUnlock()
+	summaries := []TenantRetentionSummary{}
+	for _, s := range retentionSummaries {
+		if !driftOnly || s.Drifted() {
+			summaries = append(summaries, s)
+		}
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].Tenant < summaries[j].Tenant })
+	return summaries
+}
+
+// RetentionEnforceWorker periodically enforces the plan retention,
+// it is disabled unless RetentionEnforceIntervalSeconds is set as an environment variable
+func RetentionEnforceWorker() {
+	interval := time.Duration(util.GetEnvInt("RetentionEnforceIntervalSeconds", 0)) * time.Second
+	if interval <= 0 {
+		return
+	}
+	retentionLog.Infof("enforce plan retention every %v", interval)
+	go func() {
+		ticker := time.NewTicker(interval)
+		for {
+			select {
+			case <-ticker.C:
+				if TenantManager.IsWarm() {
+					EnforceRetention()
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,315 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package policy
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/apex/log"
+	"github.com/datastax/burnell/src/metrics"
+	"github.com/datastax/burnell/src/notification"
+	"github.com/datastax/burnell/src/util"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// the message property of the probe id
+const probeProperty = "burnell-probe"
+
+// ProbeFunc produces a message on the topic and consumes it back within the timeout, it returns the end to end latency
+type ProbeFunc func(topic string, timeout time.Duration) (time.Duration, error)
+
+// TenantProbeStatus is the last synthetic probe of a tenant and the counters since the start
+type TenantProbeStatus struct {
+	Tenant        string    `json:"tenant"`
+	Topic         string    `json:"topic"`
+	CheckedAt     time.Time `json:"checkedAt"`
+	Success       bool      `json:"success"`
+	LatencyMs     float64   `json:"latencyMs"`
+	Error         string    `json:"error,omitempty"`
+	Probes        uint64    `json:"probes"`
+	Failures      uint64    `json:"failures"`
+	// ConsecutiveFailures are the failures since the last success
+	ConsecutiveFailures int `json:"consecutiveFailures"`
+	LastSuccessAt time.Time `json:"lastSuccessAt,omitempty"`
+}
+
+var (
+	probeStatuses     = make(map[string]*TenantProbeStatus)
+	probeStatusesLock = sync.RWMutex{}
+
+	probeLog = log.WithFields(log.Fields{"app": "synthetic-prober"})
+
+	// the consecutive probe failures of a tenant raising a critical SLA violation alert instead of a warning
+	probeCriticalFailures = util.GetEnvInt("SLAAlertCriticalProbeFailures", 3)
+
+	probeLatencyGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "burnell",
+		Subsystem: "synthetic_probe",
+		Name:      "latency_seconds",
+		Help:      "The end to end produce and consume latency of the last successful synthetic probe of the tenant.",
+	}, []string{"tenant"})
+	probeResultCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "burnell",
+		Subsystem: "synthetic_probe",
+		Name:      "probes_total",
+		Help:      "The number of the synthetic probes of the tenant by the result, success or failure.",
+	}, []string{"tenant", "result"})
+)
+
+func init() {
+	prometheus.MustRegister(probeLatencyGauge, probeResultCounter)
+}
+
+// HeartbeatTopic returns the synthetic probe topic of the tenant
+func HeartbeatTopic(tenant string) string {
+	pattern := util.AssignString(util.GetConfig().SyntheticProbeTopic, "persistent://{tenant}/default/burnell-heartbeat")
+	return strings.Replace(pattern, "{tenant}", tenant, -1)
+}
+
+// probedTenant evaluates the tenant plan has the synthetic probe feature and is not deleted
+func probedTenant(t TenantPlan) bool {
+	return t.TenantStatus != Deleted && IsFeatureSupported(SyntheticProbe, t.Policy.FeatureCodes)
+}
+
+// ProbeTenant probes the heartbeat topic of the tenant and records the result in the tenant SLA history
+func ProbeTenant(tenant string, probe ProbeFunc, timeout time.Duration, now time.Time) TenantProbeStatus {
+	topic := HeartbeatTopic(tenant)
+	latency, err := probe(topic, timeout)
+	metrics.RecordTenantProbe(tenant, err == nil, latency, now)
+
+	probeStatusesLock.Lock()
+	defer probeStatusesLock.Unlock()
+	status, ok := probeStatuses[tenant]
+	if !ok {
+		status = &TenantProbeStatus{Tenant: tenant}
+		probeStatuses[tenant] = status
+	}
+	status.Topic = topic
+	status.CheckedAt = now
+	status.Probes++
+	status.Success = err == nil
+	if err != nil {
+		status.Failures++
+		status.ConsecutiveFailures++
+		status.LatencyMs = 0
+		status.Error = err.Error()
+		probeResultCounter.WithLabelValues(tenant, "failure").Inc()
+		probeLog.Warnf("synthetic probe of tenant %s topic %s failed %v", tenant, topic, err)
+		severity := notification.SeverityWarning
+		if status.ConsecutiveFailures >= probeCriticalFailures {
+			severity = notification.SeverityCritical
+		}
+		go notification.Alerts.Raise(notification.Notice{
+			Tenant:   tenant,
+			Kind:     notification.SLAViolation,
+			Severity: severity,
+			Subject:  "tenant " + tenant + " synthetic probe failed",
+			Message:  fmt.Sprintf("synthetic probe of topic %s failed %d times in a row, last error %v", topic, status.ConsecutiveFailures, err),
+		})
+		return *status
+	}
+	status.ConsecutiveFailures = 0
+	status.LatencyMs = float64(latency) / float64(time.Millisecond)
+	status.Error = ""
+	status.LastSuccessAt = now
+	probeResultCounter.WithLabelValues(tenant, "success").Inc()
+	probeLatencyGauge.WithLabelValues(tenant).Set(latency.Seconds())
+	return *status
+}
+
+// ProbeTenants probes every tenant with the synthetic probe feature,
+// the tenants no longer probed are removed from the statuses
+func ProbeTenants(probe ProbeFunc, timeout time.Duration) {
+	probed := make(map[string]bool)
+	for _, t := range TenantManager.ListTenants() {
+		if probedTenant(t) {
+			probed[t.Name] = true
+			ProbeTenant(t.Name, probe, timeout, time.Now())
+		}
+	}
+	probeStatusesLock.Lock()
+	defer probeStatusesLock.Unlock()
+	for tenant := range probeStatuses {
+		if !probed[tenant] {
+			delete(probeStatuses, tenant)
+			probeLatencyGauge.DeleteLabelValues(tenant)
+		}
+	}
+}
+
+// GetProbeStatuses returns the last synthetic probe of the tenants sorted by the tenant name, or only of the tenant
+func GetProbeStatuses(tenant string) []TenantProbeStatus {
+	probeStatusesLock.RLock()
+	defer probeStatusesLock.RUnlock()
+	statuses := []TenantProbeStatus{}
+	for name, s := range probeStatuses {
+		if tenant == "" || tenant == name {
+			statuses = append(statuses, *s)
+		}
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Tenant < statuses[j].Tenant })
+	return statuses
+}
+
+// PulsarProbe produces the probe messages and reads them back by a non durable reader per heartbeat topic,
+// so that every burnell instance sees its own messages without a left over subscription
+type PulsarProbe struct {
+	client    pulsar.Client
+	producers map[string]pulsar.Producer
+	readers   map[string]pulsar.Reader
+	lock      sync.Mutex
+}
+
+// NewPulsarProbe creates a synthetic probe client of the PulsarURL
+func NewPulsarProbe() (*PulsarProbe, error) {
+	pulsarURL := util.GetConfig().PulsarURL
+	clientOpt := pulsar.ClientOptions{
+		URL:               pulsarURL,
+		OperationTimeout:  30 * time.Second,
+		ConnectionTimeout: 30 * time.Second,
+	}
+	if util.HasPulsarToken() {
+		clientOpt.Authentication = pulsar.NewAuthenticationTokenFromSupplier(util.PulsarTokenSupplier)
+	}
+	if strings.HasPrefix(pulsarURL, "pulsar+ssl://") {
+		trustStore := util.GetConfig().TrustStore
+		if trustStore == "" {
+			return nil, fmt.Errorf("missing trustStore while pulsar+ssl is required")
+		}
+		clientOpt.TLSTrustCertsFilePath = trustStore
+	}
+	client, err := pulsar.NewClient(clientOpt)
+	if err != nil {
+		return nil, err
+	}
+	return &PulsarProbe{
+		client:    client,
+		producers: make(map[string]pulsar.Producer),
+		readers:   make(map[string]pulsar.Reader),
+	}, nil
+}
+
+// endpoints returns the producer and the reader of the topic, the reader is created
+// before the first probe message so that it starts at the latest message
+func (p *PulsarProbe) endpoints(topic string) (pulsar.Producer, pulsar.Reader, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	reader, ok := p.readers[topic]
+	if !ok {
+		var err error
+		if reader, err = p.client.CreateReader(pulsar.ReaderOptions{Topic: topic, StartMessageID: pulsar.LatestMessageID()}); err != nil {
+			return nil, nil, err
+		}
+		p.readers[topic] = reader
+	}
+	producer, ok := p.producers[topic]
+	if !ok {
+		var err error
+		if producer, err = p.client.CreateProducer(pulsar.ProducerOptions{Topic: topic}); err != nil {
+			return nil, nil, err
+		}
+		p.producers[topic] = producer
+	}
+	return producer, reader, nil
+}
+
+// reset closes the producer and the reader of the topic after a failure, the next probe creates new ones
+func (p *PulsarProbe) reset(topic string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if producer, ok := p.producers[topic]; ok {
+		producer.Close()
+		delete(p.producers, topic)
+	}
+	if reader, ok := p.readers[topic]; ok {
+		reader.Close()
+		delete(p.readers, topic)
+	}
+}
+
+// Probe sends a message with a unique probe id and reads the topic until the message is back
+func (p *PulsarProbe) Probe(topic string, timeout time.Duration) (time.Duration, error) {
+	producer, reader, err := p.endpoints(topic)
+	if err != nil {
+		util.PulsarClientFailed(util.SyntheticProbeClient, topic, err)
+		return 0, err
+	}
+	util.PulsarClientConnected(util.SyntheticProbeClient, topic)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	id := strconv.FormatInt(time.Now().UnixNano(), 10)
+	start := time.Now()
+	if _, err := producer.Send(ctx, &pulsar.ProducerMessage{
+		Payload:    []byte(id),
+		Properties: map[string]string{probeProperty: id},
+	}); err != nil {
+		p.reset(topic)
+		return 0, fmt.Errorf("produce probe message %v", err)
+	}
+	util.PulsarClientMessage(util.SyntheticProbeClient)
+	for {
+		msg, err := reader.Next(ctx)
+		if err != nil {
+			p.reset(topic)
+			return 0, fmt.Errorf("consume probe message %v", err)
+		}
+		util.PulsarClientMessage(util.SyntheticProbeClient)
+		if msg.Properties()[probeProperty] == id {
+			return time.Since(start), nil
+		}
+	}
+}
+
+// SyntheticProber periodically probes the heartbeat topic of the tenants with the synthetic probe feature,
+// it is disabled unless SyntheticProbeIntervalSeconds is set as an environment variable
+func SyntheticProber() {
+	interval := time.Duration(util.GetEnvInt("SyntheticProbeIntervalSeconds", 0)) * time.Second
+	if interval <= 0 {
+		return
+	}
+	timeout := time.Duration(util.GetEnvInt("SyntheticProbeTimeoutSeconds", 10)) * time.Second
+	probe, err := NewPulsarProbe()
+	if err != nil {
+		probeLog.Errorf("synthetic probe client error %v", err)
+		return
+	}
+	probeLog.Infof("probe tenant heartbeat topics every %v", interval)
+	go func() {
+		ticker := time.NewTicker(interval)
+		for {
+			select {
+			case <-ticker.C:
+				if TenantManager.IsWarm() {
+					ProbeTenants(probe.Probe, timeout)
+				}
+			}
+		}
+	}()
+}
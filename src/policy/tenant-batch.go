@@ -0,0 +1,288 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package policy
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/datastax/burnell/src/util"
+)
+
+// the batch job states
+const (
+	BatchRunning   = "running"
+	BatchCompleted = "completed"
+)
+
+// BatchTenantFilter selects the tenants by the plan type and the metadata, all the given metadata must match
+type BatchTenantFilter struct {
+	PlanType string            `json:"planType,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// BatchPlanRequest is the same partial plan change applied to a list of tenants or the tenants selected by a filter
+type BatchPlanRequest struct {
+	Tenants []string           `json:"tenants,omitempty"`
+	Filter  *BatchTenantFilter `json:"filter,omitempty"`
+	// Plan is the partial plan reconciled against the plan of every tenant, an empty plan type keeps the tenant plan type
+	Plan               TenantPlan `json:"plan"`
+	AddFeatureCodes    []string   `json:"addFeatureCodes,omitempty"`
+	RemoveFeatureCodes []string   `json:"removeFeatureCodes,omitempty"`
+}
+
+// BatchTenantResult is the result of the plan change of a tenant
+type BatchTenantResult struct {
+	Tenant string `json:"tenant"`
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// BatchJob is the progress and the per tenant results of a batch plan update
+type BatchJob struct {
+	ID          string              `json:"id"`
+	State       string              `json:"state"`
+	CreatedAt   time.Time           `json:"createdAt"`
+	CompletedAt time.Time           `json:"completedAt,omitempty"`
+	Total       int                 `json:"total"`
+	Succeeded   int                 `json:"succeeded"`
+	Failed      int                 `json:"failed"`
+	Results     []BatchTenantResult `json:"results"`
+}
+
+// the number of the most recent batch jobs kept for the report
+var batchJobsKept = util.GetEnvInt("TenantPlanBatchJobsKept", 100)
+
+var (
+	batchJobs     = make(map[string]*BatchJob)
+	batchJobIDs   = []string{}
+	batchJobsLock = sync.RWMutex{}
+)
+
+// Validate validates the batch request has the target tenants and a valid partial plan
+func (req BatchPlanRequest) Validate() error {
+	if len(req.Tenants) == 0 && req.Filter == nil {
+		return fmt.Errorf("tenants or filter is required")
+	}
+	if len(req.Tenants) > 0 && req.Filter != nil {
+		return fmt.Errorf("tenants and filter cannot be both specified")
+	}
+	if req.Plan.PlanType != "" && getPlanPolicy(strings.ToLower(req.Plan.PlanType)) == nil {
+		return fmt.Errorf("invalid plan type %s", req.Plan.PlanType)
+	}
+	for _, codes := range [][]string{req.AddFeatureCodes, req.RemoveFeatureCodes} {
+		for _, code := range codes {
+			if _, ok := ValidateFeatureCode(code); !ok {
+				return fmt.Errorf("feature code %s must be alphanumeric and -", code)
+			}
+		}
+	}
+	return ValidateTenantPlan(req.Plan)
+}
+
+// Match returns true if the tenant plan matches the filter
+func (f BatchTenantFilter) Match(plan TenantPlan) bool {
+	if f.PlanType != "" && !strings.EqualFold(f.PlanType, plan.PlanType) {
+		return false
+	}
+	for k, v := range f.Metadata {
+		if plan.Metadata[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// SelectBatchTenants returns the sorted target tenants of the request among the tenant plans
+func SelectBatchTenants(req BatchPlanRequest, plans []TenantPlan) []string {
+	selected := map[string]bool{}
+	if req.Filter == nil {
+		for _, t := range req.Tenants {
+			selected[t] = true
+		}
+	} else {
+		for _, p := range plans {
+			if req.Filter.Match(p) {
+				selected[p.Name] = true
+			}
+		}
+	}
+	tenants := make([]string, 0, len(selected))
+	for t := range selected {
+		tenants = append(tenants, t)
+	}
+	sort.Strings(tenants)
+	return tenants
+}
+
+// BatchTenantPlan builds the requested partial plan of a tenant from its existing plan
+func BatchTenantPlan(req BatchPlanRequest, existing TenantPlan) (TenantPlan, error) {
+	plan := req.Plan
+	plan.Name = existing.Name
+	plan.PlanType = util.AssignString(plan.PlanType, existing.PlanType)
+	if len(req.AddFeatureCodes) == 0 && len(req.RemoveFeatureCodes) == 0 {
+		return plan, nil
+	}
+
+	codes := util.AssignString(plan.Policy.FeatureCodes, existing.Policy.FeatureCodes)
+	if codes == FeatureAllEnabled {
+		if len(req.RemoveFeatureCodes) > 0 {
+			return TenantPlan{}, fmt.Errorf("cannot remove feature codes from %s", FeatureAllEnabled)
+		}
+		return plan, nil
+	}
+	enabled := []string{}
+	if codes != FeatureAllDisabled {
+		for _, c := range strings.Split(codes, ",") {
+			if c = strings.TrimSpace(c); c != "" {
+				enabled = append(enabled, c)
+			}
+		}
+	}
+	for _, c := range req.AddFeatureCodes {
+		if !util.StrContains(enabled, c) {
+			enabled = append(enabled, c)
+		}
+	}
+	kept := []string{}
+	for _, c := range enabled {
+		if !util.StrContains(req.RemoveFeatureCodes, c) {
+			kept = append(kept, c)
+		}
+	}
+	plan.Policy.FeatureCodes = FeatureAllDisabled
+	if len(kept) > 0 {
+		plan.Policy.FeatureCodes = strings.Join(kept, ",")
+	}
+	return plan, nil
+}
+
+// NewBatchJob creates and tracks a running batch job of the tenants
+func NewBatchJob(tenants []string) (BatchJob, error) {
+	id, err := util.NewUUID()
+	if err != nil {
+		return BatchJob{}, err
+	}
+	job := &BatchJob{
+		ID:        id,
+		State:     BatchRunning,
+		CreatedAt: time.Now(),
+		Total:     len(tenants),
+		Results:   []BatchTenantResult{},
+	}
+	batchJobsLock.Lock()
+	defer batchJobsLock.Unlock()
+	batchJobs[id] = job
+	batchJobIDs = append(batchJobIDs, id)
+	if len(batchJobIDs) > batchJobsKept {
+		for _, old := range batchJobIDs[:len(batchJobIDs)-batchJobsKept] {
+			delete(batchJobs, old)
+		}
+		batchJobIDs = batchJobIDs[len(batchJobIDs)-batchJobsKept:]
+	}
+	return *job, nil
+}
+
+// GetBatchJob returns a snapshot of the batch job
+func GetBatchJob(id string) (BatchJob, bool) {
+	batchJobsLock.RLock()
+	defer batchJobsLock.RUnlock()
+	job, ok := batchJobs[id]
+	if !ok {
+		return BatchJob{}, false
+	}
+	snapshot := *job
+	snapshot.Results = append([]BatchTenantResult{}, job.Results...)
+	return snapshot, true
+}
+
+func recordBatchResult(id string, result BatchTenantResult) {
+	batchJobsLock.Lock()
+	defer batchJobsLock.Unlock()
+	job, ok := batchJobs[id]
+	if !ok {
+		return
+	}
+	job.Results = append(job.Results, result)
+	if result.Error == "" {
+		job.Succeeded++
+	} else {
+		job.Failed++
+	}
+	if len(job.Results) == job.Total {
+		job.State = BatchCompleted
+		job.CompletedAt = time.Now()
+	}
+}
+
+// RunBatchPlanUpdate applies the request to the tenants one by one and records the result of every tenant in the job.
+// A tenant without a plan is not created by the batch update.
+func RunBatchPlanUpdate(jobID string, tenants []string, req BatchPlanRequest,
+	get func(string) (TenantPlan, error), update func(string, TenantPlan) (TenantPlan, int, error)) {
+	if len(tenants) == 0 {
+		batchJobsLock.Lock()
+		if job, ok := batchJobs[jobID]; ok {
+			job.State = BatchCompleted
+			job.CompletedAt = time.Now()
+		}
+		batchJobsLock.Unlock()
+		return
+	}
+	for _, tenant := range tenants {
+		result := BatchTenantResult{Tenant: tenant, Status: http.StatusOK}
+		existing, err := get(tenant)
+		if err != nil {
+			result.Status, result.Error = http.StatusNotFound, err.Error()
+			recordBatchResult(jobID, result)
+			continue
+		}
+		plan, err := BatchTenantPlan(req, existing)
+		if err != nil {
+			result.Status, result.Error = http.StatusUnprocessableEntity, err.Error()
+			recordBatchResult(jobID, result)
+			continue
+		}
+		if _, status, err := update(tenant, plan); err != nil {
+			result.Status, result.Error = status, err.Error()
+		}
+		recordBatchResult(jobID, result)
+	}
+}
+
+// StartBatchPlanUpdate validates the batch request and starts the update of the target tenants as an async job
+func (s *TenantPolicyHandler) StartBatchPlanUpdate(req BatchPlanRequest) (BatchJob, int, error) {
+	if err := req.Validate(); err != nil {
+		return BatchJob{}, http.StatusUnprocessableEntity, err
+	}
+	tenants := SelectBatchTenants(req, s.ListTenants())
+	job, err := NewBatchJob(tenants)
+	if err != nil {
+		return BatchJob{}, http.StatusInternalServerError, err
+	}
+	s.logger.Infof("batch plan update job %s of %d tenants", job.ID, len(tenants))
+	go RunBatchPlanUpdate(job.ID, tenants, req, s.GetTenant, s.UpdateTenant)
+	return job, http.StatusAccepted, nil
+}
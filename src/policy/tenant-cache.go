@@ -0,0 +1,162 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package policy
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/datastax/burnell/src/util"
+)
+
+// the max number of free plans created in the cache only for the tenants without a plan, 0 is no limit
+var tenantCacheOnlyMax = util.GetEnvInt("TenantCacheOnlyMaxEntries", 1000)
+
+// the cache only free plans are dropped after the TTL and recreated on the next request
+var tenantCacheOnlyTTL = time.Duration(util.GetEnvInt("TenantCacheOnlyTTLMinutes", 60)) * time.Minute
+
+// the deleted tenants are remembered for the compaction of the other caches until the retention is over
+var tenantDeletedRetention = time.Duration(util.GetEnvInt("TenantCacheDeletedRetentionHours", 24)) * time.Hour
+
+// IsDeletedTenant returns true if the tenant plan is deleted within the retention and not recreated
+func (s *TenantPolicyHandler) IsDeletedTenant(tenantName string) bool {
+	s.tenantsLock.RLock()
+	defer s.tenantsLock.RUnlock()
+	_, ok := s.deleted[tenantName]
+	return ok
+}
+
+// tenantIndex is a secondary index of the cached tenant names by a key
+type tenantIndex map[string]map[string]bool
+
+func (i tenantIndex) add(key, tenantName string) {
+	if _, ok := i[key]; !ok {
+		i[key] = make(map[string]bool)
+	}
+	i[key][tenantName] = true
+}
+
+func (i tenantIndex) remove(key, tenantName string) {
+	delete(i[key], tenantName)
+	if len(i[key]) == 0 {
+		delete(i, key)
+	}
+}
+
+// indexTenant adds or removes the tenant in the org, email domain and IdP group indexes, the caller holds tenantsLock
+func (s *TenantPolicyHandler) indexTenant(t TenantPlan, add bool) {
+	if s.orgs == nil {
+		s.orgs, s.emailDomains, s.idpGroups = tenantIndex{}, tenantIndex{}, tenantIndex{}
+	}
+	update := tenantIndex.remove
+	if add {
+		update = tenantIndex.add
+	}
+	update(s.orgs, strings.ToLower(t.Org), t.Name)
+	if t.SSO != nil {
+		for _, domain := range t.SSO.EmailDomains {
+			update(s.emailDomains, strings.ToLower(domain), t.Name)
+		}
+		for _, group := range t.SSO.IdPGroups {
+			update(s.idpGroups, group, t.Name)
+		}
+	}
+}
+
+// cacheTenant adds or replaces a tenant plan in the cache and the indexes, the caller holds tenantsLock
+func (s *TenantPolicyHandler) cacheTenant(t TenantPlan) {
+	s.uncacheTenant(t.Name)
+	s.tenants[t.Name] = t
+	s.indexTenant(t, true)
+}
+
+// uncacheTenant removes a tenant plan from the cache and the indexes, the caller holds tenantsLock
+func (s *TenantPolicyHandler) uncacheTenant(tenantName string) {
+	if t, ok := s.tenants[tenantName]; ok {
+		delete(s.tenants, tenantName)
+		s.indexTenant(t, false)
+	}
+}
+
+// markTenantDeleted marks a tenant deleted or live, the caller holds tenantsLock
+func (s *TenantPolicyHandler) markTenantDeleted(tenantName string, deleted bool) {
+	delete(s.cacheOnly, tenantName)
+	if deleted {
+		s.deleted[tenantName] = time.Now()
+	} else {
+		delete(s.deleted, tenantName)
+	}
+}
+
+// CompactTenants drops the expired cache only plans, the deleted tenants over the retention, and the key IDs and the ciphertext of removed tenants,
+// and records the cache size. It returns the number of dropped tenant plans.
+func (s *TenantPolicyHandler) CompactTenants(now time.Time) int {
+	s.tenantsLock.Lock()
+	defer s.tenantsLock.Unlock()
+	dropped := 0
+	for k, createdAt := range s.cacheOnly {
+		if now.Sub(createdAt) > tenantCacheOnlyTTL {
+			s.uncacheTenant(k)
+			delete(s.cacheOnly, k)
+			dropped++
+		}
+	}
+	for k, deletedAt := range s.deleted {
+		if now.Sub(deletedAt) > tenantDeletedRetention {
+			delete(s.deleted, k)
+		}
+	}
+	for k := range s.keyIDs {
+		if _, ok := s.tenants[k]; !ok {
+			delete(s.keyIDs, k)
+		}
+	}
+	for k := range s.undecryptable {
+		if _, ok := s.tenants[k]; !ok {
+			delete(s.undecryptable, k)
+		}
+	}
+	bytes := 0
+	for k, v := range s.tenants {
+		if data, err := json.Marshal(v); err == nil {
+			bytes += len(k) + len(data)
+		}
+	}
+	util.CacheEvicted(util.TenantCache, util.EvictedExpired, dropped)
+	util.CacheSize(util.TenantCache, len(s.tenants), bytes)
+	return dropped
+}
+
+// tenantCacheCompactor periodically compacts the tenant cache
+func (s *TenantPolicyHandler) tenantCacheCompactor() {
+	interval := time.Duration(util.GetEnvInt("CacheCompactionIntervalSeconds", 600)) * time.Second
+	ticker := time.NewTicker(interval)
+	for {
+		select {
+		case <-ticker.C:
+			if dropped := s.CompactTenants(time.Now()); dropped > 0 {
+				s.logger.Infof("compacted %d cache only tenant plans", dropped)
+			}
+		}
+	}
+}
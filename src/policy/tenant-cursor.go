@@ -0,0 +1,103 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package policy
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// tenantCacheSnapshot is the tenant cache checkpointed along with the cursor of the tenant management topic reader,
+// the plans keep the sensitive fields encrypted as in the topic records
+type tenantCacheSnapshot struct {
+	Plans   []TenantPlan         `json:"plans"`
+	KeyIDs  map[string]string    `json:"keyIds"`
+	Deleted map[string]time.Time `json:"deleted"`
+}
+
+// cacheSnapshot returns the snapshot of the plans read from the topic, the cache only plans are not in the topic
+func (s *TenantPolicyHandler) cacheSnapshot() (interface{}, error) {
+	s.tenantsLock.RLock()
+	defer s.tenantsLock.RUnlock()
+	snapshot := tenantCacheSnapshot{
+		Plans:   make([]TenantPlan, 0, len(s.tenants)),
+		KeyIDs:  make(map[string]string, len(s.keyIDs)),
+		Deleted: make(map[string]time.Time, len(s.deleted)),
+	}
+	for name, t := range s.tenants {
+		if _, ok := s.cacheOnly[name]; ok {
+			continue
+		}
+		if ciphertext, ok := s.undecryptable[name]; ok {
+			// the ciphertext of an undecryptable plan is kept as is
+			t.Encrypted = ciphertext
+			snapshot.Plans = append(snapshot.Plans, t)
+			continue
+		}
+		record, err := EncryptTenantPlan(t)
+		if err != nil {
+			return nil, err
+		}
+		snapshot.Plans = append(snapshot.Plans, record)
+	}
+	for name, keyID := range s.keyIDs {
+		snapshot.KeyIDs[name] = keyID
+	}
+	for name, deletedAt := range s.deleted {
+		snapshot.Deleted[name] = deletedAt
+	}
+	return snapshot, nil
+}
+
+// restoreCache loads the snapshot of a checkpoint into the empty cache, the cache is untouched unless
+// every plan is decrypted so the listener reads the topic from the earliest message over a bad snapshot
+func (s *TenantPolicyHandler) restoreCache(data []byte) error {
+	snapshot := tenantCacheSnapshot{}
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return err
+	}
+	plans := make([]TenantPlan, 0, len(snapshot.Plans))
+	for _, record := range snapshot.Plans {
+		t, err := DecryptTenantPlan(record)
+		if err != nil {
+			return err
+		}
+		plans = append(plans, t)
+	}
+
+	s.tenantsLock.Lock()
+	for _, t := range plans {
+		s.cacheTenant(t)
+	}
+	for name, keyID := range snapshot.KeyIDs {
+		s.keyIDs[name] = keyID
+	}
+	for name, deletedAt := range snapshot.Deleted {
+		s.deleted[name] = deletedAt
+	}
+	s.tenantsLock.Unlock()
+	for name, deletedAt := range snapshot.Deleted {
+		trackDeletedTenant(TenantPlan{Name: name, TenantStatus: Deleted, UpdatedAt: deletedAt})
+	}
+	s.logger.Infof("tenant cache restored %d tenants from the checkpoint", len(plans))
+	return nil
+}
@@ -0,0 +1,107 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package policy
+
+import (
+	"sync"
+	"time"
+
+	"github.com/datastax/burnell/src/util"
+)
+
+// the categories of the tenant events
+const (
+	PlanEventCategory  = "plan"
+	TokenEventCategory = "token"
+	QuotaEventCategory = "quota"
+)
+
+// TenantEvent is an administrative event in the event feed of a tenant
+type TenantEvent struct {
+	// Time is the last occurrence of the event
+	Time     time.Time `json:"time"`
+	Tenant   string    `json:"tenant"`
+	Category string    `json:"category"`
+	Type     string    `json:"type"`
+	Summary  string    `json:"summary"`
+	// Changes are the changed field paths of a plan event
+	Changes []string `json:"changes,omitempty"`
+	// Count is the number of the occurrences, the repeated events within a minute are counted in one event
+	Count int `json:"count"`
+}
+
+var (
+	// the number of recent events kept per tenant
+	tenantEventsSize = util.GetEnvInt("TenantEventsPerTenant", 200)
+
+	tenantEvents     = make(map[string][]TenantEvent)
+	tenantEventsLock = sync.RWMutex{}
+)
+
+// RecordTenantEvent adds an event to the tenant event feed, an event repeating the last event of the tenant
+// within a minute increases its count instead
+func RecordTenantEvent(e TenantEvent) {
+	if e.Tenant == "" {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	e.Count = 1
+	tenantEventsLock.Lock()
+	defer tenantEventsLock.Unlock()
+	events := tenantEvents[e.Tenant]
+	if n := len(events); n > 0 {
+		last := &events[n-1]
+		if last.Category == e.Category && last.Type == e.Type && last.Summary == e.Summary &&
+			len(e.Changes) == 0 && e.Time.Sub(last.Time) < time.Minute {
+			last.Time = e.Time
+			last.Count++
+			return
+		}
+	}
+	events = append(events, e)
+	if len(events) > tenantEventsSize {
+		events = events[len(events)-tenantEventsSize:]
+	}
+	tenantEvents[e.Tenant] = events
+}
+
+// TenantEvents returns the events of the tenant after the since time in the chronological order, optionally only
+// of the categories. A positive limit returns the oldest events after the since time, so that the time of the last
+// returned event is the since time of the next page.
+func TenantEvents(tenant string, since time.Time, categories []string, limit int) []TenantEvent {
+	tenantEventsLock.RLock()
+	defer tenantEventsLock.RUnlock()
+	events := []TenantEvent{}
+	for _, e := range tenantEvents[tenant] {
+		if !e.Time.After(since) || (len(categories) > 0 && !util.StrContains(categories, e.Category)) {
+			continue
+		}
+		e.Changes = append([]string(nil), e.Changes...)
+		events = append(events, e)
+		if limit > 0 && len(events) >= limit {
+			break
+		}
+	}
+	return events
+}
@@ -0,0 +1,144 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package policy
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/datastax/burnell/src/util"
+)
+
+// metadata keys are alphanumeric, and -, ., _, / after the first character, i.e. billing.accountId or crm/id
+var metadataKeyPattern = regexp.MustCompile(`^[A-Za-z0-9][-./\w]*$`)
+
+// LogMaskMetadataPrefix is the metadata key prefix of the tenant log masking rules, i.e. logmask/accountId,
+// the value is the regex pattern of the data masked in the tenant function logs
+const LogMaskMetadataPrefix = "logmask/"
+
+// TenantLogMaskRules returns the log masking rules in the tenant metadata ordered by the key
+func TenantLogMaskRules(metadata map[string]string) []util.LogMaskRule {
+	rules := []util.LogMaskRule{}
+	for k, v := range metadata {
+		if strings.HasPrefix(k, LogMaskMetadataPrefix) {
+			rules = append(rules, util.LogMaskRule{Name: k, Pattern: v})
+		}
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Name < rules[j].Name })
+	return rules
+}
+
+// the tenant metadata size limits
+var (
+	maxMetadataKeys        = util.GetEnvInt("TenantMetadataMaxKeys", 32)
+	maxMetadataKeyLength   = util.GetEnvInt("TenantMetadataMaxKeyLength", 64)
+	maxMetadataValueLength = util.GetEnvInt("TenantMetadataMaxValueLength", 512)
+)
+
+// ValidateTenantMetadata validates the number of keys, the key names and the key and value lengths
+func ValidateTenantMetadata(metadata map[string]string) error {
+	fieldErrs := []FieldError{}
+	if len(metadata) > maxMetadataKeys {
+		fieldErrs = append(fieldErrs, FieldError{
+			Field:  "metadata",
+			Value:  fmt.Sprintf("%d keys", len(metadata)),
+			Reason: fmt.Sprintf("must not have more than %d keys", maxMetadataKeys),
+		})
+	}
+	keys := make([]string, 0, len(metadata))
+	for k := range metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if len(k) > maxMetadataKeyLength || !metadataKeyPattern.MatchString(k) {
+			fieldErrs = append(fieldErrs, FieldError{
+				Field:  "metadata",
+				Value:  k,
+				Reason: fmt.Sprintf("key must be alphanumeric, -, ., _ and / up to %d characters", maxMetadataKeyLength),
+			})
+		}
+		if strings.HasPrefix(k, LogMaskMetadataPrefix) {
+			if _, err := regexp.Compile(metadata[k]); err != nil {
+				fieldErrs = append(fieldErrs, FieldError{
+					Field:  "metadata." + k,
+					Value:  metadata[k],
+					Reason: "log masking rule must be a valid regex, " + err.Error(),
+				})
+			}
+		}
+		if len(metadata[k]) > maxMetadataValueLength {
+			fieldErrs = append(fieldErrs, FieldError{
+				Field:  "metadata." + k,
+				Value:  metadata[k][:maxMetadataValueLength] + "...",
+				Reason: fmt.Sprintf("value must not be longer than %d characters", maxMetadataValueLength),
+			})
+		}
+	}
+	if len(fieldErrs) > 0 {
+		return &ValidationError{Fields: fieldErrs}
+	}
+	return nil
+}
+
+// MergeTenantMetadata applies a JSON merge patch to the metadata, a nil value removes the key
+func MergeTenantMetadata(metadata map[string]string, patch map[string]*string) map[string]string {
+	merged := make(map[string]string, len(metadata)+len(patch))
+	for k, v := range metadata {
+		merged[k] = v
+	}
+	for k, v := range patch {
+		if v == nil {
+			delete(merged, k)
+		} else {
+			merged[k] = *v
+		}
+	}
+	return merged
+}
+
+// PatchMetadata merges the patch into the tenant metadata
+func (s *TenantPolicyHandler) PatchMetadata(tenantName string, patch map[string]*string) (TenantPlan, int, error) {
+	plan, err := s.GetTenant(tenantName)
+	if err != nil {
+		return TenantPlan{}, http.StatusNotFound, err
+	}
+	metadata := MergeTenantMetadata(plan.Metadata, patch)
+	if err := ValidateTenantMetadata(metadata); err != nil {
+		return TenantPlan{}, http.StatusUnprocessableEntity, err
+	}
+	keys := make([]string, 0, len(patch))
+	for k := range patch {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	plan.Metadata = metadata
+	plan.Audit = plan.Audit + ",metadata updated " + strings.Join(keys, " ")
+	updatedPlan, err := s.updateDb(plan)
+	if err != nil {
+		return TenantPlan{}, http.StatusInternalServerError, err
+	}
+	return updatedPlan, http.StatusOK, nil
+}
@@ -0,0 +1,87 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package policy
+
+import (
+	"time"
+
+	"github.com/datastax/burnell/src/notification"
+)
+
+// WantsNotice evaluates whether the tenant opts in the kind of notice, nil preferences opt in none
+func (p *NotificationPreferences) WantsNotice(kind string) bool {
+	if p == nil {
+		return false
+	}
+	switch kind {
+	case notification.QuotaWarning:
+		return p.QuotaWarning
+	case notification.Expiration:
+		return p.Expiration
+	case notification.Maintenance:
+		return p.Maintenance
+	case notification.UsageReport:
+		return p.UsageReport
+	default:
+		return false
+	}
+}
+
+// NotifyTenant sends a notice to the tenant contacts asynchronously if the tenant opts in the kind of notice,
+// a quota warning is also in the tenant event feed and a warning alert to the operators. It returns false if the tenant has no plan,
+// no contacts or has not opted in.
+func (s *TenantPolicyHandler) NotifyTenant(tenant, kind, subject, message string) bool {
+	notice := notification.Notice{
+		Tenant:    tenant,
+		Kind:      kind,
+		Subject:   subject,
+		Message:   message,
+		CreatedAt: time.Now(),
+	}
+	if kind == notification.QuotaWarning {
+		RecordTenantEvent(TenantEvent{
+			Time:     notice.CreatedAt,
+			Tenant:   tenant,
+			Category: QuotaEventCategory,
+			Type:     kind,
+			Summary:  subject + ", " + message,
+		})
+		alert := notice
+		alert.Severity = notification.SeverityWarning
+		alert.Subject = "tenant " + tenant + " " + subject
+		go notification.Alerts.Raise(alert)
+	}
+	return s.NotifyTenantNotice(notice)
+}
+
+// NotifyTenantNotice sends a notice to the contacts of the notice tenant asynchronously if the tenant opts in the kind of notice
+func (s *TenantPolicyHandler) NotifyTenantNotice(notice notification.Notice) bool {
+	t, err := s.GetTenant(notice.Tenant)
+	if err != nil || !t.Notifications.WantsNotice(notice.Kind) {
+		return false
+	}
+	if t.Contacts == nil || (len(t.Contacts.Emails) == 0 && len(t.Contacts.Webhooks) == 0) {
+		return false
+	}
+	go notification.Notifier.Notify(t.Contacts.Emails, t.Contacts.Webhooks, notice)
+	return true
+}
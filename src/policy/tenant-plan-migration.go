@@ -0,0 +1,76 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package policy
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TenantPlanVersion is the current schema version of the TenantPlan record stored in the tenant topic.
+// Bump the version and add a migration step whenever a field change would break historical messages.
+const TenantPlanVersion = 1
+
+// legacyTenantPlanVersion is the version of records written before the schema was versioned
+const legacyTenantPlanVersion = 0
+
+// tenantPlanMigrations upgrades a record from the version of the map key to the next version
+var tenantPlanMigrations = map[int]func(TenantPlan) TenantPlan{
+	legacyTenantPlanVersion: migrateTenantPlanV0,
+}
+
+// MigrateTenantPlan upgrades a TenantPlan read from the database to the current schema version.
+// It returns the upgraded plan and whether any migration has been applied.
+func MigrateTenantPlan(t TenantPlan) (TenantPlan, bool, error) {
+	if t.Version > TenantPlanVersion {
+		return t, false, fmt.Errorf("tenant %s plan version %d is newer than the supported version %d", t.Name, t.Version, TenantPlanVersion)
+	}
+	migrated := false
+	for t.Version < TenantPlanVersion {
+		migrate, ok := tenantPlanMigrations[t.Version]
+		if !ok {
+			return t, migrated, fmt.Errorf("missing tenant plan migration from version %d", t.Version)
+		}
+		t = migrate(t)
+		t.Version++
+		migrated = true
+	}
+	return t, migrated, nil
+}
+
+// migrateTenantPlanV0 upgrades the records created before versioning.
+// These records may only have retention in nano-seconds, and an unnormalized or empty plan type.
+func migrateTenantPlanV0(t TenantPlan) TenantPlan {
+	t.PlanType = strings.ToLower(strings.TrimSpace(t.PlanType))
+	if t.PlanType == "" {
+		t.PlanType = FreeTier
+	}
+	if t.Policy.MessageHourRetention == 0 && t.Policy.MessageRetention > 0 {
+		t.Policy.MessageHourRetention = int(t.Policy.MessageRetention / time.Hour)
+	}
+	t.Policy.MessageRetention = time.Duration(t.Policy.MessageHourRetention) * time.Hour
+	if t.Policy.Name == "" {
+		t.Policy.Name = t.PlanType
+	}
+	return t
+}
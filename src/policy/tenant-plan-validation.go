@@ -0,0 +1,309 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package policy
+
+import (
+	"fmt"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/datastax/burnell/src/util"
+)
+
+// FieldBound is the inclusive min and max value allowed for a plan policy field
+type FieldBound struct {
+	Min int `json:"min"`
+	Max int `json:"max"`
+}
+
+// PlanPolicyFieldBounds is the bounds for plan policy fields, the key is the json field name.
+// A zero value in the request means the field is not specified and it is not validated.
+// -1 is the unlimited setting for producers, consumers, functions, partitions, the request rate and burst, and the backlog quota.
+var PlanPolicyFieldBounds = map[string]FieldBound{
+	"numOfTopics":          {Min: 1, Max: 100000},
+	"numOfNamespaces":      {Min: 1, Max: 10000},
+	"messageHourRetention": {Min: 1, Max: 10 * 365 * 24},
+	"numofProducers":       {Min: -1, Max: 100000},
+	"numOfConsumers":       {Min: -1, Max: 100000},
+	"functions":            {Min: -1, Max: 10000},
+	"numOfPartitions":      {Min: -1, Max: 10000},
+	"requestRate":          {Min: -1, Max: 100000},
+	"requestBurst":         {Min: -1, Max: 1000000},
+	"backlogQuotaMB":       {Min: -1, Max: 10 * 1024 * 1024},
+}
+
+// ReservedTenantNames cannot be used by a new tenant
+var ReservedTenantNames = []string{"public", "pulsar", "system"}
+
+// MaxTenantNameLength is the max number of characters of a tenant name
+var MaxTenantNameLength = util.GetEnvInt("MaxTenantNameLength", 64)
+
+// Pulsar named entity allows alphanumeric, -, =, :, . and _
+var tenantNamePattern = regexp.MustCompile(`^[-=:.\w]+$`)
+
+// FieldError describes an invalid field in the request
+type FieldError struct {
+	Field  string `json:"field"`
+	Value  string `json:"value"`
+	Reason string `json:"reason"`
+}
+
+// ValidationError is a list of invalid fields
+type ValidationError struct {
+	Fields []FieldError `json:"fields"`
+}
+
+func (e *ValidationError) Error() string {
+	names := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		names[i] = f.Field
+	}
+	return "invalid fields " + strings.Join(names, ",")
+}
+
+// ValidateTenantPlan validates the requested tenant plan against the field bounds
+func ValidateTenantPlan(plan TenantPlan) error {
+	fieldErrs := []FieldError{}
+	if plan.PlanType != "" && getPlanPolicy(strings.ToLower(plan.PlanType)) == nil {
+		fieldErrs = append(fieldErrs, FieldError{
+			Field:  "planType",
+			Value:  plan.PlanType,
+			Reason: "unknown plan type",
+		})
+	}
+	if plan.TenantStatus < Reserved0 || plan.TenantStatus > Deleted {
+		fieldErrs = append(fieldErrs, FieldError{
+			Field:  "tenantStatus",
+			Value:  strconv.Itoa(int(plan.TenantStatus)),
+			Reason: "unknown tenant status",
+		})
+	}
+
+	p := plan.Policy
+	intFields := []struct {
+		name  string
+		value int
+	}{
+		{"numOfTopics", p.NumOfTopics},
+		{"numOfNamespaces", p.NumOfNamespaces},
+		{"messageHourRetention", p.MessageHourRetention},
+		{"numofProducers", p.NumOfProducers},
+		{"numOfConsumers", p.NumOfConsumers},
+		{"functions", p.Functions},
+		{"numOfPartitions", p.NumOfPartitions},
+		{"requestRate", p.RequestRate},
+		{"requestBurst", p.RequestBurst},
+		{"backlogQuotaMB", p.BacklogQuotaMB},
+	}
+	for _, f := range intFields {
+		bound, ok := PlanPolicyFieldBounds[f.name]
+		if !ok || f.value == 0 {
+			continue
+		}
+		if f.value < bound.Min || f.value > bound.Max {
+			fieldErrs = append(fieldErrs, FieldError{
+				Field:  "policy." + f.name,
+				Value:  strconv.Itoa(f.value),
+				Reason: fmt.Sprintf("must be between %d and %d", bound.Min, bound.Max),
+			})
+		}
+	}
+
+	if p.FeatureCodes != "" && p.FeatureCodes != FeatureAllEnabled && p.FeatureCodes != FeatureAllDisabled {
+		for _, code := range strings.Split(p.FeatureCodes, ",") {
+			if _, ok := ValidateFeatureCode(code); !ok {
+				fieldErrs = append(fieldErrs, FieldError{
+					Field:  "policy.featureCodes",
+					Value:  code,
+					Reason: "feature code must be alphanumeric and -",
+				})
+			}
+		}
+	}
+
+	if plan.Contacts != nil {
+		for _, email := range plan.Contacts.Emails {
+			if _, err := mail.ParseAddress(email); err != nil {
+				fieldErrs = append(fieldErrs, FieldError{
+					Field:  "contacts.emails",
+					Value:  email,
+					Reason: "invalid email address",
+				})
+			}
+		}
+		for _, webhook := range plan.Contacts.Webhooks {
+			if u, err := url.ParseRequestURI(webhook); err != nil || !(u.Scheme == "http" || u.Scheme == "https") {
+				fieldErrs = append(fieldErrs, FieldError{
+					Field:  "contacts.webhooks",
+					Value:  webhook,
+					Reason: "webhook must be a http or https URL",
+				})
+			}
+		}
+	}
+
+	if err := ValidateAllowedOrigins(plan.AllowedOrigins); err != nil {
+		fieldErrs = append(fieldErrs, err.(*ValidationError).Fields...)
+	}
+	seenClusters := map[string]bool{}
+	for _, cluster := range plan.AllowedClusters {
+		if !clusterNamePattern.MatchString(cluster) || seenClusters[cluster] {
+			fieldErrs = append(fieldErrs, FieldError{
+				Field:  "allowedClusters",
+				Value:  cluster,
+				Reason: "cluster must be a unique name of alphanumeric, -, _ and .",
+			})
+		}
+		seenClusters[cluster] = true
+	}
+	if plan.QuotaEnforcement != "" && !IsQuotaEnforcementMode(plan.QuotaEnforcement) {
+		fieldErrs = append(fieldErrs, FieldError{
+			Field:  "quotaEnforcement",
+			Value:  plan.QuotaEnforcement,
+			Reason: "quota enforcement must be enforce or observe",
+		})
+	}
+	if err := ValidateTenantMetadata(plan.Metadata); err != nil {
+		fieldErrs = append(fieldErrs, err.(*ValidationError).Fields...)
+	}
+	if err := ValidateTenantSSO(plan.SSO); err != nil {
+		fieldErrs = append(fieldErrs, err.(*ValidationError).Fields...)
+	}
+
+	if len(fieldErrs) > 0 {
+		return &ValidationError{Fields: fieldErrs}
+	}
+	return nil
+}
+
+// clusterNamePattern is a valid Pulsar cluster name
+var clusterNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+// the quota enforcement modes, a violation is rejected in enforce mode or only logged and counted in observe mode
+const (
+	EnforceQuota = "enforce"
+	ObserveQuota = "observe"
+)
+
+// IsQuotaEnforcementMode evaluates if the mode is a valid quota enforcement mode
+func IsQuotaEnforcementMode(mode string) bool {
+	return mode == EnforceQuota || mode == ObserveQuota
+}
+
+// IsClusterAllowed evaluates if the tenant plan allows the cluster, an empty allowed list allows all clusters
+func (t TenantPlan) IsClusterAllowed(cluster string) bool {
+	return len(t.AllowedClusters) == 0 || util.StrContains(t.AllowedClusters, cluster)
+}
+
+// ValidateAllowedOrigins validates each origin is a http or https scheme and host, optionally with a leading *. subdomain wildcard
+func ValidateAllowedOrigins(origins []string) error {
+	fieldErrs := []FieldError{}
+	for _, origin := range origins {
+		u, err := url.Parse(strings.Replace(origin, "://*.", "://", 1))
+		if err != nil || !(u.Scheme == "http" || u.Scheme == "https") || u.Host == "" || u.Path != "" || u.RawQuery != "" || u.User != nil {
+			fieldErrs = append(fieldErrs, FieldError{
+				Field:  "allowedOrigins",
+				Value:  origin,
+				Reason: "origin must be a http or https scheme and host",
+			})
+		}
+	}
+	if len(fieldErrs) > 0 {
+		return &ValidationError{Fields: fieldErrs}
+	}
+	return nil
+}
+
+// MatchOrigin evaluates if the origin matches any of the allowed origins, including *. subdomain wildcards
+func MatchOrigin(allowedOrigins []string, origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, v := range allowedOrigins {
+		v = strings.ToLower(v)
+		if v == origin {
+			return true
+		}
+		if i := strings.Index(v, "://*."); i > 0 {
+			scheme, domain := v[:i+3], v[i+4:]
+			if strings.HasPrefix(origin, scheme) && strings.HasSuffix(origin, domain) && len(origin) > len(scheme)+len(domain) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ValidateTenantName validates a tenant name is legal in Pulsar and within the length limit.
+// A reserved name is only rejected for a new tenant.
+func ValidateTenantName(name string, isNew bool) error {
+	reason := ""
+	switch {
+	case name == "":
+		reason = "tenant name cannot be empty"
+	case len(name) > MaxTenantNameLength:
+		reason = fmt.Sprintf("tenant name cannot be longer than %d characters", MaxTenantNameLength)
+	case !tenantNamePattern.MatchString(name):
+		reason = "tenant name must be alphanumeric, -, =, :, . or _"
+	case isNew && util.StrContains(ReservedTenantNames, strings.ToLower(name)):
+		reason = "tenant name is reserved"
+	default:
+		return nil
+	}
+	return &ValidationError{Fields: []FieldError{{Field: "name", Value: name, Reason: reason}}}
+}
+
+// LoadPlanPolicyFieldBounds overwrites the default field bounds with a comma separated config string
+// in the format of field:min:max, i.e. numOfTopics:1:500,functions:-1:50
+func LoadPlanPolicyFieldBounds(config string) error {
+	if strings.TrimSpace(config) == "" {
+		return nil
+	}
+	for _, entry := range strings.Split(config, ",") {
+		parts := strings.Split(strings.TrimSpace(entry), ":")
+		if len(parts) != 3 {
+			return fmt.Errorf("invalid plan policy field bound %s, expected format field:min:max", entry)
+		}
+		if _, ok := PlanPolicyFieldBounds[parts[0]]; !ok {
+			return fmt.Errorf("unknown plan policy field %s", parts[0])
+		}
+		min, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return fmt.Errorf("invalid min value of field %s", parts[0])
+		}
+		max, err := strconv.Atoi(parts[2])
+		if err != nil {
+			return fmt.Errorf("invalid max value of field %s", parts[0])
+		}
+		if min > max {
+			return fmt.Errorf("min is greater than max of field %s", parts[0])
+		}
+		PlanPolicyFieldBounds[parts[0]] = FieldBound{Min: min, Max: max}
+	}
+	return nil
+}
+
+// loadConfiguredFieldBounds applies the field bounds from burnell configuration
+func loadConfiguredFieldBounds() error {
+	return LoadPlanPolicyFieldBounds(util.GetConfig().PlanPolicyFieldBounds)
+}
@@ -0,0 +1,120 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package policy
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// the max length of a tenant query pattern
+const maxTenantQueryPattern = 256
+
+// TenantPlanQuery selects the tenant plans by the name and the org.
+// NameLike and Org are case insensitive wildcard patterns, * matches any characters and ? matches a single one.
+// NameRegex is a regular expression matching the whole name. The empty fields match all.
+type TenantPlanQuery struct {
+	NameLike  string
+	NameRegex string
+	Org       string
+	PlanType  string
+}
+
+// wildcardPattern compiles a case insensitive wildcard pattern into an anchored regular expression
+func wildcardPattern(pattern string) (*regexp.Regexp, error) {
+	expr := regexp.QuoteMeta(pattern)
+	expr = strings.ReplaceAll(expr, `\*`, ".*")
+	expr = strings.ReplaceAll(expr, `\?`, ".")
+	return regexp.Compile("(?i)^" + expr + "$")
+}
+
+func isWildcard(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?")
+}
+
+// matcher compiles the query into a tenant plan matcher
+func (q TenantPlanQuery) matcher() (func(TenantPlan) bool, error) {
+	for _, p := range []string{q.NameLike, q.NameRegex, q.Org} {
+		if len(p) > maxTenantQueryPattern {
+			return nil, fmt.Errorf("query pattern is over %d characters", maxTenantQueryPattern)
+		}
+	}
+	var nameLike, nameRegex, org *regexp.Regexp
+	var err error
+	if q.NameLike != "" {
+		if nameLike, err = wildcardPattern(q.NameLike); err != nil {
+			return nil, fmt.Errorf("invalid nameLike pattern %v", err)
+		}
+	}
+	if q.NameRegex != "" {
+		if nameRegex, err = regexp.Compile("^(?:" + q.NameRegex + ")$"); err != nil {
+			return nil, fmt.Errorf("invalid nameRegex pattern %v", err)
+		}
+	}
+	if q.Org != "" {
+		if org, err = wildcardPattern(q.Org); err != nil {
+			return nil, fmt.Errorf("invalid org pattern %v", err)
+		}
+	}
+	return func(t TenantPlan) bool {
+		return (nameLike == nil || nameLike.MatchString(t.Name)) &&
+			(nameRegex == nil || nameRegex.MatchString(t.Name)) &&
+			(org == nil || org.MatchString(t.Org)) &&
+			(q.PlanType == "" || strings.EqualFold(q.PlanType, t.PlanType))
+	}, nil
+}
+
+// Filter returns the tenant plans matching the query sorted by the name
+func (q TenantPlanQuery) Filter(plans []TenantPlan) ([]TenantPlan, error) {
+	match, err := q.matcher()
+	if err != nil {
+		return nil, err
+	}
+	selected := []TenantPlan{}
+	for _, t := range plans {
+		if match(t) {
+			selected = append(selected, t)
+		}
+	}
+	sort.Slice(selected, func(i, j int) bool { return selected[i].Name < selected[j].Name })
+	return selected, nil
+}
+
+// QueryTenants returns the cached tenant plans matching the query sorted by the name.
+// An org without a wildcard is looked up in the org index instead of scanning all tenants.
+func (s *TenantPolicyHandler) QueryTenants(q TenantPlanQuery) ([]TenantPlan, error) {
+	candidates := []TenantPlan{}
+	s.tenantsLock.RLock()
+	if q.Org != "" && !isWildcard(q.Org) {
+		for name := range s.orgs[strings.ToLower(q.Org)] {
+			candidates = append(candidates, s.tenants[name])
+		}
+	} else {
+		for _, t := range s.tenants {
+			candidates = append(candidates, t)
+		}
+	}
+	s.tenantsLock.RUnlock()
+	return q.Filter(candidates)
+}
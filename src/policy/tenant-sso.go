@@ -0,0 +1,198 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package policy
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/datastax/burnell/src/util"
+)
+
+// the SSO match reasons
+const (
+	MatchedByEmailDomain = "emailDomain"
+	MatchedByIdPGroup    = "idpGroup"
+)
+
+// the max number of email domains and IdP groups of a tenant
+const maxTenantSSOEntries = 100
+
+// an email domain optionally with a leading *. subdomain wildcard
+var emailDomainPattern = regexp.MustCompile(`^(\*\.)?([a-z0-9]([-a-z0-9]{0,61}[a-z0-9])?\.)+[a-z]{2,63}$`)
+
+// TenantSSO is the console single sign on users allowed to administer the tenant,
+// by their email domain, i.e. acme.com or *.acme.com for the subdomains, or their identity provider group
+type TenantSSO struct {
+	EmailDomains []string `json:"emailDomains,omitempty"`
+	IdPGroups    []string `json:"idpGroups,omitempty"`
+}
+
+// SSOTenant is a tenant a SSO user may administer and the reasons it is matched
+type SSOTenant struct {
+	Tenant    string   `json:"tenant"`
+	MatchedBy []string `json:"matchedBy"`
+}
+
+// ValidateTenantSSO validates the email domains and the IdP groups of the tenant
+func ValidateTenantSSO(sso *TenantSSO) error {
+	if sso == nil {
+		return nil
+	}
+	fieldErrs := []FieldError{}
+	if len(sso.EmailDomains) > maxTenantSSOEntries || len(sso.IdPGroups) > maxTenantSSOEntries {
+		fieldErrs = append(fieldErrs, FieldError{
+			Field:  "sso",
+			Value:  fmt.Sprintf("%d email domains %d idp groups", len(sso.EmailDomains), len(sso.IdPGroups)),
+			Reason: fmt.Sprintf("at most %d email domains and %d idp groups", maxTenantSSOEntries, maxTenantSSOEntries),
+		})
+	}
+	for _, domain := range sso.EmailDomains {
+		if !emailDomainPattern.MatchString(strings.ToLower(domain)) {
+			fieldErrs = append(fieldErrs, FieldError{
+				Field:  "sso.emailDomains",
+				Value:  domain,
+				Reason: "email domain must be a domain name, optionally with a leading *. subdomain wildcard",
+			})
+		}
+	}
+	for _, group := range sso.IdPGroups {
+		if group == "" || len(group) > 256 {
+			fieldErrs = append(fieldErrs, FieldError{
+				Field:  "sso.idpGroups",
+				Value:  group,
+				Reason: "idp group must be 1 to 256 characters",
+			})
+		}
+	}
+	if len(fieldErrs) > 0 {
+		return &ValidationError{Fields: fieldErrs}
+	}
+	return nil
+}
+
+// EmailDomain returns the lower case domain of an email address
+func EmailDomain(email string) (string, error) {
+	i := strings.LastIndex(email, "@")
+	if i <= 0 || i == len(email)-1 {
+		return "", fmt.Errorf("invalid email address %s", email)
+	}
+	return strings.ToLower(email[i+1:]), nil
+}
+
+// domainKeys returns the email domain index keys matching a domain, the domain itself and the *. wildcards
+// of its parent domains, i.e. eng.acme.com matches eng.acme.com and *.acme.com
+func domainKeys(domain string) []string {
+	keys := []string{domain}
+	for rest := domain; strings.Count(rest, ".") > 1; {
+		rest = rest[strings.Index(rest, ".")+1:]
+		keys = append(keys, "*."+rest)
+	}
+	return keys
+}
+
+// MatchTenantSSO returns the reasons the tenant SSO matches the email domain or any of the IdP groups,
+// it is empty if the tenant does not match
+func MatchTenantSSO(plan TenantPlan, domain string, groups []string) []string {
+	matchedBy := []string{}
+	if plan.SSO == nil {
+		return matchedBy
+	}
+	keys := domainKeys(strings.ToLower(domain))
+	for _, d := range plan.SSO.EmailDomains {
+		if domain != "" && util.StrContains(keys, strings.ToLower(d)) {
+			matchedBy = append(matchedBy, MatchedByEmailDomain)
+			break
+		}
+	}
+	for _, g := range plan.SSO.IdPGroups {
+		if util.StrContains(groups, g) {
+			matchedBy = append(matchedBy, MatchedByIdPGroup)
+			break
+		}
+	}
+	return matchedBy
+}
+
+// ResolveSSOTenants returns the tenants a SSO user may administer by the email and the IdP groups,
+// sorted by the tenant name. The candidates are looked up in the email domain and IdP group indexes.
+func (s *TenantPolicyHandler) ResolveSSOTenants(email string, groups []string) ([]SSOTenant, error) {
+	domain := ""
+	if email != "" {
+		var err error
+		if domain, err = EmailDomain(email); err != nil {
+			return nil, err
+		}
+	}
+
+	s.tenantsLock.RLock()
+	candidates := map[string]TenantPlan{}
+	if domain != "" {
+		for _, key := range domainKeys(domain) {
+			for name := range s.emailDomains[key] {
+				candidates[name] = s.tenants[name]
+			}
+		}
+	}
+	for _, group := range groups {
+		for name := range s.idpGroups[group] {
+			candidates[name] = s.tenants[name]
+		}
+	}
+	s.tenantsLock.RUnlock()
+
+	tenants := []SSOTenant{}
+	for name, plan := range candidates {
+		if plan.TenantStatus == Deleted {
+			continue
+		}
+		if matchedBy := MatchTenantSSO(plan, domain, groups); len(matchedBy) > 0 {
+			tenants = append(tenants, SSOTenant{Tenant: name, MatchedBy: matchedBy})
+		}
+	}
+	sort.Slice(tenants, func(i, j int) bool { return tenants[i].Tenant < tenants[j].Tenant })
+	return tenants, nil
+}
+
+// SetTenantSSO replaces the SSO mapping of the tenant, an empty mapping removes it
+func (s *TenantPolicyHandler) SetTenantSSO(tenantName string, sso TenantSSO) (TenantPlan, int, error) {
+	if err := ValidateTenantSSO(&sso); err != nil {
+		return TenantPlan{}, http.StatusUnprocessableEntity, err
+	}
+	plan, err := s.GetTenant(tenantName)
+	if err != nil {
+		return TenantPlan{}, http.StatusNotFound, err
+	}
+	plan.SSO = &sso
+	if len(sso.EmailDomains) == 0 && len(sso.IdPGroups) == 0 {
+		plan.SSO = nil
+	}
+	plan.Audit = plan.Audit + ",sso mapping updated"
+	updatedPlan, err := s.updateDb(plan)
+	if err != nil {
+		return TenantPlan{}, http.StatusInternalServerError, err
+	}
+	return updatedPlan, http.StatusOK, nil
+}
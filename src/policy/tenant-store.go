@@ -0,0 +1,90 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package policy
+
+import (
+	"context"
+	"time"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/apex/log"
+	"github.com/datastax/burnell/src/util"
+)
+
+// TenantStore persists the tenant plan records keyed by the tenant name. The default store is the
+// Pulsar tenant management topic, the tenant cache is rebuilt by reading the topic from the earliest message.
+type TenantStore interface {
+	Write(tenantName string, record []byte) error
+}
+
+// pulsarTenantStore writes the tenant plan records to the tenant management topic
+type pulsarTenantStore struct {
+	client    pulsar.Client
+	topicName string
+}
+
+// Write sends the record to the topic keyed by the tenant name
+func (p *pulsarTenantStore) Write(tenantName string, record []byte) error {
+	producer, err := p.client.CreateProducer(pulsar.ProducerOptions{
+		Topic:           p.topicName,
+		DisableBatching: true,
+	})
+	if err != nil {
+		util.PulsarClientFailed(util.PolicyWriterClient, p.topicName, err)
+		return err
+	}
+	defer producer.Close()
+	util.PulsarClientConnected(util.PolicyWriterClient, p.topicName)
+
+	msg := pulsar.ProducerMessage{
+		Payload: record,
+		Key:     tenantName,
+	}
+	if _, err = producer.Send(context.Background(), &msg); err != nil {
+		util.PulsarClientFailed(util.PolicyWriterClient, p.topicName, err)
+		return err
+	}
+	util.PulsarClientMessage(util.PolicyWriterClient)
+	producer.Flush()
+	return nil
+}
+
+// initCache resets the tenant cache and the indexes
+func (s *TenantPolicyHandler) initCache() {
+	s.logger = log.WithFields(log.Fields{"app": "tenantdb"})
+	s.tenantsLock.Lock()
+	defer s.tenantsLock.Unlock()
+	s.tenants = make(map[string]TenantPlan)
+	s.keyIDs = make(map[string]string)
+	s.undecryptable = make(map[string]*EncryptedFields)
+	s.cacheOnly = make(map[string]time.Time)
+	s.deleted = make(map[string]time.Time)
+	s.orgs, s.emailDomains, s.idpGroups = tenantIndex{}, tenantIndex{}, tenantIndex{}
+}
+
+// SetupStore sets up the tenant manager with an empty cache over the store instead of the Pulsar topic,
+// such as an in-memory store for the integration tests. The cache is warm right away.
+func (s *TenantPolicyHandler) SetupStore(store TenantStore) {
+	s.initCache()
+	s.store = store
+	s.markWarm()
+}
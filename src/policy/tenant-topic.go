@@ -0,0 +1,193 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package policy
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/datastax/burnell/src/util"
+)
+
+// tenantTopicReadCompacted reads the compacted tenant management topic at startup by TenantTopicReadCompacted,
+// only the latest record of each tenant is replayed once the topic is compacted, set 0 to read all records
+var tenantTopicReadCompacted = util.GetEnvInt("TenantTopicReadCompacted", 1) > 0
+
+// tenantTopicName is the tenant management topic in TenantManagmentTopic
+func tenantTopicName() string {
+	return util.AssignString(util.GetConfig().TenantManagmentTopic, "persistent://public/default/tenants-management")
+}
+
+// topicReplay counts the records read by the tenant cache listener since it started to read the topic
+type topicReplay struct {
+	startedAt time.Time
+	records   int64
+	keys      map[string]bool
+	// warmRecords and warmDuration are the records and the time to catch up to the topic at startup
+	warmRecords  int64
+	warmDuration time.Duration
+}
+
+func (r *topicReplay) start() {
+	r.startedAt = time.Now()
+	r.records = 0
+	r.keys = map[string]bool{}
+}
+
+func (r *topicReplay) record(tenant string) {
+	if r.keys == nil {
+		r.keys = map[string]bool{}
+	}
+	r.records++
+	r.keys[tenant] = true
+}
+
+func (r *topicReplay) warm() {
+	if !r.startedAt.IsZero() {
+		r.warmRecords = r.records
+		r.warmDuration = time.Since(r.startedAt)
+	}
+}
+
+// TenantTopicStats is the records of the tenant management topic read by the listener,
+// and the broker stats of the topic. The obsolete records are superseded by a later record of the same tenant.
+type TenantTopicStats struct {
+	Topic           string  `json:"topic"`
+	ReadCompacted   bool    `json:"readCompacted"`
+	Records         int64   `json:"records"`
+	Tenants         int     `json:"tenants"`
+	ObsoleteRecords int64   `json:"obsoleteRecords"`
+	ReplayRecords   int64   `json:"replayRecords"`
+	ReplaySeconds   float64 `json:"replaySeconds"`
+
+	MessageCount      int64  `json:"messageCount"`
+	StorageSize       int64  `json:"storageSize"`
+	NumberOfLedgers   int    `json:"numberOfLedgers"`
+	EarliestMessageID string `json:"earliestMessageId,omitempty"`
+	LatestMessageID   string `json:"latestMessageId,omitempty"`
+	CompactedEntries  int64  `json:"compactedEntries"`
+	CompactionStatus  string `json:"compactionStatus,omitempty"`
+	CompactionError   string `json:"compactionError,omitempty"`
+	Error             string `json:"error,omitempty"`
+}
+
+// the subset of the broker internal stats of the tenant management topic
+type tenantTopicInternalStats struct {
+	NumberOfEntries    int64  `json:"numberOfEntries"`
+	TotalSize          int64  `json:"totalSize"`
+	LastConfirmedEntry string `json:"lastConfirmedEntry"`
+	Ledgers            []struct {
+		LedgerID int64 `json:"ledgerId"`
+		Entries  int64 `json:"entries"`
+	} `json:"ledgers"`
+	CompactedLedger struct {
+		LedgerID int64 `json:"ledgerId"`
+		Entries  int64 `json:"entries"`
+	} `json:"compactedLedger"`
+}
+
+// tenantTopicPath is the admin v2 REST path of the tenant management topic
+func (s *TenantPolicyHandler) tenantTopicPath() (string, error) {
+	topic := util.AssignString(s.topicName, tenantTopicName())
+	tenant, ns, name, err := util.ExtractPartsFromTopicFn(topic)
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(topic, "persistent://") {
+		return "", fmt.Errorf("tenant management topic %s is not persistent", topic)
+	}
+	return "persistent/" + tenant + "/" + ns + "/" + name, nil
+}
+
+// TopicStats returns the tenant management topic stats, a broker stats failure is set in the Error
+func (s *TenantPolicyHandler) TopicStats() TenantTopicStats {
+	s.tenantsLock.RLock()
+	stats := TenantTopicStats{
+		Topic:         util.AssignString(s.topicName, tenantTopicName()),
+		ReadCompacted: tenantTopicReadCompacted,
+		Records:       s.replay.records,
+		Tenants:       len(s.replay.keys),
+		ReplayRecords: s.replay.warmRecords,
+		ReplaySeconds: s.replay.warmDuration.Seconds(),
+	}
+	s.tenantsLock.RUnlock()
+	stats.ObsoleteRecords = stats.Records - int64(stats.Tenants)
+
+	path, err := s.tenantTopicPath()
+	if err != nil {
+		stats.Error = err.Error()
+		return stats
+	}
+	var internal tenantTopicInternalStats
+	if _, err := adminAPIRequest(http.MethodGet, path+"/internalStats", nil, &internal); err != nil {
+		stats.Error = err.Error()
+		return stats
+	}
+	stats.MessageCount = internal.NumberOfEntries
+	stats.StorageSize = internal.TotalSize
+	stats.NumberOfLedgers = len(internal.Ledgers)
+	stats.LatestMessageID = internal.LastConfirmedEntry
+	stats.CompactedEntries = internal.CompactedLedger.Entries
+	for _, ledger := range internal.Ledgers {
+		if ledger.Entries > 0 {
+			stats.EarliestMessageID = fmt.Sprintf("%d:0", ledger.LedgerID)
+			break
+		}
+	}
+
+	var compaction struct {
+		Status    string `json:"status"`
+		LastError string `json:"lastError"`
+	}
+	if _, err := adminAPIRequest(http.MethodGet, path+"/compaction", nil, &compaction); err == nil {
+		stats.CompactionStatus = compaction.Status
+		stats.CompactionError = compaction.LastError
+	}
+	return stats
+}
+
+// CompactTopic triggers the broker compaction of the tenant management topic to keep the latest record of each tenant
+func (s *TenantPolicyHandler) CompactTopic() (int, error) {
+	return s.tenantTopicAdmin(http.MethodPut, "/compaction")
+}
+
+// TrimTopic triggers the broker to trim the ledgers of the tenant management topic out of the retention policy
+func (s *TenantPolicyHandler) TrimTopic() (int, error) {
+	return s.tenantTopicAdmin(http.MethodPost, "/trim")
+}
+
+func (s *TenantPolicyHandler) tenantTopicAdmin(method, subroute string) (int, error) {
+	path, err := s.tenantTopicPath()
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	status, err := adminAPIRequest(method, path+subroute, nil, nil)
+	if err != nil {
+		if status == 0 || status >= http.StatusInternalServerError {
+			status = http.StatusBadGateway
+		}
+		return status, err
+	}
+	return http.StatusAccepted, nil
+}
@@ -0,0 +1,148 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package policy
+
+import (
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+)
+
+const (
+	// PlanUpdated is the event type for a created or an updated tenant plan
+	PlanUpdated = "updated"
+	// PlanDeleted is the event type for a deleted tenant plan
+	PlanDeleted = "deleted"
+
+	// watchBufferSize is the number of events buffered per subscriber before event drop
+	watchBufferSize = 16
+)
+
+// TenantPlanEvent is the change event of a tenant plan
+type TenantPlanEvent struct {
+	Type   string     `json:"type"`
+	Tenant string     `json:"tenant"`
+	Plan   TenantPlan `json:"plan"`
+	Time   time.Time  `json:"time"`
+	// Changes are the changed field paths from the previous event of the tenant, see PlanFieldChanges
+	Changes []string `json:"changes"`
+}
+
+// planWatcher is a subscriber to tenant plan changes, an empty tenant subscribes to all tenants
+// and empty fields subscribe to all changes
+type planWatcher struct {
+	tenant string
+	fields []string
+	events chan TenantPlanEvent
+}
+
+// planWatchers are all subscribers to tenant plan changes
+type planWatchers struct {
+	watchers map[int]*planWatcher
+	nextID   int
+	// lastPlans are the last published plans to compute the changed fields
+	lastPlans map[string]TenantPlan
+	lock      sync.RWMutex
+}
+
+var watchers = planWatchers{watchers: make(map[int]*planWatcher), lastPlans: make(map[string]TenantPlan)}
+
+// WatchTenantPlan subscribes to plan changes of a tenant, or all tenants if the tenant is empty.
+// The returned function must be called to unsubscribe.
+func WatchTenantPlan(tenant string) (<-chan TenantPlanEvent, func()) {
+	return WatchTenantPlanFields(tenant, nil)
+}
+
+// WatchTenantPlanFields subscribes to plan changes of a tenant that change any of the fields, see MatchChangedFields.
+// The returned function must be called to unsubscribe.
+func WatchTenantPlanFields(tenant string, fields []string) (<-chan TenantPlanEvent, func()) {
+	w := &planWatcher{
+		tenant: tenant,
+		fields: fields,
+		events: make(chan TenantPlanEvent, watchBufferSize),
+	}
+	watchers.lock.Lock()
+	id := watchers.nextID
+	watchers.nextID++
+	watchers.watchers[id] = w
+	watchers.lock.Unlock()
+
+	return w.events, func() {
+		watchers.lock.Lock()
+		delete(watchers.watchers, id)
+		watchers.lock.Unlock()
+	}
+}
+
+// publishTenantPlanEvent notifies all subscribers without blocking the database listener,
+// events are dropped for a subscriber whose buffer is full. The plan change rules are not dispatched
+// for the replayed plans while the cache is warming up.
+func publishTenantPlanEvent(t TenantPlan, replay bool) {
+	event := TenantPlanEvent{
+		Type:   PlanUpdated,
+		Tenant: t.Name,
+		Plan:   t,
+		Time:   time.Now(),
+	}
+	if t.TenantStatus == Deleted {
+		event.Type = PlanDeleted
+	}
+
+	watchers.lock.Lock()
+	event.Changes = PlanFieldChanges(watchers.lastPlans[t.Name], t)
+	if t.TenantStatus == Deleted {
+		delete(watchers.lastPlans, t.Name)
+	} else {
+		watchers.lastPlans[t.Name] = t
+	}
+	watchers.lock.Unlock()
+	if !replay {
+		DispatchPlanChangeRules(event)
+		if event.Type == PlanDeleted || len(event.Changes) > 0 {
+			RecordTenantEvent(TenantEvent{
+				Time:     event.Time,
+				Tenant:   t.Name,
+				Category: PlanEventCategory,
+				Type:     event.Type,
+				Summary:  "tenant plan " + event.Type + ", plan type " + t.PlanType,
+				Changes:  event.Changes,
+			})
+		}
+	}
+
+	watchers.lock.RLock()
+	defer watchers.lock.RUnlock()
+	for _, w := range watchers.watchers {
+		if w.tenant != "" && w.tenant != t.Name {
+			continue
+		}
+		if len(w.fields) > 0 && !MatchChangedFields(w.fields, event.Changes) {
+			continue
+		}
+		select {
+		case w.events <- event:
+		default:
+			log.Warnf("drop tenant %s plan event for a slow watcher", t.Name)
+		}
+	}
+}
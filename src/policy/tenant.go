@@ -70,7 +70,7 @@ func updateTenants() error {
 		return err
 	}
 	newRequest.Header.Add("X-Proxy", "burnell")
-	newRequest.Header.Add("Authorization", "Bearer "+util.Config.PulsarToken)
+	newRequest.Header.Add("Authorization", "Bearer "+util.PulsarAuthToken())
 
 	client := &http.Client{
 		CheckRedirect: util.PreserveHeaderForRedirect,
@@ -0,0 +1,63 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package policy
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/apex/log"
+)
+
+// TopicPlanDefaults returns the topic level policies derived from the tenant plan by the admin REST API policy name,
+// deduplicationEnabled under the message-deduplication feature, maxProducers and messageTTL in seconds
+func TopicPlanDefaults(t TenantPlan) map[string]interface{} {
+	defaults := make(map[string]interface{})
+	if IsFeatureSupported(MessageDeduplication, t.Policy.FeatureCodes) {
+		defaults["deduplicationEnabled"] = true
+	}
+	if t.Policy.NumOfProducers > 0 {
+		defaults["maxProducers"] = t.Policy.NumOfProducers
+	}
+	// messages are not expired under infinite retention
+	if t.Policy.MessageHourRetention > 0 && !IsFeatureSupported(InfiniteMessageRetention, t.Policy.FeatureCodes) {
+		defaults["messageTTL"] = t.Policy.MessageHourRetention * 3600
+	}
+	return defaults
+}
+
+// ApplyTopicPlanDefaults sets the plan derived policies on a newly created topic, i.e. persistent/tenant/namespace/topic.
+// It requires the topic level policies enabled on the brokers, a failed policy does not stop the rest.
+func ApplyTopicPlanDefaults(tenant, topicPath string) []error {
+	plan, err := TenantManager.GetTenant(tenant)
+	if err != nil {
+		plan = newFreeTenantPlan(tenant)
+	}
+	errs := []error{}
+	for name, value := range TopicPlanDefaults(plan) {
+		if _, err := adminAPIRequest(http.MethodPost, topicPath+"/"+name, value, nil); err != nil {
+			log.Errorf("apply plan default %s to topic %s error %v", name, topicPath, err)
+			errs = append(errs, fmt.Errorf("%s: %v", name, err))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,191 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package policy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/datastax/burnell/src/util"
+)
+
+// the number of concurrent partition internal stats queries per request
+var internalStatsWorkers = util.GetEnvInt("StatsInternalWorkers", 8)
+
+// the partition internal stats queries, a failed query is retried unless it is a 4xx response
+var internalStatsPool = util.NewWorkerPool(util.WorkerPoolConfig{
+	Name:         "topic-internal-stats",
+	Concurrency:  internalStatsWorkers,
+	Retries:      util.GetEnvInt("StatsInternalRetries", 1),
+	RetryBackoff: 100 * time.Millisecond,
+})
+
+// PartitionInternalStats is the ledger summary of a partition internal stats, -1 partition is a non-partitioned topic
+type PartitionInternalStats struct {
+	Partition           int         `json:"partition"`
+	Topic               string      `json:"topic"`
+	NumberOfLedgers     int         `json:"numberOfLedgers"`
+	NumberOfEntries     int64       `json:"numberOfEntries"`
+	TotalSize           int64       `json:"totalSize"`
+	EntriesAddedCounter int64       `json:"entriesAddedCounter"`
+	NumberOfCursors     int         `json:"numberOfCursors"`
+	Error               string      `json:"error,omitempty"`
+	Data                interface{} `json:"data,omitempty"`
+}
+
+// TopicInternalStats is a page of partition internal stats and the ledger summary on the page
+type TopicInternalStats struct {
+	Topic           string                   `json:"topic"`
+	Partitions      int                      `json:"partitions"`
+	Offset          int                      `json:"offset"`
+	NumberOfLedgers int                      `json:"numberOfLedgers"`
+	NumberOfEntries int64                    `json:"numberOfEntries"`
+	TotalSize       int64                    `json:"totalSize"`
+	Data            []PartitionInternalStats `json:"data"`
+}
+
+// the subset of the broker internal stats to summarize
+type internalStats struct {
+	EntriesAddedCounter int64                  `json:"entriesAddedCounter"`
+	NumberOfEntries     int64                  `json:"numberOfEntries"`
+	TotalSize           int64                  `json:"totalSize"`
+	Ledgers             []interface{}          `json:"ledgers"`
+	Cursors             map[string]interface{} `json:"cursors"`
+}
+
+// GetTopicInternalStats gets the internal stats of the partitions in the range of offset and limit concurrently.
+// The full internal stats are only included with detail, otherwise only the ledger summary.
+func GetTopicInternalStats(ctx context.Context, tenant, namespace, topic string, offset, limit int, detail bool) (TopicInternalStats, int, error) {
+	if offset < 0 || limit < 1 {
+		return TopicInternalStats{}, http.StatusUnprocessableEntity, fmt.Errorf("offset cannot be negative and limit must be greater than 0")
+	}
+	topicPath := "admin/v2/persistent/" + tenant + "/" + namespace + "/" + topic
+	var metadata struct {
+		Partitions int `json:"partitions"`
+	}
+	if err := adminGetJSON(ctx, topicPath+"/partitions", &metadata); err != nil {
+		return TopicInternalStats{}, http.StatusInternalServerError, err
+	}
+
+	resp := TopicInternalStats{
+		Topic:      "persistent://" + tenant + "/" + namespace + "/" + topic,
+		Partitions: metadata.Partitions,
+	}
+	partitions := []int{-1}
+	if metadata.Partitions > 0 {
+		if offset >= metadata.Partitions {
+			return resp, http.StatusUnprocessableEntity, fmt.Errorf("offset %d cannot reconcile with the number of partitions %d", offset, metadata.Partitions)
+		}
+		end := offset + limit
+		if end > metadata.Partitions {
+			end = metadata.Partitions
+		}
+		partitions = []int{}
+		for i := offset; i < end; i++ {
+			partitions = append(partitions, i)
+		}
+		resp.Offset = end
+	}
+
+	resp.Data = make([]PartitionInternalStats, len(partitions))
+	internalStatsPool.Run(ctx, len(partitions), func(ctx context.Context, i int) error {
+		var err error
+		resp.Data[i], err = partitionInternalStats(ctx, topicPath, partitions[i], detail)
+		return err
+	})
+
+	for _, v := range resp.Data {
+		resp.NumberOfLedgers += v.NumberOfLedgers
+		resp.NumberOfEntries += v.NumberOfEntries
+		resp.TotalSize += v.TotalSize
+	}
+	return resp, http.StatusOK, nil
+}
+
+// partitionInternalStats returns the internal stats of a partition with the error in the stats if it fails
+func partitionInternalStats(ctx context.Context, topicPath string, partition int, detail bool) (PartitionInternalStats, error) {
+	path := topicPath
+	if partition >= 0 {
+		path = topicPath + "-partition-" + strconv.Itoa(partition)
+	}
+	result := PartitionInternalStats{
+		Partition: partition,
+		Topic:     path[len("admin/v2/"):],
+	}
+	var raw json.RawMessage
+	if err := adminGetJSON(ctx, path+"/internalStats", &raw); err != nil {
+		result.Error = err.Error()
+		return result, err
+	}
+	var stats internalStats
+	if err := json.Unmarshal(raw, &stats); err != nil {
+		result.Error = err.Error()
+		return result, util.NoRetry(err)
+	}
+	result.NumberOfLedgers = len(stats.Ledgers)
+	result.NumberOfEntries = stats.NumberOfEntries
+	result.TotalSize = stats.TotalSize
+	result.EntriesAddedCounter = stats.EntriesAddedCounter
+	result.NumberOfCursors = len(stats.Cursors)
+	if detail {
+		result.Data = raw
+	}
+	return result, nil
+}
+
+// adminGetJSON gets and unmarshals a broker admin REST API response, the call is canceled with the context
+func adminGetJSON(ctx context.Context, paths string, v interface{}) error {
+	requestURL := util.SingleJoinSlash(util.Config.BrokerProxyURL, paths)
+	newRequest, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL, nil)
+	if err != nil {
+		return err
+	}
+	newRequest.Header.Add("user-agent", "burnell")
+	newRequest.Header.Add("Authorization", "Bearer "+util.PulsarAuthToken())
+	client := &http.Client{
+		CheckRedirect: util.PreserveHeaderForRedirect,
+		Timeout:       30 * time.Second,
+	}
+	response, err := client.Do(newRequest)
+	if response != nil {
+		defer response.Body.Close()
+	}
+	if err != nil {
+		statsLog.Errorf("GET %s error %v", requestURL, err)
+		return err
+	}
+	if response.StatusCode >= 400 && response.StatusCode < 500 {
+		return util.NoRetry(fmt.Errorf("GET %s response status code %d", requestURL, response.StatusCode))
+	} else if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s response status code %d", requestURL, response.StatusCode)
+	}
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
@@ -22,7 +22,9 @@
 package policy
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -40,6 +42,14 @@ var statsLog = log.WithFields(log.Fields{"app": "broker stats cache"})
 
 var topicStatsDB *memdb.MemDB
 
+// brokerStatsPool fans out the broker stats queries of the aggregation
+var brokerStatsPool = util.NewWorkerPool(util.WorkerPoolConfig{
+	Name:         "broker-stats",
+	Concurrency:  util.GetEnvInt("BrokerStatsConcurrency", 16),
+	Retries:      util.GetEnvInt("BrokerStatsRetries", 0),
+	RetryBackoff: 200 * time.Millisecond,
+})
+
 const (
 	topicStatsDBTable = "topic-stats"
 )
@@ -142,7 +152,7 @@ func GetBrokers() []string {
 		statsLog.Errorf("make http request brokers %s error %v", requestBrokersURL, err)
 		return []string{}
 	}
-	newRequest.Header.Add("Authorization", "Bearer "+util.Config.PulsarToken)
+	newRequest.Header.Add("Authorization", "Bearer "+util.PulsarAuthToken())
 	client := &http.Client{
 		CheckRedirect: util.PreserveHeaderForRedirect,
 	}
@@ -224,7 +234,7 @@ func brokerStatsTopicsQuery(urlString string) (map[string]string, error) {
 		return partitionTopicNames, err
 	}
 	newRequest.Header.Add("user-agent", "burnell")
-	newRequest.Header.Add("Authorization", "Bearer "+util.Config.PulsarToken)
+	newRequest.Header.Add("Authorization", "Bearer "+util.PulsarAuthToken())
 	client := &http.Client{
 		CheckRedirect: util.PreserveHeaderForRedirect,
 	}
@@ -318,7 +328,7 @@ func getTopicsFromNamespace(path string, isPersistent bool) ([]string, error) {
 		statsLog.Errorf("make http request a single topic stats %s error %v", requestBrokersURL, err)
 		return nil, err
 	}
-	newRequest.Header.Add("Authorization", "Bearer "+util.Config.PulsarToken)
+	newRequest.Header.Add("Authorization", "Bearer "+util.PulsarAuthToken())
 	client := &http.Client{
 		CheckRedirect: util.PreserveHeaderForRedirect,
 	}
@@ -370,7 +380,7 @@ func getSingleTopicStats(topicFullname string, isPartitionTopic bool) (interface
 		statsLog.Errorf("make http request a single topic stats %s error %v", requestBrokersURL, err)
 		return nil, err
 	}
-	newRequest.Header.Add("Authorization", "Bearer "+util.Config.PulsarToken)
+	newRequest.Header.Add("Authorization", "Bearer "+util.PulsarAuthToken())
 	response, err := client.Do(newRequest)
 	if response != nil {
 		defer response.Body.Close()
@@ -484,8 +494,8 @@ func PaginateTopicStats(tenant string, offset, pageSize int, mandatoryTopics []s
 	return totalSize, newOffset, newMap
 }
 
-// AggregateBrokersStats aggregates all brokers' statistics
-func AggregateBrokersStats(subRoute string, offset, limit int) (BrokersStats, int, error) {
+// AggregateBrokersStats aggregates all brokers' statistics, the broker queries are canceled with the context
+func AggregateBrokersStats(ctx context.Context, subRoute string, offset, limit int) (BrokersStats, int, error) {
 	if offset < 0 || limit < 0 {
 		return BrokersStats{}, http.StatusUnprocessableEntity, fmt.Errorf("offset or limit cannot be negative")
 	}
@@ -513,33 +523,31 @@ func AggregateBrokersStats(subRoute string, offset, limit int) (BrokersStats, in
 		Offset: newOffset,
 	}
 
-	var brokerStats []BrokerStats
-	resultChan := make(chan BrokerStats, size)
-	BrokerTimeoutSecond := time.Duration(size*2) * time.Second
-
-	for _, broker := range brokers {
-		go brokerStatsQuery(broker, subRoute, resultChan)
-	}
-
-	ticker := time.NewTicker(BrokerTimeoutSecond)
-	for {
-		select {
-		case result := <-resultChan:
-			brokerStats = append(brokerStats, result)
-			if size--; size == 0 {
-				resp.Data = brokerStats
-				return resp, http.StatusOK, nil
-			}
-		case <-ticker.C:
-			statsLog.Errorf("timeout on brokers stats response")
-			resp.Data = brokerStats
-			return resp, http.StatusInternalServerError, fmt.Errorf("broker stats time out by server")
+	brokerStats := make([]BrokerStats, size)
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(size*2)*time.Second)
+	defer cancel()
+	errs := brokerStatsPool.Run(ctx, size, func(ctx context.Context, i int) error {
+		var err error
+		brokerStats[i], err = brokerStatsQuery(ctx, brokers[i], subRoute)
+		return err
+	})
+
+	// a failed broker is returned without the data, the brokers not done by the timeout are left out
+	resp.Data = []BrokerStats{}
+	for i, err := range errs {
+		if errors.Is(err, context.DeadlineExceeded) {
+			continue
 		}
+		resp.Data = append(resp.Data, brokerStats[i])
 	}
-
+	if ctx.Err() != nil {
+		statsLog.Errorf("timeout on brokers stats response")
+		return resp, http.StatusInternalServerError, fmt.Errorf("broker stats time out by server")
+	}
+	return resp, http.StatusOK, nil
 }
 
-func brokerStatsQuery(urlString, subRoute string, respChan chan BrokerStats) {
+func brokerStatsQuery(ctx context.Context, urlString, subRoute string) (BrokerStats, error) {
 	brokerStats := BrokerStats{
 		Broker: urlString,
 	}
@@ -550,14 +558,13 @@ func brokerStatsQuery(urlString, subRoute string, respChan chan BrokerStats) {
 	statsLog.Infof(" proxy request route is %s\n", brokerStatsURL)
 
 	// Update the headers to allow for SSL redirection
-	newRequest, err := http.NewRequest(http.MethodGet, brokerStatsURL, nil)
+	newRequest, err := http.NewRequestWithContext(ctx, http.MethodGet, brokerStatsURL, nil)
 	if err != nil {
 		statsLog.Errorf("make http request %s error %v", brokerStatsURL, err)
-		respChan <- brokerStats
-		return
+		return brokerStats, util.NoRetry(err)
 	}
 	newRequest.Header.Add("user-agent", "burnell")
-	newRequest.Header.Add("Authorization", "Bearer "+util.Config.PulsarToken)
+	newRequest.Header.Add("Authorization", "Bearer "+util.PulsarAuthToken())
 	client := &http.Client{
 		CheckRedirect: util.PreserveHeaderForRedirect,
 	}
@@ -567,32 +574,32 @@ func brokerStatsQuery(urlString, subRoute string, respChan chan BrokerStats) {
 	}
 	if err != nil {
 		statsLog.Errorf("GET broker topic stats make http request %s error %v", brokerStatsURL, err)
-		respChan <- brokerStats
-		return
+		return brokerStats, err
 	}
 
 	if response.StatusCode != http.StatusOK {
 		statsLog.Errorf("GET broker topic stats %s response status code %d", brokerStatsURL, response.StatusCode)
-		respChan <- brokerStats
-		return
+		err = fmt.Errorf("GET broker topic stats %s response status code %d", brokerStatsURL, response.StatusCode)
+		if response.StatusCode < 500 {
+			return brokerStats, util.NoRetry(err)
+		}
+		return brokerStats, err
 	}
 
 	body, err := ioutil.ReadAll(response.Body)
 	if err != nil {
 		statsLog.Errorf("GET broker topic stats request %s error %v", brokerStatsURL, err)
-		respChan <- brokerStats
-		return
+		return brokerStats, err
 	}
 
 	// tenant's namespace/bundle hash/persistent/topicFullName
 	var result interface{}
 	if err = json.Unmarshal(body, &result); err != nil {
 		statsLog.Errorf("GET broker topic stats request %s unmarshal error %v", brokerStatsURL, err)
-		respChan <- brokerStats
-		return
+		return brokerStats, util.NoRetry(err)
 	}
 	brokerStats.Data = result
-	respChan <- brokerStats
+	return brokerStats, nil
 }
 
 // IsPartitionTopic verifies if the topic is a partition topic.
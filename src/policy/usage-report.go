@@ -0,0 +1,108 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/datastax/burnell/src/metrics"
+	"github.com/datastax/burnell/src/notification"
+	"github.com/datastax/burnell/src/util"
+)
+
+// DefaultUsageReportPeriod is the period of a usage report unless it is specified, a week
+const DefaultUsageReportPeriod = 7 * 24 * time.Hour
+
+var usageReportLog = log.WithFields(log.Fields{"app": "usage-report"})
+
+// UsageReportNotice creates the usage report notice, the webhooks receive the JSON report in the data
+// and the email has the CSV report in the message
+func UsageReportNotice(report metrics.UsageReport) (notification.Notice, error) {
+	data, err := json.Marshal(report)
+	if err != nil {
+		return notification.Notice{}, err
+	}
+	csv, err := report.CSV()
+	if err != nil {
+		return notification.Notice{}, err
+	}
+	return notification.Notice{
+		Tenant: report.Tenant,
+		Kind:   notification.UsageReport,
+		Subject: fmt.Sprintf("tenant %s usage report from %s to %s", report.Tenant,
+			report.From.UTC().Format("2006-01-02"), report.To.UTC().Format("2006-01-02")),
+		Message:   string(csv),
+		CreatedAt: report.GeneratedAt,
+		Data:      data,
+	}, nil
+}
+
+// SendUsageReport delivers the usage report to the tenant contacts. It returns false if the tenant
+// has not opted in the usage report or has no contacts.
+func (s *TenantPolicyHandler) SendUsageReport(report metrics.UsageReport) (bool, error) {
+	notice, err := UsageReportNotice(report)
+	if err != nil {
+		return false, err
+	}
+	return s.NotifyTenantNotice(notice), nil
+}
+
+// SendUsageReports delivers the usage report of the period ending now to every tenant opting in it,
+// and returns the number of the delivered reports
+func (s *TenantPolicyHandler) SendUsageReports(period time.Duration, now time.Time) int {
+	sent := 0
+	for _, t := range s.ListTenants() {
+		if !t.Notifications.WantsNotice(notification.UsageReport) {
+			continue
+		}
+		report := metrics.GetUsageReport(t.Name, now.Add(-period), now)
+		if ok, err := s.SendUsageReport(report); err != nil {
+			usageReportLog.Errorf("tenant %s usage report error %v", t.Name, err)
+		} else if ok {
+			sent++
+		}
+	}
+	return sent
+}
+
+// UsageReportScheduler delivers the usage reports every UsageReportIntervalHours, the report period is the interval.
+// It is disabled unless the interval is set, 168 for a weekly report.
+func UsageReportScheduler() {
+	interval := time.Duration(util.GetEnvInt("UsageReportIntervalHours", 0)) * time.Hour
+	if interval <= 0 {
+		return
+	}
+	usageReportLog.Infof("send tenant usage reports every %v", interval)
+	go func() {
+		ticker := time.NewTicker(interval)
+		for {
+			select {
+			case <-ticker.C:
+				sent := TenantManager.SendUsageReports(interval, time.Now())
+				usageReportLog.Infof("sent %d tenant usage reports", sent)
+			}
+		}
+	}()
+}
@@ -0,0 +1,313 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package route
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/datastax/burnell/src/audit"
+	"github.com/datastax/burnell/src/util"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// adminVersionRule is the upstream admin API version of a resource since a Pulsar version, and the version before it
+type adminVersionRule struct {
+	resource string
+	version  string
+	since    [3]int
+	before   string
+}
+
+// the functions, sources and sinks moved to the v3 admin API in Pulsar 2.3.0
+var adminVersionRules = []adminVersionRule{
+	{resource: "functions", version: "v3", since: [3]int{2, 3, 0}, before: "v2"},
+	{resource: "sources", version: "v3", since: [3]int{2, 3, 0}, before: "v2"},
+	{resource: "sinks", version: "v3", since: [3]int{2, 3, 0}, before: "v2"},
+}
+
+// the versioned admin API path, /admin/{version}/{resource}/...
+var (
+	adminVersionPath    = regexp.MustCompile(`^/admin/(v[0-9]+)/([^/]+)(/.*)?$`)
+	adminVersionPattern = regexp.MustCompile(`^v[0-9]+$`)
+)
+
+// AdminAPIVersionStatus is the detected broker version of a cluster and the upstream admin API version of the resources
+type AdminAPIVersionStatus struct {
+	Cluster       string            `json:"cluster"`
+	BrokerVersion string            `json:"brokerVersion,omitempty"`
+	DetectedAt    time.Time         `json:"detectedAt,omitempty"`
+	Versions      map[string]string `json:"versions"`
+	Error         string            `json:"error,omitempty"`
+}
+
+var (
+	adminAPIVersions       = map[string]*AdminAPIVersionStatus{}
+	adminVersionOverrides  = map[string]string{}
+	adminAPIVersionsLock   = sync.RWMutex{}
+	adminVersionDetectOnce = sync.Once{}
+
+	adminVersionRefresh = time.Duration(util.GetEnvInt("AdminAPIVersionRefreshSeconds", 300)) * time.Second
+
+	adminPathTranslations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "burnell",
+		Subsystem: "admin_api",
+		Name:      "translations_total",
+		Help:      "The number of the proxied admin calls translated to the upstream admin API version.",
+	}, []string{"resource", "from", "to"})
+)
+
+func init() {
+	prometheus.MustRegister(adminPathTranslations)
+}
+
+// InitAdminAPIVersions sets the AdminAPIVersions in the configuration, and detects the broker version of
+// the fronted clusters at the start and every AdminAPIVersionRefreshSeconds to follow the Pulsar upgrades
+func InitAdminAPIVersions() error {
+	if err := SetAdminAPIVersions(util.GetConfig().AdminAPIVersions); err != nil {
+		return err
+	}
+	adminVersionDetectOnce.Do(func() {
+		go func() {
+			for {
+				DetectAdminAPIVersions()
+				if adminVersionRefresh <= 0 {
+					return
+				}
+				time.Sleep(adminVersionRefresh)
+			}
+		}()
+	})
+	return nil
+}
+
+// SetAdminAPIVersions parses a comma separated list of {resource}={version} overriding the detected versions
+func SetAdminAPIVersions(list string) error {
+	overrides := map[string]string{}
+	for _, v := range strings.Split(list, ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		parts := strings.SplitN(v, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || !adminVersionPattern.MatchString(parts[1]) {
+			return fmt.Errorf("admin api version %s is not {resource}={version}, i.e. functions=v3", v)
+		}
+		overrides[parts[0]] = parts[1]
+	}
+	adminAPIVersionsLock.Lock()
+	adminVersionOverrides = overrides
+	adminAPIVersionsLock.Unlock()
+	return nil
+}
+
+// parsePulsarVersion returns the major, minor and patch numbers of a Pulsar version such as 2.7.2.1 or 3.0.0-SNAPSHOT
+func parsePulsarVersion(version string) ([3]int, error) {
+	v := [3]int{}
+	parts := strings.SplitN(strings.SplitN(version, "-", 2)[0], ".", 4)
+	if len(parts) < 2 {
+		return v, fmt.Errorf("invalid Pulsar version %s", version)
+	}
+	for i := 0; i < len(parts) && i < 3; i++ {
+		n, err := strconv.Atoi(parts[i])
+		if err != nil {
+			return v, fmt.Errorf("invalid Pulsar version %s", version)
+		}
+		v[i] = n
+	}
+	return v, nil
+}
+
+func versionBefore(v, since [3]int) bool {
+	for i := range v {
+		if v[i] != since[i] {
+			return v[i] < since[i]
+		}
+	}
+	return false
+}
+
+// AdminVersionsOf returns the upstream admin API version of the resources for a Pulsar version
+func AdminVersionsOf(brokerVersion string) (map[string]string, error) {
+	v, err := parsePulsarVersion(brokerVersion)
+	if err != nil {
+		return nil, err
+	}
+	versions := make(map[string]string, len(adminVersionRules))
+	for _, rule := range adminVersionRules {
+		if versionBefore(v, rule.since) {
+			versions[rule.resource] = rule.before
+		} else {
+			versions[rule.resource] = rule.version
+		}
+	}
+	return versions, nil
+}
+
+// DetectAdminAPIVersion gets the broker version of the cluster at the admin URL
+func DetectAdminAPIVersion(cluster string, adminURL *url.URL) AdminAPIVersionStatus {
+	status := AdminAPIVersionStatus{Cluster: cluster, DetectedAt: time.Now(), Versions: map[string]string{}}
+	req, err := http.NewRequest(http.MethodGet, util.SingleJoinSlash(adminURL.String(), "admin/v2/brokers/version"), nil)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	req.Header.Set("Authorization", "Bearer "+util.PulsarAuthToken())
+	client := &http.Client{Timeout: 10 * time.Second}
+	if transport := injectUpstreamCredential(cluster, req); transport != nil {
+		client.Transport = transport
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	if resp.StatusCode != http.StatusOK {
+		status.Error = fmt.Sprintf("broker version status code %d", resp.StatusCode)
+		return status
+	}
+	status.BrokerVersion = strings.Trim(strings.TrimSpace(string(body)), `"`)
+	if status.Versions, err = AdminVersionsOf(status.BrokerVersion); err != nil {
+		status.Versions = map[string]string{}
+		status.Error = err.Error()
+	}
+	return status
+}
+
+// DetectAdminAPIVersions detects the admin API versions of all fronted clusters, a cluster
+// failed to detect keeps the last detected versions
+func DetectAdminAPIVersions() {
+	clusters := FrontedClusters()
+	if len(clusters) == 0 {
+		clusters = []string{util.Config.ClusterName}
+	}
+	for _, cluster := range clusters {
+		adminURL, _ := clusterAdminURL(cluster)
+		if adminURL == nil {
+			adminURL = util.BrokerProxyURL
+		}
+		if adminURL == nil {
+			continue
+		}
+		status := DetectAdminAPIVersion(cluster, adminURL)
+		adminAPIVersionsLock.Lock()
+		if last, ok := adminAPIVersions[cluster]; ok && status.Error != "" && last.BrokerVersion != "" {
+			last.Error = status.Error
+		} else {
+			adminAPIVersions[cluster] = &status
+		}
+		adminAPIVersionsLock.Unlock()
+		if status.Error != "" {
+			log.Errorf("admin api version detection of cluster %s error %s", cluster, status.Error)
+		} else {
+			log.Infof("cluster %s broker version %s admin api %v", cluster, status.BrokerVersion, status.Versions)
+		}
+	}
+}
+
+// upstreamAdminVersion returns the admin API version of the resource on the cluster, or empty if it is unknown
+func upstreamAdminVersion(cluster, resource string) string {
+	adminAPIVersionsLock.RLock()
+	defer adminAPIVersionsLock.RUnlock()
+	if v, ok := adminVersionOverrides[resource]; ok {
+		return v
+	}
+	if status, ok := adminAPIVersions[util.AssignString(cluster, util.Config.ClusterName)]; ok {
+		return status.Versions[resource]
+	}
+	return ""
+}
+
+// negotiateAdminVersion translates the admin API version in the upstream request path to the version of the cluster,
+// so that the clients call the same path across the Pulsar upgrades
+func negotiateAdminVersion(cluster string, newRequest *http.Request) {
+	m := adminVersionPath.FindStringSubmatch(newRequest.URL.Path)
+	if m == nil {
+		return
+	}
+	version := upstreamAdminVersion(cluster, m[2])
+	if version == "" || version == m[1] {
+		return
+	}
+	newRequest.URL.Path = "/admin/" + version + "/" + m[2] + m[3]
+	if newRequest.URL.RawPath != "" {
+		newRequest.URL.RawPath = strings.Replace(newRequest.URL.RawPath, "/admin/"+m[1]+"/", "/admin/"+version+"/", 1)
+	}
+	adminPathTranslations.WithLabelValues(m[2], m[1], version).Inc()
+}
+
+// AdminAPIVersions returns the detected admin API versions of the clusters, detected again first if it is forced
+func AdminAPIVersions(force bool) []AdminAPIVersionStatus {
+	if force {
+		DetectAdminAPIVersions()
+	}
+	adminAPIVersionsLock.RLock()
+	defer adminAPIVersionsLock.RUnlock()
+	statuses := []AdminAPIVersionStatus{}
+	for _, s := range adminAPIVersions {
+		status := *s
+		status.Versions = make(map[string]string, len(s.Versions))
+		for k, v := range s.Versions {
+			status.Versions[k] = v
+		}
+		for k, v := range adminVersionOverrides {
+			status.Versions[k] = v
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Cluster < statuses[j].Cluster })
+	return statuses
+}
+
+// AdminAPIVersionsHandler returns the admin API versions of the clusters, POST detects them again
+func AdminAPIVersionsHandler(w http.ResponseWriter, r *http.Request) {
+	detect := r.Method == http.MethodPost
+	data, err := json.Marshal(AdminAPIVersions(detect))
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
+		return
+	}
+	if detect {
+		audit.Record(audit.Event{
+			Subject:  r.Header.Get(injectedSubs),
+			Action:   "detect-admin-api-versions",
+			Resource: r.URL.Path,
+			Status:   http.StatusOK,
+		})
+	}
+	w.Write(data)
+}
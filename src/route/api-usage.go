@@ -0,0 +1,76 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package route
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/datastax/burnell/src/metrics"
+	"github.com/datastax/burnell/src/util"
+	"github.com/gorilla/mux"
+)
+
+// MeterAPICalls is the middleware to count the admitted API calls per tenant, endpoint and UTC day
+func MeterAPICalls(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if tenant := mux.Vars(r)["tenant"]; tenant != "" {
+			metrics.RecordAPICall(tenant, apiEndpoint(r), time.Now())
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// apiEndpoint is the method and the route name, or the path template of an unnamed route
+func apiEndpoint(r *http.Request) string {
+	route := currentRoute(r)
+	if route == nil {
+		return r.Method
+	}
+	name := route.GetName()
+	if name == "" {
+		name, _ = route.GetPathTemplate()
+	}
+	return r.Method + " " + name
+}
+
+// TenantAPIUsageHandler reports the API calls of a tenant per endpoint and day, the query parameters from and to
+// are YYYY-MM-DD, default to the first day of the current month and today
+func TenantAPIUsageHandler(w http.ResponseWriter, r *http.Request) {
+	tenant := mux.Vars(r)["tenant"]
+	now := time.Now().UTC()
+	params := r.URL.Query()
+	from := queryParamString(params, "from", now.Format("2006-01")+"-01")
+	to := queryParamString(params, "to", now.Format("2006-01-02"))
+	usage, err := metrics.GetTenantAPIUsage(tenant, from, to)
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusBadRequest)
+		return
+	}
+	data, err := json.Marshal(usage)
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
+		return
+	}
+	w.Write(data)
+}
@@ -0,0 +1,107 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package route
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/datastax/burnell/src/icrypto"
+	"github.com/datastax/burnell/src/policy"
+	"github.com/datastax/burnell/src/util"
+	"github.com/dgrijalva/jwt-go"
+)
+
+// the max size of a declarative configuration document
+var applyMaxBytes = int64(util.GetEnvInt("ApplyMaxBytes", 4*1024*1024))
+
+// declaredTokenClaims is the validated expiry and signing method of a declared token
+type declaredTokenClaims struct {
+	exp time.Duration
+	alg jwt.SigningMethod
+}
+
+// ApplyHandler applies a declarative YAML or JSON document of the desired tenants, only computes the changes with ?dryRun=true
+func ApplyHandler(w http.ResponseWriter, r *http.Request) {
+	dryRun := r.URL.Query().Get("dryRun") == "true"
+	auditedProxy("apply-declarative-config", fmt.Sprintf("dry run %t", dryRun), applyHandler, w, r)
+}
+
+func applyHandler(w http.ResponseWriter, r *http.Request) {
+	dryRun := r.URL.Query().Get("dryRun") == "true"
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, applyMaxBytes))
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusRequestEntityTooLarge)
+		return
+	}
+	cfg, err := policy.ParseDeclarativeConfig(data)
+	if err != nil {
+		responseTenantPlanError(err, w, http.StatusUnprocessableEntity)
+		return
+	}
+
+	// validate every token before any change is applied
+	claims := map[string]declaredTokenClaims{}
+	for _, t := range cfg.Tenants {
+		for _, token := range t.Tokens {
+			if !util.IsPulsarJWTEnabled() {
+				util.ResponseErrorJSON(fmt.Errorf("token issuance is not enabled"), w, http.StatusNotImplemented)
+				return
+			}
+			exp, alg, err := icrypto.ValidateClaims(util.AssignString(token.Exp, "0m"), util.AssignString(token.Alg, "rs256"))
+			if err != nil {
+				util.ResponseErrorJSON(fmt.Errorf("token %s %v", token.Subject, err), w, http.StatusUnprocessableEntity)
+				return
+			}
+			if !util.JWTAuth.IsAllowed(alg) {
+				util.ResponseErrorJSON(fmt.Errorf("token %s signing method %s is not allowed", token.Subject, alg.Alg()), w, http.StatusUnprocessableEntity)
+				return
+			}
+			claims[t.Name+"/"+token.Subject] = declaredTokenClaims{exp: exp, alg: alg}
+		}
+	}
+
+	result := policy.TenantManager.ApplyDeclarativeConfig(cfg, dryRun)
+	for i, c := range result.Changes {
+		if c.Kind != policy.TokenChange || dryRun {
+			continue
+		}
+		claim := claims[c.Tenant+"/"+c.Resource]
+		if token, err := util.JWTAuth.GenerateToken(c.Resource, claim.exp, claim.alg); err != nil {
+			result.Changes[i].Error = "failed to generate token"
+			result.Failed++
+		} else {
+			result.Changes[i].Token = token
+			result.Changes[i].Applied = true
+		}
+	}
+
+	data, err = json.Marshal(result)
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
+		return
+	}
+	w.Write(data)
+}
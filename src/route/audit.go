@@ -0,0 +1,119 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package route
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/datastax/burnell/src/audit"
+	"github.com/datastax/burnell/src/util"
+	"github.com/gorilla/mux"
+)
+
+// statusRecorder captures the response status code
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	s.status = code
+	s.ResponseWriter.WriteHeader(code)
+}
+
+// auditedProxy proxies the request and records the action on the resource in the audit with the response status code.
+func auditedProxy(action, detail string, proxy http.HandlerFunc, w http.ResponseWriter, r *http.Request) {
+	recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	proxy(recorder, r)
+	audit.Record(audit.Event{
+		Subject:  r.Header.Get(injectedSubs),
+		Tenant:   mux.Vars(r)["tenant"],
+		Action:   action,
+		Resource: r.URL.Path,
+		Detail:   detail,
+		Status:   recorder.status,
+	})
+}
+
+// auditAuthFailure records a rejected authentication or authorization in the audit
+func auditAuthFailure(r *http.Request, subject, reason string) {
+	audit.Record(audit.Event{
+		Subject:  subject,
+		Tenant:   mux.Vars(r)["tenant"],
+		Action:   "auth-failure",
+		Resource: r.URL.Path,
+		Detail:   reason,
+		Status:   http.StatusUnauthorized,
+	})
+}
+
+// TenantAuditHandler returns the recent audit events of the tenant
+func TenantAuditHandler(w http.ResponseWriter, r *http.Request) {
+	tenant := mux.Vars(r)["tenant"]
+	limit := queryParamInt(r.URL.Query(), "limit", 100)
+	data, err := json.Marshal(audit.Events(tenant, limit))
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
+		return
+	}
+	w.Write(data)
+}
+
+// AuditArchiveHandler returns the audit archive status, or archives the pending audit events now with POST
+func AuditArchiveHandler(w http.ResponseWriter, r *http.Request) {
+	archiver, err := audit.ActiveArchiver()
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusNotImplemented)
+		return
+	}
+	var v interface{} = archiver.Status()
+	if r.Method == http.MethodPost {
+		objects, err := archiver.Flush()
+		if err != nil {
+			util.ResponseErrorJSON(err, w, http.StatusBadGateway)
+			return
+		}
+		v = objects
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
+		return
+	}
+	w.Write(data)
+}
+
+// AuditArchiveVerifyHandler verifies the recent archived objects exist in the object storage
+func AuditArchiveVerifyHandler(w http.ResponseWriter, r *http.Request) {
+	archiver, err := audit.ActiveArchiver()
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusNotImplemented)
+		return
+	}
+	data, err := json.Marshal(archiver.Verify(queryParamInt(r.URL.Query(), "limit", 10)))
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
+		return
+	}
+	w.Write(data)
+}
@@ -0,0 +1,77 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package route
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path"
+	"strconv"
+
+	"github.com/datastax/burnell/src/policy"
+	"github.com/datastax/burnell/src/util"
+	"github.com/gorilla/mux"
+)
+
+// BundleOperationHandler splits or unloads a namespace bundle once the bundle is validated as a current bundle of the namespace,
+// the operation is recorded in the audit
+func BundleOperationHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	namespace := vars["tenant"] + "/" + vars["namespace"]
+	bundle := vars["bundle"]
+	split := path.Base(r.URL.Path) == "split"
+	query := r.URL.Query()
+	algorithm := query.Get("splitAlgorithmName")
+	if unload := query.Get("unload"); split && unload != "" {
+		if _, err := strconv.ParseBool(unload); err != nil {
+			util.ResponseErrorJSON(errors.New("unload query parameter requires true or false"), w, http.StatusUnprocessableEntity)
+			return
+		}
+	}
+	if status, err := policy.ValidateBundleOperation(namespace, bundle, split, algorithm); err != nil {
+		util.ResponseErrorJSON(err, w, status)
+		return
+	}
+	action, detail := "unload-bundle", namespace+"/"+bundle
+	if split {
+		action = "split-bundle"
+		detail = detail + " " + util.AssignString(algorithm, policy.RangeEquallyDivide)
+	}
+	auditedProxy(action, detail, DirectBrokerProxyHandler, w, r)
+}
+
+// BundleAdvisorHandler returns the bundles advised to split by their load in the federated metrics,
+// optionally under the tenant query parameter
+func BundleAdvisorHandler(w http.ResponseWriter, r *http.Request) {
+	advices, err := policy.GetBundleSplitAdvices(r.URL.Query().Get("tenant"))
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusServiceUnavailable)
+		return
+	}
+	data, err := json.Marshal(advices)
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
+		return
+	}
+	w.Write(data)
+}
@@ -0,0 +1,317 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package route
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/datastax/burnell/src/audit"
+	"github.com/datastax/burnell/src/util"
+)
+
+// the min interval to check the credential files for a rotation
+var credentialCheckInterval = time.Duration(util.GetEnvInt("UpstreamCredentialCheckSeconds", 10)) * time.Second
+
+// UpstreamCredentialStatus is the loaded credential of a cluster without the secrets
+type UpstreamCredentialStatus struct {
+	Cluster string `json:"cluster"`
+	// TokenFingerprint is the first 8 bytes of the token SHA-256 in hex
+	TokenFingerprint string    `json:"tokenFingerprint,omitempty"`
+	TokenLoadedAt    time.Time `json:"tokenLoadedAt,omitempty"`
+	CertSubject      string    `json:"certSubject,omitempty"`
+	CertNotAfter     time.Time `json:"certNotAfter,omitempty"`
+	CertLoadedAt     time.Time `json:"certLoadedAt,omitempty"`
+	Rotations        int       `json:"rotations"`
+	LastError        string    `json:"lastError,omitempty"`
+}
+
+// upstreamCredential is the credential of a cluster, the token and certificate files are reloaded once they change
+type upstreamCredential struct {
+	util.ClusterCredential
+	transport *http.Transport
+
+	lock         sync.Mutex
+	checkedAt    time.Time
+	token        string
+	tokenModTime time.Time
+	cert         *tls.Certificate
+	certModTime  time.Time
+	status       UpstreamCredentialStatus
+}
+
+var (
+	upstreamCredentials     = map[string]*upstreamCredential{}
+	upstreamCredentialsLock = sync.RWMutex{}
+)
+
+// InitUpstreamCredentials sets the ClusterCredentials in the configuration
+func InitUpstreamCredentials() error {
+	return SetUpstreamCredentials(util.GetConfig().ClusterCredentials)
+}
+
+// SetUpstreamCredentials loads the credentials, a cluster without a credential uses PulsarToken
+func SetUpstreamCredentials(credentials []util.ClusterCredential) error {
+	loaded := make(map[string]*upstreamCredential, len(credentials))
+	for _, c := range credentials {
+		if c.Cluster == "" {
+			return fmt.Errorf("cluster credential requires a cluster")
+		}
+		if loaded[c.Cluster] != nil {
+			return fmt.Errorf("duplicate cluster %s credential", c.Cluster)
+		}
+		if c.Token != "" && c.TokenFile != "" {
+			return fmt.Errorf("cluster %s credential has both token and token file", c.Cluster)
+		}
+		if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+			return fmt.Errorf("cluster %s credential requires both TLS certificate and key files", c.Cluster)
+		}
+		credential := &upstreamCredential{ClusterCredential: c, status: UpstreamCredentialStatus{Cluster: c.Cluster}}
+		if err := credential.reload(true); err != nil {
+			return fmt.Errorf("cluster %s credential: %v", c.Cluster, err)
+		}
+		if c.TLSCertFile != "" || c.TrustStore != "" {
+			tlsConfig := &tls.Config{}
+			if c.TLSCertFile != "" {
+				tlsConfig.GetClientCertificate = credential.clientCertificate
+			}
+			if c.TrustStore != "" {
+				pem, err := ioutil.ReadFile(c.TrustStore)
+				if err != nil {
+					return fmt.Errorf("cluster %s trust store: %v", c.Cluster, err)
+				}
+				pool := x509.NewCertPool()
+				if !pool.AppendCertsFromPEM(pem) {
+					return fmt.Errorf("cluster %s trust store has no PEM certificate", c.Cluster)
+				}
+				tlsConfig.RootCAs = pool
+			}
+			credential.transport = &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: tlsConfig}
+		}
+		loaded[c.Cluster] = credential
+	}
+
+	upstreamCredentialsLock.Lock()
+	defer upstreamCredentialsLock.Unlock()
+	for _, c := range upstreamCredentials {
+		if c.transport != nil {
+			c.transport.CloseIdleConnections()
+		}
+	}
+	upstreamCredentials = loaded
+	return nil
+}
+
+func clusterCredential(cluster string) *upstreamCredential {
+	upstreamCredentialsLock.RLock()
+	defer upstreamCredentialsLock.RUnlock()
+	return upstreamCredentials[cluster]
+}
+
+func fileModTime(file string) (time.Time, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+// reload reads the token and the certificate files if they are changed since the last load, or if it is forced.
+// A failed reload keeps the last loaded credential.
+func (c *upstreamCredential) reload(force bool) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := time.Now()
+	if !force && now.Sub(c.checkedAt) < credentialCheckInterval {
+		return nil
+	}
+	c.checkedAt = now
+	err := c.reloadToken(force, now)
+	if certErr := c.reloadCert(force, now); certErr != nil {
+		err = certErr
+	}
+	c.status.LastError = ""
+	if err != nil {
+		c.status.LastError = err.Error()
+	}
+	return err
+}
+
+func (c *upstreamCredential) reloadToken(force bool, now time.Time) error {
+	if c.TokenFile == "" {
+		if c.token == "" && c.Token != "" {
+			c.setToken(c.Token, now)
+		}
+		return nil
+	}
+	modTime, err := fileModTime(c.TokenFile)
+	if err != nil {
+		return err
+	}
+	if !force && modTime.Equal(c.tokenModTime) {
+		return nil
+	}
+	data, err := ioutil.ReadFile(c.TokenFile)
+	if err != nil {
+		return err
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return fmt.Errorf("token file %s is empty", c.TokenFile)
+	}
+	if c.token != "" && token != c.token {
+		c.status.Rotations++
+	}
+	c.setToken(token, now)
+	c.tokenModTime = modTime
+	return nil
+}
+
+func (c *upstreamCredential) setToken(token string, now time.Time) {
+	c.token = token
+	sum := sha256.Sum256([]byte(token))
+	c.status.TokenFingerprint = hex.EncodeToString(sum[:8])
+	c.status.TokenLoadedAt = now
+}
+
+func (c *upstreamCredential) reloadCert(force bool, now time.Time) error {
+	if c.TLSCertFile == "" {
+		return nil
+	}
+	modTime, err := fileModTime(c.TLSCertFile)
+	if err != nil {
+		return err
+	}
+	if !force && modTime.Equal(c.certModTime) {
+		return nil
+	}
+	cert, err := tls.LoadX509KeyPair(c.TLSCertFile, c.TLSKeyFile)
+	if err != nil {
+		return err
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return err
+	}
+	if c.cert != nil {
+		c.status.Rotations++
+	}
+	c.cert = &cert
+	c.certModTime = modTime
+	c.status.CertSubject = cert.Leaf.Subject.String()
+	c.status.CertNotAfter = cert.Leaf.NotAfter
+	c.status.CertLoadedAt = now
+	return nil
+}
+
+// bearerToken returns the token after a rotation check, empty if the credential has no token
+func (c *upstreamCredential) bearerToken() string {
+	c.reload(false)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.token
+}
+
+func (c *upstreamCredential) clientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	c.reload(false)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.cert == nil {
+		return &tls.Certificate{}, nil
+	}
+	return c.cert, nil
+}
+
+func (c *upstreamCredential) currentStatus() UpstreamCredentialStatus {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.status
+}
+
+// injectUpstreamCredential sets the token of the cluster credential on the upstream request,
+// and returns the transport of the TLS identity, nil for the default transport
+func injectUpstreamCredential(cluster string, newRequest *http.Request) http.RoundTripper {
+	c := clusterCredential(cluster)
+	if c == nil {
+		return nil
+	}
+	if token := c.bearerToken(); token != "" {
+		newRequest.Header.Set("Authorization", "Bearer "+token)
+	}
+	if c.transport == nil {
+		return nil
+	}
+	return c.transport
+}
+
+// UpstreamCredentials returns the status of the cluster credentials, reloaded first if it is forced
+func UpstreamCredentials(force bool) []UpstreamCredentialStatus {
+	upstreamCredentialsLock.RLock()
+	defer upstreamCredentialsLock.RUnlock()
+	statuses := []UpstreamCredentialStatus{}
+	for _, cluster := range sortedCredentialClusters() {
+		c := upstreamCredentials[cluster]
+		if force {
+			c.reload(true)
+		}
+		statuses = append(statuses, c.currentStatus())
+	}
+	return statuses
+}
+
+func sortedCredentialClusters() []string {
+	clusters := make([]string, 0, len(upstreamCredentials))
+	for cluster := range upstreamCredentials {
+		clusters = append(clusters, cluster)
+	}
+	sort.Strings(clusters)
+	return clusters
+}
+
+// UpstreamCredentialsHandler returns the cluster credentials status, POST reloads the credential files now
+func UpstreamCredentialsHandler(w http.ResponseWriter, r *http.Request) {
+	reload := r.Method == http.MethodPost
+	data, err := json.Marshal(UpstreamCredentials(reload))
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
+		return
+	}
+	if reload {
+		audit.Record(audit.Event{
+			Subject:  r.Header.Get(injectedSubs),
+			Action:   "reload-upstream-credentials",
+			Resource: r.URL.Path,
+			Status:   http.StatusOK,
+		})
+	}
+	w.Write(data)
+}
@@ -0,0 +1,184 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package route
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/apex/log"
+	"github.com/datastax/burnell/src/metrics"
+	"github.com/datastax/burnell/src/policy"
+	"github.com/datastax/burnell/src/util"
+)
+
+// the broker and cluster health status
+const (
+	BrokerUp          = "up"
+	BrokerDown        = "down"
+	BrokerUnreachable = "unreachable"
+	ClusterHealthy    = "healthy"
+	ClusterDegraded   = "degraded"
+	ClusterDown       = "down"
+)
+
+const loadReportRoute = "/admin/v2/broker-stats/load-report"
+
+// BrokerLoad is the resource usage percentages in the broker load report
+type BrokerLoad struct {
+	CPU          float64 `json:"cpu"`
+	Memory       float64 `json:"memory"`
+	DirectMemory float64 `json:"directMemory"`
+	BandwidthIn  float64 `json:"bandwidthIn"`
+	BandwidthOut float64 `json:"bandwidthOut"`
+}
+
+// BrokerHealth is the health of a broker
+type BrokerHealth struct {
+	Broker string `json:"broker"`
+	// Status is up if the broker is registered and replies the load report, unreachable if registered but
+	// not replying, and down if it has the federated metrics but is not registered in the cluster
+	Status  string                 `json:"status"`
+	Load    *BrokerLoad            `json:"load,omitempty"`
+	Metrics *metrics.BrokerMetrics `json:"metrics,omitempty"`
+}
+
+// ClusterHealth is the broker level health summary of the cluster
+type ClusterHealth struct {
+	Cluster         string         `json:"cluster"`
+	Status          string         `json:"status"`
+	Brokers         int            `json:"brokers"`
+	BrokersUp       int            `json:"brokersUp"`
+	StorageSize     float64        `json:"storageSize"`
+	UnackedMessages float64        `json:"unackedMessages"`
+	BacklogMessages float64        `json:"backlogMessages"`
+	MetricsError    string         `json:"metricsError,omitempty"`
+	BrokerHealth    []BrokerHealth `json:"brokerHealth"`
+}
+
+// brokerPodName is the first DNS label of a broker host:port, i.e. the pod name
+func brokerPodName(broker string) string {
+	host := strings.TrimPrefix(strings.TrimPrefix(broker, "http://"), "https://")
+	if i := strings.IndexAny(host, ".:"); i > 0 {
+		return host[:i]
+	}
+	return host
+}
+
+// usagePercent is the usage/limit percentage of a resource in the load report
+func usagePercent(report map[string]interface{}, resource string) float64 {
+	usage, ok := report[resource].(map[string]interface{})
+	if !ok {
+		return 0
+	}
+	used, _ := usage["usage"].(float64)
+	limit, _ := usage["limit"].(float64)
+	if limit <= 0 {
+		return 0
+	}
+	return used / limit * 100
+}
+
+// BuildClusterHealth combines the registered brokers, their load reports and the federated broker metrics
+func BuildClusterHealth(registered []string, loads []policy.BrokerStats, brokerMetrics map[string]*metrics.BrokerMetrics) ClusterHealth {
+	reports := make(map[string]map[string]interface{})
+	for _, l := range loads {
+		if report, ok := l.Data.(map[string]interface{}); ok {
+			reports[l.Broker] = report
+		}
+	}
+
+	health := ClusterHealth{Cluster: util.Config.ClusterName, BrokerHealth: []BrokerHealth{}}
+	matched := make(map[string]bool)
+	for _, broker := range registered {
+		b := BrokerHealth{Broker: broker, Status: BrokerUnreachable}
+		if report, ok := reports[broker]; ok {
+			b.Status = BrokerUp
+			b.Load = &BrokerLoad{
+				CPU:          usagePercent(report, "cpu"),
+				Memory:       usagePercent(report, "memory"),
+				DirectMemory: usagePercent(report, "directMemory"),
+				BandwidthIn:  usagePercent(report, "bandwidthIn"),
+				BandwidthOut: usagePercent(report, "bandwidthOut"),
+			}
+			health.BrokersUp++
+		}
+		pod := brokerPodName(broker)
+		if m, ok := brokerMetrics[pod]; ok {
+			b.Metrics = m
+			matched[pod] = true
+		}
+		health.BrokerHealth = append(health.BrokerHealth, b)
+	}
+	for pod, m := range brokerMetrics {
+		if !matched[pod] {
+			health.BrokerHealth = append(health.BrokerHealth, BrokerHealth{Broker: pod, Status: BrokerDown, Metrics: m})
+		}
+	}
+	sort.Slice(health.BrokerHealth, func(i, j int) bool { return health.BrokerHealth[i].Broker < health.BrokerHealth[j].Broker })
+
+	for _, b := range health.BrokerHealth {
+		if b.Metrics != nil {
+			health.StorageSize += b.Metrics.StorageSize
+			health.UnackedMessages += b.Metrics.UnackedMessages
+			health.BacklogMessages += b.Metrics.BacklogMessages
+		}
+	}
+	health.Brokers = len(health.BrokerHealth)
+	switch {
+	case health.BrokersUp == 0:
+		health.Status = ClusterDown
+	case health.BrokersUp < health.Brokers:
+		health.Status = ClusterDegraded
+	default:
+		health.Status = ClusterHealthy
+	}
+	return health
+}
+
+// ClusterHealthHandler returns the broker level health of the cluster
+func ClusterHealthHandler(w http.ResponseWriter, r *http.Request) {
+	registered := policy.GetBrokers()
+	var loads []policy.BrokerStats
+	if len(registered) > 0 {
+		stats, _, err := policy.AggregateBrokersStats(r.Context(), loadReportRoute, 0, 0)
+		if err != nil {
+			log.Errorf("cluster health broker load reports error %v", err)
+		}
+		loads = stats.Data
+	}
+	brokerMetrics, err := metrics.GetBrokerMetrics()
+	health := BuildClusterHealth(registered, loads, brokerMetrics)
+	if err != nil {
+		health.MetricsError = err.Error()
+	}
+
+	data, err := json.Marshal(health)
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
@@ -0,0 +1,194 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package route
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/datastax/burnell/src/policy"
+	"github.com/datastax/burnell/src/util"
+)
+
+// ClusterHeader is the request header to target a Pulsar cluster fronted by burnell, default to ClusterName
+const ClusterHeader = "X-Burnell-Cluster"
+
+var (
+	// clusterAdminURLs are the admin URLs of the clusters other than ClusterName at BrokerProxyURL
+	clusterAdminURLs     = map[string]*url.URL{}
+	clusterAdminURLsLock = sync.RWMutex{}
+
+	clusterPath     = regexp.MustCompile(`^/admin/v2/clusters/([^/]+)`)
+	replicationPath = regexp.MustCompile(`^/admin/v2/namespaces/[^/]+/[^/]+/replication$`)
+	tenantPath      = regexp.MustCompile(`^/admin/v2/tenants/[^/]+$`)
+)
+
+// InitClusterRouting sets the admin URLs of the clusters in ClusterAdminURLs
+func InitClusterRouting() error {
+	return SetClusterAdminURLs(util.GetConfig().ClusterAdminURLs)
+}
+
+// SetClusterAdminURLs parses a comma separated list of {cluster}={admin URL}
+func SetClusterAdminURLs(list string) error {
+	urls := map[string]*url.URL{}
+	for _, v := range strings.Split(list, ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		parts := strings.SplitN(v, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return fmt.Errorf("cluster admin url %s is not {cluster}={url}", v)
+		}
+		u, err := url.ParseRequestURI(parts[1])
+		if err != nil {
+			return fmt.Errorf("cluster %s admin url: %v", parts[0], err)
+		}
+		urls[parts[0]] = u
+	}
+	clusterAdminURLsLock.Lock()
+	clusterAdminURLs = urls
+	clusterAdminURLsLock.Unlock()
+	return nil
+}
+
+// FrontedClusters returns the sorted names of the clusters fronted by burnell
+func FrontedClusters() []string {
+	clusterAdminURLsLock.RLock()
+	defer clusterAdminURLsLock.RUnlock()
+	clusters := []string{}
+	if util.Config.ClusterName != "" {
+		clusters = append(clusters, util.Config.ClusterName)
+	}
+	for c := range clusterAdminURLs {
+		if c != util.Config.ClusterName {
+			clusters = append(clusters, c)
+		}
+	}
+	sort.Strings(clusters)
+	return clusters
+}
+
+// targetCluster is the cluster in ClusterHeader, default to ClusterName
+func targetCluster(r *http.Request) string {
+	return util.AssignString(r.Header.Get(ClusterHeader), util.Config.ClusterName)
+}
+
+// clusterAdminURL returns the admin URL of the cluster, nil for ClusterName at BrokerProxyURL
+func clusterAdminURL(cluster string) (*url.URL, bool) {
+	if cluster == util.Config.ClusterName {
+		return nil, true
+	}
+	clusterAdminURLsLock.RLock()
+	defer clusterAdminURLsLock.RUnlock()
+	u, ok := clusterAdminURLs[cluster]
+	return u, ok
+}
+
+// RequestedClusters returns the clusters targeted by a proxied admin call, the target cluster, the cluster
+// of /admin/v2/clusters/{cluster}, the namespace replication clusters and the allowed clusters of a Pulsar tenant.
+// The request body is restored after it is read.
+func RequestedClusters(r *http.Request) []string {
+	clusters := []string{}
+	if c := targetCluster(r); c != "" {
+		clusters = append(clusters, c)
+	}
+	if m := clusterPath.FindStringSubmatch(r.URL.Path); m != nil {
+		clusters = append(clusters, m[1])
+	}
+	if r.Method == http.MethodGet || r.Method == http.MethodDelete || r.Body == nil {
+		return clusters
+	}
+	isReplication, isTenant := replicationPath.MatchString(r.URL.Path), tenantPath.MatchString(r.URL.Path)
+	if !isReplication && !isTenant {
+		return clusters
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return clusters
+	}
+	if isReplication {
+		var replication []string
+		if json.Unmarshal(body, &replication) == nil {
+			clusters = append(clusters, replication...)
+		}
+	} else {
+		var tenantInfo struct {
+			AllowedClusters []string `json:"allowedClusters"`
+		}
+		if json.Unmarshal(body, &tenantInfo) == nil {
+			clusters = append(clusters, tenantInfo.AllowedClusters...)
+		}
+	}
+	return clusters
+}
+
+// verifyClusters rejects a call to an unknown target cluster, and a tenant call to a cluster outside
+// the allowed clusters of the tenant plan unless it is a superuser
+func verifyClusters(r *http.Request) (int, error) {
+	cluster := targetCluster(r)
+	if _, ok := clusterAdminURL(cluster); !ok {
+		return http.StatusBadRequest, fmt.Errorf("cluster %s is not fronted by the proxy", cluster)
+	}
+	tenant := requestTenant(r)
+	if tenant == "" {
+		return http.StatusOK, nil
+	}
+	if _, role := ExtractTenant(r.Header.Get(injectedSubs)); util.StrContains(util.SuperRoles, role) {
+		return http.StatusOK, nil
+	}
+	plan, err := policy.TenantManager.GetTenant(tenant)
+	if err != nil {
+		return http.StatusOK, nil
+	}
+	for _, c := range RequestedClusters(r) {
+		if !plan.IsClusterAllowed(c) {
+			return http.StatusForbidden, util.NewReasonError(util.ReasonClusterNotAllowed, fmt.Sprintf("cluster %s is not allowed for tenant %s", c, tenant))
+		}
+	}
+	return http.StatusOK, nil
+}
+
+// routeCluster routes the upstream request for the broker admin REST API to the admin URL of the target cluster,
+// and returns the target cluster or empty if the request is not for the broker admin REST API
+func routeCluster(r, newRequest *http.Request) string {
+	if util.BrokerProxyURL == nil || newRequest.URL.Host != util.BrokerProxyURL.Host {
+		return ""
+	}
+	cluster := targetCluster(r)
+	if u, _ := clusterAdminURL(cluster); u != nil {
+		newRequest.URL.Scheme = u.Scheme
+		newRequest.URL.Host = u.Host
+		newRequest.Host = u.Host
+	}
+	newRequest.Header.Del(ClusterHeader)
+	return cluster
+}
@@ -0,0 +1,109 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package route
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/datastax/burnell/src/audit"
+	"github.com/datastax/burnell/src/policy"
+	"github.com/datastax/burnell/src/util"
+	"github.com/gorilla/mux"
+)
+
+// DefaultCORSOrigins are the origins allowed for all routes
+var DefaultCORSOrigins = []string{"http://localhost:3000", "http://localhost:8080"}
+
+// CORSOriginFunc evaluates the origin against the default and configured origins,
+// then the allowed origins of the tenant resolved from the matched route.
+func CORSOriginFunc(router *mux.Router) func(r *http.Request, origin string) bool {
+	globalOrigins := append([]string{}, DefaultCORSOrigins...)
+	for _, v := range strings.Split(util.GetConfig().CORSAllowedOrigins, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			globalOrigins = append(globalOrigins, v)
+		}
+	}
+
+	return func(r *http.Request, origin string) bool {
+		if policy.MatchOrigin(globalOrigins, origin) {
+			return true
+		}
+		req := r
+		// match the preflight request against the route of the actual request method
+		if method := r.Header.Get("Access-Control-Request-Method"); r.Method == http.MethodOptions && method != "" {
+			req = r.Clone(r.Context())
+			req.Method = method
+		}
+		var match mux.RouteMatch
+		if !router.Match(req, &match) {
+			return false
+		}
+		tenant, ok := match.Vars["tenant"]
+		return ok && policy.TenantManager.IsOriginAllowed(tenant, origin)
+	}
+}
+
+// TenantCORSHandler gets or replaces the allowed browser origins of the tenant
+func TenantCORSHandler(w http.ResponseWriter, r *http.Request) {
+	tenant := mux.Vars(r)["tenant"]
+	plan, err := policy.TenantManager.GetTenant(tenant)
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusNotFound)
+		return
+	}
+
+	if r.Method == http.MethodPut {
+		var origins []string
+		decoder := json.NewDecoder(r.Body)
+		defer r.Body.Close()
+		if err := decoder.Decode(&origins); err != nil {
+			util.ResponseErrorJSON(err, w, http.StatusUnprocessableEntity)
+			return
+		}
+		var statusCode int
+		if plan, statusCode, err = policy.TenantManager.SetAllowedOrigins(tenant, origins); err != nil {
+			responseTenantPlanError(err, w, statusCode)
+			return
+		}
+		audit.Record(audit.Event{
+			Subject:  r.Header.Get(injectedSubs),
+			Tenant:   tenant,
+			Action:   "set-allowed-origins",
+			Resource: r.URL.Path,
+			Detail:   strings.Join(origins, ","),
+			Status:   http.StatusOK,
+		})
+	}
+
+	origins := plan.AllowedOrigins
+	if origins == nil {
+		origins = []string{}
+	}
+	data, err := json.Marshal(origins)
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
+		return
+	}
+	w.Write(data)
+}
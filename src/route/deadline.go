@@ -0,0 +1,107 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package route
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/datastax/burnell/src/util"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RequestTimeoutHeader is the client's request timeout in Go duration, it can only shorten the configured max
+const RequestTimeoutHeader = "X-Burnell-Timeout"
+
+// the long lived routes stream until the client disconnects, they are not bounded by the request deadline
+var deadlineExemptRoutes = map[string]bool{
+	"websocket proxy proxy":       true,
+	"tenants plan watch firehose": true,
+	"tenant plan watch":           true,
+}
+
+var canceledUpstreams = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "burnell",
+	Subsystem: "upstream",
+	Name:      "canceled_total",
+	Help:      "The number of the upstream calls abandoned by the request deadline or the client disconnect.",
+}, []string{"route", "reason"})
+
+func init() {
+	prometheus.MustRegister(canceledUpstreams)
+}
+
+// RequestDeadline bounds the request context by the client's timeout header or RequestDeadlineSeconds, whichever
+// is shorter, the proxied admin calls, the function log reads and the broker stats fan-outs are canceled with it
+func RequestDeadline(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if route := currentRoute(r); route != nil && deadlineExemptRoutes[route.GetName()] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		timeout, err := requestTimeout(r)
+		if err != nil {
+			util.ResponseErrorJSON(err, w, http.StatusBadRequest)
+			return
+		}
+		if timeout <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requestTimeout is the client's timeout capped by the configured max, 0 is no deadline
+func requestTimeout(r *http.Request) (time.Duration, error) {
+	max := time.Duration(util.GetEnvInt("RequestDeadlineSeconds", 0)) * time.Second
+	header := r.Header.Get(RequestTimeoutHeader)
+	if header == "" {
+		return max, nil
+	}
+	timeout, err := time.ParseDuration(header)
+	if err != nil || timeout <= 0 {
+		return 0, errors.New("invalid " + RequestTimeoutHeader + " header " + header)
+	}
+	if max > 0 && timeout > max {
+		return max, nil
+	}
+	return timeout, nil
+}
+
+// upstreamError maps a failed upstream call to the response, 504 if the request deadline has passed
+func upstreamError(r *http.Request, err error) (int, error) {
+	switch r.Context().Err() {
+	case context.DeadlineExceeded:
+		canceledUpstreams.WithLabelValues(routeLabel(r), "deadline").Inc()
+		return http.StatusGatewayTimeout, util.NewReasonError(util.ReasonDeadlineExceeded, err.Error())
+	case context.Canceled:
+		// nobody reads the response, the client has gone
+		canceledUpstreams.WithLabelValues(routeLabel(r), "disconnect").Inc()
+		return http.StatusServiceUnavailable, errors.New("client disconnected")
+	}
+	return http.StatusInternalServerError, errors.New("proxy failure")
+}
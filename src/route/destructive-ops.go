@@ -0,0 +1,110 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package route
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/datastax/burnell/src/audit"
+	"github.com/datastax/burnell/src/util"
+	"github.com/gorilla/mux"
+)
+
+// ConfirmHeader is the request header to confirm a destructive operation, its value is the operation action
+const ConfirmHeader = "X-Burnell-Confirm"
+
+// requireConfirmation requires the ConfirmHeader on the destructive operations by RequireDestructiveConfirmation, set 1 to enable
+var requireConfirmation = util.GetEnvInt("RequireDestructiveConfirmation", 0) > 0
+
+// destructiveOp is a proxied admin operation matched by the method and the path
+type destructiveOp struct {
+	action  string
+	methods []string
+	path    *regexp.Regexp
+}
+
+// the proxied destructive operations in the look up order
+var destructiveOps = []destructiveOp{
+	{"delete-tenant", []string{http.MethodDelete}, regexp.MustCompile(`^/admin/v2/tenants/[^/]+$`)},
+	{"delete-namespace", []string{http.MethodDelete}, regexp.MustCompile(`^/admin/v2/namespaces/[^/]+/[^/]+$`)},
+	{"delete-namespace-bundle", []string{http.MethodDelete}, regexp.MustCompile(`^/admin/v2/namespaces/[^/]+/[^/]+/[^/]+$`)},
+	{"clear-namespace-backlog", []string{http.MethodPost, http.MethodDelete}, regexp.MustCompile(`^/admin/v2/namespaces/[^/]+/[^/]+(/[^/]+)?/clearBacklog(/[^/]+)?$`)},
+	{"unsubscribe-namespace", []string{http.MethodPost, http.MethodDelete}, regexp.MustCompile(`^/admin/v2/namespaces/[^/]+/[^/]+(/[^/]+)?/unsubscribe/[^/]+$`)},
+	{"unload-namespace", []string{http.MethodPut, http.MethodDelete}, regexp.MustCompile(`^/admin/v2/namespaces/[^/]+/[^/]+(/[^/]+)?/unload$`)},
+	{"delete-topic", []string{http.MethodDelete}, regexp.MustCompile(`^/admin/v2/(persistent|non-persistent)/[^/]+/[^/]+/[^/]+(/partitions)?$`)},
+	{"delete-subscription", []string{http.MethodDelete}, regexp.MustCompile(`^/admin/v2/(persistent|non-persistent)/[^/]+/[^/]+/[^/]+/subscription/[^/]+$`)},
+	{"clear-topic-backlog", []string{http.MethodPost}, regexp.MustCompile(`^/admin/v2/(persistent|non-persistent)/[^/]+/[^/]+/[^/]+/subscription/[^/]+/skip_all$`)},
+	{"unload-topic", []string{http.MethodPut}, regexp.MustCompile(`^/admin/v2/(persistent|non-persistent)/[^/]+/[^/]+/[^/]+/unload$`)},
+	{"delete-function", []string{http.MethodDelete}, regexp.MustCompile(`^/admin/v3/(functions|sources|sinks)/[^/]+/[^/]+/[^/]+$`)},
+}
+
+// DestructiveOp returns the action of a proxied destructive operation
+func DestructiveOp(r *http.Request) (string, bool) {
+	for _, op := range destructiveOps {
+		if util.StrContains(op.methods, r.Method) && op.path.MatchString(r.URL.Path) {
+			return op.action, true
+		}
+	}
+	return "", false
+}
+
+// destructiveProxy records who did the destructive operation on which resource with the upstream status,
+// it is rejected without the confirmation header if the confirmation is required
+func destructiveProxy(action, requestURL string, w http.ResponseWriter, r *http.Request) {
+	if requireConfirmation && r.Header.Get(ConfirmHeader) != action {
+		audit.Record(audit.Event{
+			Subject:  r.Header.Get(injectedSubs),
+			Tenant:   requestTenant(r),
+			Action:   action,
+			Resource: r.URL.Path,
+			Detail:   "missing confirmation",
+			Status:   http.StatusPreconditionRequired,
+		})
+		util.ResponseErrorJSON(fmt.Errorf("%s requires the header %s: %s", action, ConfirmHeader, action), w, http.StatusPreconditionRequired)
+		return
+	}
+	recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	forwardProxy(requestURL, recorder, r)
+	audit.Record(audit.Event{
+		Subject:  r.Header.Get(injectedSubs),
+		Tenant:   requestTenant(r),
+		Action:   action,
+		Resource: r.URL.Path,
+		Detail:   r.URL.RawQuery,
+		Status:   recorder.status,
+	})
+}
+
+// requestTenant returns the tenant of the route, or the tenant in the admin path if the route has none
+func requestTenant(r *http.Request) string {
+	if tenant := mux.Vars(r)["tenant"]; tenant != "" {
+		return tenant
+	}
+	if m := adminPathTenant.FindStringSubmatch(r.URL.Path); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+var adminPathTenant = regexp.MustCompile(`^/admin/v[23]/[a-z-]+/([^/]+)`)
@@ -0,0 +1,63 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package route
+
+import (
+	"net/http"
+	"strings"
+)
+
+// ETag returns a strong entity tag computed from the version parts of a resource
+func ETag(parts ...string) string {
+	return `"` + HashKey(strings.Join(parts, "|"))[:32] + `"`
+}
+
+// negotiatedETag returns the entity tag of the version parts in the negotiated representation of the request,
+// the JSON, NDJSON or YAML content type and the selected fields, since each has its own bytes
+func negotiatedETag(r *http.Request, parts ...string) string {
+	format := "json"
+	if wantsNDJSON(r) {
+		format = "ndjson"
+	} else if acceptsYAML(r.Header.Get("Accept")) {
+		format = "yaml"
+	}
+	return ETag(append(parts, format, r.URL.Query().Get(fieldsParam))...)
+}
+
+// notModified sets the ETag header and replies 304 Not Modified if the request If-None-Match matches the etag,
+// so the caller can skip marshaling the unchanged data.
+func notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+	w.Header().Add("Vary", "Accept")
+	match := r.Header.Get("If-None-Match")
+	if match == "" {
+		return false
+	}
+	for _, v := range strings.Split(match, ",") {
+		v = strings.TrimPrefix(strings.TrimSpace(v), "W/")
+		if v == etag || v == "*" {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
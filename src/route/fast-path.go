@@ -0,0 +1,211 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package route
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/datastax/burnell/src/util"
+	"github.com/gorilla/mux"
+)
+
+// HotRoutes are the route names served by the fast path, the Prometheus scrape and the usage routes
+var HotRoutes = []string{"metrics", "pulsar metrics", "tenants usage", "tenant namespaces usage"}
+
+// fastRoute is a hot route resolved once at start up, its path template is split into segments
+// and its handler is composed with the router middlewares
+type fastRoute struct {
+	route    *mux.Route
+	methods  []string
+	segments []string
+	// the variable name of every segment, empty for a literal segment
+	vars    []string
+	numVars int
+	handler http.Handler
+}
+
+// FastPath serves the hot routes without the mux path regexes and the per request middleware chain building,
+// any other request or a request the fast path cannot match exactly falls back to the router.
+type FastPath struct {
+	router *mux.Router
+	routes []*fastRoute
+}
+
+type fastRouteKey struct{}
+
+// NewFastPath resolves the named routes of the router into the fast path. It must be created after all
+// the middlewares are added to the router. A route is skipped if its template has a variable pattern,
+// or another route registered earlier shadows it.
+func NewFastPath(router *mux.Router, names []string) *FastPath {
+	fp := &FastPath{router: router}
+	router.Walk(func(route *mux.Route, _ *mux.Router, ancestors []*mux.Route) error {
+		if len(ancestors) > 0 || !util.StrContains(names, route.GetName()) {
+			return nil
+		}
+		if fr := resolveFastRoute(router, route); fr != nil {
+			fp.routes = append(fp.routes, fr)
+		}
+		return nil
+	})
+	return fp
+}
+
+func resolveFastRoute(router *mux.Router, route *mux.Route) *fastRoute {
+	template, err := route.GetPathTemplate()
+	if err != nil || !strings.HasPrefix(template, "/") {
+		return nil
+	}
+	// a path prefix route has a regexp not anchored at the end
+	if regex, err := route.GetPathRegexp(); err != nil || !strings.HasSuffix(regex, "$") {
+		return nil
+	}
+	methods, err := route.GetMethods()
+	if err != nil {
+		return nil
+	}
+	fr := &fastRoute{route: route, methods: methods}
+	sample := []string{}
+	for _, segment := range strings.Split(template[1:], "/") {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			name := segment[1 : len(segment)-1]
+			if name == "" || strings.Contains(name, ":") {
+				return nil
+			}
+			fr.segments = append(fr.segments, "")
+			fr.vars = append(fr.vars, name)
+			fr.numVars++
+			sample = append(sample, "fastpath")
+		} else if segment == "" || strings.ContainsAny(segment, "{}") {
+			return nil
+		} else {
+			fr.segments = append(fr.segments, segment)
+			fr.vars = append(fr.vars, "")
+			sample = append(sample, segment)
+		}
+	}
+
+	// the router must resolve the same route, it also composes the handler with the middlewares
+	req, err := http.NewRequest(methods[0], "/"+strings.Join(sample, "/"), nil)
+	if err != nil {
+		return nil
+	}
+	var match mux.RouteMatch
+	if !router.Match(req, &match) || match.Route != route || match.MatchErr != nil {
+		return nil
+	}
+	fr.handler = match.Handler
+	return fr
+}
+
+// match matches the path segment by segment without allocation, the variables are only extracted on a match
+func (fr *fastRoute) match(path string) bool {
+	if len(path) == 0 || path[0] != '/' {
+		return false
+	}
+	rest := path[1:]
+	for i, segment := range fr.segments {
+		end := strings.IndexByte(rest, '/')
+		if end < 0 {
+			end = len(rest)
+		}
+		part := rest[:end]
+		if i == len(fr.segments)-1 && end != len(rest) {
+			return false
+		}
+		if fr.vars[i] == "" {
+			if part != segment {
+				return false
+			}
+		} else if part == "" || part == "." || part == ".." {
+			// the router cleans or redirects these paths
+			return false
+		}
+		if end < len(rest) {
+			rest = rest[end+1:]
+		} else {
+			rest = ""
+		}
+	}
+	return true
+}
+
+func (fr *fastRoute) extractVars(path string) map[string]string {
+	vars := make(map[string]string, fr.numVars)
+	parts := strings.Split(path[1:], "/")
+	for i, name := range fr.vars {
+		if name != "" {
+			vars[name] = parts[i]
+		}
+	}
+	return vars
+}
+
+func (fr *fastRoute) allows(method string) bool {
+	for _, m := range fr.methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// Templates returns the path templates served by the fast path
+func (fp *FastPath) Templates() []string {
+	templates := make([]string, 0, len(fp.routes))
+	for _, fr := range fp.routes {
+		template, _ := fr.route.GetPathTemplate()
+		templates = append(templates, template)
+	}
+	return templates
+}
+
+// ServeHTTP dispatches a hot route directly, or falls back to the router
+func (fp *FastPath) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+	for _, fr := range fp.routes {
+		if !fr.match(path) {
+			continue
+		}
+		if !fr.allows(r.Method) {
+			// the router replies method not allowed or matches another route
+			break
+		}
+		ctx := context.WithValue(r.Context(), fastRouteKey{}, fr.route)
+		req := r.WithContext(ctx)
+		if fr.numVars > 0 {
+			req = mux.SetURLVars(req, fr.extractVars(path))
+		}
+		fr.handler.ServeHTTP(w, req)
+		return
+	}
+	fp.router.ServeHTTP(w, r)
+}
+
+// currentRoute returns the matched route of the router or the fast path
+func currentRoute(r *http.Request) *mux.Route {
+	if route, ok := r.Context().Value(fastRouteKey{}).(*mux.Route); ok {
+		return route
+	}
+	return mux.CurrentRoute(r)
+}
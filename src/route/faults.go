@@ -0,0 +1,70 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package route
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/datastax/burnell/src/audit"
+	"github.com/datastax/burnell/src/chaos"
+	"github.com/datastax/burnell/src/util"
+)
+
+// FaultsHandler lists the fault injection rules, replaces them with PUT, or clears them with DELETE
+func FaultsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPut:
+		var rules []chaos.Fault
+		if err := json.NewDecoder(r.Body).Decode(&rules); err != nil {
+			util.ResponseErrorJSON(errors.New("request body requires a list of faults"), w, http.StatusBadRequest)
+			return
+		}
+		if err := chaos.SetFaults(rules); err != nil {
+			util.ResponseErrorJSON(err, w, http.StatusUnprocessableEntity)
+			return
+		}
+		recordFaults(r, fmt.Sprintf("set %d fault injection rules", len(rules)))
+	case http.MethodDelete:
+		chaos.SetFaults(nil)
+		recordFaults(r, "cleared fault injection rules")
+	}
+
+	data, err := json.Marshal(chaos.Faults())
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
+		return
+	}
+	w.Write(data)
+}
+
+func recordFaults(r *http.Request, detail string) {
+	audit.Record(audit.Event{
+		Subject:  r.Header.Get(injectedSubs),
+		Action:   "set-faults",
+		Resource: r.URL.Path,
+		Detail:   detail,
+		Status:   http.StatusOK,
+	})
+}
@@ -0,0 +1,161 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package route
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// fieldsParam is the query parameter of the comma separated fields to return, with dots for the nested fields
+const fieldsParam = "fields"
+
+// fieldTree is the requested fields, a nil subtree selects the whole field
+type fieldTree map[string]fieldTree
+
+func parseFields(fields string) fieldTree {
+	tree := fieldTree{}
+	for _, f := range strings.Split(fields, ",") {
+		if f = strings.TrimSpace(f); f == "" {
+			continue
+		}
+		node := tree
+		parts := strings.Split(f, ".")
+		for i, p := range parts {
+			sub, ok := node[p]
+			if i == len(parts)-1 {
+				// the whole field supersedes the nested fields
+				node[p] = nil
+				break
+			}
+			if ok && sub == nil {
+				break
+			}
+			if !ok {
+				sub = fieldTree{}
+				node[p] = sub
+			}
+			node = sub
+		}
+	}
+	return tree
+}
+
+func (t fieldTree) project(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		projected := make(map[string]interface{}, len(t))
+		for name, sub := range t {
+			if field, ok := value[name]; ok {
+				if sub == nil {
+					projected[name] = field
+				} else {
+					projected[name] = sub.project(field)
+				}
+			}
+		}
+		return projected
+	case []interface{}:
+		for i, item := range value {
+			value[i] = t.project(item)
+		}
+		return value
+	default:
+		return v
+	}
+}
+
+// ProjectFields returns the JSON document with only the requested fields, e.g. name,policy.numOfTopics.
+// The fields of an array apply to every item, and the unknown fields are omitted.
+func ProjectFields(data []byte, fields string) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var doc interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, err
+	}
+	return json.Marshal(parseFields(fields).project(doc))
+}
+
+// projectLines projects every line of a NDJSON response, a line not JSON is kept as it is
+func projectLines(body []byte, fields string) []byte {
+	var projected bytes.Buffer
+	for _, line := range bytes.SplitAfter(body, []byte("\n")) {
+		content := bytes.TrimSuffix(line, []byte("\n"))
+		if len(content) == 0 {
+			projected.Write(line)
+			continue
+		}
+		if data, err := ProjectFields(content, fields); err == nil {
+			projected.Write(data)
+			projected.Write(line[len(content):])
+		} else {
+			projected.Write(line)
+		}
+	}
+	return projected.Bytes()
+}
+
+type fieldsRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (f *fieldsRecorder) WriteHeader(code int) {
+	f.status = code
+}
+
+func (f *fieldsRecorder) Write(b []byte) (int, error) {
+	return f.body.Write(b)
+}
+
+// SelectFields is the middleware to reply a partial JSON response of the fields in the ?fields= query parameter.
+// A response without the parameter, not successful or not JSON is replied as it is.
+func SelectFields(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fields := r.URL.Query().Get(fieldsParam)
+		if fields == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		recorder := &fieldsRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		body := recorder.body.Bytes()
+		if recorder.status >= http.StatusOK && recorder.status < http.StatusMultipleChoices {
+			if w.Header().Get("Content-Type") == NDJSONContentType {
+				body = projectLines(body, fields)
+			} else if projected, err := ProjectFields(body, fields); err == nil {
+				body = projected
+			}
+		}
+		if w.Header().Get("Content-Length") != "" {
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		}
+		w.WriteHeader(recorder.status)
+		w.Write(body)
+	})
+}
@@ -0,0 +1,64 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package route
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/datastax/burnell/src/policy"
+	"github.com/datastax/burnell/src/util"
+	"github.com/gorilla/mux"
+)
+
+// GeoReplicationHandler views and sets the namespace replication clusters.
+// It requires the geo-replication feature code under the tenant plan unless it is a superuser.
+func GeoReplicationHandler(w http.ResponseWriter, r *http.Request) {
+	tenant := mux.Vars(r)["tenant"]
+	_, role := ExtractTenant(r.Header.Get(injectedSubs))
+	if !util.StrContains(util.SuperRoles, role) && !policy.TenantManager.EvaluateFeatureCode(tenant, policy.GeoReplication) {
+		util.ResponseErrorJSON(errors.New("geo-replication is not supported under the current plan, please upgrade your plan"), w, http.StatusPaymentRequired)
+		return
+	}
+	if r.Method == http.MethodGet {
+		DirectBrokerProxyHandler(w, r)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		util.ResponseErrorJSON(errors.New("can't read body"), w, http.StatusBadRequest)
+		return
+	}
+	var clusters []string
+	if err := json.Unmarshal(body, &clusters); err != nil || len(clusters) == 0 {
+		util.ResponseErrorJSON(errors.New("request body requires a list of replication clusters"), w, http.StatusUnprocessableEntity)
+		return
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	auditedProxy("set-replication-clusters", strings.Join(clusters, ","), DirectBrokerProxyHandler, w, r)
+}
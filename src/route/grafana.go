@@ -0,0 +1,222 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package route
+
+// the Grafana simple JSON datasource over the tenant usage history
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/datastax/burnell/src/audit"
+	"github.com/datastax/burnell/src/metrics"
+	"github.com/datastax/burnell/src/util"
+)
+
+// grafanaTargetDelimiter separates the tenant and the metric in a target, i.e. ming-luo/totalBytesIn
+const grafanaTargetDelimiter = "/"
+
+// GrafanaTimeRange is the time range of a query or an annotation request
+type GrafanaTimeRange struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+// GrafanaSearchRequest is the /search request body
+type GrafanaSearchRequest struct {
+	Target string `json:"target"`
+}
+
+// GrafanaQueryTarget is a target of the /query request
+type GrafanaQueryTarget struct {
+	Target string `json:"target"`
+	RefID  string `json:"refId"`
+	Type   string `json:"type"`
+}
+
+// GrafanaQueryRequest is the /query request body
+type GrafanaQueryRequest struct {
+	Range         GrafanaTimeRange     `json:"range"`
+	MaxDataPoints int                  `json:"maxDataPoints"`
+	Targets       []GrafanaQueryTarget `json:"targets"`
+}
+
+// GrafanaTimeSeries is a time series of the /query response, a data point is [value, unix epoch in milliseconds]
+type GrafanaTimeSeries struct {
+	Target     string       `json:"target"`
+	DataPoints [][2]float64 `json:"datapoints"`
+}
+
+// GrafanaAnnotationQuery is the annotation of the /annotations request, the query is a tenant name or empty for all tenants
+type GrafanaAnnotationQuery struct {
+	Name   string `json:"name"`
+	Enable bool   `json:"enable"`
+	Query  string `json:"query"`
+}
+
+// GrafanaAnnotationRequest is the /annotations request body
+type GrafanaAnnotationRequest struct {
+	Range      GrafanaTimeRange       `json:"range"`
+	Annotation GrafanaAnnotationQuery `json:"annotation"`
+}
+
+// GrafanaAnnotation is an annotation of the /annotations response
+type GrafanaAnnotation struct {
+	Annotation GrafanaAnnotationQuery `json:"annotation"`
+	Time       int64                  `json:"time"`
+	Title      string                 `json:"title"`
+	Text       string                 `json:"text"`
+	Tags       []string               `json:"tags"`
+}
+
+// grafanaTenantAllowed evaluates whether the subject can chart the tenant usage
+func grafanaTenantAllowed(tenant, subject string) bool {
+	allowed, _ := EvaluateSubject(tenant, subject)
+	return allowed
+}
+
+// GrafanaTestHandler answers the datasource connection test
+func GrafanaTestHandler(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+// GrafanaSearchHandler returns the targets of the tenants the subject is allowed to chart
+func GrafanaSearchHandler(w http.ResponseWriter, r *http.Request) {
+	var req GrafanaSearchRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			util.ResponseErrorJSON(err, w, http.StatusBadRequest)
+			return
+		}
+	}
+	subject := r.Header.Get(injectedSubs)
+	targets := []string{}
+	for _, tenant := range metrics.UsageHistoryTenants() {
+		if !grafanaTenantAllowed(tenant, subject) {
+			continue
+		}
+		for _, metric := range metrics.UsageMetrics {
+			target := tenant + grafanaTargetDelimiter + metric
+			if strings.Contains(target, req.Target) {
+				targets = append(targets, target)
+			}
+		}
+	}
+	data, err := json.Marshal(targets)
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
+		return
+	}
+	w.Write(data)
+}
+
+// GrafanaQueryHandler returns the time series of the targets within the time range
+func GrafanaQueryHandler(w http.ResponseWriter, r *http.Request) {
+	var req GrafanaQueryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusBadRequest)
+		return
+	}
+	subject := r.Header.Get(injectedSubs)
+	series := []GrafanaTimeSeries{}
+	for _, t := range req.Targets {
+		i := strings.LastIndex(t.Target, grafanaTargetDelimiter)
+		if i < 0 {
+			continue
+		}
+		tenant, metric := t.Target[:i], t.Target[i+1:]
+		if !grafanaTenantAllowed(tenant, subject) {
+			util.ResponseErrorJSON(fmt.Errorf("not authorized to query %s", t.Target), w, http.StatusForbidden)
+			return
+		}
+		samples := metrics.GetUsageHistory(tenant, req.Range.From, req.Range.To)
+		stride := 1
+		if req.MaxDataPoints > 0 && len(samples) > req.MaxDataPoints {
+			stride = (len(samples) + req.MaxDataPoints - 1) / req.MaxDataPoints
+		}
+		points := [][2]float64{}
+		for j := 0; j < len(samples); j += stride {
+			if v, ok := metrics.UsageMetricValue(samples[j], metric); ok {
+				points = append(points, [2]float64{v, float64(samples[j].UpdatedAt.UnixNano() / int64(time.Millisecond))})
+			}
+		}
+		series = append(series, GrafanaTimeSeries{Target: t.Target, DataPoints: points})
+	}
+	data, err := json.Marshal(series)
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
+		return
+	}
+	w.Write(data)
+}
+
+// GrafanaAnnotationsHandler returns the usage anomalies and the audit events within the time range as annotations
+func GrafanaAnnotationsHandler(w http.ResponseWriter, r *http.Request) {
+	var req GrafanaAnnotationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusBadRequest)
+		return
+	}
+	subject := r.Header.Get(injectedSubs)
+	tenant := strings.TrimSpace(req.Annotation.Query)
+	if tenant == "" && !grafanaTenantAllowed("", subject) {
+		util.ResponseErrorJSON(fmt.Errorf("not authorized to query all tenants"), w, http.StatusForbidden)
+		return
+	}
+	if tenant != "" && !grafanaTenantAllowed(tenant, subject) {
+		util.ResponseErrorJSON(fmt.Errorf("not authorized to query %s", tenant), w, http.StatusForbidden)
+		return
+	}
+
+	annotations := []GrafanaAnnotation{}
+	for _, a := range metrics.GetUsageAnomalies(tenant, req.Range.From, req.Range.To) {
+		annotations = append(annotations, GrafanaAnnotation{
+			Annotation: req.Annotation,
+			Time:       a.DetectedAt.UnixNano() / int64(time.Millisecond),
+			Title:      a.Tenant + " " + a.Metric + " anomaly",
+			Text:       strconv.FormatFloat(a.Value, 'f', 0, 64) + " over the mean " + strconv.FormatFloat(a.Mean, 'f', 0, 64),
+			Tags:       []string{"anomaly", a.Tenant, a.Metric},
+		})
+	}
+	for _, e := range audit.Events(tenant, 0) {
+		if e.Time.Before(req.Range.From) || e.Time.After(req.Range.To) {
+			continue
+		}
+		annotations = append(annotations, GrafanaAnnotation{
+			Annotation: req.Annotation,
+			Time:       e.Time.UnixNano() / int64(time.Millisecond),
+			Title:      e.Tenant + " " + e.Action,
+			Text:       e.Detail,
+			Tags:       []string{"audit", e.Tenant, e.Action},
+		})
+	}
+	data, err := json.Marshal(annotations)
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
+		return
+	}
+	w.Write(data)
+}
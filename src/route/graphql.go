@@ -0,0 +1,136 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package route
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/datastax/burnell/src/audit"
+	"github.com/datastax/burnell/src/graphql"
+	"github.com/datastax/burnell/src/logclient"
+	"github.com/datastax/burnell/src/metrics"
+	"github.com/datastax/burnell/src/policy"
+	"github.com/datastax/burnell/src/util"
+)
+
+// GraphQLHandler resolves a GraphQL query against tenant plan, usage, namespaces, functions, and audit
+func GraphQLHandler(w http.ResponseWriter, r *http.Request) {
+	var req graphql.Request
+	if r.Method == http.MethodGet {
+		req.Query = r.URL.Query().Get("query")
+		if vars := r.URL.Query().Get("variables"); vars != "" {
+			if err := json.Unmarshal([]byte(vars), &req.Variables); err != nil {
+				util.ResponseErrorJSON(err, w, http.StatusBadRequest)
+				return
+			}
+		}
+	} else {
+		decoder := json.NewDecoder(r.Body)
+		defer r.Body.Close()
+		if err := decoder.Decode(&req); err != nil {
+			util.ResponseErrorJSON(err, w, http.StatusBadRequest)
+			return
+		}
+	}
+	if strings.TrimSpace(req.Query) == "" {
+		util.ResponseErrorJSON(errors.New("missing query"), w, http.StatusBadRequest)
+		return
+	}
+
+	resp := graphql.Execute(tenantSchema(r.Header.Get(injectedSubs)), req)
+	data, err := json.Marshal(resp)
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
+		return
+	}
+	w.Write(data)
+}
+
+// tenantSchema builds the query schema authorized for the token subject
+func tenantSchema(subject string) graphql.Schema {
+	return graphql.Schema{
+		"tenant": func(args map[string]interface{}) (interface{}, error) {
+			tenant, err := graphql.StringArg(args, "name")
+			if err != nil {
+				return nil, err
+			}
+			if !VerifySubject(tenant, subject) {
+				return nil, errors.New("unauthorized to access tenant " + tenant)
+			}
+			return tenantObject(tenant), nil
+		},
+	}
+}
+
+func tenantObject(tenant string) graphql.Object {
+	return graphql.Object{
+		"name": func(args map[string]interface{}) (interface{}, error) {
+			return tenant, nil
+		},
+		"plan": func(args map[string]interface{}) (interface{}, error) {
+			if !policy.TenantManager.IsWarm() {
+				return nil, errors.New("tenant cache is warming up")
+			}
+			return policy.TenantManager.GetTenant(tenant)
+		},
+		"usage": func(args map[string]interface{}) (interface{}, error) {
+			return metrics.GetTenantUsage(tenant)
+		},
+		"apiUsage": func(args map[string]interface{}) (interface{}, error) {
+			// the API calls of the current month unless from and to are specified
+			now := time.Now().UTC()
+			from, to := now.Format("2006-01")+"-01", now.Format("2006-01-02")
+			if v, err := graphql.StringArg(args, "from"); err == nil {
+				from = v
+			}
+			if v, err := graphql.StringArg(args, "to"); err == nil {
+				to = v
+			}
+			return metrics.GetTenantAPIUsage(tenant, from, to)
+		},
+		"namespacesUsage": func(args map[string]interface{}) (interface{}, error) {
+			return metrics.GetTenantNamespacesUsage(tenant)
+		},
+		"namespaces": func(args map[string]interface{}) (interface{}, error) {
+			return policy.AdminAPIGETRespStringArray("namespaces/" + tenant)
+		},
+		"functions": func(args map[string]interface{}) (interface{}, error) {
+			return logclient.TenantFunctions(tenant), nil
+		},
+		"audit": func(args map[string]interface{}) (interface{}, error) {
+			// the recent audit events as /k/tenant/{tenant}/audit, 100 events unless limit is specified
+			limit := 100
+			if _, ok := args["limit"]; ok {
+				v, err := graphql.IntArg(args, "limit")
+				if err != nil {
+					return nil, err
+				}
+				limit = v
+			}
+			return audit.Events(tenant, limit), nil
+		},
+	}
+}
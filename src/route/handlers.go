@@ -30,12 +30,17 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/datastax/burnell/src/audit"
+	"github.com/datastax/burnell/src/chaos"
 	"github.com/datastax/burnell/src/icrypto"
 	"github.com/datastax/burnell/src/logclient"
 	"github.com/datastax/burnell/src/metrics"
+	"github.com/datastax/burnell/src/notification"
 	"github.com/datastax/burnell/src/policy"
 	"github.com/datastax/burnell/src/util"
 	"github.com/gorilla/mux"
@@ -80,6 +85,10 @@ func Init() {
 
 // TokenSubjectHandler issues new token
 func TokenSubjectHandler(w http.ResponseWriter, r *http.Request) {
+	auditedProxy("mint-token", "subject "+mux.Vars(r)["sub"], tokenSubjectHandler, w, r)
+}
+
+func tokenSubjectHandler(w http.ResponseWriter, r *http.Request) {
 	if !util.IsPulsarJWTEnabled() {
 		w.WriteHeader(http.StatusNotImplemented)
 		return
@@ -100,6 +109,10 @@ func TokenSubjectHandler(w http.ResponseWriter, r *http.Request) {
 		util.ResponseErrorJSON(err, w, http.StatusUnprocessableEntity)
 		return
 	}
+	if !util.JWTAuth.IsAllowed(alg) {
+		util.ResponseErrorJSON(fmt.Errorf("signing method %s is not allowed", alg.Alg()), w, http.StatusUnprocessableEntity)
+		return
+	}
 
 	tokenString, err := util.JWTAuth.GenerateToken(subject, exp, alg)
 	if err != nil {
@@ -113,18 +126,70 @@ func TokenSubjectHandler(w http.ResponseWriter, r *http.Request) {
 			util.ResponseErrorJSON(errors.New("failed to marshal token response json object"), w, http.StatusInternalServerError)
 			return
 		}
+		recordMintTokenEvent(subject, exp)
 		w.Write(respJSON) // implicitly http.StatusOK
 		return
 	}
 	return
 }
 
+// recordMintTokenEvent adds the token minted for the subject to the event feed of the tenant of the subject,
+// and tracks the expiry of the token for the expiration notice
+func recordMintTokenEvent(subject string, exp time.Duration) {
+	case1, case2 := ExtractTenant(subject)
+	for _, tenant := range []string{case2, case1} {
+		if _, err := policy.TenantManager.GetTenant(tenant); err == nil {
+			policy.RecordTenantEvent(policy.TenantEvent{
+				Tenant:   tenant,
+				Category: policy.TokenEventCategory,
+				Type:     "mint-token",
+				Summary:  "token issued to subject " + subject,
+			})
+			if exp > 0 {
+				policy.TrackTokenExpiry(tenant, subject, time.Now().Add(exp))
+			}
+			return
+		}
+	}
+}
+
+// JWKSHandler exposes the active public keys to validate the tokens issued by the token server
+func JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	if !util.IsPulsarJWTEnabled() || util.JWTAuth == nil {
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+	data, err := json.Marshal(util.JWTAuth.JWKS())
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.Write(data)
+}
+
 // StatusPage replies with basic status code
 func StatusPage(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	return
 }
 
+// ReadinessPage replies 503 until the tenant cache is warm
+func ReadinessPage(w http.ResponseWriter, r *http.Request) {
+	if !policy.TenantManager.IsWarm() {
+		tenantCacheWarming(w)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// tenantCacheWarming replies 503 while the tenant cache is replaying the tenant management topic
+func tenantCacheWarming(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", "5")
+	w.Header().Set("X-Burnell-Warming", "true")
+	util.ResponseErrorJSON(errors.New("tenant cache is warming up"), w, http.StatusServiceUnavailable)
+}
+
 // DirectBrokerProxyHandler - Pulsar broker admin REST API
 func DirectBrokerProxyHandler(w http.ResponseWriter, r *http.Request) {
 	requestURL := util.SingleJoinSlash(util.Config.BrokerProxyURL, r.URL.RequestURI())
@@ -137,7 +202,7 @@ func DirectFunctionProxyHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet && r.Method != http.MethodDelete {
 		subject := r.Header.Get("injectedSubs")
 		if subject == "" {
-			http.Error(w, "missing subject", http.StatusUnauthorized)
+			util.ResponseErrorJSON(errors.New("missing subject"), w, http.StatusUnauthorized)
 			return
 		}
 		_, role := ExtractTenant(subject)
@@ -146,8 +211,12 @@ func DirectFunctionProxyHandler(w http.ResponseWriter, r *http.Request) {
 		if tenant, ok := vars["tenant"]; ok {
 			limit := policy.TenantManager.GetFunctionsLimit(tenant)
 			log.Infof("tenant %s with function limit %d, actual counts %d, is superuser %v", tenant, logclient.TenantFunctionCount(tenant), limit, isSuperUser)
-			if logclient.TenantFunctionCount(tenant) >= limit && !isSuperUser {
-				http.Error(w, "over the number of function limit under the current plan, please upgrade your plan", http.StatusPaymentRequired)
+			if logclient.TenantFunctionCount(tenant) >= limit && !isSuperUser &&
+				enforceQuota(tenant, FunctionsQuota, fmt.Sprintf("%d functions over the limit of %d", logclient.TenantFunctionCount(tenant), limit)) {
+				policy.TenantManager.NotifyTenant(tenant, notification.QuotaWarning, "function limit reached",
+					fmt.Sprintf("tenant %s has reached the limit of %d functions under the current plan", tenant, limit))
+				util.ResponseErrorJSON(util.NewReasonError(util.ReasonQuotaFunctionsExceeded,
+					"over the number of function limit under the current plan, please upgrade your plan"), w, http.StatusPaymentRequired)
 				return
 			}
 		}
@@ -157,6 +226,36 @@ func DirectFunctionProxyHandler(w http.ResponseWriter, r *http.Request) {
 	httpProxy(requestURL, w, r)
 }
 
+// CreateTenantProxyHandler validates the tenant name before creating or updating the tenant in Pulsar
+func CreateTenantProxyHandler(w http.ResponseWriter, r *http.Request) {
+	tenant := mux.Vars(r)["tenant"]
+	isNew := false
+	if tenants, err := getTenantNameList(); err == nil {
+		isNew = !util.StrContains(tenants, tenant)
+	}
+	if err := policy.ValidateTenantName(tenant, isNew); err != nil {
+		responseTenantPlanError(err, w, http.StatusUnprocessableEntity)
+		return
+	}
+	recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	CachedProxyHandler(recorder, r)
+	if recorder.status < http.StatusMultipleChoices {
+		// a recreated tenant must not be force removed by the deleted tenant reconciler
+		if err := policy.TenantManager.ClearDeletedTenant(tenant); err != nil {
+			log.Errorf("clear the plan deletion of the recreated tenant %s error %v", tenant, err)
+		}
+	}
+}
+
+// DeleteTenantProxyHandler refuses to delete the Pulsar tenant of a plan protected from deletion
+func DeleteTenantProxyHandler(w http.ResponseWriter, r *http.Request) {
+	if policy.TenantManager.IsProtected(mux.Vars(r)["tenant"]) {
+		util.ResponseErrorJSON(policy.ErrTenantProtected, w, http.StatusConflict)
+		return
+	}
+	CachedProxyHandler(w, r)
+}
+
 // RestrictedTenantsProxyHandler filters tenants based on token subject
 func RestrictedTenantsProxyHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -172,7 +271,7 @@ func RestrictedTenantsProxyHandler(w http.ResponseWriter, r *http.Request) {
 
 	subject := r.Header.Get("injectedSubs")
 	if subject == "" {
-		http.Error(w, "missing subject", http.StatusUnauthorized)
+		util.ResponseErrorJSON(errors.New("missing subject"), w, http.StatusUnauthorized)
 		return
 	}
 	_, role := ExtractTenant(subject)
@@ -218,9 +317,21 @@ func CachedProxyHandler(w http.ResponseWriter, r *http.Request) {
 
 // CachedProxyGETHandler is a http proxy handler with caching capability for GET method only.
 func CachedProxyGETHandler(w http.ResponseWriter, r *http.Request) {
-	data, statusCode, err := cachedGetProxy(r)
+	paged := isPagedRequest(r)
+	get := cachedGetProxy
+	if paged {
+		get = cachedListProxy
+	}
+	data, statusCode, err := get(r)
 	if err == nil {
 		log.Infof("CachedProxyGETHandler return status %d", statusCode)
+		for _, hook := range matchedProxyHooks(r) {
+			statusCode, data = hook.TransformResponse(r, statusCode, w.Header(), data)
+		}
+		if paged {
+			writeListPage(w, r, statusCode, data)
+			return
+		}
 		w.WriteHeader(statusCode)
 		w.Write(data)
 		return
@@ -235,11 +346,14 @@ func cachedGetProxy(r *http.Request) ([]byte, int, error) {
 	//if entry, err := HTTPCache.Get(key); err == nil {
 	//	return entry, http.StatusOK, nil
 	//}
+	if status, err := verifyClusters(r); err != nil {
+		return nil, status, err
+	}
 	requestURL := util.SingleJoinSlash(util.Config.BrokerProxyURL, r.URL.RequestURI())
 	log.Infof("request route %s to proxy %v\n\tdestination url is %s", r.URL.RequestURI(), util.BrokerProxyURL, requestURL)
 
 	// Update the headers to allow for SSL redirection
-	newRequest, err := http.NewRequest(http.MethodGet, requestURL, nil)
+	newRequest, err := http.NewRequestWithContext(r.Context(), http.MethodGet, requestURL, nil)
 	if err != nil {
 		// util.ResponseErrorJSON(errors.New("failed to set proxy request"), w, http.StatusInternalServerError)
 		return nil, http.StatusInternalServerError, err
@@ -249,18 +363,37 @@ func cachedGetProxy(r *http.Request) ([]byte, int, error) {
 	newRequest.Header.Set("X-Proxy", "burnell")
 	//r.Host = util.ProxyURL.Host
 	//r.RequestURI = util.ProxyURL.RequestURI() + requestRoute
-	newRequest.Header.Set("Authorization", "Bearer "+util.Config.PulsarToken)
+	newRequest.Header.Set("Authorization", "Bearer "+util.PulsarAuthToken())
+	for _, hook := range matchedProxyHooks(r) {
+		if err := hook.TransformRequest(r, newRequest); err != nil {
+			return nil, http.StatusForbidden, err
+		}
+	}
+
+	cluster := routeCluster(r, newRequest)
+	transport := injectUpstreamCredential(cluster, newRequest)
+	negotiateAdminVersion(cluster, newRequest)
+	selectAdminUpstream(newRequest)
+	if status, err := chaos.Inject(chaos.Upstream, r.URL.Path); err != nil {
+		return nil, status, err
+	}
 
 	client := &http.Client{
 		CheckRedirect: util.PreserveHeaderForRedirect,
 	}
+	if transport != nil {
+		client.Transport = transport
+	}
+	upstreamStart := time.Now()
 	response, err := client.Do(newRequest)
+	recordUpstream(r, newRequest.URL.Host, time.Since(upstreamStart), upstreamFailed(response, err))
 	if response != nil {
 		defer response.Body.Close()
 	}
 	if err != nil {
 		log.Errorf("%v", err)
-		return nil, http.StatusInternalServerError, errors.New("proxy failure")
+		status, err := upstreamError(r, err)
+		return nil, status, err
 	}
 
 	body, err := ioutil.ReadAll(response.Body)
@@ -278,7 +411,20 @@ func cachedGetProxy(r *http.Request) ([]byte, int, error) {
 	return body, response.StatusCode, nil
 }
 
+// httpProxy forwards the request to the upstream, a destructive operation is confirmed and audited
 func httpProxy(requestURL string, w http.ResponseWriter, r *http.Request) {
+	if status, err := verifyClusters(r); err != nil {
+		util.ResponseErrorJSON(err, w, status)
+		return
+	}
+	if action, ok := DestructiveOp(r); ok {
+		destructiveProxy(action, requestURL, w, r)
+		return
+	}
+	forwardProxy(requestURL, w, r)
+}
+
+func forwardProxy(requestURL string, w http.ResponseWriter, r *http.Request) {
 	log.Infof("request route %s to proxy %v\n\tmethod %v destination url is %s", r.URL.RequestURI(), util.BrokerProxyURL, r.Method, requestURL)
 
 	body, err := ioutil.ReadAll(r.Body)
@@ -287,11 +433,11 @@ func httpProxy(requestURL string, w http.ResponseWriter, r *http.Request) {
 	}
 	if err != nil {
 		log.Infof("%s Error reading body: %v", requestURL, err)
-		http.Error(w, "can't read body", http.StatusBadRequest)
+		util.ResponseErrorJSON(errors.New("can't read body"), w, http.StatusBadRequest)
 		return
 	}
 	// Update the headers to allow for SSL redirection
-	newRequest, err := http.NewRequest(r.Method, requestURL, bytes.NewBuffer(body))
+	newRequest, err := http.NewRequestWithContext(r.Context(), r.Method, requestURL, bytes.NewBuffer(body))
 	if err != nil {
 		util.ResponseErrorJSON(errors.New("failed to set proxy request"), w, http.StatusInternalServerError)
 		return
@@ -302,18 +448,40 @@ func httpProxy(requestURL string, w http.ResponseWriter, r *http.Request) {
 	newRequest.Header = r.Header
 	newRequest.Header.Set("X-Forwarded-Host", r.Header.Get("Host"))
 	newRequest.Header.Set("X-Proxy", "burnell")
-	newRequest.Header.Set("Authorization", "Bearer "+util.Config.PulsarToken)
+	newRequest.Header.Set("Authorization", "Bearer "+util.PulsarAuthToken())
+	hooks := matchedProxyHooks(r)
+	for _, hook := range hooks {
+		if err := hook.TransformRequest(r, newRequest); err != nil {
+			util.ResponseErrorJSON(err, w, http.StatusForbidden)
+			return
+		}
+	}
+
+	cluster := routeCluster(r, newRequest)
+	transport := injectUpstreamCredential(cluster, newRequest)
+	negotiateAdminVersion(cluster, newRequest)
+	selectAdminUpstream(newRequest)
+	if status, err := chaos.Inject(chaos.Upstream, r.URL.Path); err != nil {
+		util.ResponseErrorJSON(err, w, status)
+		return
+	}
 
 	client := &http.Client{
 		CheckRedirect: util.PreserveHeaderForRedirect,
 	}
+	if transport != nil {
+		client.Transport = transport
+	}
+	upstreamStart := time.Now()
 	response, err := client.Do(newRequest)
+	recordUpstream(r, newRequest.URL.Host, time.Since(upstreamStart), upstreamFailed(response, err))
 	if response != nil {
 		defer response.Body.Close()
 	}
 	if err != nil {
 		log.Errorf("%v", err)
-		util.ResponseErrorJSON(errors.New("proxy failure"), w, http.StatusInternalServerError)
+		status, err := upstreamError(r, err)
+		util.ResponseErrorJSON(err, w, status)
 		return
 	}
 
@@ -323,7 +491,11 @@ func httpProxy(requestURL string, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.WriteHeader(response.StatusCode)
+	status := response.StatusCode
+	for _, hook := range hooks {
+		status, body = hook.TransformResponse(r, status, w.Header(), body)
+	}
+	w.WriteHeader(status)
 	w.Write(body)
 	return
 }
@@ -336,7 +508,7 @@ func getTenantNameList() ([]string, error) {
 		return nil, err
 	}
 	newRequest.Header.Add("X-Proxy", "burnell")
-	newRequest.Header.Add("Authorization", "Bearer "+util.Config.PulsarToken)
+	newRequest.Header.Add("Authorization", "Bearer "+util.PulsarAuthToken())
 
 	client := &http.Client{
 		CheckRedirect: util.PreserveHeaderForRedirect,
@@ -384,32 +556,77 @@ func BrokerAggregatorHandler(w http.ResponseWriter, r *http.Request) {
 	limit := queryParamInt(params, "limit", 0) // the limit is per broker
 	log.Infof("offset %d limit %d, request subroute %s", offset, limit, r.URL.RequestURI())
 
-	brokerStats, statusCode, err := policy.AggregateBrokersStats(r.URL.RequestURI(), offset, limit)
+	brokerStats, statusCode, err := policy.AggregateBrokersStats(r.Context(), r.URL.RequestURI(), offset, limit)
 	if err != nil {
-		http.Error(w, "broker stats error "+err.Error(), statusCode)
+		if r.Context().Err() != nil {
+			statusCode, err = upstreamError(r, err)
+			util.ResponseErrorJSON(err, w, statusCode)
+			return
+		}
+		util.ResponseErrorJSON(errors.New("broker stats error "+err.Error()), w, statusCode)
 		return
 	}
 
 	byte, err := json.Marshal(brokerStats)
 	if err != nil {
-		http.Error(w, "marshalling broker stats error "+err.Error(), http.StatusInternalServerError)
+		util.ResponseErrorJSON(errors.New("marshalling broker stats error "+err.Error()), w, http.StatusInternalServerError)
 		return
 	}
 	w.Write(byte)
 	return
 }
 
+// TopicInternalStatsHandler returns a page of partition internal stats with the ledger summary
+func TopicInternalStatsHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	params := r.URL.Query()
+	offset := queryParamInt(params, "offset", 0)
+	limit := queryParamInt(params, "limit", 20)
+	detail := params.Get("detail") == "true"
+
+	stats, statusCode, err := policy.GetTopicInternalStats(r.Context(), vars["tenant"], vars["namespace"], vars["topic"], offset, limit, detail)
+	if err != nil {
+		if r.Context().Err() != nil {
+			statusCode, err = upstreamError(r, err)
+		}
+		util.ResponseErrorJSON(err, w, statusCode)
+		return
+	}
+
+	data, err := json.Marshal(stats)
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
 // TopicProxyHandler enforces the number of topic based on the plan type
+// A new topic inherits the plan defaults.
 func TopicProxyHandler(w http.ResponseWriter, r *http.Request) {
-	limitEnforceProxyHandler(w, r, policy.TenantManager.EvaluateAlwaysSuccessful)
+	proxy := func(w http.ResponseWriter, r *http.Request) {
+		limitEnforceProxyHandler(w, r, policy.TenantManager.EvaluateAlwaysSuccessful, util.ReasonQuotaTopicsExceeded)
+	}
+	if topicPath, ok := createdTopic(r); ok {
+		if !namingPolicyAllowed(mux.Vars(r)["tenant"], policy.TopicResource, topicPath[strings.LastIndex(topicPath, "/")+1:], w, r) {
+			return
+		}
+		withTopicPlanDefaults(mux.Vars(r)["tenant"], topicPath, proxy, w, r)
+		return
+	}
+	proxy(w, r)
 }
 
 // NamespaceLimitEnforceProxyHandler enforces the number of namespace limit based on the plan type
 func NamespaceLimitEnforceProxyHandler(w http.ResponseWriter, r *http.Request) {
-	limitEnforceProxyHandler(w, r, policy.TenantManager.EvaluateNamespaceLimit)
+	if namespace, ok := createdNamespace(r); ok && !namingPolicyAllowed(mux.Vars(r)["tenant"], policy.NamespaceResource, namespace, w, r) {
+		return
+	}
+	limitEnforceProxyHandler(w, r, policy.TenantManager.EvaluateNamespaceLimit, util.ReasonQuotaNamespacesExceeded)
 }
 
-func limitEnforceProxyHandler(w http.ResponseWriter, r *http.Request, eval func(tenant string) (bool, error)) {
+func limitEnforceProxyHandler(w http.ResponseWriter, r *http.Request, eval func(tenant string) (bool, error), reason string) {
 	if r.Method == http.MethodGet {
 		CachedProxyGETHandler(w, r)
 		return
@@ -417,7 +634,7 @@ func limitEnforceProxyHandler(w http.ResponseWriter, r *http.Request, eval func(
 
 	subject := r.Header.Get("injectedSubs")
 	if subject == "" {
-		http.Error(w, "missing subject", http.StatusUnauthorized)
+		util.ResponseErrorJSON(errors.New("missing subject"), w, http.StatusUnauthorized)
 		return
 	}
 	_, role := ExtractTenant(subject)
@@ -428,11 +645,13 @@ func limitEnforceProxyHandler(w http.ResponseWriter, r *http.Request, eval func(
 	vars := mux.Vars(r)
 	if tenant, ok := vars["tenant"]; ok {
 		if ok, err := eval(tenant); err != nil {
-			http.Error(w, err.Error(), http.StatusUnauthorized)
-		} else if ok {
+			util.ResponseErrorJSON(err, w, http.StatusUnauthorized)
+		} else if ok || !enforceQuota(tenant, PlanLimitQuota, r.Method+" "+r.URL.Path) {
 			DirectBrokerProxyHandler(w, r)
 		} else {
-			http.Error(w, "over the quota limit", http.StatusPaymentRequired)
+			policy.TenantManager.NotifyTenant(tenant, notification.QuotaWarning, "plan quota limit reached",
+				fmt.Sprintf("tenant %s request %s %s is over the quota limit under the current plan", tenant, r.Method, r.URL.Path))
+			util.ResponseErrorJSON(util.NewReasonError(reason, "over the quota limit"), w, http.StatusPaymentRequired)
 		}
 	} else {
 		w.WriteHeader(http.StatusUnauthorized)
@@ -452,12 +671,35 @@ func FunctionStatusHandler(w http.ResponseWriter, r *http.Request) {
 
 	funcType, ok := logclient.ReadFunctionMap(tenant + namespace + funcName)
 	if !ok {
-		http.Error(w, "not found", http.StatusNotFound)
+		util.ResponseErrorJSON(errors.New("not found"), w, http.StatusNotFound)
 		return
 	}
 	responseBody, err := json.Marshal(funcType)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
+		return
+	}
+	w.Write(responseBody)
+}
+
+// FunctionResourcesResponse is the resources requested by the functions under a tenant
+type FunctionResourcesResponse struct {
+	Tenant    string                      `json:"tenant"`
+	Total     logclient.FunctionResources `json:"total"`
+	Functions []logclient.FunctionType    `json:"functions"`
+}
+
+// FunctionResourcesHandler returns the resources, runtime and secret names of all functions under the tenant
+func FunctionResourcesHandler(w http.ResponseWriter, r *http.Request) {
+	tenant := mux.Vars(r)["tenant"]
+	total, functions := logclient.TenantFunctionResources(tenant)
+	responseBody, err := json.Marshal(FunctionResourcesResponse{
+		Tenant:    tenant,
+		Total:     total,
+		Functions: functions,
+	})
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
 		return
 	}
 	w.Write(responseBody)
@@ -474,7 +716,7 @@ func FunctionLogsHandler(w http.ResponseWriter, r *http.Request) {
 		var err error
 		instance, err = strconv.Atoi(instanceStr)
 		if err != nil {
-			http.Error(w, "invalid instance name", http.StatusBadRequest)
+			util.ResponseErrorJSON(errors.New("invalid instance name"), w, http.StatusBadRequest)
 			return
 		}
 	}
@@ -492,11 +734,11 @@ func FunctionLogsHandler(w http.ResponseWriter, r *http.Request) {
 	reqObj.Bytes = int64(queryParamInt(params, "bytes", 2400))
 	log.WithField("app", "FunctionLogHandler").Infof("function log query params %v", reqObj)
 	if reqObj.BackwardPosition > 0 && reqObj.ForwardPosition > 0 {
-		http.Error(w, "backwardpos and forwardpos cannot be specified at the same time", http.StatusBadRequest)
+		util.ResponseErrorJSON(errors.New("backwardpos and forwardpos cannot be specified at the same time"), w, http.StatusBadRequest)
 		return
 	}
 	if reqObj.Bytes < 0 {
-		http.Error(w, "bytes cannot be a negative value", http.StatusBadRequest)
+		util.ResponseErrorJSON(errors.New("bytes cannot be a negative value"), w, http.StatusBadRequest)
 		return
 	}
 	workerID := ""
@@ -504,19 +746,30 @@ func FunctionLogsHandler(w http.ResponseWriter, r *http.Request) {
 		workerID = strs[0]
 	}
 
-	clientRes, err := logclient.GetFunctionLog(tenant+namespace+funcName, workerID, instance, reqObj)
+	level, err := requestLogLevel(r)
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("X-Burnell-Log-Level", level.String())
+
+	reader := levelFilteredLogReader(level, maskedLogReader(tenant, logclient.GetFunctionLog))
+	clientRes, err := reader(r.Context(), tenant+namespace+funcName, workerID, instance, reqObj)
 	if err != nil {
-		if err == logclient.ErrNotFoundFunction || strings.HasSuffix(err.Error(), "no such file or directory") {
-			http.Error(w, err.Error(), http.StatusNotFound)
+		if r.Context().Err() != nil {
+			status, err := upstreamError(r, err)
+			util.ResponseErrorJSON(err, w, status)
+		} else if err == logclient.ErrNotFoundFunction || strings.HasSuffix(err.Error(), "no such file or directory") {
+			util.ResponseErrorJSON(err, w, http.StatusNotFound)
 		} else {
-			http.Error(w, "log server returned "+err.Error(), http.StatusInternalServerError)
+			util.ResponseErrorJSON(errors.New("log server returned "+err.Error()), w, http.StatusInternalServerError)
 		}
 		return
 	}
 	// fmt.Printf("pos %d, %d\n", clientRes.BackwardPosition, clientRes.ForwardPosition)
 	jsonResponse, err := json.Marshal(clientRes)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
 		return
 	}
 
@@ -532,7 +785,7 @@ func FunctionLogsHandler(w http.ResponseWriter, r *http.Request) {
 func PulsarFederatedPrometheusHandler(w http.ResponseWriter, r *http.Request) {
 	subject := r.Header.Get("injectedSubs")
 	if subject == "" {
-		http.Error(w, "missing subject", http.StatusUnauthorized)
+		util.ResponseErrorJSON(errors.New("missing subject"), w, http.StatusUnauthorized)
 		return
 	}
 	_, tenant := ExtractTenant(subject)
@@ -540,25 +793,56 @@ func PulsarFederatedPrometheusHandler(w http.ResponseWriter, r *http.Request) {
 	if util.StrContains(util.SuperRoles, tenant) {
 		tenant = metrics.SuperRole
 	}
+	// a tenant token may only scrape its own metrics, a superuser can scrape any tenant
+	if reqTenant := r.URL.Query().Get("tenant"); reqTenant != "" {
+		if !VerifySubject(reqTenant, subject) {
+			util.ResponseErrorJSON(errors.New("Unauthorized"), w, http.StatusUnauthorized)
+			return
+		}
+		tenant = reqTenant
+	}
 
 	//TODO: disable the feature since the backend database has to populated
 	/*if !policy.TenantManager.EvaluateFeatureCode(tenant, policy.BrokerMetrics) {
-		http.Error(w, "", http.StatusForbidden)
+		util.ResponseErrorJSON(errors.New(""), w, http.StatusForbidden)
 	}
 	*/
-	tenantFederatedPrometheus(tenant, w)
+	tenantFederatedPrometheus(tenant, w, r)
 }
 
-func tenantFederatedPrometheus(tenant string, w http.ResponseWriter) {
-	data, err := metrics.GetTenantPromMetrics(tenant)
+// tenantFederatedPrometheus replies the cached federated metrics of the tenant. The Last-Modified is the scrape time of the cache,
+// so a conditional GET or HEAD with If-Modified-Since is replied 304 without the metrics until the next scrape,
+// and a HEAD is replied the Content-Length without the body.
+func tenantFederatedPrometheus(tenant string, w http.ResponseWriter, r *http.Request) {
+	data, scrapedAt, err := metrics.GetScrapedTenantPromMetrics(tenant)
 	if err != nil {
 		util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
 		return
 	}
+	if len(data) > 1 {
+		w.Header().Set("Last-Modified", scrapedAt.UTC().Format(http.TimeFormat))
+		if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !scrapedAt.Truncate(time.Second).After(since) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	if data, err = metrics.GuardCardinality(tenant, data); err != nil {
+		log.Errorf("%v", err)
+		util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
+		return
+	}
+	if data, err = metrics.Relabel(data, util.GetConfig().MetricsRelabel, util.GetConfig().ClusterName); err != nil {
+		log.Errorf("%v", err)
+		util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
+		return
+	}
 
 	if len(data) > 1 {
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
 		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(data))
+		if r.Method != http.MethodHead {
+			w.Write([]byte(data))
+		}
 	} else if tenant == metrics.SuperRole {
 		// missing all metrics must be an internal error
 		util.ResponseErrorJSON(fmt.Errorf("failed to get prometheus data"), w, http.StatusInternalServerError)
@@ -569,17 +853,18 @@ func tenantFederatedPrometheus(tenant string, w http.ResponseWriter) {
 	}
 }
 
-// PulsarFederatedDebugPrometheusHandler is for superrole to get individual tenant metrics
+// PulsarFederatedDebugPrometheusHandler gets individual tenant metrics for the tenant or a superuser
 func PulsarFederatedDebugPrometheusHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	tenant, _ := vars["tenant"]
 	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
-	tenantFederatedPrometheus(tenant, w)
+	tenantFederatedPrometheus(tenant, w, r)
 }
 
 // TenantUsageHandler returns tenant usage
 func TenantUsageHandler(w http.ResponseWriter, r *http.Request) {
 	var usages []metrics.Usage
+	var metadata []byte
 	var err error
 	vars := mux.Vars(r)
 	tenant, ok := vars["tenant"]
@@ -587,21 +872,30 @@ func TenantUsageHandler(w http.ResponseWriter, r *http.Request) {
 		usages, err = metrics.GetTenantNamespacesUsage(tenant)
 	} else {
 		usages, err = metrics.GetTenantsUsage()
+		// the tenant metadata such as billing IDs for the usage export
+		tenantsMetadata := make([]map[string]string, len(usages))
+		for i := range usages {
+			if plan, err := policy.TenantManager.GetTenant(usages[i].Name); err == nil {
+				usages[i].Metadata = plan.Metadata
+				tenantsMetadata[i] = plan.Metadata
+			}
+		}
+		// a metadata update does not rebuild the usage, so the metadata is a part of the etag
+		metadata, _ = json.Marshal(tenantsMetadata)
 	}
 	if err != nil {
 		log.Errorf("failed to get tenant usage %s", err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
 		return
 	}
-
-	data, err := json.Marshal(usages)
-	if err != nil {
-		log.Errorf("marshal tenant usage error %s", err.Error())
-		http.Error(w, "failed to marshal tenant usage data", http.StatusInternalServerError)
+	if notModified(w, r, negotiatedETag(r, "usage", tenant, strconv.FormatUint(metrics.UsageVersion(), 10), string(metadata))) {
 		return
 	}
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(data))
+
+	// stream the usage of thousands of tenants instead of marshaling all at once
+	if err := StreamJSONArray(w, r, http.StatusOK, len(usages), func(i int) interface{} { return usages[i] }); err != nil {
+		log.Errorf("stream tenant usage error %s", err.Error())
+	}
 }
 
 // TenantTopicStatsHandler returns tenant topic statistics
@@ -609,7 +903,7 @@ func TenantTopicStatsHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	tenant, ok := vars["tenant"]
 	if !ok {
-		http.Error(w, "missing tenant name", http.StatusUnprocessableEntity)
+		util.ResponseErrorJSON(errors.New("missing tenant name"), w, http.StatusUnprocessableEntity)
 		return
 	}
 	u, _ := url.Parse(r.URL.String())
@@ -640,7 +934,7 @@ func TenantTopicStatsHandler(w http.ResponseWriter, r *http.Request) {
 			Data:      topics,
 		})
 		if err != nil {
-			http.Error(w, "failed to marshal cached data", http.StatusInternalServerError)
+			util.ResponseErrorJSON(errors.New("failed to marshal cached data"), w, http.StatusInternalServerError)
 		}
 		w.WriteHeader(http.StatusOK)
 		w.Write(data)
@@ -656,7 +950,7 @@ func GroupTopicsByNamespaceHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	tenant, ok := vars["tenant"]
 	if !ok {
-		http.Error(w, "missing tenant name", http.StatusUnprocessableEntity)
+		util.ResponseErrorJSON(errors.New("missing tenant name"), w, http.StatusUnprocessableEntity)
 		return
 	}
 	topics, length := policy.CountTopics(tenant)
@@ -665,14 +959,9 @@ func GroupTopicsByNamespaceHandler(w http.ResponseWriter, r *http.Request) {
 	} else if length == 0 {
 		w.WriteHeader(http.StatusNoContent)
 	} else {
-		data, err := json.Marshal(topics)
-		if err != nil {
-			http.Error(w, "failed to marshal cached topics data", http.StatusInternalServerError)
-			return
+		if err := StreamJSONObject(w, r, http.StatusOK, topics, "namespace", "topics"); err != nil {
+			log.Errorf("stream topics grouped by namespace error %s", err.Error())
 		}
-		w.WriteHeader(http.StatusOK)
-		w.Write(data)
-		return
 	}
 }
 
@@ -694,10 +983,21 @@ func queryParamString(params url.Values, name string, defaultV string) string {
 
 // TenantManagementHandler manages tenant CRUD operations.
 func TenantManagementHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		auditedProxy("update-tenant-plan", "", tenantManagementHandler, w, r)
+	case http.MethodDelete:
+		auditedProxy("delete-tenant-plan", "", tenantManagementHandler, w, r)
+	default:
+		tenantManagementHandler(w, r)
+	}
+}
+
+func tenantManagementHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	tenant, ok := vars["tenant"]
 	if !ok {
-		http.Error(w, "missing tenant name", http.StatusUnprocessableEntity)
+		util.ResponseErrorJSON(errors.New("missing tenant name"), w, http.StatusUnprocessableEntity)
 		return
 	}
 	var newPlan policy.TenantPlan
@@ -705,6 +1005,10 @@ func TenantManagementHandler(w http.ResponseWriter, r *http.Request) {
 
 	switch r.Method {
 	case http.MethodGet:
+		if !policy.TenantManager.IsWarm() {
+			tenantCacheWarming(w)
+			return
+		}
 		tenants, err := getTenantNameList()
 		if err != nil {
 			util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
@@ -722,15 +1026,22 @@ func TenantManagementHandler(w http.ResponseWriter, r *http.Request) {
 			util.ResponseErrorJSON(err, w, http.StatusNotFound)
 			return
 		}
+		if notModified(w, r, negotiatedETag(r, "plan", newPlan.Name, strconv.FormatInt(newPlan.UpdatedAt.UnixNano(), 10))) {
+			return
+		}
 
 	case http.MethodDelete:
-		if newPlan, err = policy.TenantManager.DeleteTenant(tenant); err != nil {
+		if newPlan, err = policy.TenantManager.DeleteTenant(tenant); errors.Is(err, policy.ErrTenantProtected) {
+			util.ResponseErrorJSON(err, w, http.StatusConflict)
+			return
+		} else if err != nil {
 			util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
 			return
 		}
 
 	case http.MethodPost:
 		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
 		defer r.Body.Close()
 
 		doc := new(policy.TenantPlan)
@@ -742,7 +1053,7 @@ func TenantManagementHandler(w http.ResponseWriter, r *http.Request) {
 		var statusCode int
 		if newPlan, statusCode, err = policy.TenantManager.UpdateTenant(tenant, *doc); err != nil {
 			log.Errorf("updateTenant %v", err)
-			util.ResponseErrorJSON(err, w, statusCode)
+			responseTenantPlanError(err, w, statusCode)
 			return
 		}
 	default:
@@ -755,6 +1066,287 @@ func TenantManagementHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// TenantProtection is the deletion protection of a tenant
+type TenantProtection struct {
+	Tenant    string `json:"tenant"`
+	Protected bool   `json:"protected"`
+}
+
+// TenantProtectionHandler gets, sets or clears the deletion protection of a tenant
+func TenantProtectionHandler(w http.ResponseWriter, r *http.Request) {
+	tenant := mux.Vars(r)["tenant"]
+	plan, err := policy.TenantManager.GetTenant(tenant)
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusNotFound)
+		return
+	}
+
+	if r.Method == http.MethodPut {
+		var req TenantProtection
+		decoder := json.NewDecoder(r.Body)
+		defer r.Body.Close()
+		if err := decoder.Decode(&req); err != nil {
+			util.ResponseErrorJSON(err, w, http.StatusUnprocessableEntity)
+			return
+		}
+		var statusCode int
+		if plan, statusCode, err = policy.TenantManager.SetProtected(tenant, req.Protected); err != nil {
+			util.ResponseErrorJSON(err, w, statusCode)
+			return
+		}
+		action := "clear-deletion-protection"
+		if req.Protected {
+			action = "set-deletion-protection"
+		}
+		audit.Record(audit.Event{
+			Subject:  r.Header.Get(injectedSubs),
+			Tenant:   tenant,
+			Action:   action,
+			Resource: r.URL.Path,
+			Status:   http.StatusOK,
+		})
+	}
+
+	data, err := json.Marshal(TenantProtection{Tenant: tenant, Protected: plan.Protected})
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
+		return
+	}
+	w.Write(data)
+}
+
+// TenantSSOHandler gets or replaces the console SSO mapping of a tenant
+func TenantSSOHandler(w http.ResponseWriter, r *http.Request) {
+	tenant := mux.Vars(r)["tenant"]
+	plan, err := policy.TenantManager.GetTenant(tenant)
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusNotFound)
+		return
+	}
+
+	if r.Method == http.MethodPut {
+		var sso policy.TenantSSO
+		decoder := json.NewDecoder(r.Body)
+		defer r.Body.Close()
+		if err := decoder.Decode(&sso); err != nil {
+			util.ResponseErrorJSON(err, w, http.StatusUnprocessableEntity)
+			return
+		}
+		var statusCode int
+		if plan, statusCode, err = policy.TenantManager.SetTenantSSO(tenant, sso); err != nil {
+			responseTenantPlanError(err, w, statusCode)
+			return
+		}
+		audit.Record(audit.Event{
+			Subject:  r.Header.Get(injectedSubs),
+			Tenant:   tenant,
+			Action:   "set-sso-mapping",
+			Resource: r.URL.Path,
+			Detail:   "email domains " + strings.Join(sso.EmailDomains, ",") + " idp groups " + strings.Join(sso.IdPGroups, ","),
+			Status:   http.StatusOK,
+		})
+	}
+
+	sso := policy.TenantSSO{}
+	if plan.SSO != nil {
+		sso = *plan.SSO
+	}
+	data, err := json.Marshal(sso)
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
+		return
+	}
+	w.Write(data)
+}
+
+// SSOResolveRequest is the console SSO user to resolve the tenants for
+type SSOResolveRequest struct {
+	Email  string   `json:"email"`
+	Groups []string `json:"groups"`
+}
+
+// SSOResolveResponse is the tenants the console SSO user may administer
+type SSOResolveResponse struct {
+	Email   string             `json:"email"`
+	Tenants []policy.SSOTenant `json:"tenants"`
+}
+
+// SSOResolveHandler resolves the tenants a console SSO user may administer by the email domain and the IdP groups
+func SSOResolveHandler(w http.ResponseWriter, r *http.Request) {
+	var req SSOResolveRequest
+	decoder := json.NewDecoder(r.Body)
+	defer r.Body.Close()
+	if err := decoder.Decode(&req); err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusUnprocessableEntity)
+		return
+	}
+	if req.Email == "" && len(req.Groups) == 0 {
+		util.ResponseErrorJSON(errors.New("email or groups is required"), w, http.StatusUnprocessableEntity)
+		return
+	}
+	if !policy.TenantManager.IsWarm() {
+		tenantCacheWarming(w)
+		return
+	}
+	tenants, err := policy.TenantManager.ResolveSSOTenants(req.Email, req.Groups)
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusUnprocessableEntity)
+		return
+	}
+	data, err := json.Marshal(SSOResolveResponse{Email: req.Email, Tenants: tenants})
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
+		return
+	}
+	w.Write(data)
+}
+
+// ReasonsHandler returns the registry of the error reason codes, with the messages in the language of the lang
+// query parameter or the Accept-Language header if either is given
+func ReasonsHandler(w http.ResponseWriter, r *http.Request) {
+	lang := r.URL.Query().Get("lang")
+	if lang == "" {
+		lang = strings.TrimSpace(strings.SplitN(strings.SplitN(r.Header.Get("Accept-Language"), ",", 2)[0], ";", 2)[0])
+	}
+	reasons := util.Reasons()
+	if lang != "" {
+		for i := range reasons {
+			reasons[i].Message = util.ReasonMessage(reasons[i].Code, lang)
+			reasons[i].Messages = nil
+		}
+	}
+	data, err := json.Marshal(reasons)
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
+		return
+	}
+	w.Write(data)
+}
+
+// ValidationErrorResponse is the error response for invalid tenant plan fields, the fields are also the details
+type ValidationErrorResponse struct {
+	util.ErrorResponse
+	Fields []policy.FieldError `json:"fields"`
+}
+
+// responseTenantPlanError replies the list of invalid fields if it is a validation error
+func responseTenantPlanError(err error, w http.ResponseWriter, statusCode int) {
+	var vErr *policy.ValidationError
+	if !errors.As(err, &vErr) {
+		util.ResponseErrorJSON(err, w, statusCode)
+		return
+	}
+	response := util.NewErrorResponse(&util.APIError{
+		Code:    "validation_failed",
+		Reason:  util.ReasonValidationFailed,
+		Message: vErr.Error(),
+		Details: vErr.Fields,
+	}, w, statusCode)
+	data, err := json.Marshal(ValidationErrorResponse{
+		ErrorResponse: response,
+		Fields:        vErr.Fields,
+	})
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(data)
+}
+
+// TenantNotificationHandler sends a notice to the tenant contacts
+func TenantNotificationHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	tenant, ok := vars["tenant"]
+	if !ok {
+		util.ResponseErrorJSON(errors.New("missing tenant name"), w, http.StatusUnprocessableEntity)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	defer r.Body.Close()
+	var notice notification.Notice
+	if err := decoder.Decode(&notice); err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusUnprocessableEntity)
+		return
+	}
+	kind := util.AssignString(notice.Kind, notification.Maintenance)
+
+	if !policy.TenantManager.NotifyTenant(tenant, kind, notice.Subject, notice.Message) {
+		// the tenant either has no contacts or has not opted in this kind of notice
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// TenantUsageReportHandler previews the tenant usage report in JSON, or CSV with format=csv, and POST delivers it now.
+// The optional from and to are RFC3339 time and the report defaults to the last 7 days.
+func TenantUsageReportHandler(w http.ResponseWriter, r *http.Request) {
+	tenant := mux.Vars(r)["tenant"]
+	if _, err := policy.TenantManager.GetTenant(tenant); err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusNotFound)
+		return
+	}
+	params := r.URL.Query()
+	to, err := queryParamTime(params, "to", time.Now())
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusBadRequest)
+		return
+	}
+	from, err := queryParamTime(params, "from", to.Add(-policy.DefaultUsageReportPeriod))
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusBadRequest)
+		return
+	}
+	if !from.Before(to) {
+		util.ResponseErrorJSON(errors.New("from must be before to"), w, http.StatusBadRequest)
+		return
+	}
+	report := metrics.GetUsageReport(tenant, from, to)
+
+	if r.Method == http.MethodPost {
+		sent, err := policy.TenantManager.SendUsageReport(report)
+		if err != nil {
+			util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
+			return
+		}
+		if !sent {
+			// the tenant either has no contacts or has not opted in the usage report
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	if queryParamString(params, "format", "") == "csv" {
+		data, err := report.CSV()
+		if err != nil {
+			util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/csv")
+		w.Write(data)
+		return
+	}
+	data, err := json.Marshal(report)
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
+		return
+	}
+	w.Write(data)
+}
+
+func queryParamTime(params url.Values, name string, defaultV time.Time) (time.Time, error) {
+	str := queryParamString(params, name, "")
+	if str == "" {
+		return defaultV, nil
+	}
+	return time.Parse(time.RFC3339, str)
+}
+
 // PulsarBeamGetTopicHandler gets the topic details
 func PulsarBeamGetTopicHandler(w http.ResponseWriter, r *http.Request) {
 	topicKey, err := route.GetTopicKey(r)
@@ -790,7 +1382,7 @@ func PulsarBeamGetTopicHandler(w http.ResponseWriter, r *http.Request) {
 func PulsarBeamUpdateTopicHandler(w http.ResponseWriter, r *http.Request) {
 	subject := r.Header.Get("injectedSubs")
 	if subject == "" {
-		http.Error(w, "missing subject", http.StatusUnauthorized)
+		util.ResponseErrorJSON(errors.New("missing subject"), w, http.StatusUnauthorized)
 		return
 	}
 	decoder := json.NewDecoder(r.Body)
@@ -885,14 +1477,9 @@ func VerifyTenant(r *http.Request) (string, string, bool) {
 
 // VerifySubject verifies the subject can meet the requirement.
 func VerifySubject(requiredSubject, tokenSubjects string) bool {
-	for _, v := range strings.Split(tokenSubjects, ",") {
-		if util.StrContains(util.SuperRoles, v) {
-			return true
-		}
-		subCase1, subCase2 := ExtractTenant(v)
-		return requiredSubject == subCase1 || requiredSubject == subCase2
-	}
-	return false
+	allowed, reason := EvaluateSubject(requiredSubject, tokenSubjects)
+	recordSubjectDecision(requiredSubject, tokenSubjects, allowed, reason)
+	return allowed
 }
 
 // this is a callback for Pulsar Beam's route.VerifySubjectBasedOnTopic
@@ -928,3 +1515,243 @@ func ExtractTenant(tokenSub string) (string, string) {
 	}
 	return case1, case1
 }
+
+// DeletedTenantsHandler reports the Pulsar namespaces and topics left over by deleted tenant plans
+func DeletedTenantsHandler(w http.ResponseWriter, r *http.Request) {
+	data, err := json.Marshal(policy.GetDeletedTenantResources())
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
+		return
+	}
+	w.Write(data)
+}
+
+// TenantTopicHandler returns the tenant management topic stats
+func TenantTopicHandler(w http.ResponseWriter, r *http.Request) {
+	data, err := json.Marshal(policy.TenantManager.TopicStats())
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
+		return
+	}
+	w.Write(data)
+}
+
+// TenantTopicCompactionHandler triggers the compaction of the tenant management topic
+func TenantTopicCompactionHandler(w http.ResponseWriter, r *http.Request) {
+	auditedProxy("compact-tenant-topic", "", func(w http.ResponseWriter, r *http.Request) {
+		tenantTopicMaintenance(policy.TenantManager.CompactTopic, w)
+	}, w, r)
+}
+
+// TenantTopicTrimHandler triggers the trimming of the tenant management topic ledgers out of the retention
+func TenantTopicTrimHandler(w http.ResponseWriter, r *http.Request) {
+	auditedProxy("trim-tenant-topic", "", func(w http.ResponseWriter, r *http.Request) {
+		tenantTopicMaintenance(policy.TenantManager.TrimTopic, w)
+	}, w, r)
+}
+
+func tenantTopicMaintenance(op func() (int, error), w http.ResponseWriter) {
+	status, err := op()
+	if err != nil {
+		util.ResponseErrorJSON(err, w, status)
+		return
+	}
+	w.WriteHeader(status)
+	data, _ := json.Marshal(policy.TenantManager.TopicStats())
+	w.Write(data)
+}
+
+// ReencryptTenantPlansHandler rewrites the tenant plans with the active policy encryption key after a key rotation
+func ReencryptTenantPlansHandler(w http.ResponseWriter, r *http.Request) {
+	auditedProxy("reencrypt-tenant-plans", "active key "+policy.ActivePlanEncryptionKey(), reencryptTenantPlansHandler, w, r)
+}
+
+func reencryptTenantPlansHandler(w http.ResponseWriter, r *http.Request) {
+	count, err := policy.TenantManager.ReencryptTenants()
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusUnprocessableEntity)
+		return
+	}
+	data, err := json.Marshal(map[string]interface{}{
+		"keyId":       policy.ActivePlanEncryptionKey(),
+		"reencrypted": count,
+	})
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
+		return
+	}
+	w.Write(data)
+}
+
+// TenantPlanBatchHandler starts a batch plan update of a list or a filter of tenants as an async job
+func TenantPlanBatchHandler(w http.ResponseWriter, r *http.Request) {
+	auditedProxy("batch-update-tenant-plan", "", tenantPlanBatchHandler, w, r)
+}
+
+func tenantPlanBatchHandler(w http.ResponseWriter, r *http.Request) {
+	var req policy.BatchPlanRequest
+	decoder := json.NewDecoder(r.Body)
+	defer r.Body.Close()
+	if err := decoder.Decode(&req); err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusUnprocessableEntity)
+		return
+	}
+	job, statusCode, err := policy.TenantManager.StartBatchPlanUpdate(req)
+	if err != nil {
+		responseTenantPlanError(err, w, statusCode)
+		return
+	}
+	data, err := json.Marshal(job)
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Location", "/admin/tenantsplan/batch/"+job.ID)
+	w.WriteHeader(statusCode)
+	w.Write(data)
+}
+
+// TenantPlanBatchJobHandler returns the progress and the per tenant results of a batch plan update job
+func TenantPlanBatchJobHandler(w http.ResponseWriter, r *http.Request) {
+	job, ok := policy.GetBatchJob(mux.Vars(r)["id"])
+	if !ok {
+		util.ResponseErrorJSON(errors.New("batch job not found"), w, http.StatusNotFound)
+		return
+	}
+	data, err := json.Marshal(job)
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
+		return
+	}
+	w.Write(data)
+}
+
+// TenantsPlanQueryHandler lists the tenant plans by the name and org patterns, such as ?nameLike=acme-*&org=acme
+func TenantsPlanQueryHandler(w http.ResponseWriter, r *http.Request) {
+	if !policy.TenantManager.IsWarm() {
+		tenantCacheWarming(w)
+		return
+	}
+	params := r.URL.Query()
+	plans, err := policy.TenantManager.QueryTenants(policy.TenantPlanQuery{
+		NameLike:  params.Get("nameLike"),
+		NameRegex: params.Get("nameRegex"),
+		Org:       params.Get("org"),
+		PlanType:  params.Get("planType"),
+	})
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusBadRequest)
+		return
+	}
+	if err := StreamJSONArray(w, r, http.StatusOK, len(plans), func(i int) interface{} { return plans[i] }); err != nil {
+		log.Errorf("stream tenant plans error %s", err.Error())
+	}
+}
+
+// TenantMetadataHandler merges a JSON merge patch into the tenant metadata, a null value removes the key
+func TenantMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	auditedProxy("update-tenant-metadata", "", tenantMetadataHandler, w, r)
+}
+
+func tenantMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	tenant := mux.Vars(r)["tenant"]
+	var patch map[string]*string
+	decoder := json.NewDecoder(r.Body)
+	defer r.Body.Close()
+	if err := decoder.Decode(&patch); err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusUnprocessableEntity)
+		return
+	}
+	plan, statusCode, err := policy.TenantManager.PatchMetadata(tenant, patch)
+	if err != nil {
+		responseTenantPlanError(err, w, statusCode)
+		return
+	}
+	metadata := plan.Metadata
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
+		return
+	}
+	w.Write(data)
+}
+
+// RetentionSummaryHandler reports the last plan retention enforcement of every tenant, only the drifted tenants with ?drift=true
+func RetentionSummaryHandler(w http.ResponseWriter, r *http.Request) {
+	driftOnly := r.URL.Query().Get("drift") == "true"
+	data, err := json.Marshal(policy.GetRetentionSummaries(driftOnly))
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
+		return
+	}
+	w.Write(data)
+}
+
+// BacklogQuotaHandler reports the namespaces over the backlog quota threshold and their remediations in the last check
+func BacklogQuotaHandler(w http.ResponseWriter, r *http.Request) {
+	data, err := json.Marshal(policy.GetBacklogQuotaStatuses())
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
+		return
+	}
+	w.Write(data)
+}
+
+// SyntheticProbeHandler reports the last synthetic probe of every probed tenant, or of the tenant in the path
+func SyntheticProbeHandler(w http.ResponseWriter, r *http.Request) {
+	data, err := json.Marshal(policy.GetProbeStatuses(mux.Vars(r)["tenant"]))
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
+		return
+	}
+	w.Write(data)
+}
+
+// TenantConnectionsSummary compares the producers and consumers of a tenant to the plan limits, a -1 limit is unlimited
+type TenantConnectionsSummary struct {
+	Tenant            string `json:"tenant"`
+	Producers         uint64 `json:"producers"`
+	Consumers         uint64 `json:"consumers"`
+	Subscriptions     uint64 `json:"subscriptions"`
+	ProducerLimit     int    `json:"producerLimit"`
+	ConsumerLimit     int    `json:"consumerLimit"`
+	OverProducerLimit bool   `json:"overProducerLimit"`
+	OverConsumerLimit bool   `json:"overConsumerLimit"`
+}
+
+// ConnectionsSummaryHandler reports the producers and consumers of every tenant against the plan limits
+func ConnectionsSummaryHandler(w http.ResponseWriter, r *http.Request) {
+	usages, err := metrics.GetTenantsUsage()
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
+		return
+	}
+	summaries := []TenantConnectionsSummary{}
+	for _, u := range usages {
+		summary := TenantConnectionsSummary{
+			Tenant:        u.Name,
+			Producers:     u.Producers,
+			Consumers:     u.Consumers,
+			Subscriptions: u.Subscriptions,
+			ProducerLimit: -1,
+			ConsumerLimit: -1,
+		}
+		if plan, err := policy.TenantManager.GetTenant(u.Name); err == nil {
+			summary.ProducerLimit = plan.Policy.NumOfProducers
+			summary.ConsumerLimit = plan.Policy.NumOfConsumers
+		}
+		summary.OverProducerLimit = summary.ProducerLimit >= 0 && u.Producers > uint64(summary.ProducerLimit)
+		summary.OverConsumerLimit = summary.ConsumerLimit >= 0 && u.Consumers > uint64(summary.ConsumerLimit)
+		summaries = append(summaries, summary)
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].Tenant < summaries[j].Tenant })
+	data, err := json.Marshal(summaries)
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
+		return
+	}
+	w.Write(data)
+}
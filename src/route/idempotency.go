@@ -0,0 +1,238 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package route
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/datastax/burnell/src/util"
+)
+
+// IdempotencyKeyHeader is the request header of the client generated idempotency key
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// the window to store the response of an idempotency key
+var idempotencyWindow = time.Duration(util.GetEnvInt("IdempotencyWindowSeconds", 86400)) * time.Second
+
+// the max number of stored idempotency keys, the least recently used keys are evicted over it, 0 is no limit
+var idempotencyMaxEntries = util.GetEnvInt("IdempotencyMaxEntries", 10000)
+
+// the max total bytes of the stored responses, the least recently used keys are evicted over it, 0 is no limit
+var idempotencyMaxBytes = util.GetEnvInt("IdempotencyMaxBytes", 64<<20)
+
+// the max request body size of a request with an idempotency key
+var idempotencyMaxBodyBytes = int64(util.GetEnvInt("IdempotencyMaxBodyBytes", 1<<20))
+
+// idempotentResponse is the stored response of an idempotency key
+type idempotentResponse struct {
+	storeKey    string
+	requestHash string
+	done        bool
+	status      int
+	header      http.Header
+	body        []byte
+	expiresAt   time.Time
+	element     *list.Element
+}
+
+var (
+	// idempotentResponses is guarded by idempotentResponsesLock, idempotencyLRU orders its keys
+	// from the most to the least recently used
+	idempotentResponses     = make(map[string]*idempotentResponse)
+	idempotencyLRU          = list.New()
+	idempotencyBytes        = 0
+	idempotentResponsesLock = sync.Mutex{}
+	idempotencyCleanupOnce  sync.Once
+)
+
+// SetIdempotencyLimits sets the max number of stored keys and the max total bytes of the stored responses, 0 is no limit
+func SetIdempotencyLimits(maxEntries, maxBytes int) {
+	idempotentResponsesLock.Lock()
+	defer idempotentResponsesLock.Unlock()
+	idempotencyMaxEntries = maxEntries
+	idempotencyMaxBytes = maxBytes
+	evictIdempotentResponses()
+}
+
+// idempotencyRecorder writes through and captures the response
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (i *idempotencyRecorder) WriteHeader(code int) {
+	i.status = code
+	i.ResponseWriter.WriteHeader(code)
+}
+
+func (i *idempotencyRecorder) Write(b []byte) (int, error) {
+	i.body.Write(b)
+	return i.ResponseWriter.Write(b)
+}
+
+// Idempotent replays the stored response for a retried mutating request with the same Idempotency-Key header.
+// The key is scoped by the subject, method and path. A retry while the first request is in flight receives 409,
+// and a reused key with a different query or body receives 422. Server errors are not stored so they can be retried.
+func Idempotent(next http.Handler) http.Handler {
+	return idempotent(next, false)
+}
+
+// IdempotentMint is Idempotent for a GET route that mints a credential, such as the token server,
+// so a retried mint with the same key receives the same credential.
+func IdempotentMint(next http.Handler) http.Handler {
+	return idempotent(next, true)
+}
+
+func idempotent(next http.Handler, withGet bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyKeyHeader)
+		if key == "" || (!withGet && (r.Method == http.MethodGet || r.Method == http.MethodHead)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		idempotencyCleanupOnce.Do(func() { go idempotencyCleanup() })
+
+		var body []byte
+		if r.Body != nil {
+			var err error
+			body, err = ioutil.ReadAll(http.MaxBytesReader(w, r.Body, idempotencyMaxBodyBytes))
+			if err != nil {
+				util.ResponseErrorJSON(fmt.Errorf("request body over %d bytes", idempotencyMaxBodyBytes), w, http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body.Close()
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		requestHash := HashKey(r.URL.RawQuery + "|" + string(body))
+		storeKey := HashKey(r.Header.Get(injectedSubs) + "|" + r.Method + "|" + r.URL.Path + "|" + key)
+
+		idempotentResponsesLock.Lock()
+		if stored, ok := idempotentResponses[storeKey]; ok && time.Now().Before(stored.expiresAt) {
+			idempotencyLRU.MoveToFront(stored.element)
+			idempotentResponsesLock.Unlock()
+			switch {
+			case stored.requestHash != requestHash:
+				util.ResponseErrorJSON(fmt.Errorf("idempotency key %s was used by a different request", key), w, http.StatusUnprocessableEntity)
+			case !stored.done:
+				util.ResponseErrorJSON(fmt.Errorf("request with idempotency key %s is in progress", key), w, http.StatusConflict)
+			default:
+				for k, v := range stored.header {
+					w.Header()[k] = v
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(stored.status)
+				w.Write(stored.body)
+			}
+			return
+		} else if ok {
+			removeIdempotentResponse(stored)
+		}
+		stored := &idempotentResponse{storeKey: storeKey, requestHash: requestHash, expiresAt: time.Now().Add(idempotencyWindow)}
+		stored.element = idempotencyLRU.PushFront(stored)
+		idempotentResponses[storeKey] = stored
+		evictIdempotentResponses()
+		idempotentResponsesLock.Unlock()
+
+		recorder := &idempotencyRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		idempotentResponsesLock.Lock()
+		defer idempotentResponsesLock.Unlock()
+		if idempotentResponses[storeKey] != stored {
+			// evicted while in flight
+			return
+		}
+		if recorder.status >= http.StatusInternalServerError {
+			removeIdempotentResponse(stored)
+			return
+		}
+		stored.done = true
+		stored.status = recorder.status
+		stored.header = w.Header().Clone()
+		stored.body = recorder.body.Bytes()
+		idempotencyBytes += stored.size()
+		evictIdempotentResponses()
+	})
+}
+
+// size is the approximate bytes of a stored response
+func (i *idempotentResponse) size() int {
+	size := len(i.storeKey) + len(i.requestHash) + len(i.body)
+	for k, v := range i.header {
+		size += len(k)
+		for _, s := range v {
+			size += len(s)
+		}
+	}
+	return size
+}
+
+// removeIdempotentResponse removes a stored key, the caller holds idempotentResponsesLock
+func removeIdempotentResponse(stored *idempotentResponse) {
+	delete(idempotentResponses, stored.storeKey)
+	idempotencyLRU.Remove(stored.element)
+	if stored.done {
+		idempotencyBytes -= stored.size()
+	}
+}
+
+// evictIdempotentResponses evicts the least recently used keys over the limits, the caller holds idempotentResponsesLock
+func evictIdempotentResponses() {
+	evicted := 0
+	for (idempotencyMaxEntries > 0 && len(idempotentResponses) > idempotencyMaxEntries) ||
+		(idempotencyMaxBytes > 0 && idempotencyBytes > idempotencyMaxBytes) {
+		e := idempotencyLRU.Back()
+		if e == nil {
+			break
+		}
+		removeIdempotentResponse(e.Value.(*idempotentResponse))
+		evicted++
+	}
+	util.CacheEvicted(util.IdempotencyCache, util.EvictedLRU, evicted)
+	util.CacheSize(util.IdempotencyCache, len(idempotentResponses), idempotencyBytes)
+}
+
+// idempotencyCleanup evicts the expired idempotency keys
+func idempotencyCleanup() {
+	for {
+		time.Sleep(time.Minute)
+		now := time.Now()
+		expired := 0
+		idempotentResponsesLock.Lock()
+		for _, v := range idempotentResponses {
+			if now.After(v.expiresAt) {
+				removeIdempotentResponse(v)
+				expired++
+			}
+		}
+		util.CacheEvicted(util.IdempotencyCache, util.EvictedExpired, expired)
+		util.CacheSize(util.IdempotencyCache, len(idempotentResponses), idempotencyBytes)
+		idempotentResponsesLock.Unlock()
+	}
+}
@@ -0,0 +1,122 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package route
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/datastax/burnell/src/util"
+	"github.com/gorilla/mux"
+)
+
+// RouteInfo describes a registered route
+type RouteInfo struct {
+	Name    string   `json:"name,omitempty"`
+	Methods []string `json:"methods"`
+	Pattern string   `json:"pattern"`
+	// Prefix is true for a path prefix route that matches all the sub paths
+	Prefix bool `json:"prefix"`
+	// Auth is the auth middleware policy, handler means the authorization is done in the handler
+	Auth      string `json:"auth"`
+	RateLimit string `json:"rateLimit"`
+}
+
+// policyHandler tags a handler with the auth policy of the middleware
+type policyHandler struct {
+	policy string
+	http.HandlerFunc
+}
+
+func authPolicy(policy string, f http.HandlerFunc) http.Handler {
+	return policyHandler{policy, f}
+}
+
+// the router of the running process mode
+var activeRouter *mux.Router
+var activeRouterLock sync.RWMutex
+
+func setActiveRouter(router *mux.Router) {
+	activeRouterLock.Lock()
+	defer activeRouterLock.Unlock()
+	activeRouter = router
+}
+
+// ListRoutes lists the routes of a router in the look up order
+func ListRoutes(router *mux.Router) []RouteInfo {
+	routes := []RouteInfo{}
+	router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		pattern, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		regex, _ := route.GetPathRegexp()
+		methods, err := route.GetMethods()
+		if err != nil {
+			methods = []string{"*"}
+		}
+		auth := "handler"
+		if h, ok := route.GetHandler().(policyHandler); ok {
+			auth = h.policy
+		}
+		routes = append(routes, RouteInfo{
+			Name:      route.GetName(),
+			Methods:   methods,
+			Pattern:   pattern,
+			Prefix:    !strings.HasSuffix(regex, "$"),
+			Auth:      auth,
+			RateLimit: routePool(route.GetName()),
+		})
+		return nil
+	})
+	return routes
+}
+
+// RoutesHandler lists the routes exposed by the running process mode
+func RoutesHandler(w http.ResponseWriter, r *http.Request) {
+	activeRouterLock.RLock()
+	router := activeRouter
+	activeRouterLock.RUnlock()
+	if router == nil {
+		util.ResponseErrorJSON(errors.New("router is not set up"), w, http.StatusServiceUnavailable)
+		return
+	}
+	data, err := json.Marshal(ListRoutes(router))
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
+		return
+	}
+	w.Write(data)
+}
+
+// ConfigHandler returns the effective configuration with the secrets redacted and the source of each value
+func ConfigHandler(w http.ResponseWriter, r *http.Request) {
+	data, err := json.Marshal(util.GetConfigReport())
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
+		return
+	}
+	w.Write(data)
+}
@@ -0,0 +1,148 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package route
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/datastax/burnell/src/util"
+)
+
+// NDJSONContentType is the content type of a newline delimited JSON response, one element per line
+const NDJSONContentType = "application/x-ndjson"
+
+// the number of elements written between the flushes of a streamed response
+var jsonStreamFlushElements = util.GetEnvInt("JSONStreamFlushElements", 100)
+
+// wantsNDJSON returns true if the request asks for a newline delimited JSON response,
+// by the Accept header or ?format=ndjson for a download link
+func wantsNDJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), NDJSONContentType) || r.URL.Query().Get("format") == "ndjson"
+}
+
+// jsonStream writes the elements of a response as they are marshaled, and flushes them in chunks
+type jsonStream struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	ndjson  bool
+	count   int
+	err     error
+}
+
+func newJSONStream(w http.ResponseWriter, r *http.Request, status int) *jsonStream {
+	s := &jsonStream{w: w, ndjson: wantsNDJSON(r)}
+	s.flusher, _ = w.(http.Flusher)
+	if s.ndjson {
+		w.Header().Set("Content-Type", NDJSONContentType)
+	}
+	w.WriteHeader(status)
+	return s
+}
+
+func (s *jsonStream) write(data []byte) {
+	if s.err == nil {
+		_, s.err = s.w.Write(data)
+	}
+}
+
+// element writes an element, the JSON document is separated by comma, the NDJSON one by new line
+func (s *jsonStream) element(prefix string, v interface{}, separator string) {
+	if s.err != nil {
+		return
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		s.err = err
+		return
+	}
+	if s.count > 0 {
+		s.write([]byte(separator))
+	}
+	s.write([]byte(prefix))
+	s.write(data)
+	s.count++
+	if s.flusher != nil && jsonStreamFlushElements > 0 && s.count%jsonStreamFlushElements == 0 {
+		s.flusher.Flush()
+	}
+}
+
+// StreamJSONArray replies n elements as a JSON array, or NDJSON if the request asks for it.
+// The JSON array is identical to marshaling the whole slice at once.
+func StreamJSONArray(w http.ResponseWriter, r *http.Request, status, n int, elem func(i int) interface{}) error {
+	s := newJSONStream(w, r, status)
+	if !s.ndjson {
+		s.write([]byte("["))
+	}
+	for i := 0; i < n; i++ {
+		if s.ndjson {
+			s.element("", elem(i), "\n")
+		} else {
+			s.element("", elem(i), ",")
+		}
+	}
+	if s.ndjson {
+		if s.count > 0 {
+			s.write([]byte("\n"))
+		}
+	} else {
+		s.write([]byte("]"))
+	}
+	return s.err
+}
+
+// StreamJSONObject replies a map of lists as a JSON object in the sorted key order, or NDJSON of one
+// {"<keyName>": key, "<valueName>": value} element per key if the request asks for it.
+// The JSON object is identical to marshaling the whole map at once.
+func StreamJSONObject(w http.ResponseWriter, r *http.Request, status int, m map[string][]string, keyName, valueName string) error {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	s := newJSONStream(w, r, status)
+	if !s.ndjson {
+		s.write([]byte("{"))
+	}
+	for _, k := range keys {
+		if s.ndjson {
+			s.element("", map[string]interface{}{keyName: k, valueName: m[k]}, "\n")
+			continue
+		}
+		key, err := json.Marshal(k)
+		if err != nil {
+			return err
+		}
+		s.element(string(key)+":", m[k], ",")
+	}
+	if s.ndjson {
+		if s.count > 0 {
+			s.write([]byte("\n"))
+		}
+	} else {
+		s.write([]byte("}"))
+	}
+	return s.err
+}
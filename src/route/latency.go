@@ -0,0 +1,77 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package route
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	requestLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "burnell",
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "The total latency of the requests served by burnell, including the upstream calls.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route"})
+	upstreamLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "burnell",
+		Subsystem: "upstream",
+		Name:      "request_duration_seconds",
+		Help:      "The latency of the upstream calls proxied by burnell, i.e. the broker admin REST API.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "upstream"})
+)
+
+func init() {
+	prometheus.MustRegister(requestLatency, upstreamLatency)
+}
+
+// routeLabel is the route name, or the path template of an unnamed route
+func routeLabel(r *http.Request) string {
+	route := currentRoute(r)
+	if route == nil {
+		return ""
+	}
+	if name := route.GetName(); name != "" {
+		return name
+	}
+	template, _ := route.GetPathTemplate()
+	return template
+}
+
+// RequestLatency is the middleware to observe the total latency of every request by the route
+func RequestLatency(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		next.ServeHTTP(w, r)
+		requestLatency.WithLabelValues(routeLabel(r)).Observe(time.Since(start).Seconds())
+	})
+}
+
+// observeUpstream observes the latency of an upstream call by the route and the upstream host
+func observeUpstream(r *http.Request, upstream string, d time.Duration) {
+	upstreamLatency.WithLabelValues(routeLabel(r), upstream).Observe(d.Seconds())
+}
@@ -0,0 +1,176 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package route
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/datastax/burnell/src/util"
+)
+
+// the query parameters of the burnell side pagination of the broker admin list endpoints
+const (
+	pageLimitParam = "limit"
+	pageTokenParam = "pageToken"
+)
+
+var (
+	listPageDefaultLimit = util.GetEnvInt("ListPageDefaultLimit", 100)
+	listPageMaxLimit     = util.GetEnvInt("ListPageMaxLimit", 1000)
+	listPageCacheTTL     = time.Duration(util.GetEnvInt("ListPageCacheSeconds", 10)) * time.Second
+)
+
+// ErrInvalidPageToken is the error of a page token not issued by a previous page
+var ErrInvalidPageToken = errors.New("invalid pageToken")
+
+// PagedListResponse is a page of a broker admin list response
+type PagedListResponse struct {
+	Items         []json.RawMessage `json:"items"`
+	Total         int               `json:"total"`
+	NextPageToken string            `json:"nextPageToken,omitempty"`
+}
+
+type cachedList struct {
+	status  int
+	body    []byte
+	expires time.Time
+}
+
+var (
+	listCache     = map[string]cachedList{}
+	listCacheLock = sync.Mutex{}
+)
+
+// PaginateList returns the page of the JSON array after the page token, the items are sorted for a stable order.
+// The page token is the last item of the previous page so that the pages stay consistent as the list changes.
+func PaginateList(body []byte, limit int, pageToken string) (PagedListResponse, error) {
+	items := []json.RawMessage{}
+	if err := json.Unmarshal(body, &items); err != nil {
+		return PagedListResponse{}, err
+	}
+	sort.Slice(items, func(i, j int) bool { return bytes.Compare(items[i], items[j]) < 0 })
+
+	start := 0
+	if pageToken != "" {
+		after, err := base64.RawURLEncoding.DecodeString(pageToken)
+		if err != nil || len(after) == 0 {
+			return PagedListResponse{}, ErrInvalidPageToken
+		}
+		start = sort.Search(len(items), func(i int) bool { return bytes.Compare(items[i], after) > 0 })
+	}
+	end := start + limit
+	if end > len(items) {
+		end = len(items)
+	}
+
+	page := PagedListResponse{Items: items[start:end], Total: len(items)}
+	if end < len(items) && end > start {
+		page.NextPageToken = base64.RawURLEncoding.EncodeToString(items[end-1])
+	}
+	return page, nil
+}
+
+// isPagedRequest returns if the GET request asks for the burnell side pagination
+func isPagedRequest(r *http.Request) bool {
+	params := r.URL.Query()
+	_, limit := params[pageLimitParam]
+	_, token := params[pageTokenParam]
+	return limit || token
+}
+
+// pageLimit returns the requested page size within the max limit
+func pageLimit(r *http.Request) int {
+	limit := queryParamInt(r.URL.Query(), pageLimitParam, listPageDefaultLimit)
+	if limit <= 0 || limit > listPageMaxLimit {
+		return listPageMaxLimit
+	}
+	return limit
+}
+
+// cachedListProxy fetches the full list without the pagination parameters, the list is cached for a short TTL
+// so that the following pages are served from the same snapshot without calling the broker.
+func cachedListProxy(r *http.Request) ([]byte, int, error) {
+	listRequest := r.Clone(r.Context())
+	params := listRequest.URL.Query()
+	params.Del(pageLimitParam)
+	params.Del(pageTokenParam)
+	listRequest.URL.RawQuery = params.Encode()
+	listRequest.RequestURI = listRequest.URL.RequestURI()
+	key := targetCluster(r) + listRequest.URL.RequestURI()
+
+	now := time.Now()
+	listCacheLock.Lock()
+	if cached, ok := listCache[key]; ok && now.Before(cached.expires) {
+		listCacheLock.Unlock()
+		return cached.body, cached.status, nil
+	}
+	listCacheLock.Unlock()
+
+	body, status, err := cachedGetProxy(listRequest)
+	if err != nil || status != http.StatusOK {
+		return body, status, err
+	}
+
+	listCacheLock.Lock()
+	defer listCacheLock.Unlock()
+	for k, cached := range listCache {
+		if !now.Before(cached.expires) {
+			delete(listCache, k)
+		}
+	}
+	listCache[key] = cachedList{status: status, body: body, expires: now.Add(listPageCacheTTL)}
+	return body, status, nil
+}
+
+// writeListPage replies the page of a JSON array response, other responses are replied as they are
+func writeListPage(w http.ResponseWriter, r *http.Request, status int, data []byte) {
+	if status != http.StatusOK {
+		w.WriteHeader(status)
+		w.Write(data)
+		return
+	}
+	page, err := PaginateList(data, pageLimit(r), r.URL.Query().Get(pageTokenParam))
+	if err == ErrInvalidPageToken {
+		util.ResponseErrorJSON(err, w, http.StatusBadRequest)
+		return
+	} else if err != nil {
+		// not a list endpoint
+		w.WriteHeader(status)
+		w.Write(data)
+		return
+	}
+	body, err := json.Marshal(page)
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
@@ -0,0 +1,124 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package route
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/apex/log"
+	"github.com/datastax/burnell/src/logclient"
+	"github.com/datastax/burnell/src/logstream"
+	"github.com/datastax/burnell/src/policy"
+	"github.com/datastax/burnell/src/util"
+)
+
+// tenantMasker is the compiled masker of a tenant for the rules in the key
+type tenantMasker struct {
+	global *logstream.Masker
+	key    string
+	masker *logstream.Masker
+}
+
+var (
+	tenantMaskers     = map[string]tenantMasker{}
+	tenantMaskersLock = sync.Mutex{}
+)
+
+// tenantLogMasker returns the masker of the global rules and the tenant rules in the plan metadata,
+// it is recompiled once the rules are changed. An invalid tenant rule is skipped with the global rules only.
+func tenantLogMasker(tenant string) *logstream.Masker {
+	global := logstream.LogMasker()
+	plan, err := policy.TenantManager.GetTenant(tenant)
+	if err != nil {
+		return global
+	}
+	rules := policy.TenantLogMaskRules(plan.Metadata)
+	if len(rules) == 0 {
+		return global
+	}
+	parts := make([]string, 0, len(rules))
+	for _, r := range rules {
+		parts = append(parts, r.Name+"="+r.Pattern)
+	}
+	key := strings.Join(parts, "\n")
+
+	tenantMaskersLock.Lock()
+	defer tenantMaskersLock.Unlock()
+	if cached, ok := tenantMaskers[tenant]; ok && cached.global == global && cached.key == key {
+		return cached.masker
+	}
+	masker, err := global.With(rules)
+	if err != nil {
+		log.Errorf("tenant %s log masking rules error %v", tenant, err)
+		return global
+	}
+	tenantMaskers[tenant] = tenantMasker{global: global, key: key, masker: masker}
+	return masker
+}
+
+// maskedLogReader reads the function logs of the tenant with the sensitive data masked
+func maskedLogReader(tenant string, reader logclient.LogReader) logclient.LogReader {
+	masker := tenantLogMasker(tenant)
+	return func(ctx context.Context, functionName, workerID string, instanceID int, rd logclient.FunctionLogRequest) (logclient.FunctionLogResponse, error) {
+		res, err := reader(ctx, functionName, workerID, instanceID, rd)
+		res.Logs = masker.Mask(res.Logs)
+		return res, err
+	}
+}
+
+// isClientSubject evaluates the subject is a tenant client subject such as ming-luo-client-12345qbc
+func isClientSubject(subject string) bool {
+	if util.StrContains(util.SuperRoles, subject) {
+		return false
+	}
+	parts := strings.Split(subject, subDelimiter)
+	return len(parts) > 2 && parts[len(parts)-2] == "client"
+}
+
+// requestLogLevel returns the lowest function log level returned to the request subject. The client subjects get
+// the ClientLogLevel, and the superusers, the admin and the other tenant subjects get the full logs.
+// The level query parameter can only raise it.
+func requestLogLevel(r *http.Request) (logstream.LogLevel, error) {
+	level, err := logstream.ParseLogLevel(r.URL.Query().Get("level"))
+	if err != nil {
+		return level, err
+	}
+	if floor := logstream.ClientLogLevel(); isClientSubject(r.Header.Get(injectedSubs)) && floor > level {
+		level = floor
+	}
+	return level, nil
+}
+
+// levelFilteredLogReader reads the function logs at or above the level, the log positions are of the unfiltered logs
+func levelFilteredLogReader(level logstream.LogLevel, reader logclient.LogReader) logclient.LogReader {
+	if level == logstream.AllLevels {
+		return reader
+	}
+	return func(ctx context.Context, functionName, workerID string, instanceID int, rd logclient.FunctionLogRequest) (logclient.FunctionLogResponse, error) {
+		res, err := reader(ctx, functionName, workerID, instanceID, rd)
+		res.Logs = logstream.FilterLogLevel(res.Logs, level)
+		return res, err
+	}
+}
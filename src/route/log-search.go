@@ -0,0 +1,88 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package route
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/datastax/burnell/src/logclient"
+	"github.com/datastax/burnell/src/util"
+	"github.com/gorilla/mux"
+)
+
+// maxLogSearchWindow is the max since window of a tenant log search
+const maxLogSearchWindow = 7 * 24 * time.Hour
+
+// FunctionLogSearchHandler searches the logs of all the tenant function instances with the query parameter q,
+// within the since window (default 1h). The matched lines are streamed as newline delimited json sorted by timestamp.
+// The logs are masked and filtered by the subject log level before the match so a query cannot probe the masked
+// or the filtered data.
+func FunctionLogSearchHandler(w http.ResponseWriter, r *http.Request) {
+	tenant := mux.Vars(r)["tenant"]
+	params := r.URL.Query()
+	query := queryParamString(params, "q", "")
+	if query == "" {
+		util.ResponseErrorJSON(errors.New("missing query parameter q"), w, http.StatusBadRequest)
+		return
+	}
+	since, err := time.ParseDuration(queryParamString(params, "since", "1h"))
+	if err != nil || since <= 0 || since > maxLogSearchWindow {
+		util.ResponseErrorJSON(errors.New("since must be a positive duration up to "+maxLogSearchWindow.String()), w, http.StatusBadRequest)
+		return
+	}
+
+	level, err := requestLogLevel(r)
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusBadRequest)
+		return
+	}
+
+	matches, errs := logclient.SearchTenantLogs(r.Context(), tenant, logclient.LogSearchRequest{
+		Query:       query,
+		Since:       since,
+		Bytes:       int64(util.GetEnvInt("LogSearchBytes", 1024*1024)),
+		Concurrency: util.GetEnvInt("LogSearchConcurrency", 8),
+	}, levelFilteredLogReader(level, maskedLogReader(tenant, logclient.GetFunctionLog)))
+	for _, e := range errs {
+		log.WithField("app", "FunctionLogSearch").Warnf("tenant %s %v", tenant, e)
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("X-Burnell-Failed-Instances", strconv.Itoa(len(errs)))
+	w.Header().Set("X-Burnell-Log-Level", level.String())
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+	for i, m := range matches {
+		if err := encoder.Encode(m); err != nil {
+			return
+		}
+		if flusher != nil && i%100 == 99 {
+			flusher.Flush()
+		}
+	}
+}
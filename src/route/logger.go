@@ -22,24 +22,255 @@
 package route
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"strconv"
+	"sync"
 	"time"
+
+	"github.com/datastax/burnell/src/util"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// TextLogFormat is the tab separated method, uri, route name and latency
+	TextLogFormat = "text"
+	// JSONLogFormat is one AccessLogEntry JSON object per line
+	JSONLogFormat = "json"
+	// CombinedLogFormat is the Apache combined log format followed by tenant, latency and upstream duration
+	CombinedLogFormat = "combined"
 )
 
+// AccessLogEntry is a structured HTTP access log entry
+type AccessLogEntry struct {
+	Time       time.Time `json:"time"`
+	RemoteAddr string    `json:"remoteAddr"`
+	Method     string    `json:"method"`
+	URI        string    `json:"uri"`
+	Proto      string    `json:"proto"`
+	Route      string    `json:"route"`
+	Tenant     string    `json:"tenant,omitempty"`
+	Subject    string    `json:"subject,omitempty"`
+	Status     int       `json:"status"`
+	Bytes      int       `json:"bytes"`
+	LatencyMs  float64   `json:"latencyMs"`
+	UpstreamMs float64   `json:"upstreamMs,omitempty"`
+	Referer    string    `json:"referer,omitempty"`
+	UserAgent  string    `json:"userAgent,omitempty"`
+
+	upstream time.Duration
+	lock     sync.Mutex
+}
+
+type accessLogKey struct{}
+
+var (
+	accessLogOnce   sync.Once
+	accessLogWriter io.Writer = os.Stdout
+	accessLogLock   = sync.Mutex{}
+)
+
+// accessWriter records the response status code and bytes, and passes through streaming and hijacking
+type accessWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (a *accessWriter) WriteHeader(code int) {
+	a.status = code
+	a.ResponseWriter.WriteHeader(code)
+}
+
+func (a *accessWriter) Write(b []byte) (int, error) {
+	n, err := a.ResponseWriter.Write(b)
+	a.bytes += n
+	return n, err
+}
+
+func (a *accessWriter) Flush() {
+	if f, ok := a.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the underlying response writer for http.ResponseController
+func (a *accessWriter) Unwrap() http.ResponseWriter {
+	return a.ResponseWriter
+}
+
+func (a *accessWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := a.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, fmt.Errorf("the response writer does not support hijacking")
+}
+
+// rotatingFile is a file rotated to .1, .2 and so on when it exceeds the max size
+type rotatingFile struct {
+	path       string
+	maxBytes   int64
+	maxBackups int
+	size       int64
+	file       *os.File
+}
+
+func newRotatingFile(path string, maxBytes int64, maxBackups int) (*rotatingFile, error) {
+	f := &rotatingFile{path: path, maxBytes: maxBytes, maxBackups: maxBackups}
+	return f, f.open()
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	if f.maxBytes > 0 && f.size+int64(len(p)) > f.maxBytes {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *rotatingFile) rotate() error {
+	f.file.Close()
+	for i := f.maxBackups - 1; i > 0; i-- {
+		os.Rename(f.path+"."+strconv.Itoa(i), f.path+"."+strconv.Itoa(i+1))
+	}
+	if f.maxBackups > 0 {
+		os.Rename(f.path, f.path+".1")
+	} else {
+		os.Remove(f.path)
+	}
+	return f.open()
+}
+
+func initAccessLog() {
+	file := util.GetConfig().AccessLogFile
+	if file == "" {
+		return
+	}
+	maxBytes := int64(util.GetEnvInt("AccessLogMaxSizeMB", 100)) * 1024 * 1024
+	writer, err := newRotatingFile(file, maxBytes, util.GetEnvInt("AccessLogMaxBackups", 5))
+	if err != nil {
+		log.Printf("failed to open access log file %s, fall back to stdout, error %v", file, err)
+		return
+	}
+	accessLogWriter = writer
+}
+
+// recordUpstream adds the upstream duration of a proxied call to the access log entry of the request,
+// and observes it in the upstream latency histogram and the tenant SLA
+func recordUpstream(r *http.Request, upstream string, d time.Duration, failed bool) {
+	if failed && r.Context().Err() == context.Canceled {
+		// the client disconnected, it is not the upstream to blame
+		failed = false
+	}
+	observeUpstream(r, upstream, d)
+	observeUpstreamResult(r, failed)
+	if AdminUpstreams != nil {
+		AdminUpstreams.Observe(upstream, d, failed, time.Now())
+	}
+	if entry, ok := r.Context().Value(accessLogKey{}).(*AccessLogEntry); ok {
+		entry.lock.Lock()
+		entry.upstream += d
+		entry.lock.Unlock()
+	}
+}
+
+// FormatAccessLog formats the access log entry in the text, json or combined format
+func FormatAccessLog(format string, e *AccessLogEntry) string {
+	switch format {
+	case JSONLogFormat:
+		data, _ := json.Marshal(e)
+		return string(data)
+	case CombinedLogFormat:
+		host, _, err := net.SplitHostPort(e.RemoteAddr)
+		if err != nil {
+			host = e.RemoteAddr
+		}
+		return fmt.Sprintf("%s - %s [%s] \"%s %s %s\" %d %d \"%s\" \"%s\" %s %.3f %.3f",
+			host, util.AssignString(e.Subject, "-"), e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+			e.Method, e.URI, e.Proto, e.Status, e.Bytes, util.AssignString(e.Referer, "-"), util.AssignString(e.UserAgent, "-"),
+			util.AssignString(e.Tenant, "-"), e.LatencyMs, e.UpstreamMs)
+	default:
+		return fmt.Sprintf("%s\t%s\t%s\t%s", e.Method, e.URI, e.Route, time.Duration(e.LatencyMs*float64(time.Millisecond)))
+	}
+}
+
+// AccessLog is the middleware logs http traffic of all routes by the route name
+func AccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := ""
+		if route := currentRoute(r); route != nil {
+			name = route.GetName()
+		}
+		Logger(next, name).ServeHTTP(w, r)
+	})
+}
+
 // Logger logs http traffic.
 func Logger(inner http.Handler, name string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// already logged by the AccessLog middleware
+		if _, ok := r.Context().Value(accessLogKey{}).(*AccessLogEntry); ok {
+			inner.ServeHTTP(w, r)
+			return
+		}
+		format := util.AssignString(util.GetConfig().AccessLogFormat, TextLogFormat)
+		accessLogOnce.Do(initAccessLog)
+
 		start := time.Now()
+		entry := &AccessLogEntry{
+			Time:       start,
+			RemoteAddr: r.RemoteAddr,
+			Method:     r.Method,
+			URI:        r.RequestURI,
+			Proto:      r.Proto,
+			Route:      name,
+			Tenant:     mux.Vars(r)["tenant"],
+			Referer:    r.Referer(),
+			UserAgent:  r.UserAgent(),
+		}
+		writer := &accessWriter{ResponseWriter: w, status: http.StatusOK}
+
+		inner.ServeHTTP(writer, r.WithContext(context.WithValue(r.Context(), accessLogKey{}, entry)))
 
-		inner.ServeHTTP(w, r)
+		entry.lock.Lock()
+		entry.Subject = r.Header.Get(injectedSubs)
+		entry.Status = writer.status
+		entry.Bytes = writer.bytes
+		entry.LatencyMs = float64(time.Since(start)) / float64(time.Millisecond)
+		entry.UpstreamMs = float64(entry.upstream) / float64(time.Millisecond)
+		line := FormatAccessLog(format, entry)
+		entry.lock.Unlock()
 
-		log.Printf(
-			"%s\t%s\t%s\t%s",
-			r.Method,
-			r.RequestURI,
-			name,
-			time.Since(start),
-		)
+		accessLogLock.Lock()
+		if format == TextLogFormat && accessLogWriter == os.Stdout {
+			log.Print(line)
+		} else {
+			fmt.Fprintln(accessLogWriter, line)
+		}
+		accessLogLock.Unlock()
 	})
 }
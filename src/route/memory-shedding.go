@@ -0,0 +1,68 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package route
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/datastax/burnell/src/util"
+)
+
+// the heaviest route classes shed over the memory watermark, the log downloads and the federated scrapes
+var memoryShedRoutes = map[string]bool{
+	"function-logs":  true,
+	"pulsar metrics": true,
+}
+
+// ShedOnMemoryPressure rejects the log downloads and the federated metrics scrapes with 503 while the process memory
+// is over the watermark, so that a traffic spike does not get the process killed out of memory
+func ShedOnMemoryPressure(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !util.Memory.Shedding() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		name := ""
+		if route := currentRoute(r); route != nil {
+			name = route.GetName()
+		}
+		if !memoryShedRoutes[name] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		util.Memory.Shed(name)
+		w.Header().Set("Retry-After", strconv.Itoa(util.GetEnvInt("MemoryWatermarkIntervalSeconds", 5)))
+		util.ResponseErrorJSON(util.NewReasonError(util.ReasonMemoryPressure, "Server is low on memory"), w, http.StatusServiceUnavailable)
+	})
+}
+
+// MemoryWatermarkHandler returns the last process memory sample against the watermark
+func MemoryWatermarkHandler(w http.ResponseWriter, r *http.Request) {
+	data, err := json.Marshal(util.Memory.Status())
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
+		return
+	}
+	w.Write(data)
+}
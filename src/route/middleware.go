@@ -23,36 +23,43 @@ package route
 
 //middleware includes auth, rate limit, and etc.
 import (
+	"crypto/subtle"
+	"errors"
+	"math"
 	"net/http"
+	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/apex/log"
+	"github.com/datastax/burnell/src/policy"
 	"github.com/datastax/burnell/src/util"
+	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/gorilla/mux"
 )
 
 // Rate is the default global rate limit
 // This rate only limits the rate hitting on endpoint
 // It does not limit the underline resource access
-var Rate = NewSema(200)
+var Rate = NewLimiter(util.GetEnvInt("RateLimit", 200), util.GetEnvInt("RateLimitPerTenant", 0))
 
 // AuthVerifyJWT Authenticate middleware function that extracts the subject in JWT
 func AuthVerifyJWT(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	return authPolicy("jwt", func(w http.ResponseWriter, r *http.Request) {
 		if !util.IsPulsarJWTEnabled() {
 			r.Header.Set(injectedSubs, util.DummySuperRole)
 			next.ServeHTTP(w, r)
 			return
 		}
-		tokenStr := strings.TrimSpace(strings.Replace(r.Header.Get("Authorization"), "Bearer", "", 1))
-		subjects, err := util.JWTAuth.GetTokenSubject(tokenStr)
+		subjects, err := verifyToken(r)
 
 		if err == nil {
 			log.Infof("Authenticated with subjects %s", subjects)
 			r.Header.Set(injectedSubs, subjects)
 			next.ServeHTTP(w, r)
 		} else {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			auditAuthFailure(r, "", "invalid token")
+			util.ResponseErrorJSON(tokenError(err, "Unauthorized"), w, http.StatusUnauthorized)
 		}
 
 	})
@@ -60,17 +67,17 @@ func AuthVerifyJWT(next http.Handler) http.Handler {
 
 // AuthVerifyTenantJWT Authenticate middleware function that extracts the subject in JWT
 func AuthVerifyTenantJWT(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	return authPolicy("tenant-jwt", func(w http.ResponseWriter, r *http.Request) {
 		if !util.IsPulsarJWTEnabled() {
 			r.Header.Set(injectedSubs, util.DummySuperRole)
 			next.ServeHTTP(w, r)
 			return
 		}
-		tokenStr := strings.TrimSpace(strings.Replace(r.Header.Get("Authorization"), "Bearer", "", 1))
-		subjects, err := util.JWTAuth.GetTokenSubject(tokenStr)
+		subjects, err := verifyToken(r)
 
 		if err != nil {
-			http.Error(w, "failed to obtain subject", http.StatusUnauthorized)
+			auditAuthFailure(r, "", "invalid token")
+			util.ResponseErrorJSON(tokenError(err, "failed to obtain subject"), w, http.StatusUnauthorized)
 			return
 		}
 
@@ -79,47 +86,106 @@ func AuthVerifyTenantJWT(next http.Handler) http.Handler {
 		vars := mux.Vars(r)
 		if tenantName, ok := vars["tenant"]; ok {
 			if VerifySubject(tenantName, subjects) {
+				if tenantSuspended(tenantName, subjects, r.Method) {
+					util.ResponseErrorJSON(util.NewReasonError(util.ReasonTenantSuspended, "tenant "+tenantName+" is suspended"), w, http.StatusForbidden)
+					return
+				}
 				next.ServeHTTP(w, r)
 				return
 			}
 			log.Errorf("Authenticated subjects %s does not match tenant %s", subjects, tenantName)
 		}
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		auditAuthFailure(r, subjects, "subject does not match tenant")
+		util.ResponseErrorJSON(util.NewReasonError(util.ReasonTenantAccessDenied, "Unauthorized"), w, http.StatusUnauthorized)
 		return
 
 	})
 }
 
+// tokenError is the token verification failure with the reason code of an expired or an invalid token
+func tokenError(err error, message string) error {
+	var vErr *jwt.ValidationError
+	if errors.As(err, &vErr) && vErr.Errors&jwt.ValidationErrorExpired != 0 {
+		return util.NewReasonError(util.ReasonTokenExpired, message)
+	}
+	return util.NewReasonError(util.ReasonTokenInvalid, message)
+}
+
+// tenantSuspended evaluates if the request is a change to a suspended tenant by a tenant subject, the reads are allowed
+func tenantSuspended(tenant, subjects, method string) bool {
+	if method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions {
+		return false
+	}
+	if _, role := ExtractTenant(subjects); util.StrContains(util.SuperRoles, role) {
+		return false
+	}
+	plan, err := policy.TenantManager.GetTenant(tenant)
+	return err == nil && plan.TenantStatus == policy.Suspended
+}
+
 // SuperRoleRequired ensures token has the super user subject
 func SuperRoleRequired(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	return authPolicy("superuser", func(w http.ResponseWriter, r *http.Request) {
 		if !util.IsPulsarJWTEnabled() {
 			r.Header.Set(injectedSubs, util.DummySuperRole)
 			next.ServeHTTP(w, r)
 			return
 		}
-		tokenStr := strings.TrimSpace(strings.Replace(r.Header.Get("Authorization"), "Bearer", "", 1))
-		subject, err := util.JWTAuth.GetTokenSubject(tokenStr)
+		subject, err := verifyToken(r)
 
 		if err == nil && util.StrContains(util.SuperRoles, subject) {
 			log.Infof("superroles Authenticated")
+			r.Header.Set(injectedSubs, subject)
 			next.ServeHTTP(w, r)
+		} else if err != nil {
+			auditAuthFailure(r, "", "invalid token")
+			util.ResponseErrorJSON(tokenError(err, "Unauthorized"), w, http.StatusUnauthorized)
 		} else {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			auditAuthFailure(r, subject, "superuser required")
+			util.ResponseErrorJSON(util.NewReasonError(util.ReasonSuperuserRequired, "Unauthorized"), w, http.StatusUnauthorized)
 		}
 
 	})
 }
 
+// ScrapeAuthVerifyJWT authorizes federated metrics scrape.
+// A scrape without token is only allowed from the allowlisted CIDRs, or with the dedicated scrape token,
+// both are authorized as superuser to scrape all metrics for the cluster Prometheus.
+// Otherwise the subject is extracted from the JWT.
+func ScrapeAuthVerifyJWT(next http.Handler) http.Handler {
+	jwtAuth := AuthVerifyJWT(next)
+	return authPolicy("scrape", func(w http.ResponseWriter, r *http.Request) {
+		tokenStr := strings.TrimSpace(strings.Replace(r.Header.Get("Authorization"), "Bearer", "", 1))
+		scrapeToken := util.GetConfig().ScrapeToken
+		if tokenStr != "" && scrapeToken != "" && subtle.ConstantTimeCompare([]byte(tokenStr), []byte(scrapeToken)) == 1 {
+			r.Header.Set(injectedSubs, util.SuperRoles[0])
+			next.ServeHTTP(w, r)
+			return
+		}
+		if tokenStr == "" && util.GetConfig().ScrapeAllowedCIDRs != "" {
+			if util.IPInCIDRs(r.RemoteAddr, util.GetConfig().ScrapeAllowedCIDRs) {
+				r.Header.Set(injectedSubs, util.SuperRoles[0])
+				next.ServeHTTP(w, r)
+				return
+			}
+			log.Errorf("anonymous scrape from %s is not allowlisted", r.RemoteAddr)
+			auditAuthFailure(r, "", "anonymous scrape is not allowlisted")
+			util.ResponseErrorJSON(errors.New("Unauthorized"), w, http.StatusUnauthorized)
+			return
+		}
+		jwtAuth.ServeHTTP(w, r)
+	})
+}
+
 // AuthHeaderRequired is a very weak auth to verify token existence only.
 func AuthHeaderRequired(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	return authPolicy("auth-header", func(w http.ResponseWriter, r *http.Request) {
 		tokenStr := strings.TrimSpace(strings.Replace(r.Header.Get("Authorization"), "Bearer", "", 1))
 
 		if len(tokenStr) > 1 {
 			next.ServeHTTP(w, r)
 		} else {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			util.ResponseErrorJSON(errors.New("Unauthorized"), w, http.StatusUnauthorized)
 		}
 
 	})
@@ -127,22 +193,73 @@ func AuthHeaderRequired(next http.Handler) http.Handler {
 
 // NoAuth bypasses the auth middleware
 func NoAuth(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	return authPolicy("none", func(w http.ResponseWriter, r *http.Request) {
 		next.ServeHTTP(w, r)
 	})
 }
 
 // LimitRate rate limites against http handler
-// use semaphore as a simple rate limiter
+// use semaphore as a simple rate limiter, heavy routes are limited by their own bulkhead pool
+// and the tenant requests are also limited by the sustained rate and burst of the tenant plan.
+// An exempted infrastructure caller bypasses them all, or is only limited by its dedicated bucket.
+// The tenant plan rate only counts the violations under the observe quota enforcement.
 func LimitRate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		err := Rate.Acquire()
-		if err != nil {
-			http.Error(w, "Too many requests", http.StatusTooManyRequests)
-		} else {
+		r = withTokenVerification(r)
+		if exemption := matchRateExemption(r); exemption != nil {
+			if !exemption.acquire() {
+				util.ResponseErrorJSON(util.NewReasonError(util.ReasonRateLimited, "Too many requests"), w, http.StatusTooManyRequests)
+				return
+			}
+			defer exemption.release()
 			next.ServeHTTP(w, r)
+			return
+		}
+		tenant := limiterTenant(mux.Vars(r)["tenant"])
+		limiter := routeLimiter(r)
+		if !limiter.Acquire(tenant) {
+			util.ResponseErrorJSON(util.NewReasonError(util.ReasonRateLimited, "Too many requests"), w, http.StatusTooManyRequests)
+			return
+		}
+		defer limiter.Release(tenant)
+		if ok, wait := allowPlanRate(tenant); !ok && enforceQuota(tenant, PlanRateQuota, r.Method+" "+r.URL.Path) {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			util.ResponseErrorJSON(util.NewReasonError(util.ReasonPlanRateExceeded, "Too many requests over the tenant plan rate"), w, http.StatusTooManyRequests)
+			return
 		}
-		Rate.Release()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limiterTenant returns the tenant of the path if it is a known tenant in the plan cache,
+// otherwise an empty tenant so an unknown tenant name is only counted in the total
+func limiterTenant(tenant string) string {
+	if tenant == "" {
+		return ""
+	}
+	if _, err := policy.TenantManager.GetTenant(tenant); err != nil {
+		return ""
+	}
+	return tenant
+}
+
+// requestIDPattern is a client request ID accepted as is, otherwise a new request ID is generated
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// RequestID sets the client X-Request-ID, or a new one, on the request and the response,
+// it is forwarded to the upstreams and replied in the error response bodies.
+// It also drops the subject header supplied by the client, which only the authentication sets.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(util.RequestIDHeader)
+		if !requestIDPattern.MatchString(id) {
+			id, _ = util.NewUUID()
+		}
+		r.Header.Set(util.RequestIDHeader, id)
+		w.Header().Set(util.RequestIDHeader, id)
+		// the subject is only set by the authentication, never by the client
+		r.Header.Del(injectedSubs)
+		next.ServeHTTP(w, r)
 	})
 }
 
@@ -0,0 +1,57 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package route
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/datastax/burnell/src/policy"
+	"github.com/datastax/burnell/src/util"
+)
+
+// createdNamespace returns the namespace name of a namespace creation request
+// PUT /admin/v2/namespaces/{tenant}/{namespace}
+func createdNamespace(r *http.Request) (string, bool) {
+	if r.Method != http.MethodPut {
+		return "", false
+	}
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/v2/namespaces/"), "/"), "/")
+	if len(parts) != 2 || parts[1] == "" {
+		return "", false
+	}
+	return parts[1], true
+}
+
+// namingPolicyAllowed responds 422 and returns false if the created resource name breaks the tenant plan naming policy.
+// Superusers are not subject to the naming policies.
+func namingPolicyAllowed(tenant, kind, name string, w http.ResponseWriter, r *http.Request) bool {
+	_, role := ExtractTenant(r.Header.Get(injectedSubs))
+	if util.StrContains(util.SuperRoles, role) {
+		return true
+	}
+	if err := policy.ValidateResourceName(tenant, kind, name); err != nil {
+		responseTenantPlanError(err, w, http.StatusUnprocessableEntity)
+		return false
+	}
+	return true
+}
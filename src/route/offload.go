@@ -0,0 +1,116 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package route
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/datastax/burnell/src/policy"
+	"github.com/datastax/burnell/src/util"
+	"github.com/gorilla/mux"
+)
+
+// the minimum offload threshold a tenant can set, -1 disables offload
+var minOffloadThresholdBytes = int64(util.GetEnvInt("MinOffloadThresholdMB", 1024)) * 1024 * 1024
+
+// the offload drivers a tenant can use when AllowedOffloadDrivers is not configured
+const defaultOffloadDrivers = "aws-s3,google-cloud-storage"
+
+// ValidateOffloadThreshold ensures the threshold in bytes is not under the minimum unless offload is disabled by -1
+func ValidateOffloadThreshold(threshold int64) error {
+	if threshold != -1 && threshold < minOffloadThresholdBytes {
+		return fmt.Errorf("offload threshold %d is under the minimum %d bytes", threshold, minOffloadThresholdBytes)
+	}
+	return nil
+}
+
+// ValidateOffloadPolicies ensures the offload policies use an allowed storage driver and the minimum threshold
+func ValidateOffloadPolicies(body []byte) error {
+	var policies map[string]interface{}
+	if err := json.Unmarshal(body, &policies); err != nil {
+		return errors.New("request body requires offload policies")
+	}
+	driver, _ := policies["managedLedgerOffloadDriver"].(string)
+	allowed := strings.Split(util.AssignString(util.GetConfig().AllowedOffloadDrivers, defaultOffloadDrivers), ",")
+	for i := range allowed {
+		allowed[i] = strings.TrimSpace(allowed[i])
+	}
+	if !util.StrContains(allowed, driver) {
+		return fmt.Errorf("offload driver %s is not allowed, allowed drivers are %s", driver, strings.Join(allowed, ","))
+	}
+	if threshold, ok := policies["managedLedgerOffloadThresholdInBytes"].(float64); ok {
+		return ValidateOffloadThreshold(int64(threshold))
+	}
+	return nil
+}
+
+// OffloadPolicyHandler views and sets the namespace tiered storage offload threshold, policies and deletion lag.
+// It requires the tiered-storage feature code under the tenant plan, and the guardrails unless it is a superuser.
+func OffloadPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	tenant := mux.Vars(r)["tenant"]
+	_, role := ExtractTenant(r.Header.Get(injectedSubs))
+	isSuperUser := util.StrContains(util.SuperRoles, role)
+	if !isSuperUser && !policy.TenantManager.EvaluateFeatureCode(tenant, policy.TieredStorage) {
+		util.ResponseErrorJSON(errors.New("tiered storage is not supported under the current plan, please upgrade your plan"), w, http.StatusPaymentRequired)
+		return
+	}
+	if r.Method == http.MethodGet {
+		DirectBrokerProxyHandler(w, r)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		util.ResponseErrorJSON(errors.New("can't read body"), w, http.StatusBadRequest)
+		return
+	}
+	setting := path.Base(r.URL.Path)
+	if !isSuperUser && r.Method != http.MethodDelete {
+		switch setting {
+		case "offloadThreshold":
+			threshold, err := strconv.ParseInt(strings.TrimSpace(string(body)), 10, 64)
+			if err == nil {
+				err = ValidateOffloadThreshold(threshold)
+			}
+			if err != nil {
+				util.ResponseErrorJSON(err, w, http.StatusUnprocessableEntity)
+				return
+			}
+		case "offloadPolicies":
+			if err := ValidateOffloadPolicies(body); err != nil {
+				util.ResponseErrorJSON(err, w, http.StatusUnprocessableEntity)
+				return
+			}
+		}
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	auditedProxy(strings.ToLower(r.Method)+"-"+setting, string(body), DirectBrokerProxyHandler, w, r)
+}
@@ -0,0 +1,90 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package route
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/datastax/burnell/src/notification"
+	"github.com/datastax/burnell/src/policy"
+	"github.com/datastax/burnell/src/util"
+	"github.com/gorilla/mux"
+)
+
+// PartitionedTopicHandler creates a partitioned topic, or updates the partitions of it by POST, with the number of
+// partitions in the request body. The number of partitions is capped by the tenant plan unless it is a superuser.
+func PartitionedTopicHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		util.ResponseErrorJSON(errors.New("can't read body"), w, http.StatusBadRequest)
+		return
+	}
+	partitions, err := strconv.Atoi(strings.TrimSpace(string(body)))
+	if err != nil || partitions < 1 {
+		util.ResponseErrorJSON(errors.New("request body requires a positive number of partitions"), w, http.StatusUnprocessableEntity)
+		return
+	}
+
+	if !namingPolicyAllowed(tenant, policy.TopicResource, vars["topic"], w, r) {
+		return
+	}
+
+	_, role := ExtractTenant(r.Header.Get(injectedSubs))
+	limit := policy.TenantManager.GetPartitionsLimit(tenant)
+	if !util.StrContains(util.SuperRoles, role) && limit >= 0 && partitions > limit &&
+		enforceQuota(tenant, PartitionsQuota, fmt.Sprintf("%d partitions over the limit of %d", partitions, limit)) {
+		policy.TenantManager.NotifyTenant(tenant, notification.QuotaWarning, "partition limit reached",
+			fmt.Sprintf("tenant %s requested %d partitions over the limit of %d partitions under the current plan", tenant, partitions, limit))
+		util.ResponseErrorJSON(util.NewReasonError(util.ReasonQuotaPartitionsExceeded,
+			fmt.Sprintf("over the limit of %d partitions under the current plan, please upgrade your plan", limit)), w, http.StatusPaymentRequired)
+		return
+	}
+
+	domain := util.AssignString(vars["domain"], "persistent")
+	topicPath := fmt.Sprintf("%s/%s/%s/%s", domain, tenant, vars["namespace"], vars["topic"])
+	requestURL := util.SingleJoinSlash(util.Config.BrokerProxyURL, "/admin/v2/"+topicPath+"/partitions")
+	if r.URL.RawQuery != "" {
+		requestURL += "?" + r.URL.RawQuery
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if r.Method == http.MethodPost {
+		auditedProxy("update-partitioned-topic", strconv.Itoa(partitions)+" partitions", func(w http.ResponseWriter, r *http.Request) {
+			httpProxy(requestURL, w, r)
+		}, w, r)
+		return
+	}
+	auditedProxy("create-partitioned-topic", strconv.Itoa(partitions)+" partitions", func(w http.ResponseWriter, r *http.Request) {
+		withTopicPlanDefaults(tenant, topicPath, func(w http.ResponseWriter, r *http.Request) {
+			httpProxy(requestURL, w, r)
+		}, w, r)
+	}, w, r)
+}
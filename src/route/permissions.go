@@ -0,0 +1,88 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package route
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/datastax/burnell/src/util"
+	"github.com/gorilla/mux"
+)
+
+// RoleBelongsToTenant evaluates if the role is the tenant's role, based on the same subject convention as the tenant token
+func RoleBelongsToTenant(tenant, role string) bool {
+	subCase1, subCase2 := ExtractTenant(role)
+	return tenant == subCase1 || tenant == subCase2
+}
+
+// PermissionProxyHandler grants or revokes a namespace or topic permission. A tenant can only grant to or revoke
+// from its own roles to prevent cross tenant permission grants, superusers can grant to any role.
+func PermissionProxyHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	tenant, role := vars["tenant"], vars["role"]
+	if _, subjectRole := ExtractTenant(r.Header.Get(injectedSubs)); !util.StrContains(util.SuperRoles, subjectRole) && !RoleBelongsToTenant(tenant, role) {
+		util.ResponseErrorJSON(fmt.Errorf("role %s does not belong to tenant %s", role, tenant), w, http.StatusForbidden)
+		return
+	}
+	action := "grant-permission"
+	if r.Method == http.MethodDelete {
+		action = "revoke-permission"
+	}
+	if subscription, ok := vars["subscription"]; ok {
+		action += " subscription " + subscription
+	}
+	auditedProxy(action, "role "+role, DirectBrokerProxyHandler, w, r)
+}
+
+// SubscriptionPermissionProxyHandler grants the roles in the request body to consume a subscription of the namespace.
+// A tenant can only grant to its own roles, superusers can grant to any role.
+func SubscriptionPermissionProxyHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		util.ResponseErrorJSON(errors.New("can't read body"), w, http.StatusBadRequest)
+		return
+	}
+	var roles []string
+	if err := json.Unmarshal(body, &roles); err != nil || len(roles) == 0 {
+		util.ResponseErrorJSON(errors.New("request body requires a list of roles"), w, http.StatusUnprocessableEntity)
+		return
+	}
+	if _, subjectRole := ExtractTenant(r.Header.Get(injectedSubs)); !util.StrContains(util.SuperRoles, subjectRole) {
+		for _, role := range roles {
+			if !RoleBelongsToTenant(tenant, role) {
+				util.ResponseErrorJSON(fmt.Errorf("role %s does not belong to tenant %s", role, tenant), w, http.StatusForbidden)
+				return
+			}
+		}
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	auditedProxy("grant-permission subscription "+vars["subscription"], "roles "+strings.Join(roles, ","), DirectBrokerProxyHandler, w, r)
+}
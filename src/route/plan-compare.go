@@ -0,0 +1,95 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package route
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/datastax/burnell/src/policy"
+	"github.com/datastax/burnell/src/util"
+)
+
+// PlanCompareHandler returns the difference of the policy limits and feature codes between the plan types
+// of the from and to query parameters, the unchanged limits are included with all=true
+func PlanCompareHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	from, to := query.Get("from"), query.Get("to")
+	if from == "" || to == "" {
+		util.ResponseErrorJSON(errors.New("from and to query parameters are required"), w, http.StatusUnprocessableEntity)
+		return
+	}
+	all := false
+	if v := query.Get("all"); v != "" {
+		var err error
+		if all, err = strconv.ParseBool(v); err != nil {
+			util.ResponseErrorJSON(errors.New("all query parameter requires true or false"), w, http.StatusUnprocessableEntity)
+			return
+		}
+	}
+	comparison, err := policy.ComparePlans(from, to, all)
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusNotFound)
+		return
+	}
+	data, err := json.Marshal(comparison)
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
+		return
+	}
+	w.Write(data)
+}
+
+// PlanRecommendationsHandler returns the tenants advised to upgrade or downgrade the plan type by the usage history,
+// optionally only of the action query parameter, the window query parameter overrides PlanRecommendationWindowHours
+func PlanRecommendationsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	thresholds := policy.DefaultPlanRecommendationThresholds()
+	if v := query.Get("window"); v != "" {
+		window, err := time.ParseDuration(v)
+		if err != nil || window <= 0 {
+			util.ResponseErrorJSON(errors.New("window query parameter requires a positive duration such as 72h"), w, http.StatusUnprocessableEntity)
+			return
+		}
+		thresholds.Window = window
+	}
+	action := query.Get("action")
+	if action != "" && action != policy.RecommendUpgrade && action != policy.RecommendDowngrade {
+		util.ResponseErrorJSON(errors.New("action query parameter requires upgrade or downgrade"), w, http.StatusUnprocessableEntity)
+		return
+	}
+	recommendations := []policy.PlanRecommendation{}
+	for _, rec := range policy.GetPlanRecommendations(thresholds) {
+		if action == "" || rec.Action == action {
+			recommendations = append(recommendations, rec)
+		}
+	}
+	data, err := json.Marshal(recommendations)
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
+		return
+	}
+	w.Write(data)
+}
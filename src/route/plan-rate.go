@@ -0,0 +1,118 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package route
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/datastax/burnell/src/policy"
+	"github.com/datastax/burnell/src/util"
+)
+
+// PlanRate is the token bucket rate limiter of tenants with the sustained rate and burst of their plans
+var PlanRate = NewPlanRateLimiter()
+
+// planRateEnabled enables the plan based tenant rate limit by PlanRateLimitEnabled, set 0 to disable
+var planRateEnabled = util.GetEnvInt("PlanRateLimitEnabled", 1) > 0
+
+// tokenBucket is the tokens of a tenant refilled at the sustained rate up to the burst
+type tokenBucket struct {
+	tokens    float64
+	last      time.Time
+	throttled uint64
+}
+
+// PlanRateLimiter limits the request rate per tenant. The rate and burst are passed on every call
+// so that a plan change takes effect on the next request.
+type PlanRateLimiter struct {
+	buckets map[string]*tokenBucket
+	lock    sync.Mutex
+}
+
+// NewPlanRateLimiter creates a plan rate limiter
+func NewPlanRateLimiter() *PlanRateLimiter {
+	return &PlanRateLimiter{
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// Allow takes a token of the tenant at the time. A non positive rate is no limit, and the burst is at least the rate.
+// It returns false and the wait time until the next token when the tenant is over the rate.
+func (l *PlanRateLimiter) Allow(tenant string, rate, burst int, now time.Time) (bool, time.Duration) {
+	if rate <= 0 {
+		return true, 0
+	}
+	capacity := float64(burst)
+	if burst < rate {
+		capacity = float64(rate)
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	b, ok := l.buckets[tenant]
+	if !ok {
+		b = &tokenBucket{tokens: capacity, last: now}
+		l.buckets[tenant] = b
+	}
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(capacity, b.tokens+elapsed*float64(rate))
+		b.last = now
+	}
+	if b.tokens > capacity {
+		// the plan has been downgraded
+		b.tokens = capacity
+	}
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	b.throttled++
+	return false, time.Duration((1 - b.tokens) / float64(rate) * float64(time.Second))
+}
+
+// Throttled returns the number of throttled requests per tenant
+func (l *PlanRateLimiter) Throttled() map[string]uint64 {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	throttled := make(map[string]uint64)
+	for k, v := range l.buckets {
+		if v.throttled > 0 {
+			throttled[k] = v.throttled
+		}
+	}
+	return throttled
+}
+
+// allowPlanRate checks the tenant request against the rate of the tenant plan, a tenant without a plan is not limited
+func allowPlanRate(tenant string) (bool, time.Duration) {
+	if !planRateEnabled || tenant == "" {
+		return true, 0
+	}
+	plan, err := policy.TenantManager.GetTenant(tenant)
+	if err != nil {
+		return true, 0
+	}
+	rate, burst := policy.PlanRequestRate(plan)
+	return PlanRate.Allow(tenant, rate, burst, time.Now())
+}
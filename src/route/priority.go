@@ -0,0 +1,372 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package route
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/datastax/burnell/src/audit"
+	"github.com/datastax/burnell/src/util"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// the request priority classes, from the highest to the lowest priority
+const (
+	// SystemPriority is the superuser and the rate limit exempted infrastructure traffic
+	SystemPriority = "system"
+	// ControlPriority is the control plane calls of the tenants
+	ControlPriority = "control"
+	// HeavyPriority is the tenant logs, metrics, usage and stats queries
+	HeavyPriority = "heavy"
+)
+
+// PriorityClasses are the request priority classes in the order of the priority
+var PriorityClasses = []string{SystemPriority, ControlPriority, HeavyPriority}
+
+// the routes never queued, the probes and the long lived connections
+var unscheduledRoutes = map[string]bool{
+	"liveness":              true,
+	"readiness":             true,
+	"metrics":               true,
+	"websocket proxy proxy": true,
+}
+
+var (
+	priorityQueueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "burnell",
+		Subsystem: "priority",
+		Name:      "queue_depth",
+		Help:      "The number of the requests waiting in the priority queue of a class.",
+	}, []string{"class"})
+	priorityRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "burnell",
+		Subsystem: "priority",
+		Name:      "requests_total",
+		Help:      "The number of the scheduled requests by the class and the result, admitted, queued, timeout or rejected.",
+	}, []string{"class", "result"})
+	priorityQueueWait = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "burnell",
+		Subsystem: "priority",
+		Name:      "queue_wait_seconds",
+		Help:      "The time the admitted requests waited in the priority queue of a class.",
+		Buckets:   []float64{0.005, 0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+	}, []string{"class"})
+)
+
+func init() {
+	prometheus.MustRegister(priorityQueueDepth, priorityRequests, priorityQueueWait)
+}
+
+// PriorityClassConfig is the queue of a priority class
+type PriorityClassConfig struct {
+	Class string `json:"class"`
+	// QueueDepth is the max number of the waiting requests, 0 rejects the requests at saturation right away
+	QueueDepth int `json:"queueDepth"`
+	// TimeoutMs is the max time a request waits for an in-flight slot
+	TimeoutMs int `json:"timeoutMs"`
+}
+
+// PriorityConfig is the concurrency of the scheduler and the queues of the classes, 0 concurrency disables the scheduler
+type PriorityConfig struct {
+	Concurrency int                   `json:"concurrency"`
+	Classes     []PriorityClassConfig `json:"classes,omitempty"`
+}
+
+// PriorityClassStats is the queue configuration and the live counters of a priority class
+type PriorityClassStats struct {
+	PriorityClassConfig
+	Queued   int    `json:"queued"`
+	Admitted uint64 `json:"admitted"`
+	TimedOut uint64 `json:"timedOut"`
+	Rejected uint64 `json:"rejected"`
+}
+
+// PriorityStats is the scheduler state
+type PriorityStats struct {
+	Concurrency int                  `json:"concurrency"`
+	InFlight    int                  `json:"inFlight"`
+	Classes     []PriorityClassStats `json:"classes"`
+}
+
+type priorityClass struct {
+	queueDepth int
+	timeout    time.Duration
+	queue      []chan struct{}
+	admitted   uint64
+	timedOut   uint64
+	rejected   uint64
+}
+
+// PriorityScheduler admits the requests up to the concurrency, at saturation the requests wait in the
+// queue of their class and a released in-flight slot goes to the oldest waiter of the highest priority class.
+type PriorityScheduler struct {
+	concurrency int
+	inFlight    int
+	classes     map[string]*priorityClass
+	lock        sync.Mutex
+}
+
+// NewPriorityScheduler creates a scheduler with the same queue depth and the timeouts of the classes
+func NewPriorityScheduler(concurrency, queueDepth int, timeouts map[string]time.Duration) *PriorityScheduler {
+	s := &PriorityScheduler{
+		concurrency: concurrency,
+		classes:     make(map[string]*priorityClass, len(PriorityClasses)),
+	}
+	for _, c := range PriorityClasses {
+		s.classes[c] = &priorityClass{queueDepth: queueDepth, timeout: timeouts[c]}
+	}
+	return s
+}
+
+// Priority is the request scheduler in front of the proxy, disabled by default
+var Priority = NewPriorityScheduler(util.GetEnvInt("PriorityConcurrency", 0), util.GetEnvInt("PriorityQueueDepth", 100), map[string]time.Duration{
+	SystemPriority:  time.Duration(util.GetEnvInt("PrioritySystemTimeoutMs", 10000)) * time.Millisecond,
+	ControlPriority: time.Duration(util.GetEnvInt("PriorityControlTimeoutMs", 5000)) * time.Millisecond,
+	HeavyPriority:   time.Duration(util.GetEnvInt("PriorityHeavyTimeoutMs", 2000)) * time.Millisecond,
+})
+
+func noRelease() {}
+
+// Acquire takes an in-flight slot for the class, or waits in the class queue until a slot is released,
+// the class timeout or the context is done. It returns the release function of the slot, or false.
+func (s *PriorityScheduler) Acquire(ctx context.Context, class string) (func(), bool) {
+	s.lock.Lock()
+	c, ok := s.classes[class]
+	if s.concurrency == 0 || !ok {
+		s.lock.Unlock()
+		return noRelease, true
+	}
+	if s.inFlight < s.concurrency {
+		s.inFlight++
+		c.admitted++
+		s.lock.Unlock()
+		priorityRequests.WithLabelValues(class, "admitted").Inc()
+		return s.release, true
+	}
+	if len(c.queue) >= c.queueDepth {
+		c.rejected++
+		s.lock.Unlock()
+		priorityRequests.WithLabelValues(class, "rejected").Inc()
+		return nil, false
+	}
+	admit := make(chan struct{}, 1)
+	c.queue = append(c.queue, admit)
+	priorityQueueDepth.WithLabelValues(class).Set(float64(len(c.queue)))
+	timeout := c.timeout
+	s.lock.Unlock()
+	priorityRequests.WithLabelValues(class, "queued").Inc()
+
+	start := time.Now()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-admit:
+	case <-timer.C:
+		if s.dequeue(class, admit) {
+			return nil, false
+		}
+		// admitted while timing out
+		<-admit
+	case <-ctx.Done():
+		if s.dequeue(class, admit) {
+			return nil, false
+		}
+		<-admit
+	}
+	priorityQueueWait.WithLabelValues(class).Observe(time.Since(start).Seconds())
+	return s.release, true
+}
+
+// dequeue removes a timed out waiter, it returns false if the waiter has been admitted
+func (s *PriorityScheduler) dequeue(class string, admit chan struct{}) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	c := s.classes[class]
+	for i, w := range c.queue {
+		if w == admit {
+			c.queue = append(c.queue[:i], c.queue[i+1:]...)
+			c.timedOut++
+			priorityQueueDepth.WithLabelValues(class).Set(float64(len(c.queue)))
+			priorityRequests.WithLabelValues(class, "timeout").Inc()
+			return true
+		}
+	}
+	return false
+}
+
+// release releases an in-flight slot and admits the waiters
+func (s *PriorityScheduler) release() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.inFlight > 0 {
+		s.inFlight--
+	}
+	s.dispatch()
+}
+
+// dispatch admits the waiters by the priority while there are free in-flight slots, the lock must be held
+func (s *PriorityScheduler) dispatch() {
+	for _, class := range PriorityClasses {
+		c := s.classes[class]
+		for len(c.queue) > 0 && (s.concurrency == 0 || s.inFlight < s.concurrency) {
+			admit := c.queue[0]
+			c.queue = c.queue[1:]
+			s.inFlight++
+			c.admitted++
+			admit <- struct{}{}
+			priorityQueueDepth.WithLabelValues(class).Set(float64(len(c.queue)))
+			priorityRequests.WithLabelValues(class, "admitted").Inc()
+		}
+	}
+}
+
+// SetConfig adjusts the concurrency and the queues of the classes in the config,
+// the waiters are admitted if the concurrency is raised or the scheduler is disabled
+func (s *PriorityScheduler) SetConfig(cfg PriorityConfig) error {
+	if cfg.Concurrency < 0 {
+		return fmt.Errorf("concurrency %d cannot be negative", cfg.Concurrency)
+	}
+	for _, c := range cfg.Classes {
+		if !util.StrContains(PriorityClasses, c.Class) {
+			return fmt.Errorf("unknown priority class %s, must be one of %s", c.Class, strings.Join(PriorityClasses, ", "))
+		}
+		if c.QueueDepth < 0 {
+			return fmt.Errorf("%s queue depth %d cannot be negative", c.Class, c.QueueDepth)
+		}
+		if c.TimeoutMs < 1 {
+			return fmt.Errorf("%s timeout %d ms must be positive", c.Class, c.TimeoutMs)
+		}
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.concurrency = cfg.Concurrency
+	for _, c := range cfg.Classes {
+		s.classes[c.Class].queueDepth = c.QueueDepth
+		s.classes[c.Class].timeout = time.Duration(c.TimeoutMs) * time.Millisecond
+	}
+	s.dispatch()
+	return nil
+}
+
+// enabled evaluates if the scheduler has a concurrency
+func (s *PriorityScheduler) enabled() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.concurrency > 0
+}
+
+// Stats returns the scheduler configuration and a snapshot of the live counters
+func (s *PriorityScheduler) Stats() PriorityStats {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	stats := PriorityStats{
+		Concurrency: s.concurrency,
+		InFlight:    s.inFlight,
+		Classes:     make([]PriorityClassStats, 0, len(PriorityClasses)),
+	}
+	for _, class := range PriorityClasses {
+		c := s.classes[class]
+		stats.Classes = append(stats.Classes, PriorityClassStats{
+			PriorityClassConfig: PriorityClassConfig{Class: class, QueueDepth: c.queueDepth, TimeoutMs: int(c.timeout / time.Millisecond)},
+			Queued:              len(c.queue),
+			Admitted:            c.admitted,
+			TimedOut:            c.timedOut,
+			Rejected:            c.rejected,
+		})
+	}
+	return stats
+}
+
+// requestPriority returns the priority class of the request, or empty for an unscheduled route.
+// Only a token claiming a superuser subject is verified, the verification is reused by the auth.
+func requestPriority(r *http.Request) string {
+	name := ""
+	if route := currentRoute(r); route != nil {
+		name = route.GetName()
+	}
+	pool := routePool(name)
+	if unscheduledRoutes[name] || pool == WatchPool {
+		return ""
+	}
+	if matchRateExemption(r) != nil || (util.StrContains(util.SuperRoles, claimedSubject(r)) && util.StrContains(util.SuperRoles, tokenSubject(r))) {
+		return SystemPriority
+	}
+	if pool != DefaultPool {
+		return HeavyPriority
+	}
+	return ControlPriority
+}
+
+// Prioritize schedules the requests by their priority class, when the server is saturated
+// the superuser and the control plane calls are admitted before the heavy tenant queries
+func Prioritize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = withTokenVerification(r)
+		if !Priority.enabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		release, ok := Priority.Acquire(r.Context(), requestPriority(r))
+		if !ok {
+			w.Header().Set("Retry-After", "1")
+			util.ResponseErrorJSON(util.NewReasonError(util.ReasonServerSaturated, "Server is saturated"), w, http.StatusServiceUnavailable)
+			return
+		}
+		defer release()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// PriorityHandler returns the priority scheduler state, or adjusts the concurrency and the class queues at runtime
+func PriorityHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPut {
+		var cfg PriorityConfig
+		if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+			util.ResponseErrorJSON(err, w, http.StatusBadRequest)
+			return
+		}
+		if err := Priority.SetConfig(cfg); err != nil {
+			util.ResponseErrorJSON(err, w, http.StatusUnprocessableEntity)
+			return
+		}
+		audit.Record(audit.Event{
+			Subject:  r.Header.Get(injectedSubs),
+			Action:   "set-priority",
+			Resource: r.URL.Path,
+			Detail:   fmt.Sprintf("concurrency %d classes %d", cfg.Concurrency, len(cfg.Classes)),
+			Status:   http.StatusOK,
+		})
+	}
+
+	data, err := json.Marshal(Priority.Stats())
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
+		return
+	}
+	w.Write(data)
+}
@@ -0,0 +1,145 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package route
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/datastax/burnell/src/util"
+)
+
+// ProxyHook transforms the requests and the responses passing through the admin proxy.
+// The proxied request is the upstream request, its headers can be changed, and an error rejects the request.
+type ProxyHook interface {
+	Match(r *http.Request) bool
+	TransformRequest(r, proxied *http.Request) error
+	TransformResponse(r *http.Request, status int, header http.Header, body []byte) (int, []byte)
+}
+
+var proxyHooks []ProxyHook
+var proxyHooksLock sync.RWMutex
+
+// RegisterProxyHook adds a hook applied after the hooks registered before
+func RegisterProxyHook(hook ProxyHook) {
+	proxyHooksLock.Lock()
+	defer proxyHooksLock.Unlock()
+	proxyHooks = append(proxyHooks, hook)
+}
+
+// SetProxyHooks replaces all the hooks
+func SetProxyHooks(hooks []ProxyHook) {
+	proxyHooksLock.Lock()
+	defer proxyHooksLock.Unlock()
+	proxyHooks = hooks
+}
+
+func matchedProxyHooks(r *http.Request) []ProxyHook {
+	proxyHooksLock.RLock()
+	defer proxyHooksLock.RUnlock()
+	hooks := []ProxyHook{}
+	for _, h := range proxyHooks {
+		if h.Match(r) {
+			hooks = append(hooks, h)
+		}
+	}
+	return hooks
+}
+
+// InitProxyHooks registers the ProxyRewrites rules in the configuration
+func InitProxyHooks() error {
+	hooks := []ProxyHook{}
+	for i, rule := range util.GetConfig().ProxyRewrites {
+		hook, err := NewRewriteHook(rule)
+		if err != nil {
+			return fmt.Errorf("ProxyRewrites rule %d: %v", i, err)
+		}
+		hooks = append(hooks, hook)
+	}
+	SetProxyHooks(hooks)
+	return nil
+}
+
+// RewriteHook is the hook of a config declared rewrite rule
+type RewriteHook struct {
+	rule    util.ProxyRewriteRule
+	path    *regexp.Regexp
+	methods []string
+}
+
+// NewRewriteHook compiles a rewrite rule
+func NewRewriteHook(rule util.ProxyRewriteRule) (*RewriteHook, error) {
+	path, err := regexp.Compile(rule.Path)
+	if err != nil {
+		return nil, err
+	}
+	if rule.PathRewrite != "" && rule.Path == "" {
+		return nil, fmt.Errorf("pathRewrite requires path")
+	}
+	methods := []string{}
+	for _, m := range rule.Methods {
+		methods = append(methods, strings.ToUpper(m))
+	}
+	return &RewriteHook{
+		rule:    rule,
+		path:    path,
+		methods: methods,
+	}, nil
+}
+
+// Match matches the request path and method
+func (h *RewriteHook) Match(r *http.Request) bool {
+	if len(h.methods) > 0 && !util.StrContains(h.methods, r.Method) {
+		return false
+	}
+	return h.path.MatchString(r.URL.Path)
+}
+
+// TransformRequest rewrites the upstream request path and headers
+func (h *RewriteHook) TransformRequest(r, proxied *http.Request) error {
+	if h.rule.PathRewrite != "" {
+		proxied.URL.Path = h.path.ReplaceAllString(proxied.URL.Path, h.rule.PathRewrite)
+		proxied.URL.RawPath = ""
+	}
+	for _, name := range h.rule.RemoveRequestHeaders {
+		proxied.Header.Del(name)
+	}
+	for name, value := range h.rule.SetRequestHeaders {
+		proxied.Header.Set(name, os.ExpandEnv(value))
+	}
+	return nil
+}
+
+// TransformResponse rewrites the response headers
+func (h *RewriteHook) TransformResponse(r *http.Request, status int, header http.Header, body []byte) (int, []byte) {
+	for _, name := range h.rule.RemoveResponseHeaders {
+		header.Del(name)
+	}
+	for name, value := range h.rule.SetResponseHeaders {
+		header.Set(name, os.ExpandEnv(value))
+	}
+	return status, body
+}
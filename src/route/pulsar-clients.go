@@ -0,0 +1,136 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package route
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/datastax/burnell/src/audit"
+	"github.com/datastax/burnell/src/util"
+)
+
+// lookupBroker looks up the broker owning the topic
+func lookupBroker(topic string) string {
+	requestURL := util.SingleJoinSlash(util.Config.BrokerProxyURL, "lookup/v2/topic/"+strings.Replace(topic, "://", "/", 1))
+	newRequest, err := http.NewRequest(http.MethodGet, requestURL, nil)
+	if err != nil {
+		return ""
+	}
+	newRequest.Header.Add("X-Proxy", "burnell")
+	newRequest.Header.Add("Authorization", "Bearer "+util.PulsarAuthToken())
+	client := &http.Client{
+		CheckRedirect: util.PreserveHeaderForRedirect,
+		Timeout:       5 * time.Second,
+	}
+	response, err := client.Do(newRequest)
+	if response != nil {
+		defer response.Body.Close()
+	}
+	if err != nil || response.StatusCode != http.StatusOK {
+		return ""
+	}
+	var lookup struct {
+		BrokerURL    string `json:"brokerUrl"`
+		BrokerURLTLS string `json:"brokerUrlTls"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&lookup); err != nil {
+		return ""
+	}
+	return util.AssignString(lookup.BrokerURLTLS, lookup.BrokerURL)
+}
+
+// PulsarClientsHandler returns the connection status of all internal Pulsar clients and their connected brokers
+func PulsarClientsHandler(w http.ResponseWriter, r *http.Request) {
+	clients := util.PulsarClients()
+	for i := range clients {
+		if clients[i].Connected && clients[i].Topic != "" {
+			clients[i].Broker = lookupBroker(clients[i].Topic)
+		}
+	}
+	data, err := json.Marshal(clients)
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
+		return
+	}
+	w.Write(data)
+}
+
+// PulsarTokenHandler returns the status of the Pulsar token of burnell's own clients, POST refreshes the token
+func PulsarTokenHandler(w http.ResponseWriter, r *http.Request) {
+	refresh := r.Method == http.MethodPost
+	status, err := util.GetPulsarTokenStatus(refresh)
+	if refresh {
+		event := audit.Event{
+			Subject:  r.Header.Get(injectedSubs),
+			Action:   "refresh-pulsar-token",
+			Resource: r.URL.Path,
+			Status:   http.StatusOK,
+		}
+		if err != nil {
+			event.Status = http.StatusBadGateway
+			event.Detail = err.Error()
+		}
+		audit.Record(event)
+		if err != nil {
+			util.ResponseErrorJSON(err, w, http.StatusBadGateway)
+			return
+		}
+	}
+	data, err := json.Marshal(status)
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
+		return
+	}
+	w.Write(data)
+}
+
+// ReaderCursorsHandler returns the checkpoint status of the tenant policy and function metadata readers,
+// POST writes the pending checkpoints such as before a planned restart
+func ReaderCursorsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		err := util.FlushReaderCursors()
+		event := audit.Event{
+			Subject:  r.Header.Get(injectedSubs),
+			Action:   "checkpoint-reader-cursors",
+			Resource: r.URL.Path,
+			Status:   http.StatusOK,
+		}
+		if err != nil {
+			event.Status = http.StatusInternalServerError
+			event.Detail = err.Error()
+		}
+		audit.Record(event)
+		if err != nil {
+			util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
+			return
+		}
+	}
+	data, err := json.Marshal(util.ReaderCursors())
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
+		return
+	}
+	w.Write(data)
+}
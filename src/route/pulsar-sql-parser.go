@@ -0,0 +1,427 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package route
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// the kinds of the Presto SQL lexical tokens
+const (
+	sqlIdentifier = iota
+	sqlQuotedIdentifier
+	sqlString
+	sqlNumber
+	sqlSymbol
+)
+
+// sqlToken is a lexical token of a Presto SQL statement.
+// The value is the lower cased unquoted identifier or keyword, the unescaped quoted identifier or string, or the symbol.
+type sqlToken struct {
+	kind  int
+	value string
+}
+
+func (t sqlToken) isKeyword(keywords ...string) bool {
+	if t.kind != sqlIdentifier {
+		return false
+	}
+	for _, v := range keywords {
+		if t.value == v {
+			return true
+		}
+	}
+	return false
+}
+
+func (t sqlToken) isSymbol(symbol string) bool {
+	return t.kind == sqlSymbol && t.value == symbol
+}
+
+func (t sqlToken) isName() bool {
+	return t.kind == sqlIdentifier || t.kind == sqlQuotedIdentifier
+}
+
+// the reserved words ending the relations of a FROM clause, the non-reserved LIMIT and OFFSET can be an alias
+var sqlFromClauseEnd = []string{"where", "group", "having", "order", "union", "intersect", "except"}
+
+// the query context of the parentheses
+const (
+	sqlExpression = iota
+	sqlQuery
+	sqlRelation
+)
+
+type sqlContext struct {
+	kind     int
+	fromList bool
+}
+
+// tokenizeSQL splits a Presto SQL statement into the tokens without the whitespaces and the comments
+func tokenizeSQL(query string) ([]sqlToken, error) {
+	tokens := []sqlToken{}
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f':
+			i++
+		case strings.HasPrefix(query[i:], "--"):
+			if j := strings.IndexByte(query[i:], '\n'); j >= 0 {
+				i += j + 1
+			} else {
+				i = len(query)
+			}
+		case strings.HasPrefix(query[i:], "/*"):
+			j := strings.Index(query[i+2:], "*/")
+			if j < 0 {
+				return nil, errors.New("unterminated comment")
+			}
+			i += j + 4
+		case c == '\'' || c == '"':
+			value, n, err := scanSQLQuoted(query[i:])
+			if err != nil {
+				return nil, err
+			}
+			kind := sqlString
+			if c == '"' {
+				kind = sqlQuotedIdentifier
+			}
+			tokens = append(tokens, sqlToken{kind, value})
+			i += n
+		case c == '`':
+			return nil, errors.New("backquoted identifiers are not supported")
+		case (c == 'u' || c == 'U') && strings.HasPrefix(query[i+1:], "&\""):
+			return nil, errors.New("unicode escaped identifiers are not supported")
+		case (c == 'u' || c == 'U') && strings.HasPrefix(query[i+1:], "&'"), (c == 'x' || c == 'X') && strings.HasPrefix(query[i+1:], "'"):
+			// the unicode string and the binary literals
+			prefix := strings.IndexByte(query[i:], '\'')
+			value, n, err := scanSQLQuoted(query[i+prefix:])
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, sqlToken{sqlString, value})
+			i += prefix + n
+		case isSQLLetter(c) || c == '_':
+			j := i + 1
+			for j < len(query) && (isSQLLetter(query[j]) || isSQLDigit(query[j]) || query[j] == '_' || query[j] == '@' || query[j] == ':') {
+				j++
+			}
+			tokens = append(tokens, sqlToken{sqlIdentifier, strings.ToLower(query[i:j])})
+			i = j
+		case isSQLDigit(c) || (c == '.' && i+1 < len(query) && isSQLDigit(query[i+1])):
+			j := i + 1
+			for j < len(query) && (isSQLDigit(query[j]) || query[j] == '.') {
+				j++
+			}
+			if j < len(query) && (query[j] == 'e' || query[j] == 'E') {
+				j++
+				if j < len(query) && (query[j] == '+' || query[j] == '-') {
+					j++
+				}
+				for j < len(query) && isSQLDigit(query[j]) {
+					j++
+				}
+			}
+			tokens = append(tokens, sqlToken{sqlNumber, query[i:j]})
+			i = j
+		default:
+			tokens = append(tokens, sqlToken{sqlSymbol, string(c)})
+			i++
+		}
+	}
+	return tokens, nil
+}
+
+// scanSQLQuoted returns the unescaped value of a string or a quoted identifier, and the length of it in the statement
+func scanSQLQuoted(s string) (string, int, error) {
+	quote := s[0]
+	var sb strings.Builder
+	for j := 1; j < len(s); j++ {
+		if s[j] != quote {
+			sb.WriteByte(s[j])
+			continue
+		}
+		if j+1 < len(s) && s[j+1] == quote {
+			sb.WriteByte(quote)
+			j++
+			continue
+		}
+		return sb.String(), j + 1, nil
+	}
+	if quote == '"' {
+		return "", 0, errors.New("unterminated quoted identifier")
+	}
+	return "", 0, errors.New("unterminated string")
+}
+
+func isSQLLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isSQLDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// ValidateTenantSQL ensures the statement only reads the topics under the tenant.
+// The statement is parsed to resolve every table it references in the FROM, JOIN and TABLE relations, which has to be
+// a topic under a tenant namespace schema of the pulsar catalog, or a WITH query, because the default schema is
+// removed from the session. Only the queries and the SHOW TABLES, SHOW COLUMNS and DESCRIBE statements are allowed.
+func ValidateTenantSQL(tenant, query string) error {
+	tokens, err := tokenizeSQL(query)
+	if err != nil {
+		return err
+	}
+	for len(tokens) > 0 && tokens[len(tokens)-1].isSymbol(";") {
+		tokens = tokens[:len(tokens)-1]
+	}
+	if len(tokens) == 0 {
+		return errors.New("missing query statement")
+	}
+	for _, t := range tokens {
+		if t.isSymbol(";") {
+			return errors.New("multiple statements are not allowed")
+		}
+	}
+
+	v := sqlValidator{tenant: strings.ToLower(tenant), tokens: tokens, queries: sqlWithQueries(tokens)}
+	switch first := tokens[0]; {
+	case first.isKeyword("select", "with", "values", "table") || first.isSymbol("("):
+		return v.validateQuery()
+	case first.isKeyword("show") && len(tokens) > 2 && tokens[1].isKeyword("tables") && tokens[2].isKeyword("from", "in"):
+		return v.validateShowTables()
+	case first.isKeyword("show") && len(tokens) > 2 && tokens[1].isKeyword("columns") && tokens[2].isKeyword("from", "in"):
+		return v.validateDescribe(3)
+	case first.isKeyword("describe", "desc"):
+		return v.validateDescribe(1)
+	case first.isKeyword("show") && len(tokens) > 1:
+		return fmt.Errorf("show %s is not allowed", tokens[1].value)
+	}
+	return fmt.Errorf("%s statement is not allowed", strings.ToUpper(tokens[0].value))
+}
+
+type sqlValidator struct {
+	tenant  string
+	tokens  []sqlToken
+	queries map[string]bool
+}
+
+// sqlWithQueries returns the names of the WITH queries, which can be referenced as a table without the schema
+func sqlWithQueries(tokens []sqlToken) map[string]bool {
+	names := map[string]bool{}
+	for i, t := range tokens {
+		if !t.isKeyword("with") {
+			continue
+		}
+		j := i + 1
+		if j < len(tokens) && tokens[j].isKeyword("recursive") {
+			j++
+		}
+		for j < len(tokens) && tokens[j].isName() {
+			name := strings.ToLower(tokens[j].value)
+			j++
+			if j < len(tokens) && tokens[j].isSymbol("(") {
+				j = sqlClosingParenthesis(tokens, j) + 1
+			}
+			if j+1 >= len(tokens) || !tokens[j].isKeyword("as") || !tokens[j+1].isSymbol("(") {
+				break
+			}
+			names[name] = true
+			j = sqlClosingParenthesis(tokens, j+1) + 1
+			if j >= len(tokens) || !tokens[j].isSymbol(",") {
+				break
+			}
+			j++
+		}
+	}
+	return names
+}
+
+// sqlClosingParenthesis returns the index of the parenthesis closing the one at the index, or the end of the tokens
+func sqlClosingParenthesis(tokens []sqlToken, open int) int {
+	depth := 0
+	for i := open; i < len(tokens); i++ {
+		if tokens[i].isSymbol("(") {
+			depth++
+		} else if tokens[i].isSymbol(")") {
+			if depth--; depth == 0 {
+				return i
+			}
+		}
+	}
+	return len(tokens)
+}
+
+// qualifiedName returns the parts of the dot separated name at the index, and the index after the name
+func (v *sqlValidator) qualifiedName(i int) ([]string, int) {
+	parts := []string{}
+	for i < len(v.tokens) && v.tokens[i].isName() {
+		parts = append(parts, v.tokens[i].value)
+		i++
+		if i+1 >= len(v.tokens) || !v.tokens[i].isSymbol(".") || !v.tokens[i+1].isName() {
+			break
+		}
+		i++
+	}
+	return parts, i
+}
+
+// startsQuery evaluates if the token at the index starts a query
+func (v *sqlValidator) startsQuery(i int) bool {
+	return i < len(v.tokens) && v.tokens[i].isKeyword("select", "with", "values", "table")
+}
+
+func (v *sqlValidator) validateQuery() error {
+	stack := []sqlContext{{kind: sqlQuery}}
+	relation := false
+	for i := 0; i < len(v.tokens); i++ {
+		t := v.tokens[i]
+		ctx := &stack[len(stack)-1]
+		if relation {
+			relation = false
+			switch {
+			case t.isKeyword("lateral", "unnest") && i+1 < len(v.tokens) && v.tokens[i+1].isSymbol("("):
+				// LATERAL (query) or UNNEST(expression)
+				continue
+			case t.isSymbol("("):
+				if v.startsQuery(i + 1) {
+					stack = append(stack, sqlContext{kind: sqlQuery})
+				} else {
+					stack = append(stack, sqlContext{kind: sqlRelation})
+					relation = true
+				}
+				continue
+			case t.isName():
+				parts, next := v.qualifiedName(i)
+				if err := v.resolveTable(parts); err != nil {
+					return err
+				}
+				i = next - 1
+				continue
+			}
+			return fmt.Errorf("unsupported relation %s", t.value)
+		}
+
+		switch {
+		case t.isSymbol("("):
+			kind := sqlExpression
+			if v.startsQuery(i + 1) {
+				kind = sqlQuery
+			}
+			stack = append(stack, sqlContext{kind: kind})
+		case t.isSymbol(")"):
+			if len(stack) == 1 {
+				return errors.New("unbalanced parentheses")
+			}
+			stack = stack[:len(stack)-1]
+		case t.isKeyword("from") && ctx.kind == sqlQuery && !(i > 1 && v.tokens[i-1].isKeyword("distinct") && v.tokens[i-2].isKeyword("is", "not")):
+			// IS [NOT] DISTINCT FROM is a comparison
+			ctx.fromList = true
+			relation = true
+		case t.isKeyword("join") && ctx.kind != sqlExpression:
+			relation = true
+		case t.isKeyword("table"):
+			relation = true
+		case t.isSymbol(",") && ctx.fromList:
+			relation = true
+		case t.isKeyword(sqlFromClauseEnd...):
+			ctx.fromList = false
+		}
+	}
+	if relation {
+		return errors.New("missing relation")
+	}
+	if len(stack) != 1 {
+		return errors.New("unbalanced parentheses")
+	}
+	return nil
+}
+
+// validateShowTables validates SHOW TABLES FROM [catalog.]schema [LIKE pattern [ESCAPE escape]]
+func (v *sqlValidator) validateShowTables() error {
+	parts, next := v.qualifiedName(3)
+	switch len(parts) {
+	case 1:
+		if err := v.resolveSchema(parts[0]); err != nil {
+			return err
+		}
+	case 2:
+		if err := v.resolveCatalog(parts[0]); err != nil {
+			return err
+		}
+		if err := v.resolveSchema(parts[1]); err != nil {
+			return err
+		}
+	default:
+		return errors.New("SHOW TABLES requires the tenant namespace schema such as \"tenant/namespace\"")
+	}
+	rest := v.tokens[next:]
+	if len(rest) == 0 || (len(rest) == 2 && rest[0].isKeyword("like") && rest[1].kind == sqlString) ||
+		(len(rest) == 4 && rest[0].isKeyword("like") && rest[1].kind == sqlString && rest[2].isKeyword("escape") && rest[3].kind == sqlString) {
+		return nil
+	}
+	return errors.New("unsupported SHOW TABLES statement")
+}
+
+// validateDescribe validates the DESCRIBE and SHOW COLUMNS statements with the table starting at the index
+func (v *sqlValidator) validateDescribe(i int) error {
+	parts, next := v.qualifiedName(i)
+	if len(parts) == 0 || next != len(v.tokens) {
+		return errors.New("unsupported DESCRIBE statement")
+	}
+	return v.resolveTable(parts)
+}
+
+// resolveTable ensures the table is a WITH query or a topic under the tenant namespace schema of the pulsar catalog
+func (v *sqlValidator) resolveTable(parts []string) error {
+	switch len(parts) {
+	case 1:
+		if v.queries[strings.ToLower(parts[0])] {
+			return nil
+		}
+		return fmt.Errorf("topic %s must be qualified by the tenant namespace schema such as \"tenant/namespace\"", parts[0])
+	case 2:
+		return v.resolveSchema(parts[0])
+	case 3:
+		if err := v.resolveCatalog(parts[0]); err != nil {
+			return err
+		}
+		return v.resolveSchema(parts[1])
+	}
+	return fmt.Errorf("invalid table name %s", strings.Join(parts, "."))
+}
+
+func (v *sqlValidator) resolveCatalog(catalog string) error {
+	if strings.ToLower(catalog) != "pulsar" {
+		return fmt.Errorf("catalog %s is not allowed", catalog)
+	}
+	return nil
+}
+
+// resolveSchema ensures the schema is a namespace of the tenant, the names are case insensitive in Presto
+func (v *sqlValidator) resolveSchema(schema string) error {
+	if parts := strings.SplitN(strings.ToLower(schema), "/", 2); len(parts) != 2 || parts[0] != v.tenant {
+		return fmt.Errorf("schema \"%s\" is not under tenant %s", schema, v.tenant)
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package route
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/datastax/burnell/src/chaos"
+	"github.com/datastax/burnell/src/policy"
+	"github.com/datastax/burnell/src/util"
+	"github.com/gorilla/mux"
+)
+
+// the Presto client headers passed to the coordinator, burnell sets the user and the catalog and removes the schema
+var sqlForwardedHeaders = []string{"X-Presto-Source", "X-Presto-Time-Zone", "X-Presto-Language", "X-Presto-Session",
+	"X-Presto-Client-Info", "X-Presto-Client-Tags", "X-Presto-Client-Capabilities", "X-Presto-Trace-Token"}
+
+// Presto query ID, such as 20201014_042625_00001_abcde
+var sqlQueryIDPattern = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// the max number of the queries whose tenants are kept, and how long a query is kept since the last result page
+var (
+	sqlQueryMaxEntries = util.GetEnvInt("PulsarSQLQueryMaxEntries", 10000)
+	sqlQueryRetention  = time.Duration(util.GetEnvInt("PulsarSQLQueryRetentionMinutes", 60)) * time.Minute
+)
+
+type sqlQueryOwner struct {
+	tenant     string
+	lastAccess time.Time
+}
+
+var (
+	// sqlQueries is the tenant submitted the query by the query ID, guarded by sqlQueriesLock
+	sqlQueries     = make(map[string]sqlQueryOwner)
+	sqlQueriesLock = sync.Mutex{}
+)
+
+// recordSQLQuery records the tenant submitted the query, the least recently used queries are evicted over the limit
+func recordSQLQuery(queryID, tenant string, now time.Time) {
+	sqlQueriesLock.Lock()
+	defer sqlQueriesLock.Unlock()
+	sqlQueries[queryID] = sqlQueryOwner{tenant: tenant, lastAccess: now}
+	if len(sqlQueries) <= sqlQueryMaxEntries {
+		return
+	}
+	oldest, oldestID := now, ""
+	for k, v := range sqlQueries {
+		if now.Sub(v.lastAccess) > sqlQueryRetention {
+			delete(sqlQueries, k)
+		} else if v.lastAccess.Before(oldest) {
+			oldest, oldestID = v.lastAccess, k
+		}
+	}
+	if len(sqlQueries) > sqlQueryMaxEntries {
+		delete(sqlQueries, oldestID)
+	}
+}
+
+// sqlQueryTenant returns the tenant submitted the query, a query is looked up on the coordinator when it is unknown,
+// i.e. submitted through another burnell instance or before a restart
+func sqlQueryTenant(ctx context.Context, sqlURL, queryID string, now time.Time) (string, bool) {
+	sqlQueriesLock.Lock()
+	owner, ok := sqlQueries[queryID]
+	if ok && now.Sub(owner.lastAccess) > sqlQueryRetention {
+		delete(sqlQueries, queryID)
+		ok = false
+	} else if ok {
+		sqlQueries[queryID] = sqlQueryOwner{tenant: owner.tenant, lastAccess: now}
+	}
+	sqlQueriesLock.Unlock()
+	if ok {
+		return owner.tenant, true
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sqlURL+"/v1/query/"+queryID, nil)
+	if err != nil {
+		return "", false
+	}
+	req.Header.Set("Authorization", "Bearer "+util.PulsarAuthToken())
+	req.Header.Set("X-Proxy", "burnell")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Errorf("pulsar sql query %s lookup error %v", queryID, err)
+		return "", false
+	}
+	defer resp.Body.Close()
+	var info struct {
+		Session struct {
+			User string `json:"user"`
+		} `json:"session"`
+	}
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&info) != nil || info.Session.User == "" {
+		return "", false
+	}
+	recordSQLQuery(queryID, info.Session.User, now)
+	return info.Session.User, true
+}
+
+// sqlStatementQueryID returns the query ID of a statement result page or cancellation path, either
+// /v1/statement/{queryId}/{token} or /v1/statement/{queued|executing}/{queryId}/{slug}/{token}
+func sqlStatementQueryID(path string) string {
+	i := strings.Index(path, "/v1/statement/")
+	if i < 0 {
+		return ""
+	}
+	parts := strings.Split(path[i+len("/v1/statement/"):], "/")
+	if (parts[0] == "queued" || parts[0] == "executing") && len(parts) > 1 {
+		return parts[1]
+	}
+	return parts[0]
+}
+
+// PulsarSQLStatementHandler proxies Pulsar SQL query submission, result pages and cancellation to the Presto coordinator.
+// It requires the Pulsar SQL feature code under the tenant plan unless it is a superuser.
+func PulsarSQLStatementHandler(w http.ResponseWriter, r *http.Request) {
+	tenant := mux.Vars(r)["tenant"]
+	_, role := ExtractTenant(r.Header.Get(injectedSubs))
+	if !util.StrContains(util.SuperRoles, role) && !policy.TenantManager.EvaluateFeatureCode(tenant, policy.PulsarSQL) {
+		util.ResponseErrorJSON(errors.New("Pulsar SQL is not supported under the current plan, please upgrade your plan"), w, http.StatusPaymentRequired)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		util.ResponseErrorJSON(errors.New("can't read body"), w, http.StatusBadRequest)
+		return
+	}
+	prefix := "/sql/" + tenant
+	sqlURL := strings.TrimSuffix(util.GetConfig().PulsarSQLURL, "/")
+	if r.Method == http.MethodPost {
+		if err := ValidateTenantSQL(tenant, string(body)); err != nil {
+			util.ResponseErrorJSON(err, w, http.StatusForbidden)
+			return
+		}
+	} else if queryID := sqlStatementQueryID(r.URL.Path); !sqlQueryIDPattern.MatchString(queryID) {
+		util.ResponseErrorJSON(errors.New("invalid query ID"), w, http.StatusNotFound)
+		return
+	} else if !util.StrContains(util.SuperRoles, role) {
+		if owner, ok := sqlQueryTenant(r.Context(), sqlURL, queryID, time.Now()); !ok || owner != tenant {
+			util.ResponseErrorJSON(fmt.Errorf("query %s not found under tenant %s", queryID, tenant), w, http.StatusNotFound)
+			return
+		}
+	}
+	requestURL := util.SingleJoinSlash(sqlURL, strings.TrimPrefix(r.URL.RequestURI(), prefix))
+	log.Infof("tenant %s pulsar sql %s %s", tenant, r.Method, requestURL)
+
+	newRequest, err := http.NewRequestWithContext(r.Context(), r.Method, requestURL, bytes.NewReader(body))
+	if err != nil {
+		util.ResponseErrorJSON(errors.New("failed to set proxy request"), w, http.StatusInternalServerError)
+		return
+	}
+	for _, k := range sqlForwardedHeaders {
+		if v, ok := r.Header[k]; ok {
+			newRequest.Header[k] = v
+		}
+	}
+	newRequest.Header.Set("Content-Type", "text/plain")
+	newRequest.Header.Set("Authorization", "Bearer "+util.PulsarAuthToken())
+	newRequest.Header.Set("X-Presto-User", tenant)
+	newRequest.Header.Set("X-Presto-Catalog", "pulsar")
+	newRequest.Header.Del("X-Presto-Schema")
+	newRequest.Header.Set("X-Proxy", "burnell")
+
+	if status, err := chaos.Inject(chaos.Upstream, r.URL.Path); err != nil {
+		util.ResponseErrorJSON(err, w, status)
+		return
+	}
+
+	client := &http.Client{
+		CheckRedirect: util.PreserveHeaderForRedirect,
+	}
+	upstreamStart := time.Now()
+	response, err := client.Do(newRequest)
+	recordUpstream(r, newRequest.URL.Host, time.Since(upstreamStart), upstreamFailed(response, err))
+	if response != nil {
+		defer response.Body.Close()
+	}
+	if err != nil {
+		log.Errorf("pulsar sql proxy error %v", err)
+		status, err := upstreamError(r, err)
+		util.ResponseErrorJSON(err, w, status)
+		return
+	}
+	data, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		util.ResponseErrorJSON(errors.New("failed to read proxy response body"), w, http.StatusInternalServerError)
+		return
+	}
+	if r.Method == http.MethodPost && response.StatusCode == http.StatusOK {
+		var query struct {
+			ID string `json:"id"`
+		}
+		if json.Unmarshal(data, &query) == nil && query.ID != "" {
+			recordSQLQuery(query.ID, tenant, time.Now())
+		}
+	}
+
+	// nextUri, infoUri and partialCancelUri have to route through burnell
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	scheme = util.AssignString(r.Header.Get("X-Forwarded-Proto"), scheme)
+	data = bytes.ReplaceAll(data, []byte(sqlURL), []byte(fmt.Sprintf("%s://%s%s", scheme, r.Host, prefix)))
+
+	w.WriteHeader(response.StatusCode)
+	w.Write(data)
+}
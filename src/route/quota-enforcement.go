@@ -0,0 +1,183 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package route
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/datastax/burnell/src/audit"
+	"github.com/datastax/burnell/src/policy"
+	"github.com/datastax/burnell/src/util"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// the quotas under the enforcement mode
+const (
+	PlanRateQuota   = "plan-rate"
+	FunctionsQuota  = "functions"
+	PartitionsQuota = "partitions"
+	PlanLimitQuota  = "plan-limit"
+)
+
+var quotaViolations = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "burnell",
+	Subsystem: "quota",
+	Name:      "violations_total",
+	Help:      "The number of the requests over a tenant plan quota or rate limit, rejected in enforce mode or passed in observe mode.",
+}, []string{"quota", "mode"})
+
+func init() {
+	prometheus.MustRegister(quotaViolations)
+}
+
+// QuotaViolationStats is the violations of a quota by a tenant since the start
+type QuotaViolationStats struct {
+	Tenant     string    `json:"tenant"`
+	Quota      string    `json:"quota"`
+	Mode       string    `json:"mode"`
+	Count      int       `json:"count"`
+	LastAt     time.Time `json:"lastAt"`
+	LastDetail string    `json:"lastDetail"`
+}
+
+// QuotaEnforcementStats is the global quota enforcement mode and the violations
+type QuotaEnforcementStats struct {
+	Mode       string                `json:"mode"`
+	Violations []QuotaViolationStats `json:"violations"`
+}
+
+// the max tenant and quota pairs of the tracked violations
+const maxTrackedViolations = 1000
+
+var (
+	quotaEnforcementMode = policy.EnforceQuota
+	violationStats       = map[string]*QuotaViolationStats{}
+	quotaEnforcementLock = sync.RWMutex{}
+)
+
+// InitQuotaEnforcement sets the QuotaEnforcement in the configuration
+func InitQuotaEnforcement() error {
+	return SetQuotaEnforcement(util.AssignString(util.GetConfig().QuotaEnforcement, policy.EnforceQuota))
+}
+
+// SetQuotaEnforcement sets the global quota enforcement mode of the tenants without their own mode
+func SetQuotaEnforcement(mode string) error {
+	if !policy.IsQuotaEnforcementMode(mode) {
+		return fmt.Errorf("quota enforcement %s must be enforce or observe", mode)
+	}
+	quotaEnforcementLock.Lock()
+	defer quotaEnforcementLock.Unlock()
+	quotaEnforcementMode = mode
+	return nil
+}
+
+// tenantQuotaEnforcement returns the quota enforcement mode of the tenant plan, or the global mode
+func tenantQuotaEnforcement(tenant string) string {
+	if plan, err := policy.TenantManager.GetTenant(tenant); err == nil && plan.QuotaEnforcement != "" {
+		return plan.QuotaEnforcement
+	}
+	quotaEnforcementLock.RLock()
+	defer quotaEnforcementLock.RUnlock()
+	return quotaEnforcementMode
+}
+
+// enforceQuota counts a quota violation by the tenant and returns true if the request must be rejected,
+// in observe mode the violation is only logged and the request passes.
+func enforceQuota(tenant, quota, detail string) bool {
+	mode := tenantQuotaEnforcement(tenant)
+	quotaViolations.WithLabelValues(quota, mode).Inc()
+
+	key := tenant + "/" + quota
+	quotaEnforcementLock.Lock()
+	stats, ok := violationStats[key]
+	if !ok && len(violationStats) < maxTrackedViolations {
+		stats = &QuotaViolationStats{Tenant: tenant, Quota: quota}
+		violationStats[key] = stats
+	}
+	if stats != nil {
+		stats.Mode = mode
+		stats.Count++
+		stats.LastAt = time.Now()
+		stats.LastDetail = detail
+	}
+	quotaEnforcementLock.Unlock()
+
+	if mode == policy.ObserveQuota {
+		log.Warnf("observe mode passes tenant %s over the %s quota: %s", tenant, quota, detail)
+		return false
+	}
+	return true
+}
+
+// QuotaEnforcement returns the global quota enforcement mode and the violations by tenant and quota
+func QuotaEnforcement() QuotaEnforcementStats {
+	quotaEnforcementLock.RLock()
+	defer quotaEnforcementLock.RUnlock()
+	stats := QuotaEnforcementStats{Mode: quotaEnforcementMode, Violations: []QuotaViolationStats{}}
+	for _, v := range violationStats {
+		stats.Violations = append(stats.Violations, *v)
+	}
+	sort.Slice(stats.Violations, func(i, j int) bool {
+		if stats.Violations[i].Tenant != stats.Violations[j].Tenant {
+			return stats.Violations[i].Tenant < stats.Violations[j].Tenant
+		}
+		return stats.Violations[i].Quota < stats.Violations[j].Quota
+	})
+	return stats
+}
+
+// QuotaEnforcementHandler returns the quota enforcement mode and the violations, PUT sets the global mode
+func QuotaEnforcementHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPut {
+		var req struct {
+			Mode string `json:"mode"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			util.ResponseErrorJSON(err, w, http.StatusBadRequest)
+			return
+		}
+		if err := SetQuotaEnforcement(req.Mode); err != nil {
+			util.ResponseErrorJSON(err, w, http.StatusUnprocessableEntity)
+			return
+		}
+		audit.Record(audit.Event{
+			Subject:  r.Header.Get(injectedSubs),
+			Action:   "set-quota-enforcement",
+			Resource: r.URL.Path,
+			Detail:   "mode " + req.Mode,
+			Status:   http.StatusOK,
+		})
+	}
+
+	data, err := json.Marshal(QuotaEnforcement())
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
+		return
+	}
+	w.Write(data)
+}
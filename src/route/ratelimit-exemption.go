@@ -0,0 +1,277 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package route
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/datastax/burnell/src/audit"
+	"github.com/datastax/burnell/src/util"
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// rateExemption is a compiled exemption with its dedicated bucket, the bucket is nil for a full bypass
+type rateExemption struct {
+	served uint64
+	util.RateLimitExemption
+	ipNet  *net.IPNet
+	bucket *Limiter
+}
+
+// RateExemptionStats is an exemption and its live counters
+type RateExemptionStats struct {
+	util.RateLimitExemption
+	// Served is the number of the requests bypassing the rate limits
+	Served uint64        `json:"served"`
+	Bucket *LimiterStats `json:"bucket,omitempty"`
+}
+
+var rateExemptions []*rateExemption
+var rateExemptionsLock sync.RWMutex
+
+// InitRateLimitExemptions sets the RateLimitExemptions in the configuration
+func InitRateLimitExemptions() error {
+	return SetRateLimitExemptions(util.GetConfig().RateLimitExemptions)
+}
+
+// SetRateLimitExemptions replaces the exemptions. An exemption keeps its counters if its name is unchanged.
+func SetRateLimitExemptions(exemptions []util.RateLimitExemption) error {
+	compiled := make([]*rateExemption, 0, len(exemptions))
+	names := make(map[string]bool, len(exemptions))
+	for _, e := range exemptions {
+		if e.Name == "" {
+			return fmt.Errorf("rate limit exemption requires a name")
+		}
+		if names[e.Name] {
+			return fmt.Errorf("duplicate rate limit exemption %s", e.Name)
+		}
+		names[e.Name] = true
+		if (e.Subject == "") == (e.CIDR == "") {
+			return fmt.Errorf("rate limit exemption %s requires either subject or cidr", e.Name)
+		}
+		if e.Limit < 0 {
+			return fmt.Errorf("rate limit exemption %s limit %d cannot be negative", e.Name, e.Limit)
+		}
+		c := &rateExemption{RateLimitExemption: e}
+		if e.CIDR != "" {
+			_, ipNet, err := net.ParseCIDR(e.CIDR)
+			if err != nil {
+				return fmt.Errorf("rate limit exemption %s: %v", e.Name, err)
+			}
+			c.ipNet = ipNet
+		}
+		compiled = append(compiled, c)
+	}
+
+	rateExemptionsLock.Lock()
+	defer rateExemptionsLock.Unlock()
+	existing := make(map[string]*rateExemption, len(rateExemptions))
+	for _, e := range rateExemptions {
+		existing[e.Name] = e
+	}
+	for _, c := range compiled {
+		prev, ok := existing[c.Name]
+		if ok {
+			c.served = atomic.LoadUint64(&prev.served)
+		}
+		if c.Limit == 0 {
+			continue
+		}
+		if ok && prev.bucket != nil {
+			c.bucket = prev.bucket
+			c.bucket.SetLimits(LimiterConfig{Limit: c.Limit})
+		} else {
+			c.bucket = NewLimiter(c.Limit, 0)
+		}
+	}
+	rateExemptions = compiled
+	return nil
+}
+
+// RateLimitExemptions returns the exemptions with their live counters
+func RateLimitExemptions() []RateExemptionStats {
+	rateExemptionsLock.RLock()
+	defer rateExemptionsLock.RUnlock()
+	stats := make([]RateExemptionStats, 0, len(rateExemptions))
+	for _, e := range rateExemptions {
+		s := RateExemptionStats{RateLimitExemption: e.RateLimitExemption, Served: atomic.LoadUint64(&e.served)}
+		if e.bucket != nil {
+			bucket := e.bucket.Stats()
+			s.Bucket = &bucket
+		}
+		stats = append(stats, s)
+	}
+	return stats
+}
+
+// matchRateExemption returns the first exemption matching the client IP or the verified token subject.
+// The token is only verified when its subject claim matches a subject exemption.
+func matchRateExemption(r *http.Request) *rateExemption {
+	rateExemptionsLock.RLock()
+	defer rateExemptionsLock.RUnlock()
+	if len(rateExemptions) == 0 {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	claimed, claimParsed := "", false
+	for _, e := range rateExemptions {
+		if e.ipNet != nil {
+			if ip != nil && e.ipNet.Contains(ip) {
+				return e
+			}
+			continue
+		}
+		if !claimParsed {
+			claimed, claimParsed = claimedSubject(r), true
+		}
+		if claimed != "" && claimed == e.Subject && tokenSubject(r) == e.Subject {
+			return e
+		}
+	}
+	return nil
+}
+
+type tokenVerificationKey struct{}
+
+// tokenVerification is the bearer token verification of a request, it is done once
+// and shared by the priority scheduler, the rate limit exemptions and the auth middleware
+type tokenVerification struct {
+	once    sync.Once
+	token   string
+	subject string
+	err     error
+}
+
+// withTokenVerification attaches a token verification to the request context unless there is one
+func withTokenVerification(r *http.Request) *http.Request {
+	if _, ok := r.Context().Value(tokenVerificationKey{}).(*tokenVerification); ok {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), tokenVerificationKey{}, &tokenVerification{}))
+}
+
+func bearerToken(r *http.Request) string {
+	return strings.TrimSpace(strings.Replace(r.Header.Get("Authorization"), "Bearer", "", 1))
+}
+
+// verifyToken returns the subject of the verified bearer token,
+// the result is reused by the later calls on a request with the token verification
+func verifyToken(r *http.Request) (string, error) {
+	tokenStr := bearerToken(r)
+	v, ok := r.Context().Value(tokenVerificationKey{}).(*tokenVerification)
+	if !ok {
+		return util.JWTAuth.GetTokenSubject(tokenStr)
+	}
+	v.once.Do(func() {
+		v.token = tokenStr
+		v.subject, v.err = util.JWTAuth.GetTokenSubject(tokenStr)
+	})
+	if v.token != tokenStr {
+		return util.JWTAuth.GetTokenSubject(tokenStr)
+	}
+	return v.subject, v.err
+}
+
+// claimedSubject returns the unverified subject claim of a well formed bearer token, or empty.
+// It screens the token before the signature verification, a malformed token is never verified.
+func claimedSubject(r *http.Request) string {
+	if !util.IsPulsarJWTEnabled() {
+		return ""
+	}
+	claims := jwt.MapClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(bearerToken(r), claims); err != nil {
+		return ""
+	}
+	subject, _ := claims["sub"].(string)
+	return subject
+}
+
+// tokenSubject returns the subject of a valid bearer token, or empty
+func tokenSubject(r *http.Request) string {
+	if !util.IsPulsarJWTEnabled() || bearerToken(r) == "" {
+		return ""
+	}
+	subject, err := verifyToken(r)
+	if err != nil {
+		return ""
+	}
+	return subject
+}
+
+// acquire takes an in-flight slot of the dedicated bucket, a bypass is always allowed
+func (e *rateExemption) acquire() bool {
+	if e.bucket == nil {
+		atomic.AddUint64(&e.served, 1)
+		return true
+	}
+	return e.bucket.Acquire("")
+}
+
+func (e *rateExemption) release() {
+	if e.bucket != nil {
+		e.bucket.Release("")
+	}
+}
+
+// RateLimitExemptionsHandler returns the rate limit exemptions, or replaces them at runtime
+func RateLimitExemptionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPut {
+		var exemptions []util.RateLimitExemption
+		if err := json.NewDecoder(r.Body).Decode(&exemptions); err != nil {
+			util.ResponseErrorJSON(err, w, http.StatusBadRequest)
+			return
+		}
+		if err := SetRateLimitExemptions(exemptions); err != nil {
+			util.ResponseErrorJSON(err, w, http.StatusUnprocessableEntity)
+			return
+		}
+		names := make([]string, 0, len(exemptions))
+		for _, e := range exemptions {
+			names = append(names, e.Name)
+		}
+		audit.Record(audit.Event{
+			Subject:  r.Header.Get(injectedSubs),
+			Action:   "set-rate-limit-exemptions",
+			Resource: r.URL.Path,
+			Detail:   "exemptions " + strings.Join(names, ","),
+			Status:   http.StatusOK,
+		})
+	}
+
+	data, err := json.Marshal(RateLimitExemptions())
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
+		return
+	}
+	w.Write(data)
+}
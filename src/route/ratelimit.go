@@ -0,0 +1,288 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package route
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/datastax/burnell/src/audit"
+	"github.com/datastax/burnell/src/util"
+)
+
+const (
+	// LogsPool is the bulkhead for function log downloads
+	LogsPool = "logs"
+	// MetricsPool is the bulkhead for federated metrics and usage
+	MetricsPool = "metrics"
+	// WatchPool is the bulkhead for long lived tenant plan watches
+	WatchPool = "watch"
+	// StatsPool is the bulkhead for partition internal stats fan out
+	StatsPool = "stats"
+)
+
+// Bulkheads are the separate pools for heavy route classes so that they cannot starve the other routes limited by Rate
+var Bulkheads = map[string]*Limiter{
+	LogsPool:    NewLimiter(util.GetEnvInt("LogsConcurrency", 20), util.GetEnvInt("RateLimitPerTenant", 0)),
+	MetricsPool: NewLimiter(util.GetEnvInt("MetricsConcurrency", 20), util.GetEnvInt("RateLimitPerTenant", 0)),
+	WatchPool:   NewLimiter(util.GetEnvInt("WatchConcurrency", 100), util.GetEnvInt("RateLimitPerTenant", 0)),
+	StatsPool:   NewLimiter(util.GetEnvInt("StatsConcurrency", 10), util.GetEnvInt("RateLimitPerTenant", 0)),
+}
+
+// the bulkhead of route classes by the route name
+var routePools = map[string]string{
+	"function-logs":               LogsPool,
+	"pulsar metrics":              MetricsPool,
+	"tenants usage":               MetricsPool,
+	"tenant namespaces usage":     MetricsPool,
+	"tenants plan watch firehose": WatchPool,
+	"tenant plan watch":           WatchPool,
+	"topic internal stats":        StatsPool,
+}
+
+// a tenant bucket without in-flight requests is evicted after the idle window
+var limiterTenantIdle = time.Duration(util.GetEnvInt("RateLimitTenantIdleMinutes", 10)) * time.Minute
+
+// DefaultPool is the rate limit class of the routes without a bulkhead
+const DefaultPool = "default"
+
+// routeLimiter returns the bulkhead of the matched route, or the default Rate limiter
+func routeLimiter(r *http.Request) *Limiter {
+	if route := currentRoute(r); route != nil {
+		if pool := routePool(route.GetName()); pool != DefaultPool {
+			return Bulkheads[pool]
+		}
+	}
+	return Rate
+}
+
+// routePool returns the bulkhead name of a route name, or the default pool
+func routePool(name string) string {
+	if pool, ok := routePools[name]; ok {
+		return pool
+	}
+	return DefaultPool
+}
+
+// Limiter limits the number of in-flight requests in total and per tenant.
+// The limits can be adjusted at runtime, 0 per tenant limit is no limit.
+type Limiter struct {
+	limit          int
+	perTenantLimit int
+	inFlight       int
+	served         uint64
+	rejected       uint64
+	tenants        map[string]*TenantBucket
+	lastSweep      time.Time
+	lock           sync.Mutex
+}
+
+// TenantBucket is the live counters of a tenant
+type TenantBucket struct {
+	InFlight int    `json:"inFlight"`
+	Rejected uint64 `json:"rejected"`
+	lastSeen time.Time
+}
+
+// LimiterStats is the limiter configuration and live counters
+type LimiterStats struct {
+	Limit          int                     `json:"limit"`
+	PerTenantLimit int                     `json:"perTenantLimit"`
+	InFlight       int                     `json:"inFlight"`
+	Served         uint64                  `json:"served"`
+	Rejected       uint64                  `json:"rejected"`
+	Tenants        map[string]TenantBucket `json:"tenants"`
+}
+
+// RateLimitsResponse is the default limiter state and the bulkhead pools state
+type RateLimitsResponse struct {
+	LimiterStats
+	Pools map[string]LimiterStats `json:"pools"`
+	// PlanThrottled is the number of requests per tenant over the tenant plan rate
+	PlanThrottled map[string]uint64 `json:"planThrottled"`
+	// Exemptions are the infrastructure callers exempted from the limits
+	Exemptions []RateExemptionStats `json:"exemptions"`
+}
+
+// LimiterConfig is the request to adjust the limits of the default limiter, or a bulkhead pool
+type LimiterConfig struct {
+	Pool           string `json:"pool,omitempty"`
+	Limit          int    `json:"limit"`
+	PerTenantLimit int    `json:"perTenantLimit"`
+}
+
+// NewLimiter creates a limiter
+func NewLimiter(limit, perTenantLimit int) *Limiter {
+	return &Limiter{
+		limit:          limit,
+		perTenantLimit: perTenantLimit,
+		tenants:        make(map[string]*TenantBucket),
+	}
+}
+
+// Acquire acquires an in-flight slot for the tenant, an empty tenant is only counted in the total.
+// It returns false if the total or the tenant limit is reached.
+func (l *Limiter) Acquire(tenant string) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := time.Now()
+	if now.Sub(l.lastSweep) > time.Minute {
+		l.evictIdle(now, limiterTenantIdle)
+		l.lastSweep = now
+	}
+	var bucket *TenantBucket
+	if tenant != "" {
+		if bucket = l.tenants[tenant]; bucket == nil {
+			bucket = &TenantBucket{}
+			l.tenants[tenant] = bucket
+		}
+		bucket.lastSeen = now
+	}
+	if l.inFlight >= l.limit || (bucket != nil && l.perTenantLimit > 0 && bucket.InFlight >= l.perTenantLimit) {
+		l.rejected++
+		if bucket != nil {
+			bucket.Rejected++
+		}
+		return false
+	}
+	l.inFlight++
+	l.served++
+	if bucket != nil {
+		bucket.InFlight++
+	}
+	return true
+}
+
+// Release releases an in-flight slot acquired by the tenant
+func (l *Limiter) Release(tenant string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.inFlight > 0 {
+		l.inFlight--
+	}
+	if bucket, ok := l.tenants[tenant]; ok && bucket.InFlight > 0 {
+		bucket.InFlight--
+		bucket.lastSeen = time.Now()
+	}
+}
+
+// EvictIdle evicts the tenant buckets without in-flight requests and idle over the window.
+// It returns the number of evicted buckets.
+func (l *Limiter) EvictIdle(now time.Time, idle time.Duration) int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.evictIdle(now, idle)
+}
+
+// evictIdle is EvictIdle, the caller holds the lock
+func (l *Limiter) evictIdle(now time.Time, idle time.Duration) int {
+	evicted := 0
+	for k, v := range l.tenants {
+		if v.InFlight == 0 && now.Sub(v.lastSeen) > idle {
+			delete(l.tenants, k)
+			evicted++
+		}
+	}
+	return evicted
+}
+
+// SetLimits adjusts the total and per tenant limits
+func (l *Limiter) SetLimits(cfg LimiterConfig) error {
+	if cfg.Limit < 1 {
+		return fmt.Errorf("limit %d must be positive", cfg.Limit)
+	}
+	if cfg.PerTenantLimit < 0 {
+		return fmt.Errorf("per tenant limit %d cannot be negative", cfg.PerTenantLimit)
+	}
+	l.lock.Lock()
+	l.limit = cfg.Limit
+	l.perTenantLimit = cfg.PerTenantLimit
+	l.lock.Unlock()
+	return nil
+}
+
+// Stats returns the limiter configuration and a snapshot of the live counters
+func (l *Limiter) Stats() LimiterStats {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	stats := LimiterStats{
+		Limit:          l.limit,
+		PerTenantLimit: l.perTenantLimit,
+		InFlight:       l.inFlight,
+		Served:         l.served,
+		Rejected:       l.rejected,
+		Tenants:        make(map[string]TenantBucket, len(l.tenants)),
+	}
+	for k, v := range l.tenants {
+		stats.Tenants[k] = *v
+	}
+	return stats
+}
+
+// RateLimitsHandler returns the rate limiter state, or adjusts the limits at runtime
+func RateLimitsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPut {
+		var cfg LimiterConfig
+		if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+			util.ResponseErrorJSON(errors.New("request body requires limit and perTenantLimit"), w, http.StatusBadRequest)
+			return
+		}
+		limiter := Rate
+		if cfg.Pool != "" {
+			if limiter = Bulkheads[cfg.Pool]; limiter == nil {
+				util.ResponseErrorJSON(fmt.Errorf("unknown pool %s", cfg.Pool), w, http.StatusUnprocessableEntity)
+				return
+			}
+		}
+		if err := limiter.SetLimits(cfg); err != nil {
+			util.ResponseErrorJSON(err, w, http.StatusUnprocessableEntity)
+			return
+		}
+		audit.Record(audit.Event{
+			Subject:  r.Header.Get(injectedSubs),
+			Action:   "set-rate-limits",
+			Resource: r.URL.Path,
+			Detail:   fmt.Sprintf("pool %s limit %d per tenant limit %d", util.AssignString(cfg.Pool, "default"), cfg.Limit, cfg.PerTenantLimit),
+			Status:   http.StatusOK,
+		})
+	}
+
+	resp := RateLimitsResponse{
+		LimiterStats:  Rate.Stats(),
+		Pools:         make(map[string]LimiterStats, len(Bulkheads)),
+		PlanThrottled: PlanRate.Throttled(),
+		Exemptions:    RateLimitExemptions(),
+	}
+	for k, v := range Bulkheads {
+		resp.Pools[k] = v.Stats()
+	}
+	data, err := json.Marshal(resp)
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
+		return
+	}
+	w.Write(data)
+}
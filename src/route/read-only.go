@@ -0,0 +1,72 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package route
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/datastax/burnell/src/util"
+)
+
+// the GET routes with side effects, the token server signs a new token for every request
+var readOnlyBlockedRoutes = map[string]bool{
+	"token server": true,
+}
+
+// the POST routes that only query, the Grafana datasource and the SSO tenants lookup
+var readOnlyQueryRoutes = map[string]bool{
+	"grafana datasource search":      true,
+	"grafana datasource query":       true,
+	"grafana datasource annotations": true,
+	"sso tenants resolve":            true,
+}
+
+// ReadOnly rejects the plan writes, the token minting, the websocket producers and all the non-GET admin calls
+// with 405, so that a read-only replica can be exposed to the broader audiences or run in the DR regions
+func ReadOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := ""
+		if route := currentRoute(r); route != nil {
+			name = route.GetName()
+		}
+		if readOnlyAllowed(r.Method, r.URL.Path, name) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Allow", strings.Join([]string{http.MethodGet, http.MethodHead, http.MethodOptions}, ", "))
+		util.ResponseErrorJSON(util.NewReasonError(util.ReasonReadOnly, r.Method+" "+r.URL.Path+" is disabled on a read-only replica"),
+			w, http.StatusMethodNotAllowed)
+	})
+}
+
+func readOnlyAllowed(method, path, name string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		if readOnlyBlockedRoutes[name] {
+			return false
+		}
+		// the websocket upgrade is a GET, only the consumers and readers are allowed
+		return !(strings.HasPrefix(path, "/ws/") && strings.Contains(path, "/producer/"))
+	}
+	return readOnlyQueryRoutes[name]
+}
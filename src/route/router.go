@@ -25,6 +25,8 @@ import (
 	"net/http"
 
 	"github.com/apex/log"
+	"github.com/datastax/burnell/src/chaos"
+	"github.com/datastax/burnell/src/schema"
 	"github.com/datastax/burnell/src/util"
 	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -41,6 +43,49 @@ func HealerRouter() *mux.Router {
 	return router
 }
 
+// StatsRouter creates http routes for stats only running mode, the metrics and tenant usage routes
+// that have no Pulsar data plane dependencies
+func StatsRouter() *mux.Router {
+	log.Warnf("set up stats only routes")
+
+	router := mux.NewRouter().StrictSlash(true)
+
+	router.Path("/liveness").Methods(http.MethodGet).Name("liveness").Handler(NoAuth(Logger(http.HandlerFunc(StatusPage), "liveness")))
+	router.Path("/readiness").Methods(http.MethodGet).Name("readiness").Handler(NoAuth(http.HandlerFunc(StatusPage)))
+	router.Path("/reasons").Methods(http.MethodGet).Name("error reasons").Handler(NoAuth(http.HandlerFunc(ReasonsHandler)))
+	router.Path("/metrics").Methods(http.MethodGet).Name("metrics").Handler(NoAuth(promhttp.Handler()))
+	router.Path("/admin/routes").Methods(http.MethodGet).Name("routes").Handler(SuperRoleRequired(NegotiateYAML(http.HandlerFunc(RoutesHandler))))
+	router.Path("/admin/config").Methods(http.MethodGet).Name("config").Handler(SuperRoleRequired(NegotiateYAML(http.HandlerFunc(ConfigHandler))))
+	router.Path("/admin/internal/memory").Methods(http.MethodGet).Name("memory watermark").Handler(SuperRoleRequired(http.HandlerFunc(MemoryWatermarkHandler)))
+	router.Path("/grafana").Methods(http.MethodGet).Name("grafana datasource test").
+		Handler(AuthVerifyJWT(http.HandlerFunc(GrafanaTestHandler)))
+	router.Path("/grafana/search").Methods(http.MethodPost).Name("grafana datasource search").
+		Handler(AuthVerifyJWT(http.HandlerFunc(GrafanaSearchHandler)))
+	router.Path("/grafana/query").Methods(http.MethodPost).Name("grafana datasource query").
+		Handler(AuthVerifyJWT(http.HandlerFunc(GrafanaQueryHandler)))
+	router.Path("/grafana/annotations").Methods(http.MethodPost).Name("grafana datasource annotations").
+		Handler(AuthVerifyJWT(http.HandlerFunc(GrafanaAnnotationsHandler)))
+	router.Path("/tenantsusage").Methods(http.MethodGet).Name("tenants usage").Handler(SignedURLAuth(SuperRoleRequired, SelectFields(http.HandlerFunc(TenantUsageHandler))))
+	router.Path("/namespacesusage/{tenant}").Methods(http.MethodGet).Name("tenant namespaces usage").Handler(SignedURLAuth(AuthVerifyTenantJWT, SelectFields(http.HandlerFunc(TenantUsageHandler))))
+	router.Path("/pulsarmetrics/{tenant}").Methods(http.MethodGet, http.MethodHead).Name("pulsar metrics").
+		Handler(AuthVerifyTenantJWT(http.HandlerFunc(PulsarFederatedDebugPrometheusHandler)))
+	router.Path("/pulsarmetrics").Methods(http.MethodGet, http.MethodHead).Name("pulsar metrics").
+		Handler(ScrapeAuthVerifyJWT(http.HandlerFunc(PulsarFederatedPrometheusHandler)))
+
+	router.Use(RequestID)
+	if util.GetConfig().AccessLogFormat != "" {
+		router.Use(AccessLog)
+	}
+	router.Use(RequestLatency)
+	router.Use(ShedOnMemoryPressure)
+	router.Use(Prioritize)
+	router.Use(LimitRate)
+	router.Use(MeterAPICalls)
+	router.Use(ResponseJSONContentType)
+	setActiveRouter(router)
+	return router
+}
+
 // NewRouter - create new router for HTTP routing
 func NewRouter() *mux.Router {
 	log.Warnf("set up proxy routes")
@@ -50,22 +95,139 @@ func NewRouter() *mux.Router {
 	// Order of routes definition matters
 
 	router.Path("/liveness").Methods(http.MethodGet).Name("liveness").Handler(NoAuth(Logger(http.HandlerFunc(StatusPage), "liveness")))
-	router.Path("/subject/{sub}").Methods(http.MethodGet).Name("token server").Handler(SuperRoleRequired(Logger(http.HandlerFunc(TokenSubjectHandler), "token server")))
+	router.Path("/.well-known/jwks.json").Methods(http.MethodGet).Name("jwks").Handler(NoAuth(http.HandlerFunc(JWKSHandler)))
+	router.Path("/readiness").Methods(http.MethodGet).Name("readiness").Handler(NoAuth(http.HandlerFunc(ReadinessPage)))
+	router.Path("/reasons").Methods(http.MethodGet).Name("error reasons").Handler(NoAuth(http.HandlerFunc(ReasonsHandler)))
+	router.Path("/subject/{sub}").Methods(http.MethodGet).Name("token server").Handler(SuperRoleRequired(IdempotentMint(Logger(http.HandlerFunc(TokenSubjectHandler), "token server"))))
+	router.Path("/token/exchange").Methods(http.MethodPost).Name("token exchange").Handler(AuthVerifyJWT(http.HandlerFunc(TokenExchangeHandler)))
 	router.PathPrefix("/ws/").Name("websocket proxy proxy").
 		Handler(http.HandlerFunc(WebsocketAuthProxyHandler))
 	router.Path("/metrics").Methods(http.MethodGet).Name("metrics").Handler(NoAuth(promhttp.Handler()))
-	router.Path("/tenantsusage").Methods(http.MethodGet).Name("tenants usage").Handler(SuperRoleRequired(http.HandlerFunc(TenantUsageHandler)))
-	router.Path("/namespacesusage/{tenant}").Methods(http.MethodGet).Name("tenant namespaces usage").Handler(AuthVerifyTenantJWT(http.HandlerFunc(TenantUsageHandler)))
-	router.Path("/pulsarmetrics/{tenant}").Methods(http.MethodGet).Name("pulsar metrics").
-		Handler(SuperRoleRequired(http.HandlerFunc(PulsarFederatedDebugPrometheusHandler)))
-	router.Path("/pulsarmetrics").Methods(http.MethodGet).Name("pulsar metrics").
-		Handler(AuthVerifyJWT(http.HandlerFunc(PulsarFederatedPrometheusHandler)))
+	// Grafana simple JSON datasource over the tenant usage history
+	router.Path("/grafana").Methods(http.MethodGet).Name("grafana datasource test").
+		Handler(AuthVerifyJWT(http.HandlerFunc(GrafanaTestHandler)))
+	router.Path("/grafana/search").Methods(http.MethodPost).Name("grafana datasource search").
+		Handler(AuthVerifyJWT(http.HandlerFunc(GrafanaSearchHandler)))
+	router.Path("/grafana/query").Methods(http.MethodPost).Name("grafana datasource query").
+		Handler(AuthVerifyJWT(http.HandlerFunc(GrafanaQueryHandler)))
+	router.Path("/grafana/annotations").Methods(http.MethodPost).Name("grafana datasource annotations").
+		Handler(AuthVerifyJWT(http.HandlerFunc(GrafanaAnnotationsHandler)))
+	router.Path("/tenantsusage").Methods(http.MethodGet).Name("tenants usage").Handler(SignedURLAuth(SuperRoleRequired, SelectFields(http.HandlerFunc(TenantUsageHandler))))
+	router.Path("/namespacesusage/{tenant}").Methods(http.MethodGet).Name("tenant namespaces usage").Handler(SignedURLAuth(AuthVerifyTenantJWT, SelectFields(http.HandlerFunc(TenantUsageHandler))))
+	router.Path("/pulsarmetrics/{tenant}").Methods(http.MethodGet, http.MethodHead).Name("pulsar metrics").
+		Handler(AuthVerifyTenantJWT(http.HandlerFunc(PulsarFederatedDebugPrometheusHandler)))
+	router.Path("/pulsarmetrics").Methods(http.MethodGet, http.MethodHead).Name("pulsar metrics").
+		Handler(ScrapeAuthVerifyJWT(http.HandlerFunc(PulsarFederatedPrometheusHandler)))
 
 	// Tenant policy management URL
 	router.Path("/k/tenant/{tenant}").Methods(http.MethodGet).Name("kafkaesque tenant management GET").
-		Handler(AuthVerifyTenantJWT(http.HandlerFunc(TenantManagementHandler)))
+		Handler(AuthVerifyTenantJWT(NegotiateYAML(SelectFields(http.HandlerFunc(TenantManagementHandler)))))
 	router.Path("/k/tenant/{tenant}").Methods(http.MethodDelete, http.MethodPost).Name("kafkaesque tenant management").
-		Handler(SuperRoleRequired(http.HandlerFunc(TenantManagementHandler)))
+		Handler(SuperRoleRequired(NegotiateYAML(Idempotent(ValidateBody(schema.TenantPlan, http.HandlerFunc(TenantManagementHandler))))))
+	router.Path("/plans/compare").Methods(http.MethodGet).Name("plan compare").
+		Handler(AuthVerifyJWT(NegotiateYAML(http.HandlerFunc(PlanCompareHandler))))
+	router.Path("/admin/tenantsplan").Methods(http.MethodGet).Name("tenants plan query").
+		Handler(SuperRoleRequired(NegotiateYAML(SelectFields(http.HandlerFunc(TenantsPlanQueryHandler)))))
+	router.Path("/admin/tenantsplan/reencrypt").Methods(http.MethodPost).Name("tenants plan reencrypt").
+		Handler(SuperRoleRequired(http.HandlerFunc(ReencryptTenantPlansHandler)))
+	router.Path("/admin/apply").Methods(http.MethodPost).Name("declarative apply").
+		Handler(SuperRoleRequired(NegotiateYAML(http.HandlerFunc(ApplyHandler))))
+	router.Path("/admin/tenantsplan/batch").Methods(http.MethodPost).Name("tenants plan batch").
+		Handler(SuperRoleRequired(NegotiateYAML(ValidateBody(schema.TenantPlanBatch, http.HandlerFunc(TenantPlanBatchHandler)))))
+	router.Path("/admin/tenantsplan/batch/{id}").Methods(http.MethodGet).Name("tenants plan batch job").
+		Handler(SuperRoleRequired(NegotiateYAML(http.HandlerFunc(TenantPlanBatchJobHandler))))
+	router.Path("/admin/tenants/recommendations").Methods(http.MethodGet).Name("tenant plan recommendations").
+		Handler(SuperRoleRequired(http.HandlerFunc(PlanRecommendationsHandler)))
+	router.Path("/admin/tenants/{tenant}/sla").Methods(http.MethodGet).Name("tenant sla").
+		Handler(AuthVerifyTenantJWT(http.HandlerFunc(TenantSLAHandler)))
+	router.Path("/admin/tenants/{tenant}/probe").Methods(http.MethodGet).Name("tenant synthetic probe").
+		Handler(AuthVerifyTenantJWT(http.HandlerFunc(SyntheticProbeHandler)))
+	router.Path("/admin/tenants/{tenant}/api-usage").Methods(http.MethodGet).Name("tenant api usage").
+		Handler(AuthVerifyTenantJWT(http.HandlerFunc(TenantAPIUsageHandler)))
+	router.Path("/admin/tenantsplan/{tenant}/metadata").Methods(http.MethodPatch).Name("tenant plan metadata").
+		Handler(SuperRoleRequired(NegotiateYAML(ValidateBody(schema.TenantMetadata, http.HandlerFunc(TenantMetadataHandler)))))
+	router.Path("/admin/tenantsplan/change-rules").Methods(http.MethodGet, http.MethodPut).Name("tenants plan change rules").
+		Handler(SuperRoleRequired(NegotiateYAML(ValidateBody(schema.PlanChangeRules, http.HandlerFunc(PlanChangeRulesHandler)))))
+	router.Path("/admin/tenantsplan/watch").Methods(http.MethodGet).Name("tenants plan watch firehose").
+		Handler(SuperRoleRequired(http.HandlerFunc(TenantPlanWatchHandler)))
+	router.Path("/admin/tenantsplan/{tenant}/watch").Methods(http.MethodGet).Name("tenant plan watch").
+		Handler(AuthVerifyTenantJWT(http.HandlerFunc(TenantPlanWatchHandler)))
+	router.Path("/admin/cluster/health").Methods(http.MethodGet).Name("cluster health").
+		Handler(SuperRoleRequired(http.HandlerFunc(ClusterHealthHandler)))
+	router.Path("/admin/internal/pulsar-token").Methods(http.MethodGet, http.MethodPost).Name("pulsar token").
+		Handler(SuperRoleRequired(http.HandlerFunc(PulsarTokenHandler)))
+	router.Path("/admin/internal/memory").Methods(http.MethodGet).Name("memory watermark").
+		Handler(SuperRoleRequired(http.HandlerFunc(MemoryWatermarkHandler)))
+	router.Path("/admin/internal/pulsar-clients").Methods(http.MethodGet).Name("pulsar clients").
+		Handler(SuperRoleRequired(http.HandlerFunc(PulsarClientsHandler)))
+	router.Path("/admin/internal/reader-cursors").Methods(http.MethodGet, http.MethodPost).Name("reader cursors").
+		Handler(SuperRoleRequired(http.HandlerFunc(ReaderCursorsHandler)))
+	router.Path("/admin/internal/bundle-advisor").Methods(http.MethodGet).Name("bundle advisor").
+		Handler(SuperRoleRequired(http.HandlerFunc(BundleAdvisorHandler)))
+	router.Path("/admin/internal/auth-decisions").Methods(http.MethodGet).Name("auth decisions").
+		Handler(SuperRoleRequired(http.HandlerFunc(SubjectDecisionsHandler)))
+	router.Path("/admin/internal/connections-summary").Methods(http.MethodGet).Name("connections summary").
+		Handler(SuperRoleRequired(http.HandlerFunc(ConnectionsSummaryHandler)))
+	router.Path("/admin/internal/retention-summary").Methods(http.MethodGet).Name("retention summary").
+		Handler(SuperRoleRequired(http.HandlerFunc(RetentionSummaryHandler)))
+	router.Path("/admin/internal/upstreams").Methods(http.MethodGet).Name("admin upstreams").
+		Handler(SuperRoleRequired(http.HandlerFunc(AdminUpstreamsHandler)))
+	router.Path("/admin/internal/upstream-credentials").Methods(http.MethodGet, http.MethodPost).Name("upstream credentials").
+		Handler(SuperRoleRequired(http.HandlerFunc(UpstreamCredentialsHandler)))
+	router.Path("/admin/internal/synthetic-probe").Methods(http.MethodGet).Name("synthetic probe").
+		Handler(SuperRoleRequired(http.HandlerFunc(SyntheticProbeHandler)))
+	router.Path("/admin/internal/admin-api-versions").Methods(http.MethodGet, http.MethodPost).Name("admin api versions").
+		Handler(SuperRoleRequired(http.HandlerFunc(AdminAPIVersionsHandler)))
+	router.Path("/admin/internal/backlog-quota").Methods(http.MethodGet).Name("backlog quota").
+		Handler(SuperRoleRequired(http.HandlerFunc(BacklogQuotaHandler)))
+	router.Path("/admin/internal/deleted-tenants").Methods(http.MethodGet).Name("deleted tenants").
+		Handler(SuperRoleRequired(http.HandlerFunc(DeletedTenantsHandler)))
+	router.Path("/admin/internal/tenant-topic").Methods(http.MethodGet).Name("tenant topic stats").
+		Handler(SuperRoleRequired(http.HandlerFunc(TenantTopicHandler)))
+	router.Path("/admin/internal/tenant-topic/compaction").Methods(http.MethodPost).Name("tenant topic compaction").
+		Handler(SuperRoleRequired(http.HandlerFunc(TenantTopicCompactionHandler)))
+	router.Path("/admin/internal/tenant-topic/trim").Methods(http.MethodPost).Name("tenant topic trim").
+		Handler(SuperRoleRequired(http.HandlerFunc(TenantTopicTrimHandler)))
+	router.Path("/admin/routes").Methods(http.MethodGet).Name("routes").
+		Handler(SuperRoleRequired(NegotiateYAML(http.HandlerFunc(RoutesHandler))))
+	router.Path("/admin/config").Methods(http.MethodGet).Name("config").
+		Handler(SuperRoleRequired(NegotiateYAML(http.HandlerFunc(ConfigHandler))))
+	router.Path("/admin/ratelimits").Methods(http.MethodGet, http.MethodPut).Name("rate limits").
+		Handler(SuperRoleRequired(NegotiateYAML(ValidateBody(schema.RateLimits, http.HandlerFunc(RateLimitsHandler)))))
+	router.Path("/admin/quota-enforcement").Methods(http.MethodGet, http.MethodPut).Name("quota enforcement").
+		Handler(SuperRoleRequired(NegotiateYAML(ValidateBody(schema.QuotaEnforcement, http.HandlerFunc(QuotaEnforcementHandler)))))
+	router.Path("/admin/priority").Methods(http.MethodGet, http.MethodPut).Name("request priority").
+		Handler(SuperRoleRequired(NegotiateYAML(ValidateBody(schema.Priority, http.HandlerFunc(PriorityHandler)))))
+	router.Path("/admin/ratelimits/exemptions").Methods(http.MethodGet, http.MethodPut).Name("rate limit exemptions").
+		Handler(SuperRoleRequired(NegotiateYAML(ValidateBody(schema.RateLimitExemptions, http.HandlerFunc(RateLimitExemptionsHandler)))))
+	router.Path("/events/{tenant}").Methods(http.MethodGet).Name("tenant events").
+		Handler(AuthVerifyTenantJWT(http.HandlerFunc(TenantEventsHandler)))
+	router.Path("/k/tenant/{tenant}/audit").Methods(http.MethodGet).Name("kafkaesque tenant audit").
+		Handler(SignedURLAuth(AuthVerifyTenantJWT, http.HandlerFunc(TenantAuditHandler)))
+	router.Path("/admin/audit/archive").Methods(http.MethodGet, http.MethodPost).Name("audit archive").
+		Handler(SuperRoleRequired(http.HandlerFunc(AuditArchiveHandler)))
+	router.Path("/admin/audit/archive/verify").Methods(http.MethodGet).Name("audit archive verify").
+		Handler(SuperRoleRequired(http.HandlerFunc(AuditArchiveVerifyHandler)))
+	router.Path("/k/tenant/{tenant}/signed-url").Methods(http.MethodPost).Name("kafkaesque tenant signed url").
+		Handler(AuthVerifyTenantJWT(ValidateBody(schema.SignedURL, http.HandlerFunc(SignedURLHandler))))
+	router.Path("/k/tenant/{tenant}/cors").Methods(http.MethodGet, http.MethodPut).Name("kafkaesque tenant cors").
+		Handler(AuthVerifyTenantJWT(ValidateBody(schema.AllowedOrigins, http.HandlerFunc(TenantCORSHandler))))
+	router.Path("/k/tenant/{tenant}/protection").Methods(http.MethodGet, http.MethodPut).Name("kafkaesque tenant deletion protection").
+		Handler(SuperRoleRequired(ValidateBody(schema.TenantProtection, http.HandlerFunc(TenantProtectionHandler))))
+	router.Path("/k/tenant/{tenant}/sso").Methods(http.MethodGet, http.MethodPut).Name("kafkaesque tenant sso").
+		Handler(SuperRoleRequired(ValidateBody(schema.TenantSSO, http.HandlerFunc(TenantSSOHandler))))
+	router.Path("/k/sso/resolve").Methods(http.MethodPost).Name("sso tenants resolve").
+		Handler(SuperRoleRequired(ValidateBody(schema.SSOResolve, http.HandlerFunc(SSOResolveHandler))))
+	router.Path("/k/tenant/{tenant}/notification").Methods(http.MethodPost).Name("kafkaesque tenant notification").
+		Handler(SuperRoleRequired(ValidateBody(schema.TenantNotification, http.HandlerFunc(TenantNotificationHandler))))
+	router.Path("/k/tenant/{tenant}/usage-report").Methods(http.MethodGet, http.MethodPost).Name("kafkaesque tenant usage report").
+		Handler(SuperRoleRequired(http.HandlerFunc(TenantUsageReportHandler)))
+
+	if chaos.Enabled() {
+		// fault injection for resilience testing, never enabled in production
+		router.Path("/admin/faults").Methods(http.MethodGet, http.MethodPut, http.MethodDelete).Name("faults").
+			Handler(SuperRoleRequired(http.HandlerFunc(FaultsHandler)))
+	}
 
 	if util.GetConfig().PulsarBeamTopic != "" {
 		// Pulsar Beam topic and webhook management URL
@@ -81,17 +243,45 @@ func NewRouter() *mux.Router {
 			Handler(AuthVerifyJWT(http.HandlerFunc(PulsarBeamUpdateTopicHandler)))
 	}
 
+	if util.GetConfig().PulsarSQLURL != "" {
+		// Pulsar SQL query submission, and the following result pages and cancellation
+		router.Path("/sql/{tenant}/v1/statement").Methods(http.MethodPost).Name("pulsar sql statement").
+			Handler(AuthVerifyTenantJWT(http.HandlerFunc(PulsarSQLStatementHandler)))
+		router.PathPrefix("/sql/{tenant}/v1/statement/").Methods(http.MethodGet, http.MethodDelete).Name("pulsar sql statement results").
+			Handler(AuthVerifyTenantJWT(http.HandlerFunc(PulsarSQLStatementHandler)))
+	}
+
+	if util.GetConfig().EnableGraphQL == "true" {
+		// combined tenant plan, usage, namespaces, functions, and audit query
+		router.Path("/graphql").Methods(http.MethodGet, http.MethodPost).Name("graphql").
+			Handler(AuthVerifyJWT(http.HandlerFunc(GraphQLHandler)))
+	}
+
 	// Collect tenant topics statistics in one call
 	router.Path("/stats/topics/{tenant}").Methods(http.MethodGet).Name("tenant topic stats").
 		Handler(AuthVerifyTenantJWT(http.HandlerFunc(TenantTopicStatsHandler)))
+	// Paginated partition internal stats with ledger summary
+	router.Path("/stats-internal/{tenant}/{namespace}/{topic}").Methods(http.MethodGet).Name("topic internal stats").
+		Handler(AuthVerifyTenantJWT(http.HandlerFunc(TopicInternalStatsHandler)))
 
 	// Retrieve function logs, instance is optional and default to 0
 	router.Path("/function-logs/{tenant}/{namespace}/{function}").Methods(http.MethodGet).Name("function-logs").
-		Handler(AuthVerifyTenantJWT(http.HandlerFunc(FunctionLogsHandler)))
+		Handler(SignedURLAuth(AuthVerifyTenantJWT, http.HandlerFunc(FunctionLogsHandler)))
 	router.Path("/function-logs/{tenant}/{namespace}/{function}/{instance}").Methods(http.MethodGet).Name("function-logs").
-		Handler(AuthVerifyTenantJWT(http.HandlerFunc(FunctionLogsHandler)))
+		Handler(SignedURLAuth(AuthVerifyTenantJWT, http.HandlerFunc(FunctionLogsHandler)))
+	router.Path("/function-logs/{tenant}/search").Methods(http.MethodGet).Name("function-logs").
+		Handler(SignedURLAuth(AuthVerifyTenantJWT, http.HandlerFunc(FunctionLogSearchHandler)))
+	router.Path("/function-resources/{tenant}").Methods(http.MethodGet).Name("function-resources").
+		Handler(AuthVerifyTenantJWT(SelectFields(http.HandlerFunc(FunctionResourcesHandler))))
 	router.Path("/function-status/{tenant}/{namespace}/{function}").Methods(http.MethodGet).Name("function-logs-status").
-		Handler(AuthVerifyTenantJWT(http.HandlerFunc(FunctionStatusHandler)))
+		Handler(AuthVerifyTenantJWT(SelectFields(http.HandlerFunc(FunctionStatusHandler))))
+
+	// partitioned topic creation with the plan partition cap
+	router.Path("/admin/topics/{tenant}/{namespace}/{topic}/partitions").Methods(http.MethodPut).Name("partitioned topic creation").
+		Handler(AuthVerifyTenantJWT(Idempotent(ValidateBody(schema.Partitions, http.HandlerFunc(PartitionedTopicHandler)))))
+	router.Path("/admin/v2/{domain:persistent|non-persistent}/{tenant}/{namespace}/{topic}/partitions").Methods(http.MethodPut, http.MethodPost).
+		Name("partitioned topic creation").
+		Handler(AuthVerifyTenantJWT(Idempotent(ValidateBody(schema.Partitions, http.HandlerFunc(PartitionedTopicHandler)))))
 
 	// aggregated topics under namespaces
 	router.Path("/admin/v2/topics/{tenant}").Methods(http.MethodGet).Name("topics-grouped-by-namespaces").
@@ -135,12 +325,12 @@ func NewRouter() *mux.Router {
 		Handler(SuperRoleRequired(http.HandlerFunc(NamespacePolicyProxyHandler)))
 	router.PathPrefix("/admin/v2/namespaces/{tenant}/{namespace}/messageTTL").Methods(http.MethodPost).
 		Handler(SuperRoleRequired(http.HandlerFunc(NamespacePolicyProxyHandler)))
-	router.PathPrefix("/admin/v2/namespaces/{tenant}/{namespace}/offloadDeletionLagMs").Methods(http.MethodPut, http.MethodDelete).
-		Handler(SuperRoleRequired(http.HandlerFunc(NamespacePolicyProxyHandler)))
-	router.PathPrefix("/admin/v2/namespaces/{tenant}/{namespace}/offloadPolicies").Methods(http.MethodPost).
-		Handler(SuperRoleRequired(http.HandlerFunc(NamespacePolicyProxyHandler)))
-	router.PathPrefix("/admin/v2/namespaces/{tenant}/{namespace}/offloadThreshold").Methods(http.MethodPut).
-		Handler(SuperRoleRequired(http.HandlerFunc(NamespacePolicyProxyHandler)))
+	router.Path("/admin/v2/namespaces/{tenant}/{namespace}/offloadDeletionLagMs").Methods(http.MethodGet, http.MethodPut, http.MethodDelete).
+		Handler(AuthVerifyTenantJWT(http.HandlerFunc(OffloadPolicyHandler)))
+	router.Path("/admin/v2/namespaces/{tenant}/{namespace}/offloadPolicies").Methods(http.MethodGet, http.MethodPost, http.MethodDelete).
+		Handler(AuthVerifyTenantJWT(http.HandlerFunc(OffloadPolicyHandler)))
+	router.Path("/admin/v2/namespaces/{tenant}/{namespace}/offloadThreshold").Methods(http.MethodGet, http.MethodPut).
+		Handler(AuthVerifyTenantJWT(http.HandlerFunc(OffloadPolicyHandler)))
 	router.PathPrefix("/admin/v2/namespaces/{tenant}/{namespace}/schemaAutoUpdateCompatibilityStrategy").Methods(http.MethodPut).
 		Handler(SuperRoleRequired(http.HandlerFunc(NamespacePolicyProxyHandler)))
 
@@ -152,12 +342,19 @@ func NewRouter() *mux.Router {
 	router.PathPrefix("/admin/v2/namespaces/{tenant}/{namespace}/deduplication").Methods(http.MethodPost).
 		Handler(AuthVerifyTenantJWT(http.HandlerFunc(DirectBrokerProxyHandler)))
 
-	router.PathPrefix("/admin/v2/namespaces/{tenant}/{namespace}/permissions/{role}").Methods(http.MethodPost, http.MethodDelete).
+	router.Path("/admin/v2/namespaces/{tenant}/{namespace}/permissions/subscription/{subscription}").Methods(http.MethodPost).
+		Handler(AuthVerifyTenantJWT(http.HandlerFunc(SubscriptionPermissionProxyHandler)))
+	router.Path("/admin/v2/namespaces/{tenant}/{namespace}/permissions/{subscription}/{role}").Methods(http.MethodDelete).
+		Handler(AuthVerifyTenantJWT(http.HandlerFunc(PermissionProxyHandler)))
+	router.Path("/admin/v2/namespaces/{tenant}/{namespace}/permissions/{role}").Methods(http.MethodPost, http.MethodDelete).
+		Handler(AuthVerifyTenantJWT(http.HandlerFunc(PermissionProxyHandler)))
+	// any other permission change
+	router.PathPrefix("/admin/v2/namespaces/{tenant}/{namespace}/permissions/").Methods(http.MethodPost, http.MethodPut, http.MethodDelete).
 		Handler(SuperRoleRequired(http.HandlerFunc(DirectBrokerProxyHandler)))
 	router.PathPrefix("/admin/v2/namespaces/{tenant}/{namespace}/persistence").Methods(http.MethodPost).
 		Handler(SuperRoleRequired(http.HandlerFunc(DirectBrokerProxyHandler)))
-	router.PathPrefix("/admin/v2/namespaces/{tenant}/{namespace}/replication").Methods(http.MethodPost).
-		Handler(SuperRoleRequired(http.HandlerFunc(DirectBrokerProxyHandler)))
+	router.Path("/admin/v2/namespaces/{tenant}/{namespace}/replication").Methods(http.MethodGet, http.MethodPost).
+		Handler(AuthVerifyTenantJWT(http.HandlerFunc(GeoReplicationHandler)))
 	router.PathPrefix("/admin/v2/namespaces/{tenant}/{namespace}/replicatorDispatchRate").Methods(http.MethodPost).
 		Handler(SuperRoleRequired(http.HandlerFunc(DirectBrokerProxyHandler)))
 	router.PathPrefix("/admin/v2/namespaces/{tenant}/{namespace}/retention").Methods(http.MethodPost).
@@ -170,6 +367,10 @@ func NewRouter() *mux.Router {
 		Handler(SuperRoleRequired(http.HandlerFunc(DirectBrokerProxyHandler)))
 	router.PathPrefix("/admin/v2/namespaces/{tenant}/{namespace}/unload").Methods(http.MethodPut).
 		Handler(SuperRoleRequired(http.HandlerFunc(DirectBrokerProxyHandler)))
+	router.Path("/admin/v2/namespaces/{tenant}/{namespace}/{bundle}/split").Methods(http.MethodPut).
+		Handler(SuperRoleRequired(http.HandlerFunc(BundleOperationHandler)))
+	router.Path("/admin/v2/namespaces/{tenant}/{namespace}/{bundle}/unload").Methods(http.MethodPut).
+		Handler(SuperRoleRequired(http.HandlerFunc(BundleOperationHandler)))
 
 	router.PathPrefix("/admin/v2/namespaces/{tenant}/{namespace}/{bundle}").Methods(http.MethodDelete).
 		Handler(SuperRoleRequired(http.HandlerFunc(DirectBrokerProxyHandler)))
@@ -208,7 +409,7 @@ func NewRouter() *mux.Router {
 	// including admin/v2/namespaces/{tenant}/{namespace}/dispatchRate,
 	// including admin/v2/namespaces/{tenant}/{namespace}/isAllowAutoUpdateSchema
 	router.PathPrefix("/admin/v2/namespaces/{tenant}/{namespace}").Methods(http.MethodGet, http.MethodPut, http.MethodDelete, http.MethodPost).
-		Handler(AuthVerifyTenantJWT(http.HandlerFunc(NamespaceLimitEnforceProxyHandler)))
+		Handler(AuthVerifyTenantJWT(Idempotent(http.HandlerFunc(NamespaceLimitEnforceProxyHandler))))
 
 	router.PathPrefix("/admin/v2/namespaces/{tenant}").Methods(http.MethodGet, http.MethodPut, http.MethodDelete).
 		Handler(AuthVerifyTenantJWT(http.HandlerFunc(CachedProxyHandler)))
@@ -223,14 +424,22 @@ func NewRouter() *mux.Router {
 	//
 	// persistent topic
 	//
+	router.Path("/admin/v2/persistent/{tenant}/{namespace}/{topic}/permissions/{role}").Methods(http.MethodPost, http.MethodDelete).
+		Handler(AuthVerifyTenantJWT(http.HandlerFunc(PermissionProxyHandler)))
+	router.PathPrefix("/admin/v2/persistent/{tenant}/{namespace}/{topic}/permissions/").Methods(http.MethodPost, http.MethodPut, http.MethodDelete).
+		Handler(SuperRoleRequired(http.HandlerFunc(DirectBrokerProxyHandler)))
 	router.PathPrefix("/admin/v2/persistent/{tenant}/{namespace}").Methods(http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete).
-		Handler(AuthVerifyTenantJWT(http.HandlerFunc(TopicProxyHandler)))
+		Handler(AuthVerifyTenantJWT(Idempotent(http.HandlerFunc(TopicProxyHandler))))
 
 	// /admin/v2/persistent/{tenant}/{namespace}/partitioned
 
 	// non-persistent topic
+	router.Path("/admin/v2/non-persistent/{tenant}/{namespace}/{topic}/permissions/{role}").Methods(http.MethodPost, http.MethodDelete).
+		Handler(AuthVerifyTenantJWT(http.HandlerFunc(PermissionProxyHandler)))
+	router.PathPrefix("/admin/v2/non-persistent/{tenant}/{namespace}/{topic}/permissions/").Methods(http.MethodPost, http.MethodPut, http.MethodDelete).
+		Handler(SuperRoleRequired(http.HandlerFunc(DirectBrokerProxyHandler)))
 	router.PathPrefix("/admin/v2/non-persistent/{tenant}/{namespace}").Methods(http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete).
-		Handler(AuthVerifyTenantJWT(http.HandlerFunc(TopicProxyHandler)))
+		Handler(AuthVerifyTenantJWT(Idempotent(http.HandlerFunc(TopicProxyHandler))))
 
 	//
 	// /resource-quotas
@@ -262,6 +471,10 @@ func NewRouter() *mux.Router {
 		Handler(AuthVerifyTenantJWT(http.HandlerFunc(CachedProxyHandler)))
 	router.PathPrefix("/admin/v2/tenants").Methods(http.MethodGet).
 		Handler(AuthVerifyJWT(http.HandlerFunc(RestrictedTenantsProxyHandler)))
+	router.Path("/admin/v2/tenants/{tenant}").Methods(http.MethodPut).
+		Handler(SuperRoleRequired(Idempotent(http.HandlerFunc(CreateTenantProxyHandler))))
+	router.Path("/admin/v2/tenants/{tenant}").Methods(http.MethodDelete).
+		Handler(SuperRoleRequired(http.HandlerFunc(DeleteTenantProxyHandler)))
 	router.PathPrefix("/admin/v2/tenants").Methods(http.MethodPost, http.MethodPut, http.MethodDelete).
 		Handler(SuperRoleRequired(http.HandlerFunc(CachedProxyHandler)))
 
@@ -287,6 +500,9 @@ func NewRouter() *mux.Router {
 	router.PathPrefix("/admin/v3/sources/{tenant}").Methods(http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete).
 		Handler(AuthVerifyTenantJWT(http.HandlerFunc(DirectFunctionProxyHandler)))
 
+	router.PathPrefix("/admin/v2/sources/{tenant}").Methods(http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete).
+		Handler(AuthVerifyTenantJWT(http.HandlerFunc(DirectFunctionProxyHandler)))
+
 	//
 	// /sinks
 	//
@@ -299,10 +515,29 @@ func NewRouter() *mux.Router {
 	router.PathPrefix("/admin/v3/sinks/{tenant}").Methods(http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete).
 		Handler(AuthVerifyTenantJWT(http.HandlerFunc(DirectFunctionProxyHandler)))
 
+	router.PathPrefix("/admin/v2/sinks/{tenant}").Methods(http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete).
+		Handler(AuthVerifyTenantJWT(http.HandlerFunc(DirectFunctionProxyHandler)))
+
+	router.Use(RequestID)
+	if util.GetConfig().AccessLogFormat != "" {
+		router.Use(AccessLog)
+	}
+
+	router.Use(RequestLatency)
+	router.Use(TenantSLA)
+	router.Use(RequestDeadline)
+	if util.IsReadOnlyMode() {
+		router.Use(ReadOnly)
+	}
+
 	// TODO rate limit can be added per route basis
+	router.Use(ShedOnMemoryPressure)
+	router.Use(Prioritize)
 	router.Use(LimitRate)
+	router.Use(MeterAPICalls)
 
 	router.Use(ResponseJSONContentType)
+	setActiveRouter(router)
 
 	log.Warnf("router added")
 	return router
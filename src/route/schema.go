@@ -0,0 +1,69 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package route
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/datastax/burnell/src/policy"
+	"github.com/datastax/burnell/src/schema"
+	"github.com/datastax/burnell/src/util"
+)
+
+// ValidateBody validates POST and PUT request bodies against the embedded JSON schema,
+// the violations are replied with 400 and the path and reason of each field.
+func ValidateBody(name string, next http.Handler) http.Handler {
+	s, ok := schema.Get(name)
+	if !ok {
+		panic("unknown request body schema " + name)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodPut {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			util.ResponseErrorJSON(errors.New("can't read body"), w, http.StatusBadRequest)
+			return
+		}
+		if err := s.Validate(body); err != nil {
+			var schemaErrs schema.Errors
+			if !errors.As(err, &schemaErrs) {
+				util.ResponseErrorJSON(err, w, http.StatusBadRequest)
+				return
+			}
+			fields := make([]policy.FieldError, len(schemaErrs))
+			for i, v := range schemaErrs {
+				fields[i] = policy.FieldError{Field: v.Path, Reason: v.Reason}
+			}
+			responseTenantPlanError(&policy.ValidationError{Fields: fields}, w, http.StatusBadRequest)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		next.ServeHTTP(w, r)
+	})
+}
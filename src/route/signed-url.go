@@ -0,0 +1,206 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package route
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/datastax/burnell/src/util"
+	"github.com/gorilla/mux"
+)
+
+// the query parameters of a signed URL
+const (
+	signedExpiresParam   = "expires"
+	signedSubjectParam   = "sub"
+	signedSignatureParam = "signature"
+)
+
+var (
+	signedURLDefaultTTL = time.Duration(util.GetEnvInt("SignedURLDefaultTTLSeconds", 300)) * time.Second
+	signedURLMaxTTL     = time.Duration(util.GetEnvInt("SignedURLMaxTTLSeconds", 3600)) * time.Second
+)
+
+var (
+	signedURLKey     []byte
+	signedURLKeyOnce sync.Once
+)
+
+// SignedURLRequest is the request to sign a download URL
+type SignedURLRequest struct {
+	Path       string `json:"path"`
+	TTLSeconds int    `json:"ttlSeconds"`
+}
+
+// SignedURLResponse is a signed download URL relative to the burnell host
+type SignedURLResponse struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// signingKey returns the SignedURLSecretKey file content, or a random key that is only valid in this process
+func signingKey() []byte {
+	signedURLKeyOnce.Do(func() {
+		if file := util.GetConfig().SignedURLSecretKey; file != "" {
+			data, err := ioutil.ReadFile(file)
+			if err == nil && len(data) > 0 {
+				signedURLKey = data
+				return
+			}
+			log.Errorf("failed to load signed URL secret key %s, use a random key %v", file, err)
+		}
+		signedURLKey = make([]byte, 32)
+		if _, err := rand.Read(signedURLKey); err != nil {
+			log.Fatalf("failed to generate signed URL key %v", err)
+		}
+	})
+	return signedURLKey
+}
+
+func urlSignature(path string, query url.Values) string {
+	mac := hmac.New(sha256.New, signingKey())
+	mac.Write([]byte(path + "?" + query.Encode()))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// SignURL signs the GET of the path and query on behalf of the subject until the expiry
+func SignURL(rawPath, subject string, expires time.Time) (string, error) {
+	u, err := url.Parse(rawPath)
+	if err != nil {
+		return "", err
+	}
+	query := u.Query()
+	query.Del(signedSignatureParam)
+	query.Set(signedExpiresParam, strconv.FormatInt(expires.Unix(), 10))
+	query.Set(signedSubjectParam, subject)
+	query.Set(signedSignatureParam, urlSignature(u.Path, query))
+	u.RawQuery = query.Encode()
+	return u.RequestURI(), nil
+}
+
+// VerifySignedURL returns the signing subject of a valid and unexpired signed request
+func VerifySignedURL(r *http.Request, now time.Time) (string, error) {
+	query := r.URL.Query()
+	signature := query.Get(signedSignatureParam)
+	query.Del(signedSignatureParam)
+	if !hmac.Equal([]byte(signature), []byte(urlSignature(r.URL.Path, query))) {
+		return "", errors.New("invalid signature")
+	}
+	expires, err := strconv.ParseInt(query.Get(signedExpiresParam), 10, 64)
+	if err != nil || now.Unix() > expires {
+		return "", errors.New("signed URL expired")
+	}
+	return query.Get(signedSubjectParam), nil
+}
+
+// signablePath returns if the subject can sign the download path, the tenant's own function logs,
+// namespace usage and audit, and the all tenants usage for superusers
+func signablePath(tenant, subject, rawPath string) bool {
+	u, err := url.Parse(rawPath)
+	if err != nil || u.IsAbs() || strings.Contains(u.Path, "..") {
+		return false
+	}
+	if util.StrContains(util.SuperRoles, subject) && u.Path == "/tenantsusage" {
+		return true
+	}
+	return strings.HasPrefix(u.Path, "/function-logs/"+tenant+"/") ||
+		u.Path == "/namespacesusage/"+tenant ||
+		u.Path == "/k/tenant/"+tenant+"/audit"
+}
+
+// SignedURLHandler issues a short-lived signed URL of a download route under the tenant
+func SignedURLHandler(w http.ResponseWriter, r *http.Request) {
+	var req SignedURLRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusBadRequest)
+		return
+	}
+	subject := r.Header.Get(injectedSubs)
+	if !signablePath(mux.Vars(r)["tenant"], subject, req.Path) {
+		util.ResponseErrorJSON(errors.New("the path is not a download route of the tenant"), w, http.StatusForbidden)
+		return
+	}
+	ttl := signedURLDefaultTTL
+	if req.TTLSeconds > 0 {
+		ttl = time.Duration(req.TTLSeconds) * time.Second
+	}
+	if ttl > signedURLMaxTTL {
+		ttl = signedURLMaxTTL
+	}
+	expires := time.Now().Add(ttl)
+	signed, err := SignURL(req.Path, subject, expires)
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusUnprocessableEntity)
+		return
+	}
+	data, err := json.Marshal(SignedURLResponse{URL: signed, ExpiresAt: expires.Truncate(time.Second)})
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// SignedURLAuth authorizes a download with a signed URL on behalf of the signing subject,
+// the signing subject must still match the route tenant, or be a superuser on a route without tenant.
+// A request without signature is authorized by the JWT auth, whose policy the route reports.
+func SignedURLAuth(jwtAuth func(http.Handler) http.Handler, next http.Handler) http.Handler {
+	tokenAuth := jwtAuth(next)
+	policy := "signed-url"
+	if p, ok := tokenAuth.(policyHandler); ok {
+		policy = p.policy
+	}
+	return authPolicy(policy, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get(signedSignatureParam) == "" || r.Method != http.MethodGet {
+			tokenAuth.ServeHTTP(w, r)
+			return
+		}
+		subject, err := VerifySignedURL(r, time.Now())
+		if err != nil {
+			auditAuthFailure(r, "", err.Error())
+			util.ResponseErrorJSON(errors.New("Unauthorized"), w, http.StatusUnauthorized)
+			return
+		}
+		tenant, ok := mux.Vars(r)["tenant"]
+		if (ok && !VerifySubject(tenant, subject)) || (!ok && !util.StrContains(util.SuperRoles, subject)) {
+			auditAuthFailure(r, subject, "signed URL subject is not authorized")
+			util.ResponseErrorJSON(errors.New("Unauthorized"), w, http.StatusUnauthorized)
+			return
+		}
+		r.Header.Set(injectedSubs, subject)
+		next.ServeHTTP(w, r)
+	})
+}
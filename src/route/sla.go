@@ -0,0 +1,96 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package route
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/datastax/burnell/src/metrics"
+	"github.com/datastax/burnell/src/util"
+	"github.com/gorilla/mux"
+)
+
+// upstreamObservation counts the upstream calls of a request and the failed ones
+type upstreamObservation struct {
+	calls    int32
+	failures int32
+}
+
+type upstreamObservationKey struct{}
+
+// upstreamFailed is a connection failure or the upstream replies unavailable
+func upstreamFailed(response *http.Response, err error) bool {
+	if err != nil || response == nil {
+		return true
+	}
+	switch response.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// observeUpstreamResult counts an upstream call for the tenant SLA
+func observeUpstreamResult(r *http.Request, failed bool) {
+	if o, ok := r.Context().Value(upstreamObservationKey{}).(*upstreamObservation); ok {
+		atomic.AddInt32(&o.calls, 1)
+		if failed {
+			atomic.AddInt32(&o.failures, 1)
+		}
+	}
+}
+
+// TenantSLA is the middleware to record the response status and the upstream availability of the requests with a tenant
+func TenantSLA(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant := mux.Vars(r)["tenant"]
+		if tenant == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		o := &upstreamObservation{}
+		writer := &accessWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(writer, r.WithContext(context.WithValue(r.Context(), upstreamObservationKey{}, o)))
+		metrics.RecordTenantRequest(tenant, writer.status, atomic.LoadInt32(&o.calls) > 0, atomic.LoadInt32(&o.failures) > 0, time.Now())
+	})
+}
+
+// TenantSLAHandler reports the monthly SLA of a tenant, the query parameter month is YYYY-MM, default to the current month
+func TenantSLAHandler(w http.ResponseWriter, r *http.Request) {
+	tenant := mux.Vars(r)["tenant"]
+	month := queryParamString(r.URL.Query(), "month", time.Now().UTC().Format("2006-01"))
+	report, err := metrics.GetTenantSLAReport(tenant, month)
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusBadRequest)
+		return
+	}
+	data, err := json.Marshal(report)
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
+		return
+	}
+	w.Write(data)
+}
@@ -0,0 +1,154 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package route
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/datastax/burnell/src/util"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// the reasons of a subject verification decision
+const (
+	SuperRoleAllowed     = "superrole"
+	TenantMatchAllowed   = "tenant-match"
+	EmptySubjectDenied   = "empty-subject"
+	CaseMismatchDenied   = "case-mismatch"
+	SuffixMismatchDenied = "suffix-mismatch"
+	TenantMismatchDenied = "tenant-mismatch"
+)
+
+// SubjectDecision is a subject verification verdict
+type SubjectDecision struct {
+	Time            time.Time `json:"time"`
+	RequiredSubject string    `json:"requiredSubject"`
+	TokenSubject    string    `json:"tokenSubject"`
+	Allowed         bool      `json:"allowed"`
+	Reason          string    `json:"reason"`
+}
+
+// SubjectDecisionStats is the verdict counters by reason and the recent rejected decisions
+type SubjectDecisionStats struct {
+	Counters map[string]uint64 `json:"counters"`
+	Recent   []SubjectDecision `json:"recent"`
+}
+
+var (
+	subjectDecisionCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "burnell",
+		Subsystem: "auth",
+		Name:      "subject_decisions_total",
+		Help:      "The number of subject verification decisions by the reason.",
+	}, []string{"reason"})
+
+	// the number of recent rejected decisions to keep, the decision log is disabled with 0
+	subjectDecisionLogSize = util.GetEnvInt("SubjectDecisionLogSize", 0)
+
+	subjectDecisionCounts = make(map[string]uint64)
+	subjectDecisionLog    = []SubjectDecision{}
+	subjectDecisionLock   = sync.Mutex{}
+)
+
+func init() {
+	prometheus.MustRegister(subjectDecisionCounter)
+}
+
+// EvaluateSubject verifies the subject meets the required subject and returns the reason of the verdict
+func EvaluateSubject(requiredSubject, tokenSubjects string) (bool, string) {
+	for _, v := range strings.Split(tokenSubjects, ",") {
+		if v == "" {
+			return false, EmptySubjectDenied
+		}
+		if util.StrContains(util.SuperRoles, v) {
+			return true, SuperRoleAllowed
+		}
+		subCase1, subCase2 := ExtractTenant(v)
+		if requiredSubject == subCase1 || requiredSubject == subCase2 {
+			return true, TenantMatchAllowed
+		}
+		if lower1, lower2 := ExtractTenant(strings.ToLower(v)); strings.ToLower(requiredSubject) == lower1 || strings.ToLower(requiredSubject) == lower2 {
+			return false, CaseMismatchDenied
+		}
+		if strings.HasPrefix(v, requiredSubject+subDelimiter) {
+			// the subject starts with the tenant but the suffix is not a recognized role or key
+			return false, SuffixMismatchDenied
+		}
+		return false, TenantMismatchDenied
+	}
+	return false, EmptySubjectDenied
+}
+
+// recordSubjectDecision counts the verdict and keeps the rejected decision in the decision log
+func recordSubjectDecision(requiredSubject, tokenSubjects string, allowed bool, reason string) {
+	subjectDecisionCounter.WithLabelValues(reason).Inc()
+	subjectDecisionLock.Lock()
+	defer subjectDecisionLock.Unlock()
+	subjectDecisionCounts[reason]++
+	if allowed || subjectDecisionLogSize < 1 {
+		return
+	}
+	subjectDecisionLog = append(subjectDecisionLog, SubjectDecision{
+		Time:            time.Now(),
+		RequiredSubject: requiredSubject,
+		TokenSubject:    tokenSubjects,
+		Allowed:         allowed,
+		Reason:          reason,
+	})
+	if len(subjectDecisionLog) > subjectDecisionLogSize {
+		subjectDecisionLog = subjectDecisionLog[len(subjectDecisionLog)-subjectDecisionLogSize:]
+	}
+}
+
+// GetSubjectDecisionStats returns the verdict counters and the recent rejected decisions, newest first,
+// optionally filtered by the required subject
+func GetSubjectDecisionStats(requiredSubject string) SubjectDecisionStats {
+	subjectDecisionLock.Lock()
+	defer subjectDecisionLock.Unlock()
+	stats := SubjectDecisionStats{
+		Counters: make(map[string]uint64, len(subjectDecisionCounts)),
+		Recent:   []SubjectDecision{},
+	}
+	for k, v := range subjectDecisionCounts {
+		stats.Counters[k] = v
+	}
+	for i := len(subjectDecisionLog) - 1; i >= 0; i-- {
+		if requiredSubject == "" || subjectDecisionLog[i].RequiredSubject == requiredSubject {
+			stats.Recent = append(stats.Recent, subjectDecisionLog[i])
+		}
+	}
+	return stats
+}
+
+// SubjectDecisionsHandler returns the subject verification statistics and the decision log to superusers
+func SubjectDecisionsHandler(w http.ResponseWriter, r *http.Request) {
+	data, err := json.Marshal(GetSubjectDecisionStats(r.URL.Query().Get("tenant")))
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
+		return
+	}
+	w.Write(data)
+}
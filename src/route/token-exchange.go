@@ -0,0 +1,155 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package route
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/datastax/burnell/src/audit"
+	"github.com/datastax/burnell/src/icrypto"
+	"github.com/datastax/burnell/src/policy"
+	"github.com/datastax/burnell/src/util"
+	"github.com/dgrijalva/jwt-go"
+)
+
+// TokenExchangeRequest is the request body to exchange a tenant admin token for a client token
+type TokenExchangeRequest struct {
+	// Exp is the client token TTL, i.e. 30m, default to TokenExchangeDefaultTTL
+	Exp string `json:"exp"`
+	// Alg is the signing method, default to RS256
+	Alg string `json:"alg"`
+	// Key is the suffix of the client subject, a random key if empty
+	Key string `json:"key"`
+}
+
+var (
+	// the client token TTL if it is not requested
+	tokenExchangeDefaultTTL = util.AssignString(os.Getenv("TokenExchangeDefaultTTL"), "1h")
+	// the longest client token TTL to exchange
+	tokenExchangeMaxTTL = time.Duration(util.GetEnvInt("TokenExchangeMaxTTLMinutes", 1440)) * time.Minute
+
+	clientKeyPattern = regexp.MustCompile(`^[a-zA-Z0-9]{1,64}$`)
+)
+
+// tenantAdminSubject returns the tenant of a tenant admin subject, i.e. ming-luo-admin-12345qbc
+func tenantAdminSubject(subject string) (string, bool) {
+	parts := strings.Split(subject, subDelimiter)
+	if len(parts) < 3 || parts[len(parts)-2] != "admin" {
+		return "", false
+	}
+	return strings.Join(parts[:len(parts)-2], subDelimiter), true
+}
+
+// TokenExchangeHandler issues a client token of the tenant to a tenant admin token holder,
+// the client token can not outlive the admin token. The exchange is recorded in the audit.
+func TokenExchangeHandler(w http.ResponseWriter, r *http.Request) {
+	if !util.IsPulsarJWTEnabled() {
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+	subject := r.Header.Get(injectedSubs)
+	tenant, ok := tenantAdminSubject(subject)
+	if !ok {
+		auditAuthFailure(r, subject, "tenant admin token required")
+		util.ResponseErrorJSON(fmt.Errorf("a tenant admin token is required"), w, http.StatusForbidden)
+		return
+	}
+
+	resp, statusCode, err := exchangeToken(r, tenant)
+	detail := "client subject " + resp.Subject
+	if err != nil {
+		detail = err.Error()
+	}
+	audit.Record(audit.Event{
+		Subject:  subject,
+		Tenant:   tenant,
+		Action:   "exchange-token",
+		Resource: r.URL.Path,
+		Detail:   detail,
+		Status:   statusCode,
+	})
+	if err != nil {
+		util.ResponseErrorJSON(err, w, statusCode)
+		return
+	}
+	policy.RecordTenantEvent(policy.TenantEvent{
+		Tenant:   tenant,
+		Category: policy.TokenEventCategory,
+		Type:     "exchange-token",
+		Summary:  "client token issued to subject " + resp.Subject,
+	})
+	data, err := json.Marshal(resp)
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
+		return
+	}
+	w.Write(data)
+}
+
+func exchangeToken(r *http.Request, tenant string) (TokenServerResponse, int, error) {
+	req := TokenExchangeRequest{}
+	if r.ContentLength != 0 {
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		defer r.Body.Close()
+		if err := decoder.Decode(&req); err != nil {
+			return TokenServerResponse{}, http.StatusBadRequest, err
+		}
+	}
+	exp, alg, err := icrypto.ValidateClaims(util.AssignString(req.Exp, tokenExchangeDefaultTTL), util.AssignString(req.Alg, "rs256"))
+	if err != nil {
+		return TokenServerResponse{}, http.StatusUnprocessableEntity, err
+	}
+	if !util.JWTAuth.IsAllowed(alg) {
+		return TokenServerResponse{}, http.StatusUnprocessableEntity, fmt.Errorf("signing method %s is not allowed", alg.Alg())
+	}
+	if exp <= 0 || exp > tokenExchangeMaxTTL {
+		return TokenServerResponse{}, http.StatusUnprocessableEntity, fmt.Errorf("exp must be between 0 and %v", tokenExchangeMaxTTL)
+	}
+	// the client token expires no later than the admin token
+	tokenStr := strings.TrimSpace(strings.Replace(r.Header.Get("Authorization"), "Bearer", "", 1))
+	if token, err := util.JWTAuth.DecodeToken(tokenStr); err == nil {
+		if adminExp, ok := token.Claims.(jwt.MapClaims)["exp"].(float64); ok {
+			if remaining := time.Until(time.Unix(int64(adminExp), 0)); exp > remaining {
+				return TokenServerResponse{}, http.StatusUnprocessableEntity, fmt.Errorf("exp must not exceed the admin token remaining validity %v", remaining.Truncate(time.Second))
+			}
+		}
+	}
+
+	key := util.AssignString(req.Key, icrypto.RandKey(12))
+	if !clientKeyPattern.MatchString(key) {
+		return TokenServerResponse{}, http.StatusUnprocessableEntity, fmt.Errorf("key must be alphanumeric up to 64 characters")
+	}
+	clientSubject := tenant + subDelimiter + "client" + subDelimiter + key
+	clientToken, err := util.JWTAuth.GenerateToken(clientSubject, exp, alg)
+	if err != nil {
+		return TokenServerResponse{Subject: clientSubject}, http.StatusInternalServerError, fmt.Errorf("failed to generate token")
+	}
+	policy.TrackTokenExpiry(tenant, clientSubject, time.Now().Add(exp))
+	return TokenServerResponse{Subject: clientSubject, Token: clientToken}, http.StatusOK, nil
+}
@@ -0,0 +1,62 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package route
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/datastax/burnell/src/policy"
+	"github.com/datastax/burnell/src/util"
+)
+
+// topic plan defaults are applied to new topics unless TopicPlanDefaultsEnabled is 0
+var topicPlanDefaultsEnabled = util.GetEnvInt("TopicPlanDefaultsEnabled", 1) != 0
+
+// createdTopic returns the topic path, i.e. persistent/tenant/namespace/topic, of a topic creation request
+// PUT /admin/v2/{persistent|non-persistent}/{tenant}/{namespace}/{topic}[/partitions]
+func createdTopic(r *http.Request) (string, bool) {
+	if r.Method != http.MethodPut {
+		return "", false
+	}
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/v2/"), "/"), "/")
+	if len(parts) == 5 && parts[4] == "partitions" {
+		parts = parts[:4]
+	}
+	if len(parts) != 4 || (parts[0] != "persistent" && parts[0] != "non-persistent") {
+		return "", false
+	}
+	return strings.Join(parts, "/"), true
+}
+
+// withTopicPlanDefaults serves the topic creation and applies the tenant plan defaults to the topic once it is created
+func withTopicPlanDefaults(tenant, topicPath string, proxy http.HandlerFunc, w http.ResponseWriter, r *http.Request) {
+	if !topicPlanDefaultsEnabled {
+		proxy(w, r)
+		return
+	}
+	recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	proxy(recorder, r)
+	if recorder.status >= 200 && recorder.status < 300 {
+		policy.ApplyTopicPlanDefaults(tenant, topicPath)
+	}
+}
@@ -0,0 +1,233 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package route
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/datastax/burnell/src/util"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// upstreamEWMAWeight is the weight of the latest latency sample in the rolling average
+const upstreamEWMAWeight = 0.3
+
+var (
+	upstreamLatencyEWMA = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "burnell",
+		Subsystem: "upstream",
+		Name:      "latency_ewma_seconds",
+		Help:      "The rolling average latency of an admin upstream used by the upstream selection.",
+	}, []string{"upstream"})
+	upstreamSelected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "burnell",
+		Subsystem: "upstream",
+		Name:      "selected_total",
+		Help:      "The number of the proxied admin calls routed to an admin upstream.",
+	}, []string{"upstream"})
+	upstreamHealthy = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "burnell",
+		Subsystem: "upstream",
+		Name:      "healthy",
+		Help:      "1 if an admin upstream is eligible for the selection, 0 during its failure cooldown.",
+	}, []string{"upstream"})
+)
+
+func init() {
+	prometheus.MustRegister(upstreamLatencyEWMA, upstreamSelected, upstreamHealthy)
+}
+
+// AdminUpstreams selects the admin upstream of the proxied broker admin calls, nil unless multiple upstreams are configured
+var AdminUpstreams *UpstreamSelector
+
+// UpstreamStat is the selection stats of an admin upstream
+type UpstreamStat struct {
+	URL                 string    `json:"url"`
+	LatencyEWMAMs       float64   `json:"latencyEwmaMs"`
+	Samples             int64     `json:"samples"`
+	Selected            int64     `json:"selected"`
+	ConsecutiveFailures int       `json:"consecutiveFailures"`
+	Healthy             bool      `json:"healthy"`
+	UnhealthyUntil      time.Time `json:"unhealthyUntil,omitempty"`
+}
+
+type upstream struct {
+	url   *url.URL
+	stat  UpstreamStat
+	ewmaS float64
+}
+
+// UpstreamSelector picks the healthy upstream with the lowest rolling latency.
+// An upstream is unhealthy for a cooldown after consecutive failed calls, and sampled afresh once the cooldown ends.
+type UpstreamSelector struct {
+	lock             sync.Mutex
+	upstreams        []*upstream
+	failureThreshold int
+	cooldown         time.Duration
+}
+
+// NewUpstreamSelector creates a selector of the upstream URLs
+func NewUpstreamSelector(urls []*url.URL, failureThreshold int, cooldown time.Duration) *UpstreamSelector {
+	s := &UpstreamSelector{
+		failureThreshold: failureThreshold,
+		cooldown:         cooldown,
+	}
+	for _, u := range urls {
+		s.upstreams = append(s.upstreams, &upstream{url: u, stat: UpstreamStat{URL: u.String(), Healthy: true}})
+		upstreamHealthy.WithLabelValues(u.Host).Set(1)
+	}
+	return s
+}
+
+// InitAdminUpstreams sets up the upstream selection if AdminUpstreamURLs has more than one URL
+func InitAdminUpstreams() error {
+	urls := []*url.URL{}
+	for _, raw := range strings.Split(util.GetConfig().AdminUpstreamURLs, ",") {
+		if raw = strings.TrimSpace(raw); raw == "" {
+			continue
+		}
+		u, err := url.ParseRequestURI(raw)
+		if err != nil {
+			return fmt.Errorf("AdminUpstreamURLs %s: %v", raw, err)
+		}
+		urls = append(urls, u)
+	}
+	if len(urls) < 2 {
+		AdminUpstreams = nil
+		return nil
+	}
+	AdminUpstreams = NewUpstreamSelector(urls,
+		util.GetEnvInt("UpstreamFailureThreshold", 3),
+		time.Duration(util.GetEnvInt("UpstreamCooldownSeconds", 30))*time.Second)
+	return nil
+}
+
+// Select returns the healthy upstream with the lowest rolling latency, an upstream without samples goes first.
+// If none is healthy, it returns the upstream at the end of the earliest cooldown.
+func (s *UpstreamSelector) Select(now time.Time) *url.URL {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var best, earliest *upstream
+	for _, u := range s.upstreams {
+		if !u.stat.Healthy && !now.Before(u.stat.UnhealthyUntil) {
+			u.stat.Healthy = true
+			u.stat.ConsecutiveFailures = 0
+			u.stat.UnhealthyUntil = time.Time{}
+			u.stat.Samples = 0
+			u.ewmaS = 0
+			upstreamHealthy.WithLabelValues(u.url.Host).Set(1)
+		}
+		if !u.stat.Healthy {
+			if earliest == nil || u.stat.UnhealthyUntil.Before(earliest.stat.UnhealthyUntil) {
+				earliest = u
+			}
+			continue
+		}
+		if best == nil || u.stat.Samples == 0 && best.stat.Samples > 0 ||
+			(u.stat.Samples > 0) == (best.stat.Samples > 0) && u.ewmaS < best.ewmaS {
+			best = u
+		}
+	}
+	if best == nil {
+		best = earliest
+	}
+	if best == nil {
+		return nil
+	}
+	best.stat.Selected++
+	upstreamSelected.WithLabelValues(best.url.Host).Inc()
+	return best.url
+}
+
+// Observe updates the rolling latency and the health of the upstream by the host, other hosts are ignored
+func (s *UpstreamSelector) Observe(host string, d time.Duration, failed bool, now time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, u := range s.upstreams {
+		if u.url.Host != host {
+			continue
+		}
+		if failed {
+			u.stat.ConsecutiveFailures++
+			if u.stat.Healthy && u.stat.ConsecutiveFailures >= s.failureThreshold {
+				u.stat.Healthy = false
+				u.stat.UnhealthyUntil = now.Add(s.cooldown)
+				upstreamHealthy.WithLabelValues(host).Set(0)
+			}
+			return
+		}
+		u.stat.ConsecutiveFailures = 0
+		if u.stat.Samples == 0 {
+			u.ewmaS = d.Seconds()
+		} else {
+			u.ewmaS = upstreamEWMAWeight*d.Seconds() + (1-upstreamEWMAWeight)*u.ewmaS
+		}
+		u.stat.Samples++
+		upstreamLatencyEWMA.WithLabelValues(host).Set(u.ewmaS)
+		return
+	}
+}
+
+// Stats returns the selection stats of the upstreams in the configured order
+func (s *UpstreamSelector) Stats() []UpstreamStat {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	stats := make([]UpstreamStat, len(s.upstreams))
+	for i, u := range s.upstreams {
+		stats[i] = u.stat
+		stats[i].LatencyEWMAMs = u.ewmaS * 1000
+	}
+	return stats
+}
+
+// selectAdminUpstream routes the upstream request for the broker admin REST API to the selected admin upstream
+func selectAdminUpstream(newRequest *http.Request) {
+	if AdminUpstreams == nil || util.BrokerProxyURL == nil || newRequest.URL.Host != util.BrokerProxyURL.Host {
+		return
+	}
+	if u := AdminUpstreams.Select(time.Now()); u != nil {
+		newRequest.URL.Scheme = u.Scheme
+		newRequest.URL.Host = u.Host
+		newRequest.Host = u.Host
+	}
+}
+
+// AdminUpstreamsHandler returns the admin upstream selection stats
+func AdminUpstreamsHandler(w http.ResponseWriter, r *http.Request) {
+	stats := []UpstreamStat{}
+	if AdminUpstreams != nil {
+		stats = AdminUpstreams.Stats()
+	}
+	data, err := json.Marshal(stats)
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
@@ -0,0 +1,152 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package route
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/datastax/burnell/src/audit"
+	"github.com/datastax/burnell/src/policy"
+	"github.com/datastax/burnell/src/util"
+	"github.com/gorilla/mux"
+)
+
+const maxWatchTimeout = 30 * time.Minute
+
+// TenantPlanWatchHandler streams tenant plan change events as newline delimited json.
+// With the query parameter `longpoll=true`, it replies with the first event or 204 at the timeout.
+// The comma separated `changes` query parameter only streams the events changing any of the fields.
+func TenantPlanWatchHandler(w http.ResponseWriter, r *http.Request) {
+	tenant := mux.Vars(r)["tenant"] // empty tenant is the firehose for all tenants
+	params := r.URL.Query()
+	longPoll := queryParamString(params, "longpoll", "false") == "true"
+	timeout := watchTimeout(params, longPoll)
+	fields := []string{}
+	for _, f := range strings.Split(queryParamString(params, "changes", ""), ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			fields = append(fields, f)
+		}
+	}
+
+	events, unsubscribe := policy.WatchTenantPlanFields(tenant, fields)
+	defer unsubscribe()
+	// the watch lasts up to its own timeout, over the server read and write timeouts
+	util.LiftServerDeadlines(w)
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	if longPoll {
+		select {
+		case event := <-events:
+			data, err := json.Marshal(event)
+			if err != nil {
+				util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
+				return
+			}
+			w.Write(data)
+		case <-timer.C:
+			w.WriteHeader(http.StatusNoContent)
+		case <-r.Context().Done():
+		}
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		util.ResponseErrorJSON(errors.New("streaming is not supported"), w, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	encoder := json.NewEncoder(w)
+	for {
+		select {
+		case event := <-events:
+			if err := encoder.Encode(event); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-timer.C:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// watchTimeout parses the query parameter timeout, default is 30 seconds for long poll and the max for streaming
+func watchTimeout(params url.Values, longPoll bool) time.Duration {
+	defaultTimeout := maxWatchTimeout
+	if longPoll {
+		defaultTimeout = 30 * time.Second
+	}
+	timeout, err := time.ParseDuration(queryParamString(params, "timeout", ""))
+	if err != nil || timeout <= 0 {
+		return defaultTimeout
+	}
+	if timeout > maxWatchTimeout {
+		return maxWatchTimeout
+	}
+	return timeout
+}
+
+// PlanChangeRulesHandler returns the tenant plan change rules, or replaces them at runtime
+func PlanChangeRulesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPut {
+		var rules []util.PlanChangeRule
+		if err := json.NewDecoder(r.Body).Decode(&rules); err != nil {
+			util.ResponseErrorJSON(err, w, http.StatusBadRequest)
+			return
+		}
+		if err := policy.SetPlanChangeRules(rules); err != nil {
+			util.ResponseErrorJSON(err, w, http.StatusUnprocessableEntity)
+			return
+		}
+		names := make([]string, 0, len(rules))
+		for _, rule := range rules {
+			names = append(names, rule.Name)
+		}
+		audit.Record(audit.Event{
+			Subject:  r.Header.Get(injectedSubs),
+			Action:   "set-plan-change-rules",
+			Resource: r.URL.Path,
+			Detail:   "rules " + strings.Join(names, ","),
+			Status:   http.StatusOK,
+		})
+	}
+
+	data, err := json.Marshal(policy.PlanChangeRules())
+	if err != nil {
+		util.ResponseErrorJSON(err, w, http.StatusInternalServerError)
+		return
+	}
+	w.Write(data)
+}
@@ -22,6 +22,7 @@
 package route
 
 import (
+	"errors"
 	"net/http"
 	"net/url"
 
@@ -36,13 +37,13 @@ func WebsocketAuthProxyHandler(w http.ResponseWriter, r *http.Request) {
 	proxyURLStr := util.AssignString(util.GetConfig().WebsocketURL, "ws://localhost:8000")
 	if proxyURLStr == "" {
 		log.Errorf("websocket proxy not configured")
-		http.Error(w, "not configured", http.StatusNotImplemented)
+		util.ResponseErrorJSON(errors.New("not configured"), w, http.StatusNotImplemented)
 		return
 	}
 	proxyURL, err := url.Parse(proxyURLStr)
 	if err != nil {
 		log.Errorf("malformed proxy URL %s", proxyURLStr)
-		http.Error(w, "consult with admin for malformed proxyURL", http.StatusInternalServerError)
+		util.ResponseErrorJSON(errors.New("consult with admin for malformed proxyURL"), w, http.StatusInternalServerError)
 		return
 	}
 
@@ -74,5 +75,7 @@ func WebsocketAuthProxyHandler(w http.ResponseWriter, r *http.Request) {
 		Director: director,
 		Upgrader: &upgrader,
 	}
+	// the websocket connection is long lived, over the server read and write timeouts
+	util.LiftServerDeadlines(w)
 	proxy.ServeHTTP(w, r)
 }
@@ -0,0 +1,121 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package route
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/datastax/burnell/src/util"
+	"github.com/ghodss/yaml"
+)
+
+// YAMLContentType is the media type of the YAML request and response bodies
+const YAMLContentType = "application/yaml"
+
+// yamlMediaTypes are the accepted YAML media types, the registered one and the legacy ones used by the tools
+var yamlMediaTypes = map[string]bool{
+	YAMLContentType:      true,
+	"application/x-yaml": true,
+	"text/yaml":          true,
+	"text/x-yaml":        true,
+}
+
+func isYAMLMediaType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && yamlMediaTypes[mediaType]
+}
+
+// acceptsYAML returns true if the Accept header prefers a YAML media type to JSON,
+// the first listed one wins on the same quality
+func acceptsYAML(accept string) bool {
+	yamlQ, jsonQ := 0.0, 0.0
+	yamlFirst := false
+	for _, v := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(v))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if qValue, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(qValue, 64); err != nil {
+				continue
+			}
+		}
+		if yamlMediaTypes[mediaType] && q > yamlQ {
+			yamlQ = q
+			yamlFirst = yamlFirst || jsonQ == 0
+		} else if (mediaType == "application/json" || mediaType == "*/*") && q > jsonQ {
+			jsonQ = q
+		}
+	}
+	return yamlQ > jsonQ || (yamlQ > 0 && yamlQ == jsonQ && yamlFirst)
+}
+
+// NegotiateYAML is the middleware to accept a YAML request body and reply a YAML response with Accept: application/yaml.
+// The YAML body is converted to JSON before the next handler, including the body validation, and a JSON response
+// including the errors is converted to YAML. The other requests and responses are passed as they are.
+func NegotiateYAML(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isYAMLMediaType(r.Header.Get("Content-Type")) && r.Body != nil {
+			body, err := ioutil.ReadAll(r.Body)
+			r.Body.Close()
+			if err != nil {
+				util.ResponseErrorJSON(errors.New("can't read body"), w, http.StatusBadRequest)
+				return
+			}
+			data, err := yaml.YAMLToJSON(body)
+			if err != nil {
+				util.ResponseErrorJSON(errors.New("invalid YAML body: "+err.Error()), w, http.StatusBadRequest)
+				return
+			}
+			r.Body = ioutil.NopCloser(bytes.NewReader(data))
+			r.ContentLength = int64(len(data))
+			r.Header.Set("Content-Type", "application/json")
+		}
+
+		if !acceptsYAML(r.Header.Get("Accept")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		recorder := &fieldsRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		body := recorder.body.Bytes()
+		if mediaType, _, _ := mime.ParseMediaType(w.Header().Get("Content-Type")); mediaType == "application/json" && len(body) > 0 {
+			if data, err := yaml.JSONToYAML(body); err == nil {
+				body = data
+				w.Header().Set("Content-Type", YAMLContentType)
+			}
+		}
+		if w.Header().Get("Content-Length") != "" {
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		}
+		w.WriteHeader(recorder.status)
+		w.Write(body)
+	})
+}
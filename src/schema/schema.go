@@ -0,0 +1,243 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+// Package schema validates JSON documents against a subset of JSON Schema draft 7,
+// type, enum, properties, required, additionalProperties, items, minimum, maximum,
+// minLength, maxLength, pattern, minItems and maxItems.
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Schema is a JSON Schema
+type Schema struct {
+	Type                 interface{}        `json:"type,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// Error is a violation of the schema at the path of the document, i.e. $.policy.numOfTopics
+type Error struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+// Errors is a list of schema violations
+type Errors []Error
+
+func (e Errors) Error() string {
+	paths := make([]string, len(e))
+	for i, v := range e {
+		paths[i] = v.Path + " " + v.Reason
+	}
+	return "schema validation failed: " + strings.Join(paths, "; ")
+}
+
+// Parse parses and compiles a schema
+func Parse(data string) (*Schema, error) {
+	s := &Schema{}
+	if err := json.Unmarshal([]byte(data), s); err != nil {
+		return nil, err
+	}
+	if err := s.compile(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Schema) compile() error {
+	if s.Pattern != "" {
+		p, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return err
+		}
+		s.pattern = p
+	}
+	for _, v := range s.Properties {
+		if err := v.compile(); err != nil {
+			return err
+		}
+	}
+	if s.Items != nil {
+		return s.Items.compile()
+	}
+	return nil
+}
+
+// Validate validates the JSON document, it returns Errors for schema violations
+func (s *Schema) Validate(document []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(document))
+	decoder.UseNumber()
+	var doc interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		return Errors{{Path: "$", Reason: "invalid JSON " + err.Error()}}
+	}
+	errs := s.validate("$", doc, Errors{})
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func typeOf(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		if _, err := t.Int64(); err == nil {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return "unknown"
+}
+
+func (s *Schema) types() []string {
+	switch t := s.Type.(type) {
+	case string:
+		return []string{t}
+	case []interface{}:
+		types := []string{}
+		for _, v := range t {
+			if str, ok := v.(string); ok {
+				types = append(types, str)
+			}
+		}
+		return types
+	}
+	return nil
+}
+
+func matchType(types []string, actual string) bool {
+	for _, t := range types {
+		if t == actual || (t == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Schema) validate(path string, v interface{}, errs Errors) Errors {
+	actual := typeOf(v)
+	if types := s.types(); len(types) > 0 && !matchType(types, actual) {
+		return append(errs, Error{Path: path, Reason: fmt.Sprintf("must be %s but is %s", strings.Join(types, " or "), actual)})
+	}
+	if len(s.Enum) > 0 && !s.inEnum(v) {
+		errs = append(errs, Error{Path: path, Reason: fmt.Sprintf("must be one of %v", s.Enum)})
+	}
+
+	switch t := v.(type) {
+	case json.Number:
+		n, _ := t.Float64()
+		if s.Minimum != nil && n < *s.Minimum {
+			errs = append(errs, Error{Path: path, Reason: fmt.Sprintf("must be greater than or equal to %v", *s.Minimum)})
+		}
+		if s.Maximum != nil && n > *s.Maximum {
+			errs = append(errs, Error{Path: path, Reason: fmt.Sprintf("must be less than or equal to %v", *s.Maximum)})
+		}
+	case string:
+		length := utf8.RuneCountInString(t)
+		if s.MinLength != nil && length < *s.MinLength {
+			errs = append(errs, Error{Path: path, Reason: fmt.Sprintf("must be at least %d characters", *s.MinLength)})
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			errs = append(errs, Error{Path: path, Reason: fmt.Sprintf("must be at most %d characters", *s.MaxLength)})
+		}
+		if s.pattern != nil && !s.pattern.MatchString(t) {
+			errs = append(errs, Error{Path: path, Reason: fmt.Sprintf("must match pattern %s", s.Pattern)})
+		}
+	case []interface{}:
+		if s.MinItems != nil && len(t) < *s.MinItems {
+			errs = append(errs, Error{Path: path, Reason: fmt.Sprintf("must have at least %d items", *s.MinItems)})
+		}
+		if s.MaxItems != nil && len(t) > *s.MaxItems {
+			errs = append(errs, Error{Path: path, Reason: fmt.Sprintf("must have at most %d items", *s.MaxItems)})
+		}
+		if s.Items != nil {
+			for i, item := range t {
+				errs = s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item, errs)
+			}
+		}
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := t[name]; !ok {
+				errs = append(errs, Error{Path: path + "." + name, Reason: "is required"})
+			}
+		}
+		names := make([]string, 0, len(t))
+		for name := range t {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if prop, ok := s.Properties[name]; ok {
+				errs = prop.validate(path+"."+name, t[name], errs)
+			} else if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+				errs = append(errs, Error{Path: path + "." + name, Reason: "is not allowed"})
+			}
+		}
+	}
+	return errs
+}
+
+func (s *Schema) inEnum(v interface{}) bool {
+	for _, e := range s.Enum {
+		if n, ok := v.(json.Number); ok {
+			if f, ok := e.(float64); ok {
+				if nf, err := n.Float64(); err == nil && nf == f {
+					return true
+				}
+			}
+			continue
+		}
+		if t := typeOf(v); t != "array" && t != "object" && e == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,258 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package schema
+
+import "fmt"
+
+// the names of the embedded schemas of burnell native request bodies
+const (
+	TenantPlan          = "tenant-plan"
+	TenantNotification  = "tenant-notification"
+	AllowedOrigins      = "allowed-origins"
+	RateLimits          = "rate-limits"
+	Partitions          = "partitions"
+	TenantMetadata      = "tenant-metadata"
+	TenantPlanBatch     = "tenant-plan-batch"
+	SignedURL           = "signed-url"
+	TenantProtection    = "tenant-protection"
+	TenantSSO           = "tenant-sso"
+	SSOResolve          = "sso-resolve"
+	RateLimitExemptions = "rate-limit-exemptions"
+	PlanChangeRules     = "plan-change-rules"
+	QuotaEnforcement    = "quota-enforcement"
+	Priority            = "priority"
+)
+
+// the email domains and IdP groups of the tenant SSO mapping
+const tenantSSO = `{
+	"type": "object",
+	"additionalProperties": false,
+	"properties": {
+		"emailDomains": {"type": ["array", "null"], "maxItems": 100, "items": {"type": "string", "minLength": 1, "maxLength": 253}},
+		"idpGroups": {"type": ["array", "null"], "maxItems": 100, "items": {"type": "string", "minLength": 1, "maxLength": 256}}
+	}
+}`
+
+// the plan limits accept -1 as unlimited and 0 as unspecified, the bounds are enforced by the tenant plan validation
+const planLimit = `{"type": "integer", "minimum": -1}`
+
+var schemas = map[string]string{
+	TenantPlan: `{
+		"type": "object",
+		"properties": {
+			"name": {"type": "string", "maxLength": 256},
+			"tenantStatus": {"type": "integer", "minimum": 0},
+			"org": {"type": "string"},
+			"users": {"type": "string"},
+			"planType": {"type": "string", "pattern": "(?i)^(free|starter|production|dedicated|private)?$"},
+			"audit": {"type": "string"},
+			"policy": {
+				"type": "object",
+				"properties": {
+					"name": {"type": "string"},
+					"numOfTopics": ` + planLimit + `,
+					"numOfNamespaces": ` + planLimit + `,
+					"messageHourRetention": ` + planLimit + `,
+					"numofProducers": ` + planLimit + `,
+					"numOfConsumers": ` + planLimit + `,
+					"functions": ` + planLimit + `,
+					"numOfPartitions": ` + planLimit + `,
+					"requestRate": ` + planLimit + `,
+					"requestBurst": ` + planLimit + `,
+					"backlogQuotaMB": ` + planLimit + `,
+					"featureCodes": {"type": "string"}
+				}
+			},
+			"contacts": {
+				"type": "object",
+				"properties": {
+					"emails": {"type": ["array", "null"], "items": {"type": "string", "minLength": 3}},
+					"webhooks": {"type": ["array", "null"], "items": {"type": "string", "pattern": "^https?://"}}
+				}
+			},
+			"notifications": {
+				"type": "object",
+				"properties": {
+					"quotaWarning": {"type": "boolean"},
+					"expiration": {"type": "boolean"},
+					"maintenance": {"type": "boolean"},
+					"usageReport": {"type": "boolean"}
+				}
+			},
+			"allowedOrigins": {"type": ["array", "null"], "items": {"type": "string", "pattern": "^https?://"}},
+			"allowedClusters": {"type": ["array", "null"], "maxItems": 100, "items": {"type": "string", "pattern": "^[a-zA-Z0-9_.-]+$"}},
+			"quotaEnforcement": {"type": "string", "enum": ["", "enforce", "observe"]},
+			"metadata": {"type": ["object", "null"]},
+			"protected": {"type": "boolean"},
+			"expiresAt": {"type": ["string", "null"], "format": "date-time"},
+			"sso": ` + tenantSSO + `
+		}
+	}`,
+	TenantMetadata: `{"type": "object"}`,
+	TenantPlanBatch: `{
+		"type": "object",
+		"required": ["plan"],
+		"properties": {
+			"tenants": {"type": "array", "maxItems": 10000, "items": {"type": "string", "minLength": 1}},
+			"filter": {
+				"type": "object",
+				"properties": {
+					"planType": {"type": "string"},
+					"metadata": {"type": "object"}
+				}
+			},
+			"plan": {"type": "object"},
+			"addFeatureCodes": {"type": "array", "items": {"type": "string"}},
+			"removeFeatureCodes": {"type": "array", "items": {"type": "string"}}
+		}
+	}`,
+	TenantNotification: `{
+		"type": "object",
+		"required": ["subject"],
+		"properties": {
+			"kind": {"type": "string"},
+			"subject": {"type": "string", "minLength": 1, "maxLength": 256},
+			"message": {"type": "string"}
+		}
+	}`,
+	AllowedOrigins: `{
+		"type": "array",
+		"maxItems": 100,
+		"items": {"type": "string", "pattern": "^https?://"}
+	}`,
+	RateLimits: `{
+		"type": "object",
+		"additionalProperties": false,
+		"required": ["limit"],
+		"properties": {
+			"pool": {"type": "string"},
+			"limit": {"type": "integer", "minimum": 1},
+			"perTenantLimit": {"type": "integer", "minimum": 0}
+		}
+	}`,
+	QuotaEnforcement: `{
+		"type": "object",
+		"additionalProperties": false,
+		"required": ["mode"],
+		"properties": {
+			"mode": {"type": "string", "enum": ["enforce", "observe"]}
+		}
+	}`,
+	Priority: `{
+		"type": "object",
+		"additionalProperties": false,
+		"required": ["concurrency"],
+		"properties": {
+			"concurrency": {"type": "integer", "minimum": 0},
+			"classes": {
+				"type": "array",
+				"maxItems": 3,
+				"items": {
+					"type": "object",
+					"additionalProperties": false,
+					"required": ["class", "timeoutMs"],
+					"properties": {
+						"class": {"type": "string", "enum": ["system", "control", "heavy"]},
+						"queueDepth": {"type": "integer", "minimum": 0},
+						"timeoutMs": {"type": "integer", "minimum": 1}
+					}
+				}
+			}
+		}
+	}`,
+	RateLimitExemptions: `{
+		"type": "array",
+		"maxItems": 100,
+		"items": {
+			"type": "object",
+			"additionalProperties": false,
+			"required": ["name"],
+			"properties": {
+				"name": {"type": "string", "minLength": 1},
+				"subject": {"type": "string"},
+				"cidr": {"type": "string"},
+				"limit": {"type": "integer", "minimum": 0}
+			}
+		}
+	}`,
+	PlanChangeRules: `{
+		"type": "array",
+		"maxItems": 100,
+		"items": {
+			"type": "object",
+			"additionalProperties": false,
+			"required": ["name", "webhooks"],
+			"properties": {
+				"name": {"type": "string", "minLength": 1},
+				"tenant": {"type": "string"},
+				"fields": {"type": "array", "items": {"type": "string", "minLength": 1}},
+				"types": {"type": "array", "items": {"type": "string", "enum": ["updated", "deleted"]}},
+				"webhooks": {"type": "array", "minItems": 1, "items": {"type": "string", "pattern": "^https?://"}}
+			}
+		}
+	}`,
+	Partitions: `{"type": "integer", "minimum": 1}`,
+	SignedURL: `{
+		"type": "object",
+		"additionalProperties": false,
+		"required": ["path"],
+		"properties": {
+			"path": {"type": "string", "pattern": "^/"},
+			"ttlSeconds": {"type": "integer", "minimum": 1}
+		}
+	}`,
+	TenantSSO: tenantSSO,
+	SSOResolve: `{
+		"type": "object",
+		"additionalProperties": false,
+		"properties": {
+			"email": {"type": "string", "maxLength": 320},
+			"groups": {"type": ["array", "null"], "maxItems": 1000, "items": {"type": "string"}}
+		}
+	}`,
+	TenantProtection: `{
+		"type": "object",
+		"additionalProperties": false,
+		"required": ["protected"],
+		"properties": {
+			"protected": {"type": "boolean"}
+		}
+	}`,
+}
+
+var compiled = make(map[string]*Schema, len(schemas))
+
+func init() {
+	for name, data := range schemas {
+		s, err := Parse(data)
+		if err != nil {
+			panic(fmt.Sprintf("invalid embedded schema %s: %v", name, err))
+		}
+		compiled[name] = s
+	}
+}
+
+// Get returns the embedded schema by the name
+func Get(name string) (*Schema, bool) {
+	s, ok := compiled[name]
+	return s, ok
+}
@@ -0,0 +1,199 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package tests
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/datastax/burnell/src/audit"
+	"github.com/datastax/burnell/src/util"
+)
+
+func TestAuditEvents(t *testing.T) {
+	Record(Event{Tenant: "audit-tenant1", Action: "set-replication-clusters", Resource: "/admin/v2/namespaces/audit-tenant1/ns/replication", Status: 204})
+	Record(Event{Tenant: "audit-tenant2", Action: "set-replication-clusters", Status: 204})
+	Record(Event{Tenant: "audit-tenant1", Action: "set-offload-threshold", Status: 403})
+
+	events := Events("audit-tenant1", 0)
+	equals(t, 2, len(events))
+	equals(t, "set-offload-threshold", events[0].Action)
+	equals(t, 403, events[0].Status)
+	assert(t, !events[1].Time.IsZero(), "event time is set")
+
+	equals(t, 1, len(Events("audit-tenant1", 1)))
+	equals(t, 0, len(Events("audit-tenant3", 0)))
+	assert(t, len(Events("", 0)) >= 3, "all tenants events")
+}
+
+type flakySink struct {
+	failures int
+	batches  [][]Event
+	lock     sync.Mutex
+}
+
+func (s *flakySink) Send(events []Event) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("collector unavailable")
+	}
+	s.batches = append(s.batches, events)
+	return nil
+}
+
+func (s *flakySink) sent() [][]Event {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.batches
+}
+
+func TestAuditExporterBatchRetry(t *testing.T) {
+	sink := &flakySink{failures: 2}
+	exporter := NewExporter(sink, 2, 50*time.Millisecond, 3, time.Millisecond)
+	exporter.Start()
+	assert(t, exporter.Enqueue(Event{Tenant: "t1", Action: "auth-failure"}), "enqueue")
+	assert(t, exporter.Enqueue(Event{Tenant: "t1", Action: "mint-token"}), "enqueue")
+	assert(t, exporter.Enqueue(Event{Tenant: "t2", Action: "update-tenant-plan"}), "enqueue")
+
+	for i := 0; i < 100 && len(sink.sent()) < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	batches := sink.sent()
+	equals(t, 2, len(batches))
+	equals(t, 2, len(batches[0]))
+	equals(t, "mint-token", batches[0][1].Action)
+	// the partial batch is flushed at the interval
+	equals(t, 1, len(batches[1]))
+}
+
+func TestAuditHTTPSinkFormats(t *testing.T) {
+	var body, authz string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		body = string(data)
+		authz = r.Header.Get("Authorization")
+	}))
+	defer server.Close()
+
+	events := []Event{{Tenant: "t1", Action: "auth-failure"}, {Tenant: "t2", Action: "mint-token"}}
+	sink, err := NewHTTPSink(server.URL, "hec-token", "splunk")
+	errNil(t, err)
+	errNil(t, sink.Send(events))
+	equals(t, "Splunk hec-token", authz)
+	equals(t, 2, strings.Count(body, `"sourcetype":"burnell:audit"`))
+
+	sink, err = NewHTTPSink(server.URL, "", "elastic")
+	errNil(t, err)
+	errNil(t, sink.Send(events))
+	equals(t, "", authz)
+	equals(t, 4, len(strings.Split(strings.TrimSpace(body), "\n")))
+
+	_, err = NewHTTPSink(server.URL, "", "xml")
+	assert(t, err != nil, "unsupported format")
+
+	_, err = NewSink("ftp://collector", "", "")
+	assert(t, err != nil, "unsupported scheme")
+}
+
+func TestAuditArchiver(t *testing.T) {
+	objects := map[string][]byte{}
+	var lock sync.Mutex
+	failPut := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		switch r.Method {
+		case http.MethodPut:
+			if failPut {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			data, _ := ioutil.ReadAll(r.Body)
+			objects[r.URL.Path] = data
+		case http.MethodHead:
+			data, ok := objects[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		}
+	}))
+	defer server.Close()
+
+	store, prefix, err := util.NewObjectStore("s3://compliance/burnell/", server.URL, "")
+	errNil(t, err)
+	equals(t, "compliance", store.Bucket)
+	equals(t, "burnell/", prefix)
+	archiver := NewArchiver(store, "s3://compliance/burnell/", prefix, 100, 3)
+
+	archiver.Add(Event{Time: time.Date(2026, 3, 1, 23, 0, 0, 0, time.UTC), Tenant: "t1", Action: "delete-topic"})
+	archiver.Add(Event{Time: time.Date(2026, 3, 2, 1, 0, 0, 0, time.UTC), Tenant: "t1", Action: "mint-token"})
+	archived, err := archiver.Flush()
+	errNil(t, err)
+	equals(t, 2, len(archived))
+	assert(t, strings.HasPrefix(archived[0].Key, "burnell/audit/year=2026/month=03/day=01/"), archived[0].Key)
+	assert(t, strings.HasSuffix(archived[1].Key, ".ndjson.gz"), archived[1].Key)
+	zr, err := gzip.NewReader(bytes.NewReader(objects["/compliance/"+archived[0].Key]))
+	errNil(t, err)
+	data, _ := ioutil.ReadAll(zr)
+	assert(t, strings.Contains(string(data), `"action":"delete-topic"`), string(data))
+
+	verified := archiver.Verify(0)
+	equals(t, 2, len(verified))
+	assert(t, verified[0].Verified && verified[1].Verified, "archived objects exist")
+	delete(objects, "/compliance/"+archived[0].Key)
+	assert(t, !archiver.Verify(0)[1].Verified, "missing object is not verified")
+
+	// a failed upload keeps the events up to the cap
+	failPut = true
+	for i := 0; i < 4; i++ {
+		archiver.Add(Event{Time: time.Date(2026, 3, 2, 2, 0, 0, 0, time.UTC), Tenant: "t2", Action: "auth-failure"})
+	}
+	_, err = archiver.Flush()
+	assert(t, err != nil, "upload failure")
+	status := archiver.Status()
+	equals(t, 3, status.Pending)
+	equals(t, uint64(1), status.Dropped)
+	assert(t, status.LastError != "", "last error")
+	failPut = false
+	archived, err = archiver.Flush()
+	errNil(t, err)
+	equals(t, 3, archived[0].Events)
+	equals(t, 0, archiver.Status().Pending)
+
+	store, _, err = util.NewObjectStore("gs://compliance", "", "")
+	errNil(t, err)
+	equals(t, "https://storage.googleapis.com", store.Endpoint)
+	equals(t, "auto", store.Region)
+}
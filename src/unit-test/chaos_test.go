@@ -0,0 +1,66 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package tests
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/datastax/burnell/src/chaos"
+	"github.com/datastax/burnell/src/util"
+)
+
+func TestFaultInjection(t *testing.T) {
+	saved := util.Config.EnableFaultInjection
+	defer func() {
+		util.Config.EnableFaultInjection = saved
+		SetFaults(nil)
+	}()
+
+	assert(t, SetFaults([]Fault{{Target: "broker"}}) != nil, "unknown target")
+	assert(t, SetFaults([]Fault{{Target: Upstream, ErrorRate: 1.5}}) != nil, "error rate over 1")
+	assert(t, SetFaults([]Fault{{Target: Upstream, Status: 200}}) != nil, "not an error status")
+
+	errNil(t, SetFaults([]Fault{
+		{Target: Upstream, PathPrefix: "/admin/v2/namespaces", ErrorRate: 1},
+		{Target: PolicyStore, LatencyMs: 20},
+	}))
+	equals(t, http.StatusServiceUnavailable, Faults()[0].Status)
+
+	util.Config.EnableFaultInjection = ""
+	_, err := Inject(Upstream, "/admin/v2/namespaces/tenant1")
+	errNil(t, err)
+
+	util.Config.EnableFaultInjection = "true"
+	status, err := Inject(Upstream, "/admin/v2/namespaces/tenant1")
+	assertErr(t, "injected upstream fault on /admin/v2/namespaces/tenant1", err)
+	equals(t, http.StatusServiceUnavailable, status)
+	_, err = Inject(Upstream, "/admin/v2/persistent/tenant1")
+	errNil(t, err)
+	equals(t, uint64(1), Faults()[0].Injected)
+
+	start := time.Now()
+	_, err = Inject(PolicyStore, "tenant1")
+	errNil(t, err)
+	assert(t, time.Since(start) >= 20*time.Millisecond, "latency injected")
+}
@@ -22,6 +22,11 @@
 package tests
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
 	"fmt"
 	"io/ioutil"
 	"testing"
@@ -138,3 +143,58 @@ func TestJWTRSASignAndVerifyWithPEMKey(t *testing.T) {
 	equals(t, expireOffset, 3600)
 
 }
+
+func TestJWTKeysAlgorithms(t *testing.T) {
+	rsaKeys, err := NewRSAKeyPair()
+	errNil(t, err)
+	keys := NewJWTKeys(rsaKeys, "RS256,ES256,HS256,none")
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	errNil(t, err)
+	ecBytes, err := x509.MarshalPKCS8PrivateKey(ecKey)
+	errNil(t, err)
+	ecKeyPath := "/tmp/unitest-ec-private.pem"
+	errNil(t, ioutil.WriteFile(ecKeyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: ecBytes}), 0600))
+	errNil(t, keys.LoadECDSAKeys(ecKeyPath, ""))
+
+	secretPath := "/tmp/unitest-hmac-secret.key"
+	errNil(t, ioutil.WriteFile(secretPath, []byte("a-32-byte-long-secret-for-hs256!"), 0600))
+	errNil(t, keys.LoadHMACSecret(secretPath))
+
+	for _, method := range []jwt.SigningMethod{jwt.SigningMethodRS256, jwt.SigningMethodES256, jwt.SigningMethodHS256} {
+		tokenString, err := keys.GenerateToken("myadmin", time.Hour, method)
+		errNil(t, err)
+		subject, err := keys.GetTokenSubject(tokenString)
+		errNil(t, err)
+		equals(t, "myadmin", subject)
+	}
+
+	assert(t, !keys.IsAllowed(jwt.SigningMethodNone), "none must never be allowed")
+	_, err = keys.GenerateToken("myadmin", time.Hour, jwt.SigningMethodRS512)
+	assert(t, err != nil, "RS512 is not in the allowlist")
+
+	// a token of an algorithm outside the allowlist is rejected
+	rsOnly := NewJWTKeys(rsaKeys, DefaultAllowedAlgs)
+	hsToken, err := keys.GenerateToken("myadmin", time.Hour, jwt.SigningMethodHS256)
+	errNil(t, err)
+	_, err = rsOnly.GetTokenSubject(hsToken)
+	assert(t, err != nil, "HS256 token must be rejected by the RSA only allowlist")
+}
+
+func TestJWKS(t *testing.T) {
+	rsaKeys, err := NewRSAKeyPair()
+	errNil(t, err)
+	keys := NewJWTKeys(rsaKeys, DefaultAllowedAlgs)
+
+	jwks := keys.JWKS()
+	equals(t, 1, len(jwks.Keys))
+	equals(t, "RSA", jwks.Keys[0].Kty)
+	equals(t, "AQAB", jwks.Keys[0].E)
+	assert(t, jwks.Keys[0].Kid != "", "key ID must be set")
+
+	tokenString, err := keys.GenerateToken("myadmin", time.Hour, jwt.SigningMethodRS256)
+	errNil(t, err)
+	token, err := keys.DecodeToken(tokenString)
+	errNil(t, err)
+	equals(t, jwks.Keys[0].Kid, token.Header["kid"])
+}
@@ -22,13 +22,17 @@
 package tests
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"regexp"
 	"strings"
 	"testing"
+	"time"
 
 	. "github.com/datastax/burnell/src/metrics"
+	"github.com/datastax/burnell/src/util"
 )
 
 func TestFederatedPromProcess(t *testing.T) {
@@ -49,6 +53,12 @@ func TestFederatedPromProcess(t *testing.T) {
 	}
 	assert(t, 0 == count, "the number of type definition expected")
 
+	// the transaction metrics are kept with the namespace as the first label too
+	txn := "# TYPE pulsar_txn_tb_committed_total untyped\npulsar_txn_tb_committed_total{namespace=\"victor/ns1\",topic=\"persistent://victor/ns1/t\"} 5\n" +
+		"pulsar_txn_tb_committed_total{namespace=\"ming-luo/ns1\",topic=\"persistent://ming-luo/ns1/t\"} 7\n"
+	equals(t, "# TYPE pulsar_txn_tb_committed_total untyped\npulsar_txn_tb_committed_total{namespace=\"victor/ns1\",topic=\"persistent://victor/ns1/t\"} 5\n",
+		FilterFederatedMetrics([]byte(txn), "victor"))
+
 }
 
 func TestTenantUsage(t *testing.T) {
@@ -72,6 +82,11 @@ func TestTenantUsage(t *testing.T) {
 			equals(t, uint64(0), v.TotalBytesOut)
 			equals(t, uint64(0), v.TotalMessagesOut)
 			equals(t, uint64(6), v.MsgInBacklog)
+			equals(t, uint64(1), v.Producers)
+			equals(t, uint64(3), v.Consumers)
+			equals(t, uint64(7), v.Subscriptions)
+			equals(t, uint64(42), v.TxnCommitted)
+			equals(t, uint64(3), v.TxnAborted)
 		}
 	}
 	assert(t, found, "tenant matched")
@@ -120,6 +135,8 @@ func TestTenantNamespaceUsage(t *testing.T) {
 			equals(t, uint64(0), v.TotalBytesOut)
 			equals(t, uint64(0), v.TotalMessagesOut)
 			equals(t, uint64(0), v.MsgInBacklog)
+			equals(t, uint64(42), v.TxnCommitted)
+			equals(t, uint64(3), v.TxnAborted)
 		}
 	}
 	assert(t, found, "tenant matched")
@@ -143,3 +160,194 @@ func TestTenantNamespaceUsage(t *testing.T) {
 	}
 	assert(t, found, "tenant matched")
 }
+
+func TestGuardCardinality(t *testing.T) {
+	data := []byte("# TYPE pulsar_in_bytes_total untyped\npulsar_in_bytes_total{topic=\"a\"} 1\npulsar_in_bytes_total{topic=\"b\"} 2\n# TYPE pulsar_msg_backlog untyped\npulsar_msg_backlog{topic=\"a\"} 3\n")
+
+	guard := func(tenant string, data []byte) string {
+		guarded, err := GuardCardinality(tenant, data)
+		errNil(t, err)
+		return string(guarded)
+	}
+
+	SetSeriesLimits(0, 0)
+	equals(t, string(data), guard("victor", data))
+
+	SetSeriesLimits(2, 1)
+	capped := guard("victor", data)
+	equals(t, "# TYPE pulsar_in_bytes_total untyped\npulsar_in_bytes_total{topic=\"a\"} 1\npulsar_in_bytes_total{topic=\"b\"} 2\n# TYPE pulsar_msg_backlog untyped\n", capped)
+	capped = guard(SuperRole, data)
+	equals(t, 1, strings.Count(capped, "pulsar_in_bytes_total{"))
+	equals(t, 0, strings.Count(capped, "pulsar_msg_backlog{"))
+
+	SetSeriesLimits(10, 10)
+	equals(t, string(data), guard("victor", data))
+
+	// a line over the max length is an error instead of the metrics cut at the line
+	long := append(append([]byte{}, data...), []byte("pulsar_in_bytes_total{topic=\""+strings.Repeat("x", 1024*1024)+"\"} 1\n")...)
+	_, err := GuardCardinality("victor", long)
+	assert(t, err != nil, "line too long")
+
+	// the limits are in the configuration
+	defer func() {
+		util.Config.MaxFederatedSeriesPerTenant, util.Config.MaxFederatedSeriesTotal = "", ""
+		errNil(t, InitSeriesLimits())
+	}()
+	util.Config.MaxFederatedSeriesPerTenant, util.Config.MaxFederatedSeriesTotal = "1", "0"
+	errNil(t, InitSeriesLimits())
+	equals(t, 1, strings.Count(guard("victor", data), "pulsar_in_bytes_total{"))
+	equals(t, string(data), guard(SuperRole, data))
+	util.Config.MaxFederatedSeriesPerTenant = "-1"
+	assert(t, InitSeriesLimits() != nil, "negative limit")
+	util.Config.MaxFederatedSeriesPerTenant = "many"
+	assert(t, InitSeriesLimits() != nil, "invalid limit")
+}
+
+func TestRelabel(t *testing.T) {
+	data := []byte("# HELP pulsar_in_bytes_total bytes in\n# TYPE pulsar_in_bytes_total untyped\npulsar_in_bytes_total{exported_namespace=\"ming-luo/ns2\",topic=\"a,b\\\"c\"} 1 1590000000\n# TYPE pulsar_latency summary\npulsar_latency_count 3\n")
+
+	unchanged, err := Relabel(data, util.MetricsRelabelRules{}, "useast1")
+	errNil(t, err)
+	equals(t, data, unchanged)
+
+	rules := util.MetricsRelabelRules{
+		ClusterLabel:          "cluster",
+		NormalizeTenantLabels: true,
+		RenameMetrics:         map[string]string{"pulsar_in_bytes_total": "tenant_in_bytes_total", "pulsar_latency": "tenant_latency"},
+	}
+	relabeled, err := Relabel(data, rules, "useast1")
+	errNil(t, err)
+	equals(t, "# HELP tenant_in_bytes_total bytes in\n# TYPE tenant_in_bytes_total untyped\ntenant_in_bytes_total{namespace=\"ming-luo/ns2\",topic=\"a,b\\\"c\",tenant=\"ming-luo\",cluster=\"useast1\"} 1 1590000000\n# TYPE tenant_latency summary\ntenant_latency_count{cluster=\"useast1\"} 3\n", string(relabeled))
+
+	// an overlong line fails the relabel instead of truncating the scrape
+	overlong := []byte("pulsar_latency_count 3\npulsar_in_bytes_total{topic=\"" + strings.Repeat("a", 2*1024*1024) + "\"} 1\n")
+	_, err = Relabel(overlong, rules, "useast1")
+	assert(t, err != nil, "overlong metrics line")
+}
+
+func TestUsageAnomalyDetector(t *testing.T) {
+	d := NewAnomalyDetector(5, 3, 0)
+	for _, v := range []float64{100, 110, 90} {
+		assert(t, d.Observe("ming-luo", BytesInRate, v) == nil, "not enough samples to evaluate")
+	}
+	assert(t, d.Observe("ming-luo", BytesInRate, 105) == nil, "within the pattern")
+	anomaly := d.Observe("ming-luo", BytesInRate, 1000)
+	assert(t, anomaly != nil, "runaway producer")
+	assert(t, anomaly.ZScore > 3, "")
+	equals(t, "ming-luo", anomaly.Tenant)
+
+	// a different tenant has its own window
+	assert(t, d.Observe("another", BytesInRate, 1000) == nil, "")
+
+	// a spike over a flat window is anomalous although the window has no deviation
+	d = NewAnomalyDetector(5, 3, 0)
+	for _, v := range []float64{10, 10, 10} {
+		d.Observe("ming-luo", Backlog, v)
+	}
+	assert(t, d.Observe("ming-luo", Backlog, 10) == nil, "flat")
+	anomaly = d.Observe("ming-luo", Backlog, 500)
+	assert(t, anomaly != nil, "spike over a flat window")
+	assert(t, anomaly.ZScore > 3 && !math.IsInf(anomaly.ZScore, 1), "finite z-score from the deviation floor")
+	_, err := json.Marshal(anomaly)
+	errNil(t, err)
+
+	// an idle tenant has a zero mean
+	d = NewAnomalyDetector(5, 0, 200)
+	for _, v := range []float64{0, 0, 0} {
+		d.Observe("ming-luo", BytesInRate, v)
+	}
+	assert(t, d.Observe("ming-luo", BytesInRate, 2) == nil, "under the jump over the floor")
+	assert(t, d.Observe("ming-luo", BytesInRate, 1000) != nil, "spike over an idle window")
+
+	// the percentage jump evaluates alone without the z-score
+	d = NewAnomalyDetector(5, 0, 50)
+	for _, v := range []float64{10, 10, 10} {
+		d.Observe("ming-luo", Backlog, v)
+	}
+	assert(t, d.Observe("ming-luo", Backlog, 14) == nil, "under 50 percent jump")
+	assert(t, d.Observe("ming-luo", Backlog, 20) != nil, "over 50 percent jump")
+}
+
+func TestAggregateBrokerMetrics(t *testing.T) {
+	dat, err := ioutil.ReadFile("./federated-prom.dat")
+	errNil(t, err)
+
+	brokers, err := AggregateBrokerMetrics(dat)
+	errNil(t, err)
+	equals(t, 1, len(brokers))
+	broker, ok := brokers["useast2-aws-broker-7d847d8df9-v8p4c"]
+	assert(t, ok, "aggregated by the broker pod")
+	equals(t, 9, broker.Topics)
+	assert(t, broker.StorageSize > 0, "storage size")
+
+	_, err = AggregateBrokerMetrics([]byte(`pulsar_rate_in{topic="a" 1`))
+	assert(t, err != nil, "invalid metrics")
+
+	backlogs, err := AggregateTopicBacklogs(dat)
+	errNil(t, err)
+	assert(t, len(backlogs) > 0, "topic backlogs")
+	equals(t, "ming-luo/local-useast2-aws", backlogs[0].Namespace)
+	equals(t, float64(1e12), backlogs[0].QuotaBytes)
+	equals(t, float64(50), TopicBacklog{BacklogBytes: 5, QuotaBytes: 10}.UsagePercent())
+}
+
+func TestUsageReport(t *testing.T) {
+	from := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(7 * 24 * time.Hour)
+	samples := []Usage{
+		{Name: "ming-luo", TotalMessagesIn: 100, MsgInBacklog: 10, UpdatedAt: from},
+		{Name: "ming-luo", TotalMessagesIn: 150, MsgInBacklog: 40, UpdatedAt: from.Add(time.Hour)},
+		// the counter is reset by a broker restart
+		{Name: "ming-luo", TotalMessagesIn: 20, MsgInBacklog: 5, UpdatedAt: from.Add(2 * time.Hour)},
+	}
+	report := BuildUsageReport("ming-luo", samples, []UsageAnomaly{{Tenant: "ming-luo"}}, from, to, to)
+	equals(t, 3, report.Samples)
+	equals(t, 1, report.Anomalies)
+	equals(t, len(UsageMetrics), len(report.Metrics))
+	for _, m := range report.Metrics {
+		switch m.Metric {
+		case TotalMessagesIn:
+			equals(t, CounterMetric, m.Kind)
+			equals(t, float64(70), m.Change)
+			equals(t, float64(150), m.Max)
+		case MsgInBacklog:
+			equals(t, GaugeMetric, m.Kind)
+			equals(t, float64(-5), m.Change)
+			equals(t, float64(40), m.Max)
+		}
+	}
+
+	csv, err := report.CSV()
+	errNil(t, err)
+	lines := strings.Split(strings.TrimSpace(string(csv)), "\n")
+	equals(t, len(UsageMetrics)+1, len(lines))
+	equals(t, "tenant,from,to,metric,kind,start,end,change,max", lines[0])
+	assert(t, strings.HasPrefix(lines[1], "ming-luo,2021-03-01T00:00:00Z,2021-03-08T00:00:00Z,"), "")
+
+	empty := BuildUsageReport("ming-luo", nil, nil, from, to, to)
+	equals(t, 0, empty.Samples)
+	equals(t, float64(0), empty.Metrics[0].Change)
+}
+
+func TestTenantAPIUsage(t *testing.T) {
+	day := time.Date(2021, 3, 1, 23, 0, 0, 0, time.UTC)
+	RecordAPICall("api-meter", "GET tenant sla", day)
+	RecordAPICall("api-meter", "GET tenant sla", day)
+	RecordAPICall("api-meter", "POST tenant plan", day.Add(2*time.Hour))
+	equals(t, uint64(2), TenantAPICalls("api-meter", day))
+	equals(t, uint64(0), TenantAPICalls("another", day))
+
+	usage, err := GetTenantAPIUsage("api-meter", "2021-03-01", "2021-03-31")
+	errNil(t, err)
+	equals(t, uint64(3), usage.Calls)
+	equals(t, uint64(2), usage.Endpoints["GET tenant sla"])
+	equals(t, 2, len(usage.Days))
+	equals(t, "2021-03-02", usage.Days[1].Date)
+
+	usage, err = GetTenantAPIUsage("api-meter", "2021-03-02", "2021-03-02")
+	errNil(t, err)
+	equals(t, uint64(1), usage.Calls)
+
+	_, err = GetTenantAPIUsage("api-meter", "2021-03", "2021-03-31")
+	assert(t, err != nil, "invalid date")
+}
@@ -0,0 +1,102 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package tests
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	. "github.com/datastax/burnell/src/graphql"
+)
+
+func TestGraphQLParse(t *testing.T) {
+	fields, err := Parse(`query TenantQuery($name: String!) {
+		t: tenant(name: $name, limit: 5) { plan { planType policy { numOfTopics } } namespaces }
+	}`, map[string]interface{}{"name": "ming-luo"})
+	errNil(t, err)
+	equals(t, 1, len(fields))
+	equals(t, "t", fields[0].ResponseKey())
+	equals(t, "tenant", fields[0].Name)
+	equals(t, "ming-luo", fields[0].Arguments["name"])
+	equals(t, 5, fields[0].Arguments["limit"])
+	equals(t, 2, len(fields[0].Selections))
+	equals(t, "numOfTopics", fields[0].Selections[0].Selections[1].Selections[0].Name)
+
+	_, err = Parse(`{ tenant(name: $missing) { name } }`, nil)
+	assert(t, err != nil, "missing variable")
+	_, err = Parse(`mutation { tenant { name } }`, nil)
+	assert(t, err != nil, "mutation is not supported")
+	_, err = Parse(`{ tenant { name }`, nil)
+	assert(t, err != nil, "unterminated selection set")
+
+	nested := func(depth int) string {
+		return strings.Repeat("{ tenant ", depth-1) + "{ name }" + strings.Repeat(" }", depth-1)
+	}
+	_, err = Parse(nested(16), nil)
+	errNil(t, err)
+	_, err = Parse(nested(17), nil)
+	assert(t, err != nil, "nested deeper than the max depth")
+	_, err = Parse(nested(100000), nil)
+	assert(t, err != nil, "a deeply nested query does not exhaust the stack")
+}
+
+func TestGraphQLExecute(t *testing.T) {
+	invoked := map[string]bool{}
+	schema := Schema{
+		"tenant": func(args map[string]interface{}) (interface{}, error) {
+			name, err := StringArg(args, "name")
+			if err != nil {
+				return nil, err
+			}
+			return Object{
+				"name": func(map[string]interface{}) (interface{}, error) {
+					invoked["name"] = true
+					return name, nil
+				},
+				"plan": func(map[string]interface{}) (interface{}, error) {
+					invoked["plan"] = true
+					return map[string]interface{}{"planType": "free", "org": "datastax"}, nil
+				},
+				"usage": func(map[string]interface{}) (interface{}, error) {
+					invoked["usage"] = true
+					return nil, errors.New("usage is not enabled")
+				},
+			}, nil
+		},
+	}
+
+	resp := Execute(schema, Request{Query: `{ tenant(name: "ming-luo") { name plan { planType } } }`})
+	equals(t, 0, len(resp.Errors))
+	tenant := resp.Data["tenant"].(map[string]interface{})
+	equals(t, "ming-luo", tenant["name"])
+	equals(t, map[string]interface{}{"planType": "free"}, tenant["plan"])
+	assert(t, !invoked["usage"], "unselected field must not be resolved")
+
+	resp = Execute(schema, Request{Query: `{ tenant(name: "ming-luo") { usage bogus } }`})
+	equals(t, 2, len(resp.Errors))
+	equals(t, []string{"tenant", "usage"}, resp.Errors[0].Path)
+
+	resp = Execute(schema, Request{Query: `{ tenant { name } }`})
+	equals(t, 1, len(resp.Errors))
+	equals(t, nil, resp.Data["tenant"])
+}
@@ -22,9 +22,33 @@
 package tests
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/datastax/burnell/src/audit"
+	"github.com/datastax/burnell/src/burnelltest"
+	"github.com/datastax/burnell/src/icrypto"
+	"github.com/datastax/burnell/src/metrics"
+	"github.com/datastax/burnell/src/policy"
 	. "github.com/datastax/burnell/src/route"
+	"github.com/datastax/burnell/src/schema"
+	"github.com/datastax/burnell/src/util"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 func TestSubjectMatch(t *testing.T) {
@@ -65,3 +89,2026 @@ func TestSubjectMatch(t *testing.T) {
 	equals(t, t1, t2)
 
 }
+
+func TestValidateTenantSQL(t *testing.T) {
+	errNil(t, ValidateTenantSQL("ming-luo", `SELECT * FROM pulsar."ming-luo/default"."orders" LIMIT 10`))
+	errNil(t, ValidateTenantSQL("ming-luo", `SELECT a.id FROM pulsar."ming-luo/ns1".a JOIN "ming-luo/ns2".b ON a.id = b.id`))
+
+	errNil(t, ValidateTenantSQL("ming-luo", `WITH o AS (SELECT * FROM "ming-luo/default".orders) SELECT extract(year FROM ts) FROM o;`))
+	errNil(t, ValidateTenantSQL("ming-luo", `SELECT * FROM "ming-luo/default".a, (SELECT id FROM "MING-LUO/ns1".b) c WHERE a.id IS DISTINCT FROM c.id`))
+	errNil(t, ValidateTenantSQL("ming-luo", `SELECT * FROM "ming-luo/default".a CROSS JOIN UNNEST(a.tags) AS t(tag) ORDER BY a.id, t.tag`))
+	errNil(t, ValidateTenantSQL("ming-luo", `SHOW TABLES FROM pulsar."ming-luo/default" LIKE 'order%'`))
+	errNil(t, ValidateTenantSQL("ming-luo", `DESCRIBE "ming-luo/default".orders`))
+
+	assertErr(t, "missing query statement", ValidateTenantSQL("ming-luo", ""))
+	assertErr(t, "missing query statement", ValidateTenantSQL("ming-luo", "-- SELECT 1\n;"))
+	assertErr(t, "topic orders must be qualified by the tenant namespace schema such as \"tenant/namespace\"", ValidateTenantSQL("ming-luo", `SELECT * FROM orders`))
+	assertErr(t, "schema \"public/default\" is not under tenant ming-luo", ValidateTenantSQL("ming-luo", `SELECT * FROM pulsar."public/default".orders`))
+	assertErr(t, "schema \"other/default\" is not under tenant ming-luo",
+		ValidateTenantSQL("ming-luo", `SELECT * FROM pulsar."ming-luo/default".a JOIN pulsar."other/default".b ON a.id = b.id`))
+	assertErr(t, "show schemas is not allowed", ValidateTenantSQL("ming-luo", `SHOW SCHEMAS FROM pulsar`))
+	assertErr(t, "schema \"information_schema\" is not under tenant ming-luo",
+		ValidateTenantSQL("ming-luo", `SELECT * FROM pulsar.information_schema.tables WHERE table_schema = 'ming-luo/default'`))
+	assertErr(t, "catalog system is not allowed", ValidateTenantSQL("ming-luo", `SELECT * FROM system.runtime.queries`))
+
+	// the identifiers of other tenants behind the decoys of its own schema
+	assertErr(t, "unicode escaped identifiers are not supported",
+		ValidateTenantSQL("ming-luo", `SELECT * FROM pulsar.U&"other\002fdefault".orders, pulsar."ming-luo/default".a`))
+	assertErr(t, "schema \"other/default\" is not under tenant ming-luo",
+		ValidateTenantSQL("ming-luo", `SELECT '"ming-luo/default"' FROM /* "ming-luo/default".a */ "other/default".orders`))
+	assertErr(t, "schema \"other/default\" is not under tenant ming-luo",
+		ValidateTenantSQL("ming-luo", `SELECT * FROM "ming-luo/default".a limit, "other/default".b`))
+	assertErr(t, "schema \"other/default\" is not under tenant ming-luo",
+		ValidateTenantSQL("ming-luo", `SELECT * FROM (("other/default".b)) WHERE x IN (SELECT x FROM "ming-luo/default".a)`))
+	assertErr(t, "schema \"other/default\" is not under tenant ming-luo",
+		ValidateTenantSQL("ming-luo", `SELECT * FROM "ming-luo/default".a UNION TABLE "other/default".b`))
+	assertErr(t, "schema \"other\"/x\" is not under tenant ming-luo", ValidateTenantSQL("ming-luo", `SELECT * FROM "other""/x".b`))
+	assertErr(t, "multiple statements are not allowed", ValidateTenantSQL("ming-luo", `SELECT * FROM "ming-luo/default".a; SELECT 1`))
+	assertErr(t, "USE statement is not allowed", ValidateTenantSQL("ming-luo", `USE pulsar."other/default"`))
+	assertErr(t, "unterminated quoted identifier", ValidateTenantSQL("ming-luo", `SELECT * FROM "ming-luo/default`))
+}
+
+func TestOffloadGuardrails(t *testing.T) {
+	errNil(t, ValidateOffloadThreshold(-1))
+	errNil(t, ValidateOffloadThreshold(2*1024*1024*1024))
+	assertErr(t, "offload threshold 0 is under the minimum 1073741824 bytes", ValidateOffloadThreshold(0))
+
+	errNil(t, ValidateOffloadPolicies([]byte(`{"managedLedgerOffloadDriver":"aws-s3","managedLedgerOffloadThresholdInBytes":-1}`)))
+	assertErr(t, "offload driver filesystem is not allowed, allowed drivers are aws-s3,google-cloud-storage",
+		ValidateOffloadPolicies([]byte(`{"managedLedgerOffloadDriver":"filesystem"}`)))
+	assertErr(t, "offload threshold 1024 is under the minimum 1073741824 bytes",
+		ValidateOffloadPolicies([]byte(`{"managedLedgerOffloadDriver":"google-cloud-storage","managedLedgerOffloadThresholdInBytes":1024}`)))
+	assertErr(t, "request body requires offload policies", ValidateOffloadPolicies([]byte(`[]`)))
+}
+
+func TestLimiter(t *testing.T) {
+	l := NewLimiter(3, 2)
+	assert(t, l.Acquire("ming-luo"), "")
+	assert(t, l.Acquire("ming-luo"), "")
+	assert(t, !l.Acquire("ming-luo"), "over the per tenant limit")
+	assert(t, l.Acquire(""), "")
+	assert(t, !l.Acquire("another"), "over the total limit")
+
+	stats := l.Stats()
+	equals(t, 3, stats.InFlight)
+	equals(t, uint64(2), stats.Rejected)
+	equals(t, 2, stats.Tenants["ming-luo"].InFlight)
+	equals(t, uint64(1), stats.Tenants["another"].Rejected)
+
+	l.Release("ming-luo")
+	assert(t, l.Acquire("another"), "")
+
+	assert(t, l.SetLimits(LimiterConfig{Limit: 0}) != nil, "limit must be positive")
+	errNil(t, l.SetLimits(LimiterConfig{Limit: 10, PerTenantLimit: 0}))
+	assert(t, l.Acquire("ming-luo") && l.Acquire("ming-luo"), "no per tenant limit")
+	equals(t, 10, l.Stats().Limit)
+
+	// only the idle buckets without in-flight requests are evicted
+	l.Release("another")
+	equals(t, 0, l.EvictIdle(time.Now(), time.Minute))
+	equals(t, 1, l.EvictIdle(time.Now().Add(2*time.Minute), time.Minute))
+	_, ok := l.Stats().Tenants["another"]
+	assert(t, !ok, "idle bucket evicted")
+	equals(t, 3, l.Stats().Tenants["ming-luo"].InFlight)
+}
+
+func TestPlanRateLimiter(t *testing.T) {
+	l := NewPlanRateLimiter()
+	now := time.Now()
+	for i := 0; i < 4; i++ {
+		ok, _ := l.Allow("ming-luo", 2, 4, now)
+		assert(t, ok, "within the burst")
+	}
+	ok, wait := l.Allow("ming-luo", 2, 4, now)
+	assert(t, !ok, "over the burst")
+	equals(t, 500*time.Millisecond, wait)
+
+	ok, _ = l.Allow("ming-luo", 2, 4, now.Add(500*time.Millisecond))
+	assert(t, ok, "refilled at the sustained rate")
+	ok, _ = l.Allow("another", -1, -1, now)
+	assert(t, ok, "unlimited plan")
+	equals(t, map[string]uint64{"ming-luo": 1}, l.Throttled())
+}
+
+func TestBulkheads(t *testing.T) {
+	var metricsInFlight, defaultInFlight int
+	router := mux.NewRouter()
+	router.Path("/pulsarmetrics").Name("pulsar metrics").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		metricsInFlight = Bulkheads[MetricsPool].Stats().InFlight
+		defaultInFlight = Rate.Stats().InFlight
+	})
+	router.Use(LimitRate)
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/pulsarmetrics", nil))
+	equals(t, 1, metricsInFlight)
+	equals(t, 0, defaultInFlight)
+	equals(t, 0, Bulkheads[MetricsPool].Stats().InFlight)
+
+	errNil(t, Bulkheads[MetricsPool].SetLimits(LimiterConfig{Limit: 1}))
+	Bulkheads[MetricsPool].Acquire("")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/pulsarmetrics", nil))
+	equals(t, http.StatusTooManyRequests, rr.Code)
+	Bulkheads[MetricsPool].Release("")
+	errNil(t, Bulkheads[MetricsPool].SetLimits(LimiterConfig{Limit: 20}))
+}
+
+func TestRateLimitExemptions(t *testing.T) {
+	assert(t, SetRateLimitExemptions([]util.RateLimitExemption{{Name: "prom"}}) != nil, "subject or cidr required")
+	assert(t, SetRateLimitExemptions([]util.RateLimitExemption{{Name: "prom", CIDR: "10.0.0.0"}}) != nil, "invalid cidr")
+	assert(t, SetRateLimitExemptions([]util.RateLimitExemption{{Name: "a", CIDR: "10.0.0.0/8"}, {Name: "a", Subject: "ci"}}) != nil, "duplicate")
+	errNil(t, SetRateLimitExemptions([]util.RateLimitExemption{
+		{Name: "prometheus", CIDR: "10.1.0.0/16"},
+		{Name: "automation", CIDR: "10.2.0.0/16", Limit: 1},
+	}))
+	defer SetRateLimitExemptions(nil)
+
+	var defaultInFlight int
+	router := mux.NewRouter()
+	router.Path("/pulsarmetrics").Name("pulsar metrics").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defaultInFlight = Bulkheads[MetricsPool].Stats().InFlight
+	})
+	router.Use(LimitRate)
+
+	errNil(t, Bulkheads[MetricsPool].SetLimits(LimiterConfig{Limit: 1}))
+	defer Bulkheads[MetricsPool].SetLimits(LimiterConfig{Limit: 20})
+	Bulkheads[MetricsPool].Acquire("")
+	defer Bulkheads[MetricsPool].Release("")
+
+	req := httptest.NewRequest(http.MethodGet, "/pulsarmetrics", nil)
+	req.RemoteAddr = "10.1.2.3:5000"
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	equals(t, http.StatusOK, rr.Code)
+	equals(t, 1, defaultInFlight)
+
+	req.RemoteAddr = "10.3.2.3:5000"
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	equals(t, http.StatusTooManyRequests, rr.Code)
+
+	req.RemoteAddr = "10.2.2.3:5000"
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	equals(t, http.StatusOK, rr.Code)
+
+	stats := RateLimitExemptions()
+	equals(t, 2, len(stats))
+	equals(t, uint64(1), stats[0].Served)
+	assert(t, stats[0].Bucket == nil, "full bypass")
+	equals(t, uint64(1), stats[1].Bucket.Served)
+
+	// the counters are kept when the exemptions are replaced
+	errNil(t, SetRateLimitExemptions([]util.RateLimitExemption{{Name: "prometheus", CIDR: "10.1.0.0/16"}}))
+	equals(t, uint64(1), RateLimitExemptions()[0].Served)
+}
+
+func TestETag(t *testing.T) {
+	etag := ETag("plan", "ming-luo", "1600000000")
+	assert(t, etag == ETag("plan", "ming-luo", "1600000000"), "ETag must be deterministic")
+	assert(t, etag != ETag("plan", "ming-luo", "1600000001"), "ETag must change with the version")
+
+	req := httptest.NewRequest(http.MethodGet, "/tenantsusage", nil)
+	rr := httptest.NewRecorder()
+	TenantUsageHandler(rr, req)
+	equals(t, http.StatusOK, rr.Code)
+	usageETag := rr.Header().Get("ETag")
+	assert(t, usageETag != "", "usage response must have an ETag")
+
+	req.Header.Set("If-None-Match", usageETag)
+	rr = httptest.NewRecorder()
+	TenantUsageHandler(rr, req)
+	equals(t, http.StatusNotModified, rr.Code)
+	equals(t, 0, rr.Body.Len())
+}
+
+func TestAccessLogFormat(t *testing.T) {
+	entry := &AccessLogEntry{
+		Time:       time.Date(2021, 3, 1, 10, 30, 0, 0, time.UTC),
+		RemoteAddr: "10.0.0.1:51234",
+		Method:     http.MethodGet,
+		URI:        "/k/tenant/ming-luo",
+		Proto:      "HTTP/1.1",
+		Route:      "kafkaesque tenant management GET",
+		Tenant:     "ming-luo",
+		Subject:    "ming-luo-admin",
+		Status:     http.StatusOK,
+		Bytes:      512,
+		LatencyMs:  12.5,
+		UpstreamMs: 10,
+	}
+
+	var decoded map[string]interface{}
+	errNil(t, json.Unmarshal([]byte(FormatAccessLog(JSONLogFormat, entry)), &decoded))
+	equals(t, "ming-luo", decoded["tenant"])
+	equals(t, float64(512), decoded["bytes"])
+	equals(t, float64(10), decoded["upstreamMs"])
+
+	combined := FormatAccessLog(CombinedLogFormat, entry)
+	assert(t, strings.HasPrefix(combined, `10.0.0.1 - ming-luo-admin [01/Mar/2021:10:30:00 +0000] "GET /k/tenant/ming-luo HTTP/1.1" 200 512 "-" "-" ming-luo`), combined)
+
+	text := FormatAccessLog(TextLogFormat, entry)
+	equals(t, "GET\t/k/tenant/ming-luo\tkafkaesque tenant management GET\t12.5ms", text)
+
+	handler := Logger(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("short and stout"))
+	}), "teapot")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/teapot", nil))
+	equals(t, http.StatusTeapot, rr.Code)
+	equals(t, "short and stout", rr.Body.String())
+}
+
+func TestIdempotent(t *testing.T) {
+	calls := 0
+	handler := Idempotent(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"token":"minted"}`))
+	}))
+
+	send := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/k/tenant/ming-luo", strings.NewReader(body))
+		req.Header.Set("injectedSubs", "superuser")
+		if key != "" {
+			req.Header.Set(IdempotencyKeyHeader, key)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := send("key-1", `{"planType":"free"}`)
+	equals(t, http.StatusCreated, rr.Code)
+	rr = send("key-1", `{"planType":"free"}`)
+	equals(t, http.StatusCreated, rr.Code)
+	equals(t, `{"token":"minted"}`, rr.Body.String())
+	equals(t, "true", rr.Header().Get("Idempotent-Replayed"))
+	equals(t, 1, calls)
+
+	rr = send("key-1", `{"planType":"starter"}`)
+	equals(t, http.StatusUnprocessableEntity, rr.Code)
+	equals(t, 1, calls)
+
+	send("key-2", `{"planType":"free"}`)
+	send("", `{"planType":"free"}`)
+	send("", `{"planType":"free"}`)
+	equals(t, 4, calls)
+
+	// a reused key with a different query is a different request
+	req := httptest.NewRequest(http.MethodPost, "/k/tenant/ming-luo?force=true", strings.NewReader(`{"planType":"free"}`))
+	req.Header.Set("injectedSubs", "superuser")
+	req.Header.Set(IdempotencyKeyHeader, "key-1")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	equals(t, http.StatusUnprocessableEntity, rr.Code)
+	equals(t, 4, calls)
+
+	rr = send("key-3", strings.Repeat("x", 1<<20+1))
+	equals(t, http.StatusRequestEntityTooLarge, rr.Code)
+	equals(t, 4, calls)
+
+	// the least recently used keys are evicted over the limit
+	SetIdempotencyLimits(2, 0)
+	defer SetIdempotencyLimits(10000, 64<<20)
+	send("key-4", `{}`)
+	send("key-5", `{}`)
+	equals(t, 6, calls)
+	send("key-4", `{}`)
+	equals(t, 6, calls)
+	send("key-6", `{}`)
+	equals(t, 7, calls)
+	rr = send("key-4", `{}`)
+	equals(t, "true", rr.Header().Get("Idempotent-Replayed"))
+	rr = send("key-5", `{}`)
+	equals(t, "", rr.Header().Get("Idempotent-Replayed"))
+	equals(t, 8, calls)
+
+	// the stored bytes are capped, a response over the cap is not kept
+	SetIdempotencyLimits(0, 10)
+	send("key-7", `{}`)
+	send("key-7", `{}`)
+	equals(t, 10, calls)
+}
+
+func TestIdempotentMint(t *testing.T) {
+	minted := 0
+	mint := func(w http.ResponseWriter, r *http.Request) {
+		minted++
+		w.Write([]byte(fmt.Sprintf(`{"token":"t%d"}`, minted)))
+	}
+	send := func(handler http.Handler, target, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set("injectedSubs", "superuser")
+		req.Header.Set(IdempotencyKeyHeader, key)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// a plain Idempotent route does not store the reads
+	reads := Idempotent(http.HandlerFunc(mint))
+	equals(t, `{"token":"t1"}`, send(reads, "/subject/ming", "read-1").Body.String())
+	equals(t, `{"token":"t2"}`, send(reads, "/subject/ming", "read-1").Body.String())
+
+	handler := IdempotentMint(http.HandlerFunc(mint))
+	equals(t, `{"token":"t3"}`, send(handler, "/subject/ming", "mint-1").Body.String())
+	rr := send(handler, "/subject/ming", "mint-1")
+	equals(t, `{"token":"t3"}`, rr.Body.String())
+	equals(t, "true", rr.Header().Get("Idempotent-Replayed"))
+	equals(t, http.StatusUnprocessableEntity, send(handler, "/subject/ming?exp=1h", "mint-1").Code)
+	equals(t, `{"token":"t4"}`, send(handler, "/subject/luo", "mint-1").Body.String())
+	equals(t, 4, minted)
+}
+
+func TestTenantCacheWarming(t *testing.T) {
+	rr := httptest.NewRecorder()
+	ReadinessPage(rr, httptest.NewRequest(http.MethodGet, "/readiness", nil))
+	equals(t, http.StatusServiceUnavailable, rr.Code)
+	equals(t, "true", rr.Header().Get("X-Burnell-Warming"))
+
+	req := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/k/tenant/ming-luo", nil), map[string]string{"tenant": "ming-luo"})
+	rr = httptest.NewRecorder()
+	TenantManagementHandler(rr, req)
+	equals(t, http.StatusServiceUnavailable, rr.Code)
+	assert(t, rr.Header().Get("Retry-After") != "", "warming response must have Retry-After")
+}
+
+func TestEvaluateSubject(t *testing.T) {
+	check := func(required, subject string, expAllowed bool, expReason string) {
+		allowed, reason := EvaluateSubject(required, subject)
+		equals(t, expAllowed, allowed)
+		equals(t, expReason, reason)
+	}
+	check("chris-datastax", "chris-datastax-client-12345qbc", true, TenantMatchAllowed)
+	check("your-framework-dev", "your-framework-dev-adMin-8e5f5b7412345", false, CaseMismatchDenied)
+	check("chris-datastax", "Chris-Datastax-12345qbc", false, CaseMismatchDenied)
+	check("chris", "chris-datastax-client-12345qbc", false, SuffixMismatchDenied)
+	check("ming-luo", "chris-datastax-12345qbc", false, TenantMismatchDenied)
+	check("ming-luo", "", false, EmptySubjectDenied)
+
+	assert(t, !VerifySubject("ming-luo", "chris-datastax-12345qbc"), "")
+	stats := GetSubjectDecisionStats("")
+	assert(t, stats.Counters[TenantMismatchDenied] > 0, "tenant mismatch must be counted")
+}
+
+// enableJWT enables the JWT authentication with a new RSA key pair, it returns the function to restore the settings
+func enableJWT(t *testing.T) func() {
+	rsaKeys, err := icrypto.NewRSAKeyPair()
+	errNil(t, err)
+	savedAuth, savedPublicKey := util.JWTAuth, util.Config.PulsarPublicKey
+	util.JWTAuth = icrypto.NewJWTKeys(rsaKeys, icrypto.DefaultAllowedAlgs)
+	util.Config.PulsarPublicKey = "jwt-test-public-key"
+	return func() { util.JWTAuth, util.Config.PulsarPublicKey = savedAuth, savedPublicKey }
+}
+
+func TestPermissionRoutes(t *testing.T) {
+	h, err := burnelltest.New(burnelltest.Options{})
+	errNil(t, err)
+	defer h.Close()
+	defer enableJWT(t)()
+	token, err := util.JWTAuth.GenerateToken("chris-datastax-client-12345qbc", time.Hour, icrypto.SigMethod("RS256"))
+	errNil(t, err)
+
+	router := NewRouter()
+	send := func(method, path, body string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	namespace := "/admin/v2/namespaces/chris-datastax/ns1/permissions/"
+	equals(t, http.StatusForbidden, send(http.MethodPost, namespace+"subscription/sub1", `["ming-luo-12345qbc"]`))
+	equals(t, http.StatusForbidden, send(http.MethodPost, namespace+"subscription/sub1", `["chris-datastax-client-1","ming-luo-12345qbc"]`))
+	equals(t, http.StatusUnprocessableEntity, send(http.MethodPost, namespace+"subscription/sub1", `{}`))
+	code := send(http.MethodPost, namespace+"subscription/sub1", `["chris-datastax-client-1"]`)
+	assert(t, code != http.StatusForbidden && code != http.StatusUnauthorized, "grant to its own role")
+	equals(t, http.StatusForbidden, send(http.MethodDelete, namespace+"sub1/ming-luo-12345qbc", ""))
+	equals(t, http.StatusForbidden, send(http.MethodPost, namespace+"ming-luo-12345qbc", `["produce"]`))
+	equals(t, http.StatusMovedPermanently, send(http.MethodPost, namespace+"ming-luo-12345qbc/", `["produce"]`))
+	equals(t, http.StatusUnauthorized, send(http.MethodPost, namespace+"ming-luo-12345qbc/produce/x", `["produce"]`))
+	equals(t, http.StatusUnauthorized, send(http.MethodPost, "/admin/v2/persistent/chris-datastax/ns1/topic1/permissions/ming-luo-12345qbc/x", `["produce"]`))
+	equals(t, http.StatusForbidden, send(http.MethodPost, "/admin/v2/non-persistent/chris-datastax/ns1/topic1/permissions/ming-luo-12345qbc", `["produce"]`))
+}
+
+func TestPermissionProxyHandler(t *testing.T) {
+	assert(t, RoleBelongsToTenant("chris-datastax", "chris-datastax-client-12345qbc"), "")
+	assert(t, RoleBelongsToTenant("chris-datastax", "chris-datastax-admin-12345qbc"), "")
+	assert(t, !RoleBelongsToTenant("chris-datastax", "ming-luo-12345qbc"), "")
+	assert(t, !RoleBelongsToTenant("chris", "chris-datastax-12345qbc"), "")
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/v2/namespaces/chris-datastax/ns1/permissions/ming-luo-12345qbc", strings.NewReader(`["produce"]`))
+	req.Header.Set("injectedSubs", "chris-datastax-admin-12345qbc")
+	req = mux.SetURLVars(req, map[string]string{"tenant": "chris-datastax", "namespace": "ns1", "role": "ming-luo-12345qbc"})
+	rr := httptest.NewRecorder()
+	PermissionProxyHandler(rr, req)
+	equals(t, http.StatusForbidden, rr.Code)
+}
+
+func TestLimitRateKnownTenants(t *testing.T) {
+	h, err := burnelltest.New(burnelltest.Options{Tenants: []policy.TenantPlan{
+		{Name: "rate-known", PlanType: policy.FreeTier},
+	}})
+	errNil(t, err)
+	defer h.Close()
+
+	router := mux.NewRouter()
+	router.Path("/t/{tenant}").Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	router.Use(LimitRate)
+	for _, tenant := range []string{"rate-known", "rate-unknown"} {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/t/"+tenant, nil))
+		equals(t, http.StatusOK, rr.Code)
+	}
+	tenants := Rate.Stats().Tenants
+	_, ok := tenants["rate-known"]
+	assert(t, ok, "known tenant bucket")
+	_, ok = tenants["rate-unknown"]
+	assert(t, !ok, "an unknown tenant has no bucket")
+}
+
+func TestPulsarSQLQueryTenant(t *testing.T) {
+	h, err := burnelltest.New(burnelltest.Options{Tenants: []policy.TenantPlan{
+		{Name: "ming-luo", PlanType: policy.FreeTier, Policy: policy.PlanPolicy{FeatureCodes: policy.PulsarSQL}},
+		{Name: "chris-datastax", PlanType: policy.FreeTier, Policy: policy.PlanPolicy{FeatureCodes: policy.PulsarSQL}},
+	}})
+	errNil(t, err)
+	defer h.Close()
+
+	presto := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost:
+			fmt.Fprintf(w, `{"id":"20201014_000000_00001_aaaaa","nextUri":"http://%s/v1/statement/queued/20201014_000000_00001_aaaaa/x/1"}`, r.Host)
+		case r.URL.Path == "/v1/query/20201014_000000_00002_bbbbb":
+			w.Write([]byte(`{"queryId":"20201014_000000_00002_bbbbb","session":{"user":"chris-datastax"}}`))
+		case strings.HasPrefix(r.URL.Path, "/v1/query/"):
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.Write([]byte(`{"id":"20201014_000000_00001_aaaaa"}`))
+		}
+	}))
+	defer presto.Close()
+	savedURL := util.Config.PulsarSQLURL
+	defer func() { util.Config.PulsarSQLURL = savedURL }()
+	util.Config.PulsarSQLURL = presto.URL
+
+	send := func(tenant, method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/sql/"+tenant+path, strings.NewReader(body))
+		req.Header.Set("injectedSubs", tenant+"-client-12345qbc")
+		req = mux.SetURLVars(req, map[string]string{"tenant": tenant})
+		rr := httptest.NewRecorder()
+		PulsarSQLStatementHandler(rr, req)
+		return rr
+	}
+	rr := send("ming-luo", http.MethodPost, "/v1/statement", `SELECT * FROM "ming-luo/default".orders`)
+	equals(t, http.StatusOK, rr.Code)
+	assert(t, strings.Contains(rr.Body.String(), "/sql/ming-luo/v1/statement/queued/"), "rewritten nextUri")
+
+	equals(t, http.StatusOK, send("ming-luo", http.MethodGet, "/v1/statement/queued/20201014_000000_00001_aaaaa/x/1", "").Code)
+	equals(t, http.StatusNotFound, send("chris-datastax", http.MethodGet, "/v1/statement/queued/20201014_000000_00001_aaaaa/x/1", "").Code)
+	equals(t, http.StatusNotFound, send("chris-datastax", http.MethodDelete, "/v1/statement/20201014_000000_00001_aaaaa/1", "").Code)
+	// submitted through another instance
+	equals(t, http.StatusOK, send("chris-datastax", http.MethodGet, "/v1/statement/executing/20201014_000000_00002_bbbbb/y/2", "").Code)
+	equals(t, http.StatusNotFound, send("ming-luo", http.MethodGet, "/v1/statement/executing/20201014_000000_00002_bbbbb/y/2", "").Code)
+	equals(t, http.StatusNotFound, send("ming-luo", http.MethodGet, "/v1/statement/executing/20201014_000000_00003_ccccc/y/2", "").Code)
+	equals(t, http.StatusNotFound, send("ming-luo", http.MethodGet, "/v1/statement/..%2Fquery/1", "").Code)
+}
+
+func TestGrafanaDatasource(t *testing.T) {
+	dat, err := ioutil.ReadFile("./tenantusage.dat")
+	errNil(t, err)
+	metrics.SetCache(metrics.SuperRole, dat)
+	errNil(t, metrics.InitUsageDbTable())
+	from := time.Now().Add(-time.Minute)
+	metrics.BuildTenantUsage()
+	metrics.BuildTenantUsage()
+
+	req := httptest.NewRequest(http.MethodPost, "/grafana/search", strings.NewReader(`{"target":"totalBytesIn"}`))
+	req.Header.Set("injectedSubs", "ming-luo-client-12345qbc")
+	rr := httptest.NewRecorder()
+	GrafanaSearchHandler(rr, req)
+	equals(t, http.StatusOK, rr.Code)
+	var targets []string
+	errNil(t, json.Unmarshal(rr.Body.Bytes(), &targets))
+	equals(t, []string{"ming-luo/totalBytesIn"}, targets)
+
+	body := `{"range":{"from":"` + from.Format(time.RFC3339) + `","to":"` + time.Now().Add(time.Minute).Format(time.RFC3339) +
+		`"},"maxDataPoints":1,"targets":[{"target":"ming-luo/totalBytesIn","refId":"A"}]}`
+	req = httptest.NewRequest(http.MethodPost, "/grafana/query", strings.NewReader(body))
+	req.Header.Set("injectedSubs", "ming-luo-client-12345qbc")
+	rr = httptest.NewRecorder()
+	GrafanaQueryHandler(rr, req)
+	equals(t, http.StatusOK, rr.Code)
+	var series []GrafanaTimeSeries
+	errNil(t, json.Unmarshal(rr.Body.Bytes(), &series))
+	equals(t, 1, len(series))
+	equals(t, 1, len(series[0].DataPoints))
+	equals(t, float64(2681610), series[0].DataPoints[0][0])
+
+	req = httptest.NewRequest(http.MethodPost, "/grafana/query", strings.NewReader(body))
+	req.Header.Set("injectedSubs", "other-tenant-client-12345qbc")
+	rr = httptest.NewRecorder()
+	GrafanaQueryHandler(rr, req)
+	equals(t, http.StatusForbidden, rr.Code)
+}
+
+func TestRequestLatencyHistogram(t *testing.T) {
+	router := mux.NewRouter()
+	router.Path("/latency/{tenant}").Name("latency test").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	router.Path("/unnamed/{tenant}").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	router.Use(RequestLatency)
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/latency/ming-luo", nil))
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/unnamed/ming-luo", nil))
+
+	families, err := prometheus.DefaultGatherer.Gather()
+	errNil(t, err)
+	routes := map[string]uint64{}
+	for _, mf := range families {
+		if mf.GetName() != "burnell_http_request_duration_seconds" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "route" {
+					routes[l.GetValue()] = m.GetHistogram().GetSampleCount()
+				}
+			}
+		}
+	}
+	equals(t, uint64(1), routes["latency test"])
+	equals(t, uint64(1), routes["/unnamed/{tenant}"])
+}
+
+func TestTokenExchange(t *testing.T) {
+	defer enableJWT(t)()
+
+	adminToken, err := util.JWTAuth.GenerateToken("ming-luo-admin-12345qbc", 2*time.Hour, icrypto.SigMethod("RS256"))
+	errNil(t, err)
+	exchange := func(subject, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/token/exchange", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+adminToken)
+		req.Header.Set("injectedSubs", subject)
+		rr := httptest.NewRecorder()
+		TokenExchangeHandler(rr, req)
+		return rr
+	}
+
+	rr := exchange("ming-luo-admin-12345qbc", `{"exp":"30m","key":"app1"}`)
+	equals(t, http.StatusOK, rr.Code)
+	var resp TokenServerResponse
+	errNil(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	equals(t, "ming-luo-client-app1", resp.Subject)
+	subject, err := util.JWTAuth.GetTokenSubject(resp.Token)
+	errNil(t, err)
+	equals(t, "ming-luo-client-app1", subject)
+
+	// longer than the admin token validity
+	equals(t, http.StatusUnprocessableEntity, exchange("ming-luo-admin-12345qbc", `{"exp":"3h"}`).Code)
+	equals(t, http.StatusUnprocessableEntity, exchange("ming-luo-admin-12345qbc", `{"key":"app-1"}`).Code)
+	equals(t, http.StatusForbidden, exchange("ming-luo-client-12345qbc", `{}`).Code)
+}
+
+func TestTopicCreationPlanDefaults(t *testing.T) {
+	var lock sync.Mutex
+	policies := map[string]string{}
+	broker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		lock.Lock()
+		policies[r.Method+" "+r.URL.Path] = string(body)
+		lock.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer broker.Close()
+	savedURL := util.Config.BrokerProxyURL
+	defer func() { util.Config.BrokerProxyURL = savedURL }()
+	util.Config.BrokerProxyURL = broker.URL
+
+	req := httptest.NewRequest(http.MethodPut, "/admin/v2/persistent/ming-luo/ns1/topic1", nil)
+	req.Header.Set("injectedSubs", "ming-luo-client-12345qbc")
+	req = mux.SetURLVars(req, map[string]string{"tenant": "ming-luo", "namespace": "ns1"})
+	rr := httptest.NewRecorder()
+	TopicProxyHandler(rr, req)
+	equals(t, http.StatusNoContent, rr.Code)
+
+	// the free plan defaults of an unknown tenant
+	equals(t, "", policies["PUT /admin/v2/persistent/ming-luo/ns1/topic1"])
+	equals(t, "3", policies["POST /admin/v2/persistent/ming-luo/ns1/topic1/maxProducers"])
+	equals(t, "172800", policies["POST /admin/v2/persistent/ming-luo/ns1/topic1/messageTTL"])
+	_, dedup := policies["POST /admin/v2/persistent/ming-luo/ns1/topic1/deduplicationEnabled"]
+	assert(t, !dedup, "deduplication requires the feature")
+}
+
+func TestAllowedClusters(t *testing.T) {
+	var lock sync.Mutex
+	calls := []string{}
+	east := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		calls = append(calls, "east "+r.Method+" "+r.URL.Path+" "+r.Header.Get(ClusterHeader))
+		lock.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer east.Close()
+	h, err := burnelltest.New(burnelltest.Options{Tenants: []policy.TenantPlan{
+		{Name: "cluster-tenant", PlanType: policy.FreeTier, AllowedClusters: []string{"west", "east"}},
+		{Name: "east-tenant", PlanType: policy.FreeTier, AllowedClusters: []string{"east"}},
+	}})
+	errNil(t, err)
+	defer h.Close()
+	savedName, savedURL := util.Config.ClusterName, util.BrokerProxyURL
+	defer func() {
+		util.Config.ClusterName, util.BrokerProxyURL = savedName, savedURL
+		SetClusterAdminURLs("")
+	}()
+	util.Config.ClusterName = "west"
+	util.BrokerProxyURL, _ = url.Parse(h.Admin.URL)
+	errNil(t, SetClusterAdminURLs("east="+east.URL))
+	equals(t, []string{"east", "west"}, FrontedClusters())
+	assert(t, SetClusterAdminURLs("east") != nil, "invalid cluster admin url")
+	errNil(t, SetClusterAdminURLs("east="+east.URL))
+
+	proxy := func(tenant, method, path, cluster, body string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("injectedSubs", tenant+"-client-12345qbc")
+		if cluster != "" {
+			req.Header.Set(ClusterHeader, cluster)
+		}
+		req = mux.SetURLVars(req, map[string]string{"tenant": tenant})
+		rr := httptest.NewRecorder()
+		DirectBrokerProxyHandler(rr, req)
+		return rr.Code
+	}
+	equals(t, http.StatusNoContent, proxy("cluster-tenant", http.MethodPut, "/admin/v2/namespaces/cluster-tenant/ns1", "east", ""))
+	equals(t, []string{"east PUT /admin/v2/namespaces/cluster-tenant/ns1 "}, calls)
+	// the default cluster is served by the BrokerProxyURL
+	equals(t, http.StatusNoContent, proxy("cluster-tenant", http.MethodPut, "/admin/v2/namespaces/cluster-tenant/ns2", "", ""))
+	equals(t, 1, len(calls))
+	equals(t, http.StatusForbidden, proxy("east-tenant", http.MethodPut, "/admin/v2/namespaces/east-tenant/ns1", "", ""))
+	equals(t, http.StatusBadRequest, proxy("east-tenant", http.MethodPut, "/admin/v2/namespaces/east-tenant/ns1", "north", ""))
+	equals(t, http.StatusForbidden, proxy("east-tenant", http.MethodPost, "/admin/v2/namespaces/east-tenant/ns1/replication", "east", `["east","west"]`))
+	equals(t, http.StatusNoContent, proxy("east-tenant", http.MethodPost, "/admin/v2/namespaces/east-tenant/ns1/replication", "east", `["east"]`))
+	equals(t, 2, len(calls))
+	// a tenant without the restriction targets any fronted cluster
+	equals(t, http.StatusNoContent, proxy("unrestricted-tenant", http.MethodPut, "/admin/v2/namespaces/unrestricted-tenant/ns1", "east", ""))
+
+	err = policy.ValidateTenantPlan(policy.TenantPlan{PlanType: "free", AllowedClusters: []string{"east", "east", "a/b"}})
+	vErr, ok := err.(*policy.ValidationError)
+	assert(t, ok, "expect validation error")
+	equals(t, 2, len(vErr.Fields))
+}
+
+func TestUpstreamCredentials(t *testing.T) {
+	var lock sync.Mutex
+	tokens := []string{}
+	east := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		tokens = append(tokens, r.Header.Get("Authorization"))
+		lock.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer east.Close()
+	h, err := burnelltest.New(burnelltest.Options{})
+	errNil(t, err)
+	defer h.Close()
+	savedName, savedURL := util.Config.ClusterName, util.BrokerProxyURL
+	defer func() {
+		util.Config.ClusterName, util.BrokerProxyURL = savedName, savedURL
+		SetClusterAdminURLs("")
+		SetUpstreamCredentials(nil)
+	}()
+	util.Config.ClusterName = "west"
+	util.BrokerProxyURL, _ = url.Parse(h.Admin.URL)
+	errNil(t, SetClusterAdminURLs("east="+east.URL))
+
+	tokenFile, err := ioutil.TempFile("", "east-token")
+	errNil(t, err)
+	defer os.Remove(tokenFile.Name())
+	errNil(t, ioutil.WriteFile(tokenFile.Name(), []byte("east-token-1\n"), 0600))
+	assert(t, SetUpstreamCredentials([]util.ClusterCredential{{Token: "a"}}) != nil, "cluster is required")
+	assert(t, SetUpstreamCredentials([]util.ClusterCredential{{Cluster: "east", Token: "a", TokenFile: tokenFile.Name()}}) != nil, "token and token file")
+	assert(t, SetUpstreamCredentials([]util.ClusterCredential{{Cluster: "east", TLSCertFile: "cert.pem"}}) != nil, "certificate requires the key")
+	assert(t, SetUpstreamCredentials([]util.ClusterCredential{{Cluster: "east", TokenFile: "/no-such-token"}}) != nil, "missing token file")
+	errNil(t, SetUpstreamCredentials([]util.ClusterCredential{{Cluster: "east", TokenFile: tokenFile.Name()}}))
+
+	proxy := func(cluster string) int {
+		req := httptest.NewRequest(http.MethodPut, "/admin/v2/namespaces/ming-luo/ns1", nil)
+		req.Header.Set("injectedSubs", "ming-luo-client-12345qbc")
+		req.Header.Set(ClusterHeader, cluster)
+		req = mux.SetURLVars(req, map[string]string{"tenant": "ming-luo"})
+		rr := httptest.NewRecorder()
+		DirectBrokerProxyHandler(rr, req)
+		return rr.Code
+	}
+	equals(t, http.StatusNoContent, proxy("east"))
+	equals(t, []string{"Bearer east-token-1"}, tokens)
+
+	errNil(t, ioutil.WriteFile(tokenFile.Name(), []byte("east-token-2"), 0600))
+	future := time.Now().Add(time.Minute)
+	errNil(t, os.Chtimes(tokenFile.Name(), future, future))
+	statuses := UpstreamCredentials(true)
+	equals(t, 1, len(statuses))
+	equals(t, "east", statuses[0].Cluster)
+	equals(t, 1, statuses[0].Rotations)
+	equals(t, http.StatusNoContent, proxy("east"))
+	equals(t, "Bearer east-token-2", tokens[1])
+
+	// the status never exposes the token
+	rr := httptest.NewRecorder()
+	UpstreamCredentialsHandler(rr, httptest.NewRequest(http.MethodGet, "/admin/internal/upstream-credentials", nil))
+	equals(t, http.StatusOK, rr.Code)
+	assert(t, !strings.Contains(rr.Body.String(), "east-token"), "token in the status")
+}
+
+func TestAdminAPIVersions(t *testing.T) {
+	var lock sync.Mutex
+	paths := []string{}
+	brokerVersion := "2.2.1"
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		if r.URL.Path == "/admin/v2/brokers/version" {
+			w.Write([]byte(brokerVersion))
+			return
+		}
+		paths = append(paths, r.URL.Path)
+		w.Write([]byte(`[]`))
+	}))
+	defer upstream.Close()
+	savedName, savedURL, savedFunctionURL := util.Config.ClusterName, util.BrokerProxyURL, util.Config.FunctionProxyURL
+	defer func() {
+		util.Config.ClusterName, util.BrokerProxyURL, util.Config.FunctionProxyURL = savedName, savedURL, savedFunctionURL
+		SetAdminAPIVersions("")
+	}()
+	util.Config.ClusterName = "versioned"
+	util.BrokerProxyURL, _ = url.Parse(upstream.URL)
+	util.Config.FunctionProxyURL = upstream.URL
+
+	versions, err := AdminVersionsOf("2.7.2.1.1.22")
+	errNil(t, err)
+	equals(t, "v3", versions["functions"])
+	versions, err = AdminVersionsOf("3.0.0-SNAPSHOT")
+	errNil(t, err)
+	equals(t, "v3", versions["sinks"])
+	_, err = AdminVersionsOf("latest")
+	assert(t, err != nil, "invalid version")
+
+	statuses := AdminAPIVersions(true)
+	equals(t, 1, len(statuses))
+	equals(t, "2.2.1", statuses[0].BrokerVersion)
+	equals(t, "v2", statuses[0].Versions["functions"])
+
+	proxy := func(path string) {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req = mux.SetURLVars(req, map[string]string{"tenant": "ming-luo"})
+		rr := httptest.NewRecorder()
+		DirectFunctionProxyHandler(rr, req)
+		equals(t, http.StatusOK, rr.Code)
+	}
+	// the v3 path is translated to the v2 upstream of a Pulsar before 2.3
+	proxy("/admin/v3/functions/ming-luo/ns1")
+	proxy("/admin/v2/sinks/ming-luo/ns1")
+
+	// the upgraded cluster is detected again
+	lock.Lock()
+	brokerVersion = "2.8.0"
+	lock.Unlock()
+	equals(t, "v3", AdminAPIVersions(true)[0].Versions["functions"])
+	proxy("/admin/v2/functions/ming-luo/ns1")
+
+	// a resource version is overridden for all clusters
+	assert(t, SetAdminAPIVersions("functions=3") != nil, "invalid version")
+	errNil(t, SetAdminAPIVersions("functions=v2"))
+	proxy("/admin/v3/functions/ming-luo/ns1")
+	equals(t, "v2", AdminAPIVersions(false)[0].Versions["functions"])
+
+	lock.Lock()
+	defer lock.Unlock()
+	equals(t, []string{"/admin/v2/functions/ming-luo/ns1", "/admin/v2/sinks/ming-luo/ns1", "/admin/v3/functions/ming-luo/ns1", "/admin/v2/functions/ming-luo/ns1"}, paths)
+}
+
+func TestQuotaEnforcement(t *testing.T) {
+	h, err := burnelltest.New(burnelltest.Options{Tenants: []policy.TenantPlan{
+		{Name: "enforced-tenant", PlanType: policy.FreeTier, Policy: policy.PlanPolicy{NumOfPartitions: 2}},
+		{Name: "observed-tenant", PlanType: policy.FreeTier, Policy: policy.PlanPolicy{NumOfPartitions: 2}, QuotaEnforcement: policy.ObserveQuota},
+	}})
+	errNil(t, err)
+	defer h.Close()
+	defer SetQuotaEnforcement(policy.EnforceQuota)
+
+	partitions := func(tenant string) int {
+		req := httptest.NewRequest(http.MethodPut, "/admin/topics/"+tenant+"/ns1/topic1/partitions", strings.NewReader("3"))
+		req.Header.Set("injectedSubs", tenant+"-client-12345qbc")
+		req = mux.SetURLVars(req, map[string]string{"tenant": tenant, "namespace": "ns1", "topic": "topic1"})
+		rr := httptest.NewRecorder()
+		PartitionedTopicHandler(rr, req)
+		return rr.Code
+	}
+	equals(t, http.StatusPaymentRequired, partitions("enforced-tenant"))
+	assert(t, partitions("observed-tenant") != http.StatusPaymentRequired, "observe mode passes the violation")
+
+	assert(t, SetQuotaEnforcement("warn") != nil, "invalid mode")
+	errNil(t, SetQuotaEnforcement(policy.ObserveQuota))
+	assert(t, partitions("enforced-tenant") != http.StatusPaymentRequired, "global observe mode passes the violation")
+
+	stats := QuotaEnforcement()
+	equals(t, policy.ObserveQuota, stats.Mode)
+	equals(t, 2, len(stats.Violations))
+	equals(t, "enforced-tenant", stats.Violations[0].Tenant)
+	equals(t, PartitionsQuota, stats.Violations[0].Quota)
+	equals(t, 2, stats.Violations[0].Count)
+	equals(t, policy.ObserveQuota, stats.Violations[0].Mode)
+	equals(t, 1, stats.Violations[1].Count)
+
+	err = policy.ValidateTenantPlan(policy.TenantPlan{PlanType: "free", QuotaEnforcement: "warn"})
+	_, ok := err.(*policy.ValidationError)
+	assert(t, ok, "expect validation error")
+}
+
+func TestNativePartitionedTopicCap(t *testing.T) {
+	h, err := burnelltest.New(burnelltest.Options{Tenants: []policy.TenantPlan{
+		{Name: "enforced-tenant", PlanType: policy.FreeTier, Policy: policy.PlanPolicy{NumOfPartitions: 2}},
+	}})
+	errNil(t, err)
+	defer h.Close()
+
+	defer enableJWT(t)()
+	token, err := util.JWTAuth.GenerateToken("enforced-tenant-client-12345qbc", time.Hour, icrypto.SigMethod("RS256"))
+	errNil(t, err)
+
+	router := NewRouter()
+	for _, domain := range []string{"persistent", "non-persistent"} {
+		for _, method := range []string{http.MethodPut, http.MethodPost} {
+			req := httptest.NewRequest(method, "/admin/v2/"+domain+"/enforced-tenant/ns1/topic1/partitions", strings.NewReader("3"))
+			req.Header.Set("Authorization", "Bearer "+token)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			equals(t, http.StatusPaymentRequired, rr.Code)
+		}
+	}
+}
+
+func TestTenantTopicMaintenance(t *testing.T) {
+	h, err := burnelltest.New(burnelltest.Options{})
+	errNil(t, err)
+	defer h.Close()
+	topicPath := "/admin/v2/persistent/public/default/tenants-management"
+
+	stats := policy.TenantManager.TopicStats()
+	equals(t, "persistent://public/default/tenants-management", stats.Topic)
+	assert(t, stats.Error != "", "internal stats failure")
+
+	h.Admin.SetResponse(http.MethodGet, topicPath+"/internalStats", http.StatusOK, map[string]interface{}{
+		"numberOfEntries":    120,
+		"totalSize":          40960,
+		"lastConfirmedEntry": "12:30",
+		"ledgers":            []map[string]interface{}{{"ledgerId": 10, "entries": 0}, {"ledgerId": 11, "entries": 90}, {"ledgerId": 12, "entries": 30}},
+		"compactedLedger":    map[string]interface{}{"ledgerId": 20, "entries": 8},
+	})
+	h.Admin.SetResponse(http.MethodGet, topicPath+"/compaction", http.StatusOK, map[string]string{"status": "SUCCESS"})
+	rr := httptest.NewRecorder()
+	TenantTopicHandler(rr, httptest.NewRequest(http.MethodGet, "/admin/internal/tenant-topic", nil))
+	equals(t, http.StatusOK, rr.Code)
+	stats = policy.TenantTopicStats{}
+	errNil(t, json.Unmarshal(rr.Body.Bytes(), &stats))
+	equals(t, "", stats.Error)
+	equals(t, int64(120), stats.MessageCount)
+	equals(t, 3, stats.NumberOfLedgers)
+	equals(t, "11:0", stats.EarliestMessageID)
+	equals(t, "12:30", stats.LatestMessageID)
+	equals(t, int64(8), stats.CompactedEntries)
+	equals(t, "SUCCESS", stats.CompactionStatus)
+
+	rr = httptest.NewRecorder()
+	TenantTopicCompactionHandler(rr, httptest.NewRequest(http.MethodPost, "/admin/internal/tenant-topic/compaction", nil))
+	equals(t, http.StatusAccepted, rr.Code)
+	requests := h.Admin.Requests()
+	assert(t, util.StrContains(requests, "PUT "+topicPath+"/compaction"), "compaction triggered")
+
+	// a broker without the trim endpoint
+	h.Admin.SetResponse(http.MethodPost, topicPath+"/trim", http.StatusMethodNotAllowed, nil)
+	rr = httptest.NewRecorder()
+	TenantTopicTrimHandler(rr, httptest.NewRequest(http.MethodPost, "/admin/internal/tenant-topic/trim", nil))
+	equals(t, http.StatusMethodNotAllowed, rr.Code)
+}
+
+func TestDestructiveOpAudit(t *testing.T) {
+	for path, action := range map[string]string{
+		"DELETE /admin/v2/persistent/ming-luo/ns1/topic1":                           "delete-topic",
+		"DELETE /admin/v2/persistent/ming-luo/ns1/topic1/partitions":                "delete-topic",
+		"POST /admin/v2/namespaces/ming-luo/ns1/clearBacklog":                       "clear-namespace-backlog",
+		"POST /admin/v2/namespaces/ming-luo/ns1/0x00000000_0xffffffff/clearBacklog": "clear-namespace-backlog",
+		"PUT /admin/v2/namespaces/ming-luo/ns1/unload":                              "unload-namespace",
+		"DELETE /admin/v2/namespaces/ming-luo/ns1":                                  "delete-namespace",
+		"DELETE /admin/v3/functions/ming-luo/ns1/fn1":                               "delete-function",
+	} {
+		parts := strings.SplitN(path, " ", 2)
+		got, ok := DestructiveOp(httptest.NewRequest(parts[0], parts[1], nil))
+		assert(t, ok, path)
+		equals(t, action, got)
+	}
+	_, ok := DestructiveOp(httptest.NewRequest(http.MethodGet, "/admin/v2/persistent/ming-luo/ns1/topic1", nil))
+	assert(t, !ok, "read only")
+	_, ok = DestructiveOp(httptest.NewRequest(http.MethodPut, "/admin/v2/persistent/ming-luo/ns1/topic1", nil))
+	assert(t, !ok, "topic creation")
+
+	broker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer broker.Close()
+	savedURL := util.Config.BrokerProxyURL
+	defer func() { util.Config.BrokerProxyURL = savedURL }()
+	util.Config.BrokerProxyURL = broker.URL
+
+	req := httptest.NewRequest(http.MethodDelete, "/admin/v2/persistent/destructive-audit/ns1/topic1?force=true", nil)
+	req.Header.Set("injectedSubs", "destructive-audit-admin-12345qbc")
+	rr := httptest.NewRecorder()
+	DirectBrokerProxyHandler(rr, req)
+	equals(t, http.StatusNoContent, rr.Code)
+
+	events := audit.Events("destructive-audit", 1)
+	equals(t, 1, len(events))
+	equals(t, "delete-topic", events[0].Action)
+	equals(t, "destructive-audit-admin-12345qbc", events[0].Subject)
+	equals(t, "/admin/v2/persistent/destructive-audit/ns1/topic1", events[0].Resource)
+	equals(t, "force=true", events[0].Detail)
+	equals(t, http.StatusNoContent, events[0].Status)
+}
+
+func TestGraphQLAuditEvents(t *testing.T) {
+	for _, action := range []string{"create-topic", "update-plan", "delete-topic"} {
+		audit.Record(audit.Event{Tenant: "graphql-audit", Action: action, Status: http.StatusOK})
+	}
+	query := func(body string) map[string]interface{} {
+		req := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(body))
+		req.Header.Set("injectedSubs", "graphql-audit-admin-12345qbc")
+		rr := httptest.NewRecorder()
+		GraphQLHandler(rr, req)
+		equals(t, http.StatusOK, rr.Code)
+		var resp map[string]interface{}
+		errNil(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		equals(t, nil, resp["errors"])
+		return resp["data"].(map[string]interface{})["tenant"].(map[string]interface{})
+	}
+
+	tenant := query(`{"query": "{ tenant(name: \"graphql-audit\") { audit(limit: 2) { action status } } }"}`)
+	equals(t, []interface{}{
+		map[string]interface{}{"action": "delete-topic", "status": float64(http.StatusOK)},
+		map[string]interface{}{"action": "update-plan", "status": float64(http.StatusOK)},
+	}, tenant["audit"])
+
+	// a limit variable is decoded from json as a number
+	tenant = query(`{"query": "query($n: Int) { tenant(name: \"graphql-audit\") { audit(limit: $n) { action } } }", "variables": {"n": 1}}`)
+	equals(t, []interface{}{map[string]interface{}{"action": "delete-topic"}}, tenant["audit"])
+
+	tenant = query(`{"query": "{ tenant(name: \"graphql-audit\") { audit { action } } }"}`)
+	equals(t, 3, len(tenant["audit"].([]interface{})))
+}
+
+func TestSpoofedSubjectIgnored(t *testing.T) {
+	h, err := burnelltest.New(burnelltest.Options{})
+	errNil(t, err)
+	defer h.Close()
+	defer enableJWT(t)()
+	savedRoles := util.SuperRoles
+	defer func() { util.SuperRoles = savedRoles }()
+	util.SuperRoles = []string{"ops-superuser"}
+	token, err := util.JWTAuth.GenerateToken("ops-superuser", time.Hour, icrypto.SigMethod("RS256"))
+	errNil(t, err)
+
+	router := NewRouter()
+	req := httptest.NewRequest(http.MethodPut, "/admin/v2/namespaces/spoofed-audit/ns1/unload", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("injectedSubs", "spoofed-audit-admin-12345qbc")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	events := audit.Events("spoofed-audit", 1)
+	equals(t, 1, len(events))
+	equals(t, "unload-namespace", events[0].Action)
+	equals(t, "ops-superuser", events[0].Subject)
+}
+
+func TestMeterAPICalls(t *testing.T) {
+	router := mux.NewRouter()
+	router.Path("/admin/tenants/{tenant}/sla").Name("tenant sla").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	router.Path("/admin/v2/namespaces/{tenant}/{namespace}/retention").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	router.Use(MeterAPICalls)
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/admin/tenants/api-calls/sla", nil))
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/admin/v2/namespaces/api-calls/ns1/retention", nil))
+
+	today := time.Now().UTC().Format("2006-01-02")
+	usage, err := metrics.GetTenantAPIUsage("api-calls", today, today)
+	errNil(t, err)
+	equals(t, uint64(2), usage.Calls)
+	equals(t, uint64(1), usage.Endpoints["GET tenant sla"])
+	equals(t, uint64(1), usage.Endpoints["POST /admin/v2/namespaces/{tenant}/{namespace}/retention"])
+}
+
+func TestStatsRouter(t *testing.T) {
+	router := StatsRouter()
+	var match mux.RouteMatch
+	for _, path := range []string{"/metrics", "/tenantsusage", "/namespacesusage/ming-luo", "/pulsarmetrics", "/liveness", "/readiness"} {
+		assert(t, router.Match(httptest.NewRequest(http.MethodGet, path, nil), &match), path)
+	}
+	for _, path := range []string{"/k/tenant/ming-luo", "/admin/v2/tenants", "/function-logs/ming-luo/ns/fn"} {
+		assert(t, !router.Match(httptest.NewRequest(http.MethodGet, path, nil), &match), path)
+	}
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/readiness", nil))
+	equals(t, http.StatusOK, rr.Code)
+
+	// a stats only replica accepts the signed usage downloads
+	defer enableJWT(t)()
+	savedRoles := util.SuperRoles
+	defer func() { util.SuperRoles = savedRoles }()
+	util.SuperRoles = []string{"superuser"}
+	download := func(uri string) int {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, uri, nil))
+		return rr.Code
+	}
+	equals(t, http.StatusUnauthorized, download("/namespacesusage/ming-luo"))
+	equals(t, http.StatusUnauthorized, download("/tenantsusage"))
+	signed, err := SignURL("/namespacesusage/ming-luo", "ming-luo-12345qbc", time.Now().Add(time.Minute))
+	errNil(t, err)
+	assert(t, download(signed) != http.StatusUnauthorized, "signed namespaces usage")
+	signed, err = SignURL("/tenantsusage", "superuser", time.Now().Add(time.Minute))
+	errNil(t, err)
+	assert(t, download(signed) != http.StatusUnauthorized, "signed tenants usage")
+}
+
+func TestRoutesIntrospection(t *testing.T) {
+	routes := map[string]RouteInfo{}
+	for _, r := range ListRoutes(NewRouter()) {
+		routes[r.Pattern] = r
+	}
+	equals(t, RouteInfo{Name: "routes", Methods: []string{http.MethodGet}, Pattern: "/admin/routes", Auth: "superuser", RateLimit: DefaultPool}, routes["/admin/routes"])
+	equals(t, "tenant-jwt", routes["/namespacesusage/{tenant}"].Auth)
+	equals(t, MetricsPool, routes["/namespacesusage/{tenant}"].RateLimit)
+	equals(t, "scrape", routes["/pulsarmetrics"].Auth)
+	equals(t, LogsPool, routes["/function-logs/{tenant}/search"].RateLimit)
+	equals(t, "none", routes["/liveness"].Auth)
+	equals(t, "handler", routes["/ws/"].Auth)
+	equals(t, true, routes["/ws/"].Prefix)
+	equals(t, []string{"*"}, routes["/ws/"].Methods)
+
+	// the routes of the running mode
+	StatsRouter()
+	rr := httptest.NewRecorder()
+	RoutesHandler(rr, httptest.NewRequest(http.MethodGet, "/admin/routes", nil))
+	equals(t, http.StatusOK, rr.Code)
+	var stats []RouteInfo
+	errNil(t, json.Unmarshal(rr.Body.Bytes(), &stats))
+	for _, r := range stats {
+		assert(t, !strings.HasPrefix(r.Pattern, "/k/"), "stats router exposes "+r.Pattern)
+	}
+	assert(t, len(stats) < len(routes), "stats router is a subset")
+}
+
+type rejectHook struct{}
+
+func (rejectHook) Match(r *http.Request) bool { return r.Method == http.MethodDelete }
+func (rejectHook) TransformRequest(r, proxied *http.Request) error {
+	return fmt.Errorf("delete is disabled")
+}
+func (rejectHook) TransformResponse(r *http.Request, status int, header http.Header, body []byte) (int, []byte) {
+	return status, body
+}
+
+func TestProxyHooks(t *testing.T) {
+	var path, token, internal string
+	broker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		token = r.Header.Get("Authorization")
+		internal = r.Header.Get("X-Internal-Trace")
+		w.Write([]byte(`["ns1"]`))
+	}))
+	defer broker.Close()
+	savedURL := util.Config.BrokerProxyURL
+	defer func() { util.Config.BrokerProxyURL = savedURL }()
+	util.Config.BrokerProxyURL = broker.URL
+	defer SetProxyHooks(nil)
+
+	os.Setenv("TEST_BROKER_SUPERUSER_TOKEN", "broker-token")
+	defer os.Unsetenv("TEST_BROKER_SUPERUSER_TOKEN")
+	util.Config.ProxyRewrites = []util.ProxyRewriteRule{{
+		Path:                 "^/admin/v2/namespaces/(.*)$",
+		Methods:              []string{"get"},
+		PathRewrite:          "/admin/v2/namespaces-v2/$1",
+		SetRequestHeaders:    map[string]string{"Authorization": "Bearer ${TEST_BROKER_SUPERUSER_TOKEN}"},
+		RemoveRequestHeaders: []string{"X-Internal-Trace"},
+		SetResponseHeaders:   map[string]string{"X-Rewritten": "true"},
+	}}
+	defer func() { util.Config.ProxyRewrites = nil }()
+	errNil(t, InitProxyHooks())
+	RegisterProxyHook(rejectHook{})
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/v2/namespaces/ming-luo", nil)
+	req.Header.Set("X-Internal-Trace", "abc")
+	rr := httptest.NewRecorder()
+	DirectBrokerProxyHandler(rr, req)
+	equals(t, http.StatusOK, rr.Code)
+	equals(t, "/admin/v2/namespaces-v2/ming-luo", path)
+	equals(t, "Bearer broker-token", token)
+	equals(t, "", internal)
+	equals(t, "true", rr.Header().Get("X-Rewritten"))
+
+	// the rule only applies to GET
+	rr = httptest.NewRecorder()
+	DirectBrokerProxyHandler(rr, httptest.NewRequest(http.MethodPost, "/admin/v2/namespaces/ming-luo", nil))
+	equals(t, "/admin/v2/namespaces/ming-luo", path)
+	equals(t, "", rr.Header().Get("X-Rewritten"))
+
+	path = ""
+	rr = httptest.NewRecorder()
+	DirectBrokerProxyHandler(rr, httptest.NewRequest(http.MethodDelete, "/admin/v2/namespaces/ming-luo/ns1", nil))
+	equals(t, http.StatusForbidden, rr.Code)
+	equals(t, "", path)
+
+	util.Config.ProxyRewrites = []util.ProxyRewriteRule{{Path: "("}}
+	assert(t, InitProxyHooks() != nil, "invalid path regex")
+}
+
+func TestTenantSLA(t *testing.T) {
+	day := time.Date(2024, 5, 3, 10, 0, 0, 0, time.UTC)
+	metrics.RecordTenantRequest("sla-tenant", http.StatusOK, true, false, day)
+	metrics.RecordTenantRequest("sla-tenant", http.StatusOK, true, false, day.Add(10*time.Second))
+	metrics.RecordTenantRequest("sla-tenant", http.StatusBadGateway, true, true, day.Add(time.Minute))
+	metrics.RecordTenantRequest("sla-tenant", http.StatusInternalServerError, true, true, day.Add(time.Minute+time.Second))
+	metrics.RecordTenantRequest("sla-tenant", http.StatusNotFound, false, false, day.AddDate(0, 0, 1))
+	metrics.RecordTenantRequest("sla-tenant", http.StatusOK, true, false, day.AddDate(0, 1, 0))
+
+	report, err := metrics.GetTenantSLAReport("sla-tenant", "2024-05")
+	errNil(t, err)
+	equals(t, uint64(5), report.Requests)
+	equals(t, uint64(2), report.ServerErrors)
+	equals(t, float64(60), report.SuccessRate)
+	equals(t, uint64(2), report.ObservedMinutes)
+	equals(t, uint64(1), report.UnavailableMinutes)
+	equals(t, float64(50), report.Availability)
+	equals(t, 2, len(report.Days))
+	equals(t, "2024-05-03", report.Days[0].Date)
+
+	_, err = metrics.GetTenantSLAReport("sla-tenant", "May")
+	assert(t, err != nil, "invalid month")
+
+	// the middleware observes the upstream failure of a proxied call
+	savedURL := util.Config.BrokerProxyURL
+	defer func() { util.Config.BrokerProxyURL = savedURL }()
+	util.Config.BrokerProxyURL = "http://127.0.0.1:1"
+	req := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/admin/v2/namespaces/sla-proxy", nil), map[string]string{"tenant": "sla-proxy"})
+	TenantSLA(http.HandlerFunc(DirectBrokerProxyHandler)).ServeHTTP(httptest.NewRecorder(), req)
+	rr := httptest.NewRecorder()
+	req = mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/admin/tenants/sla-proxy/sla", nil), map[string]string{"tenant": "sla-proxy"})
+	TenantSLAHandler(rr, req)
+	equals(t, http.StatusOK, rr.Code)
+	var live metrics.TenantSLAReport
+	errNil(t, json.Unmarshal(rr.Body.Bytes(), &live))
+	equals(t, uint64(1), live.Requests)
+	equals(t, uint64(1), live.UnavailableMinutes)
+	equals(t, float64(0), live.Availability)
+}
+
+func TestUpstreamSelector(t *testing.T) {
+	a, _ := url.Parse("http://broker-a:8080")
+	b, _ := url.Parse("http://broker-b:8080")
+	now := time.Now()
+	s := NewUpstreamSelector([]*url.URL{a, b}, 2, time.Minute)
+
+	// upstreams without samples are tried first
+	equals(t, a, s.Select(now))
+	s.Observe("broker-a:8080", 50*time.Millisecond, false, now)
+	equals(t, b, s.Select(now))
+	s.Observe("broker-b:8080", 10*time.Millisecond, false, now)
+	equals(t, b, s.Select(now))
+
+	// the rolling average follows a slowing upstream
+	for i := 0; i < 5; i++ {
+		s.Observe("broker-b:8080", 200*time.Millisecond, false, now)
+	}
+	equals(t, a, s.Select(now))
+
+	// consecutive failures take an upstream out until the cooldown ends
+	s.Observe("broker-a:8080", time.Millisecond, true, now)
+	equals(t, a, s.Select(now))
+	s.Observe("broker-a:8080", time.Millisecond, true, now)
+	equals(t, b, s.Select(now))
+	stats := s.Stats()
+	equals(t, false, stats[0].Healthy)
+	equals(t, int64(6), stats[1].Samples)
+	equals(t, a, s.Select(now.Add(2*time.Minute)))
+
+	// unknown hosts are ignored
+	s.Observe("other:8080", time.Millisecond, true, now)
+	equals(t, 2, len(s.Stats()))
+
+	config := util.Config.AdminUpstreamURLs
+	defer func() { util.Config.AdminUpstreamURLs = config; InitAdminUpstreams() }()
+	util.Config.AdminUpstreamURLs = "http://broker-a:8080"
+	errNil(t, InitAdminUpstreams())
+	assert(t, AdminUpstreams == nil, "no selection with a single upstream")
+	util.Config.AdminUpstreamURLs = "http://broker-a:8080, http://broker-b:8080"
+	errNil(t, InitAdminUpstreams())
+	equals(t, 2, len(AdminUpstreams.Stats()))
+	util.Config.AdminUpstreamURLs = "broker-a, http://broker-b:8080"
+	assert(t, InitAdminUpstreams() != nil, "invalid upstream url")
+}
+
+func TestListPagination(t *testing.T) {
+	page, err := PaginateList([]byte(`["c","a","e","b","d"]`), 2, "")
+	errNil(t, err)
+	equals(t, 5, page.Total)
+	equals(t, `"a"`, string(page.Items[0]))
+	equals(t, `"b"`, string(page.Items[1]))
+	page, err = PaginateList([]byte(`["c","a","e","b","d"]`), 2, page.NextPageToken)
+	errNil(t, err)
+	equals(t, `"c"`, string(page.Items[0]))
+	token := page.NextPageToken
+	// the page resumes after the last item when the list changes
+	page, err = PaginateList([]byte(`["a","f","c1","e"]`), 2, token)
+	errNil(t, err)
+	equals(t, 2, len(page.Items))
+	equals(t, `"e"`, string(page.Items[0]))
+	equals(t, "", page.NextPageToken)
+	_, err = PaginateList([]byte(`["a"]`), 2, "%%")
+	equals(t, ErrInvalidPageToken, err)
+	_, err = PaginateList([]byte(`{"a":1}`), 2, "")
+	assert(t, err != nil, "not a list")
+
+	calls := 0
+	var query string
+	broker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		query = r.URL.RawQuery
+		w.Write([]byte(`["persistent://ming-luo/ns/t3","persistent://ming-luo/ns/t1","persistent://ming-luo/ns/t2"]`))
+	}))
+	defer broker.Close()
+	savedURL := util.Config.BrokerProxyURL
+	defer func() { util.Config.BrokerProxyURL = savedURL }()
+	util.Config.BrokerProxyURL = broker.URL
+
+	var resp PagedListResponse
+	rr := httptest.NewRecorder()
+	CachedProxyGETHandler(rr, httptest.NewRequest(http.MethodGet, "/admin/v2/persistent/ming-luo/ns?limit=2", nil))
+	equals(t, http.StatusOK, rr.Code)
+	errNil(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	equals(t, 3, resp.Total)
+	equals(t, 2, len(resp.Items))
+	equals(t, "", query)
+
+	rr = httptest.NewRecorder()
+	CachedProxyGETHandler(rr, httptest.NewRequest(http.MethodGet, "/admin/v2/persistent/ming-luo/ns?limit=2&pageToken="+resp.NextPageToken, nil))
+	errNil(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	equals(t, `"persistent://ming-luo/ns/t3"`, string(resp.Items[0]))
+	equals(t, 1, calls)
+
+	// a request without the pagination parameters is proxied as it is
+	rr = httptest.NewRecorder()
+	CachedProxyGETHandler(rr, httptest.NewRequest(http.MethodGet, "/admin/v2/persistent/ming-luo/ns", nil))
+	equals(t, 2, calls)
+	assert(t, strings.HasPrefix(rr.Body.String(), `["persistent://ming-luo/ns/t3"`), "unpaged")
+}
+
+func TestSelectFields(t *testing.T) {
+	data, err := ProjectFields([]byte(`{"name":"ming-luo","planType":"free","policy":{"numOfTopics":10,"numOfNamespaces":2}}`), "name, policy.numOfTopics")
+	errNil(t, err)
+	equals(t, `{"name":"ming-luo","policy":{"numOfTopics":10}}`, string(data))
+	data, err = ProjectFields([]byte(`{"tenant":"t","functions":[{"name":"f1","cpu":1},{"name":"f2","cpu":2}]}`), "functions.name,unknown")
+	errNil(t, err)
+	equals(t, `{"functions":[{"name":"f1"},{"name":"f2"}]}`, string(data))
+	// the whole field supersedes the nested fields
+	data, err = ProjectFields([]byte(`[{"policy":{"a":1,"b":2}}]`), "policy.a,policy")
+	errNil(t, err)
+	equals(t, `[{"policy":{"a":1,"b":2}}]`, string(data))
+	// numbers keep the precision
+	data, err = ProjectFields([]byte(`{"bytes":9007199254740993}`), "bytes")
+	errNil(t, err)
+	equals(t, `{"bytes":9007199254740993}`, string(data))
+
+	handler := SelectFields(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"name":"ming-luo","org":"datastax"}`))
+	}))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/k/tenant/ming-luo?fields=org", nil))
+	equals(t, http.StatusOK, rr.Code)
+	equals(t, `{"org":"datastax"}`, rr.Body.String())
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/k/tenant/ming-luo", nil))
+	equals(t, `{"name":"ming-luo","org":"datastax"}`, rr.Body.String())
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/missing?fields=org", nil))
+	equals(t, http.StatusNotFound, rr.Code)
+	equals(t, "not found\n", rr.Body.String())
+}
+
+func TestErrorEnvelope(t *testing.T) {
+	handler := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/invalid" {
+			util.ResponseErrorJSON(&util.APIError{Code: "plan_locked", Message: "plan is locked", Details: map[string]string{"tenant": "ming-luo"}}, w, http.StatusConflict)
+			return
+		}
+		util.ResponseErrorJSON(errors.New("missing subject"), w, http.StatusUnauthorized)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(util.RequestIDHeader, "req-1234")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	equals(t, http.StatusUnauthorized, rr.Code)
+	equals(t, "req-1234", rr.Header().Get(util.RequestIDHeader))
+	var resp util.ErrorResponse
+	errNil(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	equals(t, util.ErrorResponse{Code: "unauthorized", Message: "missing subject", RequestID: "req-1234", Error: "missing subject"}, resp)
+
+	// an invalid client request ID is replaced
+	req = httptest.NewRequest(http.MethodGet, "/invalid", nil)
+	req.Header.Set(util.RequestIDHeader, "bad id\n")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	resp = util.ErrorResponse{}
+	errNil(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	equals(t, "plan_locked", resp.Code)
+	equals(t, map[string]interface{}{"tenant": "ming-luo"}, resp.Details)
+	equals(t, 36, len(resp.RequestID))
+	equals(t, resp.RequestID, rr.Header().Get(util.RequestIDHeader))
+
+	equals(t, "too_many_requests", util.ErrorCode(http.StatusTooManyRequests))
+	equals(t, "unprocessable_entity", util.ErrorCode(http.StatusUnprocessableEntity))
+
+	// the invalid fields are the details of a validation error
+	rr = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/k/tenant/ming-luo", strings.NewReader(`{"planType": "gold"}`))
+	req = mux.SetURLVars(req, map[string]string{"tenant": "ming-luo"})
+	ValidateBody(schema.TenantPlan, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rr, req)
+	equals(t, http.StatusBadRequest, rr.Code)
+	var vResp ValidationErrorResponse
+	errNil(t, json.Unmarshal(rr.Body.Bytes(), &vResp))
+	equals(t, "validation_failed", vResp.Code)
+	assert(t, len(vResp.Fields) > 0, "invalid fields")
+	equals(t, len(vResp.Fields), len(vResp.Details.([]interface{})))
+}
+
+func TestPriorityScheduler(t *testing.T) {
+	s := NewPriorityScheduler(1, 1, map[string]time.Duration{
+		SystemPriority:  time.Second,
+		ControlPriority: time.Second,
+		HeavyPriority:   time.Second,
+	})
+	queued := func(class string) int {
+		for _, c := range s.Stats().Classes {
+			if c.Class == class {
+				return c.Queued
+			}
+		}
+		return -1
+	}
+	waitQueued := func(class string) {
+		for i := 0; i < 100 && queued(class) == 0; i++ {
+			time.Sleep(5 * time.Millisecond)
+		}
+		equals(t, 1, queued(class))
+	}
+
+	release, ok := s.Acquire(context.Background(), ControlPriority)
+	assert(t, ok, "admitted under the concurrency")
+
+	admitted := make(chan string, 2)
+	acquire := func(class string) {
+		if release, ok := s.Acquire(context.Background(), class); ok {
+			admitted <- class
+			release()
+		}
+	}
+	go acquire(HeavyPriority)
+	waitQueued(HeavyPriority)
+	go acquire(ControlPriority)
+	waitQueued(ControlPriority)
+
+	// the class queue is full
+	_, ok = s.Acquire(context.Background(), HeavyPriority)
+	assert(t, !ok, "rejected over the queue depth")
+
+	// the control call queued later is admitted before the heavy query
+	release()
+	equals(t, ControlPriority, <-admitted)
+	equals(t, HeavyPriority, <-admitted)
+
+	stats := s.Stats()
+	equals(t, 0, stats.InFlight)
+	equals(t, uint64(2), stats.Classes[1].Admitted)
+	equals(t, uint64(1), stats.Classes[2].Admitted)
+	equals(t, uint64(1), stats.Classes[2].Rejected)
+
+	// a waiter times out
+	errNil(t, s.SetConfig(PriorityConfig{Concurrency: 1, Classes: []PriorityClassConfig{{Class: HeavyPriority, QueueDepth: 1, TimeoutMs: 20}}}))
+	release, ok = s.Acquire(context.Background(), SystemPriority)
+	assert(t, ok, "admitted under the concurrency")
+	_, ok = s.Acquire(context.Background(), HeavyPriority)
+	assert(t, !ok, "timed out in the queue")
+	equals(t, uint64(1), s.Stats().Classes[2].TimedOut)
+	equals(t, 0, queued(HeavyPriority))
+	release()
+
+	assert(t, s.SetConfig(PriorityConfig{Concurrency: -1}) != nil, "negative concurrency")
+	assert(t, s.SetConfig(PriorityConfig{Concurrency: 1, Classes: []PriorityClassConfig{{Class: "batch", TimeoutMs: 10}}}) != nil, "unknown class")
+	assert(t, s.SetConfig(PriorityConfig{Concurrency: 1, Classes: []PriorityClassConfig{{Class: HeavyPriority}}}) != nil, "zero timeout")
+
+	// the middleware replies 503 at saturation
+	handler := Prioritize(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/admin/v2/tenants", nil))
+	equals(t, http.StatusOK, rr.Code)
+
+	errNil(t, Priority.SetConfig(PriorityConfig{Concurrency: 1, Classes: []PriorityClassConfig{{Class: ControlPriority, QueueDepth: 0, TimeoutMs: 5000}}}))
+	defer Priority.SetConfig(PriorityConfig{Concurrency: 0, Classes: []PriorityClassConfig{{Class: ControlPriority, QueueDepth: 100, TimeoutMs: 5000}}})
+	release, ok = Priority.Acquire(context.Background(), ControlPriority)
+	assert(t, ok, "admitted under the concurrency")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/admin/v2/tenants", nil))
+	equals(t, http.StatusServiceUnavailable, rr.Code)
+	equals(t, "1", rr.Header().Get("Retry-After"))
+	var resp util.ErrorResponse
+	errNil(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	equals(t, util.ReasonServerSaturated, resp.Reason)
+	release()
+}
+
+func TestPriorityTokenVerifiedOnce(t *testing.T) {
+	defer enableJWT(t)()
+	savedRoles := util.SuperRoles
+	defer func() { util.SuperRoles = savedRoles }()
+	util.SuperRoles = []string{"ops-superuser"}
+	errNil(t, Priority.SetConfig(PriorityConfig{Concurrency: 1}))
+	defer Priority.SetConfig(PriorityConfig{Concurrency: 0})
+
+	// the handler swaps the verification keys, so the auth only succeeds with the keys of the token
+	// if the verification at the priority is reused, or if there was none
+	signer := util.JWTAuth
+	otherKeys, err := icrypto.NewRSAKeyPair()
+	errNil(t, err)
+	other := icrypto.NewJWTKeys(otherKeys, icrypto.DefaultAllowedAlgs)
+	send := func(token string, verifyWith *icrypto.JWTKeys) int {
+		util.JWTAuth = signer
+		handler := Prioritize(LimitRate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			util.JWTAuth = verifyWith
+			AuthVerifyJWT(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(w, r)
+		})))
+		req := httptest.NewRequest(http.MethodGet, "/admin/v2/tenants", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		util.JWTAuth = signer
+		return rr.Code
+	}
+
+	// a superuser token is verified at the priority and the result is reused by the auth
+	superToken, err := signer.GenerateToken("ops-superuser", time.Hour, icrypto.SigMethod("RS256"))
+	errNil(t, err)
+	equals(t, http.StatusOK, send(superToken, other))
+
+	// a tenant token is not verified at the priority, only by the auth
+	tenantToken, err := other.GenerateToken("ming-luo-client-12345", time.Hour, icrypto.SigMethod("RS256"))
+	errNil(t, err)
+	equals(t, http.StatusOK, send(tenantToken, other))
+
+	// a malformed token is rejected by the auth
+	equals(t, http.StatusUnauthorized, send("not-a-jwt", signer))
+}
+
+func TestErrorReasons(t *testing.T) {
+	assert(t, util.RegisterReason(util.Reason{Code: "plan-locked", Message: "locked"}) != nil, "lower case reason code")
+	assert(t, util.RegisterReason(util.Reason{Code: "PLAN_LOCKED"}) != nil, "reason code without a message")
+	errNil(t, util.RegisterReason(util.Reason{Code: "PLAN_LOCKED", Status: http.StatusConflict, Message: "The plan is locked."}))
+
+	assert(t, util.SetReasonMessages(map[string]map[string]string{"NO_SUCH_REASON": {"fr": "inconnu"}}) != nil, "unknown reason code")
+	errNil(t, util.SetReasonMessages(map[string]map[string]string{"PLAN_LOCKED": {"pt": "O plano está bloqueado.", "fr": "Le plan est verrouillé."}}))
+	equals(t, "Le plan est verrouillé.", util.ReasonMessage("PLAN_LOCKED", "fr"))
+	equals(t, "O plano está bloqueado.", util.ReasonMessage("PLAN_LOCKED", "pt-BR"))
+	equals(t, "The plan is locked.", util.ReasonMessage("PLAN_LOCKED", "de"))
+	equals(t, "", util.ReasonMessage("NO_SUCH_REASON", "fr"))
+
+	// the reason code is in the envelope
+	rr := httptest.NewRecorder()
+	util.ResponseErrorJSON(util.NewReasonError(util.ReasonTenantSuspended, "tenant ming-luo is suspended"), rr, http.StatusForbidden)
+	equals(t, http.StatusForbidden, rr.Code)
+	var resp util.ErrorResponse
+	errNil(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	equals(t, "forbidden", resp.Code)
+	equals(t, util.ReasonTenantSuspended, resp.Reason)
+	equals(t, "tenant ming-luo is suspended", resp.Message)
+
+	// the catalog is localized by the Accept-Language header
+	req := httptest.NewRequest(http.MethodGet, "/reasons", nil)
+	req.Header.Set("Accept-Language", "pt-BR,pt;q=0.9,en;q=0.8")
+	rr = httptest.NewRecorder()
+	ReasonsHandler(rr, req)
+	equals(t, http.StatusOK, rr.Code)
+	var catalog []util.Reason
+	errNil(t, json.Unmarshal(rr.Body.Bytes(), &catalog))
+	found := false
+	for _, r := range catalog {
+		if r.Code == "PLAN_LOCKED" {
+			found = true
+			equals(t, "O plano está bloqueado.", r.Message)
+			equals(t, 0, len(r.Messages))
+		}
+	}
+	assert(t, found, "registered reason in the catalog")
+
+	// all the localized messages without a language
+	rr = httptest.NewRecorder()
+	ReasonsHandler(rr, httptest.NewRequest(http.MethodGet, "/reasons", nil))
+	catalog = nil
+	errNil(t, json.Unmarshal(rr.Body.Bytes(), &catalog))
+	equals(t, len(util.Reasons()), len(catalog))
+	for _, r := range catalog {
+		if r.Code == "PLAN_LOCKED" {
+			equals(t, "The plan is locked.", r.Message)
+			equals(t, 2, len(r.Messages))
+		}
+	}
+
+	// the schema validation error has the reason code
+	rr = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/k/tenant/ming-luo", strings.NewReader(`{"planType": "gold"}`))
+	req = mux.SetURLVars(req, map[string]string{"tenant": "ming-luo"})
+	ValidateBody(schema.TenantPlan, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rr, req)
+	var vResp ValidationErrorResponse
+	errNil(t, json.Unmarshal(rr.Body.Bytes(), &vResp))
+	equals(t, util.ReasonValidationFailed, vResp.Reason)
+}
+
+func TestNegotiateYAML(t *testing.T) {
+	handler := NegotiateYAML(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		if len(body) == 0 {
+			body = []byte(`{"name":"ming-luo","org":"datastax"}`)
+		}
+		w.Write(body)
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/k/tenant/ming-luo", strings.NewReader("planType: free\npolicy:\n  numOfTopics: 10\n"))
+	req.Header.Set("Content-Type", "application/x-yaml")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	equals(t, http.StatusOK, rr.Code)
+	equals(t, `{"planType":"free","policy":{"numOfTopics":10}}`, rr.Body.String())
+
+	req = httptest.NewRequest(http.MethodGet, "/k/tenant/ming-luo", nil)
+	req.Header.Set("Accept", "application/yaml")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	equals(t, YAMLContentType, rr.Header().Get("Content-Type"))
+	equals(t, "name: ming-luo\norg: datastax\n", rr.Body.String())
+
+	// JSON is preferred by the quality
+	req = httptest.NewRequest(http.MethodGet, "/k/tenant/ming-luo", nil)
+	req.Header.Set("Accept", "application/yaml;q=0.5, application/json")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	equals(t, "application/json", rr.Header().Get("Content-Type"))
+
+	req = httptest.NewRequest(http.MethodPost, "/k/tenant/ming-luo", strings.NewReader("planType: [free"))
+	req.Header.Set("Content-Type", "application/yaml")
+	req.Header.Set("Accept", "application/yaml")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	equals(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestSignedURL(t *testing.T) {
+	body := `{"path":"/function-logs/ming-luo/ns/func1?bytes=2048","ttlSeconds":60}`
+	req := httptest.NewRequest(http.MethodPost, "/k/tenant/ming-luo/signed-url", strings.NewReader(body))
+	req.Header.Set("injectedSubs", "ming-luo-12345qbc")
+	rr := httptest.NewRecorder()
+	SignedURLHandler(rr, mux.SetURLVars(req, map[string]string{"tenant": "ming-luo"}))
+	equals(t, http.StatusOK, rr.Code)
+	var signed SignedURLResponse
+	errNil(t, json.Unmarshal(rr.Body.Bytes(), &signed))
+	assert(t, strings.HasPrefix(signed.URL, "/function-logs/ming-luo/ns/func1?"), "signed path")
+
+	// another tenant's download route can't be signed
+	req = httptest.NewRequest(http.MethodPost, "/k/tenant/ming-luo/signed-url", strings.NewReader(`{"path":"/namespacesusage/other"}`))
+	req.Header.Set("injectedSubs", "ming-luo-12345qbc")
+	rr = httptest.NewRecorder()
+	SignedURLHandler(rr, mux.SetURLVars(req, map[string]string{"tenant": "ming-luo"}))
+	equals(t, http.StatusForbidden, rr.Code)
+
+	var subject string
+	handler := SignedURLAuth(AuthVerifyTenantJWT, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		subject = r.Header.Get("injectedSubs")
+	}))
+	download := func(uri, tenant string) int {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, mux.SetURLVars(httptest.NewRequest(http.MethodGet, uri, nil), map[string]string{"tenant": tenant}))
+		return rr.Code
+	}
+	equals(t, http.StatusOK, download(signed.URL, "ming-luo"))
+	equals(t, "ming-luo-12345qbc", subject)
+	equals(t, http.StatusUnauthorized, download(strings.Replace(signed.URL, "bytes=2048", "bytes=4096", 1), "ming-luo"))
+	equals(t, http.StatusUnauthorized, download(strings.Replace(signed.URL, "func1", "func2", 1), "ming-luo"))
+
+	expired, err := SignURL("/namespacesusage/ming-luo", "ming-luo-12345qbc", time.Now().Add(-time.Second))
+	errNil(t, err)
+	equals(t, http.StatusUnauthorized, download(expired, "ming-luo"))
+	valid, err := SignURL("/namespacesusage/ming-luo", "ming-luo-12345qbc", time.Now().Add(time.Minute))
+	errNil(t, err)
+	equals(t, http.StatusOK, download(valid, "ming-luo"))
+	// the signing subject must match the route tenant
+	other, err := SignURL("/namespacesusage/other", "ming-luo-12345qbc", time.Now().Add(time.Minute))
+	errNil(t, err)
+	equals(t, http.StatusUnauthorized, download(other, "other"))
+}
+
+func TestBuildClusterHealth(t *testing.T) {
+	registered := []string{"broker-0.broker.pulsar.svc.cluster.local:8080", "broker-1.broker.pulsar.svc.cluster.local:8080"}
+	loads := []policy.BrokerStats{
+		{Broker: registered[0], Data: map[string]interface{}{
+			"cpu":    map[string]interface{}{"usage": 50.0, "limit": 200.0},
+			"memory": map[string]interface{}{"usage": 1024.0, "limit": 2048.0},
+		}},
+		{Broker: registered[1]},
+	}
+	brokerMetrics := map[string]*metrics.BrokerMetrics{
+		"broker-0": {Broker: "broker-0", StorageSize: 100, UnackedMessages: 5},
+		"broker-2": {Broker: "broker-2", StorageSize: 10, UnackedMessages: 1},
+	}
+
+	health := BuildClusterHealth(registered, loads, brokerMetrics)
+	equals(t, ClusterDegraded, health.Status)
+	equals(t, 3, health.Brokers)
+	equals(t, 1, health.BrokersUp)
+	equals(t, float64(110), health.StorageSize)
+	equals(t, float64(6), health.UnackedMessages)
+
+	equals(t, BrokerUp, health.BrokerHealth[0].Status)
+	equals(t, float64(25), health.BrokerHealth[0].Load.CPU)
+	equals(t, float64(50), health.BrokerHealth[0].Load.Memory)
+	equals(t, float64(100), health.BrokerHealth[0].Metrics.StorageSize)
+	equals(t, BrokerUnreachable, health.BrokerHealth[1].Status)
+	assert(t, health.BrokerHealth[1].Metrics == nil, "no metrics")
+	// metrics of an unregistered broker
+	equals(t, "broker-2", health.BrokerHealth[2].Broker)
+	equals(t, BrokerDown, health.BrokerHealth[2].Status)
+
+	equals(t, ClusterDown, BuildClusterHealth(registered[1:], loads[1:], nil).Status)
+	equals(t, ClusterHealthy, BuildClusterHealth(registered[:1], loads[:1], nil).Status)
+}
+
+func fastPathTestRouter(dummyRoutes int) *mux.Router {
+	router := mux.NewRouter().StrictSlash(true)
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(mux.Vars(r)["tenant"]))
+	}
+	for i := 0; i < dummyRoutes; i++ {
+		router.Path(fmt.Sprintf("/admin/v2/dummy%d/{tenant}/{namespace}", i)).Methods(http.MethodGet).HandlerFunc(handler)
+	}
+	router.PathPrefix("/shadow/").Methods(http.MethodGet).Name("shadow prefix").HandlerFunc(handler)
+	router.Path("/shadow/metrics").Methods(http.MethodGet).Name("metrics").HandlerFunc(handler)
+	router.Path("/metrics").Methods(http.MethodGet).Name("metrics").HandlerFunc(handler)
+	router.Path("/pulsarmetrics/{tenant}").Methods(http.MethodGet).Name("pulsar metrics").HandlerFunc(handler)
+	router.Path("/namespacesusage/{tenant:[a-z]+}").Methods(http.MethodGet).Name("tenant namespaces usage").HandlerFunc(handler)
+	router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Middleware", "applied")
+			next.ServeHTTP(w, r)
+		})
+	})
+	return router
+}
+
+func TestFastPath(t *testing.T) {
+	fp := NewFastPath(fastPathTestRouter(1), HotRoutes)
+	// the shadowed route and the variable pattern are left to the router
+	equals(t, []string{"/metrics", "/pulsarmetrics/{tenant}"}, fp.Templates())
+
+	rr := httptest.NewRecorder()
+	fp.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/pulsarmetrics/ming-luo", nil))
+	equals(t, http.StatusOK, rr.Code)
+	equals(t, "ming-luo", rr.Body.String())
+	equals(t, "applied", rr.Header().Get("X-Middleware"))
+
+	for path, code := range map[string]int{
+		"/pulsarmetrics/ming-luo/": http.StatusMovedPermanently,
+		"/pulsarmetrics//":         http.StatusMovedPermanently,
+		"/pulsarmetrics/..":        http.StatusMovedPermanently,
+		"/pulsarmetrics/a/b":       http.StatusNotFound,
+		"/namespacesusage/ming":    http.StatusOK,
+		"/shadow/metrics":          http.StatusOK,
+		"/admin/v2/dummy0/a/b":     http.StatusOK,
+	} {
+		rr = httptest.NewRecorder()
+		fp.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		equals(t, code, rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	fp.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/metrics", nil))
+	equals(t, http.StatusMethodNotAllowed, rr.Code)
+}
+
+func benchmarkRouting(b *testing.B, handler http.Handler) {
+	req := httptest.NewRequest(http.MethodGet, "/pulsarmetrics/ming-luo", nil)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+}
+
+func BenchmarkMuxRouting(b *testing.B) {
+	benchmarkRouting(b, fastPathTestRouter(150))
+}
+
+func BenchmarkFastPathRouting(b *testing.B) {
+	benchmarkRouting(b, NewFastPath(fastPathTestRouter(150), HotRoutes))
+}
+
+func TestStreamJSON(t *testing.T) {
+	usages := []metrics.Usage{{Name: "ming-luo", TotalBytesIn: 10}, {Name: "victor<>", Producers: 2}}
+	elem := func(i int) interface{} { return usages[i] }
+	expected, err := json.Marshal(usages)
+	errNil(t, err)
+
+	rr := httptest.NewRecorder()
+	errNil(t, StreamJSONArray(rr, httptest.NewRequest(http.MethodGet, "/tenantsusage", nil), http.StatusOK, len(usages), elem))
+	equals(t, string(expected), rr.Body.String())
+
+	rr = httptest.NewRecorder()
+	errNil(t, StreamJSONArray(rr, httptest.NewRequest(http.MethodGet, "/tenantsusage", nil), http.StatusOK, 0, elem))
+	equals(t, "[]", rr.Body.String())
+
+	req := httptest.NewRequest(http.MethodGet, "/tenantsusage", nil)
+	req.Header.Set("Accept", NDJSONContentType)
+	rr = httptest.NewRecorder()
+	errNil(t, StreamJSONArray(rr, req, http.StatusOK, len(usages), elem))
+	equals(t, NDJSONContentType, rr.Header().Get("Content-Type"))
+	lines := strings.Split(strings.TrimSuffix(rr.Body.String(), "\n"), "\n")
+	equals(t, 2, len(lines))
+	var usage metrics.Usage
+	errNil(t, json.Unmarshal([]byte(lines[1]), &usage))
+	equals(t, usages[1].Name, usage.Name)
+
+	topics := map[string][]string{"ming-luo/ns2": {"t1"}, "ming-luo/ns1": {"t2", "t3"}}
+	expected, err = json.Marshal(topics)
+	errNil(t, err)
+	rr = httptest.NewRecorder()
+	errNil(t, StreamJSONObject(rr, httptest.NewRequest(http.MethodGet, "/admin/v2/topics/ming-luo", nil), http.StatusOK, topics, "namespace", "topics"))
+	equals(t, string(expected), rr.Body.String())
+
+	rr = httptest.NewRecorder()
+	errNil(t, StreamJSONObject(rr, httptest.NewRequest(http.MethodGet, "/admin/v2/topics/ming-luo?format=ndjson", nil), http.StatusOK, topics, "namespace", "topics"))
+	equals(t, "{\"namespace\":\"ming-luo/ns1\",\"topics\":[\"t2\",\"t3\"]}\n{\"namespace\":\"ming-luo/ns2\",\"topics\":[\"t1\"]}\n", rr.Body.String())
+
+	// the partial response projects every line
+	handler := SelectFields(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		StreamJSONArray(w, r, http.StatusOK, len(usages), elem)
+	}))
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/tenantsusage?format=ndjson&fields=name", nil))
+	equals(t, "{\"name\":\"ming-luo\"}\n{\"name\":\"victor\\u003c\\u003e\"}\n", rr.Body.String())
+}
+
+func TestFederatedMetricsConditionalGet(t *testing.T) {
+	dat, err := ioutil.ReadFile("./federated-prom.dat")
+	errNil(t, err)
+	metrics.SetCache("conditional-tenant", dat)
+
+	get := func(method, since string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/pulsarmetrics/conditional-tenant", nil)
+		if since != "" {
+			req.Header.Set("If-Modified-Since", since)
+		}
+		req = mux.SetURLVars(req, map[string]string{"tenant": "conditional-tenant"})
+		rr := httptest.NewRecorder()
+		PulsarFederatedDebugPrometheusHandler(rr, req)
+		return rr
+	}
+
+	rr := get(http.MethodGet, "")
+	equals(t, http.StatusOK, rr.Code)
+	lastModified := rr.Header().Get("Last-Modified")
+	_, err = http.ParseTime(lastModified)
+	errNil(t, err)
+	equals(t, strconv.Itoa(rr.Body.Len()), rr.Header().Get("Content-Length"))
+	length := rr.Header().Get("Content-Length")
+
+	rr = get(http.MethodHead, "")
+	equals(t, http.StatusOK, rr.Code)
+	equals(t, length, rr.Header().Get("Content-Length"))
+	equals(t, 0, rr.Body.Len())
+
+	// unchanged since the scrape
+	rr = get(http.MethodGet, lastModified)
+	equals(t, http.StatusNotModified, rr.Code)
+	equals(t, 0, rr.Body.Len())
+	rr = get(http.MethodHead, lastModified)
+	equals(t, http.StatusNotModified, rr.Code)
+
+	// scraped after the time of the client copy
+	rr = get(http.MethodGet, time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat))
+	equals(t, http.StatusOK, rr.Code)
+	assert(t, rr.Body.Len() > 0, "metrics body")
+	rr = get(http.MethodGet, "not a date")
+	equals(t, http.StatusOK, rr.Code)
+}
+
+func TestMemoryWatermarkShedding(t *testing.T) {
+	m := util.NewMemoryWatermark(0, 100, 80)
+	assert(t, m.Enabled(), "heap limit")
+	assert(t, !m.Update(1<<30, 99<<20), "no rss limit")
+	assert(t, m.Update(1<<30, 100<<20), "at the heap limit")
+	// shedding until under the recovery percentage of the limit
+	assert(t, m.Update(0, 90<<20), "over the recovery watermark")
+	assert(t, !m.Update(0, 79<<20), "recovered")
+	assert(t, !util.NewMemoryWatermark(0, 0, 80).Enabled(), "disabled by default")
+
+	saved := util.Memory
+	defer func() { util.Memory = saved }()
+	util.Memory = m
+	router := mux.NewRouter()
+	router.Path("/function-logs/{tenant}/search").Name("function-logs").Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	router.Path("/admin/v2/tenants").Name("tenants").Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	router.Use(ShedOnMemoryPressure)
+
+	m.Update(0, 200<<20)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/function-logs/ming/search", nil))
+	equals(t, http.StatusServiceUnavailable, rr.Code)
+	var resp util.ErrorResponse
+	errNil(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	equals(t, util.ReasonMemoryPressure, resp.Reason)
+	assert(t, rr.Header().Get("Retry-After") != "", "retry after")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/admin/v2/tenants", nil))
+	equals(t, http.StatusOK, rr.Code)
+	equals(t, uint64(1), m.Status().ShedRequests)
+
+	m.Update(0, 10<<20)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/function-logs/ming/search", nil))
+	equals(t, http.StatusOK, rr.Code)
+}
+
+func TestFunctionLogLevelParameter(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/function-logs/ming-luo/search?q=error&level=loud", nil)
+	req = mux.SetURLVars(req, map[string]string{"tenant": "ming-luo"})
+	req.Header.Set("injectedSubs", "ming-luo-client-12345qbc")
+	rr := httptest.NewRecorder()
+	FunctionLogSearchHandler(rr, req)
+	equals(t, http.StatusBadRequest, rr.Code)
+
+	req = httptest.NewRequest(http.MethodGet, "/function-logs/ming-luo/ns/fn?level=loud", nil)
+	req = mux.SetURLVars(req, map[string]string{"tenant": "ming-luo", "namespace": "ns", "function": "fn"})
+	rr = httptest.NewRecorder()
+	FunctionLogsHandler(rr, req)
+	equals(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestReadOnlyReplica(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	router := mux.NewRouter()
+	router.Path("/subject/{sub}").Name("token server").Handler(ok)
+	router.Path("/grafana/query").Name("grafana datasource query").Handler(ok)
+	router.PathPrefix("/ws/").Name("websocket proxy proxy").Handler(ok)
+	router.PathPrefix("/admin/v2/tenants").Handler(ok)
+	router.Use(ReadOnly)
+
+	for _, c := range []struct {
+		method, path string
+		status       int
+	}{
+		{http.MethodGet, "/admin/v2/tenants/ming", http.StatusOK},
+		{http.MethodHead, "/admin/v2/tenants/ming", http.StatusOK},
+		{http.MethodPut, "/admin/v2/tenants/ming", http.StatusMethodNotAllowed},
+		{http.MethodDelete, "/admin/v2/tenants/ming", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/subject/ming-admin", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/grafana/query", http.StatusOK},
+		{http.MethodGet, "/ws/v2/consumer/persistent/ming/ns/topic/sub", http.StatusOK},
+		{http.MethodGet, "/ws/v2/producer/persistent/ming/ns/topic", http.StatusMethodNotAllowed},
+	} {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(c.method, c.path, nil))
+		equals(t, c.status, rr.Code)
+		if c.status == http.StatusMethodNotAllowed {
+			var resp util.ErrorResponse
+			errNil(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+			equals(t, util.ReasonReadOnly, resp.Reason)
+			equals(t, "GET, HEAD, OPTIONS", rr.Header().Get("Allow"))
+		}
+	}
+}
+
+func TestRequestDeadline(t *testing.T) {
+	broker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer broker.Close()
+	savedURL := util.Config.BrokerProxyURL
+	defer func() { util.Config.BrokerProxyURL = savedURL }()
+	util.Config.BrokerProxyURL = broker.URL
+
+	router := mux.NewRouter()
+	router.Path("/admin/v2/tenants/{tenant}").Handler(http.HandlerFunc(DirectBrokerProxyHandler))
+	router.Use(RequestDeadline)
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/v2/tenants/ming", nil)
+	req.Header.Set(RequestTimeoutHeader, "50ms")
+	rr := httptest.NewRecorder()
+	start := time.Now()
+	router.ServeHTTP(rr, req)
+	assert(t, time.Since(start) < 2*time.Second, "the upstream call is canceled at the deadline")
+	equals(t, http.StatusGatewayTimeout, rr.Code)
+	var resp util.ErrorResponse
+	errNil(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	equals(t, util.ReasonDeadlineExceeded, resp.Reason)
+
+	req = httptest.NewRequest(http.MethodGet, "/admin/v2/tenants/ming", nil)
+	req.Header.Set(RequestTimeoutHeader, "soon")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	equals(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestTenantPlanWatchHandler(t *testing.T) {
+	h, err := burnelltest.New(burnelltest.Options{Tenants: []policy.TenantPlan{{Name: "watch-route", PlanType: policy.FreeTier}}})
+	errNil(t, err)
+	defer h.Close()
+	apply := func(plan policy.TenantPlan) {
+		data, _ := json.Marshal(plan)
+		policy.TenantManager.ApplyTenantRecord(data)
+	}
+	apply(policy.TenantPlan{Name: "watch-route", PlanType: policy.FreeTier})
+
+	// the long poll replies 204 without a change before the timeout
+	resp, err := http.Get(h.URL + "/admin/tenantsplan/watch-route/watch?longpoll=true&timeout=50ms")
+	errNil(t, err)
+	resp.Body.Close()
+	equals(t, http.StatusNoContent, resp.StatusCode)
+
+	// the long poll outlives the server read and write timeouts
+	shortCfg, err := util.LoadHTTPServerConfig(&util.Configuration{HTTPReadTimeout: "100ms", HTTPWriteTimeout: "100ms"})
+	errNil(t, err)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	errNil(t, err)
+	server := util.NewHTTPServer(shortCfg, h.Config.Handler)
+	go server.Serve(l)
+	defer server.Close()
+	resp, err = http.Get("http://" + l.Addr().String() + "/admin/tenantsplan/watch-route/watch?longpoll=true&timeout=400ms")
+	errNil(t, err)
+	resp.Body.Close()
+	equals(t, http.StatusNoContent, resp.StatusCode)
+
+	// the long poll replies the first event, the plan is updated until the poll is subscribed
+	stop := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(20 * time.Millisecond):
+				apply(policy.TenantPlan{Name: "watch-route", PlanType: policy.FreeTier})
+			}
+		}
+	}()
+	resp, err = http.Get(h.URL + "/admin/tenantsplan/watch-route/watch?longpoll=true&timeout=5s")
+	close(stop)
+	errNil(t, err)
+	var event policy.TenantPlanEvent
+	errNil(t, json.NewDecoder(resp.Body).Decode(&event))
+	resp.Body.Close()
+	equals(t, http.StatusOK, resp.StatusCode)
+	equals(t, policy.PlanUpdated, event.Type)
+	equals(t, "watch-route", event.Tenant)
+
+	// the firehose streams the events changing the fields, it is subscribed once the headers are flushed
+	resp, err = http.Get(h.URL + "/admin/tenantsplan/watch?changes=tenantStatus&timeout=5s")
+	errNil(t, err)
+	defer resp.Body.Close()
+	equals(t, http.StatusOK, resp.StatusCode)
+	equals(t, "application/x-ndjson", resp.Header.Get("Content-Type"))
+	apply(policy.TenantPlan{Name: "watch-route", PlanType: policy.FreeTier, Metadata: map[string]string{"team": "a"}})
+	apply(policy.TenantPlan{Name: "watch-route", PlanType: policy.FreeTier, Metadata: map[string]string{"team": "a"}, TenantStatus: policy.Suspended})
+	apply(policy.TenantPlan{Name: "watch-route", PlanType: policy.FreeTier, TenantStatus: policy.Deleted})
+	reader := bufio.NewReader(resp.Body)
+	for _, eventType := range []string{policy.PlanUpdated, policy.PlanDeleted} {
+		line, err := reader.ReadBytes('\n')
+		errNil(t, err)
+		event = policy.TenantPlanEvent{}
+		errNil(t, json.Unmarshal(line, &event))
+		equals(t, eventType, event.Type)
+		equals(t, "watch-route", event.Tenant)
+		assert(t, util.StrContains(event.Changes, "tenantStatus"), "only the status changes are streamed")
+	}
+}
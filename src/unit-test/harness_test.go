@@ -0,0 +1,139 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package tests
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	. "github.com/datastax/burnell/src/burnelltest"
+	"github.com/datastax/burnell/src/metrics"
+	"github.com/datastax/burnell/src/policy"
+)
+
+func TestHarness(t *testing.T) {
+	dat, err := ioutil.ReadFile("./tenantusage.dat")
+	errNil(t, err)
+	h, err := New(Options{
+		Tenants:          []policy.TenantPlan{{Name: "ming-luo", PlanType: policy.StarterTier, Org: "acme"}},
+		FederatedMetrics: dat,
+	})
+	errNil(t, err)
+	defer h.Close()
+
+	resp, err := http.Get(h.URL + "/k/tenant/ming-luo")
+	errNil(t, err)
+	var plan policy.TenantPlan
+	errNil(t, json.NewDecoder(resp.Body).Decode(&plan))
+	resp.Body.Close()
+	equals(t, http.StatusOK, resp.StatusCode)
+	equals(t, policy.StarterTier, plan.PlanType)
+
+	resp, err = http.Post(h.URL+"/k/tenant/harness-tenant", "application/json", strings.NewReader(`{"planType": "free", "org": "acme"}`))
+	errNil(t, err)
+	resp.Body.Close()
+	equals(t, http.StatusOK, resp.StatusCode)
+	records := h.Store.Records()
+	equals(t, 2, len(records))
+	equals(t, "harness-tenant", records[1].Name)
+	assert(t, len(h.Admin.Requests()) > 0, "tenant plan requests query the fake admin API")
+
+	resp, err = http.Get(h.URL + "/tenantsusage")
+	errNil(t, err)
+	var usages []metrics.Usage
+	errNil(t, json.NewDecoder(resp.Body).Decode(&usages))
+	resp.Body.Close()
+	found := false
+	for _, u := range usages {
+		if u.Name == "ming-luo" {
+			found = true
+			equals(t, uint64(11360), u.TotalMessagesIn)
+		}
+	}
+	assert(t, found, "tenant usage from the federated metrics fixture")
+
+	h.AddFunction("ming-luo", "ns1", "harness-fn", "started\nprocessed 1 message\n")
+	resp, err = http.Get(h.URL + "/function-logs/ming-luo/ns1/harness-fn")
+	errNil(t, err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	equals(t, http.StatusOK, resp.StatusCode)
+	assert(t, strings.Contains(string(body), "processed 1 message"), "function logs from the fake log server %s", string(body))
+}
+
+func TestNegotiatedETag(t *testing.T) {
+	dat, err := ioutil.ReadFile("./tenantusage.dat")
+	errNil(t, err)
+	h, err := New(Options{
+		Tenants:          []policy.TenantPlan{{Name: "ming-luo", PlanType: policy.StarterTier, Org: "acme"}},
+		FederatedMetrics: dat,
+	})
+	errNil(t, err)
+	defer h.Close()
+
+	get := func(path, accept, etag string) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, h.URL+path, nil)
+		errNil(t, err)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		errNil(t, err)
+		resp.Body.Close()
+		return resp
+	}
+
+	for _, path := range []string{"/tenantsusage", "/k/tenant/ming-luo"} {
+		resp := get(path, "", "")
+		equals(t, http.StatusOK, resp.StatusCode)
+		assert(t, strings.Contains(strings.Join(resp.Header.Values("Vary"), ","), "Accept"), "%s varies by Accept", path)
+		etag := resp.Header.Get("ETag")
+		equals(t, http.StatusNotModified, get(path, "", etag).StatusCode)
+
+		// the same version in another representation is a different entity
+		yaml := get(path, "application/yaml", etag)
+		equals(t, http.StatusOK, yaml.StatusCode)
+		assert(t, yaml.Header.Get("ETag") != etag, "%s YAML etag", path)
+		equals(t, http.StatusNotModified, get(path, "application/yaml", yaml.Header.Get("ETag")).StatusCode)
+		fields := get(path+"?fields=name", "", etag)
+		equals(t, http.StatusOK, fields.StatusCode)
+		assert(t, fields.Header.Get("ETag") != etag, "%s selected fields etag", path)
+	}
+	ndjson := get("/tenantsusage", "application/x-ndjson", get("/tenantsusage", "", "").Header.Get("ETag"))
+	equals(t, http.StatusOK, ndjson.StatusCode)
+	equals(t, "application/x-ndjson", ndjson.Header.Get("Content-Type"))
+
+	// a metadata update does not rebuild the usage but changes the tenants usage export
+	etag := get("/tenantsusage", "", "").Header.Get("ETag")
+	resp, err := http.Post(h.URL+"/k/tenant/ming-luo", "application/json",
+		strings.NewReader(`{"planType": "starter", "org": "acme", "metadata": {"billingId": "b-42"}}`))
+	errNil(t, err)
+	resp.Body.Close()
+	equals(t, http.StatusOK, resp.StatusCode)
+	equals(t, http.StatusOK, get("/tenantsusage", "", etag).StatusCode)
+}
@@ -0,0 +1,117 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package tests
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/datastax/burnell/src/k8s"
+	"github.com/datastax/burnell/src/policy"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+)
+
+type fakeTenantPlanStore struct {
+	plans   map[string]policy.TenantPlan
+	updates int
+}
+
+func (s *fakeTenantPlanStore) GetTenant(name string) (policy.TenantPlan, error) {
+	if p, ok := s.plans[name]; ok {
+		return p, nil
+	}
+	return policy.TenantPlan{}, fmt.Errorf("tenant not found in database")
+}
+
+func (s *fakeTenantPlanStore) UpdateTenant(name string, plan policy.TenantPlan) (policy.TenantPlan, int, error) {
+	if err := policy.ValidateTenantPlan(plan); err != nil {
+		return policy.TenantPlan{}, http.StatusUnprocessableEntity, err
+	}
+	plan.Name = name
+	reconciled, err := policy.ReconcileTenantPlan(plan, s.plans[name])
+	if err != nil {
+		return policy.TenantPlan{}, http.StatusUnprocessableEntity, err
+	}
+	s.plans[name] = reconciled
+	s.updates++
+	return reconciled, http.StatusOK, nil
+}
+
+func tenantPlanResource(name string, spec map[string]interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "burnell.datastax.com/v1alpha1",
+		"kind":       "TenantPlan",
+		"metadata":   map[string]interface{}{"name": name, "namespace": "tenants", "generation": int64(2)},
+		"spec":       spec,
+	}}
+}
+
+func TestTenantPlanController(t *testing.T) {
+	scheme := runtime.NewScheme()
+	scheme.AddKnownTypeWithName(schema.GroupVersionKind{Group: "burnell.datastax.com", Version: "v1alpha1", Kind: "TenantPlanList"}, &unstructured.UnstructuredList{})
+	client := fake.NewSimpleDynamicClient(scheme,
+		tenantPlanResource("crd-tenant", map[string]interface{}{"planType": "production", "policy": map[string]interface{}{"numOfTopics": int64(300)}}),
+		tenantPlanResource("crd-invalid", map[string]interface{}{"planType": "gold"}),
+	)
+	store := &fakeTenantPlanStore{plans: map[string]policy.TenantPlan{}}
+	controller := NewTenantPlanController(client, "tenants", store)
+
+	updated, err := controller.Reconcile(context.Background())
+	errNil(t, err)
+	equals(t, 1, updated)
+	equals(t, 300, store.plans["crd-tenant"].Policy.NumOfTopics)
+
+	obj, err := client.Resource(TenantPlanGVR).Namespace("tenants").Get(context.Background(), "crd-tenant", metav1.GetOptions{})
+	errNil(t, err)
+	synced, _, _ := unstructured.NestedBool(obj.Object, "status", "synced")
+	assert(t, synced, "the status reports the synced plan")
+	planType, _, _ := unstructured.NestedString(obj.Object, "status", "planType")
+	equals(t, "production", planType)
+
+	obj, err = client.Resource(TenantPlanGVR).Namespace("tenants").Get(context.Background(), "crd-invalid", metav1.GetOptions{})
+	errNil(t, err)
+	message, _, _ := unstructured.NestedString(obj.Object, "status", "message")
+	assert(t, message != "", "the status reports the invalid spec")
+
+	// an unchanged spec is not applied again
+	updated, err = controller.Reconcile(context.Background())
+	errNil(t, err)
+	equals(t, 0, updated)
+	equals(t, 1, store.updates)
+
+	// a plan changed out of band is reconciled back to the spec
+	drifted := store.plans["crd-tenant"]
+	drifted.Policy.NumOfTopics = 10
+	drifted.UpdatedAt = time.Now()
+	store.plans["crd-tenant"] = drifted
+	updated, err = controller.Reconcile(context.Background())
+	errNil(t, err)
+	equals(t, 1, updated)
+	equals(t, 300, store.plans["crd-tenant"].Policy.NumOfTopics)
+}
@@ -0,0 +1,232 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package tests
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	. "github.com/datastax/burnell/src/logclient"
+	"github.com/datastax/burnell/src/pb"
+)
+
+func TestMatchTopics(t *testing.T) {
+	topics := []string{
+		"persistent://ming-luo/default/orders-us",
+		"persistent://ming-luo/default/orders-eu-partition-0",
+		"persistent://ming-luo/default/orders-eu-partition-1",
+		"persistent://ming-luo/default/payments",
+	}
+	matched, err := MatchTopics("persistent://ming-luo/default/orders-.*", topics)
+	errNil(t, err)
+	equals(t, []string{"persistent://ming-luo/default/orders-eu", "persistent://ming-luo/default/orders-us"}, matched)
+
+	matched, err = MatchTopics("persistent://ming-luo/default/pay", topics)
+	errNil(t, err)
+	equals(t, 0, len(matched))
+
+	_, err = MatchTopics("persistent://ming-luo/default/(", topics)
+	assert(t, err != nil, "invalid regex")
+}
+
+func TestResolveInputTopics(t *testing.T) {
+	ParseServiceRequest(&pb.FunctionMetaData{
+		FunctionDetails: &pb.FunctionDetails{
+			Tenant:    "ming-luo",
+			Namespace: "default",
+			Name:      "regex-fn",
+			Source: &pb.SourceSpec{
+				InputSpecs: map[string]*pb.ConsumerSpec{
+					"persistent://ming-luo/default/orders-.*": {IsRegexPattern: true},
+					"persistent://ming-luo/default/payments":  {},
+				},
+			},
+		},
+	})
+	f, ok := ReadFunctionMap("ming-luodefaultregex-fn")
+	assert(t, ok, "")
+	equals(t, []string{"persistent://ming-luo/default/payments"}, f.InputTopics)
+	equals(t, []string{"persistent://ming-luo/default/orders-.*"}, f.InputTopicRegex)
+
+	ResolveInputTopics(func(tenant, namespace string) ([]string, error) {
+		equals(t, "ming-luo", tenant)
+		equals(t, "default", namespace)
+		return []string{"persistent://ming-luo/default/orders-us", "persistent://ming-luo/default/payments"}, nil
+	})
+	f, _ = ReadFunctionMap("ming-luodefaultregex-fn")
+	equals(t, []string{"persistent://ming-luo/default/orders-us"}, f.MatchedInputTopics)
+	DeleteFunctionMap("ming-luodefaultregex-fn")
+}
+
+func TestFunctionAssignment(t *testing.T) {
+	md := &pb.FunctionMetaData{
+		FunctionDetails: &pb.FunctionDetails{Tenant: "ming-luo", Namespace: "default", Name: "assigned-fn", Parallelism: 2},
+	}
+	errNil(t, ApplyAssignment(&pb.Assignment{Instance: &pb.Instance{FunctionMetaData: md, InstanceId: 1}, WorkerId: "worker-0"}))
+	fn, workerID, err := GetFunctionWorkerID("ming-luodefaultassigned-fn", 1)
+	errNil(t, err)
+	equals(t, "worker-0", workerID)
+	equals(t, "assigned-fn", fn.FunctionName)
+
+	// rebalanced to another worker
+	errNil(t, ApplyAssignment(&pb.Assignment{Instance: &pb.Instance{FunctionMetaData: md, InstanceId: 1}, WorkerId: "worker-2"}))
+	_, workerID, err = GetFunctionWorkerID("ming-luodefaultassigned-fn", 1)
+	errNil(t, err)
+	equals(t, "worker-2", workerID)
+
+	errNil(t, RemoveAssignment("ming-luo/default/assigned-fn:1"))
+	f, _ := ReadFunctionMap("ming-luodefaultassigned-fn")
+	equals(t, "", f.Instances[1].AssignmentWorkerID)
+	assert(t, RemoveAssignment("ming-luo/default/assigned-fn") != nil, "missing instance id")
+	assert(t, ApplyAssignment(&pb.Assignment{}) != nil, "missing function details")
+	DeleteFunctionMap("ming-luodefaultassigned-fn")
+}
+
+func TestFunctionResources(t *testing.T) {
+	ParseServiceRequest(&pb.FunctionMetaData{
+		FunctionDetails: &pb.FunctionDetails{
+			Tenant:      "resource-tenant",
+			Namespace:   "default",
+			Name:        "fn1",
+			Parallelism: 2,
+			Runtime:     pb.FunctionDetails_GO,
+			Resources:   &pb.Resources{Cpu: 0.5, Ram: 1024, Disk: 2048},
+			SecretsMap:  `{"password":"s3cr3t","apiKey":{"path":"/secrets/key"}}`,
+		},
+	})
+	ParseServiceRequest(&pb.FunctionMetaData{
+		FunctionDetails: &pb.FunctionDetails{Tenant: "resource-tenant", Namespace: "default", Name: "fn2",
+			Resources: &pb.Resources{Cpu: 1, Ram: 512, Disk: 0}},
+	})
+
+	f, ok := ReadFunctionMap("resource-tenantdefaultfn1")
+	assert(t, ok, "")
+	equals(t, "GO", f.Runtime)
+	equals(t, []string{"apiKey", "password"}, f.SecretNames)
+	equals(t, FunctionResources{CPU: 0.5, RAM: 1024, Disk: 2048}, f.Resources)
+
+	total, functions := TenantFunctionResources("resource-tenant")
+	equals(t, 2, len(functions))
+	equals(t, FunctionResources{CPU: 2, RAM: 2560, Disk: 4096}, total)
+
+	DeleteFunctionMap("resource-tenantdefaultfn1")
+	DeleteFunctionMap("resource-tenantdefaultfn2")
+}
+
+func TestSearchTenantLogs(t *testing.T) {
+	ParseServiceRequest(&pb.FunctionMetaData{
+		FunctionDetails: &pb.FunctionDetails{Tenant: "search-tenant", Namespace: "default", Name: "fn1", Parallelism: 2},
+	})
+	ParseServiceRequest(&pb.FunctionMetaData{
+		FunctionDetails: &pb.FunctionDetails{Tenant: "search-tenant", Namespace: "ns2", Name: "fn2"},
+	})
+	defer DeleteFunctionMap("search-tenantdefaultfn1")
+	defer DeleteFunctionMap("search-tenantns2fn2")
+
+	now := time.Now().UTC()
+	stamp := func(ago time.Duration) string {
+		return now.Add(-ago).Format("2006-01-02T15:04:05.000-0700")
+	}
+	logs := map[string]string{
+		"search-tenantdefaultfn1-0": stamp(3*time.Minute) + " [main] ERROR fn1 - Timeout on publish\n\tat Producer.send\n",
+		"search-tenantdefaultfn1-1": now.Add(-2*time.Hour).Format("[2006-01-02 15:04:05 -0700]") + " [ERROR] log.py: timeout too old\n",
+		"search-tenantns2fn2-0":     stamp(5*time.Minute) + " [main] WARN fn2 - timeout retry\n" + stamp(time.Minute) + " [main] INFO fn2 - ok\n",
+	}
+	reader := func(ctx context.Context, functionName, workerID string, instanceID int, rd FunctionLogRequest) (FunctionLogResponse, error) {
+		equals(t, int64(4096), rd.Bytes)
+		if l, ok := logs[functionName+"-"+strconv.Itoa(instanceID)]; ok {
+			return FunctionLogResponse{Logs: l}, nil
+		}
+		return FunctionLogResponse{}, ErrNotFoundFunction
+	}
+
+	matches, errs := SearchTenantLogs(context.Background(), "search-tenant", LogSearchRequest{Query: "TIMEOUT", Since: time.Hour, Bytes: 4096, Concurrency: 2}, reader)
+	equals(t, 0, len(errs))
+	equals(t, 2, len(matches))
+	equals(t, "fn2", matches[0].Function)
+	equals(t, "ns2", matches[0].Namespace)
+	equals(t, "fn1", matches[1].Function)
+	equals(t, 0, matches[1].Instance)
+	assert(t, matches[0].Timestamp.Before(matches[1].Timestamp), "sorted by timestamp")
+
+	// the stack trace line takes the timestamp of the line before it
+	matches, _ = SearchTenantLogs(context.Background(), "search-tenant", LogSearchRequest{Query: "producer.send", Since: time.Hour, Concurrency: 1, Bytes: 4096}, reader)
+	equals(t, 1, len(matches))
+	equals(t, matches[0].Timestamp.IsZero(), false)
+
+	delete(logs, "search-tenantns2fn2-0")
+	matches, errs = SearchTenantLogs(context.Background(), "search-tenant", LogSearchRequest{Query: "timeout", Since: 3 * time.Hour, Bytes: 4096}, reader)
+	equals(t, 1, len(errs))
+	equals(t, 2, len(matches))
+	equals(t, 1, matches[0].Instance)
+}
+
+func TestFunctionCacheLimit(t *testing.T) {
+	defer SetFunctionCacheLimit(10000)
+	for i := 0; i < 3; i++ {
+		ParseServiceRequest(&pb.FunctionMetaData{
+			FunctionDetails: &pb.FunctionDetails{Tenant: "lru-tenant", Namespace: "default", Name: "fn" + strconv.Itoa(i)},
+		})
+	}
+	_, ok := ReadFunctionMap("lru-tenantdefaultfn0")
+	assert(t, ok, "")
+	SetFunctionCacheLimit(2)
+	_, ok = ReadFunctionMap("lru-tenantdefaultfn1")
+	assert(t, !ok, "the least recently used function is evicted")
+	_, ok = ReadFunctionMap("lru-tenantdefaultfn0")
+	assert(t, ok, "a recently read function is kept")
+
+	ParseServiceRequest(&pb.FunctionMetaData{
+		FunctionDetails: &pb.FunctionDetails{Tenant: "lru-tenant", Namespace: "default", Name: "fn3"},
+	})
+	_, ok = ReadFunctionMap("lru-tenantdefaultfn2")
+	assert(t, !ok, "evicted over the limit")
+	equals(t, 2, len(TenantFunctions("lru-tenant")))
+	DeleteFunctionMap("lru-tenantdefaultfn0")
+	DeleteFunctionMap("lru-tenantdefaultfn3")
+}
+
+func TestCompactFunctionMap(t *testing.T) {
+	deletedAt := time.Now().Add(-48 * time.Hour)
+	ApplyServiceRequest(&pb.ServiceRequest{
+		ServiceRequestType: pb.ServiceRequest_DELETE,
+		FunctionMetaData:   &pb.FunctionMetaData{FunctionDetails: &pb.FunctionDetails{Tenant: "compact-tenant", Namespace: "default", Name: "deleted-fn"}},
+	}, deletedAt)
+	ApplyServiceRequest(&pb.ServiceRequest{
+		ServiceRequestType: pb.ServiceRequest_DELETE,
+		FunctionMetaData:   &pb.FunctionMetaData{FunctionDetails: &pb.FunctionDetails{Tenant: "compact-tenant", Namespace: "default", Name: "recent-fn"}},
+	}, time.Now())
+	ApplyServiceRequest(&pb.ServiceRequest{
+		FunctionMetaData: &pb.FunctionMetaData{FunctionDetails: &pb.FunctionDetails{Tenant: "gone-tenant", Namespace: "default", Name: "fn"}},
+	}, time.Now())
+
+	equals(t, 2, CompactFunctionMap(func(tenant string) bool { return tenant == "gone-tenant" }, time.Now()))
+	_, ok := ReadFunctionMap("compact-tenantdefaultdeleted-fn")
+	assert(t, !ok, "deleted over the retention")
+	_, ok = ReadFunctionMap("compact-tenantdefaultrecent-fn")
+	assert(t, ok, "the logs of a recently deleted function are still available")
+	_, ok = ReadFunctionMap("gone-tenantdefaultfn")
+	assert(t, !ok, "under a deleted tenant")
+	DeleteFunctionMap("compact-tenantdefaultrecent-fn")
+}
@@ -0,0 +1,119 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/datastax/burnell/src/notification"
+	"github.com/datastax/burnell/src/util"
+)
+
+type recordTransport struct {
+	notices []Notice
+}
+
+func (r *recordTransport) Send(recipients []string, notice Notice) error {
+	r.notices = append(r.notices, notice)
+	return nil
+}
+
+func TestNotifySuppression(t *testing.T) {
+	email := &recordTransport{}
+	webhook := &recordTransport{}
+	s := NewService(email, webhook, time.Hour)
+
+	emails := []string{"ops@example.com"}
+	webhooks := []string{"https://example.com/hook"}
+	assert(t, s.Notify(emails, webhooks, Notice{Tenant: "ming-luo", Kind: QuotaWarning}), "first quota warning")
+	assert(t, !s.Notify(emails, webhooks, Notice{Tenant: "ming-luo", Kind: QuotaWarning}), "suppress repeated quota warning")
+	assert(t, s.Notify(emails, nil, Notice{Tenant: "another", Kind: QuotaWarning}), "quota warning to another tenant")
+	assert(t, s.Notify(emails, nil, Notice{Tenant: "ming-luo", Kind: Maintenance}), "maintenance is never suppressed")
+	assert(t, s.Notify(emails, nil, Notice{Tenant: "ming-luo", Kind: Maintenance}), "maintenance is never suppressed")
+	assert(t, !s.Notify(nil, nil, Notice{Tenant: "ming-luo", Kind: Maintenance}), "no recipients")
+
+	equals(t, 4, len(email.notices))
+	equals(t, 1, len(webhook.notices))
+	assert(t, !email.notices[0].CreatedAt.IsZero(), "creation time is set")
+}
+
+func TestAlertRoutes(t *testing.T) {
+	webhook, slack, pagerDuty := &recordTransport{}, &recordTransport{}, &recordTransport{}
+	a := NewAlerter(webhook, slack, pagerDuty, time.Hour)
+	assert(t, a.SetRoutes([]util.AlertRoute{{Name: "ops", Severity: "urgent", Webhooks: []string{"https://example.com"}}}) != nil, "unknown severity")
+	assert(t, a.SetRoutes([]util.AlertRoute{{Name: "ops", Severity: SeverityInfo}}) != nil, "no transport")
+	assert(t, a.SetRoutes([]util.AlertRoute{{Name: "ops", Severity: SeverityInfo, SlackWebhooks: []string{"hooks.slack.com"}}}) != nil, "not a URL")
+	errNil(t, a.SetRoutes([]util.AlertRoute{
+		{Name: "ops", Severity: SeverityWarning, Webhooks: []string{"https://example.com/hook"}, SlackWebhooks: []string{"https://hooks.slack.com/x"}},
+		{Name: "oncall", Severity: SeverityCritical, Kinds: []string{ListenerFailure, SLAViolation}, PagerDutyRoutingKeys: []string{"key"}},
+	}))
+
+	equals(t, 0, a.Raise(Notice{Kind: QuotaWarning, Tenant: "ming", Severity: SeverityInfo}))
+	equals(t, 1, a.Raise(Notice{Kind: QuotaWarning, Tenant: "ming", Severity: SeverityWarning}))
+	equals(t, 0, a.Raise(Notice{Kind: QuotaWarning, Tenant: "ming", Severity: SeverityWarning}))
+	// the critical alerts also go to the warning route
+	equals(t, 2, a.Raise(Notice{Kind: ListenerFailure, Source: util.TenantReaderClient, Severity: SeverityCritical}))
+	equals(t, 1, a.Raise(Notice{Kind: QuotaWarning, Tenant: "ming", Severity: SeverityCritical}))
+	equals(t, 3, len(webhook.notices))
+	equals(t, 3, len(slack.notices))
+	equals(t, 1, len(pagerDuty.notices))
+	equals(t, util.TenantReaderClient, pagerDuty.notices[0].Source)
+	assert(t, !pagerDuty.notices[0].CreatedAt.IsZero(), "creation time is set")
+	equals(t, SeverityWarning, webhook.notices[0].Severity)
+}
+
+func TestSlackAndPagerDutyTransports(t *testing.T) {
+	bodies := make(chan map[string]interface{}, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		bodies <- body
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+	notice := Notice{Kind: SLAViolation, Tenant: "ming", Severity: SeverityCritical, Subject: "probe failed", Message: "timeout", CreatedAt: time.Now()}
+
+	errNil(t, (&SlackTransport{Client: server.Client()}).Send([]string{server.URL}, notice))
+	body := <-bodies
+	equals(t, "[CRITICAL] probe failed", body["text"])
+	attachment := body["attachments"].([]interface{})[0].(map[string]interface{})
+	equals(t, "timeout", attachment["text"])
+
+	errNil(t, (&PagerDutyTransport{Client: server.Client(), URL: server.URL}).Send([]string{"routing-key"}, notice))
+	body = <-bodies
+	equals(t, "routing-key", body["routing_key"])
+	equals(t, "trigger", body["event_action"])
+	equals(t, "burnell/sla-violation/ming/", body["dedup_key"])
+	payload := body["payload"].(map[string]interface{})
+	equals(t, "critical", payload["severity"])
+	equals(t, "probe failed", payload["summary"])
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer failing.Close()
+	assert(t, (&PagerDutyTransport{Client: failing.Client(), URL: failing.URL}).Send([]string{"key"}, notice) != nil, "rejected event")
+}
@@ -0,0 +1,88 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package tests
+
+import (
+	"testing"
+
+	"github.com/datastax/burnell/src/logclient"
+	. "github.com/datastax/burnell/src/pb"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// newerFunctionDetails returns the wire format of function details with fields from a newer Pulsar version
+func newerFunctionDetails(t *testing.T, fd *FunctionDetails) []byte {
+	data, err := proto.Marshal(fd)
+	errNil(t, err)
+	data = protowire.AppendTag(data, 19, protowire.BytesType)
+	data = protowire.AppendString(data, `{"env":"prod"}`)
+	data = protowire.AppendTag(data, 21, protowire.VarintType)
+	data = protowire.AppendVarint(data, 1)
+	data = protowire.AppendTag(data, 23, protowire.VarintType)
+	data = protowire.AppendVarint(data, 1)
+	data = protowire.AppendTag(data, 99, protowire.Fixed64Type)
+	data = protowire.AppendFixed64(data, 42)
+	return data
+}
+
+func TestFunctionMetadataCompatibility(t *testing.T) {
+	data := newerFunctionDetails(t, &FunctionDetails{
+		Tenant:      "ming-luo",
+		Namespace:   "default",
+		Name:        "newer-fn",
+		Parallelism: 3,
+		Runtime:     FunctionDetails_PYTHON,
+		Resources:   &Resources{Cpu: 0.5, Ram: 1073741824, Disk: 10737418240},
+	})
+
+	fd := FunctionDetails{}
+	errNil(t, proto.Unmarshal(data, &fd))
+	// the known fields are parsed as before
+	equals(t, "newer-fn", fd.GetName())
+	equals(t, int32(3), fd.GetParallelism())
+	equals(t, FunctionDetails_PYTHON, fd.GetRuntime())
+	equals(t, int64(1073741824), fd.GetResources().GetRam())
+
+	extras, err := FunctionDetailsExtras(&fd)
+	errNil(t, err)
+	equals(t, 4, len(extras))
+	equals(t, `{"env":"prod"}`, extras["customRuntimeOptions"])
+	equals(t, true, extras["retainOrdering"])
+	equals(t, uint64(1), extras["subscriptionPosition"])
+	equals(t, uint64(42), extras["field99"])
+
+	extras, err = FunctionDetailsExtras(&FunctionDetails{Name: "current-fn"})
+	errNil(t, err)
+	equals(t, 0, len(extras))
+}
+
+func TestFunctionMetadataExposed(t *testing.T) {
+	fd := FunctionDetails{}
+	errNil(t, proto.Unmarshal(newerFunctionDetails(t, &FunctionDetails{Tenant: "ming-luo", Namespace: "default", Name: "newer-fn"}), &fd))
+	logclient.ParseServiceRequest(&FunctionMetaData{FunctionDetails: &fd})
+
+	f, ok := logclient.ReadFunctionMap("ming-luodefaultnewer-fn")
+	assert(t, ok, "")
+	equals(t, true, f.Extras["retainOrdering"])
+	logclient.DeleteFunctionMap("ming-luodefaultnewer-fn")
+}
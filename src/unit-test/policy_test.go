@@ -22,9 +22,24 @@
 package tests
 
 import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/datastax/burnell/src/burnelltest"
+	"github.com/datastax/burnell/src/metrics"
+	"github.com/datastax/burnell/src/notification"
 	. "github.com/datastax/burnell/src/policy"
 	"github.com/datastax/burnell/src/util"
 )
@@ -84,3 +99,1256 @@ func TestIsPartitionTopic(t *testing.T) {
 	assert(t, util.IsPersistentTopic("persistent://ming-luo/local-useast1-gcp/partition-topic2-partition-1o9"), "")
 	assert(t, !util.IsPersistentTopic("non-persistent://ming-luo/local-useast1-gcp/partition-topic2"), "")
 }
+
+func TestMigrateTenantPlan(t *testing.T) {
+	legacy := TenantPlan{
+		Name:     "ming-luo",
+		PlanType: " Starter",
+		Policy: PlanPolicy{
+			MessageRetention: 7 * 24 * time.Hour,
+		},
+	}
+	plan, migrated, err := MigrateTenantPlan(legacy)
+	errNil(t, err)
+	assert(t, migrated, "legacy plan must be migrated")
+	equals(t, TenantPlanVersion, plan.Version)
+	equals(t, StarterTier, plan.PlanType)
+	equals(t, StarterTier, plan.Policy.Name)
+	equals(t, 7*24, plan.Policy.MessageHourRetention)
+
+	plan, migrated, err = MigrateTenantPlan(plan)
+	errNil(t, err)
+	assert(t, !migrated, "current version plan requires no migration")
+
+	_, _, err = MigrateTenantPlan(TenantPlan{Name: "future", Version: TenantPlanVersion + 1})
+	assert(t, err != nil, "future version cannot be migrated")
+}
+
+func TestValidateTenantPlan(t *testing.T) {
+	errNil(t, ValidateTenantPlan(TenantPlan{PlanType: "free"}))
+	errNil(t, ValidateTenantPlan(TenantPlan{PlanType: "private", Policy: PlanPolicy{Functions: -1, NumOfTopics: 50}}))
+
+	err := ValidateTenantPlan(TenantPlan{
+		PlanType: "bogus",
+		Policy: PlanPolicy{
+			NumOfTopics:          -5,
+			MessageHourRetention: 1000000,
+			FeatureCodes:         "broker-metrics,bad code",
+		},
+	})
+	vErr, ok := err.(*ValidationError)
+	assert(t, ok, "expect a validation error")
+	equals(t, 4, len(vErr.Fields))
+	equals(t, "planType", vErr.Fields[0].Field)
+	equals(t, "policy.numOfTopics", vErr.Fields[1].Field)
+	equals(t, "policy.messageHourRetention", vErr.Fields[2].Field)
+	equals(t, "policy.featureCodes", vErr.Fields[3].Field)
+
+	errNil(t, LoadPlanPolicyFieldBounds("numOfTopics:1:10"))
+	assert(t, ValidateTenantPlan(TenantPlan{Policy: PlanPolicy{NumOfTopics: 11}}) != nil, "over the configured bound")
+	assert(t, LoadPlanPolicyFieldBounds("bogus:1:10") != nil, "unknown field")
+	assert(t, LoadPlanPolicyFieldBounds("numOfTopics:10:1") != nil, "min over max")
+	errNil(t, LoadPlanPolicyFieldBounds("numOfTopics:1:100000"))
+
+	errNil(t, ValidateTenantPlan(TenantPlan{Policy: PlanPolicy{NumOfPartitions: -1}}))
+	assert(t, ValidateTenantPlan(TenantPlan{Policy: PlanPolicy{NumOfPartitions: -2}}) != nil, "under the partitions bound")
+}
+
+func TestPartitionsLimit(t *testing.T) {
+	handler := TenantPolicyHandler{}
+	equals(t, TenantPlanPolicies.FreePlan.NumOfPartitions, handler.GetPartitionsLimit("unknown-tenant"))
+
+	plan, err := ReconcileTenantPlan(TenantPlan{Name: "ming-luo", PlanType: ProductionTier, Policy: PlanPolicy{NumOfTopics: 10}},
+		TenantPlan{Name: "ming-luo", PlanType: ProductionTier, Policy: PlanPolicy{NumOfPartitions: 32}})
+	errNil(t, err)
+	equals(t, 32, plan.Policy.NumOfPartitions)
+	equals(t, -1, TenantPlanPolicies.PrivatePlan.NumOfPartitions)
+}
+
+func TestReconcileContactsAndNotifications(t *testing.T) {
+	existing := TenantPlan{Name: "ming-luo", PlanType: FreeTier,
+		Contacts:      &TenantContacts{Emails: []string{"ops@example.com"}, Webhooks: []string{"https://example.com/hook"}},
+		Notifications: &NotificationPreferences{QuotaWarning: true, Maintenance: true}}
+
+	// absent keeps the existing
+	var req TenantPlan
+	errNil(t, json.Unmarshal([]byte(`{"planType":"free"}`), &req))
+	plan, err := ReconcileTenantPlan(req, existing)
+	errNil(t, err)
+	equals(t, existing.Contacts, plan.Contacts)
+	equals(t, existing.Notifications, plan.Notifications)
+
+	// present replaces, an opt out of every notice and cleared contacts are saved
+	req = TenantPlan{}
+	errNil(t, json.Unmarshal([]byte(`{"planType":"free","contacts":{},"notifications":{"quotaWarning":false,"maintenance":false,"usageReport":false}}`), &req))
+	plan, err = ReconcileTenantPlan(req, existing)
+	errNil(t, err)
+	equals(t, 0, len(plan.Contacts.Emails)+len(plan.Contacts.Webhooks))
+	equals(t, NotificationPreferences{}, *plan.Notifications)
+	assert(t, !plan.Notifications.WantsNotice(notification.QuotaWarning), "opted out")
+	equals(t, []string{"ops@example.com"}, existing.Contacts.Emails)
+}
+
+func TestTopicInternalStats(t *testing.T) {
+	broker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/partitions") {
+			w.Write([]byte(`{"partitions":5}`))
+			return
+		}
+		if strings.Contains(r.URL.Path, "-partition-3") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"numberOfEntries":10,"totalSize":100,"ledgers":[{},{}],"cursors":{"sub":{}}}`))
+	}))
+	defer broker.Close()
+	brokerURL := util.Config.BrokerProxyURL
+	util.Config.BrokerProxyURL = broker.URL
+	defer func() { util.Config.BrokerProxyURL = brokerURL }()
+
+	stats, status, err := GetTopicInternalStats(context.Background(), "tenant", "ns", "topic", 2, 2, false)
+	errNil(t, err)
+	equals(t, http.StatusOK, status)
+	equals(t, 5, stats.Partitions)
+	equals(t, 4, stats.Offset)
+	equals(t, 2, len(stats.Data))
+	equals(t, 2, stats.Data[0].Partition)
+	equals(t, 2, stats.Data[0].NumberOfLedgers)
+	equals(t, 1, stats.Data[0].NumberOfCursors)
+	assert(t, stats.Data[0].Data == nil, "internal stats detail must be omitted")
+	assert(t, stats.Data[1].Error != "", "partition-3 must report the error")
+	equals(t, 2, stats.NumberOfLedgers)
+	equals(t, int64(100), stats.TotalSize)
+
+	stats, _, err = GetTopicInternalStats(context.Background(), "tenant", "ns", "topic", 3, 20, true)
+	errNil(t, err)
+	equals(t, 5, stats.Offset)
+	equals(t, 2, len(stats.Data))
+	assert(t, stats.Data[1].Data != nil, "internal stats detail must be included")
+
+	_, status, err = GetTopicInternalStats(context.Background(), "tenant", "ns", "topic", 5, 20, false)
+	assert(t, err != nil, "offset beyond the number of partitions")
+	equals(t, http.StatusUnprocessableEntity, status)
+}
+
+func TestValidateTenantName(t *testing.T) {
+	errNil(t, ValidateTenantName("ming-luo", true))
+	errNil(t, ValidateTenantName("ming_luo.dev:1=a", true))
+	errNil(t, ValidateTenantName("public", false))
+
+	for _, name := range []string{"", "ming/luo", "ming luo", "public", "System", strings.Repeat("a", MaxTenantNameLength+1)} {
+		err := ValidateTenantName(name, true)
+		assert(t, err != nil, "invalid tenant name "+name)
+		vErr, ok := err.(*ValidationError)
+		assert(t, ok, "expect a validation error")
+		equals(t, "name", vErr.Fields[0].Field)
+	}
+}
+
+func TestAllowedOrigins(t *testing.T) {
+	errNil(t, ValidateAllowedOrigins([]string{"https://app.example.com", "http://localhost:3000", "https://*.example.com"}))
+	err := ValidateAllowedOrigins([]string{"https://app.example.com/path", "ftp://example.com", "example.com"})
+	assert(t, err != nil, "invalid origins")
+	equals(t, 3, len(err.(*ValidationError).Fields))
+
+	allowed := []string{"https://app.example.com", "https://*.kafkaesque.io"}
+	assert(t, MatchOrigin(allowed, "https://app.example.com"), "")
+	assert(t, MatchOrigin(allowed, "https://App.Example.com"), "origin is case insensitive")
+	assert(t, MatchOrigin(allowed, "https://console.kafkaesque.io"), "")
+	assert(t, !MatchOrigin(allowed, "https://kafkaesque.io"), "wildcard requires a subdomain")
+	assert(t, !MatchOrigin(allowed, "https://evilkafkaesque.io"), "")
+	assert(t, !MatchOrigin(allowed, "http://console.kafkaesque.io"), "scheme must match")
+	assert(t, !MatchOrigin(allowed, "https://app.example.com.evil.io"), "")
+}
+
+func TestTenantPlanEncryption(t *testing.T) {
+	defer SetPlanEncryptionKeys("")
+	plan := TenantPlan{Name: "ming-luo", Contacts: &TenantContacts{Emails: []string{"ops@example.com"}}}
+
+	// disabled
+	record, err := EncryptTenantPlan(plan)
+	errNil(t, err)
+	assert(t, record.Encrypted == nil, "not encrypted")
+	equals(t, plan.Contacts, record.Contacts)
+
+	oldKey := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef"))
+	newKey := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+	errNil(t, SetPlanEncryptionKeys("k1="+oldKey))
+	record, err = EncryptTenantPlan(plan)
+	errNil(t, err)
+	equals(t, "k1", record.Encrypted.KeyID)
+	assert(t, record.Contacts == nil, "no plaintext contacts")
+	data, err := json.Marshal(record)
+	errNil(t, err)
+	assert(t, !strings.Contains(string(data), "ops@example.com"), "no plaintext contacts in the record")
+
+	// rotated, the old key still decrypts
+	errNil(t, SetPlanEncryptionKeys("k2="+newKey+",k1="+oldKey))
+	equals(t, "k2", ActivePlanEncryptionKey())
+	decrypted, err := DecryptTenantPlan(record)
+	errNil(t, err)
+	equals(t, plan.Contacts, decrypted.Contacts)
+	assert(t, decrypted.Encrypted == nil, "decrypted")
+
+	errNil(t, SetPlanEncryptionKeys("k2="+newKey))
+	_, err = DecryptTenantPlan(record)
+	assert(t, err != nil, "retired key")
+
+	// the plan encrypted by the retired key is cached with the ciphertext and never overwritten
+	h, err := burnelltest.New(burnelltest.Options{})
+	errNil(t, err)
+	defer h.Close()
+	record.PlanType = FreeTier
+	data, err = json.Marshal(record)
+	errNil(t, err)
+	events, unsubscribe := WatchTenantPlan("ming-luo")
+	defer unsubscribe()
+	cached := TenantManager.ApplyTenantRecord(data)
+	assert(t, cached.Encrypted == nil, "the ciphertext is not in the cached plan")
+	assert(t, cached.Contacts == nil, "no contacts")
+	select {
+	case event := <-events:
+		assert(t, event.Plan.Encrypted == nil, "the ciphertext is not in the watch event")
+	case <-time.After(5 * time.Second):
+		t.Fatal("no tenant plan event")
+	}
+	h.Admin.AddTenant("ming-luo")
+	resp, err := http.Get(h.URL + "/k/tenant/ming-luo")
+	errNil(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	errNil(t, err)
+	equals(t, http.StatusOK, resp.StatusCode)
+	assert(t, !strings.Contains(string(body), record.Encrypted.Contacts), "the ciphertext is not in the response")
+	assert(t, !strings.Contains(string(body), "encrypted"), string(body))
+	_, status, err := TenantManager.UpdateTenant("ming-luo", TenantPlan{PlanType: StarterTier})
+	equals(t, http.StatusConflict, status)
+	assert(t, errors.Is(err, ErrPlanUndecryptable), "undecryptable plan update")
+	count, err := TenantManager.ReencryptTenants()
+	errNil(t, err)
+	equals(t, 0, count)
+	equals(t, 0, len(h.Store.Records()))
+
+	// the deletion record keeps the ciphertext
+	_, err = TenantManager.DeleteTenant("ming-luo")
+	errNil(t, err)
+	records := h.Store.Records()
+	equals(t, 1, len(records))
+	equals(t, Deleted, records[0].TenantStatus)
+	equals(t, record.Encrypted, records[0].Encrypted)
+
+	assert(t, SetPlanEncryptionKeys("k1=short") != nil, "invalid key")
+	assert(t, SetPlanEncryptionKeys("k1="+base64.StdEncoding.EncodeToString([]byte("short"))) != nil, "invalid key length")
+}
+
+func TestTenantPlanEncryptionRoundTrip(t *testing.T) {
+	defer SetPlanEncryptionKeys("")
+	keyFile := filepath.Join(t.TempDir(), "policy-encryption-keys")
+	errNil(t, ioutil.WriteFile(keyFile, []byte("k1="+base64.StdEncoding.EncodeToString([]byte("0123456789abcdef"))+"\n"), 0600))
+	// the key file takes precedence over the keys in the configuration
+	errNil(t, LoadPlanEncryptionKeys("k0=invalid", keyFile))
+	equals(t, "k1", ActivePlanEncryptionKey())
+	assert(t, LoadPlanEncryptionKeys("", filepath.Join(t.TempDir(), "missing")) != nil, "missing key file")
+
+	h, err := burnelltest.New(burnelltest.Options{})
+	errNil(t, err)
+	defer h.Close()
+	contacts := &TenantContacts{Emails: []string{"ops@example.com"}}
+	metadata := map[string]string{"billingId": "acct-42"}
+	_, _, err = TenantManager.UpdateTenant("ming-luo", TenantPlan{PlanType: FreeTier, Contacts: contacts, Metadata: metadata})
+	errNil(t, err)
+
+	// the record in the tenant topic has neither the contacts nor the metadata in plaintext
+	records := h.Store.Records()
+	equals(t, 1, len(records))
+	assert(t, records[0].Metadata == nil && records[0].Contacts == nil, "no plaintext fields")
+	assert(t, records[0].Encrypted.Metadata != "", "encrypted metadata")
+	data, err := json.Marshal(records[0])
+	errNil(t, err)
+	assert(t, !strings.Contains(string(data), "acct-42"), "no plaintext metadata in the record")
+	assert(t, !strings.Contains(string(data), "ops@example.com"), "no plaintext contacts in the record")
+
+	// the record consumed back from the tenant topic is decrypted in the cache
+	cached := TenantManager.ApplyTenantRecord(data)
+	equals(t, metadata, cached.Metadata)
+	equals(t, contacts, cached.Contacts)
+	assert(t, cached.Encrypted == nil, "decrypted")
+	plan, err := TenantManager.GetTenant("ming-luo")
+	errNil(t, err)
+	equals(t, metadata, plan.Metadata)
+
+	// a record encrypted before the metadata encryption is rewritten with the encrypted metadata
+	legacy := records[0]
+	legacy.Name = "legacy-metadata"
+	legacy.Encrypted = &EncryptedFields{KeyID: "k1", Contacts: records[0].Encrypted.Contacts}
+	legacy.Metadata = metadata
+	data, err = json.Marshal(legacy)
+	errNil(t, err)
+	equals(t, metadata, TenantManager.ApplyTenantRecord(data).Metadata)
+	count, err := TenantManager.ReencryptTenants()
+	errNil(t, err)
+	equals(t, 1, count)
+	records = h.Store.Records()
+	equals(t, "legacy-metadata", records[len(records)-1].Name)
+	assert(t, records[len(records)-1].Metadata == nil && records[len(records)-1].Encrypted.Metadata != "", "rewritten with the encrypted metadata")
+
+	// a plan without metadata has no encrypted metadata
+	record, err := EncryptTenantPlan(TenantPlan{Name: "no-metadata", Contacts: contacts})
+	errNil(t, err)
+	equals(t, "", record.Encrypted.Metadata)
+	decrypted, err := DecryptTenantPlan(record)
+	errNil(t, err)
+	assert(t, decrypted.Metadata == nil, "no metadata")
+}
+
+func TestEnforceTenantRetention(t *testing.T) {
+	updates := map[string]string{}
+	broker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			body, _ := ioutil.ReadAll(r.Body)
+			updates[r.URL.Path] = string(body)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		switch r.URL.Path {
+		case "/admin/v2/namespaces/ming-luo":
+			w.Write([]byte(`["ming-luo/ns1","ming-luo/ns2"]`))
+		case "/admin/v2/namespaces/ming-luo/ns1/retention":
+			w.Write([]byte(`{"retentionTimeInMinutes":60,"retentionSizeInMB":100}`))
+		case "/admin/v2/namespaces/ming-luo/ns2/retention":
+			w.Write([]byte(`{"retentionTimeInMinutes":-1,"retentionSizeInMB":-1}`))
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer broker.Close()
+	savedURL := util.Config.BrokerProxyURL
+	defer func() { util.Config.BrokerProxyURL = savedURL }()
+	util.Config.BrokerProxyURL = broker.URL
+
+	summary := EnforceTenantRetention(TenantPlan{Name: "ming-luo", Policy: PlanPolicy{MessageHourRetention: 48}})
+	equals(t, "", summary.Error)
+	equals(t, 48*60, summary.PlanRetentionMinutes)
+	equals(t, 2, len(summary.Namespaces))
+	equals(t, true, summary.Namespaces[0].Enforced)
+	equals(t, `{"retentionTimeInMinutes":2880,"retentionSizeInMB":100}`, updates["/admin/v2/namespaces/ming-luo/ns1/retention"])
+	equals(t, "172800", updates["/admin/v2/namespaces/ming-luo/ns1/messageTTL"])
+	equals(t, true, summary.Namespaces[1].AbovePlan)
+	equals(t, false, summary.Namespaces[1].Enforced)
+	assert(t, summary.Drifted(), "above plan namespace drifted")
+
+	// not enforced
+	equals(t, 0, len(EnforceTenantRetention(TenantPlan{Name: "ming-luo"}).Namespaces))
+}
+
+func TestTopicPlanDefaults(t *testing.T) {
+	defaults := TopicPlanDefaults(TenantPlan{Policy: PlanPolicy{NumOfProducers: 30, MessageHourRetention: 2, FeatureCodes: MessageDeduplication}})
+	equals(t, map[string]interface{}{"deduplicationEnabled": true, "maxProducers": 30, "messageTTL": 7200}, defaults)
+
+	defaults = TopicPlanDefaults(TenantPlan{Policy: PlanPolicy{NumOfProducers: -1, MessageHourRetention: 2, FeatureCodes: InfiniteMessageRetention}})
+	equals(t, 0, len(defaults))
+}
+
+func TestTenantMetadata(t *testing.T) {
+	crm := "crm-123"
+	md := MergeTenantMetadata(map[string]string{"billing.accountId": "acct-1", "legacy": "x"},
+		map[string]*string{"crm/id": &crm, "legacy": nil})
+	equals(t, map[string]string{"billing.accountId": "acct-1", "crm/id": "crm-123"}, md)
+	errNil(t, ValidateTenantMetadata(md))
+	errNil(t, ValidateTenantMetadata(nil))
+
+	err := ValidateTenantMetadata(map[string]string{"-bad": "v", "ok": strings.Repeat("v", 513)})
+	vErr, ok := err.(*ValidationError)
+	assert(t, ok, "expect validation error")
+	equals(t, 2, len(vErr.Fields))
+	equals(t, "-bad", vErr.Fields[0].Value)
+	equals(t, "metadata.ok", vErr.Fields[1].Field)
+
+	tooMany := map[string]string{}
+	for i := 0; i < 33; i++ {
+		tooMany["k"+strconv.Itoa(i)] = "v"
+	}
+	assert(t, ValidateTenantMetadata(tooMany) != nil, "over the max keys")
+	assert(t, ValidateTenantPlan(TenantPlan{Metadata: tooMany}) != nil, "plan metadata validated")
+
+	maskRules := map[string]string{"logmask/order": `ORD-\d+`, "logmask/account": `ACC-\d{8}`, "crm/id": "crm-123"}
+	errNil(t, ValidateTenantMetadata(maskRules))
+	equals(t, 2, len(TenantLogMaskRules(maskRules)))
+	equals(t, "logmask/account", TenantLogMaskRules(maskRules)[0].Name)
+	assert(t, ValidateTenantMetadata(map[string]string{"logmask/bad": "ACC-("}) != nil, "invalid log masking regex")
+
+	// the metadata is kept unless the plan update replaces it
+	plan, err := ReconcileTenantPlan(TenantPlan{PlanType: "free"}, TenantPlan{Name: "t1", Metadata: md})
+	errNil(t, err)
+	equals(t, md, plan.Metadata)
+}
+
+func TestPlanRequestRate(t *testing.T) {
+	rate, burst := PlanRequestRate(TenantPlan{PlanType: "starter"})
+	equals(t, 50, rate)
+	equals(t, 100, burst)
+
+	rate, burst = PlanRequestRate(TenantPlan{PlanType: "Production", Policy: PlanPolicy{RequestRate: 300}})
+	equals(t, 300, rate)
+	equals(t, 400, burst)
+
+	rate, _ = PlanRequestRate(TenantPlan{PlanType: "private"})
+	equals(t, -1, rate)
+
+	err := ValidateTenantPlan(TenantPlan{PlanType: "free", Policy: PlanPolicy{RequestRate: -2}})
+	vErr, ok := err.(*ValidationError)
+	assert(t, ok, "expect validation error")
+	equals(t, "policy.requestRate", vErr.Fields[0].Field)
+}
+
+func TestBatchPlanUpdate(t *testing.T) {
+	assert(t, BatchPlanRequest{}.Validate() != nil, "tenants or filter is required")
+	assert(t, BatchPlanRequest{Tenants: []string{"t1"}, Plan: TenantPlan{PlanType: "gold"}}.Validate() != nil, "invalid plan type")
+	assert(t, BatchPlanRequest{Tenants: []string{"t1"}, AddFeatureCodes: []string{"bad code"}}.Validate() != nil, "invalid feature code")
+
+	plans := []TenantPlan{
+		{Name: "batch-1", PlanType: FreeTier, Policy: PlanPolicy{NumOfTopics: 5, FeatureCodes: "dedup"}, Metadata: map[string]string{"region": "us"}},
+		{Name: "batch-2", PlanType: StarterTier, Policy: PlanPolicy{NumOfTopics: 5, FeatureCodes: FeatureAllDisabled}, Metadata: map[string]string{"region": "us"}},
+		{Name: "batch-3", PlanType: FreeTier, Policy: PlanPolicy{FeatureCodes: FeatureAllEnabled}, Metadata: map[string]string{"region": "eu"}},
+	}
+	req := BatchPlanRequest{
+		Filter:          &BatchTenantFilter{Metadata: map[string]string{"region": "us"}},
+		Plan:            TenantPlan{Policy: PlanPolicy{NumOfTopics: 50}},
+		AddFeatureCodes: []string{"tiered-storage"},
+	}
+	errNil(t, req.Validate())
+	tenants := SelectBatchTenants(req, plans)
+	equals(t, []string{"batch-1", "batch-2"}, tenants)
+	equals(t, []string{"batch-3", "missing"}, SelectBatchTenants(BatchPlanRequest{Tenants: []string{"missing", "batch-3", "missing"}}, plans))
+
+	plan, err := BatchTenantPlan(req, plans[0])
+	errNil(t, err)
+	equals(t, FreeTier, plan.PlanType)
+	equals(t, "dedup,tiered-storage", plan.Policy.FeatureCodes)
+	plan, err = BatchTenantPlan(BatchPlanRequest{RemoveFeatureCodes: []string{"dedup"}}, plans[0])
+	errNil(t, err)
+	equals(t, FeatureAllDisabled, plan.Policy.FeatureCodes)
+	_, err = BatchTenantPlan(BatchPlanRequest{RemoveFeatureCodes: []string{"dedup"}}, plans[2])
+	assert(t, err != nil, "cannot remove from all enabled")
+
+	job, err := NewBatchJob(append(tenants, "missing"))
+	errNil(t, err)
+	equals(t, BatchRunning, job.State)
+	updated := map[string]TenantPlan{}
+	get := func(name string) (TenantPlan, error) {
+		for _, p := range plans {
+			if p.Name == name {
+				return p, nil
+			}
+		}
+		return TenantPlan{}, fmt.Errorf("tenant not found in database")
+	}
+	update := func(name string, p TenantPlan) (TenantPlan, int, error) {
+		if name == "batch-2" {
+			return TenantPlan{}, http.StatusInternalServerError, fmt.Errorf("policy store unavailable")
+		}
+		updated[name] = p
+		return p, http.StatusOK, nil
+	}
+	RunBatchPlanUpdate(job.ID, append(tenants, "missing"), req, get, update)
+
+	job, ok := GetBatchJob(job.ID)
+	assert(t, ok, "")
+	equals(t, BatchCompleted, job.State)
+	equals(t, 1, job.Succeeded)
+	equals(t, 2, job.Failed)
+	equals(t, BatchTenantResult{Tenant: "batch-2", Status: http.StatusInternalServerError, Error: "policy store unavailable"}, job.Results[1])
+	equals(t, http.StatusNotFound, job.Results[2].Status)
+	equals(t, 50, updated["batch-1"].Policy.NumOfTopics)
+}
+
+func TestDeclarativeApply(t *testing.T) {
+	_, err := ParseDeclarativeConfig([]byte("tenants:\n- name: gitops-1\n  bogus: 1\n"))
+	assert(t, err != nil, "unknown field")
+	_, err = ParseDeclarativeConfig([]byte("tenants:\n- name: gitops-1\n- name: gitops-1\n"))
+	assert(t, err != nil, "duplicated tenant")
+
+	cfg, err := ParseDeclarativeConfig([]byte(`
+tenants:
+- name: gitops-1
+  planType: production
+  policy:
+    numOfTopics: 200
+  namespaces:
+  - name: existing
+    retention:
+      retentionTimeInMinutes: 60
+      retentionSizeInMB: 100
+    messageTTLSeconds: 3600
+  - name: new-ns
+  tokens:
+  - subject: gitops-1-admin
+    exp: 30d
+`))
+	errNil(t, err)
+	equals(t, 200, cfg.Tenants[0].Policy.NumOfTopics)
+	equals(t, 3600, *cfg.Tenants[0].Namespaces[0].MessageTTLSeconds)
+
+	existing := TenantPlan{Name: "gitops-1", PlanType: ProductionTier, Policy: TenantPlanPolicies.ProductionPlan}
+	_, fields, err := DiffTenantPlan(cfg.Tenants[0].TenantPlan, existing)
+	errNil(t, err)
+	equals(t, []string{"policy"}, fields)
+	_, fields, err = DiffTenantPlan(TenantPlan{Name: "gitops-1"}, existing)
+	errNil(t, err)
+	equals(t, []string{}, fields)
+
+	broker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/admin/v2/tenants":
+			w.Write([]byte(`["gitops-1"]`))
+		case "/admin/v2/namespaces/gitops-1":
+			w.Write([]byte(`["gitops-1/existing"]`))
+		case "/admin/v2/namespaces/gitops-1/existing/retention":
+			w.Write([]byte(`{"retentionTimeInMinutes":60,"retentionSizeInMB":100}`))
+		case "/admin/v2/namespaces/gitops-1/existing/messageTTL":
+			w.Write([]byte(`60`))
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer broker.Close()
+	brokerURL := util.Config.BrokerProxyURL
+	util.Config.BrokerProxyURL = broker.URL
+	defer func() { util.Config.BrokerProxyURL = brokerURL }()
+
+	handler := TenantPolicyHandler{}
+	result := handler.ApplyDeclarativeConfig(cfg, true)
+	assert(t, result.DryRun, "")
+	equals(t, 0, result.Failed)
+	// the pulsar tenant, the existing namespace and its retention are unchanged
+	equals(t, 3, result.Unchanged)
+	kinds := []string{}
+	for _, c := range result.Changes {
+		assert(t, !c.Applied, "nothing is applied in a dry run")
+		kinds = append(kinds, c.Kind+" "+c.Action+" "+c.Resource)
+	}
+	equals(t, []string{
+		"tenant-plan create gitops-1",
+		"message-ttl update gitops-1/existing",
+		"namespace create gitops-1/new-ns",
+		"token issue gitops-1-admin",
+	}, kinds)
+}
+
+func TestNamingPolicy(t *testing.T) {
+	defer SetNamingPolicies(nil)
+	assert(t, SetNamingPolicies(map[string]util.NamingPolicy{"gold": {}}) != nil, "unknown plan type")
+	assert(t, SetNamingPolicies(map[string]util.NamingPolicy{"free": {Topic: util.NamingRule{Pattern: "[a-"}}}) != nil, "invalid pattern")
+
+	errNil(t, SetNamingPolicies(map[string]util.NamingPolicy{
+		"free": {
+			Namespace: util.NamingRule{Prefix: "{tenant}-", MaxLength: 16},
+			Topic:     util.NamingRule{Pattern: "[a-z][a-z0-9-]*"},
+		},
+		"default": {Topic: util.NamingRule{MaxLength: 4}},
+	}))
+	// an unknown tenant is under the free plan
+	errNil(t, ValidateResourceName("acme", NamespaceResource, "acme-dev"))
+	assertErr(t, "invalid fields namespace", ValidateResourceName("acme", NamespaceResource, "dev"))
+	assertErr(t, "invalid fields namespace", ValidateResourceName("acme", NamespaceResource, "acme-development-1"))
+	errNil(t, ValidateResourceName("acme", TopicResource, "orders-2"))
+	err := ValidateResourceName("acme", TopicResource, "Orders")
+	var vErr *ValidationError
+	assert(t, errors.As(err, &vErr), "a validation error")
+	equals(t, "does not match the pattern [a-z][a-z0-9-]*", vErr.Fields[0].Reason)
+	// the pattern matches the whole name
+	assert(t, ValidateResourceName("acme", TopicResource, "orders.v2") != nil, "anchored pattern")
+
+	production := TenantPlan{Name: "naming-corp", PlanType: ProductionTier}
+	errNil(t, ValidatePlanResourceName(production, NamespaceResource, "dev"))
+	errNil(t, ValidatePlanResourceName(production, TopicResource, "abcd"))
+	assert(t, ValidatePlanResourceName(production, TopicResource, "abcde") != nil, "default policy max length")
+}
+
+func TestBacklogQuotaMonitor(t *testing.T) {
+	equals(t, 512, PlanBacklogQuotaMB(TenantPlan{PlanType: FreeTier}))
+	equals(t, -1, PlanBacklogQuotaMB(TenantPlan{PlanType: PrivateTier}))
+	equals(t, 300, PlanBacklogQuotaMB(TenantPlan{PlanType: FreeTier, Policy: PlanPolicy{BacklogQuotaMB: 300}}))
+
+	const mb = 1024 * 1024
+	backlogs := []metrics.TopicBacklog{
+		{Topic: "persistent://acme/ns1/t1", Namespace: "acme/ns1", BacklogBytes: 95 * mb, QuotaBytes: 100 * mb},
+		{Topic: "persistent://acme/ns1/t2", Namespace: "acme/ns1", BacklogBytes: 10 * mb, QuotaBytes: 100 * mb},
+		{Topic: "persistent://acme/ns2/t1", Namespace: "acme/ns2", BacklogBytes: 512 * mb, QuotaBytes: 512 * mb},
+		{Topic: "persistent://acme/ns3/t1", Namespace: "acme/ns3", BacklogBytes: 512 * mb},
+	}
+	now := time.Now()
+	statuses := DetectBacklogQuota(backlogs, 90, now)
+	equals(t, 2, len(statuses))
+	equals(t, []string{"persistent://acme/ns1/t1"}, statuses[0].OverQuotaTopics)
+	equals(t, "acme", statuses[0].Tenant)
+	equals(t, float64(95), statuses[0].MaxUsagePercent)
+
+	var quotaSet BacklogQuota
+	skipped := []string{}
+	broker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/backlogQuotaMap"):
+			w.Write([]byte(`{}`))
+		case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/backlogQuota"):
+			json.NewDecoder(r.Body).Decode(&quotaSet)
+			w.WriteHeader(http.StatusNoContent)
+		case strings.HasSuffix(r.URL.Path, "/subscriptions"):
+			w.Write([]byte(`["sub a","sub-b"]`))
+		case strings.HasSuffix(r.URL.Path, "/skip_all"):
+			skipped = append(skipped, r.URL.EscapedPath())
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer broker.Close()
+	brokerURL := util.Config.BrokerProxyURL
+	util.Config.BrokerProxyURL = broker.URL
+	defer func() { util.Config.BrokerProxyURL = brokerURL }()
+
+	results := RemediateBacklogQuota(statuses[0], []string{ExpandRemediation, SkipRemediation, "drop"})
+	equals(t, 3, len(results))
+	equals(t, "", results[0].Error)
+	equals(t, int64(200*mb), quotaSet.Limit)
+	equals(t, "producer_request_hold", quotaSet.Policy)
+	equals(t, "2 subscriptions skipped", results[1].Detail)
+	equals(t, "/admin/v2/persistent/acme/ns1/t1/subscription/sub%20a/skip_all", skipped[0])
+	assert(t, results[2].Error != "", "unknown remediation")
+
+	// the quota is capped by the free plan 512 MB
+	results = RemediateBacklogQuota(statuses[1], []string{ExpandRemediation})
+	equals(t, "backlog quota 536870912 bytes is at the plan limit", results[0].Error)
+
+	get := func() ([]metrics.TopicBacklog, error) { return backlogs, nil }
+	quotaSet = BacklogQuota{}
+	checked, err := CheckBacklogQuota(get, []string{ExpandRemediation}, now)
+	errNil(t, err)
+	equals(t, 1, len(checked[0].Remediations))
+	equals(t, int64(200*mb), quotaSet.Limit)
+	equals(t, 2, len(GetBacklogQuotaStatuses()))
+	// no remediation within the cooldown
+	checked, err = CheckBacklogQuota(get, []string{ExpandRemediation}, now.Add(time.Minute))
+	errNil(t, err)
+	equals(t, 0, len(checked[0].Remediations))
+}
+
+func TestTenantDeletionProtection(t *testing.T) {
+	existing := TenantPlan{Name: "ming-luo", PlanType: ProductionTier, TenantStatus: Activated, Protected: true}
+
+	// a protected tenant cannot be off-boarded by the plan update, even clearing the flag in the same request
+	_, err := ReconcileTenantPlan(TenantPlan{PlanType: ProductionTier, TenantStatus: Deleted}, existing)
+	assert(t, errors.Is(err, ErrTenantProtected), "protected tenant deletion")
+	_, err = ReconcileTenantPlan(TenantPlan{PlanType: ProductionTier, TenantStatus: Deleted, Protected: false}, existing)
+	assert(t, errors.Is(err, ErrTenantProtected), "protection is not cleared by the plan update")
+
+	// the plan update keeps the protection
+	plan, err := ReconcileTenantPlan(TenantPlan{PlanType: ProductionTier, Org: "datastax"}, existing)
+	errNil(t, err)
+	assert(t, plan.Protected, "protection kept")
+	plan, err = ReconcileTenantPlan(TenantPlan{PlanType: ProductionTier, Protected: true}, TenantPlan{Name: "victor", PlanType: FreeTier})
+	errNil(t, err)
+	assert(t, !plan.Protected, "protection is only set by a superuser")
+
+	// a cleared tenant can be deleted, and a new tenant can be created protected
+	existing.Protected = false
+	plan, err = ReconcileTenantPlan(TenantPlan{PlanType: ProductionTier, TenantStatus: Deleted}, existing)
+	errNil(t, err)
+	equals(t, Deleted, plan.TenantStatus)
+	plan, err = ReconcileTenantPlan(TenantPlan{PlanType: ProductionTier, Protected: true}, TenantPlan{})
+	errNil(t, err)
+	assert(t, plan.Protected, "created protected")
+
+	assert(t, !TenantManager.IsProtected("unknown-tenant"), "")
+}
+
+func TestTenantPlanQuery(t *testing.T) {
+	plans := []TenantPlan{
+		{Name: "acme-prod", Org: "Acme", PlanType: ProductionTier},
+		{Name: "acme-dev", Org: "acme", PlanType: FreeTier},
+		{Name: "acmeish", Org: "other", PlanType: FreeTier},
+		{Name: "victor", Org: "acme-labs", PlanType: StarterTier},
+	}
+	names := func(q TenantPlanQuery) []string {
+		selected, err := q.Filter(plans)
+		errNil(t, err)
+		result := []string{}
+		for _, p := range selected {
+			result = append(result, p.Name)
+		}
+		return result
+	}
+
+	equals(t, []string{"acme-dev", "acme-prod", "acmeish", "victor"}, names(TenantPlanQuery{}))
+	equals(t, []string{"acme-dev", "acme-prod"}, names(TenantPlanQuery{NameLike: "ACME-*"}))
+	equals(t, []string{"acme-dev"}, names(TenantPlanQuery{NameLike: "acme-?ev"}))
+	equals(t, []string{"acme-dev", "acme-prod"}, names(TenantPlanQuery{Org: "acme"}))
+	equals(t, []string{"acme-dev", "acme-prod", "victor"}, names(TenantPlanQuery{Org: "acme*"}))
+	equals(t, []string{"acme-dev"}, names(TenantPlanQuery{Org: "acme", PlanType: "Free"}))
+	equals(t, []string{"acme-prod", "acmeish"}, names(TenantPlanQuery{NameRegex: "acme(-prod|ish)"}))
+	// the regex matches the whole name and the wildcard has no regex syntax
+	equals(t, []string{}, names(TenantPlanQuery{NameRegex: "acme"}))
+	equals(t, []string{}, names(TenantPlanQuery{NameLike: "acme.prod"}))
+
+	_, err := TenantPlanQuery{NameRegex: "acme("}.Filter(plans)
+	assert(t, err != nil, "invalid regex")
+	_, err = TenantPlanQuery{NameLike: strings.Repeat("a", 257)}.Filter(plans)
+	assert(t, err != nil, "pattern too long")
+
+	selected, err := TenantManager.QueryTenants(TenantPlanQuery{Org: "acme"})
+	errNil(t, err)
+	equals(t, 0, len(selected))
+}
+
+func TestTenantSSO(t *testing.T) {
+	sso := &TenantSSO{EmailDomains: []string{"Acme.com", "*.acme-labs.io"}, IdPGroups: []string{"acme-admins"}}
+	errNil(t, ValidateTenantSSO(sso))
+	errNil(t, ValidateTenantSSO(nil))
+	err := ValidateTenantSSO(&TenantSSO{EmailDomains: []string{"*.com", "acme", "user@acme.com"}, IdPGroups: []string{""}})
+	vErr, ok := err.(*ValidationError)
+	assert(t, ok, "expect validation error")
+	equals(t, 4, len(vErr.Fields))
+	assert(t, ValidateTenantPlan(TenantPlan{PlanType: "free", SSO: &TenantSSO{EmailDomains: []string{"acme"}}}) != nil, "plan sso validated")
+
+	domain, err := EmailDomain("Alice@Eng.ACME-labs.io")
+	errNil(t, err)
+	equals(t, "eng.acme-labs.io", domain)
+	_, err = EmailDomain("alice@")
+	assert(t, err != nil, "invalid email")
+
+	plan := TenantPlan{Name: "acme-prod", SSO: sso}
+	equals(t, []string{MatchedByEmailDomain}, MatchTenantSSO(plan, "acme.com", nil))
+	equals(t, []string{MatchedByEmailDomain, MatchedByIdPGroup}, MatchTenantSSO(plan, domain, []string{"devs", "acme-admins"}))
+	// the wildcard only matches the subdomains, and the exact domain does not match the subdomains
+	equals(t, []string{}, MatchTenantSSO(plan, "acme-labs.io", nil))
+	equals(t, []string{}, MatchTenantSSO(plan, "eng.acme.com", []string{"Acme-Admins"}))
+	equals(t, []string{}, MatchTenantSSO(TenantPlan{Name: "victor"}, "acme.com", []string{"acme-admins"}))
+
+	// the plan update keeps the mapping unless it replaces it
+	reconciled, err := ReconcileTenantPlan(TenantPlan{PlanType: FreeTier}, plan)
+	errNil(t, err)
+	equals(t, sso, reconciled.SSO)
+	reconciled, err = ReconcileTenantPlan(TenantPlan{PlanType: FreeTier, SSO: &TenantSSO{}}, plan)
+	errNil(t, err)
+	equals(t, &TenantSSO{}, reconciled.SSO)
+
+	tenants, err := TenantManager.ResolveSSOTenants("alice@acme.com", []string{"acme-admins"})
+	errNil(t, err)
+	equals(t, []SSOTenant{}, tenants)
+	_, err = TenantManager.ResolveSSOTenants("alice", nil)
+	assert(t, err != nil, "invalid email")
+}
+
+func TestUsageReportNotice(t *testing.T) {
+	assert(t, !(&NotificationPreferences{}).WantsNotice(notification.UsageReport), "usage report is opt in")
+	assert(t, (&NotificationPreferences{UsageReport: true}).WantsNotice(notification.UsageReport), "")
+	var none *NotificationPreferences
+	assert(t, !none.WantsNotice(notification.UsageReport), "no preferences")
+
+	from := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	report := metrics.BuildUsageReport("ming-luo", nil, nil, from, from.Add(DefaultUsageReportPeriod), time.Now())
+	notice, err := UsageReportNotice(report)
+	errNil(t, err)
+	equals(t, notification.UsageReport, notice.Kind)
+	equals(t, "tenant ming-luo usage report from 2021-03-01 to 2021-03-08", notice.Subject)
+	assert(t, strings.HasPrefix(notice.Message, "tenant,from,to,metric"), "csv report in the message")
+
+	var data metrics.UsageReport
+	errNil(t, json.Unmarshal(notice.Data, &data))
+	equals(t, "ming-luo", data.Tenant)
+	equals(t, len(metrics.UsageMetrics), len(data.Metrics))
+}
+
+func TestPlanChangeRules(t *testing.T) {
+	prev := TenantPlan{Name: "rules-tenant", TenantStatus: Activated, PlanType: FreeTier, Policy: PlanPolicy{FeatureCodes: "a"}}
+	next := prev
+	next.Version = 3
+	next.UpdatedAt = time.Now()
+	equals(t, 0, len(PlanFieldChanges(prev, next)))
+	next.TenantStatus = Suspended
+	next.Policy.FeatureCodes = "a,b"
+	next.Metadata = map[string]string{"crm": "42"}
+	equals(t, []string{"metadata", "policy.featureCodes", "tenantStatus"}, PlanFieldChanges(prev, next))
+
+	assert(t, MatchChangedFields([]string{"featureCodes"}, []string{"policy.featureCodes"}), "match the last name")
+	assert(t, MatchChangedFields([]string{"policy"}, []string{"policy.featureCodes"}), "match a nested field")
+	assert(t, !MatchChangedFields([]string{"tenantStatus"}, []string{"policy.featureCodes"}), "no match")
+	assert(t, !MatchChangedFields(nil, []string{}), "no change")
+
+	assert(t, SetPlanChangeRules([]util.PlanChangeRule{{Name: "r1"}}) != nil, "webhook is required")
+	assert(t, SetPlanChangeRules([]util.PlanChangeRule{{Name: "r1", Webhooks: []string{"http://h"}, Types: []string{"created"}}}) != nil, "unknown type")
+
+	posted := make(chan notification.Notice, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var notice notification.Notice
+		json.NewDecoder(r.Body).Decode(&notice)
+		posted <- notice
+	}))
+	defer server.Close()
+	errNil(t, SetPlanChangeRules([]util.PlanChangeRule{
+		{Name: "status", Fields: []string{"tenantStatus"}, Webhooks: []string{server.URL}},
+		{Name: "other-tenant", Tenant: "other", Webhooks: []string{server.URL}},
+		{Name: "deleted", Types: []string{PlanDeleted}, Webhooks: []string{server.URL}},
+	}))
+	defer SetPlanChangeRules(nil)
+
+	event := TenantPlanEvent{Type: PlanUpdated, Tenant: "rules-tenant", Plan: next, Time: time.Now(), Changes: PlanFieldChanges(prev, next)}
+	equals(t, 1, DispatchPlanChangeRules(event))
+	select {
+	case notice := <-posted:
+		equals(t, notification.PlanChange, notice.Kind)
+		var data TenantPlanEvent
+		errNil(t, json.Unmarshal(notice.Data, &data))
+		equals(t, "rules-tenant", data.Tenant)
+		assert(t, strings.Contains(notice.Message, "tenantStatus"), notice.Message)
+	case <-time.After(5 * time.Second):
+		t.Fatal("plan change is not posted")
+	}
+	// repeated changes are not suppressed
+	equals(t, 1, DispatchPlanChangeRules(event))
+	event.Changes = []string{"org"}
+	equals(t, 0, DispatchPlanChangeRules(event))
+
+	stats := PlanChangeRules()
+	equals(t, 3, len(stats))
+	equals(t, uint64(2), stats[0].Matched)
+	equals(t, uint64(0), stats[1].Matched)
+}
+
+func TestSyntheticProbe(t *testing.T) {
+	errNil(t, BuildFeatureCodeMap())
+	equals(t, "persistent://probe-tenant/default/burnell-heartbeat", HeartbeatTopic("probe-tenant"))
+
+	day := time.Date(2024, 6, 3, 10, 0, 0, 0, time.UTC)
+	probed := []string{}
+	probe := func(topic string, timeout time.Duration) (time.Duration, error) {
+		probed = append(probed, topic)
+		if len(probed) == 3 {
+			return 0, errors.New("consume probe message context deadline exceeded")
+		}
+		return time.Duration(len(probed)) * 10 * time.Millisecond, nil
+	}
+	status := ProbeTenant("probe-tenant", probe, time.Second, day)
+	assert(t, status.Success, "successful probe")
+	equals(t, float64(10), status.LatencyMs)
+	ProbeTenant("probe-tenant", probe, time.Second, day.Add(time.Minute))
+	status = ProbeTenant("probe-tenant", probe, time.Second, day.Add(2*time.Minute))
+	assert(t, !status.Success, "failed probe")
+	equals(t, uint64(3), status.Probes)
+	equals(t, uint64(1), status.Failures)
+	equals(t, day.Add(time.Minute), status.LastSuccessAt)
+	equals(t, []string{HeartbeatTopic("probe-tenant")}, probed[:1])
+
+	ProbeTenant("other-tenant", probe, time.Second, day)
+	equals(t, 2, len(GetProbeStatuses("")))
+	equals(t, "other-tenant", GetProbeStatuses("")[0].Tenant)
+	equals(t, 1, len(GetProbeStatuses("probe-tenant")))
+
+	// the probes are the SLA evidence of the tenant
+	report, err := metrics.GetTenantSLAReport("probe-tenant", "2024-06")
+	errNil(t, err)
+	equals(t, uint64(3), report.Probes)
+	equals(t, uint64(1), report.ProbeFailures)
+	assert(t, report.ProbeSuccessRate > 66 && report.ProbeSuccessRate < 67, "probe success rate")
+	equals(t, float64(15), report.ProbeAvgLatencyMs)
+
+	// the tenants without the feature are no longer reported
+	ProbeTenants(probe, time.Second)
+	equals(t, 0, len(GetProbeStatuses("")))
+}
+
+func TestBundleAdvisor(t *testing.T) {
+	loads, err := metrics.AggregateBundleLoads([]byte(`# TYPE pulsar_bundle_msg_rate_in gauge
+pulsar_bundle_msg_rate_in{cluster="c1",broker="b1",bundle="acme/ns1/0x00000000_0x80000000"} 40000
+pulsar_bundle_msg_rate_out{cluster="c1",broker="b1",bundle="acme/ns1/0x00000000_0x80000000"} 20000
+pulsar_bundle_topics_count{cluster="c1",broker="b1",bundle="acme/ns1/0x00000000_0x80000000"} 20
+pulsar_bundle_topics_count{cluster="c1",broker="b2",bundle="acme/ns1/0x80000000_0xffffffff"} 1500
+pulsar_bundle_msg_rate_in{cluster="c1",broker="b2",bundle="acme/ns2/0x00000000_0xffffffff"} 90000
+pulsar_bundle_topics_count{cluster="c1",broker="b2",bundle="acme/ns2/0x00000000_0xffffffff"} 1
+pulsar_bundle_consumer_count{cluster="c1",broker="b2",bundle="beta/ns/0x00000000_0xffffffff"} 10
+`))
+	errNil(t, err)
+	equals(t, 4, len(loads))
+	equals(t, "acme/ns1", loads[0].Namespace)
+	equals(t, "0x00000000_0x80000000", loads[0].Range)
+	equals(t, float64(20000), loads[0].MsgRateOut)
+
+	thresholds := BundleSplitThresholds{MsgRate: 30000, Topics: 1000, Sessions: 1000, MaxBundles: 128}
+	advices := AdviseBundleSplits(loads, thresholds)
+	// the single topic bundle of acme/ns2 can't be divided, and beta/ns is under the thresholds
+	equals(t, 2, len(advices))
+	equals(t, "acme/ns1/0x00000000_0x80000000", advices[0].Bundle)
+	equals(t, RangeEquallyDivide, advices[0].Algorithm)
+	equals(t, float64(2), advices[0].Severity)
+	equals(t, "/admin/v2/namespaces/acme/ns1/0x00000000_0x80000000/split?unload=true&splitAlgorithmName=range_equally_divide", advices[0].SplitPath)
+	equals(t, TopicCountEquallyDivide, advices[1].Algorithm)
+	equals(t, "acme", advices[1].Tenant)
+	thresholds.MaxBundles = 2
+	equals(t, 0, len(AdviseBundleSplits(loads, thresholds)))
+
+	errNil(t, ValidateBundleRange("0x00000000_0x80000000"))
+	assert(t, ValidateBundleRange("0x80000000_0x00000000") != nil, "reversed range")
+	assert(t, ValidateBundleRange("0x0_0x8") != nil, "short range")
+
+	broker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/admin/v2/namespaces/acme/ns1/bundles" {
+			w.Write([]byte(`{"boundaries":["0x00000000","0x80000000","0xffffffff"],"numBundles":2}`))
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer broker.Close()
+	brokerURL := util.Config.BrokerProxyURL
+	util.Config.BrokerProxyURL = broker.URL
+	defer func() { util.Config.BrokerProxyURL = brokerURL }()
+
+	status, err := ValidateBundleOperation("acme/ns1", "0x80000000_0xffffffff", true, TopicCountEquallyDivide)
+	errNil(t, err)
+	equals(t, http.StatusOK, status)
+	status, _ = ValidateBundleOperation("acme/ns1", "0x00000000_0xffffffff", false, "")
+	equals(t, http.StatusNotFound, status)
+	status, _ = ValidateBundleOperation("acme/ns1", "0x00000000_0x80000000", true, "random")
+	equals(t, http.StatusUnprocessableEntity, status)
+	status, _ = ValidateBundleOperation("acme/ns9", "0x00000000_0x80000000", false, "")
+	equals(t, http.StatusBadGateway, status)
+}
+
+func TestComparePlans(t *testing.T) {
+	comparison, err := ComparePlans("Starter", "production", false)
+	errNil(t, err)
+	equals(t, "starter", comparison.From)
+	assert(t, comparison.Upgrade, "starter to production is an upgrade")
+	limits := map[string]PlanLimitDiff{}
+	for _, l := range comparison.Limits {
+		limits[l.Field] = l
+	}
+	equals(t, PlanLimitDiff{Field: "policy.numOfTopics", From: 20, To: 100, Change: LimitIncreased}, limits["policy.numOfTopics"])
+	equals(t, int64(14*24), limits["policy.messageHourRetention"].To)
+	_, ok := limits["policy.messageRetention"]
+	assert(t, !ok, "message retention is compared in hours")
+	equals(t, 0, len(comparison.Features.Added))
+
+	comparison, err = ComparePlans(PrivateTier, DedicatedTier, true)
+	errNil(t, err)
+	assert(t, !comparison.Upgrade, "private to dedicated is a downgrade")
+	limits = map[string]PlanLimitDiff{}
+	for _, l := range comparison.Limits {
+		limits[l.Field] = l
+	}
+	equals(t, LimitDecreased, limits["policy.functions"].Change)
+	equals(t, len(KafkaesqueFeatureCodes), len(comparison.Features.Removed))
+
+	comparison, err = ComparePlans(DedicatedTier, PrivateTier, false)
+	errNil(t, err)
+	assert(t, comparison.Upgrade, "dedicated to private is an upgrade")
+	equals(t, LimitIncreased, comparison.Limits[0].Change)
+
+	// the unchanged limits are only included with all
+	comparison, err = ComparePlans(FreeTier, FreeTier, false)
+	errNil(t, err)
+	equals(t, 0, len(comparison.Limits))
+	comparison, err = ComparePlans(FreeTier, FreeTier, true)
+	errNil(t, err)
+	assert(t, len(comparison.Limits) > 0, "all limits")
+
+	_, err = ComparePlans(FreeTier, "gold", false)
+	assert(t, err != nil, "unknown plan type")
+}
+
+func TestTenantEvents(t *testing.T) {
+	start := time.Now().Add(-time.Hour)
+	RecordTenantEvent(TenantEvent{Time: start, Tenant: "events-tenant", Category: PlanEventCategory, Type: PlanUpdated, Summary: "tenant plan updated", Changes: []string{"policy.numOfTopics"}})
+	RecordTenantEvent(TenantEvent{Time: start.Add(time.Minute), Tenant: "events-tenant", Category: QuotaEventCategory, Type: "quota-warning", Summary: "plan quota limit reached"})
+	// a repeated event within a minute is counted
+	RecordTenantEvent(TenantEvent{Time: start.Add(time.Minute + time.Second), Tenant: "events-tenant", Category: QuotaEventCategory, Type: "quota-warning", Summary: "plan quota limit reached"})
+	RecordTenantEvent(TenantEvent{Time: start.Add(2 * time.Minute), Tenant: "events-tenant", Category: TokenEventCategory, Type: "exchange-token", Summary: "client token issued"})
+	RecordTenantEvent(TenantEvent{Time: start, Tenant: "another-tenant", Category: TokenEventCategory, Type: "mint-token"})
+	RecordTenantEvent(TenantEvent{Category: TokenEventCategory, Type: "mint-token"})
+
+	events := TenantEvents("events-tenant", time.Time{}, nil, 0)
+	equals(t, 3, len(events))
+	equals(t, PlanEventCategory, events[0].Category)
+	equals(t, []string{"policy.numOfTopics"}, events[0].Changes)
+	equals(t, 2, events[1].Count)
+	equals(t, start.Add(time.Minute+time.Second), events[1].Time)
+
+	// the since time is exclusive and the limit returns the oldest events after it
+	events = TenantEvents("events-tenant", start, nil, 1)
+	equals(t, 1, len(events))
+	equals(t, QuotaEventCategory, events[0].Category)
+	events = TenantEvents("events-tenant", events[0].Time, nil, 1)
+	equals(t, TokenEventCategory, events[0].Category)
+
+	events = TenantEvents("events-tenant", time.Time{}, []string{TokenEventCategory, PlanEventCategory}, 0)
+	equals(t, 2, len(events))
+	equals(t, 0, len(TenantEvents("no-such-tenant", time.Time{}, nil, 0)))
+}
+
+func TestRecommendPlans(t *testing.T) {
+	now := time.Date(2020, 7, 1, 12, 0, 0, 0, time.UTC)
+	series := func(producers, consumers uint64, n int) []metrics.Usage {
+		samples := []metrics.Usage{}
+		for i := n; i > 0; i-- {
+			samples = append(samples, metrics.Usage{Producers: producers, Consumers: consumers, UpdatedAt: now.Add(-time.Duration(i) * 10 * time.Minute)})
+		}
+		return samples
+	}
+	history := map[string][]metrics.Usage{
+		// the free plan capacity is 3 producers per topic of 5 topics
+		"busy":     series(15, 2, 12),
+		"idle":     series(0, 0, 12),
+		"steady":   series(300, 100, 12),
+		"rejected": series(10, 10, 2),
+		"unknown":  series(0, 0, 12),
+	}
+	tenants := []TenantPlan{
+		{Name: "steady", PlanType: StarterTier, Policy: TenantPlanPolicies.StarterPlan},
+		{Name: "busy", Org: "acme", PlanType: FreeTier, Policy: TenantPlanPolicies.FreePlan},
+		{Name: "idle", PlanType: ProductionTier, Policy: TenantPlanPolicies.ProductionPlan},
+		{Name: "rejected", PlanType: StarterTier, Policy: TenantPlanPolicies.StarterPlan},
+		{Name: "unknown", PlanType: "bogus"},
+	}
+	quotaEvents := func(tenant string, since time.Time) int {
+		if tenant == "rejected" {
+			return 10
+		}
+		return 0
+	}
+	thresholds := PlanRecommendationThresholds{Window: 24 * time.Hour, MinSamples: 12, OverPercent: 90, UnderPercent: 20, ConsistentPercent: 80, QuotaEvents: 10}
+	recs := RecommendPlans(tenants, func(tenant string, from, to time.Time) []metrics.Usage {
+		equals(t, now.Add(-24*time.Hour), from)
+		return history[tenant]
+	}, quotaEvents, thresholds, now)
+
+	equals(t, 3, len(recs))
+	equals(t, "busy", recs[0].Tenant)
+	equals(t, "acme", recs[0].Org)
+	equals(t, RecommendUpgrade, recs[0].Action)
+	equals(t, StarterTier, recs[0].RecommendedPlanType)
+	equals(t, 12, recs[0].Samples)
+	equals(t, metrics.Producers, recs[0].Findings[0].Metric)
+	equals(t, float64(100), recs[0].Findings[0].PeakPercent)
+	equals(t, float64(100), recs[0].Findings[0].OverSamplesPercent)
+
+	equals(t, "idle", recs[1].Tenant)
+	equals(t, RecommendDowngrade, recs[1].Action)
+	equals(t, FreeTier, recs[1].RecommendedPlanType)
+
+	// too few samples but rejected by the quota
+	equals(t, "rejected", recs[2].Tenant)
+	equals(t, RecommendUpgrade, recs[2].Action)
+	equals(t, ProductionTier, recs[2].RecommendedPlanType)
+	equals(t, 10, recs[2].QuotaEvents)
+
+	// the quota events are ignored at 0, and the unlimited private plan never goes over
+	thresholds.QuotaEvents = 0
+	recs = RecommendPlans([]TenantPlan{tenants[3], {Name: "busy", PlanType: PrivateTier, Policy: TenantPlanPolicies.PrivatePlan}},
+		func(tenant string, from, to time.Time) []metrics.Usage { return history[tenant] }, quotaEvents, thresholds, now)
+	equals(t, 0, len(recs))
+}
+
+func TestDeletedTenantReconciler(t *testing.T) {
+	h, err := burnelltest.New(burnelltest.Options{Tenants: []TenantPlan{
+		{Name: "recon-report", PlanType: FreeTier},
+		{Name: "recon-live", PlanType: FreeTier},
+		{Name: "recon-partial", PlanType: FreeTier},
+	}})
+	errNil(t, err)
+	defer h.Close()
+	defer SetDeletedTenantReconcile(0, 0)
+
+	for _, tenant := range []string{"recon-report", "recon-live", "recon-partial"} {
+		h.Admin.AddNamespace(tenant, "ns1")
+		h.Admin.SetResponse(http.MethodGet, "/admin/v2/namespaces/"+tenant+"/ns1/topics", http.StatusOK, []string{"persistent://" + tenant + "/ns1/t1"})
+	}
+	// the deletion record is consumed back from the tenant topic
+	track := func(tenant string) {
+		data, _ := json.Marshal(TenantPlan{Name: tenant, PlanType: FreeTier, TenantStatus: Deleted, UpdatedAt: time.Now().Add(-time.Minute)})
+		TenantManager.ApplyTenantRecord(data)
+	}
+	deleteTenant := func(tenant string) {
+		_, err := TenantManager.DeleteTenant(tenant)
+		errNil(t, err)
+		track(tenant)
+	}
+	resources := func() map[string]DeletedTenantResources {
+		m := make(map[string]DeletedTenantResources)
+		for _, r := range GetDeletedTenantResources() {
+			m[r.Tenant] = r
+		}
+		return m
+	}
+	deletes := func() []string {
+		requests := []string{}
+		for _, r := range h.Admin.Requests() {
+			if strings.HasPrefix(r, http.MethodDelete) {
+				requests = append(requests, r)
+			}
+		}
+		return requests
+	}
+
+	// without the force removal the leftovers are only reported
+	SetDeletedTenantReconcile(time.Minute, 0)
+	deleteTenant("recon-report")
+	ReconcileDeletedTenants()
+	report := resources()["recon-report"]
+	equals(t, []string{"recon-report/ns1"}, report.Namespaces)
+	equals(t, []string{"persistent://recon-report/ns1/t1"}, report.Topics)
+	assert(t, report.ForceRemoveAt.IsZero(), "no force removal")
+	equals(t, 0, len(deletes()))
+
+	// nor before the grace period is over
+	SetDeletedTenantReconcile(time.Minute, time.Hour)
+	track("recon-report")
+	ReconcileDeletedTenants()
+	assert(t, !resources()["recon-report"].ForceRemoveAt.IsZero(), "force removal scheduled")
+	equals(t, 0, len(deletes()))
+
+	// a recreated tenant is no longer tracked and never force removed
+	SetDeletedTenantReconcile(time.Minute, time.Nanosecond)
+	deleteTenant("recon-live")
+	req, _ := http.NewRequest(http.MethodPut, h.URL+"/admin/v2/tenants/recon-live", strings.NewReader(`{"allowedClusters":["standalone"]}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	errNil(t, err)
+	resp.Body.Close()
+	equals(t, http.StatusNoContent, resp.StatusCode)
+	_, ok := resources()["recon-live"]
+	assert(t, !ok, "recreated tenant is not tracked")
+	assert(t, !TenantManager.IsDeletedTenant("recon-live"), "recreated tenant is live")
+	_, err = TenantManager.GetTenant("recon-live")
+	errNil(t, err)
+	plans := h.Store.Records()
+	equals(t, Activated, plans[len(plans)-1].TenantStatus)
+
+	// a failed force removal is kept with the error and retried, while the others are force removed
+	track("recon-report")
+	h.Admin.SetResponse(http.MethodDelete, "/admin/v2/tenants/recon-partial", http.StatusInternalServerError, nil)
+	deleteTenant("recon-partial")
+	ReconcileDeletedTenants()
+	partial := resources()["recon-partial"]
+	assert(t, partial.Error != "", "force removal error")
+	equals(t, []string{"recon-partial/ns1"}, partial.Namespaces)
+	_, ok = resources()["recon-report"]
+	assert(t, !ok, "force removed tenant is not tracked")
+	for _, r := range deletes() {
+		assert(t, !strings.Contains(r, "recon-live"), "recreated tenant is not removed "+r)
+	}
+	ReconcileDeletedTenants()
+	_, ok = resources()["recon-partial"]
+	assert(t, !ok, "no left over namespace")
+}
+
+func TestWatchTenantPlan(t *testing.T) {
+	h, err := burnelltest.New(burnelltest.Options{})
+	errNil(t, err)
+	defer h.Close()
+	apply := func(plan TenantPlan) {
+		data, _ := json.Marshal(plan)
+		TenantManager.ApplyTenantRecord(data)
+	}
+	next := func(events <-chan TenantPlanEvent) TenantPlanEvent {
+		select {
+		case event := <-events:
+			return event
+		case <-time.After(5 * time.Second):
+			t.Fatal("no tenant plan event")
+		}
+		return TenantPlanEvent{}
+	}
+
+	events, unsubscribe := WatchTenantPlan("watch-tenant")
+	all, unsubscribeAll := WatchTenantPlan("")
+	status, unsubscribeStatus := WatchTenantPlanFields("watch-tenant", []string{"tenantStatus"})
+	defer unsubscribeAll()
+	defer unsubscribeStatus()
+
+	// the tenant watcher ignores the other tenants, the firehose receives all
+	apply(TenantPlan{Name: "watch-other", PlanType: FreeTier})
+	apply(TenantPlan{Name: "watch-tenant", PlanType: FreeTier})
+	event := next(events)
+	equals(t, PlanUpdated, event.Type)
+	equals(t, "watch-tenant", event.Tenant)
+	equals(t, "watch-other", next(all).Tenant)
+	equals(t, "watch-tenant", next(all).Tenant)
+
+	// the field watcher only receives the events changing the fields
+	apply(TenantPlan{Name: "watch-tenant", PlanType: FreeTier, Metadata: map[string]string{"team": "a"}})
+	equals(t, []string{"metadata"}, next(events).Changes)
+	apply(TenantPlan{Name: "watch-tenant", PlanType: FreeTier, Metadata: map[string]string{"team": "a"}, TenantStatus: Suspended})
+	equals(t, []string{"tenantStatus"}, next(status).Changes)
+	equals(t, []string{"tenantStatus"}, next(events).Changes)
+
+	apply(TenantPlan{Name: "watch-tenant", PlanType: FreeTier, TenantStatus: Deleted})
+	equals(t, PlanDeleted, next(events).Type)
+	equals(t, PlanDeleted, next(status).Type)
+
+	// the events are dropped for a slow watcher instead of blocking the listener
+	unsubscribe()
+	slow, unsubscribeSlow := WatchTenantPlan("watch-slow")
+	defer unsubscribeSlow()
+	for i := 0; i < 20; i++ {
+		apply(TenantPlan{Name: "watch-slow", PlanType: FreeTier, Metadata: map[string]string{"i": strconv.Itoa(i)}})
+	}
+	equals(t, 16, len(slow))
+	equals(t, 0, len(events))
+	equals(t, 0, len(status))
+}
+
+func TestExpirationNotice(t *testing.T) {
+	assert(t, (&NotificationPreferences{Expiration: true}).WantsNotice(notification.Expiration), "")
+	assert(t, !(&NotificationPreferences{}).WantsNotice(notification.Expiration), "expiration notice is opt in")
+
+	posted := make(chan notification.Notice, 4)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var notice notification.Notice
+		json.NewDecoder(r.Body).Decode(&notice)
+		posted <- notice
+	}))
+	defer server.Close()
+
+	now := time.Now()
+	soon, later := now.Add(24*time.Hour), now.Add(30*24*time.Hour)
+	contacts := &TenantContacts{Webhooks: []string{server.URL}}
+	h, err := burnelltest.New(burnelltest.Options{Tenants: []TenantPlan{
+		{Name: "expiring-trial", PlanType: FreeTier, ExpiresAt: &soon, Contacts: contacts, Notifications: &NotificationPreferences{Expiration: true}},
+		{Name: "expiring-later", PlanType: FreeTier, ExpiresAt: &later, Contacts: contacts, Notifications: &NotificationPreferences{Expiration: true}},
+		{Name: "expiring-optout", PlanType: FreeTier, ExpiresAt: &soon, Contacts: contacts, Notifications: &NotificationPreferences{}},
+	}})
+	errNil(t, err)
+	defer h.Close()
+
+	// the expiry is kept by a plan update without it
+	plan, err := ReconcileTenantPlan(TenantPlan{Name: "expiring-trial", PlanType: FreeTier}, TenantPlan{Name: "expiring-trial", PlanType: FreeTier, ExpiresAt: &soon})
+	errNil(t, err)
+	equals(t, soon, *plan.ExpiresAt)
+
+	TrackTokenExpiry("expiring-trial", "expiring-trial-client-abc", now.Add(2*time.Hour))
+	TrackTokenExpiry("expiring-trial", "expiring-trial-client-old", now.Add(-time.Hour))
+	TrackTokenExpiry("expiring-trial", "expiring-trial-client-long", later)
+
+	equals(t, 2, TenantManager.CheckExpirations(now, 72*time.Hour))
+	subjects := []string{}
+	for i := 0; i < 2; i++ {
+		select {
+		case notice := <-posted:
+			equals(t, notification.Expiration, notice.Kind)
+			equals(t, "expiring-trial", notice.Tenant)
+			subjects = append(subjects, notice.Subject)
+		case <-time.After(5 * time.Second):
+			t.Fatal("expiration notice is not posted")
+		}
+	}
+	sort.Strings(subjects)
+	assert(t, strings.HasPrefix(subjects[0], "tenant expiring-trial free plan expires at"), subjects[0])
+	assert(t, strings.HasPrefix(subjects[1], "tenant expiring-trial token of subject expiring-trial-client-abc expires at"), subjects[1])
+
+	// an expiry is notified once, and again once it is changed
+	equals(t, 0, TenantManager.CheckExpirations(now.Add(time.Minute), 72*time.Hour))
+	TrackTokenExpiry("expiring-trial", "expiring-trial-client-abc", now.Add(3*time.Hour))
+	equals(t, 1, TenantManager.CheckExpirations(now.Add(time.Minute), 72*time.Hour))
+	<-posted
+	// the later expiry is notified within the lead time
+	equals(t, 2, TenantManager.CheckExpirations(later.Add(-time.Hour), 72*time.Hour))
+}
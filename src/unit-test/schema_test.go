@@ -0,0 +1,86 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/datastax/burnell/src/route"
+	. "github.com/datastax/burnell/src/schema"
+)
+
+func TestTenantPlanSchema(t *testing.T) {
+	s, ok := Get(TenantPlan)
+	assert(t, ok, "tenant plan schema must be embedded")
+
+	errNil(t, s.Validate([]byte(`{"planType": "Free", "policy": {"numOfTopics": 10, "functions": -1}, "contacts": {"emails": ["ops@example.com"]}}`)))
+
+	err := s.Validate([]byte(`{"planType": "gold", "policy": {"numOfTopics": "ten", "numOfNamespaces": -2}, "notifications": {"quotaWarning": 1}}`))
+	errs, ok := err.(Errors)
+	assert(t, ok, "expect schema errors")
+	equals(t, 4, len(errs))
+	equals(t, "$.notifications.quotaWarning", errs[0].Path)
+	equals(t, "$.planType", errs[1].Path)
+	equals(t, "$.policy.numOfNamespaces", errs[2].Path)
+	equals(t, "$.policy.numOfTopics", errs[3].Path)
+	equals(t, "must be integer but is string", errs[3].Reason)
+
+	err = s.Validate([]byte(`{"planType": `))
+	assert(t, err != nil, "invalid JSON")
+}
+
+func TestSchemaKeywords(t *testing.T) {
+	s, err := Parse(`{"type": "object", "additionalProperties": false, "required": ["limit"],
+		"properties": {"limit": {"type": "integer", "minimum": 1}, "tags": {"type": "array", "maxItems": 1, "items": {"enum": ["a", "b"]}}}}`)
+	errNil(t, err)
+	errNil(t, s.Validate([]byte(`{"limit": 3, "tags": ["a"]}`)))
+
+	errs := s.Validate([]byte(`{"tags": ["c", "a"], "extra": true}`)).(Errors)
+	equals(t, 4, len(errs))
+	equals(t, Error{Path: "$.limit", Reason: "is required"}, errs[0])
+	equals(t, "$.extra", errs[1].Path)
+	equals(t, "$.tags", errs[2].Path)
+	equals(t, "$.tags[0]", errs[3].Path)
+
+	errs = s.Validate([]byte(`{"limit": 1.5}`)).(Errors)
+	equals(t, "must be integer but is number", errs[0].Reason)
+}
+
+func TestValidateBodyMiddleware(t *testing.T) {
+	called := false
+	handler := route.ValidateBody(RateLimits, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPut, "/admin/ratelimits", strings.NewReader(`{"limit": 0}`)))
+	equals(t, http.StatusBadRequest, rr.Code)
+	assert(t, strings.Contains(rr.Body.String(), `"field":"$.limit"`), rr.Body.String())
+	assert(t, !called, "invalid body must not reach the handler")
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPut, "/admin/ratelimits", strings.NewReader(`{"limit": 10}`)))
+	assert(t, called, "valid body must reach the handler")
+}
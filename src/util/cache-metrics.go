@@ -0,0 +1,79 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package util
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// the names of the in-memory caches
+const (
+	FunctionCache = "functions"
+	TenantCache   = "tenants"
+	// IdempotencyCache is the stored responses of the idempotency keys
+	IdempotencyCache = "idempotency"
+)
+
+// the cache eviction reasons
+const (
+	EvictedLRU     = "lru"
+	EvictedDeleted = "deleted"
+	EvictedExpired = "expired"
+)
+
+var (
+	cacheEntriesGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "burnell",
+		Subsystem: "cache",
+		Name:      "entries",
+		Help:      "The number of entries in the in-memory cache.",
+	}, []string{"cache"})
+	cacheBytesGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "burnell",
+		Subsystem: "cache",
+		Name:      "estimated_bytes",
+		Help:      "The estimated memory of the in-memory cache entries by their JSON size.",
+	}, []string{"cache"})
+	cacheEvictionsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "burnell",
+		Subsystem: "cache",
+		Name:      "evictions_total",
+		Help:      "The number of entries evicted from the in-memory cache.",
+	}, []string{"cache", "reason"})
+)
+
+func init() {
+	prometheus.MustRegister(cacheEntriesGauge, cacheBytesGauge, cacheEvictionsCounter)
+}
+
+// CacheSize records the number of entries and the estimated bytes of a cache
+func CacheSize(cache string, entries, bytes int) {
+	cacheEntriesGauge.WithLabelValues(cache).Set(float64(entries))
+	cacheBytesGauge.WithLabelValues(cache).Set(float64(bytes))
+}
+
+// CacheEvicted records the number of entries evicted from a cache for the reason
+func CacheEvicted(cache, reason string, n int) {
+	if n > 0 {
+		cacheEvictionsCounter.WithLabelValues(cache, reason).Add(float64(n))
+	}
+}
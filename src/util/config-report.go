@@ -0,0 +1,185 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package util
+
+import (
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// the sources of a configuration value
+const (
+	EnvSource     = "env"
+	FileSource    = "file"
+	DefaultSource = "default"
+)
+
+// RedactedValue replaces a secret in the configuration report
+const RedactedValue = "****"
+
+// ConfigFieldReport is the effective value of a configuration field and its source
+type ConfigFieldReport struct {
+	Name     string      `json:"name"`
+	Value    interface{} `json:"value"`
+	Source   string      `json:"source"`
+	Redacted bool        `json:"redacted,omitempty"`
+}
+
+// EnvTunable is an environment variable tunable read by GetEnvInt
+type EnvTunable struct {
+	Name    string `json:"name"`
+	Value   int    `json:"value"`
+	Default int    `json:"default"`
+	Source  string `json:"source"`
+}
+
+// ConfigReport is the effective configuration with the secrets redacted
+type ConfigReport struct {
+	File        string              `json:"file"`
+	ProcessMode string              `json:"processMode"`
+	Fields      []ConfigFieldReport `json:"fields"`
+	Tunables    []EnvTunable        `json:"tunables"`
+}
+
+var (
+	loadedConfigFile string
+	configSources    = make(map[string]string)
+	envTunables      = make(map[string]EnvTunable)
+	configLock       = sync.RWMutex{}
+
+	// a secret key is redacted unless it is the name or the file of the secret
+	secretConfigKey    = regexp.MustCompile(`(?i)(secret|password|privatekey|token$|encryptionkeys|webhooks?$|routingkeys?$|authorization)`)
+	nonSecretConfigKey = regexp.MustCompile(`(?i)(file|name)$`)
+	// the password in a URL and the secret query parameters in any value
+	urlPassword    = regexp.MustCompile(`(://[^:/@\s]+):[^@/\s]+@`)
+	urlSecretQuery = regexp.MustCompile(`(?i)([?&](?:token|secret|password|key)=)[^&\s,]+`)
+)
+
+// configFieldSource returns file if the field is in the configuration file, otherwise default
+func configFieldSource(field reflect.StructField, fileKeys map[string]interface{}) string {
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	if name == "" {
+		name = field.Name
+	}
+	for k := range fileKeys {
+		if strings.EqualFold(k, name) {
+			return FileSource
+		}
+	}
+	return DefaultSource
+}
+
+func setConfigSources(file string, sources map[string]string) {
+	configLock.Lock()
+	defer configLock.Unlock()
+	loadedConfigFile = file
+	configSources = sources
+}
+
+// recordEnvTunable records the value of an environment variable tunable
+func recordEnvTunable(name string, value, defaultValue int, source string) {
+	configLock.Lock()
+	defer configLock.Unlock()
+	envTunables[name] = EnvTunable{Name: name, Value: value, Default: defaultValue, Source: source}
+}
+
+func isSecretConfigKey(key string) bool {
+	return secretConfigKey.MatchString(key) && !nonSecretConfigKey.MatchString(key)
+}
+
+func isEmptyConfigValue(v interface{}) bool {
+	switch value := v.(type) {
+	case nil:
+		return true
+	case string:
+		return value == ""
+	case []interface{}:
+		return len(value) == 0
+	case map[string]interface{}:
+		return len(value) == 0
+	}
+	return false
+}
+
+// RedactConfigValue redacts the value of a secret key, the secret keys nested in the value,
+// and the URL passwords and the secret query parameters in the strings. It returns true if anything is redacted.
+func RedactConfigValue(key string, v interface{}) (interface{}, bool) {
+	if isSecretConfigKey(key) && !isEmptyConfigValue(v) {
+		return RedactedValue, true
+	}
+	redacted := false
+	switch value := v.(type) {
+	case string:
+		masked := urlPassword.ReplaceAllString(value, "$1:"+RedactedValue+"@")
+		masked = urlSecretQuery.ReplaceAllString(masked, "${1}"+RedactedValue)
+		return masked, masked != value
+	case map[string]interface{}:
+		for k, nested := range value {
+			var ok bool
+			if value[k], ok = RedactConfigValue(k, nested); ok {
+				redacted = true
+			}
+		}
+	case []interface{}:
+		for i, nested := range value {
+			var ok bool
+			if value[i], ok = RedactConfigValue("", nested); ok {
+				redacted = true
+			}
+		}
+	}
+	return v, redacted
+}
+
+// GetConfigReport returns the effective configuration with the secrets redacted, the source of each field,
+// and the environment variable tunables read so far
+func GetConfigReport() ConfigReport {
+	configLock.RLock()
+	defer configLock.RUnlock()
+	report := ConfigReport{
+		File:        loadedConfigFile,
+		ProcessMode: processMode,
+		Fields:      []ConfigFieldReport{},
+		Tunables:    make([]EnvTunable, 0, len(envTunables)),
+	}
+	fields := reflect.TypeOf(Config)
+	values := reflect.ValueOf(Config)
+	for i := 0; i < fields.NumField(); i++ {
+		name := fields.Field(i).Name
+		var value interface{}
+		if data, err := json.Marshal(values.Field(i).Interface()); err == nil {
+			json.Unmarshal(data, &value)
+		}
+		field := ConfigFieldReport{Name: name, Source: AssignString(configSources[name], DefaultSource)}
+		field.Value, field.Redacted = RedactConfigValue(name, value)
+		report.Fields = append(report.Fields, field)
+	}
+	for _, t := range envTunables {
+		report.Tunables = append(report.Tunables, t)
+	}
+	sort.Slice(report.Tunables, func(i, j int) bool { return report.Tunables[i].Name < report.Tunables[j].Name })
+	return report
+}
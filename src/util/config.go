@@ -68,6 +68,29 @@ type Configuration struct {
 	CertFile    string `json:"CertFile"`
 	KeyFile     string `json:"KeyFile"`
 
+	// HTTPReadHeaderTimeout, HTTPReadTimeout, HTTPWriteTimeout and HTTPIdleTimeout are the Go durations of the
+	// http.Server timeouts, default to 10s, 5m, 10m and 2m. The watch streams, the long polls and the websocket proxy
+	// lift the read and write deadlines of their connections
+	HTTPReadHeaderTimeout string `json:"HTTPReadHeaderTimeout"`
+	HTTPReadTimeout       string `json:"HTTPReadTimeout"`
+	HTTPWriteTimeout      string `json:"HTTPWriteTimeout"`
+	HTTPIdleTimeout       string `json:"HTTPIdleTimeout"`
+	// HTTPMaxHeaderBytes is the max size of the request headers, default to 1MB
+	HTTPMaxHeaderBytes string `json:"HTTPMaxHeaderBytes"`
+	// HTTPMaxConnsPerIP caps the open connections of a client IP, a new connection over the cap is closed,
+	// no cap if it is empty or 0, since all the connections come from the load balancer IPs behind one
+	HTTPMaxConnsPerIP string `json:"HTTPMaxConnsPerIP"`
+
+	// PulsarTokenFile is the token file of burnell's own Pulsar clients instead of PulsarToken, read again once it is rotated
+	PulsarTokenFile string `json:"PulsarTokenFile"`
+	// PulsarTokenURL is the OAuth2 token endpoint of an external IdP to get a short lived token of burnell's own
+	// Pulsar clients by the client credentials grant, it is refreshed before the expiry
+	PulsarTokenURL          string `json:"PulsarTokenURL"`
+	PulsarTokenClientID     string `json:"PulsarTokenClientID"`
+	PulsarTokenClientSecret string `json:"PulsarTokenClientSecret"`
+	PulsarTokenAudience     string `json:"PulsarTokenAudience"`
+	PulsarTokenScope        string `json:"PulsarTokenScope"`
+
 	FederatedPromURL      string `json:"FederatedPromURL"`
 	FederatedPromInterval string `json:"FederatedPromInterval"`
 
@@ -75,13 +98,240 @@ type Configuration struct {
 	PulsarBeamTopic      string `json:"PulsarBeamTopic"`
 
 	LogServerPort string `json:"LogServerPort"`
+	// LogMaskingBuiltins is the comma separated built-in masking rules of the function logs returned to the tenants,
+	// credit-card, jwt, bearer-token, secret and email, all if empty or none to disable
+	LogMaskingBuiltins string `json:"LogMaskingBuiltins"`
+	// LogMaskingRules are the additional masking rules of the function logs of all tenants
+	LogMaskingRules []LogMaskRule `json:"LogMaskingRules"`
+	// ClientFunctionLogLevel is the lowest level of the function logs returned to the -client subjects,
+	// warn if empty or all for the full logs as the -admin subjects
+	ClientFunctionLogLevel string `json:"ClientFunctionLogLevel"`
+
+	// PlanPolicyFieldBounds overwrites tenant plan field bounds, in the format of field:min:max,field:min:max
+	PlanPolicyFieldBounds string `json:"PlanPolicyFieldBounds"`
+
+	// ScrapeAllowedCIDRs is a comma separated list of CIDRs allowed to scrape all federated metrics without a token
+	ScrapeAllowedCIDRs string `json:"ScrapeAllowedCIDRs"`
+	// ScrapeToken is a dedicated bearer token to scrape all federated metrics
+	ScrapeToken string `json:"ScrapeToken"`
+
+	// MetricsRelabel is the rules to rewrite federated metrics before they are exposed
+	MetricsRelabel MetricsRelabelRules `json:"MetricsRelabel"`
+	// MaxFederatedSeriesPerTenant and MaxFederatedSeriesTotal are the max number of federated series served to a tenant,
+	// default to 20000, and to the superuser scrape, default to 500000, 0 is no limit
+	MaxFederatedSeriesPerTenant string `json:"MaxFederatedSeriesPerTenant"`
+	MaxFederatedSeriesTotal     string `json:"MaxFederatedSeriesTotal"`
+
+	// AllowedOffloadDrivers is a comma separated list of tiered storage drivers a tenant can use
+	AllowedOffloadDrivers string `json:"AllowedOffloadDrivers"`
+
+	// FunctionAssignmentTopic is the functions assignment topic, default to persistent://public/functions/assignments
+	FunctionAssignmentTopic string `json:"FunctionAssignmentTopic"`
+
+	// PulsarSQLURL is the Pulsar SQL (Presto) coordinator URL, the SQL passthrough routes are disabled if it is empty
+	PulsarSQLURL string `json:"PulsarSQLURL"`
+
+	// EnableGraphQL turns on the /graphql endpoint when it is set to true
+	EnableGraphQL string `json:"EnableGraphQL"`
+
+	// EnableFaultInjection turns on the /admin/faults endpoint to inject latency and errors when it is set to true,
+	// it is for resilience testing and must not be set in production
+	EnableFaultInjection string `json:"EnableFaultInjection"`
+
+	// SMTP server for tenant email notification
+	SMTPAddr     string `json:"SMTPAddr"`
+	SMTPFrom     string `json:"SMTPFrom"`
+	SMTPUsername string `json:"SMTPUsername"`
+	SMTPPassword string `json:"SMTPPassword"`
+
+	// UsageAnomalyWebhooks is a comma separated list of webhook URLs to receive tenant usage anomaly alerts
+	UsageAnomalyWebhooks string `json:"UsageAnomalyWebhooks"`
+
+	// AlertRoutes deliver the operator alerts, such as the quota breaches, the listener failures and the SLA violations,
+	// to the webhooks, Slack and PagerDuty by the severity
+	AlertRoutes []AlertRoute `json:"AlertRoutes"`
+
+	// AccessLogFormat is the HTTP access log format, text, json or combined (Apache combined), disabled if empty
+	AccessLogFormat string `json:"AccessLogFormat"`
+	// AccessLogFile is the access log file rotated by size, stdout if empty
+	AccessLogFile string `json:"AccessLogFile"`
+
+	// CORSAllowedOrigins is a comma separated list of browser origins allowed for all routes in addition to the defaults
+	CORSAllowedOrigins string `json:"CORSAllowedOrigins"`
+
+	// AuditExportURL is the SIEM collector of the audit events, syslog://host:514, syslog+tcp://host:514 or an HTTP(S) URL, disabled if empty
+	AuditExportURL string `json:"AuditExportURL"`
+	// AuditExportFormat is the HTTP collector format, json (default), splunk (HTTP Event Collector) or elastic (bulk API)
+	AuditExportFormat string `json:"AuditExportFormat"`
+	// AuditExportToken is the HTTP collector token
+	AuditExportToken string `json:"AuditExportToken"`
+	// AuditArchiveURL is the object storage of the long term audit archive, s3://{bucket}/{prefix} or gs://{bucket}/{prefix}, disabled if empty
+	AuditArchiveURL string `json:"AuditArchiveURL"`
+	// AuditArchiveEndpoint is an S3 compatible endpoint with path style access, default to the AWS or Google Cloud Storage endpoint
+	AuditArchiveEndpoint string `json:"AuditArchiveEndpoint"`
+	// AuditArchiveRegion is the bucket region, default to AWS_REGION or us-east-1 for s3 and auto for gs
+	AuditArchiveRegion string `json:"AuditArchiveRegion"`
+
+	// PolicyEncryptionKeys is a comma separated list of {keyId}={base64 AES key} to encrypt the sensitive tenant plan fields
+	// in the tenant topic, the first key is active and the rest decrypt the records encrypted before a key rotation
+	PolicyEncryptionKeys string `json:"PolicyEncryptionKeys"`
+	// PolicyEncryptionKeysFile is the file of the keys in the same format, such as a mounted secret, instead of PolicyEncryptionKeys
+	PolicyEncryptionKeysFile string `json:"PolicyEncryptionKeysFile"`
+
+	// JWTAllowedAlgs is a comma separated allowlist of token signature algorithms, default RS256,RS384,RS512
+	JWTAllowedAlgs string `json:"JWTAllowedAlgs"`
+	// JWTECPrivateKey and JWTECPublicKey are the ECDSA key files for ES256, ES384 and ES512
+	JWTECPrivateKey string `json:"JWTECPrivateKey"`
+	JWTECPublicKey  string `json:"JWTECPublicKey"`
+	// JWTHMACSecretKey is the secret key file for HS256, HS384 and HS512
+	JWTHMACSecretKey string `json:"JWTHMACSecretKey"`
+	// SignedURLSecretKey is the secret key file to sign the download URLs, a random key per process if empty
+	SignedURLSecretKey string `json:"SignedURLSecretKey"`
+
+	// ProxyRewrites are the header and path rewrites of the requests and responses through the admin proxy
+	ProxyRewrites []ProxyRewriteRule `json:"ProxyRewrites"`
+
+	// AdminUpstreamURLs is the comma separated broker admin URLs to select the fastest healthy one for the proxied admin calls
+	AdminUpstreamURLs string `json:"AdminUpstreamURLs"`
+
+	// ClusterAdminURLs is the comma separated {cluster}={admin URL} of the Pulsar clusters fronted by burnell other than
+	// ClusterName at BrokerProxyURL, a proxied admin call targets one by the X-Burnell-Cluster header
+	ClusterAdminURLs string `json:"ClusterAdminURLs"`
+	// ClusterCredentials are the upstream credentials of the proxied admin calls per cluster instead of PulsarToken
+	ClusterCredentials []ClusterCredential `json:"ClusterCredentials"`
+	// AdminAPIVersions is the comma separated {resource}={version}, i.e. functions=v3, of the upstream admin API
+	// of all clusters instead of the version detected by the broker version
+	AdminAPIVersions string `json:"AdminAPIVersions"`
+
+	// ErrorReasonMessages are the localized messages of the error reason codes, by the code then by the language tag
+	ErrorReasonMessages map[string]map[string]string `json:"ErrorReasonMessages"`
+
+	// QuotaEnforcement is enforce, the default, or observe the plan quota and rate limits of the tenants without their own
+	// mode, a violation in observe mode is only logged and counted to tune the limits before the hard enforcement
+	QuotaEnforcement string `json:"QuotaEnforcement"`
+
+	// SyntheticProbeTopic is the heartbeat topic of the synthetic probe with the {tenant} placeholder,
+	// default to persistent://{tenant}/default/burnell-heartbeat
+	SyntheticProbeTopic string `json:"SyntheticProbeTopic"`
+
+	// ReaderCursorDir is the directory of the checkpoints of the tenant policy and function metadata readers,
+	// the readers resume from the checkpoint on restart instead of the earliest message, disabled if empty
+	ReaderCursorDir string `json:"ReaderCursorDir"`
+
+	// BacklogQuotaRemediation is the comma separated remediations, notify, expand and skip,
+	// of the namespaces over the backlog quota threshold, only monitored if empty
+	BacklogQuotaRemediation string `json:"BacklogQuotaRemediation"`
+
+	// NamingPolicies are the namespace and topic naming rules keyed by the plan type, "default" applies to the other plans
+	NamingPolicies map[string]NamingPolicy `json:"NamingPolicies"`
+
+	// RateLimitExemptions are the infrastructure callers bypassing the rate limits or limited by their dedicated buckets
+	RateLimitExemptions []RateLimitExemption `json:"RateLimitExemptions"`
+
+	// PlanChangeRules are the webhook subscriptions to the tenant plan changes of the specific fields
+	PlanChangeRules []PlanChangeRule `json:"PlanChangeRules"`
+}
+
+// LogMaskRule replaces the matches of the regex pattern in the function logs, Replacement is **** if empty
+// and may refer to the capture groups as ${1}
+type LogMaskRule struct {
+	Name        string `json:"name"`
+	Pattern     string `json:"pattern"`
+	Replacement string `json:"replacement,omitempty"`
+}
+
+// RateLimitExemption matches a caller by the token subject or the client IP in the CIDR. The caller bypasses
+// all rate limits if Limit is 0, otherwise it is only limited by a dedicated bucket of Limit in-flight requests.
+type RateLimitExemption struct {
+	Name    string `json:"name"`
+	Subject string `json:"subject,omitempty"`
+	CIDR    string `json:"cidr,omitempty"`
+	Limit   int    `json:"limit,omitempty"`
+}
+
+// ClusterCredential is the upstream credential of the proxied admin calls to a cluster, a token or a token file,
+// and an optional TLS client certificate and trust store. The files are reloaded once they change.
+type ClusterCredential struct {
+	Cluster     string `json:"cluster"`
+	Token       string `json:"token,omitempty"`
+	TokenFile   string `json:"tokenFile,omitempty"`
+	TLSCertFile string `json:"tlsCertFile,omitempty"`
+	TLSKeyFile  string `json:"tlsKeyFile,omitempty"`
+	TrustStore  string `json:"trustStore,omitempty"`
+}
+
+// PlanChangeRule posts a tenant plan change event to the webhooks if any of the fields is changed, such as tenantStatus
+// or policy.featureCodes. An empty tenant matches all tenants, empty fields match any change, and empty types match
+// both updated and deleted events.
+type PlanChangeRule struct {
+	Name     string   `json:"name"`
+	Tenant   string   `json:"tenant,omitempty"`
+	Fields   []string `json:"fields,omitempty"`
+	Types    []string `json:"types,omitempty"`
+	Webhooks []string `json:"webhooks"`
+}
+
+// AlertRoute delivers the operator alerts at or above the severity, info, warning or critical, to the webhooks,
+// the Slack incoming webhooks and the PagerDuty Events API v2 routing keys. Empty kinds match all kinds of alerts.
+type AlertRoute struct {
+	Name                 string   `json:"name"`
+	Severity             string   `json:"severity"`
+	Kinds                []string `json:"kinds,omitempty"`
+	Webhooks             []string `json:"webhooks,omitempty"`
+	SlackWebhooks        []string `json:"slackWebhooks,omitempty"`
+	PagerDutyRoutingKeys []string `json:"pagerDutyRoutingKeys,omitempty"`
+}
+
+// NamingPolicy is the naming rules of the namespaces and topics created by a tenant
+type NamingPolicy struct {
+	Namespace NamingRule `json:"namespace"`
+	Topic     NamingRule `json:"topic"`
+}
+
+// NamingRule constrains a resource name. The prefix can reference the tenant name as {tenant}.
+type NamingRule struct {
+	// Pattern is the regex the whole name must match, no constraint if empty
+	Pattern string `json:"pattern"`
+	// Prefix is the required name prefix, no constraint if empty
+	Prefix string `json:"prefix"`
+	// MaxLength is the maximum name length, no constraint if 0
+	MaxLength int `json:"maxLength"`
+}
+
+// ProxyRewriteRule rewrites the proxied requests and responses matched by the path regex and methods.
+// The header values are expanded with the environment variables, such as ${BROKER_TOKEN}.
+type ProxyRewriteRule struct {
+	// Path is the regex of the request path, all paths if empty
+	Path string `json:"path"`
+	// Methods are the request methods, all methods if empty
+	Methods []string `json:"methods"`
+	// PathRewrite replaces the Path regex match in the upstream request path, with $1 style expansion
+	PathRewrite string `json:"pathRewrite"`
+	// SetRequestHeaders are the headers set on the upstream request
+	SetRequestHeaders map[string]string `json:"setRequestHeaders"`
+	// RemoveRequestHeaders are the headers stripped from the upstream request
+	RemoveRequestHeaders []string `json:"removeRequestHeaders"`
+	// SetResponseHeaders are the headers set on the response to the client
+	SetResponseHeaders map[string]string `json:"setResponseHeaders"`
+	// RemoveResponseHeaders are the headers stripped from the response to the client
+	RemoveResponseHeaders []string `json:"removeResponseHeaders"`
+}
+
+// MetricsRelabelRules is the relabel rules for federated Prometheus metrics
+type MetricsRelabelRules struct {
+	// ClusterLabel is the name of the injected label with ClusterName as the value, no injection if empty
+	ClusterLabel string `json:"clusterLabel"`
+	// NormalizeTenantLabels renames exported_namespace to namespace and adds a tenant label based on the namespace
+	NormalizeTenantLabels bool `json:"normalizeTenantLabels"`
+	// RenameMetrics maps the original metric family name to a new name
+	RenameMetrics map[string]string `json:"renameMetrics"`
 }
 
 // Config - this server's configuration instance
 var Config Configuration
 
-// JWTAuth is the RSA key pair for sign and verify JWT
-var JWTAuth *icrypto.RSAKeyPair
+// JWTAuth is the RSA, ECDSA and HMAC keys for sign and verify JWT
+var JWTAuth *icrypto.JWTKeys
 
 // BrokerProxyURL is the destination URL for the broker
 var BrokerProxyURL *url.URL
@@ -102,15 +352,27 @@ func Init(mode *string) {
 
 	log.SetLevel(logLevel(Config.LogLevel))
 	log.Warnf("Configuration built from file - %s", configFile)
+	processMode = *mode
 	if IsInitializer(mode) || IsHealer(mode) {
 		return
 	}
 	var err error
 	if IsPulsarJWTEnabled() {
-		JWTAuth, err = icrypto.LoadRSAKeyPair(Config.PulsarPrivateKey, Config.PulsarPublicKey)
-		if err != nil {
+		var rsaKeys *icrypto.RSAKeyPair
+		if Config.PulsarPrivateKey != "" || Config.PulsarPublicKey != "" {
+			if rsaKeys, err = icrypto.LoadRSAKeyPair(Config.PulsarPrivateKey, Config.PulsarPublicKey); err != nil {
+				panic(err)
+			}
+		}
+		JWTAuth = icrypto.NewJWTKeys(rsaKeys, AssignString(Config.JWTAllowedAlgs, icrypto.DefaultAllowedAlgs))
+		if err = JWTAuth.LoadECDSAKeys(Config.JWTECPrivateKey, Config.JWTECPublicKey); err != nil {
 			panic(err)
 		}
+		if Config.JWTHMACSecretKey != "" {
+			if err = JWTAuth.LoadHMACSecret(Config.JWTHMACSecretKey); err != nil {
+				panic(err)
+			}
+		}
 	}
 	BrokerProxyURL, err = url.ParseRequestURI(Config.BrokerProxyURL)
 	if err != nil {
@@ -121,6 +383,9 @@ func Init(mode *string) {
 		panic(err)
 	}
 	AdminRestPrefix = Config.AdminRestPrefix
+	if err = InitPulsarToken(); err != nil {
+		panic(err)
+	}
 }
 
 // ReadConfigFile reads configuration file.
@@ -143,6 +408,11 @@ func ReadConfigFile(configFile string) {
 		}
 	}
 
+	// the top level keys in the file give the source of the fields
+	fileKeys := map[string]interface{}{}
+	yaml.Unmarshal(fileBytes, &fileKeys)
+	sources := make(map[string]string)
+
 	// Next section allows env variable overwrites config file value
 	fields := reflect.TypeOf(Config)
 	// pointer to struct
@@ -152,15 +422,18 @@ func ReadConfigFile(configFile string) {
 	for i := 0; i < fields.NumField(); i++ {
 		field := fields.Field(i).Name
 		f := st.FieldByName(field)
+		sources[field] = configFieldSource(fields.Field(i), fileKeys)
 
 		if f.Kind() == reflect.String {
 			envV := os.Getenv(field)
 			if len(envV) > 0 && f.IsValid() && f.CanSet() {
 				f.SetString(strings.TrimSuffix(envV, "\n")) // ensure no \n at the end of line that was introduced by loading k8s secrete file
+				sources[field] = EnvSource
 			}
 			os.Setenv(field, f.String())
 		}
 	}
+	setConfigSources(configFile, sources)
 
 	if IsPulsarJWTEnabled() {
 		SuperRoles = []string{}
@@ -219,5 +492,5 @@ func IsPulsarJWTEnabled() bool {
 // IsStatsMode returns if the burnell is running stats mode that collects and generates tenant stats only
 func IsStatsMode() bool {
 	c := GetConfig()
-	return c.FederatedPromInterval != ""
+	return c.FederatedPromInterval != "" || IsStatsOnly(&processMode)
 }
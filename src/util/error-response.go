@@ -0,0 +1,82 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package util
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// RequestIDHeader is the request ID set on the request and the response, it is in the error response body
+const RequestIDHeader = "X-Request-ID"
+
+// ErrorResponse is the error response body of all endpoints
+type ErrorResponse struct {
+	// Code is the status text in snake case, i.e. not_found, unless the error has its own code
+	Code string `json:"code"`
+	// Reason is the machine readable reason code in the registry, i.e. QUOTA_TOPICS_EXCEEDED
+	Reason    string      `json:"reason,omitempty"`
+	Message   string      `json:"message"`
+	RequestID string      `json:"requestID,omitempty"`
+	Details   interface{} `json:"details,omitempty"`
+	// Error is the message for the clients of the former {"error": message} body
+	Error string `json:"error"`
+}
+
+// APIError is an error with its own error code, reason code and details in the error response
+type APIError struct {
+	Code    string
+	Reason  string
+	Message string
+	Details interface{}
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+// ErrorCode is the error code of the status code, the status text in snake case
+func ErrorCode(statusCode int) string {
+	text := http.StatusText(statusCode)
+	if text == "" {
+		return "error"
+	}
+	return strings.ToLower(strings.NewReplacer(" ", "_", "-", "_", "'", "").Replace(text))
+}
+
+// NewErrorResponse is the error response of the error, the request ID is the one set on the response header
+func NewErrorResponse(e error, w http.ResponseWriter, statusCode int) ErrorResponse {
+	response := ErrorResponse{
+		Code:      ErrorCode(statusCode),
+		Message:   e.Error(),
+		RequestID: w.Header().Get(RequestIDHeader),
+		Error:     e.Error(),
+	}
+	var apiErr *APIError
+	if errors.As(e, &apiErr) {
+		response.Code = AssignString(apiErr.Code, response.Code)
+		response.Reason = apiErr.Reason
+		response.Details = apiErr.Details
+	}
+	return response
+}
@@ -0,0 +1,306 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package util
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// HTTPServerConfig is the slow client protection of the public endpoint
+type HTTPServerConfig struct {
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+	// MaxConnsPerIP is the open connections allowed per client IP, 0 is no cap
+	MaxConnsPerIP int
+}
+
+var (
+	openConnsGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "burnell",
+		Subsystem: "http",
+		Name:      "open_connections",
+		Help:      "The number of the open client connections.",
+	})
+	connsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "burnell",
+		Subsystem: "http",
+		Name:      "connections_total",
+		Help:      "The number of the accepted client connections by the result, accepted or rejected over the per IP cap.",
+	}, []string{"result"})
+	connStateGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "burnell",
+		Subsystem: "http",
+		Name:      "connections",
+		Help:      "The number of the client connections by the state, new, active or idle.",
+	}, []string{"state"})
+)
+
+func init() {
+	prometheus.MustRegister(openConnsGauge, connsCounter, connStateGauge)
+}
+
+// LoadHTTPServerConfig parses the HTTP server settings of the configuration with the defaults
+func LoadHTTPServerConfig(c *Configuration) (HTTPServerConfig, error) {
+	cfg := HTTPServerConfig{}
+	var err error
+	for _, d := range []struct {
+		name  string
+		value string
+		def   time.Duration
+		field *time.Duration
+	}{
+		{"HTTPReadHeaderTimeout", c.HTTPReadHeaderTimeout, 10 * time.Second, &cfg.ReadHeaderTimeout},
+		{"HTTPReadTimeout", c.HTTPReadTimeout, 5 * time.Minute, &cfg.ReadTimeout},
+		{"HTTPWriteTimeout", c.HTTPWriteTimeout, 10 * time.Minute, &cfg.WriteTimeout},
+		{"HTTPIdleTimeout", c.HTTPIdleTimeout, 2 * time.Minute, &cfg.IdleTimeout},
+	} {
+		*d.field = d.def
+		if d.value == "" {
+			continue
+		}
+		if *d.field, err = time.ParseDuration(d.value); err != nil || *d.field < 0 {
+			return cfg, fmt.Errorf("invalid %s %s", d.name, d.value)
+		}
+	}
+	for _, n := range []struct {
+		name  string
+		value string
+		def   int
+		field *int
+	}{
+		{"HTTPMaxHeaderBytes", c.HTTPMaxHeaderBytes, http.DefaultMaxHeaderBytes, &cfg.MaxHeaderBytes},
+		{"HTTPMaxConnsPerIP", c.HTTPMaxConnsPerIP, 0, &cfg.MaxConnsPerIP},
+	} {
+		*n.field = n.def
+		if n.value == "" {
+			continue
+		}
+		if *n.field, err = strconv.Atoi(n.value); err != nil || *n.field < 0 {
+			return cfg, fmt.Errorf("invalid %s %s", n.name, n.value)
+		}
+	}
+	return cfg, nil
+}
+
+// NewHTTPServer is the http.Server of the handler with the timeouts and the connection state metrics
+func NewHTTPServer(cfg HTTPServerConfig, handler http.Handler) *http.Server {
+	return &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+		ConnState:         observeConnState(),
+	}
+}
+
+// LiftServerDeadlines clears the server read and write deadlines of the connection of a long lived response,
+// the watch streams, the long polls and the websocket proxy, the other requests keep the slow client protection
+func LiftServerDeadlines(w http.ResponseWriter) {
+	rc := http.NewResponseController(w)
+	for _, set := range []func(time.Time) error{rc.SetReadDeadline, rc.SetWriteDeadline} {
+		if err := set(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			log.Warnf("failed to lift the server deadline %v", err)
+		}
+	}
+}
+
+// observeConnState tracks the state of every connection to move it between the state gauges
+func observeConnState() func(net.Conn, http.ConnState) {
+	states := map[net.Conn]http.ConnState{}
+	lock := sync.Mutex{}
+	return func(c net.Conn, state http.ConnState) {
+		lock.Lock()
+		defer lock.Unlock()
+		if last, ok := states[c]; ok {
+			connStateGauge.WithLabelValues(last.String()).Dec()
+		}
+		switch state {
+		case http.StateNew, http.StateActive, http.StateIdle:
+			states[c] = state
+			connStateGauge.WithLabelValues(state.String()).Inc()
+		default:
+			// closed or hijacked by the websocket proxy
+			delete(states, c)
+		}
+	}
+}
+
+// ConnLimitListener closes the new connections of a client IP over the cap of the open connections
+type ConnLimitListener struct {
+	net.Listener
+	max   int
+	conns map[string]int
+	lock  sync.Mutex
+}
+
+// NewConnLimitListener caps the open connections per client IP of the listener, 0 is no cap
+func NewConnLimitListener(l net.Listener, maxPerIP int) *ConnLimitListener {
+	return &ConnLimitListener{Listener: l, max: maxPerIP, conns: map[string]int{}}
+}
+
+// Accept waits for the next connection under the per IP cap
+func (l *ConnLimitListener) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return c, err
+		}
+		ip := remoteIP(c)
+		if !l.acquire(ip) {
+			connsCounter.WithLabelValues("rejected").Inc()
+			log.Warnf("close the connection of %s over %d open connections per IP", ip, l.max)
+			c.Close()
+			continue
+		}
+		connsCounter.WithLabelValues("accepted").Inc()
+		openConnsGauge.Inc()
+		return &limitedConn{Conn: c, release: func() { l.release(ip) }}, nil
+	}
+}
+
+// OpenConns returns the open connections of the client IP
+func (l *ConnLimitListener) OpenConns(ip string) int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.conns[ip]
+}
+
+func (l *ConnLimitListener) acquire(ip string) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.max > 0 && l.conns[ip] >= l.max {
+		return false
+	}
+	l.conns[ip]++
+	return true
+}
+
+func (l *ConnLimitListener) release(ip string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.conns[ip]--; l.conns[ip] <= 0 {
+		delete(l.conns, ip)
+	}
+}
+
+func remoteIP(c net.Conn) string {
+	host, _, err := net.SplitHostPort(c.RemoteAddr().String())
+	if err != nil {
+		return c.RemoteAddr().String()
+	}
+	return host
+}
+
+// limitedConn releases its count of the client IP once closed
+type limitedConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *limitedConn) Close() error {
+	c.once.Do(func() {
+		openConnsGauge.Dec()
+		c.release()
+	})
+	return c.Conn.Close()
+}
+
+// certReloader loads the TLS key pair again once the files change, checked at most once a second on the handshakes
+type certReloader struct {
+	certFile, keyFile string
+	lock              sync.Mutex
+	cert              *tls.Certificate
+	modTime           time.Time
+	checkedAt         time.Time
+}
+
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.cert != nil && time.Since(r.checkedAt) < time.Second {
+		return r.cert, nil
+	}
+	r.checkedAt = time.Now()
+	modTime := latestModTime(r.certFile, r.keyFile)
+	if r.cert != nil && !modTime.After(r.modTime) {
+		return r.cert, nil
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		if r.cert != nil {
+			// a half written key pair, keep the last one
+			log.Errorf("reload certificate %s error %v", r.certFile, err)
+			return r.cert, nil
+		}
+		return nil, err
+	}
+	log.Infof("loaded certificate %s and key %s", r.certFile, r.keyFile)
+	r.cert, r.modTime = &cert, modTime
+	return r.cert, nil
+}
+
+func latestModTime(files ...string) time.Time {
+	latest := time.Time{}
+	for _, f := range files {
+		if info, err := os.Stat(f); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
+
+// ListenAndServe serves the handler on the address, with TLS if the certificate and key files are set.
+// The certificate is reloaded once the files are rotated.
+func ListenAndServe(address, certFile, keyFile string, cfg HTTPServerConfig, handler http.Handler) error {
+	server := NewHTTPServer(cfg, handler)
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	listener := NewConnLimitListener(l, cfg.MaxConnsPerIP)
+	if len(certFile) > 1 && len(keyFile) > 1 {
+		reloader := &certReloader{certFile: certFile, keyFile: keyFile}
+		if _, err := reloader.getCertificate(nil); err != nil {
+			return err
+		}
+		server.TLSConfig = &tls.Config{GetCertificate: reloader.getCertificate}
+		return server.ServeTLS(listener, "", "")
+	}
+	return server.Serve(listener)
+}
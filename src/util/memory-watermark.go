@@ -0,0 +1,204 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// MemoryStatus is the last memory sample of the process against the watermark
+type MemoryStatus struct {
+	RSSBytes       uint64 `json:"rssBytes"`
+	HeapBytes      uint64 `json:"heapBytes"`
+	RSSLimitBytes  uint64 `json:"rssLimitBytes"`
+	HeapLimitBytes uint64 `json:"heapLimitBytes"`
+	// RecoveryPercent is the percentage of the limits the memory must drop below to stop shedding
+	RecoveryPercent int       `json:"recoveryPercent"`
+	Shedding        bool      `json:"shedding"`
+	SheddingSince   time.Time `json:"sheddingSince,omitempty"`
+	SampledAt       time.Time `json:"sampledAt,omitempty"`
+	// ShedRequests is the number of the requests shed since the process start
+	ShedRequests uint64 `json:"shedRequests"`
+}
+
+// MemoryWatermark sheds the heavy requests once the process RSS or heap reaches a limit,
+// until the memory drops below the recovery percentage of the limits. 0 is no limit.
+type MemoryWatermark struct {
+	shedding     int32
+	shedRequests uint64
+	status       MemoryStatus
+	lock         sync.Mutex
+}
+
+var (
+	memoryBytesGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "burnell",
+		Subsystem: "memory",
+		Name:      "bytes",
+		Help:      "The sampled process memory by the type, rss or heap.",
+	}, []string{"type"})
+	memorySheddingGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "burnell",
+		Subsystem: "memory",
+		Name:      "shedding",
+		Help:      "1 if the process memory is over the watermark and the heavy requests are shed.",
+	})
+	memoryShedCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "burnell",
+		Subsystem: "memory",
+		Name:      "shed_requests_total",
+		Help:      "The number of the requests rejected by the route over the memory watermark.",
+	}, []string{"route"})
+)
+
+func init() {
+	prometheus.MustRegister(memoryBytesGauge, memorySheddingGauge, memoryShedCounter)
+}
+
+// NewMemoryWatermark creates a memory watermark of the RSS and heap limits in MB
+func NewMemoryWatermark(rssLimitMB, heapLimitMB, recoveryPercent int) *MemoryWatermark {
+	if recoveryPercent < 1 || recoveryPercent > 100 {
+		recoveryPercent = 100
+	}
+	return &MemoryWatermark{status: MemoryStatus{
+		RSSLimitBytes:   uint64(maxInt(rssLimitMB, 0)) << 20,
+		HeapLimitBytes:  uint64(maxInt(heapLimitMB, 0)) << 20,
+		RecoveryPercent: recoveryPercent,
+	}}
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// Memory is the memory watermark of the process, disabled by default
+var Memory = NewMemoryWatermark(GetEnvInt("MemoryWatermarkRSSMB", 0), GetEnvInt("MemoryWatermarkHeapMB", 0),
+	GetEnvInt("MemoryWatermarkRecoveryPercent", 80))
+
+// Enabled evaluates if the watermark has a limit
+func (m *MemoryWatermark) Enabled() bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.status.RSSLimitBytes > 0 || m.status.HeapLimitBytes > 0
+}
+
+// Update evaluates a memory sample against the limits and returns if the requests are shed
+func (m *MemoryWatermark) Update(rss, heap uint64) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	s := &m.status
+	s.RSSBytes, s.HeapBytes, s.SampledAt = rss, heap, time.Now()
+	memoryBytesGauge.WithLabelValues("rss").Set(float64(rss))
+	memoryBytesGauge.WithLabelValues("heap").Set(float64(heap))
+
+	over := func(v, limit uint64, percent int) bool {
+		return limit > 0 && v >= limit*uint64(percent)/100
+	}
+	if !s.Shedding && (over(rss, s.RSSLimitBytes, 100) || over(heap, s.HeapLimitBytes, 100)) {
+		s.Shedding, s.SheddingSince = true, s.SampledAt
+		log.Errorf("process memory rss %d heap %d bytes over the watermark, shedding the heavy requests", rss, heap)
+	} else if s.Shedding && !over(rss, s.RSSLimitBytes, s.RecoveryPercent) && !over(heap, s.HeapLimitBytes, s.RecoveryPercent) {
+		s.Shedding, s.SheddingSince = false, time.Time{}
+		log.Warnf("process memory rss %d heap %d bytes recovered under the watermark", rss, heap)
+	}
+	if s.Shedding {
+		atomic.StoreInt32(&m.shedding, 1)
+		memorySheddingGauge.Set(1)
+	} else {
+		atomic.StoreInt32(&m.shedding, 0)
+		memorySheddingGauge.Set(0)
+	}
+	return s.Shedding
+}
+
+// Sample reads the process RSS and heap in use and evaluates them against the limits
+func (m *MemoryWatermark) Sample() bool {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return m.Update(processRSS(), stats.HeapInuse)
+}
+
+// Shedding evaluates if the heavy requests are shed, it is lock free for the request path
+func (m *MemoryWatermark) Shedding() bool {
+	return atomic.LoadInt32(&m.shedding) == 1
+}
+
+// Shed counts a request shed on the route
+func (m *MemoryWatermark) Shed(route string) {
+	atomic.AddUint64(&m.shedRequests, 1)
+	memoryShedCounter.WithLabelValues(route).Inc()
+}
+
+// Status returns the last memory sample
+func (m *MemoryWatermark) Status() MemoryStatus {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	status := m.status
+	status.ShedRequests = atomic.LoadUint64(&m.shedRequests)
+	return status
+}
+
+// processRSS returns the resident set size of the process on Linux, or 0 if unknown
+func processRSS() uint64 {
+	data, err := ioutil.ReadFile("/proc/self/statm")
+	if err != nil {
+		return 0
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) < 2 {
+		return 0
+	}
+	pages, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return pages * uint64(os.Getpagesize())
+}
+
+// MemoryWatermarkMonitor samples the process memory every MemoryWatermarkIntervalSeconds if the watermark is enabled
+func MemoryWatermarkMonitor() {
+	if !Memory.Enabled() {
+		return
+	}
+	interval := time.Duration(GetEnvInt("MemoryWatermarkIntervalSeconds", 5)) * time.Second
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			Memory.Sample()
+			<-ticker.C
+		}
+	}()
+}
@@ -0,0 +1,179 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package util
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrObjectNotFound is returned when an object does not exist in the bucket
+var ErrObjectNotFound = errors.New("object not found")
+
+// ObjectStore is a bucket of an S3 compatible object storage with path style access, the requests are signed with
+// AWS signature version 4. Google Cloud Storage is accessed by its XML API with HMAC keys.
+type ObjectStore struct {
+	Endpoint     string
+	Region       string
+	Bucket       string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	Client       *http.Client
+}
+
+// NewObjectStore parses s3://{bucket}/{prefix} or gs://{bucket}/{prefix} into the bucket and the key prefix.
+// The credentials are AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN, which are the HMAC keys for gs.
+// An empty endpoint is the AWS regional or the Google Cloud Storage endpoint.
+func NewObjectStore(storeURL, endpoint, region string) (*ObjectStore, string, error) {
+	u, err := url.Parse(storeURL)
+	if err != nil {
+		return nil, "", err
+	}
+	if u.Host == "" {
+		return nil, "", fmt.Errorf("object store url %s has no bucket", storeURL)
+	}
+	store := &ObjectStore{
+		Endpoint:     endpoint,
+		Bucket:       u.Host,
+		AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	switch u.Scheme {
+	case "s3":
+		store.Region = AssignString(region, os.Getenv("AWS_REGION"), "us-east-1")
+	case "gs":
+		store.Region = AssignString(region, "auto")
+		store.Endpoint = AssignString(endpoint, "https://storage.googleapis.com")
+	default:
+		return nil, "", fmt.Errorf("unsupported object store url scheme %s", u.Scheme)
+	}
+	return store, strings.TrimPrefix(u.Path, "/"), nil
+}
+
+func (o *ObjectStore) objectURL(key string) (*url.URL, error) {
+	endpoint := AssignString(o.Endpoint, "https://s3."+o.Region+".amazonaws.com")
+	return url.Parse(strings.TrimSuffix(endpoint, "/") + "/" + o.Bucket + "/" + key)
+}
+
+func (o *ObjectStore) do(method, key, contentType string, data []byte) (*http.Response, error) {
+	u, err := o.objectURL(key)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	o.sign(req, data, time.Now().UTC())
+
+	client := o.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	return client.Do(req)
+}
+
+// PutObject uploads the data as the object key
+func (o *ObjectStore) PutObject(key, contentType string, data []byte) error {
+	resp, err := o.do(http.MethodPut, key, contentType, data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("put object %s status %d %s", key, resp.StatusCode, string(body))
+	}
+	return nil
+}
+
+// HeadObject returns the size of the object, or ErrObjectNotFound
+func (o *ObjectStore) HeadObject(key string) (int64, error) {
+	resp, err := o.do(http.MethodHead, key, "", nil)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return 0, ErrObjectNotFound
+	}
+	if resp.StatusCode/100 != 2 {
+		return 0, fmt.Errorf("head object %s status %d", key, resp.StatusCode)
+	}
+	return resp.ContentLength, nil
+}
+
+// sign adds the AWS signature version 4 authorization header
+func (o *ObjectStore) sign(req *http.Request, payload []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(payload)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := "host:" + req.URL.Host + "\nx-amz-content-sha256:" + payloadHash + "\nx-amz-date:" + amzDate + "\n"
+	if o.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", o.SessionToken)
+		signedHeaders += ";x-amz-security-token"
+		canonicalHeaders += "x-amz-security-token:" + o.SessionToken + "\n"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method, req.URL.EscapedPath(), req.URL.RawQuery, canonicalHeaders, signedHeaders, payloadHash,
+	}, "\n")
+	scope := date + "/" + o.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+o.SecretKey), date)
+	key = hmacSHA256(key, o.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+o.AccessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
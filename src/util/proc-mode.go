@@ -32,6 +32,15 @@ const Initializer = "init"
 // Healer repairs any misconfiguration in an already deployed cluster
 const Healer = "healer"
 
+// StatsOnly serves the metrics and usage routes only, without the Pulsar data plane dependencies
+const StatsOnly = "stats"
+
+// ReadOnly is the proxy mode with all the mutating routes disabled, for the read-only replicas in the DR regions
+const ReadOnly = "readonly"
+
+// the process mode set at Init
+var processMode string
+
 // IsInitializer check if the broker is required
 func IsInitializer(mode *string) bool {
 	return *mode == Initializer
@@ -41,3 +50,18 @@ func IsInitializer(mode *string) bool {
 func IsHealer(mode *string) bool {
 	return *mode == Healer
 }
+
+// IsStatsOnly is the process mode stats only
+func IsStatsOnly(mode *string) bool {
+	return *mode == StatsOnly
+}
+
+// IsReadOnly is the process mode read only
+func IsReadOnly(mode *string) bool {
+	return *mode == ReadOnly
+}
+
+// IsReadOnlyMode returns if the burnell is running as a read-only replica
+func IsReadOnlyMode() bool {
+	return IsReadOnly(&processMode)
+}
@@ -0,0 +1,158 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package util
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// the names of the internal Pulsar clients
+const (
+	PolicyWriterClient     = "policy-writer"
+	TenantReaderClient     = "tenant-reader"
+	FunctionMetadataClient = "function-metadata-reader"
+	FunctionAssignClient   = "function-assignment-reader"
+	SyntheticProbeClient   = "synthetic-prober"
+	PulsarBeamClient       = "pulsar-beam-reader"
+)
+
+// PulsarClientStatus is the connection status of an internal Pulsar client
+type PulsarClientStatus struct {
+	Name          string    `json:"name"`
+	Topic         string    `json:"topic"`
+	ServiceURL    string    `json:"serviceUrl"`
+	Broker        string    `json:"broker,omitempty"`
+	Connected     bool      `json:"connected"`
+	ConnectedAt   time.Time `json:"connectedAt,omitempty"`
+	Reconnects    int       `json:"reconnects"`
+	LastError     string    `json:"lastError,omitempty"`
+	LastErrorAt   time.Time `json:"lastErrorAt,omitempty"`
+	Messages      uint64    `json:"messages"`
+	LastMessageAt time.Time `json:"lastMessageAt,omitempty"`
+}
+
+var (
+	pulsarClients     = make(map[string]*PulsarClientStatus)
+	pulsarClientsLock = sync.RWMutex{}
+	// pulsarClientFailureHandler is called outside of the lock
+	pulsarClientFailureHandler func(PulsarClientStatus)
+
+	pulsarClientConnectedGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "burnell",
+		Subsystem: "pulsar_client",
+		Name:      "connected",
+		Help:      "1 if the internal Pulsar client is connected, otherwise 0.",
+	}, []string{"client"})
+	pulsarClientReconnectCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "burnell",
+		Subsystem: "pulsar_client",
+		Name:      "reconnects_total",
+		Help:      "The number of reconnects of the internal Pulsar client.",
+	}, []string{"client"})
+	pulsarClientErrorCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "burnell",
+		Subsystem: "pulsar_client",
+		Name:      "errors_total",
+		Help:      "The number of errors of the internal Pulsar client.",
+	}, []string{"client"})
+)
+
+func init() {
+	prometheus.MustRegister(pulsarClientConnectedGauge, pulsarClientReconnectCounter, pulsarClientErrorCounter)
+}
+
+func pulsarClient(name, topic string) *PulsarClientStatus {
+	c, ok := pulsarClients[name]
+	if !ok {
+		c = &PulsarClientStatus{Name: name}
+		pulsarClients[name] = c
+	}
+	c.Topic = topic
+	c.ServiceURL = GetConfig().PulsarURL
+	return c
+}
+
+// PulsarClientConnected records an internal Pulsar client is connected, a connection after a failure is a reconnect
+func PulsarClientConnected(name, topic string) {
+	pulsarClientsLock.Lock()
+	defer pulsarClientsLock.Unlock()
+	c := pulsarClient(name, topic)
+	if c.Connected {
+		return
+	}
+	if !c.LastErrorAt.IsZero() {
+		c.Reconnects++
+		pulsarClientReconnectCounter.WithLabelValues(name).Inc()
+	}
+	c.Connected = true
+	c.ConnectedAt = time.Now()
+	pulsarClientConnectedGauge.WithLabelValues(name).Set(1)
+}
+
+// OnPulsarClientFailed sets the handler called with the client status on every failure of an internal Pulsar client
+func OnPulsarClientFailed(handler func(PulsarClientStatus)) {
+	pulsarClientsLock.Lock()
+	defer pulsarClientsLock.Unlock()
+	pulsarClientFailureHandler = handler
+}
+
+// PulsarClientFailed records the error of an internal Pulsar client and marks it disconnected
+func PulsarClientFailed(name, topic string, err error) {
+	pulsarClientsLock.Lock()
+	c := pulsarClient(name, topic)
+	c.Connected = false
+	c.LastError = err.Error()
+	c.LastErrorAt = time.Now()
+	pulsarClientErrorCounter.WithLabelValues(name).Inc()
+	pulsarClientConnectedGauge.WithLabelValues(name).Set(0)
+	status, handler := *c, pulsarClientFailureHandler
+	pulsarClientsLock.Unlock()
+	if handler != nil {
+		handler(status)
+	}
+}
+
+// PulsarClientMessage records a message read or written by an internal Pulsar client
+func PulsarClientMessage(name string) {
+	pulsarClientsLock.Lock()
+	defer pulsarClientsLock.Unlock()
+	if c, ok := pulsarClients[name]; ok {
+		c.Messages++
+		c.LastMessageAt = time.Now()
+	}
+}
+
+// PulsarClients returns the status of all internal Pulsar clients sorted by the name
+func PulsarClients() []PulsarClientStatus {
+	pulsarClientsLock.RLock()
+	defer pulsarClientsLock.RUnlock()
+	clients := make([]PulsarClientStatus, 0, len(pulsarClients))
+	for _, c := range pulsarClients {
+		clients = append(clients, *c)
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].Name < clients[j].Name })
+	return clients
+}
@@ -0,0 +1,405 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package util
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// the sources of the Pulsar token of burnell's own clients
+const (
+	StaticTokenSource = "static"
+	FileTokenSource   = "file"
+	OAuth2TokenSource = "oauth2"
+)
+
+// TokenProvider supplies the Pulsar token of burnell's own clients, a short lived token is refreshed before the expiry
+type TokenProvider interface {
+	Token() (string, error)
+	Status() PulsarTokenStatus
+	Refresh() error
+}
+
+// PulsarTokenStatus is the current Pulsar token of burnell's own clients without the secret
+type PulsarTokenStatus struct {
+	Source string `json:"source"`
+	// Fingerprint is the first 8 bytes of the token SHA-256 in hex
+	Fingerprint string    `json:"fingerprint,omitempty"`
+	ExpiresAt   time.Time `json:"expiresAt,omitempty"`
+	RefreshedAt time.Time `json:"refreshedAt,omitempty"`
+	Refreshes   int       `json:"refreshes"`
+	LastError   string    `json:"lastError,omitempty"`
+	LastErrorAt time.Time `json:"lastErrorAt,omitempty"`
+}
+
+var (
+	// the time before the expiry to refresh the token
+	tokenRefreshBefore = time.Duration(GetEnvInt("PulsarTokenRefreshBeforeSeconds", 60)) * time.Second
+	// the min interval to retry a failed refresh while the current token is still usable
+	tokenRetryInterval = time.Duration(GetEnvInt("PulsarTokenRetrySeconds", 5)) * time.Second
+	// the min interval to check the token file for a rotation
+	tokenFileCheckInterval = time.Duration(GetEnvInt("PulsarTokenFileCheckSeconds", 10)) * time.Second
+
+	pulsarTokenProvider     TokenProvider
+	pulsarTokenProviderLock = sync.RWMutex{}
+
+	pulsarTokenExpiryGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "burnell",
+		Subsystem: "pulsar_token",
+		Name:      "expiry_timestamp_seconds",
+		Help:      "The expiry of the Pulsar token of burnell's own clients in unix seconds, 0 without an expiry.",
+	})
+	pulsarTokenRefreshCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "burnell",
+		Subsystem: "pulsar_token",
+		Name:      "refreshes_total",
+		Help:      "The number of the Pulsar token refreshes by the result, success or failure.",
+	}, []string{"result"})
+)
+
+func init() {
+	prometheus.MustRegister(pulsarTokenExpiryGauge, pulsarTokenRefreshCounter)
+}
+
+// tokenExpiry returns the exp claim of a JWT token, or zero for a token without an expiry or not a JWT
+func tokenExpiry(token string) time.Time {
+	claims := jwt.MapClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(token, claims); err != nil {
+		return time.Time{}
+	}
+	if exp, ok := claims["exp"].(float64); ok {
+		return time.Unix(int64(exp), 0)
+	}
+	return time.Time{}
+}
+
+func tokenFingerprint(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:8])
+}
+
+// refreshingToken is a token fetched from its source again before the expiry, or when the source is due for a check
+type refreshingToken struct {
+	source string
+	// fetch returns the token and its expiry, a zero expiry is the exp claim of the token
+	fetch func() (string, time.Time, error)
+	// checkInterval is the interval to fetch again a token without an expiry, 0 never
+	checkInterval time.Duration
+
+	lock      sync.Mutex
+	token     string
+	checkedAt time.Time
+	status    PulsarTokenStatus
+	// fetching is the ongoing fetch shared by the callers, nil without one
+	fetching *tokenFetch
+}
+
+// tokenFetch is a fetch of the token, done is closed once err is set
+type tokenFetch struct {
+	done chan struct{}
+	err  error
+}
+
+func (t *refreshingToken) due(now time.Time) bool {
+	if t.token == "" {
+		return true
+	}
+	if t.usable(now) && !t.status.LastErrorAt.IsZero() && now.Sub(t.status.LastErrorAt) < tokenRetryInterval {
+		return false
+	}
+	if !t.status.ExpiresAt.IsZero() && now.Add(tokenRefreshBefore).After(t.status.ExpiresAt) {
+		return true
+	}
+	return t.checkInterval > 0 && now.Sub(t.checkedAt) >= t.checkInterval
+}
+
+// usable evaluates if the current token is not expired, the lock must be held
+func (t *refreshingToken) usable(now time.Time) bool {
+	return t.token != "" && (t.status.ExpiresAt.IsZero() || now.Before(t.status.ExpiresAt))
+}
+
+// refresh fetches the token without holding the lock, the concurrent callers wait for the same fetch
+func (t *refreshingToken) refresh() error {
+	t.lock.Lock()
+	if f := t.fetching; f != nil {
+		t.lock.Unlock()
+		<-f.done
+		return f.err
+	}
+	f := &tokenFetch{done: make(chan struct{})}
+	t.fetching = f
+	now := time.Now()
+	t.checkedAt = now
+	t.lock.Unlock()
+
+	token, expiresAt, err := t.fetch()
+
+	t.lock.Lock()
+	f.err = t.update(now, token, expiresAt, err)
+	t.fetching = nil
+	t.lock.Unlock()
+	close(f.done)
+	return f.err
+}
+
+// update applies the fetched token, the lock must be held
+func (t *refreshingToken) update(now time.Time, token string, expiresAt time.Time, err error) error {
+	if err == nil && token == "" {
+		err = errors.New("empty token")
+	}
+	if err != nil {
+		t.status.LastError = err.Error()
+		t.status.LastErrorAt = now
+		pulsarTokenRefreshCounter.WithLabelValues("failure").Inc()
+		return err
+	}
+	if expiresAt.IsZero() {
+		expiresAt = tokenExpiry(token)
+	}
+	if token != t.token {
+		if t.token != "" {
+			t.status.Refreshes++
+		}
+		t.token = token
+		t.status.Fingerprint = tokenFingerprint(token)
+		t.status.RefreshedAt = now
+		t.status.LastError = ""
+		pulsarTokenRefreshCounter.WithLabelValues("success").Inc()
+	}
+	t.status.ExpiresAt = expiresAt
+	if expiresAt.IsZero() {
+		pulsarTokenExpiryGauge.Set(0)
+	} else {
+		pulsarTokenExpiryGauge.Set(float64(expiresAt.Unix()))
+	}
+	return nil
+}
+
+// Token returns the token. A due token is refreshed in the background while the current token is served until it expires,
+// only a caller without a usable token waits for the refresh. A failed refresh keeps the current token until it expires.
+func (t *refreshingToken) Token() (string, error) {
+	t.lock.Lock()
+	now := time.Now()
+	token, due, usable := t.token, t.due(now), t.usable(now)
+	t.lock.Unlock()
+	if !due {
+		return token, nil
+	}
+	if usable {
+		go func() {
+			if err := t.refresh(); err != nil {
+				log.Errorf("pulsar token %s refresh error %v, keep the current token", t.source, err)
+			}
+		}()
+		return token, nil
+	}
+	if err := t.refresh(); err != nil {
+		return "", fmt.Errorf("pulsar token %s: %v", t.source, err)
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.token, nil
+}
+
+// Refresh fetches the token now
+func (t *refreshingToken) Refresh() error {
+	return t.refresh()
+}
+
+// Status returns the token status
+func (t *refreshingToken) Status() PulsarTokenStatus {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	status := t.status
+	status.Source = t.source
+	return status
+}
+
+// NewFileTokenProvider reads the token file again once it is rotated by an external agent
+func NewFileTokenProvider(file string) TokenProvider {
+	return &refreshingToken{
+		source:        FileTokenSource,
+		checkInterval: tokenFileCheckInterval,
+		fetch: func() (string, time.Time, error) {
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				return "", time.Time{}, err
+			}
+			return strings.TrimSpace(string(data)), time.Time{}, nil
+		},
+	}
+}
+
+// OAuth2TokenConfig is the client credentials grant of an external IdP issuing the Pulsar token
+type OAuth2TokenConfig struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Audience     string
+	Scope        string
+}
+
+// NewOAuth2TokenProvider gets the token by the client credentials grant, again before it expires
+func NewOAuth2TokenProvider(cfg OAuth2TokenConfig) (TokenProvider, error) {
+	if _, err := url.ParseRequestURI(cfg.TokenURL); err != nil {
+		return nil, fmt.Errorf("pulsar token url %v", err)
+	}
+	if cfg.ClientID == "" || cfg.ClientSecret == "" {
+		return nil, errors.New("pulsar token url requires the client id and secret")
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	return &refreshingToken{
+		source: OAuth2TokenSource,
+		fetch: func() (string, time.Time, error) {
+			form := url.Values{"grant_type": {"client_credentials"}, "client_id": {cfg.ClientID}, "client_secret": {cfg.ClientSecret}}
+			if cfg.Audience != "" {
+				form.Set("audience", cfg.Audience)
+			}
+			if cfg.Scope != "" {
+				form.Set("scope", cfg.Scope)
+			}
+			start := time.Now()
+			resp, err := client.PostForm(cfg.TokenURL, form)
+			if err != nil {
+				return "", time.Time{}, err
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return "", time.Time{}, fmt.Errorf("token endpoint status code %d", resp.StatusCode)
+			}
+			var body struct {
+				AccessToken string `json:"access_token"`
+				ExpiresIn   int    `json:"expires_in"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				return "", time.Time{}, err
+			}
+			expiresAt := time.Time{}
+			if body.ExpiresIn > 0 {
+				expiresAt = start.Add(time.Duration(body.ExpiresIn) * time.Second)
+			}
+			return body.AccessToken, expiresAt, nil
+		},
+	}, nil
+}
+
+// NewStaticTokenProvider is the PulsarToken in the configuration
+func NewStaticTokenProvider(token string) TokenProvider {
+	return &refreshingToken{
+		source: StaticTokenSource,
+		fetch: func() (string, time.Time, error) {
+			return token, time.Time{}, nil
+		},
+	}
+}
+
+// InitPulsarToken sets the token provider of burnell's own Pulsar clients, the token of the PulsarTokenURL IdP,
+// the PulsarTokenFile, or the PulsarToken in the configuration
+func InitPulsarToken() error {
+	var provider TokenProvider
+	switch {
+	case Config.PulsarTokenURL != "":
+		p, err := NewOAuth2TokenProvider(OAuth2TokenConfig{
+			TokenURL:     Config.PulsarTokenURL,
+			ClientID:     Config.PulsarTokenClientID,
+			ClientSecret: AssignString(Config.PulsarTokenClientSecret, os.Getenv("PulsarTokenClientSecret")),
+			Audience:     Config.PulsarTokenAudience,
+			Scope:        Config.PulsarTokenScope,
+		})
+		if err != nil {
+			return err
+		}
+		provider = p
+	case Config.PulsarTokenFile != "":
+		provider = NewFileTokenProvider(Config.PulsarTokenFile)
+	case Config.PulsarToken != "":
+		provider = NewStaticTokenProvider(Config.PulsarToken)
+	}
+	SetPulsarTokenProvider(provider)
+	return nil
+}
+
+// SetPulsarTokenProvider sets the token provider of burnell's own Pulsar clients, nil is the PulsarToken as is
+func SetPulsarTokenProvider(provider TokenProvider) {
+	pulsarTokenProviderLock.Lock()
+	defer pulsarTokenProviderLock.Unlock()
+	pulsarTokenProvider = provider
+}
+
+func currentTokenProvider() TokenProvider {
+	pulsarTokenProviderLock.RLock()
+	defer pulsarTokenProviderLock.RUnlock()
+	return pulsarTokenProvider
+}
+
+// HasPulsarToken evaluates if burnell's own Pulsar clients authenticate with a token
+func HasPulsarToken() bool {
+	return currentTokenProvider() != nil || Config.PulsarToken != ""
+}
+
+// PulsarTokenSupplier returns the current Pulsar token, it is the token supplier of burnell's own Pulsar clients
+// so that a reconnect or an authentication challenge of the broker takes the refreshed token
+func PulsarTokenSupplier() (string, error) {
+	if provider := currentTokenProvider(); provider != nil {
+		return provider.Token()
+	}
+	return Config.PulsarToken, nil
+}
+
+// PulsarAuthToken returns the current Pulsar token of the admin REST calls, empty if it cannot be refreshed
+func PulsarAuthToken() string {
+	token, err := PulsarTokenSupplier()
+	if err != nil {
+		log.Errorf("%v", err)
+	}
+	return token
+}
+
+// GetPulsarTokenStatus returns the status of the Pulsar token, refreshed first if it is forced
+func GetPulsarTokenStatus(force bool) (PulsarTokenStatus, error) {
+	provider := currentTokenProvider()
+	if provider == nil {
+		return PulsarTokenStatus{Source: StaticTokenSource}, nil
+	}
+	var err error
+	if force {
+		err = provider.Refresh()
+	} else {
+		_, err = provider.Token()
+	}
+	return provider.Status(), err
+}
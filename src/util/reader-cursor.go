@@ -0,0 +1,312 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/apex/log"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// the names of the readers resuming from a checkpoint
+const (
+	TenantPolicyCursor     = "tenant-policy"
+	FunctionMetadataCursor = "function-metadata"
+)
+
+// the min interval between two checkpoints of a reader, the pending checkpoint is also written once the reader is idle for the interval
+var readerCursorInterval = time.Duration(GetEnvInt("ReaderCursorCheckpointSeconds", 30)) * time.Second
+
+var cursorLog = log.WithFields(log.Fields{"app": "reader-cursor"})
+
+// ReaderCheckpoint is the checkpoint file of a reader, the snapshot is the reader's cache built from
+// the messages up to and including the message ID so the reader resumes after the message with the cache restored
+type ReaderCheckpoint struct {
+	Reader    string          `json:"reader"`
+	Topic     string          `json:"topic"`
+	MessageID []byte          `json:"messageId"`
+	SavedAt   time.Time       `json:"savedAt"`
+	Snapshot  json.RawMessage `json:"snapshot"`
+}
+
+// ReaderCursorStatus is the checkpoint status of a reader
+type ReaderCursorStatus struct {
+	Reader  string `json:"reader"`
+	Topic   string `json:"topic"`
+	Enabled bool   `json:"enabled"`
+	File    string `json:"file,omitempty"`
+	// Resumed is true if the reader resumed from the checkpoint at startup instead of the earliest message
+	Resumed             bool      `json:"resumed"`
+	ResumedFrom         string    `json:"resumedFrom,omitempty"`
+	ResumedCheckpointAt time.Time `json:"resumedCheckpointAt,omitempty"`
+	Checkpoints         int       `json:"checkpoints"`
+	LastCheckpointAt    time.Time `json:"lastCheckpointAt,omitempty"`
+	LastMessageID       string    `json:"lastMessageId,omitempty"`
+	// Pending is true if the messages processed after the last checkpoint are not written yet
+	Pending     bool      `json:"pending"`
+	LastError   string    `json:"lastError,omitempty"`
+	LastErrorAt time.Time `json:"lastErrorAt,omitempty"`
+}
+
+// ReaderCursor checkpoints the last processed message ID of a reader along with the snapshot of its cache
+type ReaderCursor struct {
+	lock     sync.Mutex
+	status   ReaderCursorStatus
+	lastID   pulsar.MessageID
+	pending  bool
+	snapshot func() (interface{}, error)
+}
+
+var (
+	readerCursors     = make(map[string]*ReaderCursor)
+	readerCursorsLock = sync.RWMutex{}
+
+	readerCursorCheckpointCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "burnell",
+		Subsystem: "reader_cursor",
+		Name:      "checkpoints_total",
+		Help:      "The number of checkpoints of the reader by the result, ok or error.",
+	}, []string{"reader", "result"})
+	readerCursorResumedGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "burnell",
+		Subsystem: "reader_cursor",
+		Name:      "resumed",
+		Help:      "1 if the reader resumed from the checkpoint at startup, otherwise 0.",
+	}, []string{"reader"})
+)
+
+func init() {
+	prometheus.MustRegister(readerCursorCheckpointCounter, readerCursorResumedGauge)
+}
+
+// NewReaderCursor registers the cursor of a reader of the topic, the snapshot returns the reader's cache to checkpoint.
+// The cursor is disabled if ReaderCursorDir is not configured, in which case the reader always starts from the earliest message.
+func NewReaderCursor(reader, topic string, snapshot func() (interface{}, error)) *ReaderCursor {
+	c := &ReaderCursor{
+		status:   ReaderCursorStatus{Reader: reader, Topic: topic},
+		snapshot: snapshot,
+	}
+	if dir := GetConfig().ReaderCursorDir; dir != "" {
+		c.status.Enabled = true
+		c.status.File = filepath.Join(dir, reader+".json")
+	}
+	readerCursorsLock.Lock()
+	readerCursors[reader] = c
+	readerCursorsLock.Unlock()
+	return c
+}
+
+// Start returns the message ID the reader starts from. A reconnecting reader continues after the last processed message
+// since its cache is intact, otherwise restore loads the snapshot of the checkpoint into the empty cache
+// and the reader resumes after the checkpoint. The reader starts from the earliest message without a valid checkpoint.
+func (c *ReaderCursor) Start(restore func(snapshot []byte) error) pulsar.MessageID {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if !c.status.Enabled {
+		return pulsar.EarliestMessageID()
+	}
+	if c.lastID != nil {
+		return c.lastID
+	}
+
+	checkpoint, id, err := c.load()
+	if err == nil {
+		err = restore(checkpoint.Snapshot)
+	}
+	if err != nil {
+		if !os.IsNotExist(err) {
+			cursorLog.Errorf("reader %s starts from the earliest message, checkpoint %s error %v", c.status.Reader, c.status.File, err)
+			c.failed(err)
+		}
+		return pulsar.EarliestMessageID()
+	}
+	c.lastID = id
+	c.status.Resumed = true
+	c.status.ResumedFrom = messageIDString(id)
+	c.status.ResumedCheckpointAt = checkpoint.SavedAt
+	c.status.LastMessageID = c.status.ResumedFrom
+	readerCursorResumedGauge.WithLabelValues(c.status.Reader).Set(1)
+	cursorLog.Infof("reader %s resumes after message %s checkpointed at %v", c.status.Reader, c.status.ResumedFrom, checkpoint.SavedAt)
+	return id
+}
+
+// load reads the checkpoint file, the caller holds the lock
+func (c *ReaderCursor) load() (ReaderCheckpoint, pulsar.MessageID, error) {
+	checkpoint := ReaderCheckpoint{}
+	data, err := ioutil.ReadFile(c.status.File)
+	if err != nil {
+		return checkpoint, nil, err
+	}
+	if err = json.Unmarshal(data, &checkpoint); err != nil {
+		return checkpoint, nil, err
+	}
+	if checkpoint.Topic != c.status.Topic {
+		return checkpoint, nil, fmt.Errorf("checkpoint of topic %s instead of %s", checkpoint.Topic, c.status.Topic)
+	}
+	id, err := pulsar.DeserializeMessageID(checkpoint.MessageID)
+	if err != nil {
+		return checkpoint, nil, err
+	}
+	return checkpoint, id, nil
+}
+
+// Processed records the message processed into the reader's cache, and checkpoints once the interval is over
+func (c *ReaderCursor) Processed(id pulsar.MessageID) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if !c.status.Enabled {
+		return
+	}
+	c.lastID = id
+	c.pending = true
+	if time.Since(c.status.LastCheckpointAt) >= readerCursorInterval {
+		c.save()
+	}
+}
+
+// Next reads the next message, and writes the pending checkpoint whenever the reader is idle for the checkpoint interval
+func (c *ReaderCursor) Next(ctx context.Context, reader pulsar.Reader) (pulsar.Message, error) {
+	if !c.status.Enabled {
+		return reader.Next(ctx)
+	}
+	for {
+		idleCtx, cancel := context.WithTimeout(ctx, readerCursorInterval)
+		msg, err := reader.Next(idleCtx)
+		cancel()
+		if err == context.DeadlineExceeded && ctx.Err() == nil {
+			c.Flush()
+			continue
+		}
+		return msg, err
+	}
+}
+
+// Flush writes the pending checkpoint
+func (c *ReaderCursor) Flush() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if !c.status.Enabled || !c.pending {
+		return nil
+	}
+	return c.save()
+}
+
+// save writes the checkpoint of the last processed message and the snapshot taken after the message is processed,
+// the caller holds the lock. The file is replaced by a rename so a crash never leaves a partial checkpoint.
+func (c *ReaderCursor) save() error {
+	cache, err := c.snapshot()
+	if err != nil {
+		return c.failed(err)
+	}
+	snapshot, err := json.Marshal(cache)
+	if err != nil {
+		return c.failed(err)
+	}
+	now := time.Now()
+	data, err := json.Marshal(ReaderCheckpoint{
+		Reader:    c.status.Reader,
+		Topic:     c.status.Topic,
+		MessageID: c.lastID.Serialize(),
+		SavedAt:   now,
+		Snapshot:  snapshot,
+	})
+	if err != nil {
+		return c.failed(err)
+	}
+	if err = os.MkdirAll(filepath.Dir(c.status.File), 0700); err != nil {
+		return c.failed(err)
+	}
+	tmp := c.status.File + ".tmp"
+	if err = ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return c.failed(err)
+	}
+	if err = os.Rename(tmp, c.status.File); err != nil {
+		return c.failed(err)
+	}
+	c.pending = false
+	c.status.Checkpoints++
+	c.status.LastCheckpointAt = now
+	c.status.LastMessageID = messageIDString(c.lastID)
+	readerCursorCheckpointCounter.WithLabelValues(c.status.Reader, "ok").Inc()
+	return nil
+}
+
+// failed records the checkpoint error, the caller holds the lock
+func (c *ReaderCursor) failed(err error) error {
+	cursorLog.Errorf("reader %s checkpoint error %v", c.status.Reader, err)
+	c.status.LastError = err.Error()
+	c.status.LastErrorAt = time.Now()
+	readerCursorCheckpointCounter.WithLabelValues(c.status.Reader, "error").Inc()
+	return err
+}
+
+// Status returns the checkpoint status of the reader
+func (c *ReaderCursor) Status() ReaderCursorStatus {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	status := c.status
+	status.Pending = c.pending
+	return status
+}
+
+// messageIDString returns the readable message ID, the tracking details of a received message ID are dropped
+func messageIDString(id pulsar.MessageID) string {
+	if plain, err := pulsar.DeserializeMessageID(id.Serialize()); err == nil {
+		return fmt.Sprintf("%v", plain)
+	}
+	return fmt.Sprintf("%v", id)
+}
+
+// ReaderCursors returns the checkpoint status of all readers
+func ReaderCursors() []ReaderCursorStatus {
+	readerCursorsLock.RLock()
+	defer readerCursorsLock.RUnlock()
+	results := make([]ReaderCursorStatus, 0, len(readerCursors))
+	for _, c := range readerCursors {
+		results = append(results, c.Status())
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Reader < results[j].Reader })
+	return results
+}
+
+// FlushReaderCursors writes the pending checkpoints of all readers, such as before a planned restart
+func FlushReaderCursors() error {
+	readerCursorsLock.RLock()
+	defer readerCursorsLock.RUnlock()
+	var firstErr error
+	for _, c := range readerCursors {
+		if err := c.Flush(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
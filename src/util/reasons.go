@@ -0,0 +1,174 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package util
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// the built-in reason codes of the error responses
+const (
+	ReasonTokenInvalid            = "TOKEN_INVALID"
+	ReasonTokenExpired            = "TOKEN_EXPIRED"
+	ReasonTenantAccessDenied      = "TENANT_ACCESS_DENIED"
+	ReasonSuperuserRequired       = "SUPERUSER_REQUIRED"
+	ReasonTenantSuspended         = "TENANT_SUSPENDED"
+	ReasonRateLimited             = "RATE_LIMITED"
+	ReasonPlanRateExceeded        = "PLAN_RATE_EXCEEDED"
+	ReasonQuotaTopicsExceeded     = "QUOTA_TOPICS_EXCEEDED"
+	ReasonQuotaNamespacesExceeded = "QUOTA_NAMESPACES_EXCEEDED"
+	ReasonQuotaFunctionsExceeded  = "QUOTA_FUNCTIONS_EXCEEDED"
+	ReasonQuotaPartitionsExceeded = "QUOTA_PARTITIONS_EXCEEDED"
+	ReasonValidationFailed        = "VALIDATION_FAILED"
+	ReasonClusterNotAllowed       = "CLUSTER_NOT_ALLOWED"
+	ReasonServerSaturated         = "SERVER_SATURATED"
+	ReasonMemoryPressure          = "MEMORY_PRESSURE"
+	ReasonReadOnly                = "READ_ONLY"
+	ReasonDeadlineExceeded        = "DEADLINE_EXCEEDED"
+)
+
+// Reason is a machine readable reason code of the error responses, the console shows its message to the users
+type Reason struct {
+	Code   string `json:"code"`
+	Status int    `json:"status"`
+	// Message is the default English message
+	Message string `json:"message"`
+	// Messages are the localized messages by the language tag, i.e. fr or pt-BR
+	Messages map[string]string `json:"messages,omitempty"`
+}
+
+// reason codes are upper case words separated by _
+var reasonCodePattern = regexp.MustCompile(`^[A-Z][A-Z0-9]*(_[A-Z0-9]+)*$`)
+
+var (
+	reasons     = map[string]*Reason{}
+	reasonsLock = sync.RWMutex{}
+)
+
+func init() {
+	for _, r := range []Reason{
+		{ReasonTokenInvalid, http.StatusUnauthorized, "The token is invalid, please sign in again.", nil},
+		{ReasonTokenExpired, http.StatusUnauthorized, "The token has expired, please sign in again.", nil},
+		{ReasonTenantAccessDenied, http.StatusUnauthorized, "The token does not have access to the tenant.", nil},
+		{ReasonSuperuserRequired, http.StatusUnauthorized, "The operation requires an administrator.", nil},
+		{ReasonTenantSuspended, http.StatusForbidden, "The tenant is suspended, changes are not allowed.", nil},
+		{ReasonRateLimited, http.StatusTooManyRequests, "Too many requests, please retry later.", nil},
+		{ReasonPlanRateExceeded, http.StatusTooManyRequests, "The request rate is over the plan limit, please retry later or upgrade the plan.", nil},
+		{ReasonQuotaTopicsExceeded, http.StatusPaymentRequired, "The number of topics is at the plan limit, please upgrade the plan.", nil},
+		{ReasonQuotaNamespacesExceeded, http.StatusPaymentRequired, "The number of namespaces is at the plan limit, please upgrade the plan.", nil},
+		{ReasonQuotaFunctionsExceeded, http.StatusPaymentRequired, "The number of functions is at the plan limit, please upgrade the plan.", nil},
+		{ReasonQuotaPartitionsExceeded, http.StatusPaymentRequired, "The number of partitions is over the plan limit, please upgrade the plan.", nil},
+		{ReasonValidationFailed, http.StatusUnprocessableEntity, "Some fields are invalid.", nil},
+		{ReasonClusterNotAllowed, http.StatusForbidden, "The tenant is not allowed on the cluster.", nil},
+		{ReasonServerSaturated, http.StatusServiceUnavailable, "The server is busy, please retry later.", nil},
+		{ReasonMemoryPressure, http.StatusServiceUnavailable, "The server is low on memory, please retry later.", nil},
+		{ReasonReadOnly, http.StatusMethodNotAllowed, "The server is a read-only replica, changes are not allowed.", nil},
+		{ReasonDeadlineExceeded, http.StatusGatewayTimeout, "The request has timed out, please retry later.", nil},
+	} {
+		if err := RegisterReason(r); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// RegisterReason adds a reason code to the registry, or replaces the registered code
+func RegisterReason(r Reason) error {
+	if !reasonCodePattern.MatchString(r.Code) {
+		return fmt.Errorf("reason code %s must be upper case words separated by _", r.Code)
+	}
+	if r.Message == "" {
+		return fmt.Errorf("reason code %s requires a message", r.Code)
+	}
+	reason := r
+	reason.Messages = map[string]string{}
+	for lang, msg := range r.Messages {
+		reason.Messages[lang] = msg
+	}
+	reasonsLock.Lock()
+	defer reasonsLock.Unlock()
+	reasons[r.Code] = &reason
+	return nil
+}
+
+// SetReasonMessages sets the localized messages of the registered reason codes, by code then by the language tag
+func SetReasonMessages(messages map[string]map[string]string) error {
+	reasonsLock.Lock()
+	defer reasonsLock.Unlock()
+	for code := range messages {
+		if _, ok := reasons[code]; !ok {
+			return fmt.Errorf("unknown reason code %s", code)
+		}
+	}
+	for code, localized := range messages {
+		for lang, msg := range localized {
+			reasons[code].Messages[lang] = msg
+		}
+	}
+	return nil
+}
+
+// InitReasons sets the ErrorReasonMessages in the configuration
+func InitReasons() error {
+	return SetReasonMessages(GetConfig().ErrorReasonMessages)
+}
+
+// Reasons returns the registered reason codes sorted by the code
+func Reasons() []Reason {
+	reasonsLock.RLock()
+	defer reasonsLock.RUnlock()
+	list := make([]Reason, 0, len(reasons))
+	for _, r := range reasons {
+		list = append(list, *r)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Code < list[j].Code })
+	return list
+}
+
+// ReasonMessage returns the message of the reason code in the language, the base language of a regional tag,
+// i.e. pt for pt-BR, or the default message. It is empty for an unknown code.
+func ReasonMessage(code, lang string) string {
+	reasonsLock.RLock()
+	defer reasonsLock.RUnlock()
+	r, ok := reasons[code]
+	if !ok {
+		return ""
+	}
+	if msg, ok := r.Messages[lang]; ok {
+		return msg
+	}
+	if i := strings.IndexAny(lang, "-_"); i > 0 {
+		if msg, ok := r.Messages[lang[:i]]; ok {
+			return msg
+		}
+	}
+	return r.Message
+}
+
+// NewReasonError is an error with the reason code, the message is the server side detail
+func NewReasonError(reason, message string) *APIError {
+	return &APIError{Reason: reason, Message: message}
+}
@@ -27,6 +27,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"strconv"
@@ -61,11 +62,9 @@ func JoinString(strs ...string) string {
 	return sb.String()
 }
 
-// ResponseErrorJSON builds a Http response.
+// ResponseErrorJSON replies the JSON error response envelope with the status code.
 func ResponseErrorJSON(e error, w http.ResponseWriter, statusCode int) {
-	response := ResponseErr{e.Error()}
-
-	jsonResponse, err := json.Marshal(response)
+	jsonResponse, err := json.Marshal(NewErrorResponse(e, w, statusCode))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -132,8 +131,10 @@ func StrContains(strs []string, str string) bool {
 // GetEnvInt gets OS environment in integer format with a default if inproper value retrieved
 func GetEnvInt(env string, defaultNum int) int {
 	if i, err := strconv.Atoi(os.Getenv(env)); err == nil {
+		recordEnvTunable(env, i, defaultNum, EnvSource)
 		return i
 	}
+	recordEnvTunable(env, defaultNum, defaultNum, DefaultSource)
 	return defaultNum
 }
 
@@ -234,3 +235,21 @@ func StringToNewFile(filePath, text string) error {
 
 	return nil
 }
+
+// IPInCIDRs checks if the IP of a remote address, in the format of host:port or host, is in a comma separated list of CIDRs
+func IPInCIDRs(remoteAddr, cidrs string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, v := range strings.Split(cidrs, ",") {
+		if _, ipNet, err := net.ParseCIDR(strings.TrimSpace(v)); err == nil && ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
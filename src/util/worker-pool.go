@@ -0,0 +1,201 @@
+//
+//  Copyright (c) 2021 Datastax, Inc.
+//
+//  Licensed to the Apache Software Foundation (ASF) under one
+//  or more contributor license agreements.  See the NOTICE file
+//  distributed with this work for additional information
+//  regarding copyright ownership.  The ASF licenses this file
+//  to you under the Apache License, Version 2.0 (the
+//  "License"); you may not use this file except in compliance
+//  with the License.  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing,
+//  software distributed under the License is distributed on an
+//  "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+//  KIND, either express or implied.  See the License for the
+//  specific language governing permissions and limitations
+//  under the License.
+//
+
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// WorkerPoolConfig configures a worker pool of the fan-out calls to the brokers and the function workers
+type WorkerPoolConfig struct {
+	// Name is the pool label of the metrics
+	Name string
+	// Concurrency is the max tasks running at a time of a call, at least 1
+	Concurrency int
+	// Retries is the max retries of a failed task, RetryBackoff is the wait before the first retry doubled on every retry
+	Retries      int
+	RetryBackoff time.Duration
+	// TaskTimeout is the timeout of every attempt of a task, 0 is no timeout other than the call context
+	TaskTimeout time.Duration
+}
+
+// WorkerPool runs the tasks of a fan-out call with bounded concurrency, retries and metrics
+type WorkerPool struct {
+	config WorkerPoolConfig
+}
+
+// Task is the task of the index in a fan-out call, it gives up once the context is done
+type Task func(ctx context.Context, i int) error
+
+// permanentError is a task error not to retry
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+
+func (e permanentError) Unwrap() error { return e.err }
+
+// NoRetry marks a task error not to retry, such as a 4xx response
+func NoRetry(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err}
+}
+
+var (
+	workerPoolTaskCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "burnell",
+		Subsystem: "worker_pool",
+		Name:      "tasks_total",
+		Help:      "The number of fan-out tasks by the result, ok, error or cancelled.",
+	}, []string{"pool", "result"})
+	workerPoolRetryCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "burnell",
+		Subsystem: "worker_pool",
+		Name:      "retries_total",
+		Help:      "The number of retries of the failed fan-out tasks.",
+	}, []string{"pool"})
+	workerPoolActiveGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "burnell",
+		Subsystem: "worker_pool",
+		Name:      "active_tasks",
+		Help:      "The number of fan-out tasks running.",
+	}, []string{"pool"})
+	workerPoolDurationHistogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "burnell",
+		Subsystem: "worker_pool",
+		Name:      "task_duration_seconds",
+		Help:      "The duration of the fan-out tasks including the retries.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"pool"})
+)
+
+func init() {
+	prometheus.MustRegister(workerPoolTaskCounter, workerPoolRetryCounter, workerPoolActiveGauge, workerPoolDurationHistogram)
+}
+
+// NewWorkerPool creates a worker pool
+func NewWorkerPool(config WorkerPoolConfig) *WorkerPool {
+	if config.Concurrency < 1 {
+		config.Concurrency = 1
+	}
+	return &WorkerPool{config: config}
+}
+
+// Run runs the tasks of the indexes 0 to n-1 with up to the concurrency tasks at a time, and returns the error
+// of every task by the index, nil for a success. A failed task is retried unless the error is marked NoRetry.
+// Once the context is done, the pending tasks are not started and fail with the context error.
+func (p *WorkerPool) Run(ctx context.Context, n int, task Task) []error {
+	errs := make([]error, n)
+	workers := p.config.Concurrency
+	if workers > n {
+		workers = n
+	}
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				errs[i] = p.runTask(ctx, i, task)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		if ctx.Err() == nil {
+			select {
+			case jobs <- i:
+				continue
+			case <-ctx.Done():
+			}
+		}
+		errs[i] = ctx.Err()
+		workerPoolTaskCounter.WithLabelValues(p.config.Name, "cancelled").Inc()
+	}
+	close(jobs)
+	wg.Wait()
+	return errs
+}
+
+// runTask runs a task with the retries
+func (p *WorkerPool) runTask(ctx context.Context, i int, task Task) error {
+	workerPoolActiveGauge.WithLabelValues(p.config.Name).Inc()
+	defer workerPoolActiveGauge.WithLabelValues(p.config.Name).Dec()
+	start := time.Now()
+	backoff := p.config.RetryBackoff
+
+	err := p.attempt(ctx, i, task)
+	for retry := 0; err != nil && retry < p.config.Retries && ctx.Err() == nil; retry++ {
+		var permanent permanentError
+		if errors.As(err, &permanent) {
+			break
+		}
+		workerPoolRetryCounter.WithLabelValues(p.config.Name).Inc()
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+		}
+		backoff *= 2
+		if ctx.Err() == nil {
+			err = p.attempt(ctx, i, task)
+		}
+	}
+	workerPoolDurationHistogram.WithLabelValues(p.config.Name).Observe(time.Since(start).Seconds())
+
+	var permanent permanentError
+	if errors.As(err, &permanent) {
+		err = permanent.err
+	}
+	switch {
+	case err == nil:
+		workerPoolTaskCounter.WithLabelValues(p.config.Name, "ok").Inc()
+	case ctx.Err() != nil:
+		workerPoolTaskCounter.WithLabelValues(p.config.Name, "cancelled").Inc()
+	default:
+		workerPoolTaskCounter.WithLabelValues(p.config.Name, "error").Inc()
+	}
+	return err
+}
+
+// attempt runs a task once with the task timeout, a panic fails the task without a retry instead of crashing the process
+func (p *WorkerPool) attempt(ctx context.Context, i int, task Task) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = NoRetry(fmt.Errorf("%s task %d panic %v", p.config.Name, i, r))
+		}
+	}()
+	if p.config.TaskTimeout <= 0 {
+		return task(ctx, i)
+	}
+	taskCtx, cancel := context.WithTimeout(ctx, p.config.TaskTimeout)
+	defer cancel()
+	return task(taskCtx, i)
+}